@@ -181,6 +181,8 @@ func main() {
 	rateLimiter := middleware.NewFiberRateLimiter()
 	defer rateLimiter.Stop()
 	redisRateLimiter := middleware.NewRedisRateLimiter(redisCache)
+	// Per-key limits for API key requests (no-op without Redis)
+	middleware.SetAPIKeyRateLimiter(redisRateLimiter)
 
 	// Apply rate limiting globally (Redis-backed when available for horizontal scaling)
 	if redisRateLimiter != nil {
@@ -221,10 +223,20 @@ func main() {
 	// Notification service (for all modules)
 	notificationService := services.NewNotificationService(db, emailService, smsService, whatsappService, cfg)
 	notificationService.Start()
+	kraService.SetNotificationService(notificationService)
+
+	// Workflow engine - evaluates AutomationWorkflow rows against published events
+	workflowEngine := services.NewWorkflowEngine(db, notificationService)
+	workflowEngine.Start()
 
 	// Initialize PDF generator
 	pdfGenerator := pdf.NewPDFGenerator("./templates", "./data/pdfs")
 
+	// Accounting periods - changes dated in closed months are rejected
+	periodService := services.NewPeriodService(db)
+	taxRateService := services.NewTaxRateService(db)
+	etimsService := services.NewETIMSService(cfg, db)
+
 	// Build invoice service with all dependencies
 	invoiceService := services.NewInvoiceServiceWithDeps(db, &services.ServiceDependencies{
 		DB:           db,
@@ -235,10 +247,16 @@ func main() {
 		KRA:          kraService,
 		Config:       cfg,
 		PDFGen:       pdfGenerator,
+		Workflows:    workflowEngine,
+		Periods:      periodService,
+		TaxRates:     taxRateService,
 	})
 
 	clientService := services.NewClientService(db)
+	clientService.SetWorkflowEngine(workflowEngine)
 	reportService := services.NewReportService(db)
+	reportService.SetPDFGenerator(pdfGenerator)
+	reportService.SetExchangeRateService(exchangeRateService)
 	settingsService := services.NewSettingsService(db)
 
 	// Automation services - Enterprise Edition
@@ -247,8 +265,28 @@ func main() {
 	reminderService := services.NewAutoReminderService(db, jobQueue)
 	workflowService := services.NewAutoWorkflowService(db, jobQueue)
 
+	// Outgoing webhooks - signed event deliveries to tenant endpoints
+	webhookService := services.NewOutgoingWebhookService(db, jobQueue, cfg)
+	workflowEngine.SetWebhookService(webhookService)
+	workflowService.SetWebhookService(webhookService)
+	kraService.SetWorkflowEngine(workflowEngine)
+
+	// Job dispatcher - drains the automation job queue
+	jobDispatcher := worker.NewJobDispatcher(jobQueue, 4)
+	jobDispatcher.Register(models.JobTypeRecurringInvoice, func(ctx context.Context, job *models.AutomationJob) error {
+		return recurringInvoice.ProcessRecurringInvoice(job)
+	})
+	jobDispatcher.Register(models.JobTypeWorkflow, func(ctx context.Context, job *models.AutomationJob) error {
+		return workflowEngine.RunWorkflowJob(job)
+	})
+	jobDispatcher.Register(models.JobTypeWebhook, webhookService.Deliver)
+	kraService.SetJobQueue(jobQueue)
+	jobDispatcher.Register(models.JobTypeKRAQueue, kraService.ProcessQueueJob)
+
 	// Payment service for M-Pesa integration
 	paymentService := services.NewPaymentService(db, cfg)
+	paymentService.SetWorkflowEngine(workflowEngine)
+	paymentService.SetPeriodService(periodService)
 
 	// Item library service
 	itemLibraryService := services.NewItemLibraryService(db)
@@ -284,6 +322,9 @@ func main() {
 		Email:        emailService,
 		Notification: notificationService,
 	})
+	legacyReminderService.SetJobQueue(jobQueue)
+	jobDispatcher.Register(models.JobTypeReminder, legacyReminderService.ProcessReminderJob)
+	jobDispatcher.Start(context.Background())
 
 	// Start reminder cron job
 	wg.Add(1)
@@ -324,6 +365,10 @@ func main() {
 		}()
 		ticker := time.NewTicker(5 * time.Minute)
 		defer ticker.Stop()
+		// Replay anything left over from before the restart
+		if err := kraService.ProcessRetryQueue(); err != nil {
+			logSvc.Error(context.Background(), "Initial KRA queue error", "error", err.Error())
+		}
 		for {
 			select {
 			case <-stopCh:
@@ -372,10 +417,40 @@ func main() {
 	}
 
 	// Initialize M-Pesa service first (needed for handler)
-	var mpesaService *services.MPesaService
-	if cfg.MPesa.Enabled {
-		mpesaService = services.NewMPesaService(cfg, db, redisCache)
+	// Always created: tenants with their own Daraja credentials can collect even
+	// when the platform account (MPESA_ENABLED) is off
+	var mpesaCache services.MPesaCache
+	if redisCache != nil {
+		mpesaCache = redisCache
 	}
+	mpesaService := services.NewMPesaService(cfg, db, mpesaCache)
+	mpesaService.SetWorkflowEngine(workflowEngine)
+	mpesaService.SetCredentialStore(settingsService)
+	mpesaService.SetPeriodService(periodService)
+
+	// M-Pesa status reconciler: queries STK pushes whose callback never arrived (every minute)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				logSvc.Error(context.Background(), "panic recovered", "goroutine", "mpesa_reconcile", "recover", r)
+			}
+		}()
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				logSvc.Info(context.Background(), "Stopping M-Pesa reconciler")
+				return
+			case <-ticker.C:
+				if _, err := mpesaService.ReconcilePendingSTK(context.Background(), cfg.MPesa.ReconcileAfter); err != nil {
+					logSvc.Error(context.Background(), "M-Pesa reconcile error", "error", err.Error())
+				}
+			}
+		}
+	}()
 
 	// Create webhook verifier for middleware
 	webhookVerifier := middleware.NewWebhookVerifierMiddleware(services.NewWebhookVerifier(cfg))
@@ -395,6 +470,7 @@ func main() {
 	billingService := services.NewBillingService(db, planService, subscriptionService, nil, notificationService, cfg)
 
 	overdueService := services.NewOverdueService(db)
+	overdueService.SetWorkflowEngine(workflowEngine)
 
 	// Initialize billing worker for cron jobs
 	billingWorker := worker.NewBillingWorker(db, subscriptionService, billingService)
@@ -460,6 +536,14 @@ func main() {
 	reportHandler := handlers.NewReportHandler(reportService)
 	automationHandler := handlers.NewAutomationHandler(db, jobQueue, recurringInvoice, reminderService, workflowService)
 	publicHandler := handlers.NewPublicHandlerWithTracking(invoiceService, authService, paymentService, mpesaService, intasendService, emailTrackingService, planService)
+
+	// Quotes - accepted through the client portal and converted into invoices
+	quoteService := services.NewQuoteService(db)
+	quoteService.SetEmailService(emailService)
+	quoteService.SetWorkflowEngine(workflowEngine)
+	quoteService.SetExchangeRateService(exchangeRateService)
+	quoteService.SetBaseURL(cfg.Server.BaseURL)
+	publicHandler.SetQuoteService(quoteService)
 	passwordResetService := services.NewPasswordResetService(db, cfg, emailService)
 	authHandler := handlers.NewAuthHandlerWithDeps(authService, auditService, invoiceService, clientService, passwordResetService, db)
 	notificationHandler := handlers.NewNotificationHandler(db)
@@ -473,6 +557,8 @@ func main() {
 
 	// Expense handler
 	expenseService := services.NewExpenseService(db)
+	expenseService.SetPeriodService(periodService)
+	expenseService.SetETIMSService(etimsService)
 	expenseHandler := handlers.NewExpenseHandler(expenseService)
 
 	// Integration handler
@@ -523,8 +609,80 @@ func main() {
 	activityHandler := handlers.NewActivityHandler(activityService)
 	routes.ActivityRoutes(app, activityHandler, authService, db)
 
+	// API key management routes
+	apiKeyHandler := handlers.NewAPIKeyHandler(authService)
+	routes.APIKeyRoutes(app, apiKeyHandler, authService, db)
+
+	// Outgoing webhook endpoint routes
+	webhookEndpointHandler := handlers.NewWebhookEndpointHandler(webhookService)
+	routes.WebhookEndpointRoutes(app, webhookEndpointHandler, authService, db)
+
+	// Quote routes
+	quoteHandler := handlers.NewQuoteHandler(quoteService)
+	routes.QuoteRoutes(app, quoteHandler, authService, db)
+
+	// Bulk import routes - clients, items and historical invoices
+	importService := services.NewImportService(db)
+	importService.SetPeriodService(periodService)
+	importService.SetExchangeRateService(exchangeRateService)
+	importHandler := handlers.NewImportHandler(importService)
+	routes.ImportRoutes(app, importHandler, authService, db)
+
+	// Bank statement import and reconciliation
+	bankReconciliationHandler := handlers.NewBankReconciliationHandler(services.NewBankReconciliationService(db))
+	routes.BankReconciliationRoutes(app, bankReconciliationHandler, authService, db)
+
+	// General ledger: documents are posted as they are sent, paid, approved or voided
+	ledgerService := services.NewLedgerService(db)
+	ledgerService.SetPeriodService(periodService)
+	ledgerService.SetExchangeRateService(exchangeRateService)
+	routes.LedgerRoutes(app, handlers.NewLedgerHandler(ledgerService), authService, db)
+
+	// Fiscal year and month-end close
+	routes.PeriodRoutes(app, handlers.NewPeriodHandler(periodService), authService, db)
+
+	// Dated exchange rates, tenant overrides and month-end FX revaluation
+	routes.FXRoutes(app, handlers.NewFXHandler(exchangeRateService, ledgerService), authService, db)
+
+	// Withholding VAT and income tax certificates from clients
+	routes.WithholdingRoutes(app, handlers.NewWithholdingHandler(services.NewWithholdingService(db)), authService, db)
+
+	// Tax rate catalogue: VAT, excise and levies by the date they apply
+	routes.TaxRateRoutes(app, handlers.NewTaxRateHandler(taxRateService), authService, db)
+
+	// eTIMS device onboarding, item registration, purchase import and stock reporting
+	routes.ETIMSRoutes(app, handlers.NewETIMSHandler(etimsService), authService, db)
+
+	// Document numbering: per-type patterns and yearly resets
+	routes.NumberingRoutes(app, handlers.NewNumberingHandler(services.NewNumberingService(db)), authService, db)
+
+	// Ledger posting cron job (every 5 minutes); reports also post before they run
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				logSvc.Error(context.Background(), "panic recovered", "goroutine", "ledger_posting", "recover", r)
+			}
+		}()
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				logSvc.Info(context.Background(), "Stopping ledger posting cron")
+				return
+			case <-ticker.C:
+				if err := ledgerService.PostAllPending(); err != nil {
+					logSvc.Error(context.Background(), "Ledger posting error", "error", err.Error())
+				}
+			}
+		}
+	}()
+
 	// Payment discrepancy alert service
 	discrepancyService := services.NewPaymentDiscrepancyService(db, emailService)
+	discrepancyService.SetWorkflowEngine(workflowEngine)
 
 	// Payment discrepancy check cron job (every 15 minutes)
 	wg.Add(1)
@@ -555,9 +713,44 @@ func main() {
 
 	// Payment matching routes
 	paymentMatchingService := services.NewPaymentMatchingService(db, notificationService)
+	paymentMatchingService.SetWorkflowEngine(workflowEngine)
+	paymentMatchingService.SetPeriodService(periodService)
 	paymentMatchingHandler := handlers.NewPaymentMatchingHandler(paymentMatchingService, invoiceService)
 	routes.PaymentMatchingRoutes(app, paymentMatchingHandler, authService, db)
 
+	// M-Pesa paybill (C2B) callbacks, verified through Transaction Status and matched against open invoices
+	c2bService := services.NewC2BService(db, mpesaService, paymentMatchingService)
+	routes.C2BRoutes(app, handlers.NewC2BHandler(c2bService), authService, db, rateLimiter, webhookVerifier)
+
+	// M-Pesa B2C payouts: refunds, overpayment returns and expense reimbursements, after approval
+	payoutService := services.NewPayoutService(db, mpesaService)
+	paymentHandler.SetPayoutService(payoutService)
+	routes.PayoutRoutes(app, handlers.NewPayoutHandler(payoutService), authService, db, rateLimiter, webhookVerifier)
+
+	// Payout reconciler: asks M-Pesa about payouts whose result never arrived (every 5 minutes)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				logSvc.Error(context.Background(), "panic recovered", "goroutine", "payout_reconcile", "recover", r)
+			}
+		}()
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				logSvc.Info(context.Background(), "Stopping payout reconciler")
+				return
+			case <-ticker.C:
+				if _, err := payoutService.ReconcileProcessing(context.Background(), 0); err != nil {
+					logSvc.Error(context.Background(), "Payout reconcile error", "error", err.Error())
+				}
+			}
+		}
+	}()
+
 	// Settlement report routes
 	settlementService := services.NewMPaySettlementService(db)
 	settlementHandler := handlers.NewSettlementHandler(settlementService)
@@ -575,6 +768,16 @@ func main() {
 	sensitiveLimit := rateLimiter.SensitiveRateLimiter()
 	routes.OnboardingRoutes(app, onboardingHandler, authService, db, sensitiveLimit)
 
+	// Client self-service portal - magic link / SMS code sessions
+	clientPortalService := services.NewClientPortalService(db, reportService)
+	clientPortalService.SetSMSService(smsService)
+	clientPortalService.SetBaseURL(cfg.Server.BaseURL)
+	if emailService != nil {
+		clientPortalService.SetEmailService(emailService)
+	}
+	clientPortalService.SetSTKPusher(mpesaService)
+	routes.ClientPortalRoutes(app, handlers.NewClientPortalHandler(clientPortalService), sensitiveLimit)
+
 	// Subdomain routing for branded client portal (AFTER main routes)
 	app.Use(func(c *fiber.Ctx) error {
 		hostname := c.Hostname()
@@ -681,6 +884,8 @@ func main() {
 
 	// Stop background services
 	exchangeRateService.Stop()
+	jobDispatcher.Stop()
+	workflowEngine.Stop()
 	notificationService.Stop()
 
 	// Wait for all goroutines to finish (with timeout)
@@ -739,7 +944,7 @@ func setupRoutes(app *fiber.App, cfg *config.Config,
 	routes.TenantPaymentRoutes(app, paymentHandler, authService, db)
 	routes.ReportRoutes(app, reportHandler, authService, db)
 	routes.TeamRoutes(app, teamHandler, authService, db)
-	routes.AutomationRoutes(app, db, authService)
+	routes.AutomationRoutes(app, automationHandler, authService, db)
 	routes.NotificationRoutes(app, notificationHandler, authService, db)
 	routes.NotificationAdminRoutes(app, notificationAdminHandler, authService, db)
 	routes.BillingRoutes(app, billingHandler, authService, db, webhookVerifier, idempotencySvc, rateLimiter)
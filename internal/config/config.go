@@ -87,8 +87,22 @@ type MPesaConfig struct {
 	SecurityCredential string // SHA256 hash of online checkout password - for callback verification
 	CallbackURL        string
 	Environment        string // "sandbox" or "production"
+	APIURL             string // overrides the Daraja host picked by Environment (proxies, tests)
 	QueueTimeout       time.Duration
-	ResultURL          string
+	ResultURL          string // base of async result URLs (Transaction Status, B2C); the tenant is appended
+	C2BURL             string // base of C2B validation/confirmation URLs; Daraja rejects URLs containing "mpesa"
+
+	// API initiator for Transaction Status queries
+	InitiatorName       string
+	InitiatorCredential string // initiator password encrypted with the Safaricom certificate
+
+	// ReconcileAfter is how long an STK push may wait for its callback before it is queried
+	ReconcileAfter time.Duration
+
+	// CallbackSecret signs the per-tenant token in C2B and result callback URLs
+	CallbackSecret string
+	// CallbackIPs are the only addresses Daraja callbacks are accepted from; empty accepts any
+	CallbackIPs []string
 }
 
 type JWTConfig struct {
@@ -141,6 +155,7 @@ type KRAConfig struct {
 	BranchID   string
 	PrivateKey string
 	CertSerial string
+	VSCUURL    string // local virtual sales control unit, for devices in VSCU mode
 }
 
 type QuickBooksConfig struct {
@@ -230,16 +245,23 @@ func Load() *Config {
 			ReadTimeout:    getDurationEnv("INTASEND_READ_TIMEOUT", 30*time.Second),
 		},
 		MPesa: MPesaConfig{
-			Enabled:            getBoolEnv("MPESA_ENABLED", false),
-			ConsumerKey:        getEnv("MPESA_CONSUMER_KEY", ""),
-			ConsumerSecret:     getEnv("MPESA_CONSUMER_SECRET", ""),
-			BusinessShortCode:  getEnv("MPESA_BUSINESS_SHORT_CODE", ""),
-			PassKey:            getEnv("MPESA_PASS_KEY", ""),
-			SecurityCredential: getEnv("MPESA_SECURITY_CREDENTIAL", ""), // SHA256 hash of online checkout password
-			CallbackURL:        getEnv("MPESA_CALLBACK_URL", ""),
-			Environment:        getEnv("MPESA_ENVIRONMENT", "sandbox"),
-			QueueTimeout:       getDurationEnv("MPESA_QUEUE_TIMEOUT", 30*time.Second),
-			ResultURL:          getEnv("MPESA_RESULT_URL", ""),
+			Enabled:             getBoolEnv("MPESA_ENABLED", false),
+			ConsumerKey:         getEnv("MPESA_CONSUMER_KEY", ""),
+			ConsumerSecret:      getEnv("MPESA_CONSUMER_SECRET", ""),
+			BusinessShortCode:   getEnv("MPESA_BUSINESS_SHORT_CODE", ""),
+			PassKey:             getEnv("MPESA_PASS_KEY", ""),
+			SecurityCredential:  getEnv("MPESA_SECURITY_CREDENTIAL", ""), // SHA256 hash of online checkout password
+			CallbackURL:         getEnv("MPESA_CALLBACK_URL", ""),
+			Environment:         getEnv("MPESA_ENVIRONMENT", "sandbox"),
+			APIURL:              getEnv("MPESA_API_URL", ""),
+			QueueTimeout:        getDurationEnv("MPESA_QUEUE_TIMEOUT", 30*time.Second),
+			ResultURL:           getEnv("MPESA_RESULT_URL", ""),
+			C2BURL:              getEnv("MPESA_C2B_URL", ""),
+			InitiatorName:       getEnv("MPESA_INITIATOR_NAME", ""),
+			InitiatorCredential: getEnv("MPESA_INITIATOR_CREDENTIAL", ""),
+			ReconcileAfter:      getDurationEnv("MPESA_RECONCILE_AFTER", 3*time.Minute),
+			CallbackSecret:      getEnv("MPESA_CALLBACK_SECRET", jwtSecret),
+			CallbackIPs:         splitAndTrim(getEnv("MPESA_CALLBACK_IPS", defaultMpesaCallbackIPs(getEnv("MPESA_ENVIRONMENT", "sandbox"))), ","),
 		},
 		JWT: JWTConfig{
 			Secret:        getEnv("JWT_SECRET", "dev-secret-change-in-production-min-32-chars!"),
@@ -299,6 +321,7 @@ func Load() *Config {
 			BranchID:   getEnv("KRA_BRANCH_ID", ""),
 			PrivateKey: getEnv("KRA_PRIVATE_KEY", ""),
 			CertSerial: getEnv("KRA_CERT_SERIAL", ""),
+			VSCUURL:    getEnv("KRA_VSCU_URL", ""),
 		},
 		WhatsApp: WhatsAppConfig{
 			Enabled:       getBoolEnv("WHATSAPP_ENABLED", false),
@@ -352,6 +375,19 @@ func Load() *Config {
 	}
 }
 
+// safaricomCallbackIPs are the addresses Safaricom sends production Daraja callbacks from
+const safaricomCallbackIPs = "196.201.214.200,196.201.214.206,196.201.213.114,196.201.214.207,196.201.214.208," +
+	"196.201.213.44,196.201.212.127,196.201.212.138,196.201.212.129,196.201.212.136,196.201.212.74,196.201.212.69"
+
+// defaultMpesaCallbackIPs only restricts callbacks in production; the sandbox
+// sends from addresses Safaricom doesn't publish
+func defaultMpesaCallbackIPs(environment string) string {
+	if environment == "production" {
+		return safaricomCallbackIPs
+	}
+	return ""
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -221,6 +221,33 @@ func (db *DB) Migrate() error {
 		&models.RefreshToken{},
 		&models.AuditLog{},
 		&models.APIKey{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
+		&models.ImportJob{},
+		&models.Quote{},
+		&models.QuoteItem{},
+		&models.ClientPortalSession{},
+		&models.PaymentAllocation{},
+		&models.ClientCreditTransaction{},
+		&models.MpesaSTKRequest{},
+		&models.Payout{},
+		&models.PayoutEvent{},
+		&models.BankStatement{},
+		&models.BankStatementLine{},
+		&models.LedgerAccount{},
+		&models.JournalEntry{},
+		&models.JournalLine{},
+		&models.FiscalYearConfig{},
+		&models.AccountingPeriod{},
+		&models.FXRevaluation{},
+		&models.FXRevaluationLine{},
+		&models.WithholdingCertificate{},
+		&models.TaxRate{},
+		&models.ETIMSDevice{},
+		&models.ETIMSCode{},
+		&models.ETIMSPurchase{},
+		&models.ETIMSStockMovement{},
+		&models.VATFiling{},
 		&models.ExchangeRate{},
 		&models.KRAQueueItem{},
 		&models.KRAAuditLog{},
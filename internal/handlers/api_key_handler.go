@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type APIKeyHandler struct {
+	authService *services.AuthService
+}
+
+func NewAPIKeyHandler(authSvc *services.AuthService) *APIKeyHandler {
+	return &APIKeyHandler{authService: authSvc}
+}
+
+func (h *APIKeyHandler) ListAPIKeys(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	keys, err := h.authService.ListAPIKeys(tenantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"api_keys": keys})
+}
+
+// CreateAPIKey issues a key. The raw key is only ever returned in this response.
+func (h *APIKeyHandler) CreateAPIKey(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req services.CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	key, rawKey, err := h.authService.CreateAPIKey(tenantID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"api_key": key,
+		"secret":  rawKey,
+		"message": "Store this key now - it will not be shown again",
+	})
+}
+
+func (h *APIKeyHandler) RotateAPIKey(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req services.RotateAPIKeyRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+	}
+
+	key, rawKey, err := h.authService.RotateAPIKey(tenantID, userID, c.Params("id"), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"api_key": key,
+		"secret":  rawKey,
+		"message": "Store this key now - it will not be shown again",
+	})
+}
+
+func (h *APIKeyHandler) RevokeAPIKey(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := h.authService.RevokeAPIKey(tenantID, userID, c.Params("id")); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"success": true})
+}
@@ -371,6 +371,40 @@ func (h *AutomationHandler) GetWorkflowStats(c *fiber.Ctx) error {
 	return c.JSON(stats)
 }
 
+func (h *AutomationHandler) GetWorkflowRuns(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	runs, err := h.workflowService.GetWorkflowRuns(tenantID, c.Params("id"), limit)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "workflow not found"})
+	}
+
+	return c.JSON(fiber.Map{"runs": runs})
+}
+
+func (h *AutomationHandler) TestWorkflow(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var payload map[string]interface{}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	matched, err := h.workflowService.TestWorkflowConditions(tenantID, c.Params("id"), payload)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"matched": matched})
+}
+
 // ============================================================================
 // JOB QUEUE HANDLERS
 // ============================================================================
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BankReconciliationHandler serves bank statement imports, matching and the reconciliation report
+type BankReconciliationHandler struct {
+	bankService *services.BankReconciliationService
+}
+
+func NewBankReconciliationHandler(bankSvc *services.BankReconciliationService) *BankReconciliationHandler {
+	return &BankReconciliationHandler{bankService: bankSvc}
+}
+
+func bankErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrBankStatementNotFound), errors.Is(err, services.ErrBankLineNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrBankLineMatched):
+		return fiber.StatusConflict
+	case errors.Is(err, services.ErrBankMatchInvalid):
+		return fiber.StatusUnprocessableEntity
+	}
+	return fiber.StatusBadRequest
+}
+
+// ImportStatement accepts a CSV, OFX/QFX or MT940 statement and matches its lines
+func (h *BankReconciliationHandler) ImportStatement(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "no file provided"})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "failed to read file"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "failed to read file"})
+	}
+
+	result, err := h.bankService.Import(tenantID, userID, fileHeader.Filename, data, &services.BankImportOptions{
+		BankName:      c.FormValue("bank_name"),
+		AccountNumber: c.FormValue("account_number"),
+		Currency:      c.FormValue("currency"),
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(result)
+}
+
+func (h *BankReconciliationHandler) ListStatements(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	statements, err := h.bankService.ListStatements(tenantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"statements": statements})
+}
+
+func (h *BankReconciliationHandler) GetStatement(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	statement, err := h.bankService.GetStatement(tenantID, c.Params("id"))
+	if err != nil {
+		return c.Status(bankErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(statement)
+}
+
+// AutoMatch re-runs matching for a statement, e.g. after recording the payments it shows
+func (h *BankReconciliationHandler) AutoMatch(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	if _, err := h.bankService.GetStatement(tenantID, c.Params("id")); err != nil {
+		return c.Status(bankErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	summary, err := h.bankService.AutoMatch(tenantID, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(summary)
+}
+
+// ReviewQueue lists statement lines with more than one possible match
+func (h *BankReconciliationHandler) ReviewQueue(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	items, err := h.bankService.ReviewQueue(tenantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"lines": items, "total": len(items)})
+}
+
+// MatchLine matches a statement line to a payment, unallocated deposit or expense
+func (h *BankReconciliationHandler) MatchLine(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Type == "" || req.ID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "type and id are required"})
+	}
+
+	line, err := h.bankService.ConfirmMatch(tenantID, userID, c.Params("lineID"), req.Type, req.ID)
+	if err != nil {
+		return c.Status(bankErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(line)
+}
+
+func (h *BankReconciliationHandler) UnmatchLine(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	line, err := h.bankService.Unmatch(tenantID, c.Params("lineID"))
+	if err != nil {
+		return c.Status(bankErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(line)
+}
+
+// IgnoreLine takes a line out of reconciliation, e.g. bank charges
+func (h *BankReconciliationHandler) IgnoreLine(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	_ = c.BodyParser(&req)
+
+	line, err := h.bankService.Ignore(tenantID, userID, c.Params("lineID"), req.Reason)
+	if err != nil {
+		return c.Status(bankErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(line)
+}
+
+// Report lists unmatched statement lines and unmatched book entries for
+// ?from=YYYY-MM-DD&to=YYYY-MM-DD (default: this month), optionally for one ?account=
+func (h *BankReconciliationHandler) Report(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := from.AddDate(0, 1, -1)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be YYYY-MM-DD"})
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be YYYY-MM-DD"})
+		}
+		to = t
+	}
+	if to.Before(from) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must not be before from"})
+	}
+
+	report, err := h.bankService.Report(tenantID, from, to, c.Query("account"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(report)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+
+	"invoicefast/internal/logger"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// C2BHandler serves the M-Pesa paybill (C2B) callbacks and URL registration
+type C2BHandler struct {
+	c2bService *services.C2BService
+}
+
+func NewC2BHandler(c2bSvc *services.C2BService) *C2BHandler {
+	return &C2BHandler{c2bService: c2bSvc}
+}
+
+// RegisterURLs registers this tenant's validation and confirmation URLs with Daraja
+func (h *C2BHandler) RegisterURLs(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	resp, err := h.c2bService.RegisterURLs(c.UserContext(), tenantID)
+	if err != nil {
+		status := fiber.StatusBadGateway
+		if errors.Is(err, services.ErrC2BNotConfigured) || errors.Is(err, services.ErrMpesaNotConfigured) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(resp)
+}
+
+// Validate answers Daraja's validation callback; a rejection cancels the payment
+func (h *C2BHandler) Validate(c *fiber.Ctx) error {
+	var req services.C2BRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.JSON(services.C2BResponse{ResultCode: services.C2BRejectOther, ResultDesc: "Rejected"})
+	}
+
+	return c.JSON(h.c2bService.Validate(c.Params("tenantID"), &req))
+}
+
+// Confirm records a paybill payment; it is applied once Transaction Status confirms it
+func (h *C2BHandler) Confirm(c *fiber.Ctx) error {
+	var req services.C2BRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(services.C2BResponse{ResultCode: "1", ResultDesc: "invalid request"})
+	}
+
+	if _, err := h.c2bService.Confirm(c.UserContext(), c.Params("tenantID"), &req); err != nil {
+		logger.Get().Error(c.UserContext(), "C2B confirmation failed", "component", "M-Pesa", "trans_id", req.TransID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(services.C2BResponse{ResultCode: "1", ResultDesc: "confirmation failed"})
+	}
+
+	return c.JSON(services.C2BResponse{ResultCode: "0", ResultDesc: "Success"})
+}
+
+// HandleStatusResult applies Daraja's Transaction Status answer for a paybill confirmation
+func (h *C2BHandler) HandleStatusResult(c *fiber.Ctx) error {
+	var result services.MpesaResult
+	if err := c.BodyParser(&result); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid result"})
+	}
+
+	if _, err := h.c2bService.ProcessStatusResult(c.UserContext(), c.Params("tenantID"), &result); err != nil {
+		logger.Get().Error(c.UserContext(), "C2B verification processing error", "component", "M-Pesa", "error", err)
+		if errors.Is(err, services.ErrMpesaResultUnknown) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown result"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "result processing failed"})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
+
+// HandleStatusTimeout processes paybill verifications that timed out in Daraja's queue
+func (h *C2BHandler) HandleStatusTimeout(c *fiber.Ctx) error {
+	var result services.MpesaResult
+	if err := c.BodyParser(&result); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid result"})
+	}
+
+	if err := h.c2bService.StatusTimeout(c.UserContext(), c.Params("tenantID"), &result); err != nil {
+		logger.Get().Error(c.UserContext(), "C2B verification timeout error", "component", "M-Pesa", "error", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown result"})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
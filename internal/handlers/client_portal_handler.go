@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const portalSessionKey = "portal_session"
+
+// ClientPortalHandler serves the client self-service portal API
+type ClientPortalHandler struct {
+	portalService *services.ClientPortalService
+}
+
+func NewClientPortalHandler(portalSvc *services.ClientPortalService) *ClientPortalHandler {
+	return &ClientPortalHandler{portalService: portalSvc}
+}
+
+// portalErrorStatus maps portal service errors to HTTP status codes
+func portalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPortalLoginInvalid), errors.Is(err, services.ErrPortalSessionInvalid):
+		return fiber.StatusUnauthorized
+	case errors.Is(err, services.ErrPortalInvoiceNotFound), errors.Is(err, services.ErrPaymentNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrPortalPaymentInProgress):
+		return fiber.StatusConflict
+	case errors.Is(err, services.ErrPortalPaymentsDisabled), errors.Is(err, services.ErrMpesaNotConfigured):
+		return fiber.StatusServiceUnavailable
+	}
+	return fiber.StatusBadRequest
+}
+
+// RequireSession authenticates the portal session token from the
+// Authorization bearer or X-Portal-Token header
+func (h *ClientPortalHandler) RequireSession(c *fiber.Ctx) error {
+	token := c.Get("X-Portal-Token")
+	if token == "" {
+		token = strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	}
+
+	session, err := h.portalService.Authenticate(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Locals(portalSessionKey, session)
+	return c.Next()
+}
+
+func portalSession(c *fiber.Ctx) *models.ClientPortalSession {
+	session, _ := c.Locals(portalSessionKey).(*models.ClientPortalSession)
+	return session
+}
+
+func (h *ClientPortalHandler) RequestLogin(c *fiber.Ctx) error {
+	var req services.PortalLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	result, err := h.portalService.RequestLogin(&req, c.IP())
+	if err != nil {
+		return c.Status(portalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "If the details match our records, a login link or code has been sent",
+		"login":   result,
+	})
+}
+
+func (h *ClientPortalHandler) Verify(c *fiber.Ctx) error {
+	var req services.PortalVerifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	session, err := h.portalService.Verify(&req)
+	if err != nil {
+		return c.Status(portalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(session)
+}
+
+func (h *ClientPortalHandler) Logout(c *fiber.Ctx) error {
+	if err := h.portalService.Logout(portalSession(c)); err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Signed out"})
+}
+
+func (h *ClientPortalHandler) GetProfile(c *fiber.Ctx) error {
+	client, err := h.portalService.GetProfile(portalSession(c))
+	if err != nil {
+		return c.Status(portalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(client)
+}
+
+func (h *ClientPortalHandler) UpdateProfile(c *fiber.Ctx) error {
+	var req services.PortalProfileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	client, err := h.portalService.UpdateProfile(portalSession(c), &req)
+	if err != nil {
+		return c.Status(portalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(client)
+}
+
+func (h *ClientPortalHandler) ListInvoices(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	invoices, total, err := h.portalService.ListInvoices(portalSession(c), c.Query("status"), (page-1)*limit, limit)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+
+	return c.JSON(NewPaginatedResponse(invoices, page, limit, total))
+}
+
+func (h *ClientPortalHandler) GetInvoice(c *fiber.Ctx) error {
+	invoice, err := h.portalService.GetInvoice(portalSession(c), c.Params("id"))
+	if err != nil {
+		return c.Status(portalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(invoice)
+}
+
+func (h *ClientPortalHandler) ListCreditNotes(c *fiber.Ctx) error {
+	notes, err := h.portalService.ListCreditNotes(portalSession(c))
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.JSON(fiber.Map{"data": notes})
+}
+
+func (h *ClientPortalHandler) ListReceipts(c *fiber.Ctx) error {
+	receipts, err := h.portalService.ListReceipts(portalSession(c))
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.JSON(fiber.Map{"data": receipts})
+}
+
+func (h *ClientPortalHandler) GetStatement(c *fiber.Ctx) error {
+	startDate := time.Now().AddDate(0, -1, 0)
+	endDate := time.Now()
+
+	if startStr := c.Query("start_date"); startStr != "" {
+		if parsed, err := time.Parse("2006-01-02", startStr); err == nil {
+			startDate = parsed
+		}
+	}
+	if endStr := c.Query("end_date"); endStr != "" {
+		if parsed, err := time.Parse("2006-01-02", endStr); err == nil {
+			endDate = parsed
+		}
+	}
+
+	statement, err := h.portalService.Statement(portalSession(c), startDate, endDate)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.JSON(statement)
+}
+
+func (h *ClientPortalHandler) PayInvoices(c *fiber.Ctx) error {
+	var req struct {
+		InvoiceIDs []string `json:"invoice_ids"`
+		Phone      string   `json:"phone"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	payment, err := h.portalService.PayInvoices(c.Context(), portalSession(c), req.InvoiceIDs, req.Phone)
+	if err != nil {
+		return c.Status(portalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(payment)
+}
+
+func (h *ClientPortalHandler) PaymentStatus(c *fiber.Ctx) error {
+	payment, err := h.portalService.PaymentStatus(portalSession(c), c.Params("checkout_id"))
+	if err != nil {
+		return c.Status(portalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(payment)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ETIMSHandler serves eTIMS device onboarding, code lists, item registration,
+// purchase import and stock reporting
+type ETIMSHandler struct {
+	etimsService *services.ETIMSService
+}
+
+func NewETIMSHandler(etimsSvc *services.ETIMSService) *ETIMSHandler {
+	return &ETIMSHandler{etimsService: etimsSvc}
+}
+
+func etimsErrorStatus(err error) int {
+	var etimsErr *services.ETIMSError
+	switch {
+	case errors.Is(err, services.ErrETIMSNotInitialized):
+		return fiber.StatusPreconditionFailed
+	case errors.Is(err, services.ErrETIMSItemNotFound), errors.Is(err, services.ErrETIMSPurchaseNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrETIMSDeviceInvalid), errors.Is(err, services.ErrETIMSModeInvalid),
+		errors.Is(err, services.ErrETIMSItemInvalid), errors.Is(err, services.ErrETIMSCodeUnknown),
+		errors.Is(err, services.ErrETIMSItemNotRegistered), errors.Is(err, services.ErrETIMSStockInvalid):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrETIMSPurchaseConfirmed), errors.Is(err, services.ErrETIMSInsufficientStock):
+		return fiber.StatusConflict
+	case errors.Is(err, services.ErrMockMode):
+		return fiber.StatusServiceUnavailable
+	case errors.As(err, &etimsErr):
+		return fiber.StatusBadGateway
+	}
+	return fiber.StatusInternalServerError
+}
+
+// GetDevice returns the tenant's eTIMS control unit
+func (h *ETIMSHandler) GetDevice(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	device, err := h.etimsService.GetDevice(tenantID)
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(device)
+}
+
+// InitializeDevice initializes the control unit and stores its communication key
+func (h *ETIMSHandler) InitializeDevice(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.ETIMSDeviceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	device, err := h.etimsService.InitializeDevice(c.UserContext(), tenantID, &req)
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(device)
+}
+
+// SyncCodes fetches code lists and item classification codes changed since the last sync
+func (h *ETIMSHandler) SyncCodes(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	synced, err := h.etimsService.SyncCodes(c.UserContext(), tenantID)
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"synced": synced})
+}
+
+// ListCodes lists synced codes (?class=04|05|10|12|17|item_class)
+func (h *ETIMSHandler) ListCodes(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	codes, err := h.etimsService.ListCodes(tenantID, c.Query("class"))
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"codes": codes})
+}
+
+// RegisterItem registers a library item with eTIMS and returns it with its KRA item code
+func (h *ETIMSHandler) RegisterItem(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.ETIMSItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	item, err := h.etimsService.RegisterItem(c.UserContext(), tenantID, userID, c.Params("id"), &req)
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(item)
+}
+
+// ImportPurchases fetches invoices issued to the tenant's PIN since the last import
+func (h *ETIMSHandler) ImportPurchases(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	imported, err := h.etimsService.ImportPurchases(c.UserContext(), tenantID)
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"imported": imported})
+}
+
+// ListPurchases lists imported purchases (?status=pending|accepted|rejected)
+func (h *ETIMSHandler) ListPurchases(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	purchases, err := h.etimsService.ListPurchases(tenantID, c.Query("status"))
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"purchases": purchases})
+}
+
+// AcceptPurchase confirms a purchase to eTIMS as received
+func (h *ETIMSHandler) AcceptPurchase(c *fiber.Ctx) error {
+	return h.confirmPurchase(c, true)
+}
+
+// RejectPurchase rejects a purchase on eTIMS
+func (h *ETIMSHandler) RejectPurchase(c *fiber.Ctx) error {
+	return h.confirmPurchase(c, false)
+}
+
+func (h *ETIMSHandler) confirmPurchase(c *fiber.Ctx, accept bool) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	purchase, err := h.etimsService.ConfirmPurchase(c.UserContext(), tenantID, userID, c.Params("id"), accept)
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(purchase)
+}
+
+// ListStockMovements lists stock movements, newest first (?item_id=)
+func (h *ETIMSHandler) ListStockMovements(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	movements, err := h.etimsService.ListStockMovements(tenantID, c.Query("item_id"))
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"movements": movements})
+}
+
+// RecordStockMovement records stock moving in or out of a registered item and reports it
+func (h *ETIMSHandler) RecordStockMovement(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		ItemID     string  `json:"item_id"`
+		Type       string  `json:"type"`
+		Quantity   float64 `json:"quantity"`
+		UnitPrice  float64 `json:"unit_price"`
+		Reference  string  `json:"reference"`
+		OccurredAt string  `json:"occurred_at"` // YYYY-MM-DD, default today
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	occurredAt, err := ledgerDate(req.OccurredAt, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "occurred_at must be YYYY-MM-DD"})
+	}
+
+	movement, err := h.etimsService.RecordStockMovement(c.UserContext(), tenantID, userID, &services.ETIMSStockRequest{
+		ItemID:     req.ItemID,
+		Type:       req.Type,
+		Quantity:   req.Quantity,
+		UnitPrice:  req.UnitPrice,
+		Reference:  req.Reference,
+		OccurredAt: occurredAt,
+	})
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(movement)
+}
+
+// ReportPendingStock retries movements eTIMS hasn't accepted yet
+func (h *ETIMSHandler) ReportPendingStock(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	reported, err := h.etimsService.ReportPendingStock(c.UserContext(), tenantID)
+	if err != nil {
+		return c.Status(etimsErrorStatus(err)).JSON(fiber.Map{"error": err.Error(), "reported": reported})
+	}
+	return c.JSON(fiber.Map{"reported": reported})
+}
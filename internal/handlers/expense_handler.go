@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -23,6 +24,17 @@ func NewExpenseHandler(expenseService *services.ExpenseService) *ExpenseHandler
 	return &ExpenseHandler{expenseService: expenseService}
 }
 
+// expenseErrorStatus maps expense errors; changes in a closed accounting period conflict
+func expenseErrorStatus(err error) int {
+	if errors.Is(err, services.ErrPeriodClosed) {
+		return fiber.StatusConflict
+	}
+	if errors.Is(err, services.ErrExpenseSupplierPIN) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
 func (h *ExpenseHandler) CreateExpense(c *fiber.Ctx) error {
 	tenantID := middleware.GetTenantID(c)
 	if tenantID == "" {
@@ -40,9 +52,9 @@ func (h *ExpenseHandler) CreateExpense(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "title and amount required"})
 	}
 
-	expense, err := h.expenseService.CreateExpense(tenantID, userID, &req)
+	expense, err := h.expenseService.CreateExpense(c.UserContext(), tenantID, userID, &req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(expenseErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(expense)
@@ -65,6 +77,11 @@ func (h *ExpenseHandler) GetExpenses(c *fiber.Ctx) error {
 		"limit":       c.QueryInt("limit", 15),
 	}
 
+	// Expenses whose supplier invoice isn't verified can't claim input VAT
+	if etimsStatus := c.Query("etims_status"); etimsStatus != "" {
+		filters["etims_status"] = etimsStatus
+	}
+
 	// Parse amount filters as float64
 	if minAmt := c.Query("min_amount"); minAmt != "" {
 		if f, err := strconv.ParseFloat(minAmt, 64); err == nil {
@@ -142,9 +159,29 @@ func (h *ExpenseHandler) UpdateExpense(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
 	}
 
-	expense, err := h.expenseService.UpdateExpense(tenantID, expenseID, &req)
+	expense, err := h.expenseService.UpdateExpense(c.UserContext(), tenantID, expenseID, &req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(expenseErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(expense)
+}
+
+// VerifyExpense checks the expense's supplier invoice against eTIMS again
+func (h *ExpenseHandler) VerifyExpense(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	expenseID := c.Params("id")
+	if expenseID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expense ID required"})
+	}
+
+	expense, err := h.expenseService.VerifyExpense(c.UserContext(), tenantID, expenseID)
+	if err != nil {
+		return c.Status(expenseErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.JSON(expense)
@@ -162,7 +199,7 @@ func (h *ExpenseHandler) DeleteExpense(c *fiber.Ctx) error {
 	}
 
 	if err := h.expenseService.DeleteExpense(tenantID, expenseID); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(expenseErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.JSON(fiber.Map{"message": "expense deleted"})
@@ -399,7 +436,7 @@ func (h *ExpenseHandler) bulkApproveExpenses(c *fiber.Ctx, tenantID string, ids
 				Status: func() *string { s := "approved"; return &s }(),
 			}
 
-			if _, err := h.expenseService.UpdateExpense(tenantID, id, &updateReq); err != nil {
+			if _, err := h.expenseService.UpdateExpense(c.UserContext(), tenantID, id, &updateReq); err != nil {
 				failedCount++
 			} else {
 				approvedCount++
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FXHandler serves dated exchange rates, tenant rate overrides and month-end revaluation
+type FXHandler struct {
+	exchangeService *services.ExchangeRateService
+	ledgerService   *services.LedgerService
+}
+
+func NewFXHandler(exchangeSvc *services.ExchangeRateService, ledgerSvc *services.LedgerService) *FXHandler {
+	return &FXHandler{exchangeService: exchangeSvc, ledgerService: ledgerSvc}
+}
+
+func fxErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrExchangeRateNotFound), errors.Is(err, services.ErrRevaluationNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrExchangeRateInvalid), errors.Is(err, services.ErrPeriodInvalid):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrRevaluationExists), errors.Is(err, services.ErrPeriodClosed),
+		errors.Is(err, services.ErrPeriodNotEnded):
+		return fiber.StatusConflict
+	case errors.Is(err, services.ErrRevaluationNoRates):
+		return fiber.StatusServiceUnavailable
+	}
+	return fiber.StatusInternalServerError
+}
+
+// ListRates lists published rates and the tenant's overrides (?currency=&from=&to=, default the last 30 days)
+func (h *FXHandler) ListRates(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	to, err := ledgerDate(c.Query("to"), time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be YYYY-MM-DD"})
+	}
+	from, err := ledgerDate(c.Query("from"), to.AddDate(0, 0, -30))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be YYYY-MM-DD"})
+	}
+
+	rates, err := h.exchangeService.ListRates(tenantID, c.Query("currency"), from, to)
+	if err != nil {
+		return c.Status(fxErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"rates": rates})
+}
+
+// SetOverride sets the tenant's own rate for a currency on a day
+func (h *FXHandler) SetOverride(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Currency   string  `json:"currency"`
+		Date       string  `json:"date"`
+		KESPerUnit float64 `json:"kes_per_unit"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	date, err := ledgerDate(req.Date, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+	}
+
+	rate, err := h.exchangeService.SetOverride(tenantID, userID, req.Currency, date, req.KESPerUnit)
+	if err != nil {
+		return c.Status(fxErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(rate)
+}
+
+// DeleteOverride removes one of the tenant's rate overrides
+func (h *FXHandler) DeleteOverride(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	if err := h.exchangeService.DeleteOverride(tenantID, c.Params("id")); err != nil {
+		return c.Status(fxErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Exchange rate override deleted"})
+}
+
+func (h *FXHandler) ListRevaluations(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	runs, err := h.ledgerService.ListRevaluations(tenantID)
+	if err != nil {
+		return c.Status(fxErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"revaluations": runs})
+}
+
+func (h *FXHandler) GetRevaluation(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	run, err := h.ledgerService.GetRevaluation(tenantID, c.Params("id"))
+	if err != nil {
+		return c.Status(fxErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(run)
+}
+
+// RunRevaluation revalues open foreign-currency invoices at a month's closing rate
+func (h *FXHandler) RunRevaluation(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Period string `json:"period"` // YYYY-MM
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	run, err := h.ledgerService.RevalueForeignBalances(tenantID, userID, req.Period)
+	if err != nil {
+		return c.Status(fxErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(run)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ImportHandler struct {
+	importService *services.ImportService
+}
+
+func NewImportHandler(importSvc *services.ImportService) *ImportHandler {
+	return &ImportHandler{importService: importSvc}
+}
+
+// Fields lists the mappable columns for an entity type
+func (h *ImportHandler) Fields(c *fiber.Ctx) error {
+	fields := services.ImportFields(c.Params("entity"))
+	if fields == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unsupported import type"})
+	}
+	return c.JSON(fiber.Map{"fields": fields})
+}
+
+// Upload accepts a CSV or XLSX file and returns a preview with a suggested mapping
+func (h *ImportHandler) Upload(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "no file provided"})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "failed to read file"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "failed to read file"})
+	}
+
+	preview, err := h.importService.Upload(tenantID, userID, c.FormValue("entity_type"), fileHeader.Filename, data)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(preview)
+}
+
+func (h *ImportHandler) ListImports(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	jobs, err := h.importService.ListImports(tenantID, c.QueryInt("limit", 20))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"imports": jobs})
+}
+
+func (h *ImportHandler) GetImport(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	job, err := h.importService.GetImport(tenantID, c.Params("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrImportNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(job)
+}
+
+// Validate dry-runs the import and returns row-level errors without saving anything
+func (h *ImportHandler) Validate(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Mapping map[string]string `json:"mapping"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+	}
+
+	result, err := h.importService.Validate(tenantID, userID, c.Params("id"), req.Mapping)
+	if err != nil {
+		if errors.Is(err, services.ErrImportNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(result)
+}
+
+// Commit creates the imported records in a single transaction
+func (h *ImportHandler) Commit(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.CommitImportRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+	}
+
+	result, err := h.importService.Commit(tenantID, userID, c.Params("id"), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrImportNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		if result != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error(), "result": result})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(result)
+}
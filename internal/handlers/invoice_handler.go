@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"time"
@@ -48,6 +49,18 @@ func NewInvoiceHandler(invoiceSvc *services.InvoiceService, kraSvc *services.KRA
 	}
 }
 
+// reversalErrorStatus maps errors from cancelling, deleting and crediting invoices
+func reversalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvoiceNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrCreditNoteExceedsInvoice), errors.Is(err, services.ErrInvoiceKRARegistered),
+		errors.Is(err, services.ErrAllocationConflict):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
+
 // CreateInvoice - create new invoice
 func (h *InvoiceHandler) CreateInvoice(c *fiber.Ctx) error {
 	tenantID := middleware.GetTenantID(c)
@@ -262,7 +275,7 @@ func (h *InvoiceHandler) CancelInvoice(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 	err := h.invoiceService.CancelInvoice(tenantID, invoiceID, userID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(reversalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	if h.subService != nil {
@@ -389,7 +402,7 @@ func (h *InvoiceHandler) HandleMpesaCallback(c *fiber.Ctx) error {
 	}
 
 	if h.mpesaService != nil {
-		err := h.mpesaService.ProcessSTKCallback(c.Context(), *callback)
+		err := h.mpesaService.ProcessSTKCallback(c.Context(), c.Params("tenantID"), *callback)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "callback processing failed",
@@ -606,6 +619,13 @@ func (h *InvoiceHandler) RecordPayment(c *fiber.Ctx) error {
 		Method    string  `json:"method"`
 		Reference string  `json:"reference"`
 		Date      string  `json:"date"`
+		// Tax the client deducted before paying, with its certificate if they've issued it
+		Withholding []struct {
+			Type              string  `json:"type"` // vat, income_tax
+			Amount            float64 `json:"amount"`
+			Rate              float64 `json:"rate"`
+			CertificateNumber string  `json:"certificate_number"`
+		} `json:"withholding"`
 	}
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
@@ -645,6 +665,15 @@ func (h *InvoiceHandler) RecordPayment(c *fiber.Ctx) error {
 	// Generate unique ID to bypass any unique constraints on tenant_id
 	payment.ID = uuid.New().String()
 
+	for _, w := range req.Withholding {
+		payment.Withholdings = append(payment.Withholdings, models.WithholdingCertificate{
+			Type:              w.Type,
+			Amount:            models.ToCents(w.Amount),
+			Rate:              w.Rate,
+			CertificateNumber: w.CertificateNumber,
+		})
+	}
+
 	if err := h.invoiceService.RecordPayment(tenantID, invoiceID, payment); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -665,13 +694,13 @@ func (h *InvoiceHandler) DeleteInvoice(c *fiber.Ctx) error {
 	}
 
 	if err := h.invoiceService.DeleteInvoice(tenantID, invoiceID); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(reversalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.JSON(fiber.Map{"message": "invoice deleted"})
 }
 
-// CreateCreditNote creates a credit note for an invoice
+// CreateCreditNote credits an invoice registered with KRA, in full when no items are given
 func (h *InvoiceHandler) CreateCreditNote(c *fiber.Ctx) error {
 	tenantID := middleware.GetTenantID(c)
 	if tenantID == "" {
@@ -684,21 +713,20 @@ func (h *InvoiceHandler) CreateCreditNote(c *fiber.Ctx) error {
 	}
 
 	var req struct {
-		Items []services.CreateCreditNoteItem `json:"items"`
+		Items  []services.CreateCreditNoteItem `json:"items"`
+		Reason string                          `json:"reason"`
 	}
 
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
-	}
-
-	if len(req.Items) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "at least one item required"})
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+		}
 	}
 
 	userID := middleware.GetUserID(c)
-	creditNote, err := h.invoiceService.CreateCreditNote(tenantID, userID, invoiceID, req.Items)
+	creditNote, err := h.invoiceService.CreateCreditNote(tenantID, userID, invoiceID, req.Items, req.Reason)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(reversalErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(creditNote)
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LedgerHandler serves the chart of accounts, journal entries and ledger reports
+type LedgerHandler struct {
+	ledgerService *services.LedgerService
+}
+
+func NewLedgerHandler(ledgerSvc *services.LedgerService) *LedgerHandler {
+	return &LedgerHandler{ledgerService: ledgerSvc}
+}
+
+func ledgerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrLedgerAccountNotFound), errors.Is(err, services.ErrJournalNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrLedgerAccountCode), errors.Is(err, services.ErrJournalReversed),
+		errors.Is(err, services.ErrPeriodClosed):
+		return fiber.StatusConflict
+	case errors.Is(err, services.ErrLedgerAccountInvalid), errors.Is(err, services.ErrLedgerAccountInactive),
+		errors.Is(err, services.ErrLedgerSystemKey), errors.Is(err, services.ErrJournalUnbalanced),
+		errors.Is(err, services.ErrJournalNotReversible):
+		return fiber.StatusUnprocessableEntity
+	}
+	return fiber.StatusInternalServerError
+}
+
+// ledgerDate reads a YYYY-MM-DD query or body value, falling back to def when empty
+func ledgerDate(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func (h *LedgerHandler) ListAccounts(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	accounts, err := h.ledgerService.ListAccounts(tenantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"accounts": accounts})
+}
+
+func (h *LedgerHandler) CreateAccount(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.LedgerAccountInput
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	account, err := h.ledgerService.CreateAccount(tenantID, req)
+	if err != nil {
+		return c.Status(ledgerErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(account)
+}
+
+// UpdateAccount changes an account's code, name, description or active flag
+func (h *LedgerHandler) UpdateAccount(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.LedgerAccountInput
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	account, err := h.ledgerService.UpdateAccount(tenantID, c.Params("id"), req)
+	if err != nil {
+		return c.Status(ledgerErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(account)
+}
+
+// AssignSystemKey makes the account the target of an automatic posting, e.g. sales
+func (h *LedgerHandler) AssignSystemKey(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		SystemKey string `json:"system_key"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.SystemKey == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "system_key is required"})
+	}
+
+	account, err := h.ledgerService.AssignSystemKey(tenantID, c.Params("id"), req.SystemKey)
+	if err != nil {
+		return c.Status(ledgerErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(account)
+}
+
+// ListJournals lists journal entries, newest first, optionally for
+// ?from=YYYY-MM-DD&to=YYYY-MM-DD and ?source_type=
+func (h *LedgerHandler) ListJournals(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	filter := services.JournalFilter{
+		SourceType: c.Query("source_type"),
+		Limit:      c.QueryInt("limit", 50),
+		Offset:     c.QueryInt("offset", 0),
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be YYYY-MM-DD"})
+		}
+		filter.From = &t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be YYYY-MM-DD"})
+		}
+		filter.To = &t
+	}
+
+	entries, total, err := h.ledgerService.ListJournals(tenantID, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"journals": entries, "total": total})
+}
+
+func (h *LedgerHandler) GetJournal(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	entry, err := h.ledgerService.GetJournal(tenantID, c.Params("id"))
+	if err != nil {
+		return c.Status(ledgerErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(entry)
+}
+
+// CreateJournal posts a manual journal entry
+func (h *LedgerHandler) CreateJournal(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Date  string                      `json:"date"` // YYYY-MM-DD, default today
+		Memo  string                      `json:"memo"`
+		Lines []services.JournalLineInput `json:"lines"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	date, err := ledgerDate(req.Date, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+	}
+
+	entry, err := h.ledgerService.CreateJournal(tenantID, userID, date, req.Memo, req.Lines)
+	if err != nil {
+		return c.Status(ledgerErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(entry)
+}
+
+// ReverseJournal posts the opposite of a manual journal entry
+func (h *LedgerHandler) ReverseJournal(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Date string `json:"date"`
+		Memo string `json:"memo"`
+	}
+	_ = c.BodyParser(&req)
+	date, err := ledgerDate(req.Date, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+	}
+
+	entry, err := h.ledgerService.ReverseJournal(tenantID, userID, c.Params("id"), date, req.Memo)
+	if err != nil {
+		return c.Status(ledgerErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(entry)
+}
+
+// TrialBalance reports account balances as of ?as_of=YYYY-MM-DD (default today)
+func (h *LedgerHandler) TrialBalance(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	asOf, err := ledgerDate(c.Query("as_of"), time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "as_of must be YYYY-MM-DD"})
+	}
+
+	report, err := h.ledgerService.TrialBalance(tenantID, asOf)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(report)
+}
+
+// BalanceSheet reports assets, liabilities and equity as of ?as_of=YYYY-MM-DD (default today)
+func (h *LedgerHandler) BalanceSheet(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	asOf, err := ledgerDate(c.Query("as_of"), time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "as_of must be YYYY-MM-DD"})
+	}
+
+	sheet, err := h.ledgerService.BalanceSheet(tenantID, asOf)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(sheet)
+}
+
+// GeneralLedger lists postings for ?from=YYYY-MM-DD&to=YYYY-MM-DD (default:
+// this month), for one ?account_id= or every account
+func (h *LedgerHandler) GeneralLedger(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	from, err := ledgerDate(c.Query("from"), monthStart)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be YYYY-MM-DD"})
+	}
+	to, err := ledgerDate(c.Query("to"), monthStart.AddDate(0, 1, -1))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be YYYY-MM-DD"})
+	}
+	if to.Before(from) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must not be before from"})
+	}
+
+	report, err := h.ledgerService.GeneralLedger(tenantID, c.Query("account_id"), from, to)
+	if err != nil {
+		return c.Status(ledgerErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(report)
+}
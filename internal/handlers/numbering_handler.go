@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NumberingHandler serves how the tenant numbers each document type
+type NumberingHandler struct {
+	numberingService *services.NumberingService
+}
+
+func NewNumberingHandler(numberingSvc *services.NumberingService) *NumberingHandler {
+	return &NumberingHandler{numberingService: numberingSvc}
+}
+
+func numberingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrNumberingDocumentType):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrNumberingPattern), errors.Is(err, services.ErrNumberingPrefix),
+		errors.Is(err, services.ErrNumberingPadding), errors.Is(err, services.ErrNumberingReset):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+// ListSchemes lists each document type's numbering with the number it issues next
+func (h *NumberingHandler) ListSchemes(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	schemes, err := h.numberingService.ListSchemes(tenantID)
+	if err != nil {
+		return c.Status(numberingErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"numbering": schemes})
+}
+
+// UpdateScheme changes the prefix, pattern, padding or yearly reset of a document type
+func (h *NumberingHandler) UpdateScheme(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Prefix      *string `json:"prefix"`
+		Pattern     *string `json:"pattern"` // e.g. {PREFIX}/{FY}/{SEQ:5}; empty for PREFIX-000001
+		Padding     *int    `json:"padding"`
+		ResetYearly *bool   `json:"reset_yearly"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	scheme, err := h.numberingService.UpdateScheme(tenantID, userID, c.Params("documentType"), services.NumberingUpdate{
+		Prefix:      req.Prefix,
+		Pattern:     req.Pattern,
+		Padding:     req.Padding,
+		ResetYearly: req.ResetYearly,
+	})
+	if err != nil {
+		return c.Status(numberingErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(scheme)
+}
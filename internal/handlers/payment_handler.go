@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -19,6 +21,12 @@ type PaymentHandler struct {
 	mpesaService     *services.MPesaService
 	db               *database.DB
 	thankYouService *services.ThankYouMessageService
+	payoutService   *services.PayoutService
+}
+
+// SetPayoutService sends M-Pesa refunds back over B2C instead of only marking them refunded
+func (h *PaymentHandler) SetPayoutService(payoutSvc *services.PayoutService) {
+	h.payoutService = payoutSvc
 }
 
 // NewPaymentHandler creates PaymentHandler
@@ -123,7 +131,7 @@ func (h *PaymentHandler) HandleMpesaCallback(c *fiber.Ctx) error {
 	}
 
 	if h.mpesaService != nil {
-		err := h.mpesaService.ProcessSTKCallback(c.Context(), *callback)
+		err := h.mpesaService.ProcessSTKCallback(c.Context(), c.Params("tenantID"), *callback)
 		if err != nil {
 			logger.Get().Error(c.UserContext(), "Callback processing error", "component", "M-Pesa", "error", err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -141,6 +149,40 @@ func (h *PaymentHandler) HandleMpesaCallback(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"status": "received"})
 }
 
+// HandleMpesaStatusResult processes Transaction Status results for STK pushes the reconciler asked about
+func (h *PaymentHandler) HandleMpesaStatusResult(c *fiber.Ctx) error {
+	return h.handleMpesaResult(c, h.mpesaService.ProcessTransactionStatusResult)
+}
+
+// HandleMpesaStatusTimeout processes Transaction Status queries that timed out in Daraja's queue
+func (h *PaymentHandler) HandleMpesaStatusTimeout(c *fiber.Ctx) error {
+	return h.handleMpesaResult(c, h.mpesaService.TransactionStatusTimeout)
+}
+
+func (h *PaymentHandler) handleMpesaResult(c *fiber.Ctx, process func(context.Context, string, *services.MpesaResult) error) error {
+	if h.mpesaService == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "M-Pesa not configured"})
+	}
+
+	var result services.MpesaResult
+	if err := c.BodyParser(&result); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid result"})
+	}
+
+	if err := process(c.Context(), c.Params("tenantID"), &result); err != nil {
+		logger.Get().Error(c.UserContext(), "Result processing error", "component", "M-Pesa", "error", err)
+		if errors.Is(err, services.ErrMpesaResultUnknown) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown result"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "result processing failed",
+			"code":  "PROCESSING_ERROR",
+		})
+	}
+
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
+
 // InitiateSTKPush initiates M-Pesa STK push
 func (h *PaymentHandler) InitiateSTKPush(c *fiber.Ctx) error {
 	var req struct {
@@ -362,11 +404,26 @@ func (h *PaymentHandler) RefundPayment(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "payment already refunded"})
 	}
 
-	now := time.Now()
-	payment.Status = "refunded"
-	payment.UpdatedAt = now
+	// M-Pesa refunds are paid back over B2C once approved; the payment is
+	// marked refunded when M-Pesa confirms the payout
+	if payment.Method == models.PaymentMethodMpesa && h.payoutService != nil {
+		var req struct {
+			PhoneNumber string `json:"phone_number"`
+			Reason      string `json:"reason"`
+		}
+		_ = c.BodyParser(&req)
+		payout, err := h.payoutService.RequestPayout(tenantID, middleware.GetUserID(c), models.PayoutTypeRefund, &services.PayoutRequest{
+			PaymentID: payment.ID, PhoneNumber: req.PhoneNumber, Reason: req.Reason,
+		})
+		if err != nil {
+			return c.Status(payoutErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "refund awaiting approval", "payout": payout})
+	}
 
-	if err := h.db.Save(&payment).Error; err != nil {
+	// Update only the status; a full save would write back the decrypted phone number
+	if err := h.db.Model(&models.Payment{}).Where("id = ?", payment.ID).
+		Updates(map[string]interface{}{"status": models.PaymentStatusRefunded, "updated_at": time.Now()}).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to process refund"})
 	}
 
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -122,6 +123,47 @@ func (h *PaymentMatchingHandler) ManualMatch(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"message": "payment matched successfully"})
 }
 
+// AllocatePayment records one payment and splits it across a client's invoices
+func (h *PaymentMatchingHandler) AllocatePayment(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.AllocatePaymentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = c.Get("Idempotency-Key")
+	}
+
+	result, err := h.service.AllocatePayment(tenantID, middleware.GetUserID(c), &req)
+	if err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, services.ErrAllocationConflict) || errors.Is(err, services.ErrUnallocatedAlreadyMatched) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(result)
+}
+
+func (h *PaymentMatchingHandler) GetClientCredit(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	balance, history, err := h.service.GetClientCredit(tenantID, c.Params("clientID"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "client not found"})
+	}
+
+	return c.JSON(fiber.Map{"credit_balance": balance, "transactions": history})
+}
+
 func (h *PaymentMatchingHandler) GetReceipt(c *fiber.Ctx) error {
 	tenantID := middleware.GetTenantID(c)
 	if tenantID == "" {
@@ -137,13 +179,18 @@ func (h *PaymentMatchingHandler) GetReceipt(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "payment not found"})
 	}
+	if err := h.service.IssueReceiptNumber(payment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
 
 	// Get related invoice and client
 	invoice, _ := h.invoiceService.GetInvoiceByID(tenantID, payment.InvoiceID)
+	allocations, _ := h.service.GetAllocations(tenantID, payment.ID)
 
 	return c.JSON(fiber.Map{
-		"payment": payment,
-		"invoice": invoice,
+		"payment":     payment,
+		"invoice":     invoice,
+		"allocations": allocations,
 	})
 }
 
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+
+	"invoicefast/internal/logger"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PayoutHandler serves M-Pesa B2C payouts and their Daraja result callbacks
+type PayoutHandler struct {
+	payoutService *services.PayoutService
+}
+
+func NewPayoutHandler(payoutSvc *services.PayoutService) *PayoutHandler {
+	return &PayoutHandler{payoutService: payoutSvc}
+}
+
+func payoutErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPayoutNotFound), errors.Is(err, services.ErrPayoutSourceMissing):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrPayoutNotPending), errors.Is(err, services.ErrPayoutInProgress):
+		return fiber.StatusConflict
+	case errors.Is(err, services.ErrPayoutSelfApproval):
+		return fiber.StatusForbidden
+	case errors.Is(err, services.ErrPayoutType), errors.Is(err, services.ErrPayoutAmount),
+		errors.Is(err, services.ErrPayoutExceedsCredit), errors.Is(err, services.ErrPayoutPhone),
+		errors.Is(err, services.ErrPayoutSource), errors.Is(err, services.ErrPayoutNotConfigured),
+		errors.Is(err, services.ErrMpesaNotConfigured):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusBadGateway
+}
+
+// ListPayouts returns the tenant's payouts, filtered by ?status= and ?type=
+func (h *PayoutHandler) ListPayouts(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	payouts, err := h.payoutService.ListPayouts(tenantID, c.Query("status"), c.Query("type"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"payouts": payouts, "total": len(payouts)})
+}
+
+// GetPayout returns a payout with its history of statuses and receipts
+func (h *PayoutHandler) GetPayout(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	payout, err := h.payoutService.GetPayout(tenantID, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(payout)
+}
+
+// RequestPayout records a refund, overpayment return or reimbursement for approval
+func (h *PayoutHandler) RequestPayout(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Type string `json:"type"`
+		services.PayoutRequest
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	payout, err := h.payoutService.RequestPayout(tenantID, middleware.GetUserID(c), req.Type, &req.PayoutRequest)
+	if err != nil {
+		return c.Status(payoutErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(payout)
+}
+
+// ApprovePayout sends a pending payout to M-Pesa
+func (h *PayoutHandler) ApprovePayout(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	payout, err := h.payoutService.Approve(c.UserContext(), tenantID, middleware.GetUserID(c), c.Params("id"))
+	if err != nil {
+		return c.Status(payoutErrorStatus(err)).JSON(fiber.Map{"error": err.Error(), "payout": payout})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(payout)
+}
+
+// RejectPayout declines a pending payout
+func (h *PayoutHandler) RejectPayout(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	_ = c.BodyParser(&req)
+
+	payout, err := h.payoutService.Reject(tenantID, middleware.GetUserID(c), c.Params("id"), req.Reason)
+	if err != nil {
+		return c.Status(payoutErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(payout)
+}
+
+// HandleB2CResult processes Daraja's B2C result callback
+func (h *PayoutHandler) HandleB2CResult(c *fiber.Ctx) error {
+	var result services.MpesaResult
+	if err := c.BodyParser(&result); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid result"})
+	}
+
+	if err := h.payoutService.ProcessB2CResult(c.UserContext(), c.Params("tenantID"), &result); err != nil {
+		logger.Get().Error(c.UserContext(), "B2C result processing error", "component", "M-Pesa", "error", err)
+		if errors.Is(err, services.ErrMpesaResultUnknown) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown result"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "result processing failed"})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
+
+// HandleB2CTimeout processes payouts that timed out in Daraja's queue
+func (h *PayoutHandler) HandleB2CTimeout(c *fiber.Ctx) error {
+	var result services.MpesaResult
+	if err := c.BodyParser(&result); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid result"})
+	}
+
+	if err := h.payoutService.B2CTimeout(c.UserContext(), c.Params("tenantID"), &result); err != nil {
+		logger.Get().Error(c.UserContext(), "B2C timeout processing error", "component", "M-Pesa", "error", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown result"})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
+
+// HandleStatusResult settles a payout from Daraja's Transaction Status answer
+func (h *PayoutHandler) HandleStatusResult(c *fiber.Ctx) error {
+	var result services.MpesaResult
+	if err := c.BodyParser(&result); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid result"})
+	}
+
+	if err := h.payoutService.ProcessStatusResult(c.UserContext(), c.Params("tenantID"), &result); err != nil {
+		logger.Get().Error(c.UserContext(), "Payout status processing error", "component", "M-Pesa", "error", err)
+		if errors.Is(err, services.ErrMpesaResultUnknown) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown result"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "result processing failed"})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
+
+// HandleStatusTimeout processes payout status queries that timed out in Daraja's queue
+func (h *PayoutHandler) HandleStatusTimeout(c *fiber.Ctx) error {
+	var result services.MpesaResult
+	if err := c.BodyParser(&result); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid result"})
+	}
+
+	if err := h.payoutService.StatusTimeout(c.UserContext(), c.Params("tenantID"), &result); err != nil {
+		logger.Get().Error(c.UserContext(), "Payout status timeout error", "component", "M-Pesa", "error", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown result"})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
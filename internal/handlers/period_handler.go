@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PeriodHandler serves the fiscal year settings and month-end close
+type PeriodHandler struct {
+	periodService *services.PeriodService
+}
+
+func NewPeriodHandler(periodSvc *services.PeriodService) *PeriodHandler {
+	return &PeriodHandler{periodService: periodSvc}
+}
+
+func periodErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPeriodInvalid), errors.Is(err, services.ErrPeriodReason),
+		errors.Is(err, services.ErrFiscalYearStart), errors.Is(err, services.ErrFiscalYearFormat),
+		errors.Is(err, services.ErrNumberingReset):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrPeriodClosed), errors.Is(err, services.ErrPeriodLocked),
+		errors.Is(err, services.ErrPeriodNotClosed), errors.Is(err, services.ErrPeriodNotEnded):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusInternalServerError
+}
+
+func (h *PeriodHandler) GetFiscalYear(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	config, err := h.periodService.GetFiscalYear(tenantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(config)
+}
+
+// SetFiscalYear sets the month the fiscal year starts in
+func (h *PeriodHandler) SetFiscalYear(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		StartMonth int `json:"start_month"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	config, err := h.periodService.SetFiscalYear(tenantID, userID, req.StartMonth)
+	if err != nil {
+		return c.Status(periodErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(config)
+}
+
+// ListPeriods lists the months of ?fiscal_year= (the year it starts in; default the current one)
+func (h *PeriodHandler) ListPeriods(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	periods, err := h.periodService.ListPeriods(tenantID, c.QueryInt("fiscal_year", 0))
+	if err != nil {
+		return c.Status(periodErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"periods": periods})
+}
+
+// ClosePeriod closes a month (YYYY-MM) to changes
+func (h *PeriodHandler) ClosePeriod(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	period, err := h.periodService.ClosePeriod(tenantID, userID, c.Params("period"))
+	if err != nil {
+		return c.Status(periodErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(period)
+}
+
+// LockPeriod closes a month for good
+func (h *PeriodHandler) LockPeriod(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	period, err := h.periodService.LockPeriod(tenantID, userID, c.Params("period"))
+	if err != nil {
+		return c.Status(periodErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(period)
+}
+
+// ReopenPeriod reopens a closed month; a reason is required
+func (h *PeriodHandler) ReopenPeriod(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	period, err := h.periodService.ReopenPeriod(tenantID, userID, c.Params("period"), req.Reason)
+	if err != nil {
+		return c.Status(periodErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(period)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -21,6 +22,7 @@ type PublicHandler struct {
 	intasendService      *services.IntasendService
 	emailTrackingService *services.EmailTrackingService
 	planService          *services.PlanService
+	quoteService         *services.QuoteService
 }
 
 // NewPublicHandler creates a new PublicHandler
@@ -62,6 +64,11 @@ func NewPublicHandlerWithTracking(
 	}
 }
 
+// SetQuoteService enables the quote accept/decline portal
+func (h *PublicHandler) SetQuoteService(quoteSvc *services.QuoteService) {
+	h.quoteService = quoteSvc
+}
+
 // ServeLanding serves the landing page
 func (h *PublicHandler) ServeLanding(c *fiber.Ctx) error {
 	return c.SendFile("./views/pages/landing.html")
@@ -85,6 +92,75 @@ func (h *PublicHandler) ServePortal(c *fiber.Ctx) error {
 	})
 }
 
+// ServeQuotePortal - get public quote by magic token
+func (h *PublicHandler) ServeQuotePortal(c *fiber.Ctx) error {
+	if h.quoteService == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+	}
+	quote, err := h.quoteService.GetQuoteByMagicToken(c.Params("token"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"id":          quote.ID,
+		"quoteNumber": quote.QuoteNumber,
+		"title":       quote.Title,
+		"clientName":  quote.Client.Name,
+		"items":       quote.Items,
+		"subtotal":    quote.Subtotal,
+		"discount":    quote.Discount,
+		"totalTax":    quote.TotalTax,
+		"total":       quote.Total,
+		"currency":    quote.Currency,
+		"status":      quote.Status,
+		"expiryDate":  quote.ExpiryDate,
+		"notes":       quote.Notes,
+		"terms":       quote.Terms,
+	})
+}
+
+// AcceptQuote - client accepts a quote from the portal; it is converted into an invoice
+func (h *PublicHandler) AcceptQuote(c *fiber.Ctx) error {
+	if h.quoteService == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+	}
+	quote, invoice, err := h.quoteService.AcceptQuoteByToken(c.Params("token"))
+	if err != nil {
+		if errors.Is(err, services.ErrQuoteNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+		}
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":       "Quote accepted",
+		"status":        quote.Status,
+		"invoiceNumber": invoice.InvoiceNumber,
+	})
+}
+
+// DeclineQuote - client declines a quote from the portal
+func (h *PublicHandler) DeclineQuote(c *fiber.Ctx) error {
+	if h.quoteService == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	c.BodyParser(&req)
+
+	quote, err := h.quoteService.DeclineQuoteByToken(c.Params("token"), req.Reason)
+	if err != nil {
+		if errors.Is(err, services.ErrQuoteNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+		}
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"message": "Quote declined", "status": quote.Status})
+}
+
 // ServeSuccess - payment success JSON
 func (h *PublicHandler) ServeSuccess(c *fiber.Ctx) error {
 	token := c.Params("token")
@@ -208,7 +284,19 @@ func (h *PublicHandler) CheckPaymentStatus(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Not found"})
 	}
-	return c.JSON(fiber.Map{"status": invoice.Status, "paidAmount": invoice.PaidAmount})
+	resp := fiber.Map{"status": invoice.Status, "paidAmount": invoice.PaidAmount}
+
+	// The latest payment tells the portal when a push failed, so it can stop waiting
+	if h.paymentService != nil {
+		payments, err := h.paymentService.GetPaymentsByInvoice(c.Context(), invoice.TenantID, invoice.ID)
+		if err == nil && len(payments) > 0 {
+			resp["paymentStatus"] = payments[0].Status
+			if payments[0].Status == models.PaymentStatusFailed {
+				resp["failureReason"] = payments[0].FailureReason
+			}
+		}
+	}
+	return c.JSON(resp)
 }
 
 // GetPricing - get pricing info
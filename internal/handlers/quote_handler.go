@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type QuoteHandler struct {
+	quoteService *services.QuoteService
+}
+
+func NewQuoteHandler(quoteSvc *services.QuoteService) *QuoteHandler {
+	return &QuoteHandler{quoteService: quoteSvc}
+}
+
+// quoteErrorStatus maps quote service errors to HTTP status codes
+func quoteErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrQuoteNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrQuoteAlreadyConverted), errors.Is(err, services.ErrQuoteNotOpen),
+		errors.Is(err, services.ErrQuoteNotEditable), errors.Is(err, services.ErrQuoteExpired):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
+
+func (h *QuoteHandler) ListQuotes(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	quotes, total, err := h.quoteService.ListQuotes(tenantID, services.QuoteFilter{
+		Status:   c.Query("status"),
+		ClientID: c.Query("client_id"),
+		Offset:   (page - 1) * limit,
+		Limit:    limit,
+	})
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+
+	return c.JSON(NewPaginatedResponse(quotes, page, limit, total))
+}
+
+func (h *QuoteHandler) CreateQuote(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.CreateQuoteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	quote, err := h.quoteService.CreateQuote(tenantID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(quote)
+}
+
+func (h *QuoteHandler) GetQuote(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	quote, err := h.quoteService.GetQuote(tenantID, c.Params("id"))
+	if err != nil {
+		return c.Status(quoteErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(quote)
+}
+
+func (h *QuoteHandler) UpdateQuote(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.CreateQuoteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	quote, err := h.quoteService.UpdateQuote(tenantID, c.Params("id"), &req)
+	if err != nil {
+		return c.Status(quoteErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(quote)
+}
+
+func (h *QuoteHandler) DeleteQuote(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	if err := h.quoteService.DeleteQuote(tenantID, c.Params("id")); err != nil {
+		return c.Status(quoteErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"message": "Quote deleted"})
+}
+
+// SendQuote emails the quote to the client and returns the portal link
+func (h *QuoteHandler) SendQuote(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	quote, err := h.quoteService.SendQuote(tenantID, c.Params("id"))
+	if err != nil {
+		return c.Status(quoteErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"quote": quote, "link": h.quoteService.QuoteLink(quote)})
+}
+
+// ConvertQuote accepts the quote on the client's behalf and creates the invoice
+func (h *QuoteHandler) ConvertQuote(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	quote, invoice, err := h.quoteService.ConvertToInvoice(tenantID, userID, c.Params("id"))
+	if err != nil {
+		return c.Status(quoteErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"quote": quote, "invoice": invoice})
+}
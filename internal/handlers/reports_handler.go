@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -108,6 +109,21 @@ func (h *ReportHandler) GetTax(c *fiber.Ctx) error {
 	return c.JSON(result)
 }
 
+func (h *ReportHandler) GetQuoteConversion(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	period := c.Query("period", "30")
+	result, err := h.reportService.GetQuoteConversion(tenantID, period)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(result)
+}
+
 func (h *ReportHandler) GetVATReport(c *fiber.Ctx) error {
 	tenantID := middleware.GetTenantID(c)
 	if tenantID == "" {
@@ -123,6 +139,75 @@ func (h *ReportHandler) GetVATReport(c *fiber.Ctx) error {
 	return c.JSON(result)
 }
 
+// GetWithholdingReport reconciles withholding certificates received against deductions made
+func (h *ReportHandler) GetWithholdingReport(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	period := c.Query("period", "30")
+	result, err := h.reportService.GetWithholdingReport(tenantID, period)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(result)
+}
+
+// ExportVAT3 downloads a month's VAT3 schedules as the zip iTax uploads and records the filing.
+// Lines iTax would reject come back as 422; a month exported before needs "reexport": true.
+func (h *ReportHandler) ExportVAT3(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Period   string `json:"period"` // YYYY-MM
+		Reexport bool   `json:"reexport"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	export, err := h.reportService.ExportVAT3(tenantID, userID, req.Period, req.Reexport)
+	if err != nil {
+		var invalid *services.VAT3ValidationError
+		var exported *services.VAT3ExportedError
+		switch {
+		case errors.As(err, &invalid):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error(), "issues": invalid.Issues})
+		case errors.As(err, &exported):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error(), "previous": exported.Previous})
+		case errors.Is(err, services.ErrVAT3PeriodInvalid):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set("Content-Type", "application/zip")
+	c.Set("Content-Disposition", "attachment; filename="+export.Filename)
+	c.Set("X-VAT-Filing-ID", export.Filing.ID)
+	c.Set("X-VAT-Filing-Version", fmt.Sprint(export.Filing.Version))
+	return c.Send(export.Data)
+}
+
+// ListVATFilings lists the VAT3 returns exported so far
+func (h *ReportHandler) ListVATFilings(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	filings, err := h.reportService.ListVATFilings(tenantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"filings": filings})
+}
+
 func (h *ReportHandler) Export(c *fiber.Ctx) error {
 	tenantID := middleware.GetTenantID(c)
 	if tenantID == "" {
@@ -130,16 +215,20 @@ func (h *ReportHandler) Export(c *fiber.Ctx) error {
 	}
 
 	format := c.Query("format", "csv")
-	reportType := c.Query("type", "overview")
+	reportType := services.ReportExportType(c.Query("type", "overview"))
 	period := c.Query("period", "30")
 
 	data, err := h.reportService.ExportReport(tenantID, format, reportType, period)
 	if err != nil {
+		if errors.Is(err, services.ErrPDFExportUnavailable) {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	c.Set("Content-Type", "text/csv")
-	c.Set("Content-Disposition", "attachment; filename=report.csv")
+	contentType, filename := services.ReportExportFile(format, reportType)
+	c.Set("Content-Type", contentType)
+	c.Set("Content-Disposition", "attachment; filename="+filename)
 
 	return c.Send(data)
 }
@@ -235,6 +235,7 @@ func (h *SettingsHandler) SaveSettingsMpesa(c *fiber.Ctx) error {
 		ConsumerSecret string `json:"consumer_secret"`
 		Shortcode      string `json:"shortcode"`
 		Passkey        string `json:"passkey"`
+		Environment    string `json:"environment"`
 		Enabled        bool   `json:"enabled"`
 	}
 
@@ -245,12 +246,16 @@ func (h *SettingsHandler) SaveSettingsMpesa(c *fiber.Ctx) error {
 	if req.ConsumerKey == "" || req.Shortcode == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Consumer Key and Shortcode are required"})
 	}
+	if req.Environment != "" && req.Environment != "sandbox" && req.Environment != "production" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "environment must be sandbox or production"})
+	}
 
 	settings := &services.MpesaSettings{
 		ConsumerKey:    req.ConsumerKey,
 		ConsumerSecret: req.ConsumerSecret,
 		Shortcode:      req.Shortcode,
 		Passkey:        req.Passkey,
+		Environment:    req.Environment,
 		Enabled:        req.Enabled,
 	}
 
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TaxRateHandler serves the tenant's tax rate catalogue
+type TaxRateHandler struct {
+	taxRateService *services.TaxRateService
+}
+
+func NewTaxRateHandler(taxRateSvc *services.TaxRateService) *TaxRateHandler {
+	return &TaxRateHandler{taxRateService: taxRateSvc}
+}
+
+func taxRateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrTaxRateNotFound), errors.Is(err, services.ErrTaxRateNotInForce):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrTaxRateInvalid), errors.Is(err, services.ErrTaxRateCategory):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrTaxRateOverlap):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusInternalServerError
+}
+
+// ListRates lists the catalogue (?kind=vat|excise|levy)
+func (h *TaxRateHandler) ListRates(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	rates, err := h.taxRateService.ListRates(tenantID, c.Query("kind"))
+	if err != nil {
+		return c.Status(taxRateErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"tax_rates": rates})
+}
+
+// GetRateOn returns the rate for a code in force on a date (?date=YYYY-MM-DD, default today)
+func (h *TaxRateHandler) GetRateOn(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	date, err := ledgerDate(c.Query("date"), time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+	}
+	rate, err := h.taxRateService.RateOn(tenantID, c.Params("code"), date)
+	if err != nil {
+		return c.Status(taxRateErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(rate)
+}
+
+// CreateRate adds a rate, such as an excise duty or a new VAT rate from the day it applies
+func (h *TaxRateHandler) CreateRate(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Code        string  `json:"code"`
+		Name        string  `json:"name"`
+		Kind        string  `json:"kind"`
+		TaxType     string  `json:"tax_type"`
+		Rate        float64 `json:"rate"`
+		KRACategory string  `json:"kra_category"`
+		ValidFrom   string  `json:"valid_from"` // YYYY-MM-DD, default today
+		ValidTo     string  `json:"valid_to"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	validFrom, err := ledgerDate(req.ValidFrom, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "valid_from must be YYYY-MM-DD"})
+	}
+
+	rate := &models.TaxRate{
+		Code:        req.Code,
+		Name:        req.Name,
+		Kind:        req.Kind,
+		TaxType:     models.TaxType(req.TaxType),
+		Rate:        req.Rate,
+		KRACategory: req.KRACategory,
+		ValidFrom:   validFrom,
+	}
+	if req.ValidTo != "" {
+		validTo, err := time.Parse("2006-01-02", req.ValidTo)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "valid_to must be YYYY-MM-DD"})
+		}
+		rate.ValidTo = &validTo
+	}
+
+	if err := h.taxRateService.CreateRate(tenantID, rate); err != nil {
+		return c.Status(taxRateErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(rate)
+}
+
+// UpdateRate renames, recategorises, end-dates or deactivates a rate
+func (h *TaxRateHandler) UpdateRate(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		Name        *string `json:"name"`
+		KRACategory *string `json:"kra_category"`
+		ValidTo     *string `json:"valid_to"` // YYYY-MM-DD; empty string makes the rate open-ended
+		IsActive    *bool   `json:"is_active"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	update := services.TaxRateUpdate{Name: req.Name, KRACategory: req.KRACategory, IsActive: req.IsActive}
+	if req.ValidTo != nil {
+		if *req.ValidTo == "" {
+			update.ClearValidTo = true
+		} else {
+			validTo, err := time.Parse("2006-01-02", *req.ValidTo)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "valid_to must be YYYY-MM-DD"})
+			}
+			update.ValidTo = &validTo
+		}
+	}
+
+	rate, err := h.taxRateService.UpdateRate(tenantID, c.Params("id"), update)
+	if err != nil {
+		return c.Status(taxRateErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(rate)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type WebhookEndpointHandler struct {
+	webhookService *services.OutgoingWebhookService
+}
+
+func NewWebhookEndpointHandler(webhookSvc *services.OutgoingWebhookService) *WebhookEndpointHandler {
+	return &WebhookEndpointHandler{webhookService: webhookSvc}
+}
+
+func (h *WebhookEndpointHandler) ListEndpoints(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	endpoints, err := h.webhookService.ListEndpoints(tenantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"endpoints": endpoints, "events": services.WebhookEvents()})
+}
+
+// CreateEndpoint registers an endpoint. The signing secret is only ever returned here and on rotation.
+func (h *WebhookEndpointHandler) CreateEndpoint(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.WebhookEndpointRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	endpoint, secret, err := h.webhookService.CreateEndpoint(tenantID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"endpoint": endpoint,
+		"secret":   secret,
+		"message":  "Store this secret now - it will not be shown again",
+	})
+}
+
+func (h *WebhookEndpointHandler) UpdateEndpoint(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req services.WebhookEndpointRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	endpoint, err := h.webhookService.UpdateEndpoint(tenantID, c.Params("id"), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrWebhookEndpointNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"endpoint": endpoint})
+}
+
+func (h *WebhookEndpointHandler) DeleteEndpoint(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	if err := h.webhookService.DeleteEndpoint(tenantID, c.Params("id")); err != nil {
+		if errors.Is(err, services.ErrWebhookEndpointNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"success": true})
+}
+
+func (h *WebhookEndpointHandler) RotateSecret(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	secret, err := h.webhookService.RotateSecret(tenantID, c.Params("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrWebhookEndpointNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":  secret,
+		"message": "Store this secret now - it will not be shown again",
+	})
+}
+
+// ListDeliveries returns the delivery log, for one endpoint when :id is set
+func (h *WebhookEndpointHandler) ListDeliveries(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	deliveries, total, err := h.webhookService.ListDeliveries(tenantID, c.Params("id"), limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"deliveries": deliveries,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+func (h *WebhookEndpointHandler) ReplayDelivery(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	delivery, err := h.webhookService.ReplayDelivery(tenantID, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"delivery": delivery})
+}
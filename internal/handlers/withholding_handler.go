@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WithholdingHandler serves withholding certificates tracked per client
+type WithholdingHandler struct {
+	withholdingService *services.WithholdingService
+}
+
+func NewWithholdingHandler(withholdingSvc *services.WithholdingService) *WithholdingHandler {
+	return &WithholdingHandler{withholdingService: withholdingSvc}
+}
+
+func withholdingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrWithholdingNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrWithholdingCertificate):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrWithholdingDuplicate):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusInternalServerError
+}
+
+// ListCertificates lists deductions (?client_id=&type=&status=pending|received&page=&limit=)
+func (h *WithholdingHandler) ListCertificates(c *fiber.Ctx) error {
+	tenantID := middleware.GetTenantID(c)
+	if tenantID == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	filter := services.WithholdingFilter{
+		ClientID: c.Query("client_id"),
+		Type:     c.Query("type"),
+		Status:   c.Query("status"),
+		Page:     c.QueryInt("page", 1),
+		Limit:    c.QueryInt("limit", 50),
+	}
+	certificates, total, err := h.withholdingService.ListCertificates(tenantID, filter)
+	if err != nil {
+		return c.Status(withholdingErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"certificates": certificates, "total": total})
+}
+
+// ReceiveCertificate records the certificate number the client issued for a deduction
+func (h *WithholdingHandler) ReceiveCertificate(c *fiber.Ctx) error {
+	tenantID, userID, err := middleware.GetTenantAndUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "tenant required"})
+	}
+
+	var req struct {
+		CertificateNumber string `json:"certificate_number"`
+		CertificateDate   string `json:"certificate_date"` // YYYY-MM-DD, default today
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	date, err := ledgerDate(req.CertificateDate, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "certificate_date must be YYYY-MM-DD"})
+	}
+
+	certificate, err := h.withholdingService.ReceiveCertificate(tenantID, userID, c.Params("id"), req.CertificateNumber, date)
+	if err != nil {
+		return c.Status(withholdingErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(certificate)
+}
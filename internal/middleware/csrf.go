@@ -143,6 +143,11 @@ func csrfHandler(config CSRFConfig) fiber.Handler {
 			return c.Next()
 		}
 
+		// Skip API key requests from outside a browser session
+		if apiKeyOnlyRequest(c, config.CookieName) {
+			return c.Next()
+		}
+
 		// ALL other state-changing requests require CSRF validation
 		cookieToken := c.Cookies(config.CookieName)
 
@@ -82,6 +82,10 @@ func csrfHandlerWithRedis(config CSRFConfig, store *RedisCSRFStore) fiber.Handle
 			return c.Next()
 		}
 
+		if apiKeyOnlyRequest(c, config.CookieName) {
+			return c.Next()
+		}
+
 		cookieToken := c.Cookies(config.CookieName)
 		if cookieToken == "" {
 			token := generateCSRFToken(c)
@@ -127,3 +127,23 @@ func CanViewReports() fiber.Handler {
 func CanManageSettings() fiber.Handler {
 	return HasAnyRole(RoleAdmin, RoleOwner, RoleManager)
 }
+
+// CanApprovePayouts gates sending money out over M-Pesa B2C
+func CanApprovePayouts() fiber.Handler {
+	return HasAnyRole(RoleAdmin, RoleOwner, RoleFinance)
+}
+
+// CanReconcileBank gates importing bank statements and matching them to the books
+func CanReconcileBank() fiber.Handler {
+	return HasAnyRole(RoleAdmin, RoleOwner, RoleFinance)
+}
+
+// CanPostJournals gates manual journals and changes to the chart of accounts
+func CanPostJournals() fiber.Handler {
+	return HasAnyRole(RoleAdmin, RoleOwner, RoleFinance)
+}
+
+// CanClosePeriods gates closing, locking and reopening accounting periods
+func CanClosePeriods() fiber.Handler {
+	return HasAnyRole(RoleAdmin, RoleOwner)
+}
@@ -20,6 +20,11 @@ func TenantMiddleware(authService *services.AuthService, db *database.DB) fiber.
 		var tenantID, userID, role string
 		var authErr error
 
+		// Machine-to-machine access with a tenant API key
+		if apiKey := extractAPIKey(c); apiKey != "" {
+			return authenticateAPIKey(c, authService, apiKey)
+		}
+
 		// Extract from JWT Bearer token
 		authHeader := c.Get("Authorization")
 		if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
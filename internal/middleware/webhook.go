@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"encoding/json"
 	"strings"
 	"time"
 
@@ -56,15 +57,42 @@ func (m *WebhookVerifierMiddleware) MpesaVerification() fiber.Handler {
 			})
 		}
 
+		var callback services.STKCallback
+		if err := json.Unmarshal(body, &callback); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid callback body",
+				"code":  "INVALID_BODY",
+			})
+		}
+
 		// Store verified callback data in context
 		c.Locals("webhook_verified", true)
 		c.Locals("webhook_provider", "mpesa")
 		c.Locals("webhook_timestamp", result.Timestamp)
+		c.Locals("mpesa_callback", &callback)
 
 		return c.Next()
 	}
 }
 
+// MpesaCallbackURLVerification guards the C2B and result callback routes, whose
+// path carries :tenantID and the tenant's :token
+func (m *WebhookVerifierMiddleware) MpesaCallbackURLVerification() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result := m.verifier.VerifyMpesaCallbackURL(c.Params("tenantID"), c.Params("token"), c.IP())
+		if !result.Valid {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "callback verification failed",
+				"code":  "WEBHOOK_VERIFICATION_FAILED",
+			})
+		}
+
+		c.Locals("webhook_verified", true)
+		c.Locals("webhook_provider", "mpesa")
+		return c.Next()
+	}
+}
+
 // IntasendVerification verifies Intasend webhook signatures
 func (m *WebhookVerifierMiddleware) IntasendVerification() fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// Accounting period statuses
+const (
+	PeriodOpen   = "open"
+	PeriodClosed = "closed" // no changes dated inside it; an admin can reopen it with a reason
+	PeriodLocked = "locked" // closed for good, e.g. once the VAT return is filed
+)
+
+// FiscalYearConfig sets the month a tenant's financial year starts in
+type FiscalYearConfig struct {
+	ID         string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID   string    `json:"tenant_id" gorm:"type:uuid;uniqueIndex;not null"`
+	StartMonth int       `json:"start_month" gorm:"default:1"` // 1 = January
+	UpdatedBy  string    `json:"updated_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (FiscalYearConfig) TableName() string {
+	return "fiscal_year_configs"
+}
+
+// AccountingPeriod is one calendar month of a tenant's books. Months without a
+// row are open; a row is written the first time the month is closed.
+type AccountingPeriod struct {
+	ID           string     `json:"id,omitempty" gorm:"type:uuid;primaryKey"`
+	TenantID     string     `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_accounting_periods_tenant_period;not null"`
+	Period       string     `json:"period" gorm:"uniqueIndex:idx_accounting_periods_tenant_period;not null"` // 2026-03
+	Status       string     `json:"status" gorm:"default:'open'"`
+	ClosedBy     string     `json:"closed_by,omitempty"`
+	ClosedAt     *time.Time `json:"closed_at,omitempty"`
+	LockedBy     string     `json:"locked_by,omitempty"`
+	LockedAt     *time.Time `json:"locked_at,omitempty"`
+	ReopenedBy   string     `json:"reopened_by,omitempty"`
+	ReopenedAt   *time.Time `json:"reopened_at,omitempty"`
+	ReopenReason string     `json:"reopen_reason,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// Filled in from the tenant's fiscal year
+	FiscalYear   string    `json:"fiscal_year" gorm:"-"` // FY2026 or FY2025/26
+	PeriodNumber int       `json:"period_number" gorm:"-"`
+	StartDate    time.Time `json:"start_date" gorm:"-"`
+	EndDate      time.Time `json:"end_date" gorm:"-"` // last day of the month
+}
+
+func (AccountingPeriod) TableName() string {
+	return "accounting_periods"
+}
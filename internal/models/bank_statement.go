@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+)
+
+// Bank statement formats
+const (
+	BankStatementCSV   = "csv"
+	BankStatementOFX   = "ofx"
+	BankStatementMT940 = "mt940"
+)
+
+// Bank statement line statuses
+const (
+	BankLineUnmatched = "unmatched"
+	BankLineSuggested = "suggested" // more than one possible match, waiting for review
+	BankLineMatched   = "matched"
+	BankLineIgnored   = "ignored" // bank charges, transfers between own accounts and the like
+)
+
+// Book entries a statement line can be matched to
+const (
+	BankMatchPayment     = "payment"
+	BankMatchUnallocated = "unallocated_payment"
+	BankMatchExpense     = "expense"
+)
+
+// BankStatement is an imported bank statement file
+type BankStatement struct {
+	ID             string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID       string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	UploadedBy     string     `json:"uploaded_by" gorm:"type:uuid"`
+	FileName       string     `json:"file_name"`
+	Format         string     `json:"format"` // csv, ofx, mt940
+	BankName       string     `json:"bank_name"`
+	AccountNumber  string     `json:"account_number" gorm:"index"`
+	Currency       string     `json:"currency" gorm:"default:'KES'"`
+	PeriodStart    *time.Time `json:"period_start"`
+	PeriodEnd      *time.Time `json:"period_end"`
+	OpeningBalance *Money     `json:"opening_balance"`
+	ClosingBalance *Money     `json:"closing_balance"`
+	LineCount      int        `json:"line_count"`
+	DuplicateCount int        `json:"duplicate_count"` // lines already imported from an earlier statement
+	MatchedCount   int        `json:"matched_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	Lines []BankStatementLine `json:"lines,omitempty" gorm:"foreignKey:StatementID"`
+}
+
+// BankStatementLine is one transaction on a bank statement. Amount is positive
+// for money in and negative for money out.
+type BankStatementLine struct {
+	ID            string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID      string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	StatementID   string     `json:"statement_id" gorm:"type:uuid;index;not null"`
+	LineNumber    int        `json:"line_number"` // position in the statement file
+	AccountNumber string     `json:"account_number" gorm:"index"`
+	Date          time.Time  `json:"date" gorm:"index"`
+	ValueDate     *time.Time `json:"value_date"`
+	Amount        Money      `json:"amount" gorm:"not null"`
+	Description   string     `json:"description"`
+	Reference     string     `json:"reference"`
+	BankReference string     `json:"bank_reference"` // FITID or the bank's own transaction reference
+	Balance       *Money     `json:"balance"`
+	Fingerprint   string     `json:"-" gorm:"index"` // spots the same line in overlapping statements
+	Status        string     `json:"status" gorm:"index;default:'unmatched'"`
+	MatchType     string     `json:"match_type,omitempty"` // payment, unallocated_payment, expense
+	MatchID       string     `json:"match_id,omitempty" gorm:"index"`
+	MatchReason   string     `json:"match_reason,omitempty"`
+	Candidates    string     `json:"-" gorm:"type:text"`   // JSON list of possible matches for review
+	MatchedBy     string     `json:"matched_by,omitempty"` // user ID, empty when matched automatically
+	MatchedAt     *time.Time `json:"matched_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Client portal login channels
+const (
+	PortalChannelEmail = "email"
+	PortalChannelSMS   = "sms"
+)
+
+// ClientPortalSession is a client's self-service portal login. It starts as a
+// pending challenge (magic link or SMS code) and becomes a session once verified.
+// Only hashes of the link token, code and session token are stored.
+type ClientPortalSession struct {
+	ID                 string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID           string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	ClientID           string     `json:"client_id" gorm:"type:uuid;index;not null"`
+	Channel            string     `json:"channel" gorm:"not null"` // email, sms
+	ChallengeHash      string     `json:"-" gorm:"index"`
+	ChallengeExpiresAt time.Time  `json:"challenge_expires_at"`
+	Attempts           int        `json:"-" gorm:"default:0"`
+	TokenHash          string     `json:"-" gorm:"index"`
+	VerifiedAt         *time.Time `json:"verified_at"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+	RevokedAt          *time.Time `json:"revoked_at"`
+	LastSeenAt         *time.Time `json:"last_seen_at"`
+	IPAddress          string     `json:"ip_address"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// IsActive reports whether the session has been verified and is still usable
+func (s *ClientPortalSession) IsActive(now time.Time) bool {
+	return s.VerifiedAt != nil && s.RevokedAt == nil && s.ExpiresAt != nil && now.Before(*s.ExpiresAt)
+}
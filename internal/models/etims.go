@@ -0,0 +1,134 @@
+package models
+
+import (
+	"time"
+)
+
+// eTIMS control unit types
+const (
+	ETIMSModeOSCU = "oscu" // online sales control unit, hosted by KRA
+	ETIMSModeVSCU = "vscu" // virtual sales control unit, run by the taxpayer
+)
+
+// eTIMS code list classes synced from KRA
+const (
+	ETIMSCodeTaxType       = "04" // taxation type (A-E)
+	ETIMSCodeCountry       = "05" // country of origin
+	ETIMSCodeQuantityUnit  = "10" // unit of quantity
+	ETIMSCodeStockIOType   = "12" // stock in/out type
+	ETIMSCodePackagingUnit = "17" // packaging unit
+	ETIMSCodeItemClass     = "item_class"
+)
+
+// eTIMS item types
+const (
+	ETIMSItemRawMaterial = "1"
+	ETIMSItemFinished    = "2"
+	ETIMSItemService     = "3"
+)
+
+// eTIMS purchase states
+const (
+	ETIMSPurchasePending  = "pending"  // imported, not yet confirmed to KRA
+	ETIMSPurchaseAccepted = "accepted" // confirmed as received
+	ETIMSPurchaseRejected = "rejected" // disputed with the supplier
+)
+
+// eTIMS stock in/out types (sarTyCd). 01-06 bring stock in, 11-16 take it out.
+const (
+	ETIMSStockImport        = "01"
+	ETIMSStockPurchase      = "02"
+	ETIMSStockReturnIn      = "03"
+	ETIMSStockAdjustmentIn  = "06"
+	ETIMSStockSale          = "11"
+	ETIMSStockReturnOut     = "12"
+	ETIMSStockDiscarded     = "15"
+	ETIMSStockAdjustmentOut = "16"
+)
+
+// eTIMS stock movement reporting states
+const (
+	ETIMSStockPending  = "pending"
+	ETIMSStockReported = "reported"
+	ETIMSStockFailed   = "failed"
+)
+
+// ETIMSDevice is a tenant's initialized eTIMS control unit
+type ETIMSDevice struct {
+	ID               string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID         string     `json:"tenant_id" gorm:"type:uuid;uniqueIndex;not null"`
+	Mode             string     `json:"mode" gorm:"not null;default:'oscu'"` // oscu, vscu
+	TIN              string     `json:"tin" gorm:"column:tin;not null"`      // taxpayer KRA PIN
+	BranchID         string     `json:"branch_id" gorm:"not null"`           // bhfId, "00" for head office
+	DeviceSerial     string     `json:"device_serial" gorm:"not null"`       // dvcSrlNo
+	SDCID            string     `json:"sdc_id" gorm:"column:sdc_id"`         // control unit ID assigned by KRA
+	MRCNo            string     `json:"mrc_no" gorm:"column:mrc_no"`         // machine registration code
+	CommunicationKey string     `json:"-"`                                   // cmcKey, encrypted at rest
+	InitializedAt    *time.Time `json:"initialized_at"`
+	CodesSyncedAt    *time.Time `json:"codes_synced_at"`
+	PurchasesAt      *time.Time `json:"purchases_synced_at" gorm:"column:purchases_synced_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// ETIMSCode is one entry in a code list synced from eTIMS: units, tax types,
+// countries and item classification codes
+type ETIMSCode struct {
+	ID          string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID    string    `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_etims_codes_class_code;not null"`
+	Class       string    `json:"class" gorm:"uniqueIndex:idx_etims_codes_class_code;not null"`
+	Code        string    `json:"code" gorm:"uniqueIndex:idx_etims_codes_class_code;not null"`
+	Name        string    `json:"name"`
+	TaxCategory string    `json:"tax_category,omitempty"` // item classes: default eTIMS tax type
+	IsActive    bool      `json:"is_active"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ETIMSPurchase is a sales invoice a supplier issued to the tenant's PIN,
+// imported from eTIMS so it can be confirmed
+type ETIMSPurchase struct {
+	ID             string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID       string     `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_etims_purchases_supplier_invoice;not null"`
+	SupplierPIN    string     `json:"supplier_pin" gorm:"uniqueIndex:idx_etims_purchases_supplier_invoice;not null"`
+	SupplierName   string     `json:"supplier_name"`
+	SupplierBranch string     `json:"supplier_branch"`
+	InvoiceNumber  string     `json:"invoice_number" gorm:"uniqueIndex:idx_etims_purchases_supplier_invoice;not null"` // spplrInvcNo
+	ICN            string     `json:"icn"`                                                                             // receipt signature / control number
+	InvoiceDate    time.Time  `json:"invoice_date"`
+	TaxableAmount  Money      `json:"taxable_amount"`
+	TaxAmount      Money      `json:"tax_amount"`
+	TotalAmount    Money      `json:"total_amount"`
+	ItemsJSON      string     `json:"items_json" gorm:"type:text"` // line items as reported by eTIMS
+	Status         string     `json:"status" gorm:"default:'pending';index"`
+	ConfirmedAt    *time.Time `json:"confirmed_at"`
+	ConfirmedBy    string     `json:"confirmed_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ETIMSStockMovement is stock moving in or out of a registered item, reported
+// to eTIMS with the quantity left afterwards
+type ETIMSStockMovement struct {
+	ID         string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID   string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	ItemID     string     `json:"item_id" gorm:"type:uuid;index;not null"`
+	ItemCode   string     `json:"item_code" gorm:"not null"`
+	SarNo      int64      `json:"sar_no"`               // stored-and-released number, sequential per tenant
+	Type       string     `json:"type" gorm:"not null"` // sarTyCd
+	Quantity   float64    `json:"quantity"`             // always positive; the type gives the direction
+	UnitPrice  Money      `json:"unit_price"`
+	Remaining  float64    `json:"remaining"` // stock on hand after this movement
+	Reference  string     `json:"reference"`
+	OccurredAt time.Time  `json:"occurred_at"`
+	Status     string     `json:"status" gorm:"default:'pending';index"`
+	Error      string     `json:"error,omitempty"`
+	ReportedAt *time.Time `json:"reported_at"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// StockIn reports whether the movement adds to stock on hand
+func (m *ETIMSStockMovement) StockIn() bool {
+	return m.Type < "10"
+}
@@ -19,6 +19,14 @@ func (ExpenseCategory) TableName() string {
 	return "expense_categories"
 }
 
+// Expense eTIMS verification states. Only input VAT on verified supplier
+// invoices can be claimed.
+const (
+	ExpenseETIMSUnverified = "unverified" // no supplier PIN and ICN yet, or eTIMS couldn't be reached
+	ExpenseETIMSVerified   = "verified"   // the supplier's invoice is registered with eTIMS
+	ExpenseETIMSRejected   = "rejected"   // eTIMS has no such invoice, or it doesn't match the expense
+)
+
 type Expense struct {
 	ID              string     `json:"id" gorm:"type:uuid;primaryKey"`
 	TenantID        string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
@@ -34,6 +42,11 @@ type Expense struct {
 	Vendor          string     `json:"vendor"`
 	TaxAmount Money      `json:"tax_amount"`
 	TaxRate   float64    `json:"tax_rate"`
+	SupplierPIN     string     `json:"supplier_pin"`
+	SupplierICN     string     `json:"supplier_icn"` // invoice control number on the supplier's eTIMS invoice
+	ETIMSStatus     string     `json:"etims_status" gorm:"default:'unverified';index"`
+	ETIMSNote       string     `json:"etims_note,omitempty"` // why the invoice isn't verified
+	ETIMSVerifiedAt *time.Time `json:"etims_verified_at"`
 	IsRecurring     bool       `json:"is_recurring"`
 	RecurringPeriod string     `json:"recurring_period"` // weekly, monthly, yearly
 	Notes           string     `json:"notes"`
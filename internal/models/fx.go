@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// FXRevaluation is a month-end run restating open foreign-currency invoices at
+// the closing rate. The unrealized gain or loss is posted on the last day of
+// the month and reversed on the first day of the next.
+type FXRevaluation struct {
+	ID         string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID   string    `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_fx_revaluations_tenant_period;not null"`
+	Period     string    `json:"period" gorm:"uniqueIndex:idx_fx_revaluations_tenant_period;not null"` // 2026-03
+	AsOf       time.Time `json:"as_of"`
+	Gain       Money     `json:"gain"` // KES, negative for a loss
+	EntryID    string    `json:"entry_id,omitempty" gorm:"type:uuid"`
+	ReversalID string    `json:"reversal_id,omitempty" gorm:"type:uuid"`
+	RunBy      string    `json:"run_by"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	Lines []FXRevaluationLine `json:"lines,omitempty" gorm:"foreignKey:RevaluationID"`
+}
+
+func (FXRevaluation) TableName() string {
+	return "fx_revaluations"
+}
+
+// FXRevaluationLine is one invoice's open balance in a revaluation run
+type FXRevaluationLine struct {
+	ID            string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID      string    `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	RevaluationID string    `json:"revaluation_id" gorm:"type:uuid;index;not null"`
+	InvoiceID     string    `json:"invoice_id" gorm:"type:uuid;index"`
+	InvoiceNumber string    `json:"invoice_number"`
+	Currency      string    `json:"currency"`
+	Balance       Money     `json:"balance"` // in Currency
+	BookRate      float64   `json:"book_rate"`
+	ClosingRate   float64   `json:"closing_rate"`
+	BookValue     Money     `json:"book_value"`    // KES
+	ClosingValue  Money     `json:"closing_value"` // KES
+	Gain          Money     `json:"gain"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (FXRevaluationLine) TableName() string {
+	return "fx_revaluation_lines"
+}
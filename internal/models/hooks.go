@@ -9,12 +9,15 @@ import (
 	"fmt"
 	"io"
 	"sync"
-	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrInvoiceNumberMissing is returned when an invoice is saved without a number
+// issued from its tenant's numbering scheme
+var ErrInvoiceNumberMissing = errors.New("invoice number is required")
+
 var (
 	encryptMu      sync.Mutex
 	encryptionKey  string
@@ -257,8 +260,10 @@ func (i *Invoice) BeforeCreate(tx *gorm.DB) error {
 	if i.ID == "" {
 		i.ID = uuid.New().String()
 	}
+	// Numbers come from the tenant's numbering scheme; making one up here would
+	// leave a gap in the sequence and a number outside its pattern
 	if i.InvoiceNumber == "" {
-		i.InvoiceNumber = generateInvoiceNumber()
+		return ErrInvoiceNumberMissing
 	}
 	if i.MagicToken == "" {
 		i.MagicToken = uuid.New().String()
@@ -318,6 +323,27 @@ func (p *Payment) AfterFind(tx *gorm.DB) error {
 	return nil
 }
 
-func generateInvoiceNumber() string {
-	return "INV-" + time.Now().Format("20060102") + "-" + uuid.New().String()[:4]
+func (p *Payout) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == "" {
+		p.ID = uuid.New().String()
+	}
+	if p.PhoneNumber != "" {
+		enc, err := encrypt(p.PhoneNumber)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt phone: %w", err)
+		}
+		p.PhoneNumber = enc
+	}
+	return nil
+}
+
+func (p *Payout) AfterFind(tx *gorm.DB) error {
+	if p.PhoneNumber != "" {
+		dec, err := decrypt(p.PhoneNumber)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt phone: %w", err)
+		}
+		p.PhoneNumber = dec
+	}
+	return nil
 }
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// ImportJob is an uploaded spreadsheet being mapped, validated and committed
+type ImportJob struct {
+	ID           string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID     string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	UserID       string     `json:"user_id" gorm:"type:uuid;index"`
+	EntityType   string     `json:"entity_type" gorm:"index"` // clients, items, invoices
+	FileName     string     `json:"file_name"`
+	Format       string     `json:"format"` // csv, xlsx
+	FileData     []byte     `json:"-"`
+	Headers      string     `json:"-" gorm:"type:text"` // JSON array of column headers
+	Mapping      string     `json:"-" gorm:"type:text"` // JSON object field -> column header
+	Status       string     `json:"status" gorm:"index"`
+	TotalRows    int        `json:"total_rows"`
+	ValidRows    int        `json:"valid_rows"`
+	ErrorRows    int        `json:"error_rows"`
+	CreatedCount int        `json:"created_count"`
+	SkippedCount int        `json:"skipped_count"`      // duplicates of existing records
+	Errors       string     `json:"-" gorm:"type:text"` // JSON array of row errors from the last run
+	CommittedAt  *time.Time `json:"committed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Import job status constants
+const (
+	ImportStatusUploaded  = "uploaded"
+	ImportStatusValidated = "validated"
+	ImportStatusCommitted = "committed"
+	ImportStatusFailed    = "failed"
+)
+
+// Import entity types
+const (
+	ImportEntityClients  = "clients"
+	ImportEntityItems    = "items"
+	ImportEntityInvoices = "invoices"
+)
@@ -19,6 +19,16 @@ type ItemLibrary struct {
 	Notes     string    `json:"notes"`                       // Optional notes
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// eTIMS item registration
+	ItemCode          string     `json:"item_code,omitempty" gorm:"index"` // KRA item code, used as InvoiceItem.ItemCode
+	ItemClassCode     string     `json:"item_class_code,omitempty"`        // KRA item classification code
+	ItemType          string     `json:"item_type,omitempty"`              // 1 raw material, 2 finished product, 3 service
+	OriginCountry     string     `json:"origin_country,omitempty"`
+	PackagingUnit     string     `json:"packaging_unit,omitempty"`
+	QuantityUnit      string     `json:"quantity_unit,omitempty"`
+	TaxCategory       string     `json:"tax_category,omitempty"` // eTIMS tax type, A-E
+	ETIMSRegisteredAt *time.Time `json:"etims_registered_at,omitempty" gorm:"column:etims_registered_at"`
 }
 
 // BeforeCreate hook to generate UUID
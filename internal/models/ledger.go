@@ -0,0 +1,134 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrJournalImmutable is returned when a posted journal entry is changed or deleted;
+// corrections are made with a reversing entry
+var ErrJournalImmutable = errors.New("posted journal entries cannot be changed; post a reversal instead")
+
+// Ledger account types
+const (
+	LedgerAsset     = "asset"
+	LedgerLiability = "liability"
+	LedgerEquity    = "equity"
+	LedgerIncome    = "income"
+	LedgerExpense   = "expense"
+)
+
+// System keys mark the accounts automatic postings go to. Each key is held by
+// exactly one account per tenant and can be moved to another account of the same type.
+const (
+	LedgerKeyCash             = "cash"
+	LedgerKeyBank             = "bank"
+	LedgerKeyMpesa            = "mpesa"
+	LedgerKeyCardClearing     = "card_clearing"
+	LedgerKeyReceivable       = "accounts_receivable"
+	LedgerKeyInputVAT         = "input_vat"
+	LedgerKeyWithholdingVAT   = "withholding_vat"
+	LedgerKeyWithholdingTax   = "withholding_tax"
+	LedgerKeyPayable          = "accounts_payable"
+	LedgerKeyOutputVAT        = "output_vat"
+	LedgerKeyCustomerCredit   = "customer_credit"
+	LedgerKeyOwnerEquity      = "owner_equity"
+	LedgerKeyRetainedEarnings = "retained_earnings"
+	LedgerKeySales            = "sales"
+	LedgerKeyLateFeeIncome    = "late_fee_income"
+	LedgerKeyRealizedFX       = "realized_fx"
+	LedgerKeyUnrealizedFX     = "unrealized_fx"
+	LedgerKeyExpenses         = "operating_expenses"
+)
+
+// Journal entry sources
+const (
+	JournalSourceManual         = "manual"
+	JournalSourceReversal       = "reversal"
+	JournalSourceInvoice        = "invoice" // invoices, credit notes and debit notes
+	JournalSourceInvoiceVoid    = "invoice_void"
+	JournalSourcePayment        = "payment"
+	JournalSourceRefund         = "refund"
+	JournalSourceLateFee        = "late_fee"
+	JournalSourceLateFeeWaiver  = "late_fee_waiver"
+	JournalSourceExpense        = "expense"
+	JournalSourceExpensePayment = "expense_payment"
+	JournalSourcePayout         = "payout" // client credit returned over M-Pesa
+	JournalSourceFXRevaluation  = "fx_revaluation"
+	JournalSourceFXReversal     = "fx_revaluation_reversal" // undoes a revaluation the next day
+)
+
+// LedgerAccount is an account in a tenant's chart of accounts
+type LedgerAccount struct {
+	ID          string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID    string    `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_ledger_accounts_tenant_code;not null"`
+	Code        string    `json:"code" gorm:"uniqueIndex:idx_ledger_accounts_tenant_code;not null"`
+	Name        string    `json:"name" gorm:"not null"`
+	Type        string    `json:"type" gorm:"not null"` // asset, liability, equity, income, expense
+	SystemKey   string    `json:"system_key,omitempty" gorm:"index"`
+	Description string    `json:"description"`
+	IsActive    bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// DebitNormal reports whether the account's balance grows with debits
+func (a *LedgerAccount) DebitNormal() bool {
+	return a.Type == LedgerAsset || a.Type == LedgerExpense
+}
+
+// JournalEntry is a balanced set of journal lines. It is never updated or
+// deleted once posted; SourceType and SourceID record what it was posted for.
+type JournalEntry struct {
+	ID         string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID   string    `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_journal_entries_source;not null"`
+	Number     string    `json:"number" gorm:"index"` // JE-000001
+	Date       time.Time `json:"date" gorm:"index"`
+	Memo       string    `json:"memo"`
+	SourceType string    `json:"source_type" gorm:"uniqueIndex:idx_journal_entries_source;not null"`
+	SourceID   string    `json:"source_id" gorm:"type:uuid;uniqueIndex:idx_journal_entries_source;not null"`
+	ReversesID string    `json:"reverses_id,omitempty" gorm:"type:uuid;index"` // the entry this one reverses
+	PostedBy   string    `json:"posted_by,omitempty"`                          // user ID, empty for automatic postings
+	CreatedAt  time.Time `json:"created_at"`
+
+	Lines []JournalLine `json:"lines,omitempty" gorm:"foreignKey:EntryID"`
+}
+
+// BeforeUpdate - Posted entries are immutable
+func (e *JournalEntry) BeforeUpdate(tx *gorm.DB) error {
+	return ErrJournalImmutable
+}
+
+// BeforeDelete - Posted entries are immutable
+func (e *JournalEntry) BeforeDelete(tx *gorm.DB) error {
+	return ErrJournalImmutable
+}
+
+// JournalLine debits or credits one account in KES. Lines posted from
+// foreign-currency documents keep the document amount in FxAmount.
+type JournalLine struct {
+	ID          string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID    string    `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	EntryID     string    `json:"entry_id" gorm:"type:uuid;index;not null"`
+	AccountID   string    `json:"account_id" gorm:"type:uuid;index;not null"`
+	Debit       Money     `json:"debit" gorm:"default:0"`
+	Credit      Money     `json:"credit" gorm:"default:0"`
+	Description string    `json:"description"`
+	Currency    string    `json:"currency,omitempty"`
+	FxAmount    Money     `json:"fx_amount,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	Account *LedgerAccount `json:"account,omitempty" gorm:"foreignKey:AccountID"`
+}
+
+// BeforeUpdate - Posted lines are immutable
+func (l *JournalLine) BeforeUpdate(tx *gorm.DB) error {
+	return ErrJournalImmutable
+}
+
+// BeforeDelete - Posted lines are immutable
+func (l *JournalLine) BeforeDelete(tx *gorm.DB) error {
+	return ErrJournalImmutable
+}
@@ -7,8 +7,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 // Tenant represents an organization/company in the system
@@ -103,6 +103,7 @@ type Client struct {
 	Notes                string       `json:"notes"`          // Client-facing notes
 	TotalBilled          Money        `json:"total_billed" gorm:"default:0"`
 	TotalPaid            Money        `json:"total_paid" gorm:"default:0"`
+	CreditBalance        Money        `json:"credit_balance" gorm:"default:0"` // Unallocated overpayments
 	InvoiceCount         int64        `json:"invoice_count" gorm:"-"`
 	TagsList             []string     `json:"tags" gorm:"-"` // For API response only
 	LastPaymentDate      *time.Time   `json:"last_payment_date"`
@@ -156,6 +157,7 @@ const (
 	KRAInvoiceStatusFailed    KRAInvoiceStatus = "failed"
 	KRAInvoiceStatusAccepted  KRAInvoiceStatus = "accepted"
 	KRAInvoiceStatusRejected  KRAInvoiceStatus = "rejected"
+	KRAInvoiceStatusCredited  KRAInvoiceStatus = "credited" // Reversed in full by credit notes
 )
 
 // TaxType represents the type of tax applied
@@ -171,10 +173,10 @@ const (
 // Invoice represents an invoice - IMMUTABLE after PAID or KRA SUBMITTED
 type Invoice struct {
 	ID                string           `json:"id" gorm:"type:uuid;primaryKey"`
-	TenantID          string           `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	TenantID          string           `json:"tenant_id" gorm:"type:uuid;index;not null;uniqueIndex:idx_invoices_tenant_number"`
 	UserID            string           `json:"user_id" gorm:"type:uuid;index;not null"`
 	ClientID          string           `json:"client_id" gorm:"type:uuid;index;not null"`
-	InvoiceNumber     string           `json:"invoice_number" gorm:"uniqueIndex"`
+	InvoiceNumber     string           `json:"invoice_number" gorm:"uniqueIndex:idx_invoices_tenant_number"` // Unique per tenant
 	SequenceNumber    int64            `json:"sequence_number"` // For sequential numbering
 	Reference         string           `json:"reference"`
 	Title             string           `json:"title"` // Invoice title/subject
@@ -199,10 +201,12 @@ type Invoice struct {
 	Total        Money `json:"total" gorm:"not null"`
 	PaidAmount   Money `json:"paid_amount" gorm:"default:0"`
 	BalanceDue   Money `json:"balance_due" gorm:"default:0"` // Calculated: Total - PaidAmount
+	CreditedAmount Money `json:"credited_amount" gorm:"default:0"` // Reversed by credit notes; the part that settled the balance is in PaidAmount
 
 	// Tax breakdown for KRA compliance
 	TaxType           TaxType `json:"tax_type" gorm:"default:'standard'"`
-	TaxRate           float64 `json:"tax_rate" gorm:"default:16"` // 16% default for VAT
+	TaxRate           float64 `json:"tax_rate"`                    // Rate in force on the invoice date
+	TaxCode           string  `json:"tax_code,omitempty"`          // Tax catalogue code the rate came from
 	TaxableAmount     Money   `json:"taxable_amount"`              // Amount before tax
 	ExemptAmount      Money   `json:"exempt_amount"`               // Exempt portion
 	ZeroRatedAmount   Money   `json:"zero_rated_amount"`          // Zero-rated portion
@@ -231,9 +235,9 @@ type Invoice struct {
 	MagicTokenExpiresAt *time.Time `json:"magic_token_expires_at"`         // Token expiration
 
 	// KRA eTIMS Fields
-	KRAICN           string           `json:"kra_icn"`            // KRA Invoice Confirmation Number
-	KRAQRCode        string            `json:"kra_qr_code"`        // KRA QR Code
-	KRAStatus        KRAInvoiceStatus  `json:"kra_status"`         // pending, submitted, failed, accepted, rejected
+	KRAICN           string           `json:"kra_icn" gorm:"column:kra_icn"`            // KRA Invoice Confirmation Number
+	KRAQRCode        string            `json:"kra_qr_code" gorm:"column:kra_qr_code"`        // KRA QR Code
+	KRAStatus        KRAInvoiceStatus  `json:"kra_status"`         // pending, submitted, failed, accepted, rejected, credited
 	KRASubmittedAt   *time.Time        `json:"kra_submitted_at"`  // When submitted to KRA
 	KRAError         string            `json:"kra_error"`          // Error message if failed
 	KRARetryCount    int               `json:"kra_retry_count"`   // Number of retry attempts
@@ -279,7 +283,7 @@ func (i *Invoice) BeforeDelete(tx *gorm.DB) error {
 	if i.Status == InvoiceStatusPaid || i.Status == InvoiceStatusPartiallyPaid {
 		return errors.New("invoice cannot be deleted: financial records must be preserved")
 	}
-	if i.KRAStatus == KRAInvoiceStatusSubmitted || i.KRAStatus == KRAInvoiceStatusAccepted {
+	if i.KRAStatus == KRAInvoiceStatusSubmitted || i.KRAStatus == KRAInvoiceStatusAccepted || i.KRAStatus == KRAInvoiceStatusCredited {
 		return errors.New("invoice cannot be deleted: KRA submission exists")
 	}
 	return nil
@@ -349,48 +353,38 @@ func ValidateTransition(from, to InvoiceStatus) error {
 // INVOICE SEQUENCE - Sequential Numbering
 // ============================================
 
-// InvoiceSequence tracks invoice numbers per tenant
+// Sequence document types
+const (
+	SequenceDocumentInvoice    = "invoice"
+	SequenceDocumentCreditNote = "credit_note"
+	SequenceDocumentDebitNote  = "debit_note"
+	SequenceDocumentReceipt    = "receipt"
+	SequenceDocumentQuote      = "quote"
+	SequenceDocumentJournal    = "journal"
+)
+
+// InvoiceSequence tracks document numbers per tenant and document type
 type InvoiceSequence struct {
 	ID              string    `json:"id" gorm:"type:uuid;primaryKey"`
-	TenantID        string    `json:"tenant_id" gorm:"type:uuid;uniqueIndex;not null"`
+	TenantID        string    `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_invoice_sequences_tenant_document;not null"`
+	DocumentType    string    `json:"document_type" gorm:"uniqueIndex:idx_invoice_sequences_tenant_document;default:'invoice'"` // invoice, credit_note, debit_note, receipt, quote, journal
 	LastSequenceNum int64     `json:"last_sequence_num" gorm:"default:0"`
 	Prefix         string    `json:"prefix" gorm:"default:'INV'"`
 	Padding        int       `json:"padding" gorm:"default:6"` // Number of digits (INV-000001)
+	Pattern        string    `json:"pattern"`                  // e.g. {PREFIX}/{FY}/{SEQ:5}; empty means {PREFIX}-{SEQ}
+	ResetYearly    bool      `json:"reset_yearly" gorm:"default:false"` // Start again at 1 each fiscal year
+	FiscalYear     int       `json:"fiscal_year" gorm:"default:0"`      // Fiscal year LastSequenceNum was issued in
+	HighestSequenceNum int64 `json:"highest_sequence_num" gorm:"default:0"` // Highest number issued in any fiscal year
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
-// GetNextSequence returns the next sequence number in a transaction-safe manner
-func (s *InvoiceSequence) GetNextSequence(tx *gorm.DB) (int64, error) {
-	var seq InvoiceSequence
-	
-	// Try with locking first (for PostgreSQL)
-	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-		FirstOrCreate(&seq, InvoiceSequence{TenantID: s.TenantID}).Error
-	
-	// If locking not supported (SQLite), fallback to regular FirstOrCreate
-	if err != nil || seq.TenantID == "" {
-		err = tx.FirstOrCreate(&seq, InvoiceSequence{TenantID: s.TenantID}).Error
-	}
-	
-	if err != nil {
-		return 0, fmt.Errorf("failed to get sequence: %w", err)
-	}
-
-	newNum := seq.LastSequenceNum + 1
-	
-	// Use Update with explicit WHERE clause for SQLite compatibility
-	err = tx.Model(&seq).Where("tenant_id = ?", s.TenantID).Update("last_sequence_num", newNum).Error
-	if err != nil {
-		return 0, fmt.Errorf("failed to update sequence: %w", err)
+// BeforeCreate generates the ID for sequences created on first use
+func (s *InvoiceSequence) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
 	}
-
-	return newNum, nil
-}
-
-// FormatInvoiceNumber formats sequence number with prefix and padding
-func FormatInvoiceNumber(prefix string, sequence int64, padding int) string {
-	return fmt.Sprintf("%s-%0*d", prefix, padding, sequence)
+	return nil
 }
 
 // ============================================
@@ -401,6 +395,7 @@ func FormatInvoiceNumber(prefix string, sequence int64, padding int) string {
 func ValidateInvoiceTotals(invoice *Invoice, items []InvoiceItem) error {
 	var calculatedSubtotal Money
 	var calculatedTax Money
+	var calculatedExcise Money
 	var calculatedTotal Money
 
 	for _, item := range items {
@@ -413,7 +408,7 @@ func ValidateInvoiceTotals(invoice *Invoice, items []InvoiceItem) error {
 			discount = item.DiscountAmt
 		}
 
-		taxable := itemSubtotal.Sub(discount)
+		taxable := itemSubtotal.Sub(discount).Add(item.ExciseDuty)
 
 		var tax Money
 		if item.TaxRate > 0 && item.TaxType != TaxTypeNone && item.TaxType != TaxTypeExempt {
@@ -424,10 +419,11 @@ func ValidateInvoiceTotals(invoice *Invoice, items []InvoiceItem) error {
 
 		calculatedSubtotal = calculatedSubtotal.Add(itemSubtotal)
 		calculatedTax = calculatedTax.Add(tax)
+		calculatedExcise = calculatedExcise.Add(item.ExciseDuty)
 		calculatedTotal = calculatedTotal.Add(itemTotal)
 	}
 
-	calculatedTotal = calculatedSubtotal.Add(calculatedTax).Sub(invoice.Discount)
+	calculatedTotal = calculatedSubtotal.Add(calculatedExcise).Add(calculatedTax).Sub(invoice.Discount)
 
 	if invoice.Total != calculatedTotal {
 		return fmt.Errorf("total mismatch: stored=%.2f, calculated=%.2f", invoice.Total.Float64(), calculatedTotal.Float64())
@@ -444,7 +440,9 @@ func ValidateInvoiceTotals(invoice *Invoice, items []InvoiceItem) error {
 }
 
 // CalculateLineItemTax calculates tax for a single line item using exact Money arithmetic.
-func CalculateLineItemTax(quantity, unitPrice, discountRate, discountAmt, taxRate float64, taxType TaxType) (subtotal, taxAmount, total Money) {
+// vat and excise are the catalogue rates in force on the invoice date; either may be nil.
+// Excise is charged on the discounted amount and VAT on that plus the excise.
+func CalculateLineItemTax(quantity, unitPrice, discountRate, discountAmt float64, vat, excise *TaxRate) (subtotal, exciseDuty, taxAmount, total Money) {
 	subtotal = ToCents(quantity * unitPrice)
 
 	var discount Money
@@ -456,11 +454,14 @@ func CalculateLineItemTax(quantity, unitPrice, discountRate, discountAmt, taxRat
 
 	afterDiscount := subtotal.Sub(discount)
 
-	if taxType == TaxTypeStandard && taxRate > 0 {
-		taxAmount = afterDiscount.Mul(taxRate / 100)
+	if excise != nil && excise.Rate > 0 {
+		exciseDuty = afterDiscount.Mul(excise.Rate / 100)
+	}
+	if vat.Charges() {
+		taxAmount = afterDiscount.Add(exciseDuty).Mul(vat.Rate / 100)
 	}
 
-	total = afterDiscount.Add(taxAmount)
+	total = afterDiscount.Add(exciseDuty).Add(taxAmount)
 	return
 }
 
@@ -480,6 +481,10 @@ type InvoiceItem struct {
 	TaxType   TaxType `json:"tax_type" gorm:"default:'standard'"`
 	TaxRate   float64 `json:"tax_rate" gorm:"default:0"`  // e.g., 16 for 16%
 	TaxAmount Money   `json:"tax_amount" gorm:"default:0"` // Calculated tax
+	TaxCode     string `json:"tax_code,omitempty"`               // Tax catalogue code
+	TaxCategory string `json:"tax_category,omitempty" gorm:"type:varchar(2)"` // KRA eTIMS tax category, A-E
+	ExciseCode  string `json:"excise_code,omitempty"`            // Excise or levy catalogue code
+	ExciseDuty  Money  `json:"excise_duty" gorm:"default:0"`     // Charged before VAT, which is due on it too
 
 	// Discount per line item
 	DiscountRate float64 `json:"discount_rate" gorm:"default:0"` // Percentage discount
@@ -531,12 +536,20 @@ type Payment struct {
 	Method         PaymentMethod `json:"method" gorm:"not null"`
 	Status         PaymentStatus `json:"status" gorm:"default:'pending';index:idx_payment_invoice_status,priority:2"`
 	Reference     string        `json:"reference" gorm:"index"` // M-Pesa receipt number
+	ReceiptNumber string        `json:"receipt_number,omitempty" gorm:"index"` // Our receipt number, issued when the payment completes
 	IntasendID    string        `json:"intasend_id"`
 	StripeChargeID string       `json:"stripe_charge_id"`
 	PhoneNumber   string       `json:"phone_number"`
 	CustomerEmail string       `json:"customer_email"`
 	FailureReason string       `json:"failure_reason"`
+	ResolvedBy    string       `json:"resolved_by,omitempty"` // what settled a pending M-Pesa payment: callback, stk_query, transaction_status
 	IdempotencyKey string    `json:"idempotency_key" gorm:"index"` // Prevent duplicate processing
+	// Foreign-currency payments: KES per unit on the day it settled. The gain
+	// against the invoice's booked rate is posted with the payment's journal entry
+	ExchangeRate   float64   `json:"exchange_rate,omitempty" gorm:"default:0"`
+	// Tax the client withheld; it settles the invoice along with Amount
+	Withheld      Money                    `json:"withheld" gorm:"default:0"`
+	Withholdings  []WithholdingCertificate `json:"withholdings,omitempty" gorm:"-"`
 	CompletedAt   *time.Time   `json:"completed_at"`
 	CreatedAt     time.Time    `json:"created_at"`
 	UpdatedAt     time.Time    `json:"updated_at"`
@@ -579,6 +592,17 @@ type UnallocatedPayment struct {
 	MatchedAt   *time.Time `json:"matched_at"`
 	MatchedBy   string     `json:"matched_by"` // User ID who matched
 	CreatedAt   time.Time  `json:"created_at"`
+
+	// Paybill (C2B) details and the best guess at which invoice it pays
+	AccountReference   string `json:"account_reference"`
+	PayerName          string `json:"payer_name"`
+	SuggestedInvoiceID string `json:"suggested_invoice_id"`
+	SuggestionReason   string `json:"suggestion_reason"` // invoice_number, phone, fuzzy_reference
+	SuggestionScore    int    `json:"suggestion_score"`
+
+	// Whether Daraja's Transaction Status confirmed the paybill payment happened
+	Verification         string `json:"verification,omitempty"` // pending, verified, unverified, rejected
+	StatusConversationID string `json:"-" gorm:"index"`          // pending Transaction Status query
 }
 
 // Template represents an invoice template
@@ -616,37 +640,74 @@ type AuditLog struct {
 	CreatedAt  time.Time `json:"created_at;index:idx_audit_tenant_action_created,priority:3"`
 }
 
-// APIKey for programmatic access
+// APIKey for programmatic access. The raw key is only returned once at
+// creation; Key keeps a non-secret prefix so users can tell keys apart.
 type APIKey struct {
-	ID         string       `json:"id" gorm:"type:uuid;primaryKey"`
-	TenantID   string       `json:"tenant_id" gorm:"type:uuid;index;not null"`
-	UserID     string       `json:"user_id" gorm:"type:uuid;index;not null"`
-	Name       string       `json:"name"`
-	Key        string       `json:"key" gorm:"uniqueIndex;not null"`
-	KeyHash    string       `json:"-" gorm:"not null"`
-	LastUsedAt sql.NullTime `json:"last_used_at"`
-	ExpiresAt  time.Time    `json:"expires_at"`
-	IsActive   bool         `json:"is_active" gorm:"default:true"`
-	CreatedAt  time.Time    `json:"created_at"`
-}
-
-// ExchangeRate for storing currency rates
+	ID           string       `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID     string       `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	UserID       string       `json:"user_id" gorm:"type:uuid;index;not null"`
+	Name         string       `json:"name"`
+	Key          string       `json:"key" gorm:"uniqueIndex;not null"` // display prefix, e.g. if_sk_AbCd1234
+	KeyHash      string       `json:"-" gorm:"uniqueIndex;not null"`
+	Scopes       string       `json:"scopes"`                             // comma separated, see APIKeyScope*
+	RateLimit    int          `json:"rate_limit" gorm:"default:0"`        // requests per minute, 0 = default
+	LastUsedAt   sql.NullTime `json:"last_used_at"`
+	ExpiresAt    time.Time    `json:"expires_at"`
+	IsActive     bool         `json:"is_active" gorm:"default:true"`
+	RevokedAt    *time.Time   `json:"revoked_at"`
+	ReplacedByID *string      `json:"replaced_by_id" gorm:"type:uuid"` // set when rotated
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// API key scopes. A :write scope implies the matching :read scope.
+const (
+	APIKeyScopeReadOnly      = "read_only"
+	APIKeyScopeInvoicesRead  = "invoices:read"
+	APIKeyScopeInvoicesWrite = "invoices:write"
+	APIKeyScopeClientsRead   = "clients:read"
+	APIKeyScopeClientsWrite  = "clients:write"
+	APIKeyScopePaymentsRead  = "payments:read"
+	APIKeyScopePaymentsWrite = "payments:write"
+	APIKeyScopeExpensesRead  = "expenses:read"
+	APIKeyScopeExpensesWrite = "expenses:write"
+	APIKeyScopeReportsRead   = "reports:read"
+)
+
+// Exchange rate sources
+const (
+	ExchangeRateSourceCBK     = "cbk"     // published by the Central Bank of Kenya
+	ExchangeRateSourceDefault = "default" // seeded when no rate was ever fetched
+	ExchangeRateSourceManual  = "manual"  // a tenant's override for one day
+)
+
+// ExchangeRate for storing currency rates. Rate is units of Currency per 1 KES;
+// one row is kept per currency and day. Rows with a TenantID are that tenant's
+// manual overrides and win over the published rate for their day.
 type ExchangeRate struct {
 	ID           string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID     *string   `json:"tenant_id,omitempty" gorm:"type:uuid;index"`
 	Currency     string    `json:"currency" gorm:"not null;index"`
 	BaseCurrency string    `json:"base_currency" gorm:"default:'KES'"`
 	Rate         float64   `json:"rate" gorm:"not null"`
 	ValidFrom    time.Time `json:"valid_from"`
+	Source       string    `json:"source" gorm:"default:'cbk'"`
+	CreatedBy    string    `json:"created_by,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
+
+	KESPerUnit float64 `json:"kes_per_unit" gorm:"-"` // 1 / Rate
 }
 
 // KRAQueueStatus represents KRA submission status
 type KRAQueueStatus string
 
 const (
-	KRAQueuePending   KRAQueueStatus = "pending"
-	KRAQueueFailed    KRAQueueStatus = "failed"
-	KRAQueueCompleted KRAQueueStatus = "completed"
+	KRAQueuePending    KRAQueueStatus = "pending"
+	KRAQueueProcessing KRAQueueStatus = "processing"
+	KRAQueueAccepted   KRAQueueStatus = "accepted" // KRA issued an ICN not yet recorded on the invoice
+	KRAQueueFailed     KRAQueueStatus = "failed"
+	KRAQueueCompleted  KRAQueueStatus = "completed"
+	KRAQueueCancelled  KRAQueueStatus = "cancelled" // invoice cancelled before KRA registered it
 )
 
 // KRAQueueItem for failed KRA submissions
@@ -660,6 +721,8 @@ type KRAQueueItem struct {
 	MaxRetries    int            `json:"max_retries" gorm:"default:3"`
 	Status        KRAQueueStatus `json:"status" gorm:"default:'pending'"`
 	LastError     string         `json:"last_error"`
+	ICN           string         `json:"icn"`     // set once KRA accepts the submission
+	QRCode        string         `json:"qr_code"` // set once KRA accepts the submission
 	NextRetryAt   *time.Time     `json:"next_retry_at"`
 	CompletedAt   *time.Time     `json:"completed_at"`
 	CreatedAt     time.Time      `json:"created_at"`
@@ -1186,6 +1249,8 @@ const (
 	TriggerEventPaymentFailed   = "payment_failed"
 	TriggerEventFraudDetected   = "fraud_detected"
 	TriggerEventClientAdded     = "client_added"
+	TriggerEventKRAAccepted     = "kra_accepted"
+	TriggerEventKRARejected     = "kra_rejected"
 )
 
 // WorkflowCondition defines a condition for workflow execution
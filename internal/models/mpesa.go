@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// M-Pesa credential sources
+const (
+	MpesaCredentialsPlatform = "platform"
+	MpesaCredentialsTenant   = "tenant"
+)
+
+// What resolved a pending M-Pesa payment
+const (
+	MpesaResolvedByCallback          = "callback"
+	MpesaResolvedBySTKQuery          = "stk_query"
+	MpesaResolvedByTransactionStatus = "transaction_status"
+)
+
+// Transaction Status verification of a paybill (C2B) confirmation
+const (
+	C2BVerificationPending    = "pending"
+	C2BVerificationVerified   = "verified"
+	C2BVerificationUnverified = "unverified" // couldn't be checked; left for a person
+	C2BVerificationRejected   = "rejected"   // M-Pesa has no such completed payment
+)
+
+// MpesaSTKRequest records an STK push sent to Daraja so its callback can be
+// traced back to exactly one tenant and the credentials used for it
+type MpesaSTKRequest struct {
+	ID                string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID          string    `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	InvoiceID         string    `json:"invoice_id" gorm:"type:uuid;index"`
+	ShortCode         string    `json:"short_code"`
+	CredentialSource  string    `json:"credential_source"` // platform, tenant
+	Environment       string    `json:"environment"`
+	MerchantRequestID string    `json:"merchant_request_id" gorm:"index"`
+	CheckoutRequestID string    `json:"checkout_request_id" gorm:"uniqueIndex;not null"`
+	AccountReference  string    `json:"account_reference"`
+	Amount            string    `json:"amount"`
+	CreatedAt         time.Time `json:"created_at"`
+
+	// Status polling when the callback doesn't arrive
+	QueryAttempts        int        `json:"query_attempts" gorm:"default:0"`
+	LastQueriedAt        *time.Time `json:"last_queried_at"`
+	StatusConversationID string     `json:"status_conversation_id" gorm:"index"` // pending Transaction Status query
+	ResultCode           string     `json:"result_code"`                         // last STK Push Query result
+	ResultDesc           string     `json:"result_desc"`
+	ResolvedBy           string     `json:"resolved_by"`
+	ResolvedAt           *time.Time `json:"resolved_at"`
+}
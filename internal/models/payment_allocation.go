@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// Payment allocation strategies
+const (
+	AllocationStrategyOldestFirst  = "oldest_first"
+	AllocationStrategyExplicit     = "explicit"
+	AllocationStrategyProportional = "proportional"
+)
+
+// Client credit transaction types
+const (
+	ClientCreditOverpayment = "overpayment"
+	ClientCreditPayout      = "payout"      // credit returned to the client over M-Pesa
+	ClientCreditRefund      = "refund"      // the payment that created the credit was refunded
+	ClientCreditCreditNote  = "credit_note" // a credit note reversed more than was left to pay
+)
+
+// PaymentAllocation is the share of a payment applied to one invoice. A payment
+// keeps its first invoice in Payment.InvoiceID; the allocations carry the split.
+type PaymentAllocation struct {
+	ID        string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID  string    `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	PaymentID string    `json:"payment_id" gorm:"type:uuid;index;not null"`
+	InvoiceID string    `json:"invoice_id" gorm:"type:uuid;index;not null"`
+	ClientID  string    `json:"client_id" gorm:"type:uuid;index"`
+	Amount    Money     `json:"amount" gorm:"not null"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+
+	InvoiceNumber string `json:"invoice_number,omitempty" gorm:"-"`
+}
+
+// ClientCreditTransaction records a change to a client's credit balance
+type ClientCreditTransaction struct {
+	ID           string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID     string    `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	ClientID     string    `json:"client_id" gorm:"type:uuid;index;not null"`
+	PaymentID    string    `json:"payment_id" gorm:"index"`
+	PayoutID     string    `json:"payout_id,omitempty" gorm:"index"`
+	CreditNoteID string    `json:"credit_note_id,omitempty" gorm:"index"`
+	Type         string    `json:"type"` // overpayment, payout, refund, credit_note
+	Amount       Money     `json:"amount" gorm:"not null"`
+	BalanceAfter Money     `json:"balance_after"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+)
+
+// Payout types
+const (
+	PayoutTypeRefund        = "refund"        // a completed payment returned to the payer
+	PayoutTypeOverpayment   = "overpayment"   // client credit returned to the client
+	PayoutTypeReimbursement = "reimbursement" // an approved expense paid to the employee
+)
+
+// Payout statuses
+const (
+	PayoutStatusPendingApproval = "pending_approval"
+	PayoutStatusRejected        = "rejected"
+	PayoutStatusProcessing      = "processing" // sent to M-Pesa, waiting for the result
+	PayoutStatusCompleted       = "completed"
+	PayoutStatusFailed          = "failed"
+)
+
+// Payout is money sent out of the tenant's M-Pesa B2C account. It records
+// what it pays back: a payment, a client's credit or an expense.
+type Payout struct {
+	ID            string `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID      string `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	Type          string `json:"type" gorm:"not null"`
+	Status        string `json:"status" gorm:"default:'pending_approval';index"`
+	Amount        Money  `json:"amount" gorm:"not null"`
+	Currency      string `json:"currency" gorm:"default:'KES'"`
+	PhoneNumber   string `json:"phone_number"` // Encrypted at rest
+	RecipientName string `json:"recipient_name"`
+	Reason        string `json:"reason"`
+
+	// Exactly one of these is set, depending on Type
+	PaymentID string `json:"payment_id,omitempty" gorm:"index"`
+	ClientID  string `json:"client_id,omitempty" gorm:"index"`
+	ExpenseID string `json:"expense_id,omitempty" gorm:"index"`
+
+	RequestedBy    string     `json:"requested_by"`
+	ApprovedBy     string     `json:"approved_by,omitempty"`
+	ApprovedAt     *time.Time `json:"approved_at,omitempty"`
+	RejectedBy     string     `json:"rejected_by,omitempty"`
+	RejectedReason string     `json:"rejected_reason,omitempty"`
+
+	// Daraja B2C request and result
+	ShortCode      string     `json:"short_code"`
+	ConversationID string     `json:"conversation_id" gorm:"index"`
+	ReceiptNumber  string     `json:"receipt_number" gorm:"index"`
+	ResultCode     string     `json:"result_code"`
+	ResultDesc     string     `json:"result_desc"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+
+	// Transaction Status query for a payout whose result never arrived
+	StatusConversationID string     `json:"-" gorm:"index"`
+	StatusQueriedAt      *time.Time `json:"status_queried_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Events []PayoutEvent `json:"events,omitempty" gorm:"foreignKey:PayoutID"`
+}
+
+// PayoutEvent is one entry in a payout's history of statuses and receipts
+type PayoutEvent struct {
+	ID            string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID      string    `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	PayoutID      string    `json:"payout_id" gorm:"type:uuid;index;not null"`
+	Status        string    `json:"status"`
+	Detail        string    `json:"detail"`
+	ReceiptNumber string    `json:"receipt_number,omitempty"`
+	CreatedBy     string    `json:"created_by,omitempty"` // empty for M-Pesa results
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"time"
+)
+
+// QuoteStatus represents the status of a quote
+type QuoteStatus string
+
+const (
+	QuoteStatusDraft    QuoteStatus = "draft"
+	QuoteStatusSent     QuoteStatus = "sent"
+	QuoteStatusViewed   QuoteStatus = "viewed"
+	QuoteStatusAccepted QuoteStatus = "accepted"
+	QuoteStatusDeclined QuoteStatus = "declined"
+	QuoteStatusExpired  QuoteStatus = "expired"
+)
+
+// Quote is a pre-sale quotation that converts into an invoice once accepted
+type Quote struct {
+	ID             string  `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID       string  `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_quotes_tenant_number;not null"`
+	UserID         string  `json:"user_id" gorm:"type:uuid;index;not null"`
+	ClientID       string  `json:"client_id" gorm:"type:uuid;index;not null"`
+	QuoteNumber    string  `json:"quote_number" gorm:"uniqueIndex:idx_quotes_tenant_number"`
+	SequenceNumber int64   `json:"sequence_number"`
+	Reference      string  `json:"reference"`
+	Title          string  `json:"title"`
+	Currency       string  `json:"currency" gorm:"default:'KES'"`
+	ExchangeRate   float64 `json:"exchange_rate" gorm:"default:1"`
+
+	Subtotal   Money   `json:"subtotal"`
+	Discount   Money   `json:"discount" gorm:"default:0"`
+	TaxRate    float64 `json:"tax_rate" gorm:"default:0"` // Invoice-level rate for lines without their own
+	TaxCode    string  `json:"tax_code,omitempty"`        // Tax catalogue code the rate came from
+	TotalTax   Money   `json:"total_tax" gorm:"default:0"`
+	ExciseDuty Money   `json:"excise_duty" gorm:"default:0"`
+	Total      Money   `json:"total" gorm:"not null"`
+	TaxType    TaxType `json:"tax_type" gorm:"default:'standard'"`
+
+	Status        QuoteStatus `json:"status" gorm:"index;default:'draft'"`
+	ExpiryDate    time.Time   `json:"expiry_date"`
+	SentAt        *time.Time  `json:"sent_at"`
+	ViewedAt      *time.Time  `json:"viewed_at"`
+	AcceptedAt    *time.Time  `json:"accepted_at"`
+	DeclinedAt    *time.Time  `json:"declined_at"`
+	DeclineReason string      `json:"decline_reason"`
+
+	ConvertedInvoiceID string     `json:"converted_invoice_id" gorm:"index"`
+	ConvertedAt        *time.Time `json:"converted_at"`
+
+	Notes      string `json:"notes"`
+	Terms      string `json:"terms"`
+	MagicToken string `json:"magic_token" gorm:"uniqueIndex"` // For client portal accept/decline
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Client Client      `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+	Items  []QuoteItem `json:"items,omitempty" gorm:"foreignKey:QuoteID"`
+}
+
+// IsExpired reports whether an open quote is past its expiry date
+func (q *Quote) IsExpired(now time.Time) bool {
+	if q.Status != QuoteStatusSent && q.Status != QuoteStatusViewed {
+		return false
+	}
+	return !q.ExpiryDate.IsZero() && now.After(q.ExpiryDate)
+}
+
+// QuoteItem is a quote line. Fields mirror InvoiceItem so conversion is a straight copy.
+type QuoteItem struct {
+	ID            string  `json:"id" gorm:"type:uuid;primaryKey"`
+	QuoteID       string  `json:"quote_id" gorm:"type:uuid;index;not null"`
+	Description   string  `json:"description" gorm:"not null"`
+	ItemCode      string  `json:"item_code" gorm:"type:varchar(100)"`
+	Quantity      float64 `json:"quantity" gorm:"default:1"`
+	UnitPrice     Money   `json:"unit_price" gorm:"not null"`
+	Unit          string  `json:"unit" gorm:"type:varchar(50)"`
+	UnitOfMeasure string  `json:"unit_of_measure" gorm:"type:varchar(50)"`
+
+	TaxType    TaxType `json:"tax_type" gorm:"default:'standard'"`
+	TaxRate    float64 `json:"tax_rate" gorm:"default:0"`
+	TaxAmount  Money   `json:"tax_amount" gorm:"default:0"`
+	TaxCode    string  `json:"tax_code,omitempty"`    // Tax catalogue code
+	ExciseCode string  `json:"excise_code,omitempty"` // Excise or levy catalogue code
+	ExciseDuty Money   `json:"excise_duty" gorm:"default:0"`
+
+	DiscountRate float64 `json:"discount_rate" gorm:"default:0"`
+	DiscountAmt  Money   `json:"discount_amount" gorm:"default:0"`
+
+	Subtotal Money `json:"subtotal"`
+	Total    Money `json:"total" gorm:"not null"`
+
+	SortOrder int       `json:"sort_order" gorm:"default:0"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+)
+
+// Tax rate kinds
+const (
+	TaxKindVAT    = "vat"
+	TaxKindExcise = "excise"
+	TaxKindLevy   = "levy"
+)
+
+// Catalogue codes seeded for every Kenyan tenant
+const (
+	TaxCodeVAT       = "VAT"    // standard rate
+	TaxCodeReduced   = "VAT8"   // reduced rate (petroleum products)
+	TaxCodeZeroRated = "ZERO"   // zero-rated supplies and exports
+	TaxCodeExempt    = "EXEMPT" // exempt supplies
+	TaxCodeNonVAT    = "NONVAT" // outside the scope of VAT
+)
+
+// KRA eTIMS tax categories (taxTyCd)
+const (
+	KRATaxCategoryExempt    = "A" // exempt
+	KRATaxCategoryStandard  = "B" // standard rate VAT
+	KRATaxCategoryZeroRated = "C" // zero-rated
+	KRATaxCategoryNonVAT    = "D" // outside the scope of VAT
+	KRATaxCategoryReduced   = "E" // reduced rate VAT
+)
+
+// TaxRate is one rate in a tenant's tax catalogue. A rate that changes gets a
+// new row from the date it changes, so invoices always pick the rate in force
+// on their own date.
+type TaxRate struct {
+	ID          string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID    string     `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_tax_rates_code_from;not null"`
+	Country     string     `json:"country" gorm:"type:varchar(2);uniqueIndex:idx_tax_rates_code_from;default:'KE'"`
+	Code        string     `json:"code" gorm:"uniqueIndex:idx_tax_rates_code_from;not null"` // e.g. VAT, VAT8, ZERO, EXEMPT
+	Name        string     `json:"name" gorm:"not null"`
+	Kind        string     `json:"kind" gorm:"not null;default:'vat'"` // vat, excise, levy
+	TaxType     TaxType    `json:"tax_type" gorm:"not null;default:'standard'"`
+	Rate        float64    `json:"rate"`                                    // percentage, e.g. 16
+	KRACategory string     `json:"kra_category" gorm:"column:kra_category"` // eTIMS tax category, A-E
+	ValidFrom   time.Time  `json:"valid_from" gorm:"uniqueIndex:idx_tax_rates_code_from;not null"`
+	ValidTo     *time.Time `json:"valid_to,omitempty"` // last day in force; nil while current
+	IsSystem    bool       `json:"is_system" gorm:"default:false"`
+	IsActive    bool       `json:"is_active" gorm:"default:true"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// InForce reports whether the rate applies on date
+func (r *TaxRate) InForce(date time.Time) bool {
+	day := date.Format("2006-01-02")
+	if day < r.ValidFrom.Format("2006-01-02") {
+		return false
+	}
+	return r.ValidTo == nil || day <= r.ValidTo.Format("2006-01-02")
+}
+
+// Charges reports whether the rate adds tax to a line
+func (r *TaxRate) Charges() bool {
+	return r != nil && r.Rate > 0 && (r.TaxType == TaxTypeStandard || r.TaxType == "")
+}
+
+// KRATaxCategory returns the eTIMS tax category implied by a line's tax type
+// and rate alone. Standard-rated lines return "" - which category they fall in
+// depends on the catalogue rate they match.
+func KRATaxCategory(taxType TaxType, rate float64) string {
+	switch {
+	case taxType == TaxTypeExempt:
+		return KRATaxCategoryExempt
+	case taxType == TaxTypeNone:
+		return KRATaxCategoryNonVAT
+	case taxType == TaxTypeZeroRated, rate <= 0:
+		return KRATaxCategoryZeroRated
+	}
+	return ""
+}
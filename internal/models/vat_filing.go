@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// VATFiling records a VAT3 return bundle exported for upload to iTax. Each
+// export of the same month is a new version, so a period exported twice is
+// never a surprise.
+type VATFiling struct {
+	ID            string    `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID      string    `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_vat_filings_period_version;not null"`
+	Period        string    `json:"period" gorm:"type:varchar(7);uniqueIndex:idx_vat_filings_period_version;not null"` // YYYY-MM
+	Version       int       `json:"version" gorm:"uniqueIndex:idx_vat_filings_period_version;not null"`
+	OutputTax     Money     `json:"output_tax"`
+	InputTax      Money     `json:"input_tax"`
+	NetVAT        Money     `json:"net_vat"`
+	SalesLines    int       `json:"sales_lines"`
+	PurchaseLines int       `json:"purchase_lines"`
+	Checksum      string    `json:"checksum"` // sha256 of the bundle
+	ExportedBy    string    `json:"exported_by" gorm:"type:uuid"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (VATFiling) TableName() string {
+	return "vat_filings"
+}
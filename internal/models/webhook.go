@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+// WebhookEndpoint is a tenant URL that receives signed event deliveries
+type WebhookEndpoint struct {
+	ID              string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID        string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	URL             string     `json:"url" gorm:"not null"`
+	Description     string     `json:"description"`
+	Events          string     `json:"events" gorm:"type:text"`     // comma separated event names, "*" for all
+	Secret          string     `json:"-" gorm:"type:text;not null"` // encrypted signing secret
+	IsActive        bool       `json:"is_active" gorm:"default:true"`
+	FailureCount    int        `json:"failure_count" gorm:"default:0"` // consecutive failed deliveries
+	LastStatusCode  int        `json:"last_status_code"`
+	LastDeliveryAt  *time.Time `json:"last_delivery_at"`
+	SecretRotatedAt *time.Time `json:"secret_rotated_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// WebhookDelivery records one event sent (or to be sent) to an endpoint
+type WebhookDelivery struct {
+	ID            string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID      string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	EndpointID    string     `json:"endpoint_id" gorm:"type:uuid;index;not null"`
+	Event         string     `json:"event" gorm:"index"`
+	EventID       string     `json:"event_id" gorm:"index"` // shared by all deliveries of the same event
+	Payload       string     `json:"payload" gorm:"type:text"`
+	Status        string     `json:"status" gorm:"index"` // pending, retrying, succeeded, failed
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	ResponseCode  int        `json:"response_code"`
+	ResponseBody  string     `json:"response_body" gorm:"type:text"`
+	Error         string     `json:"error" gorm:"type:text"`
+	DurationMs    int64      `json:"duration_ms"`
+	JobID         string     `json:"job_id"`
+	ReplayOfID    *string    `json:"replay_of_id" gorm:"type:uuid"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Webhook delivery status constants
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryRetrying  = "retrying"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// Withholding types
+const (
+	WithholdingVAT       = "vat"        // withholding VAT, 2% of the taxable value
+	WithholdingIncomeTax = "income_tax" // withholding income tax
+)
+
+// Withholding certificate states
+const (
+	WithholdingPending  = "pending"  // deducted, certificate not received yet
+	WithholdingReceived = "received" // certificate received; the credit can be claimed
+)
+
+// WithholdingCertificate is tax a client withheld from a payment and the
+// certificate they issue for it. The amount withheld settles the invoice like
+// cash; the certificate lets it be claimed against the tenant's own tax.
+type WithholdingCertificate struct {
+	ID                string     `json:"id" gorm:"type:uuid;primaryKey"`
+	TenantID          string     `json:"tenant_id" gorm:"type:uuid;index;not null"`
+	ClientID          string     `json:"client_id" gorm:"type:uuid;index"`
+	InvoiceID         string     `json:"invoice_id" gorm:"type:uuid;index"`
+	PaymentID         string     `json:"payment_id" gorm:"type:uuid;index"`
+	Type              string     `json:"type" gorm:"not null"` // vat, income_tax
+	CertificateNumber string     `json:"certificate_number,omitempty" gorm:"index"`
+	CertificateDate   *time.Time `json:"certificate_date,omitempty"`
+	Rate              float64    `json:"rate"`     // percent of the taxable value
+	Amount            Money      `json:"amount"`   // withheld, in Currency
+	Expected          Money      `json:"expected"` // Rate applied to the taxable value of what was paid
+	Currency          string     `json:"currency" gorm:"default:'KES'"`
+	DeductedAt        time.Time  `json:"deducted_at" gorm:"index"`
+	Status            string     `json:"status" gorm:"default:'pending';index"`
+	ReceivedAt        *time.Time `json:"received_at,omitempty"`
+	ReceivedBy        string     `json:"received_by,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+
+	Client *Client `json:"client,omitempty" gorm:"foreignKey:ClientID"`
+}
+
+func (WithholdingCertificate) TableName() string {
+	return "withholding_certificates"
+}
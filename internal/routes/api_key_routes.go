@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIKeyRoutes configures /api/v1/tenant/api-keys
+func APIKeyRoutes(app *fiber.App, h *handlers.APIKeyHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/api-keys")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Use(middleware.RequireOwnerOrAdmin())
+
+	group.Get("/", h.ListAPIKeys)
+	group.Post("/", h.CreateAPIKey)
+	group.Post("/:id/rotate", h.RotateAPIKey)
+	group.Delete("/:id", h.RevokeAPIKey)
+
+	return group
+}
@@ -10,16 +10,7 @@ import (
 )
 
 // AutomationRoutes configures automation API routes - Enterprise edition
-func AutomationRoutes(app *fiber.App, db *database.DB, authService *services.AuthService) fiber.Router {
-	// Initialize services
-	jobQueue := services.NewJobQueueService(db)
-	recurringInvoice := services.NewAutoRecurringInvoiceService(db, jobQueue)
-	reminderService := services.NewAutoReminderService(db, jobQueue)
-	workflowService := services.NewAutoWorkflowService(db, jobQueue)
-	
-	// Create handler
-	handler := handlers.NewAutomationHandler(db, jobQueue, recurringInvoice, reminderService, workflowService)
-	
+func AutomationRoutes(app *fiber.App, handler *handlers.AutomationHandler, authService *services.AuthService, db *database.DB) fiber.Router {
 	group := app.Group("/api/v1/tenant/automations")
 	group.Use(middleware.TenantMiddleware(authService, db))
 	group.Use(middleware.RequireEmailVerified(db))
@@ -54,6 +45,8 @@ func AutomationRoutes(app *fiber.App, db *database.DB, authService *services.Aut
 	workflows.Get("/", handler.GetWorkflows)
 	workflows.Get("/stats", handler.GetWorkflowStats)
 	workflows.Get("/:id", handler.GetWorkflow)
+	workflows.Get("/:id/runs", handler.GetWorkflowRuns)
+	workflows.Post("/:id/test", handler.TestWorkflow)
 	workflows.Post("/", handler.CreateWorkflow)
 	workflows.Put("/:id", handler.UpdateWorkflow)
 	workflows.Delete("/:id", handler.DeleteWorkflow)
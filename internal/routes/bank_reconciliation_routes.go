@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BankReconciliationRoutes configures /api/v1/tenant/bank-reconciliation
+func BankReconciliationRoutes(app *fiber.App, h *handlers.BankReconciliationHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/bank-reconciliation")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Use(middleware.CanReconcileBank())
+
+	group.Get("/statements", h.ListStatements)
+	group.Post("/statements", h.ImportStatement)
+	group.Get("/statements/:id", h.GetStatement)
+	group.Post("/statements/:id/match", h.AutoMatch)
+	group.Get("/review", h.ReviewQueue)
+	group.Post("/lines/:lineID/match", h.MatchLine)
+	group.Post("/lines/:lineID/unmatch", h.UnmatchLine)
+	group.Post("/lines/:lineID/ignore", h.IgnoreLine)
+	group.Get("/report", h.Report)
+
+	return group
+}
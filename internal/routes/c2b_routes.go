@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// C2BRoutes configures the Daraja paybill callbacks (/api/v1/webhook/c2b/:tenantID/:token/...),
+// the Transaction Status results that verify them (MPESA_RESULT_URL/:tenantID/:token/c2b)
+// and the tenant endpoint that registers them
+func C2BRoutes(app *fiber.App, h *handlers.C2BHandler, authService *services.AuthService, db *database.DB, rateLimiter *middleware.FiberRateLimiter, mpesaVerifier *middleware.WebhookVerifierMiddleware) fiber.Router {
+	hooks := app.Group("/api/v1/webhook/c2b")
+	hooks.Use(rateLimiter.WebhookRateLimiter())
+	hooks.Post("/:tenantID/:token/validation", mpesaVerifier.MpesaCallbackURLVerification(), h.Validate)
+	hooks.Post("/:tenantID/:token/confirmation", mpesaVerifier.MpesaCallbackURLVerification(), h.Confirm)
+
+	results := app.Group("/api/v1/webhook/mpesa/result")
+	results.Use(rateLimiter.WebhookRateLimiter())
+	results.Post("/:tenantID/:token/c2b", mpesaVerifier.MpesaCallbackURLVerification(), h.HandleStatusResult)
+	results.Post("/:tenantID/:token/c2b/timeout", mpesaVerifier.MpesaCallbackURLVerification(), h.HandleStatusTimeout)
+
+	group := app.Group("/api/v1/tenant/payments/c2b")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Post("/register", middleware.CanManageSettings(), h.RegisterURLs)
+
+	return group
+}
@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"invoicefast/internal/handlers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ClientPortalRoutes configures /api/v1/portal, the client self-service portal.
+// Login endpoints are public and rate limited; the rest need a portal session token.
+func ClientPortalRoutes(app *fiber.App, h *handlers.ClientPortalHandler, sensitiveRateLimit fiber.Handler) fiber.Router {
+	portal := app.Group("/api/v1/portal")
+
+	portal.Post("/login", sensitiveRateLimit, h.RequestLogin)
+	portal.Post("/verify", sensitiveRateLimit, h.Verify)
+
+	auth := h.RequireSession
+	portal.Post("/logout", auth, h.Logout)
+	portal.Get("/profile", auth, h.GetProfile)
+	portal.Put("/profile", auth, h.UpdateProfile)
+	portal.Get("/invoices", auth, h.ListInvoices)
+	portal.Get("/invoices/:id", auth, h.GetInvoice)
+	portal.Get("/credit-notes", auth, h.ListCreditNotes)
+	portal.Get("/receipts", auth, h.ListReceipts)
+	portal.Get("/statement", auth, h.GetStatement)
+	portal.Post("/pay", auth, h.PayInvoices)
+	portal.Get("/pay/:checkout_id", auth, h.PaymentStatus)
+
+	return portal
+}
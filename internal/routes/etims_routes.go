@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ETIMSRoutes configures /api/v1/tenant/etims
+func ETIMSRoutes(app *fiber.App, h *handlers.ETIMSHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/etims")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+
+	group.Get("/device", h.GetDevice)
+	group.Post("/device/initialize", middleware.CanManageSettings(), h.InitializeDevice)
+
+	group.Get("/codes", h.ListCodes)
+	group.Post("/codes/sync", middleware.CanManageSettings(), h.SyncCodes)
+
+	group.Post("/items/:id/register", middleware.CanManageSettings(), h.RegisterItem)
+
+	group.Get("/purchases", h.ListPurchases)
+	group.Post("/purchases/import", middleware.CanEditInvoice(), h.ImportPurchases)
+	group.Post("/purchases/:id/accept", middleware.CanPostJournals(), h.AcceptPurchase)
+	group.Post("/purchases/:id/reject", middleware.CanPostJournals(), h.RejectPurchase)
+
+	group.Get("/stock", h.ListStockMovements)
+	group.Post("/stock", middleware.CanEditInvoice(), h.RecordStockMovement)
+	group.Post("/stock/report", middleware.CanEditInvoice(), h.ReportPendingStock)
+
+	return group
+}
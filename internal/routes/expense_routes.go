@@ -24,6 +24,7 @@ func ExpenseRoutes(app *fiber.App, h *handlers.ExpenseHandler, authService *serv
 	group.Get("/:id", h.GetExpense)
 	group.Put("/:id", h.UpdateExpense)
 	group.Delete("/:id", h.DeleteExpense)
+	group.Post("/:id/verify-etims", h.VerifyExpense)
 	
 	// Expense attachment routes
 	group.Post("/:id/attachments", h.UploadExpenseAttachment)
@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FXRoutes configures /api/v1/tenant/fx
+func FXRoutes(app *fiber.App, h *handlers.FXHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/fx")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Use(middleware.CanViewReports())
+
+	// Exchange rates
+	group.Get("/rates", h.ListRates)
+	group.Post("/rates/overrides", middleware.CanPostJournals(), h.SetOverride)
+	group.Delete("/rates/overrides/:id", middleware.CanPostJournals(), h.DeleteOverride)
+
+	// Month-end revaluation
+	group.Get("/revaluations", h.ListRevaluations)
+	group.Get("/revaluations/:id", h.GetRevaluation)
+	group.Post("/revaluations", middleware.CanPostJournals(), h.RunRevaluation)
+
+	return group
+}
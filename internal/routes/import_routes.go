@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ImportRoutes configures /api/v1/tenant/imports
+func ImportRoutes(app *fiber.App, h *handlers.ImportHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/imports")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Use(middleware.RequireOwnerOrAdmin())
+
+	group.Get("/", h.ListImports)
+	group.Post("/", h.Upload)
+	group.Get("/fields/:entity", h.Fields)
+	group.Get("/:id", h.GetImport)
+	group.Post("/:id/validate", h.Validate)
+	group.Post("/:id/commit", h.Commit)
+
+	return group
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LedgerRoutes configures /api/v1/tenant/ledger
+func LedgerRoutes(app *fiber.App, h *handlers.LedgerHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/ledger")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Use(middleware.CanViewReports())
+
+	// Chart of accounts
+	group.Get("/accounts", h.ListAccounts)
+	group.Post("/accounts", middleware.CanPostJournals(), h.CreateAccount)
+	group.Put("/accounts/:id", middleware.CanPostJournals(), h.UpdateAccount)
+	group.Post("/accounts/:id/system-key", middleware.CanPostJournals(), h.AssignSystemKey)
+
+	// Journal entries
+	group.Get("/journals", h.ListJournals)
+	group.Get("/journals/:id", h.GetJournal)
+	group.Post("/journals", middleware.CanPostJournals(), h.CreateJournal)
+	group.Post("/journals/:id/reverse", middleware.CanPostJournals(), h.ReverseJournal)
+
+	// Reports
+	group.Get("/trial-balance", h.TrialBalance)
+	group.Get("/balance-sheet", h.BalanceSheet)
+	group.Get("/general-ledger", h.GeneralLedger)
+
+	return group
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NumberingRoutes configures /api/v1/tenant/numbering
+func NumberingRoutes(app *fiber.App, h *handlers.NumberingHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/numbering")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+
+	group.Get("/", h.ListSchemes)
+	group.Put("/:documentType", middleware.CanManageSettings(), h.UpdateScheme)
+
+	return group
+}
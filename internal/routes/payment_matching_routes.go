@@ -16,6 +16,8 @@ func PaymentMatchingRoutes(app *fiber.App, h *handlers.PaymentMatchingHandler, a
 
 	group.Post("/request", h.RequestPayment)
 	group.Post("/manual-match", h.ManualMatch)
+	group.Post("/allocate", h.AllocatePayment)
+	group.Get("/credit/:clientID", h.GetClientCredit)
 	group.Get("/unallocated", h.GetUnallocated)
 	group.Get("/stats", h.GetStats)
 	group.Get("/", h.GetPayments)
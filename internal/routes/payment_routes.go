@@ -19,6 +19,16 @@ func PaymentRoutes(app *fiber.App, h *handlers.PaymentHandler, idempotencySvc *s
 		mpesaVerifier.MpesaVerification(),
 		h.HandleMpesaCallback)
 
+	// Per-tenant callback path used by STK pushes; the legacy path above resolves the tenant from the push
+	group.Post("/mpesa/:tenantID",
+		middleware.IdempotencyMiddleware(idempotencySvc),
+		mpesaVerifier.MpesaVerification(),
+		h.HandleMpesaCallback)
+
+	// Transaction Status results for STK pushes whose callback never arrived (MPESA_RESULT_URL)
+	group.Post("/mpesa/result/:tenantID/:token/status", mpesaVerifier.MpesaCallbackURLVerification(), h.HandleMpesaStatusResult)
+	group.Post("/mpesa/result/:tenantID/:token/timeout", mpesaVerifier.MpesaCallbackURLVerification(), h.HandleMpesaStatusTimeout)
+
 	group.Post("/intasend",
 		middleware.IdempotencyMiddleware(idempotencySvc),
 		mpesaVerifier.IntasendVerification(),
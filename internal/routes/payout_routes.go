@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PayoutRoutes configures M-Pesa B2C payouts and the Daraja result callbacks
+// (MPESA_RESULT_URL/:tenantID/:token/b2c)
+func PayoutRoutes(app *fiber.App, h *handlers.PayoutHandler, authService *services.AuthService, db *database.DB, rateLimiter *middleware.FiberRateLimiter, mpesaVerifier *middleware.WebhookVerifierMiddleware) fiber.Router {
+	hooks := app.Group("/api/v1/webhook/mpesa/result")
+	hooks.Use(rateLimiter.WebhookRateLimiter())
+	hooks.Post("/:tenantID/:token/b2c", mpesaVerifier.MpesaCallbackURLVerification(), h.HandleB2CResult)
+	hooks.Post("/:tenantID/:token/b2c/timeout", mpesaVerifier.MpesaCallbackURLVerification(), h.HandleB2CTimeout)
+	hooks.Post("/:tenantID/:token/b2c/status", mpesaVerifier.MpesaCallbackURLVerification(), h.HandleStatusResult)
+	hooks.Post("/:tenantID/:token/b2c/status/timeout", mpesaVerifier.MpesaCallbackURLVerification(), h.HandleStatusTimeout)
+
+	group := app.Group("/api/v1/tenant/payouts")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Get("/", h.ListPayouts)
+	group.Get("/:id", h.GetPayout)
+	group.Post("/", middleware.CanEditInvoice(), h.RequestPayout)
+	group.Post("/:id/approve", middleware.CanApprovePayouts(), h.ApprovePayout)
+	group.Post("/:id/reject", middleware.CanApprovePayouts(), h.RejectPayout)
+
+	return group
+}
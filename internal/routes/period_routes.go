@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PeriodRoutes configures /api/v1/tenant/periods
+func PeriodRoutes(app *fiber.App, h *handlers.PeriodHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/periods")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Use(middleware.CanViewReports())
+
+	group.Get("/fiscal-year", h.GetFiscalYear)
+	group.Put("/fiscal-year", middleware.CanClosePeriods(), h.SetFiscalYear)
+
+	group.Get("/", h.ListPeriods)
+	group.Post("/:period/close", middleware.CanClosePeriods(), h.ClosePeriod)
+	group.Post("/:period/lock", middleware.CanClosePeriods(), h.LockPeriod)
+	group.Post("/:period/reopen", middleware.CanClosePeriods(), h.ReopenPeriod)
+
+	return group
+}
@@ -26,6 +26,7 @@ func PublicRoutes(app *fiber.App, h *handlers.PublicHandler) fiber.Router {
 	public.Get("/pay/:token", h.ServePortal)
 	public.Get("/invoice/:token", h.ServePortal)
 	public.Get("/invoice/:token/success", h.ServeSuccess)
+	public.Get("/quote/:token", h.ServeQuotePortal)
 
 	// Email tracking endpoints (public - no auth required)
 	public.Get("/api/track/open/:trackingId", h.TrackOpen)
@@ -41,6 +42,9 @@ func PublicAPIRoutes(app *fiber.App, h *handlers.PublicHandler) fiber.Router {
 	api.Get("/invoice/:token", h.GetInvoiceByToken)
 	api.Get("/invoice/:token/pdf", h.GetInvoicePDF)
 	api.Get("/invoice/:token/receipt.pdf", h.GetInvoiceReceipt)
+	api.Get("/quote/:token", h.ServeQuotePortal)
+	api.Post("/quote/:token/accept", h.AcceptQuote)
+	api.Post("/quote/:token/decline", h.DeclineQuote)
 	api.Post("/payment/stk-push", h.InitiateSTKPush)
 	api.Get("/payment/status/:token", h.CheckPaymentStatus)
 	api.Get("/pricing", h.GetPricing)
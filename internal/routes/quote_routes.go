@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// QuoteRoutes configures /api/v1/tenant/quotes
+func QuoteRoutes(app *fiber.App, h *handlers.QuoteHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/quotes")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+
+	group.Get("/", h.ListQuotes)
+	group.Post("/", h.CreateQuote)
+	group.Get("/:id", h.GetQuote)
+	group.Put("/:id", h.UpdateQuote)
+	group.Delete("/:id", h.DeleteQuote)
+	group.Post("/:id/send", h.SendQuote)
+	group.Post("/:id/convert", h.ConvertQuote)
+
+	return group
+}
@@ -30,6 +30,7 @@ func ReportRoutes(app *fiber.App, h *handlers.ReportHandler, authService *servic
 	group.Get("/payments", h.GetPayments)
 	group.Get("/clients", h.GetClients)
 	group.Get("/expenses", h.GetExpensesReport)
+	group.Get("/quotes", h.GetQuoteConversion)
 
 	// Client Reports
 	group.Get("/clients/revenue", h.GetClientRevenue)
@@ -42,6 +43,9 @@ func ReportRoutes(app *fiber.App, h *handlers.ReportHandler, authService *servic
 	// Aging & Tax
 	group.Get("/tax", h.GetTax)
 	group.Get("/vat", h.GetVATReport)
+	group.Get("/vat/filings", h.ListVATFilings)
+	group.Post("/vat/vat3", middleware.CanClosePeriods(), h.ExportVAT3)
+	group.Get("/withholding", h.GetWithholdingReport)
 	group.Get("/aging", h.GetAging)
 	group.Get("/aging-detailed", h.GetAgingDetailed)
 
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TaxRateRoutes configures /api/v1/tenant/tax-rates
+func TaxRateRoutes(app *fiber.App, h *handlers.TaxRateHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/tax-rates")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+
+	group.Get("/", h.ListRates)
+	group.Get("/:code/on", h.GetRateOn)
+	group.Post("/", middleware.CanManageSettings(), h.CreateRate)
+	group.Patch("/:id", middleware.CanManageSettings(), h.UpdateRate)
+
+	return group
+}
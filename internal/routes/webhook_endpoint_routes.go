@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WebhookEndpointRoutes configures /api/v1/tenant/webhooks
+func WebhookEndpointRoutes(app *fiber.App, h *handlers.WebhookEndpointHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/webhooks")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Use(middleware.RequireOwnerOrAdmin())
+
+	group.Get("/", h.ListEndpoints)
+	group.Post("/", h.CreateEndpoint)
+	group.Get("/deliveries", h.ListDeliveries)
+	group.Post("/deliveries/:id/replay", h.ReplayDelivery)
+	group.Put("/:id", h.UpdateEndpoint)
+	group.Delete("/:id", h.DeleteEndpoint)
+	group.Post("/:id/rotate-secret", h.RotateSecret)
+	group.Get("/:id/deliveries", h.ListDeliveries)
+
+	return group
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"invoicefast/internal/database"
+	"invoicefast/internal/handlers"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WithholdingRoutes configures /api/v1/tenant/withholding
+func WithholdingRoutes(app *fiber.App, h *handlers.WithholdingHandler, authService *services.AuthService, db *database.DB) fiber.Router {
+	group := app.Group("/api/v1/tenant/withholding")
+	group.Use(middleware.TenantMiddleware(authService, db))
+	group.Use(middleware.RequireEmailVerified(db))
+	group.Use(middleware.CanViewReports())
+
+	group.Get("/certificates", h.ListCertificates)
+	group.Post("/certificates/:id/receive", middleware.CanEditInvoice(), h.ReceiveCertificate)
+
+	return group
+}
@@ -0,0 +1,369 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============================================================================
+// ACCOUNTING PERIODS - fiscal year and month-end close
+// ============================================================================
+//
+// Periods are calendar months keyed "2006-01" in UTC. A month is open until an
+// admin closes it; while closed, invoices, credit notes, payments, expenses and
+// journals dated inside it can't be created, changed or deleted. A closed month
+// can be reopened with a reason; a locked one can't. Only months that have
+// ended can be closed, so the current month is always open and payments
+// arriving from M-Pesa are never turned away.
+
+var (
+	ErrPeriodClosed     = errors.New("accounting period is closed")
+	ErrPeriodInvalid    = errors.New("period must be YYYY-MM")
+	ErrPeriodNotEnded   = errors.New("a period can only be closed or locked after it ends")
+	ErrPeriodLocked     = errors.New("accounting period is locked and can't be reopened")
+	ErrPeriodNotClosed  = errors.New("accounting period is not closed")
+	ErrPeriodReason     = errors.New("a reason is required to reopen a period")
+	ErrFiscalYearStart  = errors.New("start_month must be between 1 and 12")
+	ErrFiscalYearFormat = errors.New("fiscal_year must be the year the fiscal year starts in")
+)
+
+const periodLayout = "2006-01"
+
+// PeriodService keeps the fiscal year settings and the open/closed state of each month
+type PeriodService struct {
+	db *database.DB
+}
+
+func NewPeriodService(db *database.DB) *PeriodService {
+	return &PeriodService{db: db}
+}
+
+// PeriodKey is the period a date falls in
+func PeriodKey(t time.Time) string {
+	return t.UTC().Format(periodLayout)
+}
+
+func parsePeriod(period string) (time.Time, error) {
+	start, err := time.Parse(periodLayout, period)
+	if err != nil {
+		return time.Time{}, ErrPeriodInvalid
+	}
+	return start, nil
+}
+
+// ============================================================================
+// FISCAL YEAR
+// ============================================================================
+
+// GetFiscalYear returns the tenant's fiscal year settings, defaulting to a January start
+func (s *PeriodService) GetFiscalYear(tenantID string) (*models.FiscalYearConfig, error) {
+	var config models.FiscalYearConfig
+	err := s.db.Scopes(database.TenantFilter(tenantID)).First(&config).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.FiscalYearConfig{TenantID: tenantID, StartMonth: 1}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fiscal year: %w", err)
+	}
+	return &config, nil
+}
+
+// SetFiscalYear changes the month the tenant's fiscal year starts in. It is
+// refused while a numbering scheme resets on the calendar year and the fiscal
+// year would no longer start in January.
+func (s *PeriodService) SetFiscalYear(tenantID, userID string, startMonth int) (*models.FiscalYearConfig, error) {
+	if startMonth < 1 || startMonth > 12 {
+		return nil, ErrFiscalYearStart
+	}
+	config, err := s.GetFiscalYear(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	previous := config.StartMonth
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// A sequence that resets on {YYYY} only stays unique while the fiscal
+		// year is the calendar year
+		var sequences []models.InvoiceSequence
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(database.TenantFilter(tenantID)).
+			Where("reset_yearly = ?", true).Find(&sequences).Error; err != nil {
+			return err
+		}
+		for i := range sequences {
+			if err := validateSequenceReset(&sequences[i], startMonth); err != nil {
+				return err
+			}
+		}
+
+		if config.ID == "" {
+			config.ID = uuid.New().String()
+			config.StartMonth = startMonth
+			config.UpdatedBy = userID
+			if err := tx.Create(config).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(config).Updates(map[string]interface{}{
+			"start_month": startMonth,
+			"updated_by":  userID,
+		}).Error; err != nil {
+			return err
+		}
+		config.StartMonth = startMonth
+		config.UpdatedBy = userID
+
+		return tx.Create(periodAuditLog(tenantID, userID, AuditActionSettingsUpdate, AuditEntitySettings, config.ID, map[string]interface{}{
+			"fiscal_year_start_month": startMonth,
+			"previous_start_month":    previous,
+		})).Error
+	})
+	if errors.Is(err, ErrNumberingReset) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update fiscal year: %w", err)
+	}
+	return config, nil
+}
+
+// fiscalYearLabel names the fiscal year starting in startYear: FY2026, or FY2025/26
+// when it doesn't start in January
+func fiscalYearLabel(startYear, startMonth int) string {
+	if startMonth == 1 {
+		return fmt.Sprintf("FY%d", startYear)
+	}
+	return fmt.Sprintf("FY%d/%02d", startYear, (startYear+1)%100)
+}
+
+// fiscalYearOf returns the year the fiscal year containing t starts in
+func fiscalYearOf(t time.Time, startMonth int) int {
+	t = t.UTC()
+	if int(t.Month()) >= startMonth {
+		return t.Year()
+	}
+	return t.Year() - 1
+}
+
+// describePeriod fills in the fiscal year fields of a period
+func describePeriod(p *models.AccountingPeriod, start time.Time, startMonth int) {
+	year := fiscalYearOf(start, startMonth)
+	p.FiscalYear = fiscalYearLabel(year, startMonth)
+	p.PeriodNumber = (int(start.Month())-startMonth+12)%12 + 1
+	p.StartDate = start
+	p.EndDate = start.AddDate(0, 1, -1)
+	if p.Status == "" {
+		p.Status = models.PeriodOpen
+	}
+}
+
+// ============================================================================
+// PERIODS
+// ============================================================================
+
+// ListPeriods returns the twelve months of a fiscal year, identified by the
+// year it starts in (0 for the current one)
+func (s *PeriodService) ListPeriods(tenantID string, fiscalYear int) ([]models.AccountingPeriod, error) {
+	config, err := s.GetFiscalYear(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if fiscalYear == 0 {
+		fiscalYear = fiscalYearOf(time.Now(), config.StartMonth)
+	}
+	if fiscalYear < 1900 || fiscalYear > 9998 {
+		return nil, ErrFiscalYearFormat
+	}
+
+	first := time.Date(fiscalYear, time.Month(config.StartMonth), 1, 0, 0, 0, 0, time.UTC)
+	keys := make([]string, 12)
+	for i := range keys {
+		keys[i] = first.AddDate(0, i, 0).Format(periodLayout)
+	}
+
+	var rows []models.AccountingPeriod
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Where("period IN ?", keys).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	saved := make(map[string]models.AccountingPeriod, len(rows))
+	for _, row := range rows {
+		saved[row.Period] = row
+	}
+
+	periods := make([]models.AccountingPeriod, 12)
+	for i, key := range keys {
+		p, ok := saved[key]
+		if !ok {
+			p = models.AccountingPeriod{TenantID: tenantID, Period: key}
+		}
+		describePeriod(&p, first.AddDate(0, i, 0), config.StartMonth)
+		periods[i] = p
+	}
+	return periods, nil
+}
+
+// ClosePeriod closes an ended month to changes
+func (s *PeriodService) ClosePeriod(tenantID, userID, period string) (*models.AccountingPeriod, error) {
+	return s.setStatus(tenantID, userID, period, models.PeriodClosed, "")
+}
+
+// LockPeriod closes a month for good; it can't be reopened
+func (s *PeriodService) LockPeriod(tenantID, userID, period string) (*models.AccountingPeriod, error) {
+	return s.setStatus(tenantID, userID, period, models.PeriodLocked, "")
+}
+
+// ReopenPeriod reopens a closed (not locked) month so corrections can be made
+func (s *PeriodService) ReopenPeriod(tenantID, userID, period, reason string) (*models.AccountingPeriod, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrPeriodReason
+	}
+	return s.setStatus(tenantID, userID, period, models.PeriodOpen, reason)
+}
+
+func (s *PeriodService) setStatus(tenantID, userID, period, status, reason string) (*models.AccountingPeriod, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	start, err := parsePeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	if status != models.PeriodOpen && time.Now().Before(start.AddDate(0, 1, 0)) {
+		return nil, ErrPeriodNotEnded
+	}
+	config, err := s.GetFiscalYear(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var row models.AccountingPeriod
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(database.TenantFilter(tenantID)).
+			First(&row, "period = ?", period).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			row = models.AccountingPeriod{ID: uuid.New().String(), TenantID: tenantID, Period: period, Status: models.PeriodOpen}
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{"status": status}
+		action := AuditActionPeriodClose
+		switch status {
+		case models.PeriodClosed:
+			if row.Status != models.PeriodOpen {
+				return fmt.Errorf("%w: %s", ErrPeriodClosed, period)
+			}
+			updates["closed_by"], updates["closed_at"] = userID, now
+		case models.PeriodLocked:
+			if row.Status == models.PeriodLocked {
+				return ErrPeriodLocked
+			}
+			action = AuditActionPeriodLock
+			updates["locked_by"], updates["locked_at"] = userID, now
+			if row.Status == models.PeriodOpen {
+				updates["closed_by"], updates["closed_at"] = userID, now
+			}
+		case models.PeriodOpen:
+			if row.Status == models.PeriodLocked {
+				return ErrPeriodLocked
+			}
+			if row.Status != models.PeriodClosed {
+				return ErrPeriodNotClosed
+			}
+			action = AuditActionPeriodReopen
+			updates["reopened_by"], updates["reopened_at"], updates["reopen_reason"] = userID, now, reason
+		}
+
+		previous := row.Status
+		if row.CreatedAt.IsZero() {
+			if err := tx.Create(&row).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&row).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		details := map[string]interface{}{"period": period, "from": previous, "to": status}
+		if reason != "" {
+			details["reason"] = reason
+		}
+		return tx.Create(periodAuditLog(tenantID, userID, action, AuditEntityPeriod, row.ID, details)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.First(&row, "id = ?", row.ID).Error; err != nil {
+		return nil, err
+	}
+	describePeriod(&row, start, config.StartMonth)
+	return &row, nil
+}
+
+func periodAuditLog(tenantID, userID, action, entityType, entityID string, details map[string]interface{}) *models.AuditLog {
+	detailsJSON, _ := json.Marshal(details)
+	return &models.AuditLog{
+		ID:         uuid.New().String(),
+		TenantID:   tenantID,
+		UserID:     userID,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Details:    string(detailsJSON),
+		CreatedAt:  time.Now(),
+	}
+}
+
+// ============================================================================
+// POSTING GUARD
+// ============================================================================
+
+// CheckOpen returns ErrPeriodClosed if any of the dates falls in a closed or
+// locked period. Zero dates are ignored. A nil service allows everything, so
+// services work without period control wired in.
+func (s *PeriodService) CheckOpen(tenantID string, dates ...time.Time) error {
+	if s == nil {
+		return nil
+	}
+	return s.CheckOpenTx(s.db.DB, tenantID, dates...)
+}
+
+// CheckOpenTx is CheckOpen inside the caller's transaction
+func (s *PeriodService) CheckOpenTx(tx *gorm.DB, tenantID string, dates ...time.Time) error {
+	if s == nil || tenantID == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(dates))
+	for _, d := range dates {
+		if !d.IsZero() {
+			keys = append(keys, PeriodKey(d))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var closed []string
+	if err := tx.Model(&models.AccountingPeriod{}).Scopes(database.TenantFilter(tenantID)).
+		Where("period IN ? AND status <> ?", keys, models.PeriodOpen).
+		Order("period").Pluck("period", &closed).Error; err != nil {
+		return fmt.Errorf("failed to check accounting period: %w", err)
+	}
+	if len(closed) > 0 {
+		return fmt.Errorf("%w: %s", ErrPeriodClosed, closed[0])
+	}
+	return nil
+}
@@ -45,6 +45,9 @@ const (
 	AuditActionSettingsUpdate  = "settings_update"
 	AuditActionAPIAccess       = "api_access"
 	AuditActionSecurityEvent   = "security_event"
+	AuditActionPeriodClose     = "period_close"
+	AuditActionPeriodLock      = "period_lock"
+	AuditActionPeriodReopen    = "period_reopen"
 )
 
 // AuditEntity types
@@ -55,6 +58,7 @@ const (
 	AuditEntityClient   = "client"
 	AuditEntitySettings = "settings"
 	AuditEntityAPI      = "api"
+	AuditEntityPeriod   = "accounting_period"
 )
 
 // LogAction records an audit log entry
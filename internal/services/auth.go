@@ -5,7 +5,6 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
@@ -677,65 +676,6 @@ func (s *AuthService) Logout(refreshToken string) error {
 	return s.db.Where("token = ?", tokenHash).Delete(&models.RefreshToken{}).Error
 }
 
-func (s *AuthService) GenerateAPIKey(userID, keyName string) (string, error) {
-	if strings.TrimSpace(keyName) == "" {
-		keyName = "Default"
-	}
-
-	bytes := make([]byte, 32)
-	rand.Read(bytes)
-	apiKey := "if_sk_" + base64.URLEncoding.EncodeToString(bytes)
-
-	hash := sha256.Sum256([]byte(apiKey))
-	keyHash := fmt.Sprintf("%x", hash[:])
-
-	apiKeyModel := &models.APIKey{
-		ID:        uuid.New().String(),
-		UserID:    userID,
-		Name:      strings.TrimSpace(keyName),
-		Key:       apiKey,
-		KeyHash:   keyHash,
-		IsActive:  true,
-		ExpiresAt: time.Now().AddDate(1, 0, 0),
-	}
-
-	if err := s.db.Create(apiKeyModel).Error; err != nil {
-		return "", fmt.Errorf("failed to create API key: %w", err)
-	}
-
-	// Clear raw key from DB after storing hash
-	if err := s.db.Model(apiKeyModel).Update("key", "").Error; err != nil {
-		return "", fmt.Errorf("failed to clear raw key: %w", err)
-	}
-
-	return apiKey, nil
-}
-
-func (s *AuthService) ValidateAPIKey(tenantID, apiKey string) (*models.User, error) {
-	if tenantID == "" {
-		return nil, errors.New("tenant_id is required")
-	}
-	if strings.TrimSpace(apiKey) == "" {
-		return nil, errors.New("API key is required")
-	}
-
-	hash := sha256.Sum256([]byte(apiKey))
-	keyHash := fmt.Sprintf("%x", hash[:])
-
-	var key models.APIKey
-	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&key, "key_hash = ? AND is_active = ? AND (expires_at IS NULL OR expires_at > ?)", keyHash, true, time.Now()).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("invalid API key")
-		}
-		return nil, fmt.Errorf("failed to validate API key: %w", err)
-	}
-
-	key.LastUsedAt = sql.NullTime{Time: time.Now(), Valid: true}
-	s.db.Save(&key)
-
-	return s.GetUserByID(tenantID, key.UserID)
-}
-
 type RegisterRequest struct {
 	Email       string `json:"email" binding:"required,email"`
 	Password    string `json:"password" binding:"required"`
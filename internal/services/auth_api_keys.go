@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix marks InvoiceFast secret keys so they can be told apart from JWTs
+const APIKeyPrefix = "if_sk_"
+
+var (
+	ErrAPIKeyInvalid  = errors.New("invalid API key")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// validAPIKeyScopes lists the scopes a key can be issued with
+var validAPIKeyScopes = map[string]bool{
+	models.APIKeyScopeReadOnly:      true,
+	models.APIKeyScopeInvoicesRead:  true,
+	models.APIKeyScopeInvoicesWrite: true,
+	models.APIKeyScopeClientsRead:   true,
+	models.APIKeyScopeClientsWrite:  true,
+	models.APIKeyScopePaymentsRead:  true,
+	models.APIKeyScopePaymentsWrite: true,
+	models.APIKeyScopeExpensesRead:  true,
+	models.APIKeyScopeExpensesWrite: true,
+	models.APIKeyScopeReportsRead:   true,
+}
+
+// CreateAPIKeyRequest holds the fields for issuing an API key
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // default 365
+	RateLimit     int      `json:"rate_limit"`      // requests per minute, 0 = default
+}
+
+// RotateAPIKeyRequest controls how long the old key keeps working after rotation
+type RotateAPIKeyRequest struct {
+	GracePeriodHours int `json:"grace_period_hours"`
+}
+
+// APIKeyScopes splits the stored comma separated scope list
+func APIKeyScopes(key *models.APIKey) []string {
+	if key == nil || key.Scopes == "" {
+		return nil
+	}
+	return strings.Split(key.Scopes, ",")
+}
+
+func normalizeAPIKeyScopes(scopes []string) (string, error) {
+	if len(scopes) == 0 {
+		return models.APIKeyScopeReadOnly, nil
+	}
+	seen := make(map[string]bool, len(scopes))
+	out := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !validAPIKeyScopes[scope] {
+			return "", fmt.Errorf("invalid scope: %s", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			out = append(out, scope)
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+func hashAPIKey(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return fmt.Sprintf("%x", hash[:])
+}
+
+func newRawAPIKey() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// CreateAPIKey issues a new key for the tenant. The raw key is returned once and
+// only its hash is stored.
+func (s *AuthService) CreateAPIKey(tenantID, userID string, req *CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	if tenantID == "" {
+		return nil, "", ErrTenantRequired
+	}
+	if req == nil {
+		req = &CreateAPIKeyRequest{}
+	}
+
+	scopes, err := normalizeAPIKeyScopes(req.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if req.RateLimit < 0 {
+		return nil, "", errors.New("rate_limit cannot be negative")
+	}
+	expiresInDays := req.ExpiresInDays
+	if expiresInDays <= 0 {
+		expiresInDays = 365
+	}
+	if expiresInDays > 730 {
+		return nil, "", errors.New("API keys can be valid for at most 730 days")
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Default"
+	}
+
+	rawKey, err := newRawAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &models.APIKey{
+		ID:        uuid.New().String(),
+		TenantID:  tenantID,
+		UserID:    userID,
+		Name:      name,
+		Key:       rawKey[:len(APIKeyPrefix)+8],
+		KeyHash:   hashAPIKey(rawKey),
+		Scopes:    scopes,
+		RateLimit: req.RateLimit,
+		IsActive:  true,
+		ExpiresAt: time.Now().AddDate(0, 0, expiresInDays),
+	}
+
+	if err := s.db.Create(key).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	s.logAPIKeyEvent(tenantID, userID, "api_key_created", key)
+	return key, rawKey, nil
+}
+
+// GenerateAPIKey issues a read-only key for the user's tenant and returns the raw key
+func (s *AuthService) GenerateAPIKey(userID, keyName string) (string, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return "", fmt.Errorf("user not found: %w", err)
+	}
+	tenantID := user.TenantID
+	if tenantID == "" {
+		tenantID = user.ID
+	}
+	_, rawKey, err := s.CreateAPIKey(tenantID, userID, &CreateAPIKeyRequest{Name: keyName})
+	return rawKey, err
+}
+
+// ListAPIKeys returns the tenant's keys, newest first. Hashes are never serialized.
+func (s *AuthService) ListAPIKeys(tenantID string) ([]models.APIKey, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	var keys []models.APIKey
+	err := s.db.Scopes(database.TenantFilter(tenantID)).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey disables a key immediately
+func (s *AuthService) RevokeAPIKey(tenantID, userID, id string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	now := time.Now()
+	result := s.db.Model(&models.APIKey{}).
+		Scopes(database.TenantFilter(tenantID)).
+		Where("id = ? AND is_active = ?", id, true).
+		Updates(map[string]interface{}{
+			"is_active":  false,
+			"revoked_at": now,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	s.logAPIKeyEvent(tenantID, userID, "api_key_revoked", &models.APIKey{ID: id})
+	return nil
+}
+
+// RotateAPIKey issues a replacement key with the same name, scopes and limits. The
+// old key keeps working for the grace period (if any) so clients can switch over.
+func (s *AuthService) RotateAPIKey(tenantID, userID, id string, req *RotateAPIKeyRequest) (*models.APIKey, string, error) {
+	if tenantID == "" {
+		return nil, "", ErrTenantRequired
+	}
+	grace := 0
+	if req != nil {
+		grace = req.GracePeriodHours
+	}
+	if grace < 0 || grace > 168 {
+		return nil, "", errors.New("grace_period_hours must be between 0 and 168")
+	}
+
+	var old models.APIKey
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		First(&old, "id = ? AND is_active = ?", id, true).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrAPIKeyNotFound
+		}
+		return nil, "", err
+	}
+
+	days := int(time.Until(old.ExpiresAt).Hours()/24) + 1
+	if days < 1 || days > 365 {
+		days = 365
+	}
+
+	newKey, rawKey, err := s.CreateAPIKey(tenantID, userID, &CreateAPIKeyRequest{
+		Name:          old.Name,
+		Scopes:        APIKeyScopes(&old),
+		ExpiresInDays: days,
+		RateLimit:     old.RateLimit,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"replaced_by_id": newKey.ID,
+		"updated_at":     now,
+	}
+	if grace == 0 {
+		updates["is_active"] = false
+		updates["revoked_at"] = now
+	} else if cutoff := now.Add(time.Duration(grace) * time.Hour); cutoff.Before(old.ExpiresAt) {
+		updates["expires_at"] = cutoff
+	}
+	if err := s.db.Model(&models.APIKey{}).Where("id = ?", old.ID).Updates(updates).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to retire rotated key: %w", err)
+	}
+
+	s.logAPIKeyEvent(tenantID, userID, "api_key_rotated", newKey)
+	return newKey, rawKey, nil
+}
+
+// AuthenticateAPIKey resolves a raw key to its record and owning user. The key
+// itself identifies the tenant, so no tenant context is needed.
+func (s *AuthService) AuthenticateAPIKey(apiKey string) (*models.APIKey, *models.User, error) {
+	apiKey = strings.TrimSpace(apiKey)
+	if !strings.HasPrefix(apiKey, APIKeyPrefix) {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+
+	var key models.APIKey
+	if err := s.db.First(&key, "key_hash = ? AND is_active = ? AND expires_at > ?", hashAPIKey(apiKey), true, time.Now()).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrAPIKeyInvalid
+		}
+		return nil, nil, fmt.Errorf("failed to validate API key: %w", err)
+	}
+
+	user, err := s.GetUserByID(key.TenantID, key.UserID)
+	if err != nil || !user.IsActive {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+
+	// Only touch last_used_at once a minute to keep hot keys from writing on every request
+	if !key.LastUsedAt.Valid || time.Since(key.LastUsedAt.Time) > time.Minute {
+		now := time.Now()
+		s.db.Model(&models.APIKey{}).Where("id = ?", key.ID).UpdateColumn("last_used_at", now)
+		key.LastUsedAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	return &key, user, nil
+}
+
+// ValidateAPIKey checks a raw key belongs to the tenant and returns its user
+func (s *AuthService) ValidateAPIKey(tenantID, apiKey string) (*models.User, error) {
+	if tenantID == "" {
+		return nil, errors.New("tenant_id is required")
+	}
+	if strings.TrimSpace(apiKey) == "" {
+		return nil, errors.New("API key is required")
+	}
+
+	key, user, err := s.AuthenticateAPIKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if key.TenantID != tenantID {
+		return nil, ErrAPIKeyInvalid
+	}
+	return user, nil
+}
+
+func (s *AuthService) logAPIKeyEvent(tenantID, userID, event string, key *models.APIKey) {
+	if s.auditService == nil {
+		return
+	}
+	s.auditService.LogSecurityEvent(context.Background(), tenantID, event, "", map[string]interface{}{
+		"user_id":    userID,
+		"api_key_id": key.ID,
+		"key":        key.Key,
+		"scopes":     key.Scopes,
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"invoicefast/internal/models"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // ============================================================================
@@ -59,12 +60,16 @@ func (s *JobQueueService) EnqueueJobWithIdempotency(key string, job *models.Auto
 	return s.EnqueueJob(job)
 }
 
-// GetPendingJobs retrieves jobs ready to be processed
-func (s *JobQueueService) GetPendingJobs(limit int) ([]models.AutomationJob, error) {
+// GetPendingJobs retrieves jobs ready to be processed, optionally restricted to the given job types
+func (s *JobQueueService) GetPendingJobs(limit int, jobTypes ...string) ([]models.AutomationJob, error) {
 	var jobs []models.AutomationJob
 	now := time.Now()
-	err := s.db.Where("status = ? AND run_at <= ?", models.JobStatusPending, now).
-		Order("priority DESC, run_at ASC").
+	query := s.db.Where("status = ? AND run_at <= ?", models.JobStatusPending, now).
+		Where("next_retry_at IS NULL OR next_retry_at <= ?", now)
+	if len(jobTypes) > 0 {
+		query = query.Where("job_type IN ?", jobTypes)
+	}
+	err := query.Order("priority DESC, run_at ASC").
 		Limit(limit).
 		Find(&jobs).Error
 	return jobs, err
@@ -101,6 +106,43 @@ func (s *JobQueueService) ClaimJob(jobID string) error {
 	return result.Error
 }
 
+// ReleaseJob returns a claimed job to the queue without counting it as a failed attempt
+func (s *JobQueueService) ReleaseJob(jobID string) error {
+	return s.db.Model(&models.AutomationJob{}).
+		Where("id = ? AND status = ?", jobID, models.JobStatusProcessing).
+		Updates(map[string]interface{}{
+			"status":     models.JobStatusPending,
+			"started_at": nil,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// RenewJob extends the lease on a job still being processed so RequeueStaleJobs
+// does not hand it to another worker
+func (s *JobQueueService) RenewJob(jobID string) error {
+	now := time.Now()
+	return s.db.Model(&models.AutomationJob{}).
+		Where("id = ? AND status = ?", jobID, models.JobStatusProcessing).
+		Updates(map[string]interface{}{
+			"started_at": now,
+			"updated_at": now,
+		}).Error
+}
+
+// RequeueStaleJobs releases jobs left in processing by a worker that died mid-run
+func (s *JobQueueService) RequeueStaleJobs(olderThan time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-olderThan)
+	result := s.db.Model(&models.AutomationJob{}).
+		Where("status = ? AND started_at < ?", models.JobStatusProcessing, cutoff).
+		Updates(map[string]interface{}{
+			"status":     models.JobStatusPending,
+			"started_at": nil,
+			"last_error": "requeued after stalled run",
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
 // CompleteJob marks a job as completed
 func (s *JobQueueService) CompleteJob(jobID string, result string) error {
 	now := time.Now()
@@ -217,14 +259,6 @@ func (s *JobQueueService) GetJobStats(tenantID string) (map[string]interface{},
 // RECURRING INVOICE SERVICE - Handles recurring invoice automation
 // ============================================================================
 
-var autoInvoiceCounter int64
-
-func autoGenerateInvoiceNumber() string {
-	autoInvoiceCounter++
-	timestamp := time.Now().UnixNano() / 1000000
-	return fmt.Sprintf("INV-%d-%d", timestamp, autoInvoiceCounter)
-}
-
 func getFloat(m map[string]interface{}, key string, def float64) float64 {
 	if v, ok := m[key].(float64); ok {
 		return v
@@ -554,7 +588,6 @@ func (s *AutoRecurringInvoiceService) ProcessRecurringInvoice(job *models.Automa
 		TenantID:       job.TenantID,
 		UserID:         recurring.UserID,
 		ClientID:       recurring.ClientID,
-		InvoiceNumber: autoGenerateInvoiceNumber(),
 		Status:        models.InvoiceStatusDraft,
 		Subtotal:      subtotal,
 		TaxRate:       taxRate,
@@ -584,7 +617,16 @@ func (s *AutoRecurringInvoiceService) ProcessRecurringInvoice(job *models.Automa
 	invoice.Total = invoice.Subtotal.Add(invoice.TotalTax)
 	invoice.BalanceDue = invoice.Total
 	
-	if err := s.db.Create(invoice).Error; err != nil {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		number, seqNum, err := nextDocumentNumber(tx, job.TenantID, models.SequenceDocumentInvoice)
+		if err != nil {
+			return err
+		}
+		invoice.InvoiceNumber = number
+		invoice.SequenceNumber = seqNum
+		return tx.Create(invoice).Error
+	})
+	if err != nil {
 		return s.jobQueue.FailJob(job.ID, fmt.Sprintf("failed to create invoice: %v", err))
 	}
 	
@@ -189,6 +189,7 @@ func (s *AutoReminderService) GetReminderStats(tenantID string) (map[string]inte
 type AutoWorkflowService struct {
 	db        *database.DB
 	jobQueue *JobQueueService
+	webhooks *OutgoingWebhookService
 }
 
 // NewAutoWorkflowService creates a new workflow service
@@ -199,6 +200,12 @@ func NewAutoWorkflowService(db *database.DB, jobQueue *JobQueueService) *AutoWor
 	}
 }
 
+// SetWebhookService checks webhook action URLs against the outgoing webhook
+// rules when a workflow is saved
+func (s *AutoWorkflowService) SetWebhookService(webhooks *OutgoingWebhookService) {
+	s.webhooks = webhooks
+}
+
 // CreateWorkflowRequest defines request for creating workflow
 type CreateWorkflowRequest struct {
 	Name         string                    `json:"name"`
@@ -215,9 +222,67 @@ type UpdateWorkflowRequest struct {
 	Description *string                  `json:"description,omitempty"`
 	IsActive    *bool                    `json:"is_active,omitempty"`
 	Priority   *int                     `json:"priority,omitempty"`
+	TriggerEvent *string                 `json:"trigger_event,omitempty"`
+	Conditions *[]models.WorkflowCondition `json:"conditions,omitempty"`
 	Actions    *[]models.WorkflowAction `json:"actions,omitempty"`
 }
 
+var validTriggerEvents = map[string]bool{
+	models.TriggerEventInvoiceCreated:  true,
+	models.TriggerEventInvoiceSent:     true,
+	models.TriggerEventInvoicePaid:     true,
+	models.TriggerEventInvoiceOverdue:  true,
+	models.TriggerEventPaymentReceived: true,
+	models.TriggerEventPaymentFailed:   true,
+	models.TriggerEventFraudDetected:   true,
+	models.TriggerEventClientAdded:     true,
+	models.TriggerEventKRAAccepted:     true,
+	models.TriggerEventKRARejected:     true,
+}
+
+var validConditionOperators = map[string]bool{
+	ConditionEquals: true, ConditionNotEquals: true, ConditionContains: true,
+	ConditionGreaterThan: true, ConditionLessThan: true, ConditionIn: true, ConditionNotIn: true,
+}
+
+var validActionTypes = map[string]bool{
+	WorkflowActionSendEmail: true, WorkflowActionSendSMS: true, WorkflowActionSendWhatsApp: true,
+	WorkflowActionUpdateStatus: true, WorkflowActionWebhook: true, WorkflowActionNotifyAdmin: true,
+}
+
+// validateWorkflowDefinition rejects workflows the engine would not be able to
+// run, and webhook actions aimed anywhere outgoing webhooks may not go
+func validateWorkflowDefinition(trigger string, conditions []models.WorkflowCondition, actions []models.WorkflowAction, webhooks *OutgoingWebhookService) error {
+	if !validTriggerEvents[trigger] {
+		return fmt.Errorf("unsupported trigger event: %s", trigger)
+	}
+	for _, c := range conditions {
+		if c.Field == "" {
+			return fmt.Errorf("condition field is required")
+		}
+		if !validConditionOperators[c.Operator] {
+			return fmt.Errorf("unsupported condition operator: %s", c.Operator)
+		}
+	}
+	for _, a := range actions {
+		if !validActionTypes[a.ActionType] {
+			return fmt.Errorf("unsupported action type: %s", a.ActionType)
+		}
+		if a.Delay < 0 {
+			return fmt.Errorf("action delay cannot be negative")
+		}
+		if a.ActionType == WorkflowActionWebhook {
+			if webhooks == nil {
+				return fmt.Errorf("webhook actions are unavailable")
+			}
+			if err := webhooks.checkURL(configString(a.Config, "url", "")); err != nil {
+				return fmt.Errorf("invalid webhook action: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
 // GetWorkflows returns all workflows for a tenant
 func (s *AutoWorkflowService) GetWorkflows(tenantID string, activeOnly bool) ([]models.AutomationWorkflow, error) {
 	var workflows []models.AutomationWorkflow
@@ -244,6 +309,9 @@ func (s *AutoWorkflowService) CreateWorkflow(tenantID, userID string, req *Creat
 	if len(req.Actions) == 0 {
 		return nil, fmt.Errorf("at least one action is required")
 	}
+	if err := validateWorkflowDefinition(req.TriggerEvent, req.Conditions, req.Actions, s.webhooks); err != nil {
+		return nil, err
+	}
 	
 	conditionsJSON, _ := json.Marshal(req.Conditions)
 	actionsJSON, _ := json.Marshal(req.Actions)
@@ -289,6 +357,31 @@ func (s *AutoWorkflowService) UpdateWorkflow(tenantID, id string, req *UpdateWor
 	if req.Priority != nil {
 		workflow.Priority = *req.Priority
 	}
+	if req.TriggerEvent != nil || req.Conditions != nil || req.Actions != nil {
+		trigger := workflow.TriggerEvent
+		if req.TriggerEvent != nil {
+			trigger = *req.TriggerEvent
+		}
+		var conditions []models.WorkflowCondition
+		if req.Conditions != nil {
+			conditions = *req.Conditions
+		}
+		var actions []models.WorkflowAction
+		if req.Actions != nil {
+			if len(*req.Actions) == 0 {
+				return nil, fmt.Errorf("at least one action is required")
+			}
+			actions = *req.Actions
+		}
+		if err := validateWorkflowDefinition(trigger, conditions, actions, s.webhooks); err != nil {
+			return nil, err
+		}
+		workflow.TriggerEvent = trigger
+	}
+	if req.Conditions != nil {
+		conditionsJSON, _ := json.Marshal(req.Conditions)
+		workflow.Conditions = string(conditionsJSON)
+	}
 	if req.Actions != nil {
 		actionsJSON, _ := json.Marshal(req.Actions)
 		workflow.Actions = string(actionsJSON)
@@ -312,6 +405,37 @@ func (s *AutoWorkflowService) DeleteWorkflow(tenantID, id string) error {
 	return s.db.Delete(workflow).Error
 }
 
+// GetWorkflowRuns returns the execution audit trail of a workflow
+func (s *AutoWorkflowService) GetWorkflowRuns(tenantID, id string, limit int) ([]models.AutomationAuditLog, error) {
+	if _, err := s.GetWorkflow(tenantID, id); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	var runs []models.AutomationAuditLog
+	err := s.db.Where("tenant_id = ? AND actor_type = ? AND actor_id = ?", tenantID, "automation", id).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&runs).Error
+	return runs, err
+}
+
+// TestWorkflowConditions evaluates a workflow's conditions against a sample payload without running actions
+func (s *AutoWorkflowService) TestWorkflowConditions(tenantID, id string, payload map[string]interface{}) (bool, error) {
+	workflow, err := s.GetWorkflow(tenantID, id)
+	if err != nil {
+		return false, err
+	}
+	var conditions []models.WorkflowCondition
+	if workflow.Conditions != "" && workflow.Conditions != "null" {
+		if err := json.Unmarshal([]byte(workflow.Conditions), &conditions); err != nil {
+			return false, fmt.Errorf("invalid conditions: %w", err)
+		}
+	}
+	return EvaluateWorkflowConditions(conditions, payload), nil
+}
+
 // GetWorkflowStats returns workflow statistics
 func (s *AutoWorkflowService) GetWorkflowStats(tenantID string) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
@@ -0,0 +1,642 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// BANK RECONCILIATION - match statement lines to payments, deposits and expenses
+// ============================================================================
+
+const (
+	// bankMatchWindow is how far a book entry's date may be from the statement
+	// line; bank transfers can take a few working days to clear
+	bankMatchWindow = 5 * 24 * time.Hour
+	// bankAutoMatchDays is how close a lone amount-only candidate must be to be matched without review
+	bankAutoMatchDays   = 2
+	maxBankSuggestions  = 5
+	bankReferenceMinLen = 4
+)
+
+var (
+	ErrBankStatementNotFound = errors.New("bank statement not found")
+	ErrBankLineNotFound      = errors.New("statement line not found")
+	ErrBankStatementFormat   = errors.New("only CSV, OFX/QFX and MT940 statements can be imported")
+	ErrBankStatementEmpty    = errors.New("no transactions found in the statement")
+	ErrBankLineMatched       = errors.New("statement line is already matched")
+	ErrBankMatchInvalid      = errors.New("entry can't be matched to this line: the amount or direction differs, or it is already reconciled")
+)
+
+// BankImportOptions fill in statement details the file doesn't carry (most CSV exports)
+type BankImportOptions struct {
+	BankName      string `json:"bank_name"`
+	AccountNumber string `json:"account_number"`
+	Currency      string `json:"currency"`
+}
+
+// BankImportResult summarizes an imported statement and the first matching pass
+type BankImportResult struct {
+	Statement  *models.BankStatement `json:"statement"`
+	Imported   int                   `json:"imported"`
+	Duplicates int                   `json:"duplicates"`
+	BankMatchSummary
+}
+
+// BankMatchSummary counts the outcome of a matching pass
+type BankMatchSummary struct {
+	Matched   int `json:"matched"`
+	Suggested int `json:"suggested"`
+	Unmatched int `json:"unmatched"`
+}
+
+// BankBookEntry is a payment, unallocated deposit or expense that should appear on the bank statement
+type BankBookEntry struct {
+	Type        string       `json:"type"` // payment, unallocated_payment, expense
+	ID          string       `json:"id"`
+	Date        time.Time    `json:"date"`
+	Amount      models.Money `json:"amount"` // signed like statement lines: negative for expenses
+	Reference   string       `json:"reference"`
+	Description string       `json:"description"`
+	Score       int          `json:"score,omitempty"`
+	Reason      string       `json:"reason,omitempty"` // reference, amount_date
+}
+
+// BankReviewItem is a statement line with more than one possible match
+type BankReviewItem struct {
+	models.BankStatementLine
+	Suggestions []BankBookEntry `json:"suggestions"`
+}
+
+// BankReconciliationReport lists what doesn't agree between the statements and the books for a period
+type BankReconciliationReport struct {
+	From               time.Time                  `json:"from"`
+	To                 time.Time                  `json:"to"`
+	AccountNumber      string                     `json:"account_number,omitempty"`
+	StatementCredits   models.Money               `json:"statement_credits"`
+	StatementDebits    models.Money               `json:"statement_debits"`
+	MatchedLines       int                        `json:"matched_lines"`
+	IgnoredLines       int                        `json:"ignored_lines"`
+	UnmatchedLines     []models.BankStatementLine `json:"unmatched_lines"`
+	UnmatchedLineTotal models.Money               `json:"unmatched_line_total"`
+	UnmatchedEntries   []BankBookEntry            `json:"unmatched_entries"`
+	UnmatchedBookTotal models.Money               `json:"unmatched_book_total"`
+}
+
+// BankReconciliationService imports bank statements and reconciles them with the books
+type BankReconciliationService struct {
+	db *database.DB
+}
+
+func NewBankReconciliationService(db *database.DB) *BankReconciliationService {
+	return &BankReconciliationService{db: db}
+}
+
+// Import parses a statement file, skips lines already imported from an
+// earlier statement and matches the rest
+func (s *BankReconciliationService) Import(tenantID, userID, fileName string, data []byte, opts *BankImportOptions) (*BankImportResult, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	if len(data) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	if len(data) > maxImportFileSize {
+		return nil, fmt.Errorf("file exceeds %d MB", maxImportFileSize>>20)
+	}
+	if opts == nil {
+		opts = &BankImportOptions{}
+	}
+
+	format := statementFormat(fileName, data)
+	if format == "" {
+		return nil, ErrBankStatementFormat
+	}
+	parsed, err := parseStatement(format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &models.BankStatement{
+		ID:             uuid.New().String(),
+		TenantID:       tenantID,
+		UploadedBy:     userID,
+		FileName:       filepath.Base(fileName),
+		Format:         format,
+		BankName:       firstNonEmpty(strings.TrimSpace(opts.BankName), parsed.BankName),
+		AccountNumber:  firstNonEmpty(strings.TrimSpace(opts.AccountNumber), parsed.AccountNumber),
+		Currency:       strings.ToUpper(firstNonEmpty(strings.TrimSpace(opts.Currency), parsed.Currency, "KES")),
+		OpeningBalance: parsed.OpeningBalance,
+		ClosingBalance: parsed.ClosingBalance,
+	}
+
+	fingerprints := lineFingerprints(statement.AccountNumber, parsed.Lines)
+	existing := make(map[string]bool)
+	for start := 0; start < len(fingerprints); start += 500 {
+		var found []string
+		if err := s.db.Model(&models.BankStatementLine{}).
+			Where("tenant_id = ? AND fingerprint IN ?", tenantID, fingerprints[start:min(start+500, len(fingerprints))]).
+			Pluck("fingerprint", &found).Error; err != nil {
+			return nil, err
+		}
+		for _, fp := range found {
+			existing[fp] = true
+		}
+	}
+
+	var lines []models.BankStatementLine
+	for i, p := range parsed.Lines {
+		if statement.PeriodStart == nil || p.Date.Before(*statement.PeriodStart) {
+			d := p.Date
+			statement.PeriodStart = &d
+		}
+		if statement.PeriodEnd == nil || p.Date.After(*statement.PeriodEnd) {
+			d := p.Date
+			statement.PeriodEnd = &d
+		}
+		if existing[fingerprints[i]] {
+			statement.DuplicateCount++
+			continue
+		}
+		lines = append(lines, models.BankStatementLine{
+			ID:            uuid.New().String(),
+			TenantID:      tenantID,
+			StatementID:   statement.ID,
+			LineNumber:    i + 1,
+			AccountNumber: statement.AccountNumber,
+			Date:          p.Date,
+			ValueDate:     p.ValueDate,
+			Amount:        p.Amount,
+			Description:   p.Description,
+			Reference:     p.Reference,
+			BankReference: p.BankReference,
+			Balance:       p.Balance,
+			Fingerprint:   fingerprints[i],
+			Status:        models.BankLineUnmatched,
+		})
+	}
+	statement.LineCount = len(lines)
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(statement).Error; err != nil {
+			return fmt.Errorf("failed to save statement: %w", err)
+		}
+		if len(lines) > 0 {
+			if err := tx.CreateInBatches(lines, 200).Error; err != nil {
+				return fmt.Errorf("failed to save statement lines: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := s.AutoMatch(tenantID, statement.ID)
+	if err != nil {
+		return nil, err
+	}
+	statement.MatchedCount = summary.Matched
+	return &BankImportResult{
+		Statement:        statement,
+		Imported:         len(lines),
+		Duplicates:       statement.DuplicateCount,
+		BankMatchSummary: *summary,
+	}, nil
+}
+
+// AutoMatch matches a statement's open lines, or every open line of the tenant
+// when statementID is empty (e.g. after recording payments the bank already showed).
+// A line is matched outright when one entry carries its reference, or when a
+// single entry has the same amount within a couple of days; anything less
+// certain goes to the review queue.
+func (s *BankReconciliationService) AutoMatch(tenantID, statementID string) (*BankMatchSummary, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	query := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("status IN ?", []string{models.BankLineUnmatched, models.BankLineSuggested})
+	if statementID != "" {
+		query = query.Where("statement_id = ?", statementID)
+	}
+	var lines []models.BankStatementLine
+	if err := query.Order("date, line_number").Find(&lines).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &BankMatchSummary{}
+	claimed := make(map[string]bool) // entries matched earlier in this pass
+	touched := make(map[string]bool)
+	for i := range lines {
+		line := &lines[i]
+		entries, err := s.bookEntries(tenantID, line.Amount > 0, &line.Amount,
+			line.Date.Add(-bankMatchWindow), line.Date.Add(bankMatchWindow))
+		if err != nil {
+			return nil, err
+		}
+		var candidates []BankBookEntry
+		for _, e := range entries {
+			if !claimed[e.Type+":"+e.ID] {
+				candidates = append(candidates, scoreBookEntry(line, e))
+			}
+		}
+		sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].Score > candidates[b].Score })
+
+		updates := map[string]interface{}{"updated_at": time.Now()}
+		if match := autoMatch(line, candidates); match != nil {
+			now := time.Now()
+			claimed[match.Type+":"+match.ID] = true
+			updates["status"] = models.BankLineMatched
+			updates["match_type"] = match.Type
+			updates["match_id"] = match.ID
+			updates["match_reason"] = match.Reason
+			updates["candidates"] = ""
+			updates["matched_at"] = now
+			summary.Matched++
+		} else if len(candidates) > 0 {
+			if len(candidates) > maxBankSuggestions {
+				candidates = candidates[:maxBankSuggestions]
+			}
+			suggestions, _ := json.Marshal(candidates)
+			updates["status"] = models.BankLineSuggested
+			updates["candidates"] = string(suggestions)
+			summary.Suggested++
+		} else {
+			updates["status"] = models.BankLineUnmatched
+			updates["candidates"] = ""
+			summary.Unmatched++
+		}
+		if err := s.db.Model(&models.BankStatementLine{}).
+			Where("id = ? AND status IN ?", line.ID, []string{models.BankLineUnmatched, models.BankLineSuggested}).
+			Updates(updates).Error; err != nil {
+			return nil, err
+		}
+		touched[line.StatementID] = true
+	}
+
+	for id := range touched {
+		s.refreshMatchedCount(s.db.DB, id)
+	}
+	return summary, nil
+}
+
+func autoMatch(line *models.BankStatementLine, candidates []BankBookEntry) *BankBookEntry {
+	var byReference []BankBookEntry
+	for _, c := range candidates {
+		if c.Reason == "reference" {
+			byReference = append(byReference, c)
+		}
+	}
+	if len(byReference) == 1 {
+		return &byReference[0]
+	}
+	if len(byReference) == 0 && len(candidates) == 1 && daysApart(line.Date, candidates[0].Date) <= bankAutoMatchDays {
+		return &candidates[0]
+	}
+	return nil
+}
+
+// scoreBookEntry rates a same-amount entry by reference and date proximity
+func scoreBookEntry(line *models.BankStatementLine, e BankBookEntry) BankBookEntry {
+	e.Score = 50
+	e.Reason = "amount_date"
+	ref := normalizeReference(e.Reference)
+	if len(ref) >= bankReferenceMinLen &&
+		strings.Contains(normalizeReference(line.Description+" "+line.Reference+" "+line.BankReference), ref) {
+		e.Score += 40
+		e.Reason = "reference"
+	}
+	e.Score += max(0, 10-2*daysApart(line.Date, e.Date))
+	return e
+}
+
+func daysApart(a, b time.Time) int {
+	d := a.Sub(b)
+	if d < 0 {
+		d = -d
+	}
+	return int(d.Hours() / 24)
+}
+
+// bookEntries returns the tenant's bank-side entries that no statement line
+// is matched to yet: completed bank payments and unallocated deposits for
+// money in, expenses paid by bank for money out. amount narrows to one value.
+func (s *BankReconciliationService) bookEntries(tenantID string, moneyIn bool, amount *models.Money, from, to time.Time) ([]BankBookEntry, error) {
+	var entries []BankBookEntry
+
+	if moneyIn {
+		var payments []models.Payment
+		q := s.db.Scopes(database.TenantFilter(tenantID)).
+			Where("method = ? AND status = ?", models.PaymentMethodBank, models.PaymentStatusCompleted).
+			Where("COALESCE(completed_at, created_at) BETWEEN ? AND ?", from, to).
+			Where("id NOT IN (?)", s.matchedIDs(tenantID, models.BankMatchPayment))
+		if amount != nil {
+			q = q.Where("amount = ?", *amount)
+		}
+		if err := q.Find(&payments).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range payments {
+			date := p.CreatedAt
+			if p.CompletedAt != nil {
+				date = *p.CompletedAt
+			}
+			entries = append(entries, BankBookEntry{
+				Type: models.BankMatchPayment, ID: p.ID, Date: date, Amount: p.Amount,
+				Reference: p.Reference, Description: "Bank payment",
+			})
+		}
+
+		// M-Pesa receipts carry the payer's phone and settle to the paybill, not the bank account
+		var deposits []models.UnallocatedPayment
+		q = s.db.Scopes(database.TenantFilter(tenantID)).
+			Where("is_matched = ? AND (phone_number = '' OR phone_number IS NULL)", false).
+			Where("created_at BETWEEN ? AND ?", from, to).
+			Where("id NOT IN (?)", s.matchedIDs(tenantID, models.BankMatchUnallocated))
+		if amount != nil {
+			q = q.Where("amount = ?", *amount)
+		}
+		if err := q.Find(&deposits).Error; err != nil {
+			return nil, err
+		}
+		for _, d := range deposits {
+			entries = append(entries, BankBookEntry{
+				Type: models.BankMatchUnallocated, ID: d.ID, Date: d.CreatedAt, Amount: d.Amount,
+				Reference: d.Reference, Description: firstNonEmpty(d.PayerName, d.AccountReference, "Unallocated deposit"),
+			})
+		}
+		return entries, nil
+	}
+
+	var expenses []models.Expense
+	q := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("payment_method = ? AND status = ?", "bank", "paid").
+		Where("(date BETWEEN ? AND ?) OR (paid_at BETWEEN ? AND ?)", from, to, from, to).
+		Where("id NOT IN (?)", s.matchedIDs(tenantID, models.BankMatchExpense))
+	if amount != nil {
+		q = q.Where("amount = ?", -*amount)
+	}
+	if err := q.Find(&expenses).Error; err != nil {
+		return nil, err
+	}
+	for _, e := range expenses {
+		entries = append(entries, BankBookEntry{
+			Type: models.BankMatchExpense, ID: e.ID, Date: e.Date, Amount: -e.Amount,
+			Reference: e.Reference, Description: strings.TrimSpace(e.Title + " " + e.Vendor),
+		})
+	}
+	return entries, nil
+}
+
+func (s *BankReconciliationService) matchedIDs(tenantID, matchType string) *gorm.DB {
+	return s.db.Model(&models.BankStatementLine{}).Select("match_id").
+		Where("tenant_id = ? AND status = ? AND match_type = ?", tenantID, models.BankLineMatched, matchType)
+}
+
+// bookEntry loads one entry for a manual match, if it is still unmatched
+func (s *BankReconciliationService) bookEntry(tenantID, matchType, id string) (*BankBookEntry, error) {
+	var count int64
+	s.matchedIDs(tenantID, matchType).Where("match_id = ?", id).Count(&count)
+	if count > 0 {
+		return nil, ErrBankMatchInvalid
+	}
+
+	scoped := s.db.Scopes(database.TenantFilter(tenantID))
+	switch matchType {
+	case models.BankMatchPayment:
+		var p models.Payment
+		if err := scoped.First(&p, "id = ? AND status = ?", id, models.PaymentStatusCompleted).Error; err != nil {
+			return nil, ErrBankMatchInvalid
+		}
+		return &BankBookEntry{Type: matchType, ID: p.ID, Date: p.CreatedAt, Amount: p.Amount, Reference: p.Reference}, nil
+	case models.BankMatchUnallocated:
+		var d models.UnallocatedPayment
+		if err := scoped.First(&d, "id = ?", id).Error; err != nil {
+			return nil, ErrBankMatchInvalid
+		}
+		return &BankBookEntry{Type: matchType, ID: d.ID, Date: d.CreatedAt, Amount: d.Amount, Reference: d.Reference}, nil
+	case models.BankMatchExpense:
+		var e models.Expense
+		if err := scoped.First(&e, "id = ?", id).Error; err != nil {
+			return nil, ErrBankMatchInvalid
+		}
+		return &BankBookEntry{Type: matchType, ID: e.ID, Date: e.Date, Amount: -e.Amount, Reference: e.Reference}, nil
+	}
+	return nil, ErrBankMatchInvalid
+}
+
+// ConfirmMatch matches a line to an entry chosen by a person, from the
+// suggestions or found by hand; the amount must agree but the date may not
+func (s *BankReconciliationService) ConfirmMatch(tenantID, userID, lineID, matchType, matchID string) (*models.BankStatementLine, error) {
+	line, err := s.getLine(tenantID, lineID)
+	if err != nil {
+		return nil, err
+	}
+	if line.Status == models.BankLineMatched {
+		return nil, ErrBankLineMatched
+	}
+	entry, err := s.bookEntry(tenantID, matchType, matchID)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.Amount.Equals(line.Amount) {
+		return nil, ErrBankMatchInvalid
+	}
+
+	now := time.Now()
+	res := s.db.Model(&models.BankStatementLine{}).
+		Where("id = ? AND status <> ?", line.ID, models.BankLineMatched).
+		Updates(map[string]interface{}{
+			"status":       models.BankLineMatched,
+			"match_type":   matchType,
+			"match_id":     matchID,
+			"match_reason": "manual",
+			"candidates":   "",
+			"matched_by":   userID,
+			"matched_at":   now,
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrBankLineMatched
+	}
+	s.refreshMatchedCount(s.db.DB, line.StatementID)
+	return s.getLine(tenantID, lineID)
+}
+
+// Unmatch returns a matched or ignored line to the unmatched state
+func (s *BankReconciliationService) Unmatch(tenantID, lineID string) (*models.BankStatementLine, error) {
+	line, err := s.getLine(tenantID, lineID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&models.BankStatementLine{}).Where("id = ?", line.ID).Updates(map[string]interface{}{
+		"status":       models.BankLineUnmatched,
+		"match_type":   "",
+		"match_id":     "",
+		"match_reason": "",
+		"candidates":   "",
+		"matched_by":   "",
+		"matched_at":   nil,
+	}).Error; err != nil {
+		return nil, err
+	}
+	s.refreshMatchedCount(s.db.DB, line.StatementID)
+	return s.getLine(tenantID, lineID)
+}
+
+// Ignore takes a line out of reconciliation, e.g. bank charges or a transfer between own accounts
+func (s *BankReconciliationService) Ignore(tenantID, userID, lineID, reason string) (*models.BankStatementLine, error) {
+	line, err := s.getLine(tenantID, lineID)
+	if err != nil {
+		return nil, err
+	}
+	if line.Status == models.BankLineMatched {
+		return nil, ErrBankLineMatched
+	}
+	now := time.Now()
+	if err := s.db.Model(&models.BankStatementLine{}).Where("id = ?", line.ID).Updates(map[string]interface{}{
+		"status":       models.BankLineIgnored,
+		"match_reason": strings.TrimSpace(reason),
+		"candidates":   "",
+		"matched_by":   userID,
+		"matched_at":   now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return s.getLine(tenantID, lineID)
+}
+
+// ReviewQueue returns lines waiting for a person to pick between possible matches
+func (s *BankReconciliationService) ReviewQueue(tenantID string) ([]BankReviewItem, error) {
+	var lines []models.BankStatementLine
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("status = ?", models.BankLineSuggested).
+		Order("date, line_number").Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	items := make([]BankReviewItem, 0, len(lines))
+	for _, line := range lines {
+		item := BankReviewItem{BankStatementLine: line}
+		if line.Candidates != "" {
+			_ = json.Unmarshal([]byte(line.Candidates), &item.Suggestions)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Report compares statement lines and bank-side book entries dated in [from, to]
+func (s *BankReconciliationService) Report(tenantID string, from, to time.Time, accountNumber string) (*BankReconciliationReport, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	to = to.Add(24*time.Hour - time.Nanosecond) // through the end of the last day
+	report := &BankReconciliationReport{From: from, To: to, AccountNumber: accountNumber}
+
+	query := s.db.Scopes(database.TenantFilter(tenantID)).Where("date BETWEEN ? AND ?", from, to)
+	if accountNumber != "" {
+		query = query.Where("account_number = ?", accountNumber)
+	}
+	var lines []models.BankStatementLine
+	if err := query.Order("date, line_number").Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	report.UnmatchedLines = []models.BankStatementLine{}
+	for _, line := range lines {
+		if line.Amount > 0 {
+			report.StatementCredits = report.StatementCredits.Add(line.Amount)
+		} else {
+			report.StatementDebits = report.StatementDebits.Sub(line.Amount)
+		}
+		switch line.Status {
+		case models.BankLineMatched:
+			report.MatchedLines++
+		case models.BankLineIgnored:
+			report.IgnoredLines++
+		default:
+			report.UnmatchedLines = append(report.UnmatchedLines, line)
+			report.UnmatchedLineTotal = report.UnmatchedLineTotal.Add(line.Amount)
+		}
+	}
+
+	report.UnmatchedEntries = []BankBookEntry{}
+	for _, moneyIn := range []bool{true, false} {
+		entries, err := s.bookEntries(tenantID, moneyIn, nil, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			// Expenses are picked by paid date too when matching; the report keeps to the expense date
+			if e.Date.Before(from) || e.Date.After(to) {
+				continue
+			}
+			report.UnmatchedEntries = append(report.UnmatchedEntries, e)
+			report.UnmatchedBookTotal = report.UnmatchedBookTotal.Add(e.Amount)
+		}
+	}
+	sort.SliceStable(report.UnmatchedEntries, func(a, b int) bool {
+		return report.UnmatchedEntries[a].Date.Before(report.UnmatchedEntries[b].Date)
+	})
+	return report, nil
+}
+
+// ListStatements returns the tenant's imported statements, newest first
+func (s *BankReconciliationService) ListStatements(tenantID string) ([]models.BankStatement, error) {
+	var statements []models.BankStatement
+	err := s.db.Scopes(database.TenantFilter(tenantID)).Order("created_at DESC").Find(&statements).Error
+	return statements, err
+}
+
+// GetStatement returns a statement with its lines
+func (s *BankReconciliationService) GetStatement(tenantID, id string) (*models.BankStatement, error) {
+	var statement models.BankStatement
+	err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("date, line_number") }).
+		First(&statement, "id = ?", id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBankStatementNotFound
+		}
+		return nil, err
+	}
+	return &statement, nil
+}
+
+func (s *BankReconciliationService) getLine(tenantID, id string) (*models.BankStatementLine, error) {
+	var line models.BankStatementLine
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&line, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBankLineNotFound
+		}
+		return nil, err
+	}
+	return &line, nil
+}
+
+func (s *BankReconciliationService) refreshMatchedCount(tx *gorm.DB, statementID string) {
+	var matched int64
+	tx.Model(&models.BankStatementLine{}).Where("statement_id = ? AND status = ?", statementID, models.BankLineMatched).Count(&matched)
+	tx.Model(&models.BankStatement{}).Where("id = ?", statementID).UpdateColumn("matched_count", matched)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
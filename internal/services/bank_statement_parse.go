@@ -0,0 +1,595 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoicefast/internal/models"
+)
+
+// ============================================================================
+// BANK STATEMENT PARSING - bank CSV exports, OFX/QFX and SWIFT MT940
+// ============================================================================
+
+// parsedStatement is a statement file reduced to what reconciliation needs
+type parsedStatement struct {
+	Format         string
+	BankName       string
+	AccountNumber  string
+	Currency       string
+	OpeningBalance *models.Money
+	ClosingBalance *models.Money
+	Lines          []parsedStatementLine
+}
+
+type parsedStatementLine struct {
+	Date          time.Time
+	ValueDate     *time.Time
+	Amount        models.Money // positive for money in
+	Description   string
+	Reference     string
+	BankReference string
+	Balance       *models.Money
+}
+
+// statementFormat picks the parser from the file extension, falling back to the content
+func statementFormat(fileName string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return models.BankStatementCSV
+	case ".ofx", ".qfx":
+		return models.BankStatementOFX
+	case ".sta", ".mt940", ".940":
+		return models.BankStatementMT940
+	case ".txt", "":
+		head := string(data[:min(len(data), 4096)])
+		switch {
+		case strings.Contains(strings.ToUpper(head), "<OFX>"):
+			return models.BankStatementOFX
+		case strings.Contains(head, ":20:") && strings.Contains(string(data), ":61:"):
+			return models.BankStatementMT940
+		default:
+			return models.BankStatementCSV
+		}
+	}
+	return ""
+}
+
+func parseStatement(format string, data []byte) (*parsedStatement, error) {
+	var (
+		stmt *parsedStatement
+		err  error
+	)
+	switch format {
+	case models.BankStatementCSV:
+		stmt, err = parseStatementCSV(data)
+	case models.BankStatementOFX:
+		stmt, err = parseStatementOFX(data)
+	case models.BankStatementMT940:
+		stmt, err = parseStatementMT940(data)
+	default:
+		return nil, ErrBankStatementFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(stmt.Lines) == 0 {
+		return nil, ErrBankStatementEmpty
+	}
+	if len(stmt.Lines) > maxImportRows {
+		return nil, fmt.Errorf("statements are limited to %d lines", maxImportRows)
+	}
+	stmt.Format = format
+	return stmt, nil
+}
+
+// ----------------------------------------------------------------------------
+// CSV
+// ----------------------------------------------------------------------------
+
+// statementColumns are the header names Kenyan banks use in their CSV exports
+// (Equity, KCB, Co-op, NCBA, Stanbic, Absa, I&M, DTB), normalized
+var statementColumns = map[string][]string{
+	"date":        {"transaction date", "trans date", "tran date", "txn date", "posting date", "post date", "booking date", "date"},
+	"value_date":  {"value date", "val date"},
+	"description": {"narrative", "narration", "description", "transaction details", "transaction description", "details", "particulars", "remarks"},
+	"reference":   {"reference", "ref", "ref no", "reference no", "reference number", "customer reference", "transaction reference", "cheque no", "cheque number", "chq no"},
+	"bank_ref":    {"bank reference", "transaction id", "trans id", "tran id"},
+	"debit":       {"debit", "debits", "debit amount", "withdrawal", "withdrawals", "money out", "paid out", "dr"},
+	"credit":      {"credit", "credits", "credit amount", "deposit", "deposits", "money in", "paid in", "cr"},
+	"amount":      {"amount", "transaction amount"},
+	"balance":     {"balance", "running balance", "book balance", "ledger balance"},
+}
+
+var statementAccountLabels = []string{"account number", "account no", "acc no", "account"}
+
+// parseStatementCSV reads a bank CSV export. Banks put a preamble (account
+// details, period) above the header row and wrap long narratives onto extra
+// rows without a date; both are handled.
+func parseStatementCSV(data []byte) (*parsedStatement, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.LazyQuotes = true
+	var records [][]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	stmt := &parsedStatement{}
+	header := -1
+	var cols map[string]int
+	for i, record := range records {
+		if i > 30 {
+			break
+		}
+		if c := statementHeader(record); c != nil {
+			header, cols = i, c
+			break
+		}
+		if stmt.AccountNumber == "" {
+			stmt.AccountNumber = preambleAccount(record)
+		}
+	}
+	if header < 0 {
+		return nil, errors.New("no header row found: the statement needs a date column and an amount, or debit and credit, columns")
+	}
+
+	get := func(record []string, key string) string {
+		if i, ok := cols[key]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	for n, record := range records[header+1:] {
+		row := header + n + 2
+		if isBlankRow(record) {
+			continue
+		}
+		desc := get(record, "description")
+		dateText := get(record, "date")
+		if dateText == "" {
+			// A narrative wrapped onto the next row
+			if len(stmt.Lines) > 0 && desc != "" {
+				last := &stmt.Lines[len(stmt.Lines)-1]
+				last.Description = strings.TrimSpace(last.Description + " " + desc)
+			}
+			continue
+		}
+		lower := strings.ToLower(desc + " " + dateText)
+		if strings.Contains(lower, "opening balance") || strings.Contains(lower, "closing balance") ||
+			strings.Contains(lower, "balance b/f") || strings.Contains(lower, "balance c/f") || strings.HasPrefix(lower, "total") {
+			continue
+		}
+
+		date, err := parseStatementDate(dateText)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		line := parsedStatementLine{
+			Date:          date,
+			Description:   desc,
+			Reference:     get(record, "reference"),
+			BankReference: get(record, "bank_ref"),
+		}
+		if v := get(record, "value_date"); v != "" {
+			if vd, err := parseStatementDate(v); err == nil {
+				line.ValueDate = &vd
+			}
+		}
+
+		if _, ok := cols["amount"]; ok {
+			line.Amount, err = parseStatementAmount(get(record, "amount"))
+		} else {
+			var debit, credit models.Money
+			if debit, err = parseStatementAmount(get(record, "debit")); err == nil {
+				credit, err = parseStatementAmount(get(record, "credit"))
+			}
+			// Some banks show debits as negative numbers, others as positive
+			if debit < 0 {
+				debit = -debit
+			}
+			line.Amount = credit.Sub(debit)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		if line.Amount == 0 {
+			continue
+		}
+		if v := get(record, "balance"); v != "" {
+			if balance, err := parseStatementAmount(v); err == nil {
+				line.Balance = &balance
+			}
+		}
+		stmt.Lines = append(stmt.Lines, line)
+	}
+	return stmt, nil
+}
+
+// statementHeader returns the column index of each statement field when the
+// record looks like the header row
+func statementHeader(record []string) map[string]int {
+	cols := make(map[string]int)
+	for key, aliases := range statementColumns {
+		for _, alias := range aliases {
+			for i, h := range record {
+				if normalizeHeader(h) == alias {
+					cols[key] = i
+					break
+				}
+			}
+			if _, ok := cols[key]; ok {
+				break
+			}
+		}
+	}
+	_, hasDate := cols["date"]
+	_, hasAmount := cols["amount"]
+	_, hasDebit := cols["debit"]
+	_, hasCredit := cols["credit"]
+	if !hasDate || !(hasAmount || (hasDebit && hasCredit)) {
+		return nil
+	}
+	if hasDebit && hasCredit {
+		delete(cols, "amount")
+	}
+	return cols
+}
+
+// preambleAccount finds "Account Number: 0123..." in the rows above the header
+func preambleAccount(record []string) string {
+	for i, cell := range record {
+		label, value, found := strings.Cut(cell, ":")
+		for _, want := range statementAccountLabels {
+			if normalizeHeader(label) != want {
+				continue
+			}
+			if found && strings.TrimSpace(value) != "" {
+				return strings.TrimSpace(value)
+			}
+			if i+1 < len(record) {
+				return strings.TrimSpace(record[i+1])
+			}
+		}
+	}
+	return ""
+}
+
+var statementDateLayouts = []string{
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+	"2006-01-02 15:04",
+	"02-Jan-2006 15:04:05",
+	"02 January 2006",
+	"02/01/06",
+	"20060102",
+}
+
+func parseStatementDate(v string) (time.Time, error) {
+	if t, err := parseImportDate(v); err == nil {
+		return t, nil
+	}
+	for _, layout := range statementDateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date: %s", v)
+}
+
+// parseStatementAmount reads amounts like "1,234.50", "(1,234.50)", "-1234.5",
+// "1,234.50 DR" and "KES 1,234.50". An empty cell or a dash is zero.
+func parseStatementAmount(v string) (models.Money, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if v == "" || v == "-" {
+		return 0, nil
+	}
+	negative := false
+	if strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")") {
+		negative, v = true, v[1:len(v)-1]
+	}
+	switch {
+	case strings.HasSuffix(v, "DR"):
+		negative, v = true, strings.TrimSuffix(v, "DR")
+	case strings.HasSuffix(v, "CR"):
+		v = strings.TrimSuffix(v, "CR")
+	}
+	for _, code := range []string{"KES", "KSHS", "KSH", "USD"} {
+		v = strings.TrimPrefix(v, code)
+	}
+	v = strings.NewReplacer(",", "", " ", "").Replace(v)
+	if strings.HasSuffix(v, "-") {
+		negative, v = true, strings.TrimSuffix(v, "-")
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount: %s", v)
+	}
+	amount := models.ToCents(n)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// ----------------------------------------------------------------------------
+// OFX / QFX (both the SGML 1.x and XML 2.x flavours)
+// ----------------------------------------------------------------------------
+
+var ofxTransaction = regexp.MustCompile(`(?is)<STMTTRN>(.*?)</STMTTRN>`)
+
+func parseStatementOFX(data []byte) (*parsedStatement, error) {
+	text := string(data)
+	if !strings.Contains(strings.ToUpper(text), "<OFX>") {
+		return nil, errors.New("invalid OFX: missing <OFX> element")
+	}
+
+	stmt := &parsedStatement{
+		AccountNumber: ofxValue(text, "ACCTID"),
+		Currency:      strings.ToUpper(ofxValue(text, "CURDEF")),
+	}
+	if org := ofxValue(text, "ORG"); org != "" {
+		stmt.BankName = org
+	}
+	if ledger := ofxBlock(text, "LEDGERBAL"); ledger != "" {
+		if amount, err := parseOFXAmount(ofxValue(ledger, "BALAMT")); err == nil {
+			stmt.ClosingBalance = &amount
+		}
+	}
+
+	for _, m := range ofxTransaction.FindAllStringSubmatch(text, -1) {
+		block := m[1]
+		date, err := parseOFXDate(ofxValue(block, "DTPOSTED"))
+		if err != nil {
+			return nil, err
+		}
+		amount, err := parseOFXAmount(ofxValue(block, "TRNAMT"))
+		if err != nil {
+			return nil, err
+		}
+		if amount == 0 {
+			continue
+		}
+		desc := ofxValue(block, "NAME")
+		if memo := ofxValue(block, "MEMO"); memo != "" && memo != desc {
+			desc = strings.TrimSpace(desc + " " + memo)
+		}
+		ref := ofxValue(block, "CHECKNUM")
+		if ref == "" {
+			ref = ofxValue(block, "REFNUM")
+		}
+		stmt.Lines = append(stmt.Lines, parsedStatementLine{
+			Date:          date,
+			Amount:        amount,
+			Description:   desc,
+			Reference:     ref,
+			BankReference: ofxValue(block, "FITID"),
+		})
+	}
+	return stmt, nil
+}
+
+// ofxValue returns the text after <TAG>, up to the next tag. SGML OFX leaves
+// leaf elements unclosed, so the closing tag can't be relied on.
+func ofxValue(text, tag string) string {
+	upper := strings.ToUpper(text)
+	i := strings.Index(upper, "<"+tag+">")
+	if i < 0 {
+		return ""
+	}
+	rest := text[i+len(tag)+2:]
+	if j := strings.Index(rest, "<"); j >= 0 {
+		rest = rest[:j]
+	}
+	return strings.TrimSpace(ofxUnescape.Replace(rest))
+}
+
+var ofxUnescape = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&apos;", "'", "&quot;", `"`)
+
+func ofxBlock(text, tag string) string {
+	upper := strings.ToUpper(text)
+	start := strings.Index(upper, "<"+tag+">")
+	end := strings.Index(upper, "</"+tag+">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return text[start:end]
+}
+
+// parseOFXDate reads YYYYMMDD[HHMMSS[.XXX]][[offset:TZ]]; only the date matters here
+func parseOFXDate(v string) (time.Time, error) {
+	if len(v) < 8 {
+		return time.Time{}, fmt.Errorf("invalid OFX date: %q", v)
+	}
+	t, err := time.Parse("20060102", v[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid OFX date: %q", v)
+	}
+	return t, nil
+}
+
+func parseOFXAmount(v string) (models.Money, error) {
+	// Some European exports use a decimal comma
+	v = strings.ReplaceAll(strings.TrimSpace(v), ",", ".")
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid OFX amount: %q", v)
+	}
+	return models.ToCents(n), nil
+}
+
+// ----------------------------------------------------------------------------
+// SWIFT MT940
+// ----------------------------------------------------------------------------
+
+var (
+	mt940Tag      = regexp.MustCompile(`^:(\d{2}[A-Z]?):`)
+	mt940Line     = regexp.MustCompile(`(?s)^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d+,\d*)([NFS][A-Z0-9]{3})([^/\n]*)(?://([^\n]*))?(?:\n(.*))?$`)
+	mt940Balance  = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})(\d+,\d*)`)
+	mt940Subfield = regexp.MustCompile(`\?\d{2}`)
+)
+
+type mt940Field struct {
+	tag   string
+	value string
+}
+
+func parseStatementMT940(data []byte) (*parsedStatement, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	var fields []mt940Field
+	for _, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimRight(raw, " \r")
+		if m := mt940Tag.FindStringSubmatch(raw); m != nil {
+			fields = append(fields, mt940Field{tag: m[1], value: raw[len(m[0]):]})
+			continue
+		}
+		// Message trailers and SWIFT block headers
+		if raw == "" || raw == "-" || raw == "-}" || strings.HasPrefix(raw, "{") {
+			continue
+		}
+		if len(fields) > 0 {
+			fields[len(fields)-1].value += "\n" + raw
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("invalid MT940: no fields found")
+	}
+
+	stmt := &parsedStatement{}
+	for _, f := range fields {
+		switch f.tag {
+		case "25":
+			if stmt.AccountNumber == "" {
+				stmt.AccountNumber = strings.TrimSpace(f.value)
+			}
+		case "60F", "60M":
+			if stmt.OpeningBalance == nil {
+				if amount, currency, err := parseMT940Balance(f.value); err == nil {
+					stmt.OpeningBalance = &amount
+					stmt.Currency = currency
+				}
+			}
+		case "62F", "62M":
+			if amount, currency, err := parseMT940Balance(f.value); err == nil {
+				stmt.ClosingBalance = &amount
+				if stmt.Currency == "" {
+					stmt.Currency = currency
+				}
+			}
+		case "61":
+			line, err := parseMT940Line(f.value)
+			if err != nil {
+				return nil, err
+			}
+			stmt.Lines = append(stmt.Lines, *line)
+		case "86":
+			// Information to account owner, for the :61: line before it
+			if n := len(stmt.Lines); n > 0 && stmt.Lines[n-1].Description == "" {
+				info := mt940Subfield.ReplaceAllString(f.value, " ")
+				stmt.Lines[n-1].Description = strings.Join(strings.Fields(info), " ")
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// parseMT940Line reads a :61: statement line: value date, optional entry
+// date, debit/credit mark, amount, transaction type, references and
+// supplementary details
+func parseMT940Line(v string) (*parsedStatementLine, error) {
+	m := mt940Line.FindStringSubmatch(v)
+	if m == nil {
+		return nil, fmt.Errorf("invalid MT940 statement line: %q", strings.SplitN(v, "\n", 2)[0])
+	}
+	valueDate, err := time.Parse("060102", m[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid MT940 value date: %q", m[1])
+	}
+	date := valueDate
+	if m[2] != "" {
+		// The entry date has no year; it can fall in the year before or after the value date
+		if entry, err := time.Parse("0102", m[2]); err == nil {
+			date = time.Date(valueDate.Year(), entry.Month(), entry.Day(), 0, 0, 0, 0, time.UTC)
+			if date.Sub(valueDate) > 180*24*time.Hour {
+				date = date.AddDate(-1, 0, 0)
+			} else if valueDate.Sub(date) > 180*24*time.Hour {
+				date = date.AddDate(1, 0, 0)
+			}
+		}
+	}
+	amount, err := parseOFXAmount(m[5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid MT940 amount: %q", m[5])
+	}
+	// D and RC (reversal of a credit) take money out
+	if m[3] == "D" || m[3] == "RC" {
+		amount = -amount
+	}
+
+	line := &parsedStatementLine{
+		Date:          date,
+		ValueDate:     &valueDate,
+		Amount:        amount,
+		BankReference: strings.TrimSpace(m[8]),
+		Description:   strings.TrimSpace(m[9]),
+	}
+	if ref := strings.TrimSpace(m[7]); !strings.EqualFold(ref, "NONREF") {
+		line.Reference = ref
+	}
+	return line, nil
+}
+
+func parseMT940Balance(v string) (models.Money, string, error) {
+	m := mt940Balance.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return 0, "", fmt.Errorf("invalid MT940 balance: %q", v)
+	}
+	amount, err := parseOFXAmount(m[4])
+	if err != nil {
+		return 0, "", err
+	}
+	if m[1] == "D" {
+		amount = -amount
+	}
+	return amount, m[3], nil
+}
+
+// ----------------------------------------------------------------------------
+
+// lineFingerprints identifies each line so a re-imported or overlapping
+// statement doesn't create the same transaction twice. Identical lines in one
+// file (two equal deposits on the same day) are told apart by occurrence.
+func lineFingerprints(accountNumber string, lines []parsedStatementLine) []string {
+	seen := make(map[string]int)
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		key := l.BankReference
+		if key == "" {
+			key = normalizeReference(l.Reference + l.Description)
+		}
+		base := fmt.Sprintf("%s|%s|%d|%s", normalizeReference(accountNumber), l.Date.Format("2006-01-02"), int64(l.Amount), key)
+		seen[base]++
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d", base, seen[base])))
+		out[i] = hex.EncodeToString(sum[:16])
+	}
+	return out
+}
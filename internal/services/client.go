@@ -15,13 +15,19 @@ import (
 )
 
 type ClientService struct {
-	db *database.DB
+	db        *database.DB
+	workflows *WorkflowEngine
 }
 
 func NewClientService(db *database.DB) *ClientService {
 	return &ClientService{db: db}
 }
 
+// SetWorkflowEngine wires the workflow event bus (optional)
+func (s *ClientService) SetWorkflowEngine(engine *WorkflowEngine) {
+	s.workflows = engine
+}
+
 // CreateClient creates a new client (tenant-scoped)
 func (s *ClientService) CreateClient(tenantID, userID string, req *CreateClientRequest) (*models.Client, error) {
 	// Validate inputs
@@ -66,6 +72,24 @@ func (s *ClientService) CreateClient(tenantID, userID string, req *CreateClientR
 		json.Unmarshal([]byte(client.Tags), &client.TagsList)
 	}
 
+	s.workflows.Publish(&WorkflowEvent{
+		TenantID:   tenantID,
+		UserID:     userID,
+		Event:      models.TriggerEventClientAdded,
+		EntityType: "client",
+		EntityID:   client.ID,
+		Data: map[string]interface{}{
+			"client_id":      client.ID,
+			"client_name":    client.Name,
+			"client_email":   client.Email,
+			"client_phone":   client.Phone,
+			"client_country": client.Country,
+			"currency":       client.Currency,
+			"payment_terms":  client.PaymentTerms,
+			"has_kra_pin":    client.KRAPIN != "",
+		},
+	})
+
 	return client, nil
 }
 
@@ -0,0 +1,787 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+	"invoicefast/internal/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// CLIENT PORTAL - Self-service sessions for a tenant's clients
+// ============================================================================
+
+const (
+	portalLinkTTL         = 15 * time.Minute
+	portalCodeTTL         = 10 * time.Minute
+	portalSessionTTL      = 12 * time.Hour
+	portalMaxCodeAttempts = 5
+	portalMaxPayInvoices  = 20
+	// portalPaymentWindow blocks a second STK push for the same invoice while one is in flight
+	portalPaymentWindow = 2 * time.Minute
+)
+
+var (
+	ErrPortalLoginInvalid      = errors.New("invalid or expired login link or code")
+	ErrPortalSessionInvalid    = errors.New("portal session is invalid or has expired")
+	ErrPortalInvoiceNotFound   = errors.New("invoice not found")
+	ErrPortalNothingToPay      = errors.New("selected invoices have no balance due")
+	ErrPortalPaymentInProgress = errors.New("a payment for one of these invoices is already in progress")
+	ErrPortalPaymentsDisabled  = errors.New("M-Pesa payments are not available")
+)
+
+// portalPayableStatuses are the invoice states a client can pay from the portal
+var portalPayableStatuses = []models.InvoiceStatus{
+	models.InvoiceStatusSent,
+	models.InvoiceStatusViewed,
+	models.InvoiceStatusPartiallyPaid,
+	models.InvoiceStatusOverdue,
+}
+
+// PortalSTKPusher starts an M-Pesa STK push (implemented by MPesaService)
+type PortalSTKPusher interface {
+	InitiateSTKPush(ctx context.Context, tenantID, invoiceID, phoneNumber, amount, invoiceNumber string) (*STKPushResponse, error)
+}
+
+// PortalLinkSender emails portal login links (implemented by EmailService)
+type PortalLinkSender interface {
+	SendPortalLoginEmail(to, companyName, loginLink string, expiresIn time.Duration) error
+}
+
+// PortalCodeSender texts one-time login codes (implemented by SMSService)
+type PortalCodeSender interface {
+	Send(to, message string) error
+}
+
+// ClientPortalService lets a tenant's clients sign in and manage their account
+type ClientPortalService struct {
+	db      *database.DB
+	reports *ReportService
+	email   PortalLinkSender
+	sms     PortalCodeSender
+	mpesa   PortalSTKPusher
+	baseURL string
+}
+
+// NewClientPortalService creates a new client portal service
+func NewClientPortalService(db *database.DB, reports *ReportService) *ClientPortalService {
+	return &ClientPortalService{db: db, reports: reports, baseURL: "https://invoice.simuxtech.com"}
+}
+
+// SetEmailService enables magic-link login by email
+func (s *ClientPortalService) SetEmailService(sender PortalLinkSender) {
+	s.email = sender
+}
+
+// SetSMSService enables one-time code login by phone
+func (s *ClientPortalService) SetSMSService(sender PortalCodeSender) {
+	s.sms = sender
+}
+
+// SetSTKPusher enables paying invoices from the portal with M-Pesa
+func (s *ClientPortalService) SetSTKPusher(pusher PortalSTKPusher) {
+	s.mpesa = pusher
+}
+
+// SetBaseURL sets the public URL used for magic links
+func (s *ClientPortalService) SetBaseURL(baseURL string) {
+	if baseURL != "" {
+		s.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+type PortalLoginRequest struct {
+	Tenant string `json:"tenant"` // tenant ID or subdomain
+	Email  string `json:"email"`
+	Phone  string `json:"phone"`
+}
+
+// PortalLoginResult is returned whether or not a client matched, so the
+// response cannot be used to discover who is a client of the tenant.
+type PortalLoginResult struct {
+	Channel     string    `json:"channel"`
+	ChallengeID string    `json:"challenge_id,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type PortalVerifyRequest struct {
+	Token       string `json:"token"`
+	ChallengeID string `json:"challenge_id"`
+	Code        string `json:"code"`
+}
+
+type PortalSession struct {
+	Token     string         `json:"token"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	Client    *models.Client `json:"client"`
+}
+
+type PortalProfileRequest struct {
+	Email   *string `json:"email"`
+	Phone   *string `json:"phone"`
+	Address *string `json:"address"`
+}
+
+type PortalReceipt struct {
+	PaymentID     string               `json:"payment_id"`
+	InvoiceID     string               `json:"invoice_id"`
+	InvoiceNumber string               `json:"invoice_number"`
+	MagicToken    string               `json:"magic_token"`
+	Amount        models.Money         `json:"amount"`
+	Currency      string               `json:"currency"`
+	Method        models.PaymentMethod `json:"method"`
+	Reference     string               `json:"reference"`
+	ReceiptNumber string               `json:"receipt_number,omitempty"`
+	PaidAt        *time.Time           `json:"paid_at"`
+	Allocations   []PortalPaymentLine  `json:"allocations,omitempty"` // set when the payment covered several invoices
+}
+
+type PortalPaymentLine struct {
+	InvoiceID     string               `json:"invoice_id"`
+	InvoiceNumber string               `json:"invoice_number"`
+	Amount        models.Money         `json:"amount"`
+	Status        models.PaymentStatus `json:"status"`
+}
+
+type PortalPayment struct {
+	CheckoutRequestID string              `json:"checkout_request_id"`
+	Status            string              `json:"status"` // pending, completed, failed
+	Amount            models.Money        `json:"amount"`
+	Charged           models.Money        `json:"charged,omitempty"` // whole shillings pushed; the cents over Amount become client credit
+	Currency          string              `json:"currency"`
+	CustomerMessage   string              `json:"customer_message,omitempty"`
+	Invoices          []PortalPaymentLine `json:"invoices"`
+}
+
+// RequestLogin sends a magic link (email) or a one-time code (phone) to a matching client
+func (s *ClientPortalService) RequestLogin(req *PortalLoginRequest, ipAddress string) (*PortalLoginResult, error) {
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	phone := normalizePhone(req.Phone)
+
+	result := &PortalLoginResult{}
+	switch {
+	case email != "":
+		if err := utils.ValidateEmail(email); err != nil {
+			return nil, err
+		}
+		if s.email == nil {
+			return nil, errors.New("email login is not available")
+		}
+		result.Channel = models.PortalChannelEmail
+		result.ExpiresAt = time.Now().Add(portalLinkTTL)
+	case phone != "":
+		if err := utils.ValidatePhone(phone); err != nil {
+			return nil, err
+		}
+		if s.sms == nil {
+			return nil, errors.New("SMS login is not available")
+		}
+		result.Channel = models.PortalChannelSMS
+		result.ExpiresAt = time.Now().Add(portalCodeTTL)
+		// Unknown numbers still get a challenge ID that will never verify
+		result.ChallengeID = uuid.New().String()
+	default:
+		return nil, &utils.ValidationError{Field: "email", Message: "email or phone is required"}
+	}
+
+	tenant, err := s.findTenant(req.Tenant)
+	if err != nil {
+		return result, nil
+	}
+	client, err := s.matchClient(tenant.ID, email, phone)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		logger.Get().Info(context.Background(), "Portal login for unknown contact", "category", "security", "tenant_id", tenant.ID, "channel", result.Channel)
+		return result, nil
+	}
+
+	var secret string
+	if result.Channel == models.PortalChannelEmail {
+		if secret, err = newPortalToken(); err != nil {
+			return nil, err
+		}
+	} else if secret, err = newPortalCode(); err != nil {
+		return nil, err
+	}
+
+	session := &models.ClientPortalSession{
+		ID:                 uuid.New().String(),
+		TenantID:           tenant.ID,
+		ClientID:           client.ID,
+		Channel:            result.Channel,
+		ChallengeExpiresAt: result.ExpiresAt,
+		IPAddress:          ipAddress,
+	}
+	if result.Channel == models.PortalChannelSMS {
+		session.ID = result.ChallengeID
+		session.ChallengeHash = hashPortalSecret(session.ID + ":" + secret)
+	} else {
+		session.ChallengeHash = hashPortalSecret(secret)
+	}
+	if err := s.db.Create(session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create portal login: %w", err)
+	}
+
+	// Delivery failures are logged rather than returned so the response stays the same
+	if result.Channel == models.PortalChannelEmail {
+		link := fmt.Sprintf("%s/portal/login?token=%s", s.baseURL, secret)
+		if err := s.email.SendPortalLoginEmail(email, tenant.Name, link, portalLinkTTL); err != nil {
+			logger.Get().Error(context.Background(), "Failed to send portal login email", "error", err, "client_id", client.ID)
+		}
+	} else {
+		message := fmt.Sprintf("%s: your login code is %s. It expires in %d minutes.", tenant.Name, secret, int(portalCodeTTL.Minutes()))
+		if err := s.sms.Send(phone, message); err != nil {
+			logger.Get().Error(context.Background(), "Failed to send portal login code", "error", err, "client_id", client.ID)
+		}
+	}
+
+	logger.Get().Info(context.Background(), "Portal login requested", "category", "audit", "tenant_id", tenant.ID, "client_id", client.ID, "channel", result.Channel)
+	return result, nil
+}
+
+// Verify exchanges a magic-link token or a one-time code for a session token
+func (s *ClientPortalService) Verify(req *PortalVerifyRequest) (*PortalSession, error) {
+	now := time.Now()
+	var session models.ClientPortalSession
+
+	switch {
+	case strings.TrimSpace(req.Token) != "":
+		err := s.db.First(&session, "challenge_hash = ? AND channel = ? AND verified_at IS NULL",
+			hashPortalSecret(strings.TrimSpace(req.Token)), models.PortalChannelEmail).Error
+		if err != nil {
+			return nil, ErrPortalLoginInvalid
+		}
+	case req.ChallengeID != "" && req.Code != "":
+		if _, err := uuid.Parse(req.ChallengeID); err != nil {
+			return nil, ErrPortalLoginInvalid
+		}
+		err := s.db.First(&session, "id = ? AND channel = ? AND verified_at IS NULL",
+			req.ChallengeID, models.PortalChannelSMS).Error
+		if err != nil {
+			return nil, ErrPortalLoginInvalid
+		}
+		if session.Attempts >= portalMaxCodeAttempts || now.After(session.ChallengeExpiresAt) {
+			return nil, ErrPortalLoginInvalid
+		}
+		if hashPortalSecret(session.ID+":"+strings.TrimSpace(req.Code)) != session.ChallengeHash {
+			s.db.Model(&models.ClientPortalSession{}).Where("id = ?", session.ID).
+				UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+			return nil, ErrPortalLoginInvalid
+		}
+	default:
+		return nil, ErrPortalLoginInvalid
+	}
+
+	if now.After(session.ChallengeExpiresAt) {
+		return nil, ErrPortalLoginInvalid
+	}
+
+	raw, err := newPortalToken()
+	if err != nil {
+		return nil, err
+	}
+	expires := now.Add(portalSessionTTL)
+
+	// The challenge is single use: only the first verification claims it
+	result := s.db.Model(&models.ClientPortalSession{}).
+		Where("id = ? AND verified_at IS NULL", session.ID).
+		Updates(map[string]interface{}{
+			"verified_at":    now,
+			"expires_at":     expires,
+			"last_seen_at":   now,
+			"token_hash":     hashPortalSecret(raw),
+			"challenge_hash": "",
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to start portal session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrPortalLoginInvalid
+	}
+
+	client, err := s.loadClient(session.TenantID, session.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Get().Info(context.Background(), "Portal session started", "category", "audit", "tenant_id", session.TenantID, "client_id", session.ClientID, "channel", session.Channel)
+	return &PortalSession{Token: raw, ExpiresAt: expires, Client: client}, nil
+}
+
+// Authenticate resolves a session token to its session
+func (s *ClientPortalService) Authenticate(token string) (*models.ClientPortalSession, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrPortalSessionInvalid
+	}
+
+	var session models.ClientPortalSession
+	if err := s.db.First(&session, "token_hash = ?", hashPortalSecret(token)).Error; err != nil {
+		return nil, ErrPortalSessionInvalid
+	}
+	now := time.Now()
+	if !session.IsActive(now) {
+		return nil, ErrPortalSessionInvalid
+	}
+
+	s.db.Model(&models.ClientPortalSession{}).Where("id = ?", session.ID).UpdateColumn("last_seen_at", now)
+	return &session, nil
+}
+
+// Logout revokes a session
+func (s *ClientPortalService) Logout(session *models.ClientPortalSession) error {
+	return s.db.Model(&models.ClientPortalSession{}).Where("id = ?", session.ID).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+// GetProfile returns the signed-in client
+func (s *ClientPortalService) GetProfile(session *models.ClientPortalSession) (*models.Client, error) {
+	return s.loadClient(session.TenantID, session.ClientID)
+}
+
+// UpdateProfile updates the client's billing contact details
+func (s *ClientPortalService) UpdateProfile(session *models.ClientPortalSession, req *PortalProfileRequest) (*models.Client, error) {
+	updates := map[string]interface{}{}
+
+	if req.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*req.Email))
+		if err := utils.ValidateEmail(email); err != nil {
+			return nil, err
+		}
+		enc, err := models.EncryptValue(email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt email: %w", err)
+		}
+		updates["email"] = enc
+	}
+	if req.Phone != nil {
+		phone := normalizePhone(*req.Phone)
+		if err := utils.ValidatePhone(phone); err != nil {
+			return nil, err
+		}
+		value := ""
+		if phone != "" {
+			enc, err := models.EncryptValue(phone)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt phone: %w", err)
+			}
+			value = enc
+		}
+		updates["phone"] = value
+	}
+	if req.Address != nil {
+		updates["address"] = strings.TrimSpace(*req.Address)
+	}
+
+	if len(updates) > 0 {
+		updates["updated_at"] = time.Now()
+		// UpdateColumns skips the client hooks; contact fields are encrypted above
+		if err := s.db.Model(&models.Client{}).Scopes(database.TenantFilter(session.TenantID)).
+			Where("id = ?", session.ClientID).UpdateColumns(updates).Error; err != nil {
+			return nil, fmt.Errorf("failed to update contact details: %w", err)
+		}
+		logger.Get().Info(context.Background(), "Portal contact details updated", "category", "audit", "tenant_id", session.TenantID, "client_id", session.ClientID)
+	}
+
+	return s.loadClient(session.TenantID, session.ClientID)
+}
+
+// ListInvoices returns the client's issued invoices; status is "open", "paid" or empty for both
+func (s *ClientPortalService) ListInvoices(session *models.ClientPortalSession, status string, offset, limit int) ([]models.Invoice, int64, error) {
+	query := s.invoiceQuery(session).Where("invoice_type <> ?", "credit_note")
+	switch status {
+	case "open":
+		query = query.Where("status IN ?", portalPayableStatuses)
+	case "paid":
+		query = query.Where("status = ?", models.InvoiceStatusPaid)
+	default:
+		query = query.Where("status IN ?", append(portalPayableStatuses, models.InvoiceStatusPaid))
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count invoices: %w", err)
+	}
+
+	var invoices []models.Invoice
+	if err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&invoices).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list invoices: %w", err)
+	}
+	return invoices, total, nil
+}
+
+// GetInvoice returns one of the client's issued invoices or credit notes
+func (s *ClientPortalService) GetInvoice(session *models.ClientPortalSession, invoiceID string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := s.invoiceQuery(session).Preload("Items").
+		Where("status NOT IN ?", []models.InvoiceStatus{models.InvoiceStatusDraft, models.InvoiceStatusCancelled}).
+		First(&invoice, "id = ?", invoiceID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPortalInvoiceNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch invoice: %w", err)
+	}
+	return &invoice, nil
+}
+
+// ListCreditNotes returns credit notes issued to the client
+func (s *ClientPortalService) ListCreditNotes(session *models.ClientPortalSession) ([]models.Invoice, error) {
+	var notes []models.Invoice
+	if err := s.invoiceQuery(session).Where("invoice_type = ?", "credit_note").
+		Order("created_at DESC").Find(&notes).Error; err != nil {
+		return nil, fmt.Errorf("failed to list credit notes: %w", err)
+	}
+	return notes, nil
+}
+
+// ListReceipts returns the client's completed payments
+func (s *ClientPortalService) ListReceipts(session *models.ClientPortalSession) ([]PortalReceipt, error) {
+	var payments []models.Payment
+	err := s.db.Preload("Invoice").
+		Joins("JOIN invoices ON invoices.id = payments.invoice_id").
+		Where("payments.tenant_id = ? AND invoices.client_id = ? AND payments.status = ?",
+			session.TenantID, session.ClientID, models.PaymentStatusCompleted).
+		Order("payments.completed_at DESC").
+		Find(&payments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list receipts: %w", err)
+	}
+
+	ids := make([]string, 0, len(payments))
+	for _, p := range payments {
+		ids = append(ids, p.ID)
+	}
+	var allocations []models.PaymentAllocation
+	if len(ids) > 0 {
+		if err := s.db.Where("tenant_id = ? AND payment_id IN ?", session.TenantID, ids).
+			Order("created_at ASC").Find(&allocations).Error; err != nil {
+			return nil, fmt.Errorf("failed to list receipts: %w", err)
+		}
+	}
+	lines := make(map[string][]PortalPaymentLine)
+	if len(allocations) > 0 {
+		invoiceIDs := make([]string, 0, len(allocations))
+		for _, a := range allocations {
+			invoiceIDs = append(invoiceIDs, a.InvoiceID)
+		}
+		var invoices []models.Invoice
+		s.db.Select("id", "invoice_number").Where("tenant_id = ? AND id IN ?", session.TenantID, invoiceIDs).Find(&invoices)
+		numbers := make(map[string]string, len(invoices))
+		for _, inv := range invoices {
+			numbers[inv.ID] = inv.InvoiceNumber
+		}
+		for _, a := range allocations {
+			lines[a.PaymentID] = append(lines[a.PaymentID], PortalPaymentLine{
+				InvoiceID: a.InvoiceID, InvoiceNumber: numbers[a.InvoiceID], Amount: a.Amount,
+				Status: models.PaymentStatusCompleted,
+			})
+		}
+	}
+
+	receipts := make([]PortalReceipt, 0, len(payments))
+	for _, p := range payments {
+		receipts = append(receipts, PortalReceipt{
+			PaymentID:     p.ID,
+			InvoiceID:     p.InvoiceID,
+			InvoiceNumber: p.Invoice.InvoiceNumber,
+			MagicToken:    p.Invoice.MagicToken,
+			Amount:        p.Amount,
+			Currency:      p.Currency,
+			Method:        p.Method,
+			Reference:     p.Reference,
+			ReceiptNumber: p.ReceiptNumber,
+			PaidAt:        p.CompletedAt,
+			Allocations:   lines[p.ID],
+		})
+	}
+	return receipts, nil
+}
+
+// Statement returns the client's account statement for a period
+func (s *ClientPortalService) Statement(session *models.ClientPortalSession, startDate, endDate time.Time) (*ClientStatement, error) {
+	return s.reports.GetClientStatement(session.TenantID, session.ClientID, startDate, endDate)
+}
+
+// PayInvoices settles several open invoices with a single M-Pesa STK push.
+// One pending payment is recorded per invoice against the push's CheckoutRequestID,
+// and the M-Pesa callback settles them together.
+func (s *ClientPortalService) PayInvoices(ctx context.Context, session *models.ClientPortalSession, invoiceIDs []string, phone string) (*PortalPayment, error) {
+	if s.mpesa == nil {
+		return nil, ErrPortalPaymentsDisabled
+	}
+
+	var ids []string
+	seen := make(map[string]bool, len(invoiceIDs))
+	for _, id := range invoiceIDs {
+		id = strings.TrimSpace(id)
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, &utils.ValidationError{Field: "invoice_ids", Message: "select at least one invoice"}
+	}
+	if len(ids) > portalMaxPayInvoices {
+		return nil, &utils.ValidationError{Field: "invoice_ids", Message: fmt.Sprintf("at most %d invoices can be paid at once", portalMaxPayInvoices)}
+	}
+
+	client, err := s.loadClient(session.TenantID, session.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(phone) == "" {
+		phone = client.Phone
+	}
+	phone = normalizePhone(phone)
+	if phone == "" {
+		return nil, &utils.ValidationError{Field: "phone", Message: "an M-Pesa phone number is required"}
+	}
+	if err := utils.ValidatePhone(phone); err != nil {
+		return nil, err
+	}
+
+	var invoices []models.Invoice
+	if err := s.invoiceQuery(session).Where("id IN ? AND invoice_type <> ?", ids, "credit_note").
+		Order("due_date ASC").Find(&invoices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+	if len(invoices) != len(ids) {
+		return nil, ErrPortalInvoiceNotFound
+	}
+
+	var total models.Money
+	balances := make(map[string]models.Money, len(invoices))
+	for _, inv := range invoices {
+		if !portalPayable(inv.Status) {
+			return nil, fmt.Errorf("invoice %s cannot be paid: %s", inv.InvoiceNumber, inv.Status)
+		}
+		if inv.Currency != "" && inv.Currency != "KES" {
+			return nil, fmt.Errorf("invoice %s is in %s; M-Pesa payments must be in KES", inv.InvoiceNumber, inv.Currency)
+		}
+		balance := inv.Total.Sub(inv.PaidAmount)
+		if balance <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrPortalNothingToPay, inv.InvoiceNumber)
+		}
+		balances[inv.ID] = balance
+		total = total.Add(balance)
+	}
+
+	var inFlight int64
+	s.db.Model(&models.Payment{}).
+		Where("tenant_id = ? AND invoice_id IN ? AND method = ? AND status = ? AND created_at > ?",
+			session.TenantID, ids, models.PaymentMethodMpesa, models.PaymentStatusPending, time.Now().Add(-portalPaymentWindow)).
+		Count(&inFlight)
+	if inFlight > 0 {
+		return nil, ErrPortalPaymentInProgress
+	}
+
+	accountRef := invoices[0].InvoiceNumber
+	if len(invoices) > 1 {
+		accountRef = fmt.Sprintf("%s+%d", accountRef, len(invoices)-1)
+	}
+	// Daraja only accepts whole shillings. Cents are rounded up and what is paid
+	// over the balances is kept as credit for the client when the push completes.
+	shillings := (int64(total) + 99) / 100
+	amount := fmt.Sprintf("%d", shillings)
+
+	resp, err := s.mpesa.InitiateSTKPush(ctx, session.TenantID, invoices[0].ID, phone, amount, accountRef)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &PortalPayment{
+		CheckoutRequestID: resp.CheckoutRequestID,
+		Status:            string(models.PaymentStatusPending),
+		Amount:            total,
+		Charged:           models.Money(shillings * 100),
+		Currency:          "KES",
+		CustomerMessage:   resp.CustomerMessage,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, inv := range invoices {
+			record := &models.Payment{
+				ID:             uuid.New().String(),
+				TenantID:       inv.TenantID,
+				UserID:         inv.UserID,
+				InvoiceID:      inv.ID,
+				Amount:         balances[inv.ID],
+				Currency:       "KES",
+				Method:         models.PaymentMethodMpesa,
+				Status:         models.PaymentStatusPending,
+				Reference:      resp.CheckoutRequestID,
+				PhoneNumber:    phone,
+				IdempotencyKey: portalPaymentKey(resp.CheckoutRequestID, inv.ID),
+				PaymentType:    "invoice",
+			}
+			if err := tx.Create(record).Error; err != nil {
+				return fmt.Errorf("failed to record payment: %w", err)
+			}
+			payment.Invoices = append(payment.Invoices, PortalPaymentLine{
+				InvoiceID:     inv.ID,
+				InvoiceNumber: inv.InvoiceNumber,
+				Amount:        record.Amount,
+				Status:        record.Status,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Get().Error(ctx, "STK push sent but pending payments not recorded", "error", err, "checkout_request_id", resp.CheckoutRequestID)
+		return nil, err
+	}
+
+	logger.Get().Info(ctx, "Portal payment initiated", "category", "audit", "tenant_id", session.TenantID, "client_id", session.ClientID, "checkout_request_id", resp.CheckoutRequestID, "invoices", len(invoices))
+	return payment, nil
+}
+
+// PaymentStatus reports the progress of a portal STK push
+func (s *ClientPortalService) PaymentStatus(session *models.ClientPortalSession, checkoutRequestID string) (*PortalPayment, error) {
+	var payments []models.Payment
+	err := s.db.Preload("Invoice").
+		Joins("JOIN invoices ON invoices.id = payments.invoice_id").
+		Where("payments.tenant_id = ? AND invoices.client_id = ? AND payments.idempotency_key LIKE ?",
+			session.TenantID, session.ClientID, portalPaymentKey(checkoutRequestID, "%")).
+		Find(&payments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load payment: %w", err)
+	}
+	if len(payments) == 0 {
+		return nil, ErrPaymentNotFound
+	}
+
+	result := &PortalPayment{CheckoutRequestID: checkoutRequestID, Currency: "KES"}
+	completed, failed := 0, 0
+	for _, p := range payments {
+		result.Amount = result.Amount.Add(p.Amount)
+		result.Invoices = append(result.Invoices, PortalPaymentLine{
+			InvoiceID:     p.InvoiceID,
+			InvoiceNumber: p.Invoice.InvoiceNumber,
+			Amount:        p.Amount,
+			Status:        p.Status,
+		})
+		switch p.Status {
+		case models.PaymentStatusCompleted:
+			completed++
+		case models.PaymentStatusFailed:
+			failed++
+		}
+	}
+
+	switch {
+	case completed == len(payments):
+		result.Status = string(models.PaymentStatusCompleted)
+	case failed > 0:
+		result.Status = string(models.PaymentStatusFailed)
+	default:
+		result.Status = string(models.PaymentStatusPending)
+	}
+	return result, nil
+}
+
+func (s *ClientPortalService) invoiceQuery(session *models.ClientPortalSession) *gorm.DB {
+	return s.db.Model(&models.Invoice{}).Scopes(database.TenantFilter(session.TenantID)).
+		Where("client_id = ?", session.ClientID)
+}
+
+func (s *ClientPortalService) loadClient(tenantID, clientID string) (*models.Client, error) {
+	var client models.Client
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&client, "id = ?", clientID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPortalSessionInvalid
+		}
+		return nil, fmt.Errorf("failed to fetch client: %w", err)
+	}
+	return &client, nil
+}
+
+func (s *ClientPortalService) findTenant(ref string) (*models.Tenant, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var tenant models.Tenant
+	query := s.db.Where("is_active = ?", true)
+	if _, err := uuid.Parse(ref); err == nil {
+		query = query.Where("id = ?", ref)
+	} else {
+		query = query.Where("subdomain = ?", strings.ToLower(ref))
+	}
+	if err := query.First(&tenant).Error; err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// matchClient finds the tenant's client by email or phone. Contact fields are
+// encrypted, so they are compared after loading.
+func (s *ClientPortalService) matchClient(tenantID, email, phone string) (*models.Client, error) {
+	var clients []models.Client
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("status <> ?", models.ClientStatusArchived).
+		Order("created_at ASC").Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+
+	for i := range clients {
+		c := &clients[i]
+		if email != "" && strings.EqualFold(strings.TrimSpace(c.Email), email) {
+			return c, nil
+		}
+		if phone != "" && c.Phone != "" && normalizePhone(c.Phone) == phone {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func portalPayable(status models.InvoiceStatus) bool {
+	for _, s := range portalPayableStatuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+func portalPaymentKey(checkoutRequestID, invoiceID string) string {
+	return "portal:" + checkoutRequestID + ":" + invoiceID
+}
+
+func hashPortalSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return fmt.Sprintf("%x", hash[:])
+}
+
+func newPortalToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func newPortalCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -32,6 +32,7 @@ func (DiscrepancyAlert) TableName() string {
 type PaymentDiscrepancyService struct {
 	db           *database.DB
 	emailService *EmailService
+	workflows    *WorkflowEngine
 }
 
 func NewPaymentDiscrepancyService(db *database.DB, email *EmailService) *PaymentDiscrepancyService {
@@ -41,6 +42,11 @@ func NewPaymentDiscrepancyService(db *database.DB, email *EmailService) *Payment
 	}
 }
 
+// SetWorkflowEngine wires the workflow event bus (optional)
+func (s *PaymentDiscrepancyService) SetWorkflowEngine(engine *WorkflowEngine) {
+	s.workflows = engine
+}
+
 func (s *PaymentDiscrepancyService) CheckAndCreateAlerts() error {
 	var payments []models.Payment
 	now := time.Now()
@@ -88,12 +94,27 @@ func (s *PaymentDiscrepancyService) createAlert(payment *models.Payment, invoice
 		CreatedAt:      time.Now(),
 	}
 
-	s.db.Create(&alert)
+	if err := s.db.Create(&alert).Error; err != nil {
+		return
+	}
 	logger.Get().Info(context.Background(), "Payment discrepancy detected",
 		"payment", payment.Reference,
 		"discrepancy", discrepancy,
 		"expected", expected,
 	)
+
+	data := paymentEventData(payment, invoice)
+	data["alert_id"] = alert.ID
+	data["expected_amount"] = expected.Float64()
+	data["discrepancy"] = discrepancy.Float64()
+	s.workflows.Publish(&WorkflowEvent{
+		TenantID:   payment.TenantID,
+		UserID:     payment.UserID,
+		Event:      models.TriggerEventFraudDetected,
+		EntityType: "payment",
+		EntityID:   payment.ID,
+		Data:       data,
+	})
 }
 
 func (s *PaymentDiscrepancyService) GetAlerts(tenantID string) ([]DiscrepancyAlert, error) {
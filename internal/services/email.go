@@ -212,6 +212,56 @@ func (s *EmailService) SendTeamInvite(to, inviterName, companyName, inviteLink s
 	return s.Send(req)
 }
 
+// SendQuoteEmail sends a quotation with a link to accept or decline it
+func (s *EmailService) SendQuoteEmail(to, companyName, quoteNumber, amount string, expiry time.Time, quoteLink string) error {
+	body := fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto;">
+			<h2>Quotation %s from %s</h2>
+			<p>Hello,</p>
+			<p>Please find our quotation for <strong>%s</strong>. It is valid until %s.</p>
+			<p>You can review the quotation and accept or decline it online:</p>
+			<a href="%s" style="display: inline-block; background: #2563eb; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px;">View Quotation</a>
+		</div>
+	`, template.HTMLEscapeString(quoteNumber), template.HTMLEscapeString(companyName), amount, expiry.Format("02 Jan 2006"), quoteLink)
+
+	billingName, billingEmail := s.sender("billing")
+	req := EmailRequest{
+		FromName:  billingName,
+		FromEmail: billingEmail,
+		To:        []string{to},
+		Subject:   fmt.Sprintf("Quotation %s from %s", quoteNumber, companyName),
+		Body:      body,
+		IsHTML:    true,
+	}
+
+	return s.Send(req)
+}
+
+// SendPortalLoginEmail sends a client portal magic link
+func (s *EmailService) SendPortalLoginEmail(to, companyName, loginLink string, expiresIn time.Duration) error {
+	body := fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto;">
+			<h2>Sign in to %s</h2>
+			<p>Hello,</p>
+			<p>Use the button below to view your invoices, statements and receipts. The link works once and expires in %d minutes.</p>
+			<a href="%s" style="display: inline-block; background: #2563eb; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px;">Open Client Portal</a>
+			<p style="color: #6b7280; font-size: 13px;">If you did not request this, you can ignore this email.</p>
+		</div>
+	`, template.HTMLEscapeString(companyName), int(expiresIn.Minutes()), loginLink)
+
+	billingName, billingEmail := s.sender("billing")
+	req := EmailRequest{
+		FromName:  billingName,
+		FromEmail: billingEmail,
+		To:        []string{to},
+		Subject:   fmt.Sprintf("Your %s client portal link", companyName),
+		Body:      body,
+		IsHTML:    true,
+	}
+
+	return s.Send(req)
+}
+
 // InvoiceEmailData for invoice email template
 type InvoiceEmailData struct {
 	CompanyName   string
@@ -0,0 +1,832 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============================================================================
+// eTIMS - control unit onboarding, items, purchases and stock
+// ============================================================================
+//
+// Before a taxpayer can sign sales on eTIMS the control unit has to be
+// initialized, which hands back the communication key every later call is
+// made with. Items sold must be registered under a KRA classification code,
+// and the item code eTIMS knows them by is what invoice lines carry. Invoices
+// suppliers issue to the tenant's PIN are imported and confirmed, and stock
+// moving in and out of registered items is reported with what's left.
+
+var (
+	ErrETIMSNotInitialized    = errors.New("eTIMS device has not been initialized")
+	ErrETIMSDeviceInvalid     = errors.New("device initialization needs a KRA PIN and a device serial number")
+	ErrETIMSModeInvalid       = errors.New("mode must be oscu or vscu")
+	ErrETIMSItemNotFound      = errors.New("item not found")
+	ErrETIMSItemInvalid       = errors.New("item registration needs a classification code, an item type (1, 2 or 3), a country of origin, a packaging unit and a quantity unit")
+	ErrETIMSCodeUnknown       = errors.New("code is not in the eTIMS code list")
+	ErrETIMSItemNotRegistered = errors.New("item is not registered with eTIMS")
+	ErrETIMSPurchaseNotFound  = errors.New("purchase not found")
+	ErrETIMSPurchaseConfirmed = errors.New("purchase has already been confirmed")
+	ErrETIMSStockInvalid      = errors.New("stock movement needs a known type and a quantity above zero")
+	ErrETIMSInsufficientStock = errors.New("not enough stock on hand")
+	ErrETIMSInvoiceNotFound   = errors.New("the supplier's invoice is not registered with eTIMS")
+	ErrETIMSInvoiceRejected   = errors.New("the supplier's invoice was rejected on eTIMS")
+	ErrETIMSInvoiceOtherBuyer = errors.New("the supplier's invoice was issued to a different KRA PIN")
+)
+
+// etimsStockTypes are the stock in/out types movements can be recorded with
+var etimsStockTypes = map[string]bool{
+	models.ETIMSStockImport:        true,
+	models.ETIMSStockPurchase:      true,
+	models.ETIMSStockReturnIn:      true,
+	models.ETIMSStockAdjustmentIn:  true,
+	models.ETIMSStockSale:          true,
+	models.ETIMSStockReturnOut:     true,
+	models.ETIMSStockDiscarded:     true,
+	models.ETIMSStockAdjustmentOut: true,
+}
+
+// ETIMSService onboards a tenant's control unit and keeps eTIMS in step with
+// its items, purchases and stock
+type ETIMSService struct {
+	db       *database.DB
+	client   *ETIMSClient
+	taxRates *TaxRateService
+}
+
+func NewETIMSService(cfg *config.Config, db *database.DB) *ETIMSService {
+	return &ETIMSService{db: db, client: NewETIMSClient(cfg), taxRates: NewTaxRateService(db)}
+}
+
+// ============================================================================
+// DEVICE
+// ============================================================================
+
+// ETIMSDeviceRequest identifies the control unit to initialize
+type ETIMSDeviceRequest struct {
+	Mode         string `json:"mode"` // oscu (default) or vscu
+	TIN          string `json:"tin"`
+	BranchID     string `json:"branch_id"` // default "00", the head office
+	DeviceSerial string `json:"device_serial"`
+}
+
+// GetDevice returns the tenant's initialized control unit
+func (s *ETIMSService) GetDevice(tenantID string) (*models.ETIMSDevice, error) {
+	var device models.ETIMSDevice
+	err := s.db.Scopes(database.TenantFilter(tenantID)).First(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && device.InitializedAt == nil) {
+		return nil, ErrETIMSNotInitialized
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get eTIMS device: %w", err)
+	}
+	return &device, nil
+}
+
+// identity returns the device and the credentials calls are made with
+func (s *ETIMSService) identity(tenantID string) (*models.ETIMSDevice, etimsIdentity, error) {
+	device, err := s.GetDevice(tenantID)
+	if err != nil {
+		return nil, etimsIdentity{}, err
+	}
+	key, err := models.DecryptValue(device.CommunicationKey)
+	if err != nil {
+		return nil, etimsIdentity{}, fmt.Errorf("failed to decrypt eTIMS communication key: %w", err)
+	}
+	return device, etimsIdentity{Mode: device.Mode, TIN: device.TIN, BranchID: device.BranchID, CMCKey: key}, nil
+}
+
+// InitializeDevice initializes the tenant's control unit and stores the
+// communication key eTIMS issues. Initializing again replaces the device.
+func (s *ETIMSService) InitializeDevice(ctx context.Context, tenantID string, req *ETIMSDeviceRequest) (*models.ETIMSDevice, error) {
+	mode := strings.ToLower(strings.TrimSpace(req.Mode))
+	if mode == "" {
+		mode = models.ETIMSModeOSCU
+	}
+	if mode != models.ETIMSModeOSCU && mode != models.ETIMSModeVSCU {
+		return nil, ErrETIMSModeInvalid
+	}
+	tin := strings.ToUpper(strings.TrimSpace(req.TIN))
+	serial := strings.TrimSpace(req.DeviceSerial)
+	if tin == "" || serial == "" {
+		return nil, ErrETIMSDeviceInvalid
+	}
+	branch := strings.TrimSpace(req.BranchID)
+	if branch == "" {
+		branch = "00"
+	}
+
+	info, err := s.client.InitDevice(ctx, mode, tin, branch, serial)
+	if err != nil {
+		return nil, err
+	}
+	key, err := models.EncryptValue(info.CmcKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt eTIMS communication key: %w", err)
+	}
+
+	var device models.ETIMSDevice
+	err = s.db.Scopes(database.TenantFilter(tenantID)).First(&device).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get eTIMS device: %w", err)
+	}
+	now := time.Now()
+	replaced := device.ID != "" && (device.TIN != tin || device.BranchID != branch)
+	if device.ID == "" {
+		device.ID = uuid.New().String()
+		device.TenantID = tenantID
+	}
+	device.Mode = mode
+	device.TIN = tin
+	device.BranchID = branch
+	device.DeviceSerial = serial
+	device.SDCID = info.SdcID
+	device.MRCNo = info.MrcNo
+	device.CommunicationKey = key
+	device.InitializedAt = &now
+	if replaced {
+		// A different taxpayer or branch starts its syncs over
+		device.CodesSyncedAt = nil
+		device.PurchasesAt = nil
+	}
+	if err := s.db.Save(&device).Error; err != nil {
+		return nil, fmt.Errorf("failed to save eTIMS device: %w", err)
+	}
+	return &device, nil
+}
+
+// ============================================================================
+// CODE LISTS
+// ============================================================================
+
+// SyncCodes fetches code lists and item classification codes changed since the
+// last sync and returns how many entries were added or updated
+func (s *ETIMSService) SyncCodes(ctx context.Context, tenantID string) (int, error) {
+	device, id, err := s.identity(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	since := etimsFirstRequest
+	if device.CodesSyncedAt != nil {
+		since = device.CodesSyncedAt.Format(etimsDateTime)
+	}
+	started := time.Now()
+
+	classes, err := s.client.CodeList(ctx, id, since)
+	if err != nil {
+		return 0, err
+	}
+	itemClasses, err := s.client.ItemClassList(ctx, id, since)
+	if err != nil {
+		return 0, err
+	}
+
+	var codes []models.ETIMSCode
+	for _, class := range classes {
+		for _, detail := range class.DtlList {
+			codes = append(codes, models.ETIMSCode{
+				ID:        uuid.New().String(),
+				TenantID:  tenantID,
+				Class:     class.CdCls,
+				Code:      detail.Cd,
+				Name:      detail.CdNm,
+				IsActive:  detail.UseYn != "N",
+				UpdatedAt: started,
+			})
+		}
+	}
+	for _, itemClass := range itemClasses {
+		codes = append(codes, models.ETIMSCode{
+			ID:          uuid.New().String(),
+			TenantID:    tenantID,
+			Class:       models.ETIMSCodeItemClass,
+			Code:        itemClass.ItemClsCd,
+			Name:        itemClass.ItemClsNm,
+			TaxCategory: itemClass.TaxTyCd,
+			IsActive:    itemClass.UseYn != "N",
+			UpdatedAt:   started,
+		})
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if len(codes) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "class"}, {Name: "code"}},
+				DoUpdates: clause.AssignmentColumns([]string{"name", "tax_category", "is_active", "updated_at"}),
+			}).CreateInBatches(codes, 200).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(device).Update("codes_synced_at", started).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to save eTIMS codes: %w", err)
+	}
+	return len(codes), nil
+}
+
+// ListCodes lists synced codes, optionally of one class
+func (s *ETIMSService) ListCodes(tenantID, class string) ([]models.ETIMSCode, error) {
+	query := s.db.Scopes(database.TenantFilter(tenantID))
+	if class != "" {
+		query = query.Where("class = ?", class)
+	}
+	var codes []models.ETIMSCode
+	err := query.Order("class, code").Find(&codes).Error
+	return codes, err
+}
+
+// checkCode rejects a code missing from a class that has been synced. Classes
+// never synced aren't checked; eTIMS rejects bad codes itself.
+func (s *ETIMSService) checkCode(tenantID, class, code string) (*models.ETIMSCode, error) {
+	var codes []models.ETIMSCode
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("class = ?", class).Find(&codes).Error; err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	for i := range codes {
+		if codes[i].Code == code && codes[i].IsActive {
+			return &codes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s (class %s)", ErrETIMSCodeUnknown, code, class)
+}
+
+// ============================================================================
+// ITEMS
+// ============================================================================
+
+// ETIMSItemRequest holds the eTIMS details of a library item. Empty fields keep
+// what the item already has.
+type ETIMSItemRequest struct {
+	ItemClassCode string `json:"item_class_code"`
+	ItemType      string `json:"item_type"`      // 1 raw material, 2 finished product, 3 service
+	OriginCountry string `json:"origin_country"` // default KE
+	PackagingUnit string `json:"packaging_unit"` // default NT (net)
+	QuantityUnit  string `json:"quantity_unit"`  // default U (pieces)
+	TaxCategory   string `json:"tax_category"`   // default from the classification code
+}
+
+// RegisterItem registers a library item with eTIMS, giving it the KRA item
+// code invoice lines refer to it by. Registering again updates the details
+// under the same code.
+func (s *ETIMSService) RegisterItem(ctx context.Context, tenantID, userID, itemID string, req *ETIMSItemRequest) (*models.ItemLibrary, error) {
+	_, id, err := s.identity(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	var item models.ItemLibrary
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&item, "id = ?", itemID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrETIMSItemNotFound
+		}
+		return nil, err
+	}
+
+	pick := func(value, current, fallback string) string {
+		if value = strings.ToUpper(strings.TrimSpace(value)); value != "" {
+			return value
+		}
+		if current != "" {
+			return current
+		}
+		return fallback
+	}
+	item.ItemClassCode = pick(req.ItemClassCode, item.ItemClassCode, "")
+	item.ItemType = pick(req.ItemType, item.ItemType, "")
+	item.OriginCountry = pick(req.OriginCountry, item.OriginCountry, "KE")
+	item.PackagingUnit = pick(req.PackagingUnit, item.PackagingUnit, "NT")
+	item.QuantityUnit = pick(req.QuantityUnit, item.QuantityUnit, "U")
+	item.TaxCategory = pick(req.TaxCategory, item.TaxCategory, "")
+	if item.ItemClassCode == "" || len(item.OriginCountry) != 2 ||
+		(item.ItemType != models.ETIMSItemRawMaterial && item.ItemType != models.ETIMSItemFinished && item.ItemType != models.ETIMSItemService) {
+		return nil, ErrETIMSItemInvalid
+	}
+
+	itemClass, err := s.checkCode(tenantID, models.ETIMSCodeItemClass, item.ItemClassCode)
+	if err != nil {
+		return nil, err
+	}
+	for class, code := range map[string]string{
+		models.ETIMSCodeCountry:       item.OriginCountry,
+		models.ETIMSCodePackagingUnit: item.PackagingUnit,
+		models.ETIMSCodeQuantityUnit:  item.QuantityUnit,
+	} {
+		if _, err := s.checkCode(tenantID, class, code); err != nil {
+			return nil, err
+		}
+	}
+	if item.TaxCategory == "" {
+		switch {
+		case itemClass != nil && itemClass.TaxCategory != "":
+			item.TaxCategory = itemClass.TaxCategory
+		case item.Taxable:
+			item.TaxCategory = models.KRATaxCategoryStandard
+		default:
+			item.TaxCategory = models.KRATaxCategoryExempt
+		}
+	}
+	if _, err := s.checkCode(tenantID, models.ETIMSCodeTaxType, item.TaxCategory); err != nil {
+		return nil, err
+	}
+
+	if item.ItemCode == "" {
+		if item.ItemCode, err = s.nextItemCode(tenantID, &item); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.client.SaveItem(ctx, id, etimsItem{
+		ItemCd:      item.ItemCode,
+		ItemClsCd:   item.ItemClassCode,
+		ItemTyCd:    item.ItemType,
+		ItemNm:      item.Name,
+		OrgnNatCd:   item.OriginCountry,
+		PkgUnitCd:   item.PackagingUnit,
+		QtyUnitCd:   item.QuantityUnit,
+		TaxTyCd:     item.TaxCategory,
+		DftPrc:      item.UnitPrice.Float64(),
+		IsrcAplcbYn: "N",
+		UseYn:       "Y",
+		RegrID:      userID,
+		RegrNm:      userID,
+		ModrID:      userID,
+		ModrNm:      userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	item.ETIMSRegisteredAt = &now
+	if err := s.db.Save(&item).Error; err != nil {
+		return nil, fmt.Errorf("failed to save item: %w", err)
+	}
+	return &item, nil
+}
+
+// nextItemCode builds a KRA item code: country of origin, item type, packaging
+// unit and quantity unit followed by a 7-digit sequence, e.g. KE2NTU0000001
+func (s *ETIMSService) nextItemCode(tenantID string, item *models.ItemLibrary) (string, error) {
+	prefix := item.OriginCountry + item.ItemType + item.PackagingUnit + item.QuantityUnit
+	var count int64
+	if err := s.db.Model(&models.ItemLibrary{}).Scopes(database.TenantFilter(tenantID)).
+		Where("item_code LIKE ?", prefix+"%").Count(&count).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%07d", prefix, count+1), nil
+}
+
+// ============================================================================
+// PURCHASES
+// ============================================================================
+
+// ImportPurchases fetches invoices issued to the tenant's PIN since the last
+// import and returns how many were new
+func (s *ETIMSService) ImportPurchases(ctx context.Context, tenantID string) (int, error) {
+	device, id, err := s.identity(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	since := etimsFirstRequest
+	if device.PurchasesAt != nil {
+		since = device.PurchasesAt.Format(etimsDateTime)
+	}
+	started := time.Now()
+
+	sales, err := s.client.PurchaseList(ctx, id, since)
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, sale := range sales {
+			items, err := json.Marshal(sale.ItemList)
+			if err != nil {
+				return err
+			}
+			invoiceDate, err := time.Parse("20060102", sale.SalesDt)
+			if err != nil {
+				invoiceDate = started
+			}
+			purchase := models.ETIMSPurchase{
+				ID:             uuid.New().String(),
+				TenantID:       tenantID,
+				SupplierPIN:    sale.SpplrTin,
+				SupplierName:   sale.SpplrNm,
+				SupplierBranch: sale.SpplrBhfID,
+				InvoiceNumber:  sale.SpplrInvcNo,
+				ICN:            sale.IntrlData,
+				InvoiceDate:    invoiceDate,
+				TaxableAmount:  models.ToCents(sale.TotTaxblAmt),
+				TaxAmount:      models.ToCents(sale.TotTaxAmt),
+				TotalAmount:    models.ToCents(sale.TotAmt),
+				ItemsJSON:      string(items),
+				Status:         models.ETIMSPurchasePending,
+			}
+			// An invoice already imported stays as it is
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&purchase)
+			if result.Error != nil {
+				return result.Error
+			}
+			imported += int(result.RowsAffected)
+		}
+		return tx.Model(device).Update("purchases_synced_at", started).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to save eTIMS purchases: %w", err)
+	}
+	return imported, nil
+}
+
+// ListPurchases lists imported purchases, optionally only those in one state
+func (s *ETIMSService) ListPurchases(tenantID, status string) ([]models.ETIMSPurchase, error) {
+	query := s.db.Scopes(database.TenantFilter(tenantID))
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var purchases []models.ETIMSPurchase
+	err := query.Order("invoice_date DESC, created_at DESC").Find(&purchases).Error
+	return purchases, err
+}
+
+// ConfirmPurchase tells eTIMS the tenant accepts a purchase as received, or
+// rejects it
+func (s *ETIMSService) ConfirmPurchase(ctx context.Context, tenantID, userID, purchaseID string, accept bool) (*models.ETIMSPurchase, error) {
+	_, id, err := s.identity(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	var purchase models.ETIMSPurchase
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&purchase, "id = ?", purchaseID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrETIMSPurchaseNotFound
+		}
+		return nil, err
+	}
+	if purchase.Status != models.ETIMSPurchasePending {
+		return nil, ErrETIMSPurchaseConfirmed
+	}
+
+	var items []etimsPurchaseItem
+	if purchase.ItemsJSON != "" {
+		if err := json.Unmarshal([]byte(purchase.ItemsJSON), &items); err != nil {
+			return nil, fmt.Errorf("failed to read purchase items: %w", err)
+		}
+	}
+	// eTIMS numbers the buyer's confirmed purchases
+	var confirmed int64
+	if err := s.db.Model(&models.ETIMSPurchase{}).Scopes(database.TenantFilter(tenantID)).
+		Where("status <> ?", models.ETIMSPurchasePending).Count(&confirmed).Error; err != nil {
+		return nil, err
+	}
+
+	status, state := etimsPurchaseApproved, models.ETIMSPurchaseAccepted
+	if !accept {
+		status, state = etimsPurchaseRejected, models.ETIMSPurchaseRejected
+	}
+	now := time.Now()
+	err = s.client.ConfirmPurchase(ctx, id, etimsPurchaseConfirmation{
+		InvcNo:      confirmed + 1,
+		SpplrTin:    purchase.SupplierPIN,
+		SpplrBhfID:  purchase.SupplierBranch,
+		SpplrNm:     purchase.SupplierName,
+		SpplrInvcNo: purchase.InvoiceNumber,
+		PchsTyCd:    "N",
+		RcptTyCd:    "P",
+		PmtTyCd:     "01",
+		PchsSttsCd:  status,
+		CfmDt:       now.Format(etimsDateTime),
+		PchsDt:      purchase.InvoiceDate.Format("20060102"),
+		TotItemCnt:  len(items),
+		TotTaxblAmt: purchase.TaxableAmount.Float64(),
+		TotTaxAmt:   purchase.TaxAmount.Float64(),
+		TotAmt:      purchase.TotalAmount.Float64(),
+		RegrID:      userID,
+		ModrID:      userID,
+		ItemList:    items,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	purchase.Status = state
+	purchase.ConfirmedAt = &now
+	purchase.ConfirmedBy = userID
+	if err := s.db.Model(&purchase).Updates(map[string]interface{}{
+		"status":       state,
+		"confirmed_at": now,
+		"confirmed_by": userID,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update purchase: %w", err)
+	}
+	return &purchase, nil
+}
+
+// SupplierInvoice is a supplier's invoice as eTIMS has it. Amounts are zero
+// when the lookup doesn't return them.
+type SupplierInvoice struct {
+	SupplierPIN   string       `json:"supplier_pin"`
+	SupplierName  string       `json:"supplier_name"`
+	InvoiceNumber string       `json:"invoice_number"`
+	ICN           string       `json:"icn"`
+	TaxAmount     models.Money `json:"tax_amount"`
+	TotalAmount   models.Money `json:"total_amount"`
+}
+
+// VerifySupplierInvoice checks that a supplier's invoice is registered with
+// eTIMS and was issued to the tenant's PIN, first among the purchases already
+// imported for the tenant's PIN and then with an eTIMS lookup
+func (s *ETIMSService) VerifySupplierInvoice(ctx context.Context, tenantID, supplierPIN, icn string) (*SupplierInvoice, error) {
+	supplierPIN = strings.ToUpper(strings.TrimSpace(supplierPIN))
+	icn = strings.ToUpper(strings.TrimSpace(icn))
+
+	var purchase models.ETIMSPurchase
+	err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("supplier_pin = ? AND (icn = ? OR invoice_number = ?)", supplierPIN, icn, icn).
+		First(&purchase).Error
+	if err == nil {
+		if purchase.Status == models.ETIMSPurchaseRejected {
+			return nil, ErrETIMSInvoiceRejected
+		}
+		return &SupplierInvoice{
+			SupplierPIN:   purchase.SupplierPIN,
+			SupplierName:  purchase.SupplierName,
+			InvoiceNumber: purchase.InvoiceNumber,
+			ICN:           purchase.ICN,
+			TaxAmount:     purchase.TaxAmount,
+			TotalAmount:   purchase.TotalAmount,
+		}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	_, id, err := s.identity(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.client.LookupInvoice(ctx, id, supplierPIN, icn)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil || info.SpplrTin != supplierPIN {
+		return nil, ErrETIMSInvoiceNotFound
+	}
+	// Input VAT can only be claimed on an invoice issued to the tenant's own PIN
+	if !strings.EqualFold(strings.TrimSpace(info.CustTin), id.TIN) {
+		return nil, ErrETIMSInvoiceOtherBuyer
+	}
+	return &SupplierInvoice{
+		SupplierPIN:   info.SpplrTin,
+		SupplierName:  info.SpplrNm,
+		InvoiceNumber: info.SpplrInvcNo,
+		ICN:           info.Icn,
+		TaxAmount:     models.ToCents(info.TotTaxAmt),
+		TotalAmount:   models.ToCents(info.TotAmt),
+	}, nil
+}
+
+// ============================================================================
+// STOCK
+// ============================================================================
+
+// ETIMSStockRequest is stock moving in or out of a registered item
+type ETIMSStockRequest struct {
+	ItemID     string    `json:"item_id"`
+	Type       string    `json:"type"` // sarTyCd, e.g. 02 purchase, 11 sale, 16 adjustment out
+	Quantity   float64   `json:"quantity"`
+	UnitPrice  float64   `json:"unit_price"` // default the item's price
+	Reference  string    `json:"reference"`
+	OccurredAt time.Time `json:"occurred_at"` // default now
+}
+
+// RecordStockMovement records a movement and reports it to eTIMS. A movement
+// eTIMS can't take now stays pending or failed for ReportPendingStock; it is
+// only refused outright when it would leave stock below zero.
+func (s *ETIMSService) RecordStockMovement(ctx context.Context, tenantID, userID string, req *ETIMSStockRequest) (*models.ETIMSStockMovement, error) {
+	if !etimsStockTypes[req.Type] || req.Quantity <= 0 || req.UnitPrice < 0 {
+		return nil, ErrETIMSStockInvalid
+	}
+	item, err := s.registeredItem(tenantID, req.ItemID)
+	if err != nil {
+		return nil, err
+	}
+
+	movement := &models.ETIMSStockMovement{
+		ID:         uuid.New().String(),
+		TenantID:   tenantID,
+		ItemID:     item.ID,
+		ItemCode:   item.ItemCode,
+		Type:       req.Type,
+		Quantity:   req.Quantity,
+		UnitPrice:  item.UnitPrice,
+		Reference:  strings.TrimSpace(req.Reference),
+		OccurredAt: req.OccurredAt,
+		Status:     models.ETIMSStockPending,
+		CreatedBy:  userID,
+	}
+	if req.UnitPrice > 0 {
+		movement.UnitPrice = models.ToCents(req.UnitPrice)
+	}
+	if movement.OccurredAt.IsZero() {
+		movement.OccurredAt = time.Now()
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		onHand, err := stockOnHand(tx, tenantID, item.ID)
+		if err != nil {
+			return err
+		}
+		movement.Remaining = onHand + req.Quantity
+		if !movement.StockIn() {
+			movement.Remaining = onHand - req.Quantity
+		}
+		if movement.Remaining < 0 {
+			return fmt.Errorf("%w: %g on hand", ErrETIMSInsufficientStock, onHand)
+		}
+
+		var last struct{ SarNo int64 }
+		if err := tx.Model(&models.ETIMSStockMovement{}).Scopes(database.TenantFilter(tenantID)).
+			Select("COALESCE(MAX(sar_no), 0) AS sar_no").Scan(&last).Error; err != nil {
+			return err
+		}
+		movement.SarNo = last.SarNo + 1
+		return tx.Create(movement).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrETIMSInsufficientStock) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to record stock movement: %w", err)
+	}
+
+	if _, id, err := s.identity(tenantID); err == nil {
+		s.reportStock(ctx, tenantID, id, item, movement)
+	}
+	return movement, nil
+}
+
+// ReportPendingStock reports movements not yet accepted by eTIMS, in order,
+// and returns how many went through. It stops at the first failure so later
+// movements don't overtake it.
+func (s *ETIMSService) ReportPendingStock(ctx context.Context, tenantID string) (int, error) {
+	_, id, err := s.identity(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	var movements []models.ETIMSStockMovement
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("status <> ?", models.ETIMSStockReported).
+		Order("sar_no").Find(&movements).Error; err != nil {
+		return 0, err
+	}
+
+	reported := 0
+	items := make(map[string]*models.ItemLibrary)
+	for i := range movements {
+		m := &movements[i]
+		item, ok := items[m.ItemID]
+		if !ok {
+			if item, err = s.registeredItem(tenantID, m.ItemID); err != nil {
+				return reported, err
+			}
+			items[m.ItemID] = item
+		}
+		if !s.reportStock(ctx, tenantID, id, item, m) {
+			return reported, fmt.Errorf("stock movement %d: %s", m.SarNo, m.Error)
+		}
+		reported++
+	}
+	return reported, nil
+}
+
+// ListStockMovements lists movements, newest first, optionally for one item
+func (s *ETIMSService) ListStockMovements(tenantID, itemID string) ([]models.ETIMSStockMovement, error) {
+	query := s.db.Scopes(database.TenantFilter(tenantID))
+	if itemID != "" {
+		query = query.Where("item_id = ?", itemID)
+	}
+	var movements []models.ETIMSStockMovement
+	err := query.Order("sar_no DESC").Find(&movements).Error
+	return movements, err
+}
+
+func (s *ETIMSService) registeredItem(tenantID, itemID string) (*models.ItemLibrary, error) {
+	var item models.ItemLibrary
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&item, "id = ?", itemID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrETIMSItemNotFound
+		}
+		return nil, err
+	}
+	if item.ItemCode == "" || item.ETIMSRegisteredAt == nil {
+		return nil, ErrETIMSItemNotRegistered
+	}
+	return &item, nil
+}
+
+// stockOnHand adds up the item's movements
+func stockOnHand(tx *gorm.DB, tenantID, itemID string) (float64, error) {
+	var totals []struct {
+		Type     string
+		Quantity float64
+	}
+	if err := tx.Model(&models.ETIMSStockMovement{}).Scopes(database.TenantFilter(tenantID)).
+		Select("type, SUM(quantity) AS quantity").
+		Where("item_id = ?", itemID).Group("type").Scan(&totals).Error; err != nil {
+		return 0, err
+	}
+	onHand := 0.0
+	for _, t := range totals {
+		if (&models.ETIMSStockMovement{Type: t.Type}).StockIn() {
+			onHand += t.Quantity
+		} else {
+			onHand -= t.Quantity
+		}
+	}
+	return onHand, nil
+}
+
+// reportStock sends a movement and the quantity left, then records the outcome
+func (s *ETIMSService) reportStock(ctx context.Context, tenantID string, id etimsIdentity, item *models.ItemLibrary, m *models.ETIMSStockMovement) bool {
+	supply := m.UnitPrice.Mul(m.Quantity)
+	tax := supply.Mul(s.categoryRate(tenantID, item.TaxCategory, m.OccurredAt) / 100)
+	err := s.client.StockIO(ctx, id, etimsStockReport{
+		SarNo:       m.SarNo,
+		RegTyCd:     "M",
+		SarTyCd:     m.Type,
+		OcrnDt:      m.OccurredAt.Format("20060102"),
+		TotItemCnt:  1,
+		TotTaxblAmt: supply.Float64(),
+		TotTaxAmt:   tax.Float64(),
+		TotAmt:      supply.Add(tax).Float64(),
+		RegrID:      m.CreatedBy,
+		ModrID:      m.CreatedBy,
+		ItemList: []etimsStockItem{{
+			ItemSeq:   1,
+			ItemCd:    item.ItemCode,
+			ItemClsCd: item.ItemClassCode,
+			ItemNm:    item.Name,
+			PkgUnitCd: item.PackagingUnit,
+			Pkg:       m.Quantity,
+			QtyUnitCd: item.QuantityUnit,
+			Qty:       m.Quantity,
+			Prc:       m.UnitPrice.Float64(),
+			SplyAmt:   supply.Float64(),
+			TaxTyCd:   item.TaxCategory,
+			TaxblAmt:  supply.Float64(),
+			TaxAmt:    tax.Float64(),
+			TotAmt:    supply.Add(tax).Float64(),
+		}},
+	})
+	if err == nil {
+		err = s.client.StockMaster(ctx, id, etimsStockMasterUpdate{
+			ItemCd: item.ItemCode,
+			RsdQty: m.Remaining,
+			RegrID: m.CreatedBy,
+			ModrID: m.CreatedBy,
+		})
+	}
+
+	updates := map[string]interface{}{"status": models.ETIMSStockReported, "error": ""}
+	if err != nil {
+		m.Status, m.Error = models.ETIMSStockFailed, err.Error()
+		updates = map[string]interface{}{"status": m.Status, "error": m.Error}
+	} else {
+		now := time.Now()
+		m.Status, m.Error, m.ReportedAt = models.ETIMSStockReported, "", &now
+		updates["reported_at"] = now
+	}
+	s.db.Model(m).Updates(updates)
+	return err == nil
+}
+
+// categoryRate is the VAT rate of an eTIMS tax category on date, from the catalogue
+func (s *ETIMSService) categoryRate(tenantID, category string, date time.Time) float64 {
+	rates, err := s.taxRates.ListRates(tenantID, models.TaxKindVAT)
+	if err != nil {
+		return 0
+	}
+	for _, r := range rates {
+		if r.IsActive && r.KRACategory == category && r.InForce(date) {
+			return r.Rate
+		}
+	}
+	return 0
+}
@@ -0,0 +1,474 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"invoicefast/internal/circuitbreaker"
+	"invoicefast/internal/config"
+	"invoicefast/internal/models"
+)
+
+// ============================================================================
+// eTIMS CLIENT - OSCU/VSCU endpoints beyond sales submission
+// ============================================================================
+//
+// Every eTIMS call is a JSON POST identified by the taxpayer PIN, branch and,
+// once the device is initialized, its communication key. Replies share one
+// envelope: a result code, a message and the data. OSCU devices talk to KRA;
+// VSCU devices talk to the control unit the taxpayer runs themselves. In dev
+// mode with neither configured, calls go to an in-process simulator.
+
+// eTIMS endpoints
+const (
+	etimsInitDevice      = "/selectInitOsdcInfo"
+	etimsCodeList        = "/selectCodeList"
+	etimsItemClassList   = "/selectItemClsList"
+	etimsSaveItem        = "/saveItem"
+	etimsPurchaseList    = "/selectTrnsPurchaseSalesList"
+	etimsConfirmPurchase = "/insertTrnsPurchase"
+	etimsStockIO         = "/insertStockIO"
+	etimsStockMaster     = "/saveStockMaster"
+	etimsInvoiceLookup   = "/selectInvoiceInfo"
+)
+
+// eTIMS result codes
+const (
+	etimsResultOK        = "000"
+	etimsResultNoRecords = "001"
+)
+
+// etimsDateTime is the format of eTIMS timestamps such as lastReqDt
+const etimsDateTime = "20060102150405"
+
+// etimsFirstRequest is the lastReqDt that asks for everything
+const etimsFirstRequest = "20180101000000"
+
+// ETIMSError is a request eTIMS answered with a result code other than success
+type ETIMSError struct {
+	Code    string
+	Message string
+}
+
+func (e *ETIMSError) Error() string {
+	return fmt.Sprintf("eTIMS error %s: %s", e.Code, e.Message)
+}
+
+// etimsIdentity identifies the control unit a request comes from
+type etimsIdentity struct {
+	Mode     string
+	TIN      string
+	BranchID string
+	CMCKey   string
+}
+
+type etimsEnvelope struct {
+	ResultCd  string          `json:"resultCd"`
+	ResultMsg string          `json:"resultMsg"`
+	ResultDt  string          `json:"resultDt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ETIMSClient sends requests to eTIMS, or to the simulator in dev mode
+type ETIMSClient struct {
+	cfg       *config.Config
+	http      *http.Client
+	simulator *ETIMSSimulator
+}
+
+func NewETIMSClient(cfg *config.Config) *ETIMSClient {
+	return &ETIMSClient{
+		cfg:       cfg,
+		http:      &http.Client{Timeout: 30 * time.Second},
+		simulator: NewETIMSSimulator(),
+	}
+}
+
+// baseURL returns the endpoint for a device's mode, or "" if none is configured
+func (c *ETIMSClient) baseURL(mode string) string {
+	if mode == models.ETIMSModeVSCU {
+		return strings.TrimRight(c.cfg.KRA.VSCUURL, "/")
+	}
+	if c.cfg.KRA.APIURL == "" || c.cfg.KRA.APIURL == "https://api.kra.go.ke" {
+		return ""
+	}
+	return strings.TrimRight(c.cfg.KRA.APIURL, "/") + "/etims/v1"
+}
+
+// Simulated reports whether requests for a device mode go to the simulator
+func (c *ETIMSClient) Simulated(mode string) bool {
+	return c.baseURL(mode) == "" && c.cfg.Server.Mode != "production"
+}
+
+// call posts a request and decodes the envelope's data into out. "No records"
+// is not an error: out is left empty.
+func (c *ETIMSClient) call(ctx context.Context, id etimsIdentity, path string, request, out interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode eTIMS request: %w", err)
+	}
+
+	var envelope *etimsEnvelope
+	if base := c.baseURL(id.Mode); base != "" {
+		envelope, err = c.post(ctx, base+path, id, body)
+		if err != nil {
+			return err
+		}
+	} else if c.Simulated(id.Mode) {
+		envelope = c.simulator.handle(id, path, body)
+	} else {
+		return ErrMockMode
+	}
+
+	switch envelope.ResultCd {
+	case etimsResultOK:
+	case etimsResultNoRecords:
+		return nil
+	default:
+		return &ETIMSError{Code: envelope.ResultCd, Message: envelope.ResultMsg}
+	}
+	if out == nil || len(envelope.Data) == 0 || string(envelope.Data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("failed to parse eTIMS response: %w", err)
+	}
+	return nil
+}
+
+func (c *ETIMSClient) post(ctx context.Context, url string, id etimsIdentity, body []byte) (*etimsEnvelope, error) {
+	result, err := circuitbreaker.KRACircuit().ExecuteWithResult(ctx, func(ctx context.Context) (interface{}, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("tin", id.TIN)
+		req.Header.Set("bhfId", id.BranchID)
+		if id.CMCKey != "" {
+			req.Header.Set("cmcKey", id.CMCKey)
+		}
+		if c.cfg.KRA.APIKey != "" && id.Mode != models.ETIMSModeVSCU {
+			req.Header.Set("Authorization", "Bearer "+c.cfg.KRA.APIKey)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("eTIMS request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read eTIMS response: %w", err)
+		}
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("eTIMS API error (status %d): %s", resp.StatusCode, string(data))
+		}
+		var envelope etimsEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, fmt.Errorf("failed to parse eTIMS response: %w", err)
+		}
+		return &envelope, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	envelope, ok := result.(*etimsEnvelope)
+	if !ok {
+		return nil, errors.New("unexpected eTIMS response")
+	}
+	return envelope, nil
+}
+
+// ---------------------------------------------------------------------------
+// Device initialization
+// ---------------------------------------------------------------------------
+
+type etimsInitRequest struct {
+	TIN      string `json:"tin"`
+	BhfID    string `json:"bhfId"`
+	DvcSrlNo string `json:"dvcSrlNo"`
+}
+
+type etimsDeviceInfo struct {
+	TIN     string `json:"tin"`
+	TaxprNm string `json:"taxprNm"`
+	BhfID   string `json:"bhfId"`
+	BhfNm   string `json:"bhfNm"`
+	DvcID   string `json:"dvcId"`
+	SdcID   string `json:"sdcId"`
+	MrcNo   string `json:"mrcNo"`
+	CmcKey  string `json:"cmcKey"`
+}
+
+// InitDevice initializes a control unit and returns its communication key
+func (c *ETIMSClient) InitDevice(ctx context.Context, mode, tin, branchID, serial string) (*etimsDeviceInfo, error) {
+	var out struct {
+		Info etimsDeviceInfo `json:"info"`
+	}
+	id := etimsIdentity{Mode: mode, TIN: tin, BranchID: branchID}
+	if err := c.call(ctx, id, etimsInitDevice, etimsInitRequest{TIN: tin, BhfID: branchID, DvcSrlNo: serial}, &out); err != nil {
+		return nil, err
+	}
+	return &out.Info, nil
+}
+
+// ---------------------------------------------------------------------------
+// Code lists
+// ---------------------------------------------------------------------------
+
+type etimsLastRequest struct {
+	TIN       string `json:"tin"`
+	BhfID     string `json:"bhfId"`
+	LastReqDt string `json:"lastReqDt"`
+}
+
+type etimsCodeClass struct {
+	CdCls   string `json:"cdCls"`
+	CdClsNm string `json:"cdClsNm"`
+	DtlList []struct {
+		Cd    string `json:"cd"`
+		CdNm  string `json:"cdNm"`
+		UseYn string `json:"useYn"`
+	} `json:"dtlList"`
+}
+
+type etimsItemClass struct {
+	ItemClsCd  string `json:"itemClsCd"`
+	ItemClsNm  string `json:"itemClsNm"`
+	ItemClsLvl int    `json:"itemClsLvl"`
+	TaxTyCd    string `json:"taxTyCd"`
+	UseYn      string `json:"useYn"`
+}
+
+// CodeList returns code lists changed since lastReqDt
+func (c *ETIMSClient) CodeList(ctx context.Context, id etimsIdentity, lastReqDt string) ([]etimsCodeClass, error) {
+	var out struct {
+		ClsList []etimsCodeClass `json:"clsList"`
+	}
+	err := c.call(ctx, id, etimsCodeList, etimsLastRequest{TIN: id.TIN, BhfID: id.BranchID, LastReqDt: lastReqDt}, &out)
+	return out.ClsList, err
+}
+
+// ItemClassList returns item classification codes changed since lastReqDt
+func (c *ETIMSClient) ItemClassList(ctx context.Context, id etimsIdentity, lastReqDt string) ([]etimsItemClass, error) {
+	var out struct {
+		ItemClsList []etimsItemClass `json:"itemClsList"`
+	}
+	err := c.call(ctx, id, etimsItemClassList, etimsLastRequest{TIN: id.TIN, BhfID: id.BranchID, LastReqDt: lastReqDt}, &out)
+	return out.ItemClsList, err
+}
+
+// ---------------------------------------------------------------------------
+// Items
+// ---------------------------------------------------------------------------
+
+type etimsItem struct {
+	TIN         string  `json:"tin"`
+	BhfID       string  `json:"bhfId"`
+	ItemCd      string  `json:"itemCd"`
+	ItemClsCd   string  `json:"itemClsCd"`
+	ItemTyCd    string  `json:"itemTyCd"`
+	ItemNm      string  `json:"itemNm"`
+	OrgnNatCd   string  `json:"orgnNatCd"`
+	PkgUnitCd   string  `json:"pkgUnitCd"`
+	QtyUnitCd   string  `json:"qtyUnitCd"`
+	TaxTyCd     string  `json:"taxTyCd"`
+	DftPrc      float64 `json:"dftPrc"`
+	IsrcAplcbYn string  `json:"isrcAplcbYn"`
+	UseYn       string  `json:"useYn"`
+	RegrID      string  `json:"regrId"`
+	RegrNm      string  `json:"regrNm"`
+	ModrID      string  `json:"modrId"`
+	ModrNm      string  `json:"modrNm"`
+}
+
+// SaveItem registers an item, or updates one already registered
+func (c *ETIMSClient) SaveItem(ctx context.Context, id etimsIdentity, item etimsItem) error {
+	item.TIN, item.BhfID = id.TIN, id.BranchID
+	return c.call(ctx, id, etimsSaveItem, item, nil)
+}
+
+// ---------------------------------------------------------------------------
+// Purchases
+// ---------------------------------------------------------------------------
+
+type etimsPurchaseItem struct {
+	ItemSeq   int     `json:"itemSeq"`
+	ItemCd    string  `json:"itemCd"`
+	ItemClsCd string  `json:"itemClsCd"`
+	ItemNm    string  `json:"itemNm"`
+	PkgUnitCd string  `json:"pkgUnitCd"`
+	QtyUnitCd string  `json:"qtyUnitCd"`
+	Qty       float64 `json:"qty"`
+	Prc       float64 `json:"prc"`
+	SplyAmt   float64 `json:"splyAmt"`
+	TaxTyCd   string  `json:"taxTyCd"`
+	TaxblAmt  float64 `json:"taxblAmt"`
+	TaxAmt    float64 `json:"taxAmt"`
+	TotAmt    float64 `json:"totAmt"`
+}
+
+type etimsPurchase struct {
+	SpplrTin    string              `json:"spplrTin"`
+	SpplrNm     string              `json:"spplrNm"`
+	SpplrBhfID  string              `json:"spplrBhfId"`
+	SpplrInvcNo string              `json:"spplrInvcNo"`
+	RcptTyCd    string              `json:"rcptTyCd"`
+	PmtTyCd     string              `json:"pmtTyCd"`
+	CfmDt       string              `json:"cfmDt"`
+	SalesDt     string              `json:"salesDt"`
+	TotItemCnt  int                 `json:"totItemCnt"`
+	TotTaxblAmt float64             `json:"totTaxblAmt"`
+	TotTaxAmt   float64             `json:"totTaxAmt"`
+	TotAmt      float64             `json:"totAmt"`
+	IntrlData   string              `json:"intrlData"`
+	ItemList    []etimsPurchaseItem `json:"itemList"`
+}
+
+// eTIMS purchase status codes
+const (
+	etimsPurchaseApproved = "02"
+	etimsPurchaseRejected = "04"
+)
+
+type etimsPurchaseConfirmation struct {
+	TIN         string              `json:"tin"`
+	BhfID       string              `json:"bhfId"`
+	InvcNo      int64               `json:"invcNo"`
+	SpplrTin    string              `json:"spplrTin"`
+	SpplrBhfID  string              `json:"spplrBhfId"`
+	SpplrNm     string              `json:"spplrNm"`
+	SpplrInvcNo string              `json:"spplrInvcNo"`
+	PchsTyCd    string              `json:"pchsTyCd"`
+	RcptTyCd    string              `json:"rcptTyCd"`
+	PmtTyCd     string              `json:"pmtTyCd"`
+	PchsSttsCd  string              `json:"pchsSttsCd"`
+	CfmDt       string              `json:"cfmDt"`
+	PchsDt      string              `json:"pchsDt"`
+	TotItemCnt  int                 `json:"totItemCnt"`
+	TotTaxblAmt float64             `json:"totTaxblAmt"`
+	TotTaxAmt   float64             `json:"totTaxAmt"`
+	TotAmt      float64             `json:"totAmt"`
+	RegrID      string              `json:"regrId"`
+	ModrID      string              `json:"modrId"`
+	ItemList    []etimsPurchaseItem `json:"itemList"`
+}
+
+// PurchaseList returns sales invoices issued to the taxpayer's PIN since lastReqDt
+func (c *ETIMSClient) PurchaseList(ctx context.Context, id etimsIdentity, lastReqDt string) ([]etimsPurchase, error) {
+	var out struct {
+		SaleList []etimsPurchase `json:"saleList"`
+	}
+	err := c.call(ctx, id, etimsPurchaseList, etimsLastRequest{TIN: id.TIN, BhfID: id.BranchID, LastReqDt: lastReqDt}, &out)
+	return out.SaleList, err
+}
+
+// ConfirmPurchase accepts or rejects a purchase
+func (c *ETIMSClient) ConfirmPurchase(ctx context.Context, id etimsIdentity, confirmation etimsPurchaseConfirmation) error {
+	confirmation.TIN, confirmation.BhfID = id.TIN, id.BranchID
+	return c.call(ctx, id, etimsConfirmPurchase, confirmation, nil)
+}
+
+// ---------------------------------------------------------------------------
+// Stock
+// ---------------------------------------------------------------------------
+
+type etimsStockItem struct {
+	ItemSeq   int     `json:"itemSeq"`
+	ItemCd    string  `json:"itemCd"`
+	ItemClsCd string  `json:"itemClsCd"`
+	ItemNm    string  `json:"itemNm"`
+	PkgUnitCd string  `json:"pkgUnitCd"`
+	Pkg       float64 `json:"pkg"`
+	QtyUnitCd string  `json:"qtyUnitCd"`
+	Qty       float64 `json:"qty"`
+	Prc       float64 `json:"prc"`
+	SplyAmt   float64 `json:"splyAmt"`
+	TaxTyCd   string  `json:"taxTyCd"`
+	TaxblAmt  float64 `json:"taxblAmt"`
+	TaxAmt    float64 `json:"taxAmt"`
+	TotAmt    float64 `json:"totAmt"`
+}
+
+type etimsStockReport struct {
+	TIN         string           `json:"tin"`
+	BhfID       string           `json:"bhfId"`
+	SarNo       int64            `json:"sarNo"`
+	OrgSarNo    int64            `json:"orgSarNo"`
+	RegTyCd     string           `json:"regTyCd"` // M manual, A automatic
+	SarTyCd     string           `json:"sarTyCd"`
+	OcrnDt      string           `json:"ocrnDt"`
+	TotItemCnt  int              `json:"totItemCnt"`
+	TotTaxblAmt float64          `json:"totTaxblAmt"`
+	TotTaxAmt   float64          `json:"totTaxAmt"`
+	TotAmt      float64          `json:"totAmt"`
+	RegrID      string           `json:"regrId"`
+	ModrID      string           `json:"modrId"`
+	ItemList    []etimsStockItem `json:"itemList"`
+}
+
+type etimsStockMasterUpdate struct {
+	TIN    string  `json:"tin"`
+	BhfID  string  `json:"bhfId"`
+	ItemCd string  `json:"itemCd"`
+	RsdQty float64 `json:"rsdQty"`
+	RegrID string  `json:"regrId"`
+	ModrID string  `json:"modrId"`
+}
+
+// StockIO reports stock moving in or out
+func (c *ETIMSClient) StockIO(ctx context.Context, id etimsIdentity, movement etimsStockReport) error {
+	movement.TIN, movement.BhfID = id.TIN, id.BranchID
+	return c.call(ctx, id, etimsStockIO, movement, nil)
+}
+
+// StockMaster reports the quantity of an item left in stock
+func (c *ETIMSClient) StockMaster(ctx context.Context, id etimsIdentity, update etimsStockMasterUpdate) error {
+	update.TIN, update.BhfID = id.TIN, id.BranchID
+	return c.call(ctx, id, etimsStockMaster, update, nil)
+}
+
+// ---------------------------------------------------------------------------
+// Invoice lookup
+// ---------------------------------------------------------------------------
+
+type etimsInvoiceLookupRequest struct {
+	TIN      string `json:"tin"`
+	BhfID    string `json:"bhfId"`
+	SpplrTin string `json:"spplrTin"`
+	Icn      string `json:"icn"`
+}
+
+type etimsInvoiceInfo struct {
+	SpplrTin    string  `json:"spplrTin"`
+	SpplrNm     string  `json:"spplrNm"`
+	SpplrInvcNo string  `json:"spplrInvcNo"`
+	Icn         string  `json:"icn"`
+	CustTin     string  `json:"custTin"` // the buyer's PIN
+	SalesDt     string  `json:"salesDt"`
+	TotTaxblAmt float64 `json:"totTaxblAmt"`
+	TotTaxAmt   float64 `json:"totTaxAmt"`
+	TotAmt      float64 `json:"totAmt"`
+}
+
+// LookupInvoice looks up an invoice a supplier signed on eTIMS by its control
+// number. It returns nil when eTIMS has no such invoice.
+func (c *ETIMSClient) LookupInvoice(ctx context.Context, id etimsIdentity, supplierPIN, icn string) (*etimsInvoiceInfo, error) {
+	var out struct {
+		InvcInfo *etimsInvoiceInfo `json:"invcInfo"`
+	}
+	request := etimsInvoiceLookupRequest{TIN: id.TIN, BhfID: id.BranchID, SpplrTin: supplierPIN, Icn: icn}
+	if err := c.call(ctx, id, etimsInvoiceLookup, request, &out); err != nil {
+		return nil, err
+	}
+	return out.InvcInfo, nil
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// ETIMSSimulator answers eTIMS requests in dev mode, the way mockSubmit does for
+// sales. It keeps devices, items and stock in memory and serves a small fixed
+// code list and two purchases addressed to any PIN. State is lost on restart,
+// so a device it doesn't know is taken to be one it initialized earlier.
+type ETIMSSimulator struct {
+	mu        sync.Mutex
+	devices   map[string]string             // tin/bhfId -> cmcKey
+	items     map[string]map[string]bool    // tin -> registered item codes
+	stock     map[string]map[string]float64 // tin -> item code -> quantity on hand
+	confirmed map[string]map[string]string  // tin -> supplier invoice -> purchase status
+	published time.Time                     // when the sample purchases "arrived"
+}
+
+func NewETIMSSimulator() *ETIMSSimulator {
+	return &ETIMSSimulator{
+		devices:   make(map[string]string),
+		items:     make(map[string]map[string]bool),
+		stock:     make(map[string]map[string]float64),
+		confirmed: make(map[string]map[string]string),
+		published: time.Now().Add(-time.Minute),
+	}
+}
+
+// Sample code lists, a subset of what KRA publishes
+var etimsSimulatedCodes = []struct {
+	class, className string
+	codes            [][2]string
+}{
+	{models.ETIMSCodeTaxType, "Taxation Type", [][2]string{
+		{"A", "A-Exempt"}, {"B", "B-16.00%"}, {"C", "C-0%"}, {"D", "D-Non-VAT"}, {"E", "E-8%"},
+	}},
+	{models.ETIMSCodeCountry, "Country", [][2]string{
+		{"KE", "Kenya"}, {"UG", "Uganda"}, {"TZ", "Tanzania"}, {"CN", "China"}, {"AE", "United Arab Emirates"},
+	}},
+	{models.ETIMSCodeQuantityUnit, "Quantity Unit", [][2]string{
+		{"U", "Pieces/item [Number]"}, {"KG", "Kilo-Gramme"}, {"L", "Litre"}, {"M", "Metre"}, {"BA", "Barrel"},
+	}},
+	{models.ETIMSCodeStockIOType, "Stock I/O Type", [][2]string{
+		{"01", "Import"}, {"02", "Purchase"}, {"03", "Return"}, {"06", "Adjustment"},
+		{"11", "Sale"}, {"12", "Return"}, {"15", "Discarding"}, {"16", "Adjustment"},
+	}},
+	{models.ETIMSCodePackagingUnit, "Packing Unit", [][2]string{
+		{"NT", "Net"}, {"BX", "Box"}, {"BG", "Bag"}, {"CT", "Carton"}, {"BL", "Bale"},
+	}},
+}
+
+var etimsSimulatedItemClasses = []etimsItemClass{
+	{ItemClsCd: "10101500", ItemClsNm: "Livestock", ItemClsLvl: 4, TaxTyCd: "A", UseYn: "Y"},
+	{ItemClsCd: "15101500", ItemClsNm: "Petroleum and distillates", ItemClsLvl: 4, TaxTyCd: "E", UseYn: "Y"},
+	{ItemClsCd: "43211500", ItemClsNm: "Computers", ItemClsLvl: 4, TaxTyCd: "B", UseYn: "Y"},
+	{ItemClsCd: "50202300", ItemClsNm: "Non alcoholic beverages", ItemClsLvl: 4, TaxTyCd: "B", UseYn: "Y"},
+	{ItemClsCd: "81111500", ItemClsNm: "Software or hardware engineering", ItemClsLvl: 4, TaxTyCd: "B", UseYn: "Y"},
+	{ItemClsCd: "86101700", ItemClsNm: "Vocational training services", ItemClsLvl: 4, TaxTyCd: "A", UseYn: "Y"},
+}
+
+func etimsSimulatedPurchases(published time.Time) []etimsPurchase {
+	day := published.AddDate(0, 0, -1)
+	return []etimsPurchase{
+		{
+			SpplrTin: "P051234567X", SpplrNm: "Simulated Supplies Ltd", SpplrBhfID: "00", SpplrInvcNo: "1001",
+			RcptTyCd: "S", PmtTyCd: "01", CfmDt: published.Format(etimsDateTime), SalesDt: day.Format("20060102"),
+			TotItemCnt: 1, TotTaxblAmt: 10000, TotTaxAmt: 1600, TotAmt: 11600, IntrlData: "SIMULATED1001",
+			ItemList: []etimsPurchaseItem{{
+				ItemSeq: 1, ItemCd: "KE1NTU0000001", ItemClsCd: "43211500", ItemNm: "Laptop", PkgUnitCd: "NT", QtyUnitCd: "U",
+				Qty: 1, Prc: 10000, SplyAmt: 10000, TaxTyCd: "B", TaxblAmt: 10000, TaxAmt: 1600, TotAmt: 11600,
+			}},
+		},
+		{
+			SpplrTin: "P052345678Y", SpplrNm: "Demo Wholesalers", SpplrBhfID: "00", SpplrInvcNo: "INV-778",
+			RcptTyCd: "S", PmtTyCd: "02", CfmDt: published.Format(etimsDateTime), SalesDt: day.Format("20060102"),
+			TotItemCnt: 2, TotTaxblAmt: 5500, TotTaxAmt: 800, TotAmt: 6300, IntrlData: "SIMULATED778",
+			ItemList: []etimsPurchaseItem{
+				{
+					ItemSeq: 1, ItemCd: "KE2BXU0000014", ItemClsCd: "50202300", ItemNm: "Bottled water 24x500ml", PkgUnitCd: "BX", QtyUnitCd: "U",
+					Qty: 10, Prc: 500, SplyAmt: 5000, TaxTyCd: "B", TaxblAmt: 5000, TaxAmt: 800, TotAmt: 5800,
+				},
+				{
+					ItemSeq: 2, ItemCd: "KE2NTU0000020", ItemClsCd: "10101500", ItemNm: "Eggs tray", PkgUnitCd: "NT", QtyUnitCd: "U",
+					Qty: 1, Prc: 500, SplyAmt: 500, TaxTyCd: "A", TaxblAmt: 500, TaxAmt: 0, TotAmt: 500,
+				},
+			},
+		},
+	}
+}
+
+// Supplier PINs and invoice control numbers (e.g. KRACU0100000001/152) the
+// simulator takes to be registered
+var (
+	simulatedPIN = regexp.MustCompile(`^[AP][0-9]{9}[A-Z]$`)
+	simulatedICN = regexp.MustCompile(`^[A-Z0-9][A-Z0-9/-]{7,}$`)
+)
+
+// A control number the simulator takes to be issued to someone else's PIN,
+// and that PIN
+const (
+	simulatedOtherBuyerICN = "KRACU0100000009/001"
+	simulatedOtherBuyerPIN = "P059999999Z"
+)
+
+func simulatorReply(code, msg string, data interface{}) *etimsEnvelope {
+	envelope := &etimsEnvelope{ResultCd: code, ResultMsg: msg, ResultDt: time.Now().Format(etimsDateTime)}
+	if data != nil {
+		envelope.Data, _ = json.Marshal(data)
+	}
+	return envelope
+}
+
+// handle answers one request. Result codes follow eTIMS: 000 success, 001 no
+// records, 9xx errors.
+func (s *ETIMSSimulator) handle(id etimsIdentity, path string, body []byte) *etimsEnvelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logger.Get().Info(context.Background(), "eTIMS SIMULATOR request",
+		"path", path,
+		"tin", maskPIN(id.TIN),
+		"branch", id.BranchID,
+	)
+
+	device := id.TIN + "/" + id.BranchID
+	if path == etimsInitDevice {
+		var req etimsInitRequest
+		if err := json.Unmarshal(body, &req); err != nil || req.TIN == "" || req.DvcSrlNo == "" {
+			return simulatorReply("910", "Request parameter error", nil)
+		}
+		key, ok := s.devices[device]
+		if !ok {
+			key = strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", ""))
+			s.devices[device] = key
+		}
+		return simulatorReply(etimsResultOK, "It is succeeded", map[string]interface{}{
+			"info": etimsDeviceInfo{
+				TIN:     req.TIN,
+				TaxprNm: "SIMULATED TAXPAYER",
+				BhfID:   req.BhfID,
+				BhfNm:   "Headquarter",
+				DvcID:   req.DvcSrlNo,
+				SdcID:   "KRACU0100000001",
+				MrcNo:   "WIS00000001",
+				CmcKey:  key,
+			},
+		})
+	}
+
+	key, ok := s.devices[device]
+	if !ok && id.CMCKey != "" {
+		key = id.CMCKey
+		s.devices[device] = key
+	}
+	if key == "" || key != id.CMCKey {
+		return simulatorReply("901", "It is not valid device", nil)
+	}
+
+	switch path {
+	case etimsCodeList:
+		var req etimsLastRequest
+		_ = json.Unmarshal(body, &req)
+		if req.LastReqDt >= s.published.Format(etimsDateTime) {
+			return simulatorReply(etimsResultNoRecords, "There is no search result", nil)
+		}
+		classes := make([]map[string]interface{}, 0, len(etimsSimulatedCodes))
+		for _, class := range etimsSimulatedCodes {
+			details := make([]map[string]string, 0, len(class.codes))
+			for _, code := range class.codes {
+				details = append(details, map[string]string{"cd": code[0], "cdNm": code[1], "useYn": "Y"})
+			}
+			classes = append(classes, map[string]interface{}{"cdCls": class.class, "cdClsNm": class.className, "dtlList": details})
+		}
+		return simulatorReply(etimsResultOK, "It is succeeded", map[string]interface{}{"clsList": classes})
+
+	case etimsItemClassList:
+		var req etimsLastRequest
+		_ = json.Unmarshal(body, &req)
+		if req.LastReqDt >= s.published.Format(etimsDateTime) {
+			return simulatorReply(etimsResultNoRecords, "There is no search result", nil)
+		}
+		return simulatorReply(etimsResultOK, "It is succeeded", map[string]interface{}{"itemClsList": etimsSimulatedItemClasses})
+
+	case etimsSaveItem:
+		var req etimsItem
+		if err := json.Unmarshal(body, &req); err != nil || req.ItemCd == "" || req.ItemClsCd == "" {
+			return simulatorReply("910", "Request parameter error", nil)
+		}
+		if s.items[id.TIN] == nil {
+			s.items[id.TIN] = make(map[string]bool)
+		}
+		s.items[id.TIN][req.ItemCd] = true
+		return simulatorReply(etimsResultOK, "It is succeeded", nil)
+
+	case etimsPurchaseList:
+		var req etimsLastRequest
+		_ = json.Unmarshal(body, &req)
+		if req.LastReqDt >= s.published.Format(etimsDateTime) {
+			return simulatorReply(etimsResultNoRecords, "There is no search result", nil)
+		}
+		return simulatorReply(etimsResultOK, "It is succeeded", map[string]interface{}{"saleList": etimsSimulatedPurchases(s.published)})
+
+	case etimsConfirmPurchase:
+		var req etimsPurchaseConfirmation
+		if err := json.Unmarshal(body, &req); err != nil || req.SpplrInvcNo == "" {
+			return simulatorReply("910", "Request parameter error", nil)
+		}
+		if s.confirmed[id.TIN] == nil {
+			s.confirmed[id.TIN] = make(map[string]string)
+		}
+		invoice := req.SpplrTin + "/" + req.SpplrInvcNo
+		if _, done := s.confirmed[id.TIN][invoice]; done {
+			return simulatorReply("924", "Purchase has already been confirmed", nil)
+		}
+		s.confirmed[id.TIN][invoice] = req.PchsSttsCd
+		return simulatorReply(etimsResultOK, "It is succeeded", nil)
+
+	case etimsStockIO:
+		var req etimsStockReport
+		if err := json.Unmarshal(body, &req); err != nil || len(req.ItemList) == 0 {
+			return simulatorReply("910", "Request parameter error", nil)
+		}
+		return simulatorReply(etimsResultOK, "It is succeeded", nil)
+
+	case etimsStockMaster:
+		var req etimsStockMasterUpdate
+		if err := json.Unmarshal(body, &req); err != nil {
+			return simulatorReply("910", "Request parameter error", nil)
+		}
+		if s.stock[id.TIN] == nil {
+			s.stock[id.TIN] = make(map[string]float64)
+		}
+		s.stock[id.TIN][req.ItemCd] = req.RsdQty
+		return simulatorReply(etimsResultOK, "It is succeeded", nil)
+
+	case etimsInvoiceLookup:
+		var req etimsInvoiceLookupRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return simulatorReply("910", "Request parameter error", nil)
+		}
+		for _, sale := range etimsSimulatedPurchases(s.published) {
+			if sale.SpplrTin == req.SpplrTin && sale.IntrlData == req.Icn {
+				return simulatorReply(etimsResultOK, "It is succeeded", map[string]interface{}{"invcInfo": etimsInvoiceInfo{
+					SpplrTin: sale.SpplrTin, SpplrNm: sale.SpplrNm, SpplrInvcNo: sale.SpplrInvcNo, Icn: sale.IntrlData, CustTin: id.TIN,
+					SalesDt: sale.SalesDt, TotTaxblAmt: sale.TotTaxblAmt, TotTaxAmt: sale.TotTaxAmt, TotAmt: sale.TotAmt,
+				}})
+			}
+		}
+		// Any other well-formed PIN and control number is taken to be
+		// registered; the simulator doesn't know its amounts
+		if !simulatedPIN.MatchString(req.SpplrTin) || !simulatedICN.MatchString(req.Icn) {
+			return simulatorReply(etimsResultNoRecords, "There is no search result", nil)
+		}
+		buyer := id.TIN
+		if req.Icn == simulatedOtherBuyerICN {
+			buyer = simulatedOtherBuyerPIN
+		}
+		return simulatorReply(etimsResultOK, "It is succeeded", map[string]interface{}{"invcInfo": etimsInvoiceInfo{
+			SpplrTin: req.SpplrTin, Icn: req.Icn, CustTin: buyer,
+		}})
+	}
+
+	return simulatorReply("999", "Unknown endpoint "+path, nil)
+}
@@ -3,16 +3,25 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"invoicefast/internal/database"
 	"invoicefast/internal/logger"
 	"invoicefast/internal/models"
+	"invoicefast/internal/utils"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrExchangeRateInvalid  = errors.New("a supported foreign currency, a date and a rate above zero are required")
+	ErrExchangeRateNotFound = errors.New("exchange rate not found")
 )
 
 type ExchangeRateService struct {
@@ -27,8 +36,9 @@ type ExchangeRateService struct {
 func NewExchangeRateService(db *database.DB) *ExchangeRateService {
 	svc := &ExchangeRateService{
 		db:     db,
-		apiURL: "https://api.centralbank.go.ke/exchange-rates",
-		stopCh: make(chan struct{}),
+		apiURL:      "https://api.centralbank.go.ke/exchange-rates",
+		stopCh:      make(chan struct{}),
+		cachedRates: make(map[string]float64),
 	}
 	// Fetch rates on startup
 	svc.fetchRates()
@@ -48,6 +58,12 @@ func (s *ExchangeRateService) GetRate(from, to string) (float64, error) {
 
 	s.mu.RLock()
 	rate, ok := s.cachedRates[fmt.Sprintf("%s/%s", from, to)]
+	if !ok {
+		// Rates are cached per KES; the other direction is the inverse
+		if inverse := s.cachedRates[fmt.Sprintf("%s/%s", to, from)]; inverse > 0 {
+			rate, ok = 1/inverse, true
+		}
+	}
 	s.mu.RUnlock()
 
 	if ok {
@@ -80,7 +96,7 @@ func (s *ExchangeRateService) GetKESEquivalent(amount float64, currency string)
 		return 0, err
 	}
 
-	return amount / rate, nil
+	return amount * rate, nil
 }
 
 func (s *ExchangeRateService) FetchRatesFromCBK() error {
@@ -107,19 +123,26 @@ func (s *ExchangeRateService) FetchRatesFromCBK() error {
 	}
 
 	s.mu.Lock()
+	fetched := make(map[string]float64)
 	for _, r := range rates {
 		switch r.Code {
-		case "USD":
-			s.cachedRates["KES/USD"] = 1.0 / r.Rate
-		case "EUR":
-			s.cachedRates["KES/EUR"] = 1.0 / r.Rate
-		case "GBP":
-			s.cachedRates["KES/GBP"] = 1.0 / r.Rate
+		case "USD", "EUR", "GBP":
+			if r.Rate > 0 {
+				s.cachedRates["KES/"+r.Code] = 1.0 / r.Rate
+				fetched[r.Code] = 1.0 / r.Rate
+			}
 		}
 	}
 	s.lastUpdated = time.Now()
 	s.mu.Unlock()
 
+	// Keep the day's rate so documents can be valued as of any date
+	for currency, rate := range fetched {
+		if err := s.StoreRateInDB(currency, rate); err != nil {
+			logger.Get().Error(context.Background(), "Failed to store exchange rate", "currency", currency, "error", err)
+		}
+	}
+
 	logger.Get().Info(context.Background(), "Updated rates from CBK")
 	return nil
 }
@@ -141,13 +164,17 @@ func (s *ExchangeRateService) loadFromDB() {
 		s.cachedRates = make(map[string]float64)
 	}
 	
+	// The latest published rate for each currency
+	latest := func() *gorm.DB {
+		return s.db.Where("tenant_id IS NULL AND valid_from = (SELECT MAX(r.valid_from) FROM exchange_rates r WHERE r.currency = exchange_rates.currency AND r.tenant_id IS NULL)")
+	}
 	var rates []models.ExchangeRate
-	err := s.db.Limit(100).Find(&rates).Error
+	err := latest().Find(&rates).Error
 	if err == nil {
 		if len(rates) == 0 {
 			// No rates in DB - seed default rates
 			s.seedDefaultRates()
-			s.db.Limit(100).Find(&rates)
+			latest().Find(&rates)
 		}
 		for _, r := range rates {
 			key := fmt.Sprintf("KES/%s", r.Currency)
@@ -176,7 +203,8 @@ func (s *ExchangeRateService) seedDefaultRates() {
 			Currency:     r.Currency,
 			BaseCurrency: "KES",
 			Rate:         r.Rate,
-			ValidFrom:    time.Now(),
+			ValidFrom:    rateDay(time.Now()),
+			Source:       models.ExchangeRateSourceDefault,
 			CreatedAt:    time.Now(),
 		}
 		if err := s.db.Create(&rate).Error; err != nil {
@@ -214,19 +242,17 @@ func (s *ExchangeRateService) Stop() {
 	close(s.stopCh)
 }
 
+// StoreRateInDB records today's published rate (units of currency per KES),
+// replacing one already stored for today
 func (s *ExchangeRateService) StoreRateInDB(currency string, rate float64) error {
-	rateRecord := models.ExchangeRate{
-		Currency:     currency,
-		Rate:         rate,
-		BaseCurrency: "KES",
-		ValidFrom:    time.Now(),
-	}
+	day := rateDay(time.Now())
 
-	result := s.db.Where("currency = ?", currency).Assign(models.ExchangeRate{
-		Currency:  currency,
-		Rate:      rate,
-		ValidFrom: time.Now(),
-	}).FirstOrCreate(&rateRecord)
+	// One published rate per currency and day
+	var rateRecord models.ExchangeRate
+	result := s.db.Where("tenant_id IS NULL AND currency = ? AND valid_from >= ? AND valid_from < ?", currency, day, day.AddDate(0, 0, 1)).
+		Attrs(models.ExchangeRate{ID: uuid.New().String(), Currency: currency, BaseCurrency: "KES", ValidFrom: day}).
+		Assign(map[string]interface{}{"rate": rate, "source": models.ExchangeRateSourceCBK}).
+		FirstOrCreate(&rateRecord)
 
 	return result.Error
 }
@@ -241,3 +267,126 @@ func (s *ExchangeRateService) GetAllRates() map[string]float64 {
 	}
 	return rates
 }
+
+// ============================================================================
+// DATED RATES AND OVERRIDES
+// ============================================================================
+
+// rateDay is the day a rate applies to
+func rateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// RateOn returns KES per unit of currency on a date: the tenant's override for
+// that day, else the latest published rate on or before it, else today's rate
+func (s *ExchangeRateService) RateOn(tenantID, currency string, date time.Time) (float64, error) {
+	rate, err := s.DatedRateOn(tenantID, currency, date)
+	if errors.Is(err, ErrExchangeRateNotFound) {
+		return s.GetRate(strings.ToUpper(currency), "KES")
+	}
+	return rate, err
+}
+
+// DatedRateOn is RateOn without the fallback to today's rate: it returns
+// ErrExchangeRateNotFound when nothing was published on or before the date
+func (s *ExchangeRateService) DatedRateOn(tenantID, currency string, date time.Time) (float64, error) {
+	currency = strings.ToUpper(currency)
+	if currency == "" || currency == "KES" {
+		return 1, nil
+	}
+	day := rateDay(date)
+
+	var rate models.ExchangeRate
+	if tenantID != "" {
+		err := s.db.Where("tenant_id = ? AND currency = ? AND valid_from >= ? AND valid_from < ?", tenantID, currency, day, day.AddDate(0, 0, 1)).
+			First(&rate).Error
+		if err == nil && rate.Rate > 0 {
+			return 1 / rate.Rate, nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, err
+		}
+	}
+
+	err := s.db.Where("tenant_id IS NULL AND currency = ? AND valid_from < ? AND rate > 0", currency, day.AddDate(0, 0, 1)).
+		Order("valid_from DESC").First(&rate).Error
+	if err == nil {
+		return 1 / rate.Rate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	return 0, ErrExchangeRateNotFound
+}
+
+// SetOverride sets the tenant's own rate for a currency on one day, e.g. the
+// rate their bank actually gave them
+func (s *ExchangeRateService) SetOverride(tenantID, userID, currency string, date time.Time, kesPerUnit float64) (*models.ExchangeRate, error) {
+	currency = strings.ToUpper(currency)
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	if currency == "KES" || !utils.IsValidCurrency(currency) || date.IsZero() || kesPerUnit <= 0 {
+		return nil, ErrExchangeRateInvalid
+	}
+	day := rateDay(date)
+
+	// One override per tenant, currency and day
+	var rate models.ExchangeRate
+	err := s.db.Where("tenant_id = ? AND currency = ? AND valid_from >= ? AND valid_from < ?", tenantID, currency, day, day.AddDate(0, 0, 1)).
+		First(&rate).Error
+	switch {
+	case err == nil:
+		err = s.db.Model(&rate).Updates(map[string]interface{}{"rate": 1 / kesPerUnit, "created_by": userID}).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		rate = models.ExchangeRate{
+			ID:           uuid.New().String(),
+			TenantID:     &tenantID,
+			Currency:     currency,
+			BaseCurrency: "KES",
+			Rate:         1 / kesPerUnit,
+			ValidFrom:    day,
+			Source:       models.ExchangeRateSourceManual,
+			CreatedBy:    userID,
+		}
+		err = s.db.Create(&rate).Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save exchange rate: %w", err)
+	}
+	rate.KESPerUnit = kesPerUnit
+	return &rate, nil
+}
+
+// DeleteOverride removes one of the tenant's overrides; the published rate applies again
+func (s *ExchangeRateService) DeleteOverride(tenantID, id string) error {
+	result := s.db.Where("tenant_id = ? AND id = ?", tenantID, id).Delete(&models.ExchangeRate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrExchangeRateNotFound
+	}
+	return nil
+}
+
+// ListRates returns published rates and the tenant's overrides between two
+// dates, newest first, optionally for one currency
+func (s *ExchangeRateService) ListRates(tenantID, currency string, from, to time.Time) ([]models.ExchangeRate, error) {
+	query := s.db.Where("(tenant_id IS NULL OR tenant_id = ?) AND valid_from >= ? AND valid_from < ?",
+		tenantID, rateDay(from), rateDay(to).AddDate(0, 0, 1))
+	if currency != "" {
+		query = query.Where("currency = ?", strings.ToUpper(currency))
+	}
+	var rates []models.ExchangeRate
+	if err := query.Order("valid_from DESC, currency").Limit(1000).Find(&rates).Error; err != nil {
+		return nil, err
+	}
+	for i := range rates {
+		if rates[i].Rate > 0 {
+			rates[i].KESPerUnit = 1 / rates[i].Rate
+		}
+	}
+	return rates, nil
+}
@@ -1,21 +1,30 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"mime/multipart"
+	"strings"
 	"time"
 
 	"invoicefast/internal/database"
 	"invoicefast/internal/models"
+	"invoicefast/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrExpenseSupplierPIN is returned for a supplier PIN that isn't a KRA PIN
+var ErrExpenseSupplierPIN = errors.New("supplier_pin is not a valid KRA PIN")
+
 type ExpenseService struct {
 	db          *database.DB
 	attachmentService *ExpenseAttachmentService
+	periods           *PeriodService
+	etims             *ETIMSService
 }
 
 func NewExpenseService(db *database.DB) *ExpenseService {
@@ -25,6 +34,26 @@ func NewExpenseService(db *database.DB) *ExpenseService {
 	}
 }
 
+// SetPeriodService rejects changes dated in closed accounting periods (optional)
+func (s *ExpenseService) SetPeriodService(periods *PeriodService) {
+	s.periods = periods
+}
+
+// SetETIMSService verifies supplier invoices against eTIMS so their input VAT
+// can be claimed (optional; without it expenses stay unverified)
+func (s *ExpenseService) SetETIMSService(etims *ETIMSService) {
+	s.etims = etims
+}
+
+// expenseDates are the dates an expense is booked on: incurred and paid
+func expenseDates(expense *models.Expense) []time.Time {
+	dates := []time.Time{expense.Date}
+	if expense.PaidAt != nil {
+		dates = append(dates, *expense.PaidAt)
+	}
+	return dates
+}
+
 type CreateExpenseRequest struct {
 	CategoryID      string  `json:"category_id"`
 	Title           string  `json:"title"`
@@ -38,6 +67,8 @@ type CreateExpenseRequest struct {
 	Vendor          string  `json:"vendor"`
 	TaxAmount       float64 `json:"tax_amount"`
 	TaxRate         float64 `json:"tax_rate"`
+	SupplierPIN     string  `json:"supplier_pin"`
+	SupplierICN     string  `json:"supplier_icn"`
 	IsRecurring     bool    `json:"is_recurring"`
 	RecurringPeriod string  `json:"recurring_period"`
 	Notes           string  `json:"notes"`
@@ -56,17 +87,28 @@ type UpdateExpenseRequest struct {
 	Vendor          *string  `json:"vendor"`
 	TaxAmount       *float64 `json:"tax_amount"`
 	TaxRate         *float64 `json:"tax_rate"`
+	SupplierPIN     *string  `json:"supplier_pin"`
+	SupplierICN     *string  `json:"supplier_icn"`
 	IsRecurring     *bool    `json:"is_recurring"`
 	RecurringPeriod *string  `json:"recurring_period"`
 	Notes           *string  `json:"notes"`
 	ApprovedBy      *string  `json:"approved_by"`
 }
 
-func (s *ExpenseService) CreateExpense(tenantID, userID string, req *CreateExpenseRequest) (*models.Expense, error) {
+// CreateExpense records an expense and checks its supplier invoice against
+// eTIMS within ctx, so the lookup ends with the request that made it
+func (s *ExpenseService) CreateExpense(ctx context.Context, tenantID, userID string, req *CreateExpenseRequest) (*models.Expense, error) {
 	expenseDate := time.Now()
 	if req.Date != "" {
 		expenseDate, _ = time.Parse("2006-01-02", req.Date)
 	}
+	if err := s.periods.CheckOpen(tenantID, expenseDate); err != nil {
+		return nil, err
+	}
+	supplierPIN, err := supplierKRAPIN(req.SupplierPIN)
+	if err != nil {
+		return nil, err
+	}
 
 	expense := &models.Expense{
 		ID:              uuid.New().String(),
@@ -83,6 +125,9 @@ func (s *ExpenseService) CreateExpense(tenantID, userID string, req *CreateExpen
 		Vendor:          req.Vendor,
 		TaxAmount:       models.ToCents(req.TaxAmount),
 		TaxRate:         req.TaxRate,
+		SupplierPIN:     supplierPIN,
+		SupplierICN:     strings.ToUpper(strings.TrimSpace(req.SupplierICN)),
+		ETIMSStatus:     models.ExpenseETIMSUnverified,
 		IsRecurring:     req.IsRecurring,
 		RecurringPeriod: req.RecurringPeriod,
 		Notes:           req.Notes,
@@ -96,6 +141,7 @@ func (s *ExpenseService) CreateExpense(tenantID, userID string, req *CreateExpen
 	if err := s.db.Create(expense).Error; err != nil {
 		return nil, fmt.Errorf("failed to create expense: %w", err)
 	}
+	s.verifyETIMS(ctx, expense)
 
 	return expense, nil
 }
@@ -109,6 +155,9 @@ func (s *ExpenseService) GetExpenses(tenantID string, filters map[string]interfa
 	if status, ok := filters["status"].(string); ok && status != "" {
 		query = query.Where("status = ?", status)
 	}
+	if etimsStatus, ok := filters["etims_status"].(string); ok && etimsStatus != "" {
+		query = query.Where("etims_status = ?", etimsStatus)
+	}
 	
 	// Date range
 	startDate := ""
@@ -194,11 +243,16 @@ func (s *ExpenseService) GetExpenseByID(tenantID, expenseID string) (*models.Exp
 	return &expense, nil
 }
 
-func (s *ExpenseService) UpdateExpense(tenantID, expenseID string, req *UpdateExpenseRequest) (*models.Expense, error) {
+// UpdateExpense updates an expense, checking its supplier invoice against eTIMS
+// again within ctx when the supplier, ICN or VAT changed
+func (s *ExpenseService) UpdateExpense(ctx context.Context, tenantID, expenseID string, req *UpdateExpenseRequest) (*models.Expense, error) {
 	expense, err := s.GetExpenseByID(tenantID, expenseID)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.periods.CheckOpen(tenantID, expenseDates(expense)...); err != nil {
+		return nil, err
+	}
 
 	if req.CategoryID != nil {
 		expense.CategoryID = *req.CategoryID
@@ -217,6 +271,9 @@ func (s *ExpenseService) UpdateExpense(tenantID, expenseID string, req *UpdateEx
 	}
 	if req.Date != nil {
 		expense.Date, _ = time.Parse("2006-01-02", *req.Date)
+		if err := s.periods.CheckOpen(tenantID, expense.Date); err != nil {
+			return nil, err
+		}
 	}
 	if req.Status != nil {
 		expense.Status = *req.Status
@@ -244,6 +301,20 @@ func (s *ExpenseService) UpdateExpense(tenantID, expenseID string, req *UpdateEx
 	if req.TaxRate != nil {
 		expense.TaxRate = *req.TaxRate
 	}
+	// A changed supplier invoice or VAT amount has to be verified again
+	reverify := req.TaxAmount != nil
+	if req.SupplierPIN != nil {
+		pin, err := supplierKRAPIN(*req.SupplierPIN)
+		if err != nil {
+			return nil, err
+		}
+		expense.SupplierPIN = pin
+		reverify = true
+	}
+	if req.SupplierICN != nil {
+		expense.SupplierICN = strings.ToUpper(strings.TrimSpace(*req.SupplierICN))
+		reverify = true
+	}
 	if req.IsRecurring != nil {
 		expense.IsRecurring = *req.IsRecurring
 	}
@@ -262,11 +333,82 @@ func (s *ExpenseService) UpdateExpense(tenantID, expenseID string, req *UpdateEx
 	if err := s.db.Save(expense).Error; err != nil {
 		return nil, fmt.Errorf("failed to update expense: %w", err)
 	}
+	if reverify {
+		s.verifyETIMS(ctx, expense)
+	}
 
 	return expense, nil
 }
 
+// VerifyExpense checks the expense's supplier invoice against eTIMS again, such
+// as after a lookup that failed or once the supplier has signed the invoice
+func (s *ExpenseService) VerifyExpense(ctx context.Context, tenantID, expenseID string) (*models.Expense, error) {
+	expense, err := s.GetExpenseByID(tenantID, expenseID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifyETIMS(ctx, expense); err != nil {
+		return nil, err
+	}
+	return expense, nil
+}
+
+// verifyETIMS looks up the supplier invoice behind an expense and records
+// whether its input VAT can be claimed. A lookup that couldn't be made leaves
+// the expense unverified with the reason.
+func (s *ExpenseService) verifyETIMS(ctx context.Context, expense *models.Expense) error {
+	status, note := models.ExpenseETIMSUnverified, ""
+	switch {
+	case expense.SupplierPIN == "" || expense.SupplierICN == "":
+		note = "supplier PIN and invoice ICN are needed to claim input VAT"
+	case s.etims == nil:
+		note = "eTIMS verification is not available"
+	default:
+		invoice, err := s.etims.VerifySupplierInvoice(ctx, expense.TenantID, expense.SupplierPIN, expense.SupplierICN)
+		switch {
+		case errors.Is(err, ErrETIMSInvoiceNotFound), errors.Is(err, ErrETIMSInvoiceRejected), errors.Is(err, ErrETIMSInvoiceOtherBuyer):
+			status, note = models.ExpenseETIMSRejected, err.Error()
+		case err != nil:
+			note = "eTIMS lookup failed: " + err.Error()
+		case invoice.TaxAmount > 0 && expense.TaxAmount > invoice.TaxAmount:
+			status = models.ExpenseETIMSRejected
+			note = fmt.Sprintf("VAT of %.2f is more than the %.2f charged on the supplier's invoice", expense.TaxAmount.Float64(), invoice.TaxAmount.Float64())
+		default:
+			status = models.ExpenseETIMSVerified
+		}
+	}
+
+	updates := map[string]interface{}{"etims_status": status, "etims_note": note, "etims_verified_at": nil}
+	expense.ETIMSStatus, expense.ETIMSNote, expense.ETIMSVerifiedAt = status, note, nil
+	if status == models.ExpenseETIMSVerified {
+		now := time.Now()
+		expense.ETIMSVerifiedAt = &now
+		updates["etims_verified_at"] = now
+	}
+	if err := s.db.Model(expense).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update expense: %w", err)
+	}
+	return nil
+}
+
+// supplierKRAPIN normalises a supplier PIN, which may be left empty
+func supplierKRAPIN(pin string) (string, error) {
+	pin = utils.SanitizeKRAPIN(pin)
+	if utils.ValidateKRAPIN(pin) != nil {
+		return "", ErrExpenseSupplierPIN
+	}
+	return pin, nil
+}
+
 func (s *ExpenseService) DeleteExpense(tenantID, expenseID string) error {
+	expense, err := s.GetExpenseByID(tenantID, expenseID)
+	if err != nil {
+		return err
+	}
+	if err := s.periods.CheckOpen(tenantID, expenseDates(expense)...); err != nil {
+		return err
+	}
+
 	result := s.db.Where("id = ? AND tenant_id = ?", expenseID, tenantID).Delete(&models.Expense{})
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete expense: %w", result.Error)
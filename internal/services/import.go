@@ -0,0 +1,1084 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/utils"
+	"invoicefast/internal/xlsx"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// BULK IMPORT - Clients, items and historical invoices from CSV / XLSX
+// ============================================================================
+
+const (
+	maxImportRows     = 10000
+	maxImportFileSize = 10 << 20
+	importPreviewRows = 5
+)
+
+var ErrImportNotFound = errors.New("import not found")
+
+// ImportField describes a target field a spreadsheet column can be mapped to
+type ImportField struct {
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Required bool     `json:"required"`
+	Aliases  []string `json:"-"` // header names matched when suggesting a mapping
+}
+
+var importFields = map[string][]ImportField{
+	models.ImportEntityClients: {
+		{Key: "name", Label: "Name", Required: true, Aliases: []string{"client", "client name", "customer", "customer name", "company", "company name"}},
+		{Key: "email", Label: "Email", Aliases: []string{"email address", "e-mail"}},
+		{Key: "phone", Label: "Phone", Aliases: []string{"phone number", "mobile", "telephone", "tel"}},
+		{Key: "address", Label: "Address", Aliases: []string{"physical address", "location"}},
+		{Key: "kra_pin", Label: "KRA PIN", Aliases: []string{"pin", "kra pin", "tax pin", "tax id"}},
+		{Key: "currency", Label: "Currency"},
+		{Key: "payment_terms", Label: "Payment Terms (days)", Aliases: []string{"terms", "payment terms"}},
+		{Key: "notes", Label: "Notes"},
+	},
+	models.ImportEntityItems: {
+		{Key: "name", Label: "Name", Required: true, Aliases: []string{"item", "item name", "product", "product name", "description", "service"}},
+		{Key: "unit_price", Label: "Unit Price", Required: true, Aliases: []string{"price", "rate", "amount", "selling price"}},
+		{Key: "unit", Label: "Unit", Aliases: []string{"uom", "unit of measure"}},
+		{Key: "taxable", Label: "Taxable", Aliases: []string{"vat", "vatable"}},
+		{Key: "notes", Label: "Notes"},
+	},
+	models.ImportEntityInvoices: {
+		{Key: "invoice_number", Label: "Invoice Number", Required: true, Aliases: []string{"invoice", "invoice no", "invoice #", "number", "no"}},
+		{Key: "client_name", Label: "Client Name", Required: true, Aliases: []string{"client", "customer", "customer name", "bill to"}},
+		{Key: "client_email", Label: "Client Email", Aliases: []string{"email", "customer email"}},
+		{Key: "issue_date", Label: "Issue Date", Required: true, Aliases: []string{"date", "invoice date"}},
+		{Key: "due_date", Label: "Due Date", Aliases: []string{"due"}},
+		{Key: "paid_date", Label: "Paid Date", Aliases: []string{"payment date", "date paid"}},
+		{Key: "currency", Label: "Currency"},
+		{Key: "exchange_rate", Label: "Exchange Rate (KES per unit)", Aliases: []string{"fx rate", "kes rate", "rate to kes"}},
+		{Key: "description", Label: "Description", Aliases: []string{"item", "details"}},
+		{Key: "subtotal", Label: "Subtotal", Aliases: []string{"net", "net amount", "amount before tax"}},
+		{Key: "tax", Label: "Tax", Aliases: []string{"vat", "tax amount", "vat amount"}},
+		{Key: "total", Label: "Total", Required: true, Aliases: []string{"amount", "gross", "total amount", "invoice total"}},
+		{Key: "amount_paid", Label: "Amount Paid", Aliases: []string{"paid", "paid amount"}},
+		{Key: "payment_method", Label: "Payment Method", Aliases: []string{"method"}},
+		{Key: "reference", Label: "Payment Reference", Aliases: []string{"receipt", "receipt number", "transaction id"}},
+	},
+}
+
+// ImportFields returns the mappable fields for an entity type
+func ImportFields(entityType string) []ImportField {
+	return importFields[entityType]
+}
+
+// ImportRowError is a validation problem (or skipped duplicate) on a spreadsheet row
+type ImportRowError struct {
+	Row     int    `json:"row"` // 1-based, counting the header row
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportPreview is returned after upload so the user can confirm the column mapping
+type ImportPreview struct {
+	Job     *models.ImportJob `json:"job"`
+	Headers []string          `json:"headers"`
+	Mapping map[string]string `json:"mapping"`
+	Sample  [][]string        `json:"sample"`
+	Fields  []ImportField     `json:"fields"`
+}
+
+// ImportResult summarizes a dry run or commit
+type ImportResult struct {
+	Job        *models.ImportJob `json:"job"`
+	DryRun     bool              `json:"dry_run"`
+	Errors     []ImportRowError  `json:"errors"`
+	Duplicates []ImportRowError  `json:"duplicates"`
+}
+
+// CommitImportRequest commits a validated import. Rows with errors are only
+// skipped when SkipInvalid is set; otherwise any error aborts the commit.
+type CommitImportRequest struct {
+	Mapping     map[string]string `json:"mapping"`
+	SkipInvalid bool              `json:"skip_invalid"`
+}
+
+// ImportService handles bulk imports
+type ImportService struct {
+	db      *database.DB
+	periods *PeriodService
+	rates   *ExchangeRateService
+}
+
+// NewImportService creates a new import service
+func NewImportService(db *database.DB) *ImportService {
+	return &ImportService{db: db}
+}
+
+// SetExchangeRateService looks up the rate on the issue date for foreign-currency
+// invoice rows that don't carry their own (optional)
+func (s *ImportService) SetExchangeRateService(rates *ExchangeRateService) {
+	s.rates = rates
+}
+
+// SetPeriodService rejects rows dated in closed accounting periods (optional)
+func (s *ImportService) SetPeriodService(periods *PeriodService) {
+	s.periods = periods
+}
+
+// Upload stores an uploaded file and suggests a column mapping
+func (s *ImportService) Upload(tenantID, userID, entityType, fileName string, data []byte) (*ImportPreview, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	if _, ok := importFields[entityType]; !ok {
+		return nil, fmt.Errorf("unsupported import type: %s", entityType)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	if len(data) > maxImportFileSize {
+		return nil, fmt.Errorf("file exceeds %d MB", maxImportFileSize>>20)
+	}
+
+	format := importFormat(fileName)
+	if format == "" {
+		return nil, errors.New("only .csv and .xlsx files can be imported")
+	}
+
+	headers, rows, err := parseImportFile(format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := suggestImportMapping(entityType, headers)
+	headersJSON, _ := json.Marshal(headers)
+	mappingJSON, _ := json.Marshal(mapping)
+
+	job := &models.ImportJob{
+		ID:         uuid.New().String(),
+		TenantID:   tenantID,
+		UserID:     userID,
+		EntityType: entityType,
+		FileName:   filepath.Base(fileName),
+		Format:     format,
+		FileData:   data,
+		Headers:    string(headersJSON),
+		Mapping:    string(mappingJSON),
+		Status:     models.ImportStatusUploaded,
+		TotalRows:  len(rows),
+	}
+	if err := s.db.Create(job).Error; err != nil {
+		return nil, fmt.Errorf("failed to save import: %w", err)
+	}
+
+	sample := rows
+	if len(sample) > importPreviewRows {
+		sample = sample[:importPreviewRows]
+	}
+	return &ImportPreview{
+		Job:     job,
+		Headers: headers,
+		Mapping: mapping,
+		Sample:  sample,
+		Fields:  importFields[entityType],
+	}, nil
+}
+
+// GetImport returns an import job
+func (s *ImportService) GetImport(tenantID, id string) (*models.ImportJob, error) {
+	var job models.ImportJob
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&job, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImportNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListImports returns the tenant's recent imports
+func (s *ImportService) ListImports(tenantID string, limit int) ([]models.ImportJob, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	var jobs []models.ImportJob
+	err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Omit("file_data").
+		Order("created_at DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+// Validate dry-runs the import with the given mapping and reports row-level errors.
+// Nothing is written apart from the job's counters.
+func (s *ImportService) Validate(tenantID, userID, id string, mapping map[string]string) (*ImportResult, error) {
+	job, err := s.GetImport(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == models.ImportStatusCommitted {
+		return nil, errors.New("import has already been committed")
+	}
+
+	plan, err := s.plan(job, userID, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = models.ImportStatusValidated
+	s.recordRun(job, plan, 0)
+	return &ImportResult{Job: job, DryRun: true, Errors: plan.errors, Duplicates: plan.duplicates}, nil
+}
+
+// Commit writes every valid, non-duplicate row in a single transaction
+func (s *ImportService) Commit(tenantID, userID, id string, req *CommitImportRequest) (*ImportResult, error) {
+	if req == nil {
+		req = &CommitImportRequest{}
+	}
+	job, err := s.GetImport(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == models.ImportStatusCommitted {
+		return nil, errors.New("import has already been committed")
+	}
+
+	plan, err := s.plan(job, userID, req.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.errors) > 0 && !req.SkipInvalid {
+		s.recordRun(job, plan, 0)
+		return &ImportResult{Job: job, Errors: plan.errors, Duplicates: plan.duplicates},
+			fmt.Errorf("%d rows have errors; fix them or commit with skip_invalid", len(plan.errors))
+	}
+
+	created := 0
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		created, err = plan.commit(tx)
+		return err
+	})
+	if err != nil {
+		job.Status = models.ImportStatusFailed
+		s.recordRun(job, plan, 0)
+		return nil, fmt.Errorf("import failed, nothing was saved: %w", err)
+	}
+
+	now := time.Now()
+	job.Status = models.ImportStatusCommitted
+	job.CommittedAt = &now
+	s.recordRun(job, plan, created)
+	return &ImportResult{Job: job, Errors: plan.errors, Duplicates: plan.duplicates}, nil
+}
+
+func (s *ImportService) recordRun(job *models.ImportJob, plan *importPlan, created int) {
+	errorsJSON, _ := json.Marshal(plan.errors)
+	mappingJSON, _ := json.Marshal(plan.mapping)
+
+	job.Mapping = string(mappingJSON)
+	job.Errors = string(errorsJSON)
+	job.TotalRows = plan.totalRows
+	job.ValidRows = plan.validRows
+	job.ErrorRows = plan.errorRows
+	job.SkippedCount = len(plan.duplicates)
+	job.CreatedCount = created
+
+	s.db.Model(job).Updates(map[string]interface{}{
+		"status":        job.Status,
+		"mapping":       job.Mapping,
+		"errors":        job.Errors,
+		"total_rows":    job.TotalRows,
+		"valid_rows":    job.ValidRows,
+		"error_rows":    job.ErrorRows,
+		"skipped_count": job.SkippedCount,
+		"created_count": job.CreatedCount,
+		"committed_at":  job.CommittedAt,
+		"updated_at":    time.Now(),
+	})
+}
+
+// ============================================================================
+// FILE PARSING AND MAPPING
+// ============================================================================
+
+func importFormat(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return "csv"
+	case ".xlsx":
+		return "xlsx"
+	}
+	return ""
+}
+
+// parseImportFile returns the header row and the non-empty data rows
+func parseImportFile(format string, data []byte) ([]string, [][]string, error) {
+	var records [][]string
+	switch format {
+	case "csv":
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+			}
+			records = append(records, record)
+			if len(records) > maxImportRows+1 {
+				return nil, nil, fmt.Errorf("imports are limited to %d rows", maxImportRows)
+			}
+		}
+	case "xlsx":
+		var err error
+		if records, err = xlsx.ReadRows(data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Skip any blank lines above the header
+	for len(records) > 0 && isBlankRow(records[0]) {
+		records = records[1:]
+	}
+	if len(records) < 2 {
+		return nil, nil, errors.New("file needs a header row and at least one data row")
+	}
+
+	headers := make([]string, len(records[0]))
+	for i, h := range records[0] {
+		headers[i] = strings.TrimSpace(h)
+	}
+
+	var rows [][]string
+	for _, record := range records[1:] {
+		rows = append(rows, record)
+	}
+	if len(rows) > maxImportRows {
+		return nil, nil, fmt.Errorf("imports are limited to %d rows", maxImportRows)
+	}
+	return headers, rows, nil
+}
+
+func isBlankRow(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeHeader(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	h = strings.NewReplacer("_", " ", "-", " ", ".", "", ":", "").Replace(h)
+	return strings.Join(strings.Fields(h), " ")
+}
+
+// suggestImportMapping matches headers to fields by key, label or alias
+func suggestImportMapping(entityType string, headers []string) map[string]string {
+	mapping := make(map[string]string)
+	used := make(map[string]bool)
+	for _, field := range importFields[entityType] {
+		candidates := append([]string{field.Key, field.Label}, field.Aliases...)
+		for _, candidate := range candidates {
+			want := normalizeHeader(candidate)
+			for _, h := range headers {
+				if !used[h] && normalizeHeader(h) == want {
+					mapping[field.Key] = h
+					used[h] = true
+					break
+				}
+			}
+			if _, ok := mapping[field.Key]; ok {
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// importRow gives mapped access to a spreadsheet row
+type importRow struct {
+	num    int
+	values map[string]string
+}
+
+func (r importRow) get(field string) string {
+	return strings.TrimSpace(r.values[field])
+}
+
+// ============================================================================
+// PLANNING - validation, dedupe and the records to create
+// ============================================================================
+
+type importPlan struct {
+	mapping    map[string]string
+	totalRows  int
+	validRows  int
+	errorRows  int
+	errors     []ImportRowError
+	duplicates []ImportRowError
+	commit     func(tx *gorm.DB) (int, error)
+}
+
+func (p *importPlan) fail(row int, field string, err error) {
+	msg := err.Error()
+	var vErr *utils.ValidationError
+	if errors.As(err, &vErr) {
+		msg = vErr.Message
+	}
+	p.errors = append(p.errors, ImportRowError{Row: row, Field: field, Message: msg})
+}
+
+func (p *importPlan) duplicate(row int, field, msg string) {
+	p.duplicates = append(p.duplicates, ImportRowError{Row: row, Field: field, Message: msg})
+}
+
+func (s *ImportService) plan(job *models.ImportJob, userID string, mapping map[string]string) (*importPlan, error) {
+	headers, rows, err := parseImportFile(job.Format, job.FileData)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mapping) == 0 && job.Mapping != "" {
+		json.Unmarshal([]byte(job.Mapping), &mapping)
+	}
+	columns := make(map[string]int, len(headers))
+	for i, h := range headers {
+		columns[h] = i
+	}
+	for _, field := range importFields[job.EntityType] {
+		header, ok := mapping[field.Key]
+		if field.Required && (!ok || header == "") {
+			return nil, fmt.Errorf("column for %q must be mapped", field.Label)
+		}
+		if ok && header != "" {
+			if _, exists := columns[header]; !exists {
+				return nil, fmt.Errorf("mapped column %q is not in the file", header)
+			}
+		}
+	}
+
+	plan := &importPlan{mapping: mapping}
+	var mapped []importRow
+	for i, row := range rows {
+		if isBlankRow(row) {
+			continue
+		}
+		values := make(map[string]string, len(mapping))
+		for field, header := range mapping {
+			if idx, ok := columns[header]; ok && idx < len(row) {
+				values[field] = row[idx]
+			}
+		}
+		mapped = append(mapped, importRow{num: i + 2, values: values})
+	}
+	plan.totalRows = len(mapped)
+
+	if userID == "" {
+		userID = job.UserID
+	}
+	switch job.EntityType {
+	case models.ImportEntityClients:
+		err = s.planClients(plan, job.TenantID, userID, mapped)
+	case models.ImportEntityItems:
+		err = s.planItems(plan, job.TenantID, userID, mapped)
+	case models.ImportEntityInvoices:
+		err = s.planInvoices(plan, job.TenantID, userID, mapped)
+	default:
+		err = fmt.Errorf("unsupported import type: %s", job.EntityType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make(map[int]bool)
+	for _, e := range plan.errors {
+		failed[e.Row] = true
+	}
+	plan.errorRows = len(failed)
+	plan.validRows = plan.totalRows - plan.errorRows
+	return plan, nil
+}
+
+// clientIndex finds existing clients by email, KRA PIN or name (all normalized)
+type clientIndex struct {
+	byEmail map[string]*models.Client
+	byPIN   map[string]*models.Client
+	byName  map[string]*models.Client
+}
+
+func (s *ImportService) loadClientIndex(tenantID string) (*clientIndex, error) {
+	// Contact fields are encrypted at rest, so dedupe happens on the decrypted values
+	var clients []models.Client
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+	idx := &clientIndex{
+		byEmail: make(map[string]*models.Client),
+		byPIN:   make(map[string]*models.Client),
+		byName:  make(map[string]*models.Client),
+	}
+	for i := range clients {
+		idx.add(&clients[i])
+	}
+	return idx, nil
+}
+
+func (idx *clientIndex) add(c *models.Client) {
+	if email := strings.ToLower(strings.TrimSpace(c.Email)); email != "" {
+		idx.byEmail[email] = c
+	}
+	if pin := utils.SanitizeKRAPIN(c.KRAPIN); pin != "" {
+		idx.byPIN[pin] = c
+	}
+	if name := strings.ToLower(strings.TrimSpace(c.Name)); name != "" {
+		idx.byName[name] = c
+	}
+}
+
+func (s *ImportService) planClients(plan *importPlan, tenantID, userID string, rows []importRow) error {
+	existing, err := s.loadClientIndex(tenantID)
+	if err != nil {
+		return err
+	}
+	seenEmail := make(map[string]int)
+	seenPIN := make(map[string]int)
+
+	var clients []*models.Client
+	for _, row := range rows {
+		before := len(plan.errors)
+
+		name := row.get("name")
+		if name == "" {
+			plan.fail(row.num, "name", errors.New("name is required"))
+		}
+		email := strings.ToLower(row.get("email"))
+		if email != "" {
+			if err := utils.ValidateEmail(email); err != nil {
+				plan.fail(row.num, "email", err)
+			}
+		}
+		phone := row.get("phone")
+		if err := utils.ValidatePhone(phone); err != nil {
+			plan.fail(row.num, "phone", err)
+		}
+		pin := utils.SanitizeKRAPIN(row.get("kra_pin"))
+		if err := utils.ValidateKRAPIN(pin); err != nil {
+			plan.fail(row.num, "kra_pin", err)
+		}
+		currency := strings.ToUpper(row.get("currency"))
+		if currency != "" && !utils.IsValidCurrency(currency) {
+			plan.fail(row.num, "currency", fmt.Errorf("unsupported currency: %s", currency))
+		}
+		terms := 0
+		if v := row.get("payment_terms"); v != "" {
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(strings.ToLower(v), "net ")), " days"))
+			if err != nil || n < 0 || n > 365 {
+				plan.fail(row.num, "payment_terms", errors.New("payment terms must be 0-365 days"))
+			}
+			terms = n
+		}
+		if len(plan.errors) > before {
+			continue
+		}
+
+		switch {
+		case email != "" && existing.byEmail[email] != nil:
+			plan.duplicate(row.num, "email", "a client with this email already exists")
+			continue
+		case pin != "" && existing.byPIN[pin] != nil:
+			plan.duplicate(row.num, "kra_pin", "a client with this KRA PIN already exists")
+			continue
+		case email != "" && seenEmail[email] > 0:
+			plan.duplicate(row.num, "email", fmt.Sprintf("same email as row %d", seenEmail[email]))
+			continue
+		case pin != "" && seenPIN[pin] > 0:
+			plan.duplicate(row.num, "kra_pin", fmt.Sprintf("same KRA PIN as row %d", seenPIN[pin]))
+			continue
+		}
+		if email != "" {
+			seenEmail[email] = row.num
+		}
+		if pin != "" {
+			seenPIN[pin] = row.num
+		}
+
+		clients = append(clients, &models.Client{
+			ID:           uuid.New().String(),
+			TenantID:     tenantID,
+			UserID:       userID,
+			Name:         name,
+			Email:        email,
+			Phone:        normalizePhone(phone),
+			Address:      row.get("address"),
+			KRAPIN:       pin,
+			Currency:     getValidCurrency(currency),
+			PaymentTerms: getValidPaymentTerms(terms),
+			Notes:        row.get("notes"),
+		})
+	}
+
+	plan.commit = func(tx *gorm.DB) (int, error) {
+		for _, c := range clients {
+			if err := tx.Create(c).Error; err != nil {
+				return 0, fmt.Errorf("failed to create client %q: %w", c.Name, err)
+			}
+		}
+		return len(clients), nil
+	}
+	return nil
+}
+
+func (s *ImportService) planItems(plan *importPlan, tenantID, userID string, rows []importRow) error {
+	var names []string
+	if err := s.db.Model(&models.ItemLibrary{}).Where("tenant_id = ?", tenantID).Pluck("name", &names).Error; err != nil {
+		return fmt.Errorf("failed to load items: %w", err)
+	}
+	existing := make(map[string]bool, len(names))
+	for _, n := range names {
+		existing[strings.ToLower(strings.TrimSpace(n))] = true
+	}
+	seen := make(map[string]int)
+
+	var items []*models.ItemLibrary
+	for _, row := range rows {
+		before := len(plan.errors)
+
+		name := row.get("name")
+		if name == "" {
+			plan.fail(row.num, "name", errors.New("name is required"))
+		}
+		price, err := parseImportAmount(row.get("unit_price"))
+		if err != nil {
+			plan.fail(row.num, "unit_price", err)
+		}
+		taxable := true
+		if v := row.get("taxable"); v != "" {
+			if taxable, err = parseImportBool(v); err != nil {
+				plan.fail(row.num, "taxable", err)
+			}
+		}
+		if len(plan.errors) > before {
+			continue
+		}
+
+		key := strings.ToLower(name)
+		if existing[key] {
+			plan.duplicate(row.num, "name", "an item with this name already exists")
+			continue
+		}
+		if seen[key] > 0 {
+			plan.duplicate(row.num, "name", fmt.Sprintf("same name as row %d", seen[key]))
+			continue
+		}
+		seen[key] = row.num
+
+		items = append(items, &models.ItemLibrary{
+			ID:        uuid.New().String(),
+			TenantID:  tenantID,
+			UserID:    userID,
+			Name:      name,
+			UnitPrice: models.ToCents(price),
+			Unit:      row.get("unit"),
+			Taxable:   taxable,
+			Notes:     row.get("notes"),
+		})
+	}
+
+	plan.commit = func(tx *gorm.DB) (int, error) {
+		for _, item := range items {
+			// Select every column so taxable=false is not replaced by the column default
+			if err := tx.Select("*").Create(item).Error; err != nil {
+				return 0, fmt.Errorf("failed to create item %q: %w", item.Name, err)
+			}
+		}
+		return len(items), nil
+	}
+	return nil
+}
+
+// importedInvoice is a historical invoice with its client, line and payment
+type importedInvoice struct {
+	invoice   *models.Invoice
+	item      *models.InvoiceItem
+	payment   *models.Payment
+	newClient *models.Client
+}
+
+func (s *ImportService) planInvoices(plan *importPlan, tenantID, userID string, rows []importRow) error {
+	clients, err := s.loadClientIndex(tenantID)
+	if err != nil {
+		return err
+	}
+
+	tenantCurrency := utils.DefaultCurrency
+	s.db.Model(&models.Tenant{}).Where("id = ?", tenantID).Select("currency").Scan(&tenantCurrency)
+	if tenantCurrency == "" {
+		tenantCurrency = utils.DefaultCurrency
+	}
+
+	var numbers []string
+	for _, row := range rows {
+		if n := utils.SanitizeInvoiceNumber(row.get("invoice_number")); n != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	taken := make(map[string]bool)
+	if len(numbers) > 0 {
+		var found []string
+		s.db.Model(&models.Invoice{}).Scopes(database.TenantFilter(tenantID)).
+			Where("invoice_number IN ?", numbers).Pluck("invoice_number", &found)
+		for _, n := range found {
+			taken[n] = true
+		}
+	}
+
+	seen := make(map[string]int)
+	var invoices []*importedInvoice
+	for _, row := range rows {
+		before := len(plan.errors)
+
+		number := utils.SanitizeInvoiceNumber(row.get("invoice_number"))
+		if number == "" {
+			plan.fail(row.num, "invoice_number", errors.New("invoice number is required"))
+		}
+		clientName := row.get("client_name")
+		if clientName == "" {
+			plan.fail(row.num, "client_name", errors.New("client name is required"))
+		}
+		clientEmail := strings.ToLower(row.get("client_email"))
+		if clientEmail != "" {
+			if err := utils.ValidateEmail(clientEmail); err != nil {
+				plan.fail(row.num, "client_email", err)
+			}
+		}
+
+		issued, err := parseImportDate(row.get("issue_date"))
+		if err != nil {
+			plan.fail(row.num, "issue_date", err)
+		}
+		due := issued
+		if v := row.get("due_date"); v != "" {
+			if due, err = parseImportDate(v); err != nil {
+				plan.fail(row.num, "due_date", err)
+			}
+		}
+		paidAt := issued
+		if v := row.get("paid_date"); v != "" {
+			if paidAt, err = parseImportDate(v); err != nil {
+				plan.fail(row.num, "paid_date", err)
+			}
+		}
+
+		currency := strings.ToUpper(row.get("currency"))
+		if currency == "" {
+			currency = tenantCurrency
+		} else if !utils.IsValidCurrency(currency) {
+			plan.fail(row.num, "currency", fmt.Errorf("unsupported currency: %s", currency))
+		}
+		// Foreign-currency history is booked at the rate of its issue date: the
+		// row's own rate, else the one published for that day. Today's rate would
+		// misstate every KES figure, so rows with neither are refused.
+		exchangeRate := 1.0
+		if currency != utils.DefaultCurrency {
+			if v := row.get("exchange_rate"); v != "" {
+				if exchangeRate, err = parseImportAmount(v); err != nil || exchangeRate <= 0 {
+					plan.fail(row.num, "exchange_rate", errors.New("exchange rate must be a number greater than zero"))
+				}
+			} else if s.rates == nil || issued.IsZero() {
+				plan.fail(row.num, "exchange_rate", fmt.Errorf("an exchange rate is required for %s invoices", currency))
+			} else if exchangeRate, err = s.rates.DatedRateOn(tenantID, currency, issued); errors.Is(err, ErrExchangeRateNotFound) {
+				plan.fail(row.num, "exchange_rate", fmt.Errorf("no %s rate published for %s; add an exchange rate column", currency, issued.Format("2006-01-02")))
+			} else if err != nil {
+				return err
+			}
+		}
+
+		total, err := parseImportAmount(row.get("total"))
+		if err != nil {
+			plan.fail(row.num, "total", err)
+		} else if total <= 0 {
+			plan.fail(row.num, "total", errors.New("total must be greater than zero"))
+		}
+		tax := 0.0
+		if v := row.get("tax"); v != "" {
+			if tax, err = parseImportAmount(v); err != nil {
+				plan.fail(row.num, "tax", err)
+			}
+		}
+		subtotal := total - tax
+		if v := row.get("subtotal"); v != "" {
+			if subtotal, err = parseImportAmount(v); err != nil {
+				plan.fail(row.num, "subtotal", err)
+			}
+		}
+		discount := math.Round((subtotal+tax-total)*100) / 100
+		if discount < 0 {
+			plan.fail(row.num, "total", errors.New("total is more than subtotal + tax"))
+		}
+		paid := total
+		if v := row.get("amount_paid"); v != "" {
+			if paid, err = parseImportAmount(v); err != nil {
+				plan.fail(row.num, "amount_paid", err)
+			} else if paid > total {
+				plan.fail(row.num, "amount_paid", errors.New("amount paid is more than the total"))
+			}
+		}
+		method := models.PaymentMethodBank
+		if v := strings.ToLower(row.get("payment_method")); v != "" {
+			if v = strings.ReplaceAll(v, "-", ""); getValidPaymentMethod(v) == v {
+				method = models.PaymentMethod(v)
+			}
+		}
+		// History can't be added to months that are closed
+		dated := map[string]time.Time{"issue_date": issued}
+		if paid > 0 && !paidAt.Equal(issued) {
+			dated["paid_date"] = paidAt
+		}
+		for _, field := range []string{"issue_date", "paid_date"} {
+			if date, ok := dated[field]; ok {
+				if err := s.periods.CheckOpen(tenantID, date); errors.Is(err, ErrPeriodClosed) {
+					plan.fail(row.num, field, err)
+				} else if err != nil {
+					return err
+				}
+			}
+		}
+		if len(plan.errors) > before {
+			continue
+		}
+
+		if taken[number] {
+			plan.duplicate(row.num, "invoice_number", "an invoice with this number already exists")
+			continue
+		}
+		if seen[number] > 0 {
+			plan.duplicate(row.num, "invoice_number", fmt.Sprintf("same invoice number as row %d", seen[number]))
+			continue
+		}
+		seen[number] = row.num
+
+		rec := &importedInvoice{}
+		client := clients.byEmail[clientEmail]
+		if client == nil {
+			client = clients.byName[strings.ToLower(clientName)]
+		}
+		if client == nil {
+			client = &models.Client{
+				ID:       uuid.New().String(),
+				TenantID: tenantID,
+				UserID:   userID,
+				Name:     clientName,
+				Email:    clientEmail,
+				Currency: currency,
+			}
+			rec.newClient = client
+			clients.add(client)
+		}
+
+		issuedAt := issued
+		taxRate := 0.0
+		if subtotal > 0 {
+			taxRate = math.Round(tax/subtotal*10000) / 100
+		}
+		status := models.InvoiceStatusPaid
+		switch {
+		case paid <= 0:
+			status = models.InvoiceStatusSent
+			if due.Before(time.Now()) {
+				status = models.InvoiceStatusOverdue
+			}
+		case paid < total:
+			status = models.InvoiceStatusPartiallyPaid
+		}
+
+		invoice := &models.Invoice{
+			ID:             uuid.New().String(),
+			TenantID:       tenantID,
+			UserID:         userID,
+			ClientID:       client.ID,
+			InvoiceNumber:  number,
+			Currency:       currency,
+			ExchangeRate:   exchangeRate,
+			ExchangeRateAt: issued,
+			Subtotal:       models.ToCents(subtotal),
+			Discount:       models.ToCents(discount),
+			TaxRate:        taxRate,
+			TotalTax:       models.ToCents(tax),
+			TaxableAmount:  models.ToCents(subtotal),
+			Total:          models.ToCents(total),
+			PaidAmount:     models.ToCents(paid),
+			BalanceDue:     models.ToCents(total - paid),
+			TaxType:        models.TaxTypeStandard,
+			Status:         status,
+			DueDate:        due,
+			SentAt:         &issuedAt,
+			Notes:          "Imported historical invoice",
+			MagicToken:     uuid.New().String(),
+			Version:        1,
+			CreatedAt:      issued,
+		}
+		invoice.KESEquivalent = invoice.Total.Mul(exchangeRate)
+		if paid > 0 {
+			paidOn := paidAt
+			invoice.PaidAt = &paidOn
+		}
+		rec.invoice = invoice
+
+		description := row.get("description")
+		if description == "" {
+			description = "Invoice " + number
+		}
+		rec.item = &models.InvoiceItem{
+			ID:          uuid.New().String(),
+			InvoiceID:   invoice.ID,
+			Description: description,
+			Quantity:    1,
+			UnitPrice:   models.ToCents(subtotal),
+			TaxType:     models.TaxTypeStandard,
+			TaxRate:     taxRate,
+			TaxAmount:   models.ToCents(tax),
+			DiscountAmt: models.ToCents(discount),
+			Subtotal:    models.ToCents(subtotal),
+			Total:       models.ToCents(total),
+		}
+
+		if paid > 0 {
+			paidOn := paidAt
+			rec.payment = &models.Payment{
+				ID:             uuid.New().String(),
+				TenantID:       tenantID,
+				UserID:         userID,
+				InvoiceID:      invoice.ID,
+				Amount:         models.ToCents(paid),
+				Currency:       currency,
+				Method:         method,
+				Status:         models.PaymentStatusCompleted,
+				Reference:      row.get("reference"),
+				IdempotencyKey: "import_" + tenantID + "_" + number,
+				CompletedAt:    &paidOn,
+				CreatedAt:      paidAt,
+				PaymentType:    "invoice",
+			}
+			if currency != utils.DefaultCurrency {
+				// Settled at the booked rate: the history carries no FX gain or loss
+				rec.payment.ExchangeRate = exchangeRate
+			}
+		}
+		invoices = append(invoices, rec)
+	}
+
+	plan.commit = func(tx *gorm.DB) (int, error) {
+		numbers := make([]string, 0, len(invoices))
+		for _, rec := range invoices {
+			if rec.newClient != nil {
+				if err := tx.Create(rec.newClient).Error; err != nil {
+					return 0, fmt.Errorf("failed to create client %q: %w", rec.newClient.Name, err)
+				}
+			}
+			if err := tx.Create(rec.invoice).Error; err != nil {
+				return 0, fmt.Errorf("failed to create invoice %s: %w", rec.invoice.InvoiceNumber, err)
+			}
+			if err := tx.Create(rec.item).Error; err != nil {
+				return 0, fmt.Errorf("failed to create invoice %s line: %w", rec.invoice.InvoiceNumber, err)
+			}
+			if rec.payment != nil {
+				if err := numberReceipt(tx, rec.payment); err != nil {
+					return 0, err
+				}
+				if err := tx.Create(rec.payment).Error; err != nil {
+					return 0, fmt.Errorf("failed to record payment for %s: %w", rec.invoice.InvoiceNumber, err)
+				}
+			}
+			numbers = append(numbers, rec.invoice.InvoiceNumber)
+		}
+
+		// Keep new invoice numbers clear of the imported ones
+		if len(numbers) > 0 {
+			if err := advanceSequencePast(tx, tenantID, models.SequenceDocumentInvoice, numbers); err != nil {
+				return 0, err
+			}
+		}
+		return len(invoices), nil
+	}
+	return nil
+}
+
+// ============================================================================
+// VALUE PARSING
+// ============================================================================
+
+func parseImportAmount(v string) (float64, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, errors.New("amount is required")
+	}
+	v = strings.NewReplacer(",", "", " ", "").Replace(v)
+	for _, code := range []string{"KES", "KSH", "KSHS", "USD", "$"} {
+		v = strings.TrimPrefix(strings.ToUpper(v), code)
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount: %s", v)
+	}
+	return utils.SanitizeAmount(n)
+}
+
+func parseImportBool(v string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes", "y", "taxable", "vatable":
+		return true, nil
+	case "0", "false", "no", "n", "exempt":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected yes or no, got %q", v)
+}
+
+// importDateLayouts are tried in order; day-first layouts come before month-first ones
+var importDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"02/01/2006",
+	"2/1/2006",
+	"02-01-2006",
+	"02.01.2006",
+	"2 Jan 2006",
+	"02-Jan-2006",
+	"2-Jan-06",
+	"Jan 2, 2006",
+}
+
+func parseImportDate(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, errors.New("date is required")
+	}
+	// Spreadsheet dates arrive as serial numbers
+	if serial, err := strconv.ParseFloat(v, 64); err == nil && serial > 0 && serial < 100000 {
+		return xlsx.DateFromSerial(serial), nil
+	}
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date: %s (use YYYY-MM-DD)", v)
+}
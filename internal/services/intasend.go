@@ -257,19 +257,21 @@ func (s *IntasendService) handlePaymentSuccess(checkoutID, tenantID string, even
 	now := time.Now()
 	payment.CompletedAt = &now
 
-	if err := s.db.Create(payment).Error; err != nil {
-		return fmt.Errorf("failed to record payment: %w", err)
-	}
-
-	if invoice.ID != "" {
-		invoice.PaidAmount += amount
-		if invoice.PaidAmount >= invoice.Total {
-			invoice.Status = models.InvoiceStatusPaid
-			invoice.PaidAt = &now
-		} else {
-			invoice.Status = models.InvoiceStatusPartiallyPaid
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := numberReceipt(tx, payment); err != nil {
+			return err
+		}
+		if err := tx.Create(payment).Error; err != nil {
+			return fmt.Errorf("failed to record payment: %w", err)
 		}
-		s.db.Save(&invoice)
+		if invoice.ID == "" {
+			return nil
+		}
+		_, _, err := creditInvoice(tx, invoice.TenantID, invoice.ID, amount, now)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
 	s.recordIdempotency(tenantID, checkoutID, "payment", payment.ID)
@@ -36,10 +36,13 @@ type InvoiceService struct {
 	cfg               *config.Config
 	notificationSvc   *NotificationService
 	pdfGenerator      *pdf.PDFGenerator
+	workflows         *WorkflowEngine
+	periods           *PeriodService
+	taxRates          *TaxRateService
 }
 
 func NewInvoiceService(db *database.DB) *InvoiceService {
-	return &InvoiceService{db: db}
+	return &InvoiceService{db: db, taxRates: NewTaxRateService(db)}
 }
 
 func (s *InvoiceService) getTenantCurrency(tenantID string) string {
@@ -66,6 +69,9 @@ type ServiceDependencies struct {
 	SMS           *SMSService
 	Config        *config.Config
 	PDFGen        *pdf.PDFGenerator
+	Workflows     *WorkflowEngine
+	Periods       *PeriodService
+	TaxRates      *TaxRateService
 }
 
 func NewInvoiceServiceWithDeps(db *database.DB, deps *ServiceDependencies) *InvoiceService {
@@ -77,6 +83,12 @@ func NewInvoiceServiceWithDeps(db *database.DB, deps *ServiceDependencies) *Invo
 		kraService:      deps.KRA,
 		cfg:             deps.Config,
 		pdfGenerator:    deps.PDFGen,
+		workflows:       deps.Workflows,
+		periods:         deps.Periods,
+		taxRates:        deps.TaxRates,
+	}
+	if svc.taxRates == nil {
+		svc.taxRates = NewTaxRateService(db)
 	}
 	if deps.WhatsApp != nil {
 		svc.whatsappService = deps.WhatsApp
@@ -84,6 +96,16 @@ func NewInvoiceServiceWithDeps(db *database.DB, deps *ServiceDependencies) *Invo
 	return svc
 }
 
+// invoiceDates are the dates an invoice is reported on: created (VAT returns)
+// and sent (the ledger)
+func invoiceDates(invoice *models.Invoice) []time.Time {
+	dates := []time.Time{invoice.CreatedAt}
+	if invoice.SentAt != nil {
+		dates = append(dates, *invoice.SentAt)
+	}
+	return dates
+}
+
 func (s *InvoiceService) GetDB() *gorm.DB {
 	return s.db.DB
 }
@@ -95,6 +117,7 @@ type CreateInvoiceRequest struct {
 	Title         string               `json:"title"`
 	Currency      string               `json:"currency"`
 	TaxRate       float64              `json:"tax_rate"`
+	TaxCode       string               `json:"tax_code"` // tax catalogue code; the rate in force on the invoice date is used
 	Discount      float64              `json:"discount"`
 	DueDate       time.Time            `json:"due_date"`
 	Notes         string               `json:"notes"`
@@ -114,6 +137,9 @@ type InvoiceItemRequest struct {
 	Quantity     float64 `json:"quantity" binding:"required,min=-999999"`
 	UnitPrice    float64 `json:"unit_price" binding:"required,min=0"`
 	TaxRate      float64 `json:"tax_rate,omitempty"`
+	TaxCode      string  `json:"tax_code,omitempty"`    // VAT catalogue code, overrides tax_rate
+	ExciseCode   string  `json:"excise_code,omitempty"` // excise or levy catalogue code
+	ItemCode     string  `json:"item_code,omitempty"`   // KRA item code of an item registered with eTIMS
 	DiscountRate float64 `json:"discount_rate,omitempty"`
 	Unit         string  `json:"unit"`
 }
@@ -125,6 +151,7 @@ type UpdateInvoiceRequest struct {
 	Currency   *string    `json:"currency"`
 	Title      *string    `json:"title"`
 	TaxRate    *float64   `json:"tax_rate"`
+	TaxCode    *string    `json:"tax_code"`
 	Discount   *float64   `json:"discount"`
 	Notes      *string    `json:"notes"`
 	Terms      *string    `json:"terms"`
@@ -1,98 +1,329 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
 	"invoicefast/internal/models"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-// CreateCreditNote creates a credit note from an original invoice
-func (s *InvoiceService) CreateCreditNote(tenantID, userID, originalInvoiceID string, kraPayloadItems []CreateCreditNoteItem) (*models.Invoice, error) {
+var (
+	ErrCreditNoteOriginal       = errors.New("only open invoices registered with KRA can be credited")
+	ErrCreditNoteAmount         = errors.New("credit note amount must be greater than zero")
+	ErrCreditNoteExceedsInvoice = errors.New("credit note is more than is left to credit on the invoice")
+	ErrInvoiceKRARegistered     = errors.New("invoice is registered with KRA and can only be reversed with a credit note")
+)
+
+// creditedTotals sums the credit notes already issued against an invoice
+type creditedTotals struct {
+	Subtotal   models.Money
+	Discount   models.Money
+	TotalTax   models.Money
+	ExciseDuty models.Money
+	Total      models.Money
+}
+
+// CreateCreditNote credits an invoice registered with KRA, in full when no
+// items are given or for the items given. The credit settles what is left to
+// pay on the invoice and any excess goes to the client's credit balance; an
+// invoice credited in full is cancelled. The credit note is then submitted to
+// eTIMS against the original ICN. A failed submission is kept on the credit
+// note and retried from the KRA queue.
+func (s *InvoiceService) CreateCreditNote(tenantID, userID, originalInvoiceID string, items []CreateCreditNoteItem, reason string) (*models.Invoice, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
 	original, err := s.GetInvoiceByID(tenantID, originalInvoiceID)
 	if err != nil {
-		return nil, fmt.Errorf("original invoice not found: %w", err)
+		return nil, err
+	}
+	if original.KRAICN == "" || original.InvoiceType == "credit_note" ||
+		original.Status == models.InvoiceStatusCancelled || original.Status == models.InvoiceStatusVoid {
+		return nil, ErrCreditNoteOriginal
 	}
 
-	var creditItems []models.InvoiceItem
-	var subtotal float64
-	for i, item := range kraPayloadItems {
-		lineTotal := item.Quantity * item.UnitPrice
-		subtotal += lineTotal
-		creditItems = append(creditItems, models.InvoiceItem{
-			ID:          uuid.New().String(),
-			Description: item.Description,
-			Quantity:    item.Quantity,
-			UnitPrice:   models.ToCents(item.UnitPrice),
-			Unit:        item.Unit,
-			Total:       models.ToCents(lineTotal),
-			SortOrder:   i,
+	now := time.Now()
+	var creditNote *models.Invoice
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// A credit note is booked on the day it is issued, so that day's period
+		// must be open; the credited invoice's own period can stay closed
+		if err := s.periods.CheckOpenTx(tx, tenantID, now); err != nil {
+			return err
+		}
+
+		// Hold the invoice until the credit note is applied so two credits, or a
+		// credit and a payment, can't both work from the same credited totals
+		locked, err := lockInvoice(tx, tenantID, original.ID)
+		if err != nil {
+			return err
+		}
+		locked.Items = original.Items
+		original = locked
+		if original.KRAICN == "" || original.Status == models.InvoiceStatusCancelled || original.Status == models.InvoiceStatusVoid {
+			return ErrCreditNoteOriginal
+		}
+
+		var credited creditedTotals
+		if err := tx.Model(&models.Invoice{}).Scopes(database.TenantFilter(tenantID)).
+			Select("COALESCE(SUM(subtotal), 0) AS subtotal, COALESCE(SUM(discount), 0) AS discount, "+
+				"COALESCE(SUM(total_tax), 0) AS total_tax, COALESCE(SUM(excise_duty), 0) AS excise_duty, COALESCE(SUM(-total), 0) AS total").
+			Where("original_invoice_id = ? AND invoice_type = ?", original.ID, "credit_note").
+			Scan(&credited).Error; err != nil {
+			return fmt.Errorf("failed to load earlier credit notes: %w", err)
+		}
+
+		creditNote, err = newCreditNote(original, userID, reason, credited, items)
+		if err != nil {
+			return err
+		}
+		gross := -creditNote.Total
+		if !gross.GreaterThan(0) {
+			return ErrCreditNoteAmount
+		}
+		if gross.GreaterThan(original.Total.Sub(credited.Total)) || creditNote.Subtotal.GreaterThan(original.Subtotal.Sub(credited.Subtotal)) {
+			return ErrCreditNoteExceedsInvoice
+		}
+
+		creditNote.InvoiceNumber, creditNote.SequenceNumber, err = nextDocumentNumber(tx, tenantID, models.SequenceDocumentCreditNote)
+		if err != nil {
+			return fmt.Errorf("failed to generate credit note number: %w", err)
+		}
+		lines := creditNote.Items
+		creditNote.Items = nil
+		if err := tx.Create(creditNote).Error; err != nil {
+			return fmt.Errorf("failed to create credit note: %w", err)
+		}
+		for i := range lines {
+			lines[i].InvoiceID = creditNote.ID
+		}
+		if err := tx.Create(&lines).Error; err != nil {
+			return fmt.Errorf("failed to create credit note items: %w", err)
+		}
+		creditNote.Items = lines
+
+		if err := applyCreditNote(tx, original, creditNote, userID, now); err != nil {
+			return err
+		}
+
+		tx.Create(&models.AuditLog{
+			ID:         uuid.New().String(),
+			TenantID:   tenantID,
+			UserID:     userID,
+			Action:     "credit_note_created",
+			EntityType: "invoice",
+			EntityID:   original.ID,
+			Details:    fmt.Sprintf(`{"invoice_number": "%s", "credit_note": "%s", "amount": %.2f}`, original.InvoiceNumber, creditNote.InvoiceNumber, gross.Float64()),
 		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	taxRate := original.TaxRate
-	taxAmount := subtotal * (taxRate / 100)
-	discount := original.Discount
-	if discount.LessThan(0) {
-		discount = 0
-	}
-	total := subtotal + taxAmount - discount.Float64()
+	s.submitNote(tenantID, creditNote)
+	return s.GetInvoiceByID(tenantID, creditNote.ID)
+}
 
-	// Validate buyer type inheritance - must match original for KRA compliance
+// newCreditNote builds the credit note and its lines. A full credit copies the
+// invoice's lines unless part of it was credited before, when a single line
+// reverses what is left. A partial credit takes the invoice's tax, excise and
+// discount in proportion to the lines credited.
+func newCreditNote(original *models.Invoice, userID, reason string, credited creditedTotals, items []CreateCreditNoteItem) (*models.Invoice, error) {
 	buyerType := original.BuyerClassification
 	if buyerType == "" {
 		buyerType = string(models.BuyerClassificationB2C)
 	}
-
-	// Validate original was submitted to KRA
-	if original.KRAICN == "" {
-		return nil, fmt.Errorf("cannot create credit note: original invoice not submitted to KRA")
-	}
-
-	creditNote := &models.Invoice{
-		ID:                uuid.New().String(),
-		TenantID:          tenantID,
-		UserID:            userID,
-		ClientID:          original.ClientID,
-		InvoiceNumber:     generateCreditNoteNumber(userID),
-		Reference:         "Credit for " + original.InvoiceNumber,
-		Currency:          original.Currency,
-		InvoiceType:       "credit_note",
-		OriginalInvoiceID: originalInvoiceID,
-		OriginalICN:       original.KRAICN,
+	note := &models.Invoice{
+		ID:                  uuid.New().String(),
+		TenantID:            original.TenantID,
+		UserID:              userID,
+		ClientID:            original.ClientID,
+		Reference:           "Credit for " + original.InvoiceNumber,
+		Title:               original.Title,
+		Currency:            original.Currency,
+		ExchangeRate:        original.ExchangeRate,
+		ExchangeRateAt:      original.ExchangeRateAt,
+		InvoiceType:         "credit_note",
+		OriginalInvoiceID:   original.ID,
+		OriginalICN:         original.KRAICN,
 		BuyerClassification: buyerType,
-		Subtotal:          models.ToCents(subtotal),
-		TaxRate:           taxRate,
-		TaxAmount:         models.ToCents(taxAmount),
-		Discount:          discount,
-		Total:             models.ToCents(-total),
-		Status:            models.InvoiceStatusCreditNote,
-		DueDate:           time.Now().AddDate(0, 0, 30),
-		Notes:             "Credit note for: " + original.InvoiceNumber,
+		TaxType:             original.TaxType,
+		TaxRate:             original.TaxRate,
+		TaxCode:             original.TaxCode,
+		Status:              models.InvoiceStatusCreditNote,
+		DueDate:             time.Now(),
+		Notes:               "Credit note for: " + original.InvoiceNumber,
+		Version:             1,
+	}
+	if reason = strings.TrimSpace(reason); reason != "" {
+		note.Notes += "\nReason: " + reason
 	}
 
-	err = s.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(creditNote).Error; err != nil {
-			return fmt.Errorf("failed to create credit note: %w", err)
+	switch {
+	case len(items) == 0 && credited.Total.IsZero():
+		note.Subtotal, note.Discount, note.TotalTax, note.ExciseDuty = original.Subtotal, original.Discount, original.TotalTax, original.ExciseDuty
+		note.ExemptAmount, note.ZeroRatedAmount = original.ExemptAmount, original.ZeroRatedAmount
+		for _, item := range original.Items {
+			item.ID = uuid.New().String()
+			item.InvoiceID = ""
+			item.CreatedAt = time.Time{}
+			note.Items = append(note.Items, item)
 		}
-		for i := range creditItems {
-			creditItems[i].InvoiceID = creditNote.ID
+	case len(items) == 0:
+		note.Subtotal = original.Subtotal.Sub(credited.Subtotal)
+		note.Discount = original.Discount.Sub(credited.Discount)
+		note.TotalTax = original.TotalTax.Sub(credited.TotalTax)
+		note.ExciseDuty = original.ExciseDuty.Sub(credited.ExciseDuty)
+		note.Items = []models.InvoiceItem{{
+			ID:          uuid.New().String(),
+			Description: "Balance of invoice " + original.InvoiceNumber,
+			Quantity:    1,
+			UnitPrice:   note.Subtotal,
+			Subtotal:    note.Subtotal,
+			TaxType:     original.TaxType,
+			TaxRate:     original.TaxRate,
+			TaxAmount:   note.TotalTax,
+			ExciseDuty:  note.ExciseDuty,
+			Total:       note.Subtotal.Add(note.ExciseDuty).Add(note.TotalTax),
+		}}
+	default:
+		for i, item := range items {
+			if item.Quantity <= 0 || item.UnitPrice < 0 {
+				return nil, ErrCreditNoteAmount
+			}
+			line := models.ToCents(item.Quantity * item.UnitPrice)
+			share := 0.0
+			if original.Subtotal.GreaterThan(0) {
+				share = line.Float64() / original.Subtotal.Float64()
+			}
+			tax, excise := original.TotalTax.Mul(share), original.ExciseDuty.Mul(share)
+			note.Subtotal = note.Subtotal.Add(line)
+			note.TotalTax = note.TotalTax.Add(tax)
+			note.ExciseDuty = note.ExciseDuty.Add(excise)
+			note.Discount = note.Discount.Add(original.Discount.Mul(share))
+			note.Items = append(note.Items, models.InvoiceItem{
+				ID:          uuid.New().String(),
+				Description: item.Description,
+				Quantity:    item.Quantity,
+				UnitPrice:   models.ToCents(item.UnitPrice),
+				Unit:        item.Unit,
+				Subtotal:    line,
+				TaxType:     original.TaxType,
+				TaxRate:     original.TaxRate,
+				TaxAmount:   tax,
+				ExciseDuty:  excise,
+				Total:       line.Add(excise).Add(tax),
+				SortOrder:   i,
+			})
 		}
-		if err := tx.Create(&creditItems).Error; err != nil {
-			return fmt.Errorf("failed to create credit note kraPayloadItems: %w", err)
+	}
+
+	note.TaxAmount = note.TotalTax
+	note.Total = -note.Subtotal.Add(note.ExciseDuty).Add(note.TotalTax).Sub(note.Discount)
+	note.KESEquivalent = -note.Total
+	if original.ExchangeRate > 0 {
+		note.KESEquivalent = note.KESEquivalent.Mul(original.ExchangeRate)
+	}
+	return note, nil
+}
+
+// applyCreditNote settles the credited invoice with the credit note. The credit
+// pays what was left to pay and the rest is owed back to the client.
+func applyCreditNote(tx *gorm.DB, original, creditNote *models.Invoice, userID string, now time.Time) error {
+	gross := -creditNote.Total
+	settled := original.Total.Sub(original.PaidAmount)
+	if gross.LessThan(settled) {
+		settled = gross
+	}
+	if settled.LessThan(0) {
+		settled = 0
+	}
+	paid := original.PaidAmount.Add(settled)
+	credited := original.CreditedAmount.Add(gross)
+
+	status := original.Status
+	updates := map[string]interface{}{
+		"paid_amount":     paid,
+		"balance_due":     original.Total.Sub(paid),
+		"credited_amount": credited,
+		"version":         original.Version + 1,
+	}
+	switch {
+	case !credited.LessThan(original.Total):
+		updates["kra_status"] = models.KRAInvoiceStatusCredited
+		if models.CanTransition(status, models.InvoiceStatusCancelled) {
+			status = models.InvoiceStatusCancelled
+			updates["cancelled_at"] = now
 		}
-		return nil
-	})
+	case settled.GreaterThan(0):
+		next := models.InvoiceStatusPartiallyPaid
+		if !paid.LessThan(original.Total) {
+			next = models.InvoiceStatusPaid
+			updates["paid_at"] = now
+		}
+		if models.CanTransition(status, next) {
+			status = next
+		}
+	}
+	updates["status"] = status
 
-	if err != nil {
-		return nil, err
+	res := tx.Model(&models.Invoice{}).Where("id = ? AND version = ?", original.ID, original.Version).Updates(updates)
+	if res.Error != nil {
+		return fmt.Errorf("failed to update invoice: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrAllocationConflict
+	}
+
+	excess := gross.Sub(settled)
+	if !excess.GreaterThan(0) {
+		return nil
+	}
+	if err := tx.Model(&models.Client{}).Where("id = ? AND tenant_id = ?", original.ClientID, original.TenantID).
+		UpdateColumn("credit_balance", gorm.Expr("credit_balance + ?", excess)).Error; err != nil {
+		return fmt.Errorf("failed to credit client: %w", err)
 	}
+	var client models.Client
+	if err := tx.Select("id", "credit_balance").First(&client, "id = ?", original.ClientID).Error; err != nil {
+		return fmt.Errorf("client not found: %w", err)
+	}
+	if err := tx.Create(&models.ClientCreditTransaction{
+		ID:           uuid.New().String(),
+		TenantID:     original.TenantID,
+		ClientID:     original.ClientID,
+		CreditNoteID: creditNote.ID,
+		Type:         models.ClientCreditCreditNote,
+		Amount:       excess,
+		BalanceAfter: client.CreditBalance,
+		CreatedBy:    userID,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to record client credit: %w", err)
+	}
+	return nil
+}
 
-	creditNote.Items = creditItems
-	return creditNote, nil
+// submitNote registers a credit or debit note with eTIMS. Without a KRA
+// service it stays unsubmitted for the next bulk submission.
+func (s *InvoiceService) submitNote(tenantID string, note *models.Invoice) {
+	if s.kraService == nil {
+		return
+	}
+	if _, err := s.SubmitInvoiceToKRA(tenantID, note.ID); err != nil {
+		s.db.Model(&models.Invoice{}).Where("id = ?", note.ID).Updates(map[string]interface{}{
+			"kra_status": models.KRAInvoiceStatusFailed,
+			"kra_error":  err.Error(),
+		})
+		logger.Get().Error(context.Background(), "KRA submission failed", "category", "kra",
+			"invoice_number", note.InvoiceNumber, "original_icn", note.OriginalICN, "error", err)
+	}
 }
 
 type CreateCreditNoteItem struct {
@@ -109,7 +340,7 @@ type CreateDebitNoteItem struct {
 	Unit        string  `json:"unit"`
 }
 
-// CreateDebitNote creates a debit note from an original invoice
+// CreateDebitNote creates a debit note from an original invoice and submits it to eTIMS
 // Debit notes are used when additional charges need to be billed (e.g., extra services)
 func (s *InvoiceService) CreateDebitNote(tenantID, userID, originalInvoiceID string, kraPayloadItems []CreateDebitNoteItem) (*models.Invoice, error) {
 	original, err := s.GetInvoiceByID(tenantID, originalInvoiceID)
@@ -153,28 +384,38 @@ func (s *InvoiceService) CreateDebitNote(tenantID, userID, originalInvoiceID str
 	}
 
 	debitNote := &models.Invoice{
-		ID:                uuid.New().String(),
-		TenantID:          tenantID,
-		UserID:            userID,
-		ClientID:          original.ClientID,
-		InvoiceNumber:     generateDebitNoteNumber(userID),
-		Reference:         "Debit for " + original.InvoiceNumber,
-		Currency:          original.Currency,
-		InvoiceType:       "debit_note",
-		OriginalInvoiceID: originalInvoiceID,
-		OriginalICN:       original.KRAICN,
+		ID:                  uuid.New().String(),
+		TenantID:            tenantID,
+		UserID:              userID,
+		ClientID:            original.ClientID,
+		Reference:           "Debit for " + original.InvoiceNumber,
+		Currency:            original.Currency,
+		InvoiceType:         "debit_note",
+		OriginalInvoiceID:   originalInvoiceID,
+		OriginalICN:         original.KRAICN,
 		BuyerClassification: buyerType,
-		Subtotal:          models.ToCents(subtotal),
-		TaxRate:           taxRate,
-		TaxAmount:         models.ToCents(taxAmount),
-		Discount:          discount,
-		Total:             models.ToCents(total),
-		Status:            models.InvoiceStatusSent,
-		DueDate:           time.Now().AddDate(0, 0, 30),
-		Notes:             "Debit note for: " + original.InvoiceNumber,
+		Subtotal:            models.ToCents(subtotal),
+		TaxRate:             taxRate,
+		TotalTax:            models.ToCents(taxAmount),
+		TaxAmount:           models.ToCents(taxAmount),
+		Discount:            discount,
+		Total:               models.ToCents(total),
+		BalanceDue:          models.ToCents(total),
+		Status:              models.InvoiceStatusSent,
+		DueDate:             time.Now().AddDate(0, 0, 30),
+		Notes:               "Debit note for: " + original.InvoiceNumber,
 	}
 
 	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Like a credit note, a debit note is booked on the day it is issued
+		if err := s.periods.CheckOpenTx(tx, tenantID, time.Now()); err != nil {
+			return err
+		}
+		var err error
+		debitNote.InvoiceNumber, debitNote.SequenceNumber, err = nextDocumentNumber(tx, tenantID, models.SequenceDocumentDebitNote)
+		if err != nil {
+			return fmt.Errorf("failed to generate debit note number: %w", err)
+		}
 		if err := tx.Create(debitNote).Error; err != nil {
 			return fmt.Errorf("failed to create debit note: %w", err)
 		}
@@ -192,5 +433,6 @@ func (s *InvoiceService) CreateDebitNote(tenantID, userID, originalInvoiceID str
 	}
 
 	debitNote.Items = debitItems
-	return debitNote, nil
+	s.submitNote(tenantID, debitNote)
+	return s.GetInvoiceByID(tenantID, debitNote.ID)
 }
@@ -41,9 +41,24 @@ func (s *InvoiceService) CreateInvoice(tenantID, userID, clientID string, req *C
 		return nil, ErrEmptyItems
 	}
 
+	// Rates come from the tax catalogue as at the invoice date
+	invoiceDate := time.Now()
+	var invoiceRate *models.TaxRate
+	if strings.TrimSpace(req.TaxCode) != "" {
+		rate, err := s.taxRates.RateOn(tenantID, req.TaxCode, invoiceDate)
+		if err != nil {
+			return nil, err
+		}
+		if rate.Kind != models.TaxKindVAT {
+			return nil, fmt.Errorf("%s is not a VAT rate: %w", rate.Code, ErrTaxRateKind)
+		}
+		invoiceRate = rate
+	}
+
 	// Calculate totals
 	var totalPreTax float64
 	var totalItemTax float64
+	var totalExcise float64
 	var invoiceTaxAmount float64
 	var kraPayloadItems []models.InvoiceItem
 	for i, item := range req.Items {
@@ -72,26 +87,34 @@ func (s *InvoiceService) CreateInvoice(tenantID, userID, clientID string, req *C
 			itemDiscountRate = 100
 		}
 
-		lineSubtotal := item.Quantity * item.UnitPrice
-		itemDiscountAmt := lineSubtotal * (itemDiscountRate / 100)
-		lineSubtotalAfterDiscount := lineSubtotal - itemDiscountAmt
-		itemTaxAmt := lineSubtotalAfterDiscount * (itemTaxRate / 100)
-		lineTotal := lineSubtotalAfterDiscount + itemTaxAmt
+		vat, excise, err := s.taxRates.LineRates(tenantID, item.TaxCode, item.ExciseCode, &models.TaxRate{TaxType: models.TaxTypeStandard, Rate: itemTaxRate}, invoiceRate, invoiceDate)
+		if err != nil {
+			return nil, err
+		}
+		lineSubtotal, exciseDuty, itemTaxAmt, lineTotal := models.CalculateLineItemTax(item.Quantity, item.UnitPrice, itemDiscountRate, 0, vat, excise)
+		itemDiscountAmt := lineSubtotal.Mul(itemDiscountRate / 100)
 
-		totalPreTax += lineSubtotal
-		totalItemTax += itemTaxAmt
+		totalPreTax += lineSubtotal.Float64()
+		totalItemTax += itemTaxAmt.Float64()
+		totalExcise += exciseDuty.Float64()
 		kraPayloadItems = append(kraPayloadItems, models.InvoiceItem{
 			ID:           uuid.New().String(),
 			Description:  strings.TrimSpace(item.Description),
 			Quantity:     item.Quantity,
 			UnitPrice:    models.ToCents(item.UnitPrice),
-			Subtotal:     models.ToCents(lineSubtotal),
+			Subtotal:     lineSubtotal,
 			Unit:         item.Unit,
-			TaxRate:      itemTaxRate,
-			TaxAmount:    models.ToCents(itemTaxAmt),
+			TaxType:      vat.TaxType,
+			TaxRate:      vat.Rate,
+			TaxAmount:    itemTaxAmt,
+			TaxCode:      vat.Code,
+			TaxCategory:  s.taxRates.LineCategory(tenantID, vat, invoiceDate),
+			ExciseCode:   strings.ToUpper(strings.TrimSpace(item.ExciseCode)),
+			ItemCode:     strings.TrimSpace(item.ItemCode),
+			ExciseDuty:   exciseDuty,
 			DiscountRate: itemDiscountRate,
-			DiscountAmt:  models.ToCents(itemDiscountAmt),
-			Total:        models.ToCents(lineTotal),
+			DiscountAmt:  itemDiscountAmt,
+			Total:        lineTotal,
 			SortOrder:    i,
 		})
 	}
@@ -105,15 +128,20 @@ func (s *InvoiceService) CreateInvoice(tenantID, userID, clientID string, req *C
 	}
 
 	taxRate := math.Max(0, math.Min(100, req.TaxRate))
+	taxType := models.TaxTypeStandard
+	taxCode := ""
 	discount := math.Max(0, req.Discount)
 	subtotal := totalPreTax
 
-	// Apply invoice-level tax rate to items without their own tax rate
-	if taxRate > 0 {
+	// Apply invoice-level tax rate to items without their own tax rate. A
+	// catalogue rate has already been applied to them line by line.
+	if invoiceRate != nil {
+		taxRate, taxType, taxCode = invoiceRate.Rate, invoiceRate.TaxType, invoiceRate.Code
+	} else if taxRate > 0 {
 		invoiceTaxAmount = (totalPreTax - totalItemTax) * (taxRate / 100)
 	}
 	totalTax := totalItemTax + invoiceTaxAmount
-	total := subtotal + totalTax - discount
+	total := subtotal + totalExcise + totalTax - discount
 
 	// Handle edge case: total cannot be negative
 	if total < 0 {
@@ -157,12 +185,14 @@ magicTokenExpires := time.Now().AddDate(0, 3, 0)
 		ExchangeRateAt:    time.Now(),
 		Subtotal:          models.ToCents(subtotal),
 		TaxRate:           taxRate,
+		TaxCode:           taxCode,
 		TotalTax:          models.ToCents(totalTax),
 		TaxAmount:         models.ToCents(totalTax),
+		ExciseDuty:        models.ToCents(totalExcise),
 		Discount:          models.ToCents(discount),
 		Total:             models.ToCents(total),
 		BalanceDue:        models.ToCents(total),
-		TaxType:          models.TaxTypeStandard,
+		TaxType:          taxType,
 		Status:           models.InvoiceStatusDraft,
 		DueDate:         req.DueDate,
 		Notes:           strings.TrimSpace(req.Notes),
@@ -184,12 +214,11 @@ magicTokenExpires := time.Now().AddDate(0, 3, 0)
 	// Use transaction for data integrity - including sequential numbering
 	err := s.db.Transaction(func(tx *gorm.DB) error {
 		// Get next sequence number in a transaction-safe manner
-		seq := models.InvoiceSequence{TenantID: tenantID, Prefix: "INV", Padding: 6}
-		seqNum, err := seq.GetNextSequence(tx)
+		number, seqNum, err := nextDocumentNumber(tx, tenantID, models.SequenceDocumentInvoice)
 		if err != nil {
 			return fmt.Errorf("failed to generate invoice number: %w", err)
 		}
-		invoice.InvoiceNumber = models.FormatInvoiceNumber("INV", seqNum, 6)
+		invoice.InvoiceNumber = number
 		invoice.SequenceNumber = seqNum
 
 		if err := tx.Create(invoice).Error; err != nil {
@@ -221,6 +250,15 @@ magicTokenExpires := time.Now().AddDate(0, 3, 0)
 
 	metrics.RecordInvoiceCreated()
 
+	s.workflows.Publish(&WorkflowEvent{
+		TenantID:   tenantID,
+		UserID:     userID,
+		Event:      models.TriggerEventInvoiceCreated,
+		EntityType: "invoice",
+		EntityID:   invoice.ID,
+		Data:       invoiceEventData(invoice, client),
+	})
+
 	return invoice, nil
 }
 
@@ -446,6 +484,9 @@ func (s *InvoiceService) UpdateInvoice(tenantID, invoiceID string, req *UpdateIn
 	if invoice.Status != models.InvoiceStatusDraft && req.Status == nil {
 		return nil, ErrCannotEditPaid
 	}
+	if err := s.periods.CheckOpen(tenantID, invoiceDates(invoice)...); err != nil {
+		return nil, err
+	}
 
 	// Update status if provided
 	if req.Status != nil {
@@ -481,6 +522,17 @@ func (s *InvoiceService) UpdateInvoice(tenantID, invoiceID string, req *UpdateIn
 	}
 	if req.TaxRate != nil {
 		invoice.TaxRate = math.Max(0, math.Min(100, *req.TaxRate))
+		invoice.TaxCode = ""
+	}
+	if req.TaxCode != nil && strings.TrimSpace(*req.TaxCode) != "" {
+		rate, err := s.taxRates.RateOn(tenantID, *req.TaxCode, invoice.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if rate.Kind != models.TaxKindVAT {
+			return nil, fmt.Errorf("%s is not a VAT rate: %w", rate.Code, ErrTaxRateKind)
+		}
+		invoice.TaxRate, invoice.TaxType, invoice.TaxCode = rate.Rate, rate.TaxType, rate.Code
 	}
 	if req.Discount != nil {
 		invoice.Discount = models.ToCents(math.Max(0, *req.Discount))
@@ -520,12 +572,23 @@ func (s *InvoiceService) UpdateInvoiceItems(tenantID, invoiceID string, kraPaylo
 	if invoice.Status != models.InvoiceStatusDraft {
 		return nil, ErrCannotEditPaid
 	}
+	if err := s.periods.CheckOpen(tenantID, invoiceDates(invoice)...); err != nil {
+		return nil, err
+	}
 
 	// Validate kraPayloadItems
 	if len(kraPayloadItems) == 0 {
 		return nil, ErrEmptyItems
 	}
 
+	// Lines are taxed at the catalogue rates in force on the invoice date
+	var invoiceRate *models.TaxRate
+	if invoice.TaxCode != "" {
+		if invoiceRate, err = s.taxRates.RateOn(tenantID, invoice.TaxCode, invoice.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
 	// Use transaction
 	err = s.db.Transaction(func(tx *gorm.DB) error {
 		// Delete existing kraPayloadItems
@@ -557,25 +620,33 @@ func (s *InvoiceService) UpdateInvoiceItems(tenantID, invoiceID string, kraPaylo
 			}
 
 			// Calculate line totals
-			lineSubtotal := item.Quantity * item.UnitPrice
-			itemDiscountAmt := lineSubtotal * (itemDiscountRate / 100)
-			lineSubtotalAfterDiscount := lineSubtotal - itemDiscountAmt
-			itemTaxAmt := lineSubtotalAfterDiscount * (itemTaxRate / 100)
-			lineTotal := lineSubtotalAfterDiscount + itemTaxAmt
+			vat, excise, err := s.taxRates.LineRates(tenantID, item.TaxCode, item.ExciseCode, &models.TaxRate{TaxType: models.TaxTypeStandard, Rate: itemTaxRate}, invoiceRate, invoice.CreatedAt)
+			if err != nil {
+				return err
+			}
+			lineSubtotal, exciseDuty, itemTaxAmt, lineTotal := models.CalculateLineItemTax(item.Quantity, item.UnitPrice, itemDiscountRate, 0, vat, excise)
+			itemDiscountAmt := lineSubtotal.Mul(itemDiscountRate / 100)
 
-			subtotal += lineTotal
+			subtotal += lineTotal.Float64()
 			newItems = append(newItems, models.InvoiceItem{
 				ID:           uuid.New().String(),
 				InvoiceID:    invoiceID,
 				Description:  strings.TrimSpace(item.Description),
 				Quantity:     item.Quantity,
 				UnitPrice:    models.ToCents(item.UnitPrice),
+				Subtotal:     lineSubtotal,
 				Unit:         item.Unit,
-				TaxRate:      itemTaxRate,
-				TaxAmount:    models.ToCents(itemTaxAmt),
+				TaxType:      vat.TaxType,
+				TaxRate:      vat.Rate,
+				TaxAmount:    itemTaxAmt,
+				TaxCode:      vat.Code,
+				TaxCategory:  s.taxRates.LineCategory(tenantID, vat, invoice.CreatedAt),
+				ExciseCode:   strings.ToUpper(strings.TrimSpace(item.ExciseCode)),
+				ItemCode:     strings.TrimSpace(item.ItemCode),
+				ExciseDuty:   exciseDuty,
 				DiscountRate: itemDiscountRate,
-				DiscountAmt:  models.ToCents(itemDiscountAmt),
-				Total:        models.ToCents(lineTotal),
+				DiscountAmt:  itemDiscountAmt,
+				Total:        lineTotal,
 				SortOrder:    i,
 			})
 		}
@@ -604,13 +675,15 @@ func (s *InvoiceService) UpdateInvoiceItems(tenantID, invoiceID string, kraPaylo
 
 // recalculateInvoiceTotals recalculates invoice totals from line items
 func (s *InvoiceService) recalculateInvoiceTotals(invoice *models.Invoice) {
-	var subtotal, totalTax models.Money
+	var subtotal, totalTax, excise models.Money
 	for _, item := range invoice.Items {
 		subtotal += item.Total
 		totalTax += item.TaxAmount
+		excise += item.ExciseDuty
 	}
 	invoice.Subtotal = subtotal
 	invoice.TotalTax = totalTax
+	invoice.ExciseDuty = excise
 	invoice.TaxAmount = invoice.TotalTax
 	invoice.Total = subtotal.Subtract(invoice.Discount)
 
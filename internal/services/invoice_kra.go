@@ -134,6 +134,7 @@ dbItems := make([]models.InvoiceItem, 0)
 		// Convert to KRAItem format for KRA payload
 		kraPayloadItems := make([]KRAItem, len(dbItems))
 		for i, item := range dbItems {
+			itemRate, category := s.kraService.itemTax(&invoice, &item)
 			kraPayloadItems[i] = KRAItem{
 				ItemCode:        item.ItemCode,
 				ItemDescription: item.Description,
@@ -142,11 +143,14 @@ dbItems := make([]models.InvoiceItem, 0)
 				UnitPrice:    item.UnitPrice.Float64(),
 				Discount:     item.DiscountAmt.Float64(),
 				DiscountRate: item.DiscountRate,
-				VATRate:      item.TaxRate,
+				ExciseDuty:   item.ExciseDuty.Float64(),
+				VATRate:      itemRate,
 				VATAmount:    item.TaxAmount.Float64(),
+				TaxCategory:  category,
 				Total:       item.Total.Float64(),
 			}
 		}
+		classifyKRAItems(tx, tenantID, kraPayloadItems)
 
 		// Determine buyer classification based on client
 		buyerClassification := determineBuyerClassification(&cli)
@@ -164,7 +168,17 @@ dbItems := make([]models.InvoiceItem, 0)
 
 		// Determine payment mode from payments (simplified)
 		paymentMode := "CASH"
-		
+
+		// Credit notes are reported with positive amounts against the original ICN
+		totalIncludingVAT := invoice.Total.Float64()
+		if invoice.InvoiceType == "credit_note" {
+			totalIncludingVAT = -totalIncludingVAT
+		}
+		invoiceType := invoice.InvoiceType
+		if invoiceType == "" {
+			invoiceType = "invoice"
+		}
+
 		kraData := &KRAInvoiceData{
 			InvoiceNumber: invoice.InvoiceNumber,
 			InvoiceDate:   invoice.CreatedAt.Format("2006-01-02"),
@@ -187,11 +201,13 @@ dbItems := make([]models.InvoiceItem, 0)
 			Items:              kraPayloadItems,
 			SubTotal:          invoice.Subtotal.Float64(),
 			TotalExcludingVAT: invoice.Subtotal.Subtract(invoice.Discount).Float64(),
-			VATRate:           invoice.TaxRate,
+			VATRate:           invoiceVATRate(&invoice, dbItems),
 			VATAmount:         invoice.TotalTax.Float64(),
-			TotalIncludingVAT: invoice.Total.Float64(),
+			TotalIncludingVAT: totalIncludingVAT,
 			Currency:          invoice.Currency,
 			PaymentMode:       paymentMode,
+			InvoiceType:       invoiceType,
+			OriginalICN:       invoice.OriginalICN,
 		}
 
 		kraResp, err := s.kraService.SubmitInvoice(kraData, invoice.TenantID, invoice.ID)
@@ -397,7 +413,11 @@ func (s *InvoiceService) validateInvoiceForKRA(invoice *models.Invoice) error {
 		return errors.New("invoice number is required")
 	}
 	
-	if invoice.Total <= 0 {
+	if invoice.InvoiceType == "credit_note" {
+		if invoice.Total >= 0 {
+			return errors.New("credit note total must be negative")
+		}
+	} else if invoice.Total <= 0 {
 		return errors.New("invoice total must be greater than zero")
 	}
 	
@@ -14,6 +14,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // SendInvoice marks invoice as sent, triggers notifications, and submits to KRA e-TIMS (tenant-scoped)
@@ -53,7 +54,6 @@ func (s *InvoiceService) SendInvoice(tenantID, invoiceID, userID string) (*model
 		createdAt := invoice.CreatedAt
 		subtotal := invoice.Subtotal
 		discount := invoice.Discount
-		taxRate := invoice.TaxRate
 		taxAmount := invoice.TaxAmount
 		total := invoice.Total
 		currency := invoice.Currency
@@ -80,6 +80,7 @@ func (s *InvoiceService) SendInvoice(tenantID, invoiceID, userID string) (*model
 			// Convert to KRAItem format
 			kraPayloadItems := make([]KRAItem, len(dbItems))
 			for i, item := range dbItems {
+				itemRate, category := s.kraService.itemTax(invoice, &item)
 				kraPayloadItems[i] = KRAItem{
 					ItemCode:        item.ItemCode,
 					ItemDescription: item.Description,
@@ -88,11 +89,14 @@ func (s *InvoiceService) SendInvoice(tenantID, invoiceID, userID string) (*model
 					UnitPrice:    item.UnitPrice.Float64(),
 					Discount:     item.DiscountAmt.Float64(),
 					DiscountRate: item.DiscountRate,
-					VATRate:      item.TaxRate,
+					ExciseDuty:   item.ExciseDuty.Float64(),
+					VATRate:      itemRate,
 					VATAmount:    item.TaxAmount.Float64(),
+					TaxCategory:  category,
 					Total:       item.Total.Float64(),
 				}
 			}
+			classifyKRAItems(s.db.WithContext(ctx), tenantID, kraPayloadItems)
 
 			kraData := &KRAInvoiceData{
 				InvoiceNumber: invoiceNum,
@@ -113,7 +117,7 @@ func (s *InvoiceService) SendInvoice(tenantID, invoiceID, userID string) (*model
 				Items:             kraPayloadItems,
 				SubTotal:          subtotal.Float64(),
 				TotalExcludingVAT: subtotal.Subtract(discount).Float64(),
-				VATRate:           taxRate,
+				VATRate:           invoiceVATRate(invoice, dbItems),
 				VATAmount:         taxAmount.Float64(),
 				TotalIncludingVAT: total.Float64(),
 				Currency:          currency,
@@ -170,6 +174,15 @@ func (s *InvoiceService) SendInvoice(tenantID, invoiceID, userID string) (*model
 	// Send email notification (async, don't fail if email fails)
 	go s.sendInvoiceNotifications(invoice, userID)
 
+	s.workflows.Publish(&WorkflowEvent{
+		TenantID:   tenantID,
+		UserID:     userID,
+		Event:      models.TriggerEventInvoiceSent,
+		EntityType: "invoice",
+		EntityID:   invoice.ID,
+		Data:       invoiceEventData(invoice, &client),
+	})
+
 	return invoice, nil
 }
 
@@ -260,9 +273,11 @@ func (s *InvoiceService) RecordPayment(tenantID, invoiceID string, payment *mode
 	}
 
 	// Use transaction for payment processing
+	var paidInvoice *models.Invoice
 	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the invoice so concurrent payments apply one after the other
 		var invoice models.Invoice
-		if err := tx.Scopes(database.TenantFilter(tenantID)).
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(database.TenantFilter(tenantID)).
 			Preload("Items").
 			First(&invoice, "id = ?", invoiceID).Error; err != nil {
 			return ErrInvoiceNotFound
@@ -286,27 +301,48 @@ func (s *InvoiceService) RecordPayment(tenantID, invoiceID string, payment *mode
 			return fmt.Errorf("cannot record payment on invoice with status %s", invoice.Status)
 		}
 
+		// Payments are booked on their completion date
+		paidAt := time.Now()
+		if payment.CompletedAt != nil {
+			paidAt = *payment.CompletedAt
+		}
+		if err := s.periods.CheckOpenTx(tx, tenantID, paidAt); err != nil {
+			return err
+		}
+
 		// Validate payment amount
 		if payment.Amount <= 0 {
 			return errors.New("payment amount must be positive")
 		}
+		if payment.ID == "" {
+			payment.ID = uuid.New().String()
+		}
 
-		// Calculate new balance using exact Money arithmetic
-		newPaidAmount := invoice.PaidAmount.Add(payment.Amount)
-		total := invoice.Total
+		// Tax the client withheld settles the invoice along with the cash
+		withheld, err := prepareWithholdings(tx, &invoice, payment, paidAt)
+		if err != nil {
+			return err
+		}
+		payment.Withheld = withheld
 
-		// Handle overpayment gracefully
-		if newPaidAmount.GreaterThan(total) {
-			overpayment := newPaidAmount.Subtract(total)
-			newPaidAmount = total
-			payment.Amount = overpayment
+		// Apply up to the balance due; whatever is left becomes client credit
+		settled := payment.Amount.Add(withheld)
+		applied := settled
+		if due := invoice.Total.Sub(invoice.PaidAmount); due.LessThan(applied) {
+			applied = due
 		}
+		overpayment := settled.Sub(applied)
 
 		// Set payment details
+		payment.TenantID = tenantID
 		payment.InvoiceID = invoiceID
 		payment.Status = models.PaymentStatusCompleted
-		if payment.ID == "" {
-			payment.ID = uuid.New().String()
+		// Foreign-currency payments keep the day's rate; the ledger books the
+		// difference from the invoice's rate as a realized gain or loss
+		if s.exchangeService != nil && payment.ExchangeRate == 0 && invoice.Currency != "KES" && payment.Currency == invoice.Currency {
+			if rate, err := s.exchangeService.RateOn(tenantID, payment.Currency, paidAt); err == nil {
+				payment.ExchangeRate = rate
+			}
 		}
 
 		// Check for duplicate idempotency key at DB level
@@ -318,37 +354,32 @@ func (s *InvoiceService) RecordPayment(tenantID, invoiceID string, payment *mode
 			}
 		}
 
-		// Save payment
+		// Save payment with its receipt number
+		if err := numberReceipt(tx, payment); err != nil {
+			return err
+		}
 		if err := tx.Create(payment).Error; err != nil {
 			return fmt.Errorf("failed to record payment: %w", err)
 		}
-
-		// Update invoice
-		invoice.PaidAmount = newPaidAmount
-		balanceDue := total.Subtract(newPaidAmount)
-		invoice.BalanceDue = balanceDue
-
-		// Determine new status based on paid amount
-		newStatus := invoice.Status
-		if newPaidAmount.Equals(total) || invoice.BalanceDue <= 0 {
-			// Full payment
-			newStatus = models.InvoiceStatusPaid
-			now := time.Now()
-			invoice.PaidAt = &now
-		} else if newPaidAmount.GreaterThan(0) {
-			// Partial payment
-			newStatus = models.InvoiceStatusPartiallyPaid
+		if len(payment.Withholdings) > 0 {
+			if err := tx.Create(&payment.Withholdings).Error; err != nil {
+				return fmt.Errorf("failed to record withholding: %w", err)
+			}
 		}
 
-		// Validate state transition
-		if err := models.ValidateTransition(invoice.Status, newStatus); err != nil {
+		// Update invoice through the same versioned write as allocations
+		if err := applyAllocation(tx, &invoice, applied, time.Now()); err != nil {
 			return err
 		}
-		invoice.Status = newStatus
-		invoice.Version++
-
-		if err := tx.Save(&invoice).Error; err != nil {
-			return fmt.Errorf("failed to update invoice: %w", err)
+		newStatus := invoice.Status
+		if overpayment > 0 {
+			if err := addClientCredit(tx, tenantID, invoice.ClientID, payment.ID, overpayment, payment.UserID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.Client{}).Where("id = ? AND tenant_id = ?", invoice.ClientID, tenantID).
+			Update("total_paid", gorm.Expr("total_paid + ?", payment.Amount)).Error; err != nil {
+			return fmt.Errorf("failed to update client totals: %w", err)
 		}
 
 		// Log the action
@@ -365,16 +396,43 @@ func (s *InvoiceService) RecordPayment(tenantID, invoiceID string, payment *mode
 		// Send payment notification
 		go s.sendPaymentNotification(tenantID, &invoice, payment)
 
+		paidInvoice = &invoice
 		return nil
 	})
 	if err != nil {
 		return err
 	}
 
+	if paidInvoice != nil {
+		s.publishPaymentEvents(tenantID, payment, paidInvoice)
+	}
+
 	metrics.RecordInvoicePaid()
 	return nil
 }
 
+// publishPaymentEvents emits payment_received, and invoice_paid once the invoice is settled
+func (s *InvoiceService) publishPaymentEvents(tenantID string, payment *models.Payment, invoice *models.Invoice) {
+	s.workflows.Publish(&WorkflowEvent{
+		TenantID:   tenantID,
+		UserID:     payment.UserID,
+		Event:      models.TriggerEventPaymentReceived,
+		EntityType: "payment",
+		EntityID:   payment.ID,
+		Data:       paymentEventData(payment, invoice),
+	})
+	if invoice.Status == models.InvoiceStatusPaid {
+		s.workflows.Publish(&WorkflowEvent{
+			TenantID:   tenantID,
+			UserID:     payment.UserID,
+			Event:      models.TriggerEventInvoicePaid,
+			EntityType: "invoice",
+			EntityID:   invoice.ID,
+			Data:       invoiceEventData(invoice, nil),
+		})
+	}
+}
+
 // sendPaymentNotification sends payment received notifications
 func (s *InvoiceService) sendPaymentNotification(tenantID string, invoice *models.Invoice, payment *models.Payment) {
 	if s.notificationSvc == nil {
@@ -404,18 +462,32 @@ func (s *InvoiceService) sendPaymentNotification(tenantID string, invoice *model
 	})
 }
 
-// CancelInvoice cancels an invoice with proper state machine validation
+// CancelInvoice cancels an invoice with proper state machine validation.
+// An invoice registered with KRA is cancelled by crediting what is left of it.
 func (s *InvoiceService) CancelInvoice(tenantID, invoiceID, userID string) error {
 	if tenantID == "" {
 		return ErrTenantRequired
 	}
 
+	var registered models.Invoice
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&registered, "id = ?", invoiceID).Error; err != nil {
+		return ErrInvoiceNotFound
+	}
+	if registered.KRAICN != "" {
+		if err := models.ValidateTransition(registered.Status, models.InvoiceStatusCancelled); err != nil {
+			return err
+		}
+		_, err := s.CreateCreditNote(tenantID, userID, invoiceID, nil, "Invoice cancelled")
+		return err
+	}
+
 	// Use transaction for cancellation
 	return s.db.Transaction(func(tx *gorm.DB) error {
-		var invoice models.Invoice
-		if err := tx.Scopes(database.TenantFilter(tenantID)).First(&invoice, "id = ?", invoiceID).Error; err != nil {
-			return ErrInvoiceNotFound
+		locked, err := lockInvoice(tx, tenantID, invoiceID)
+		if err != nil {
+			return err
 		}
+		invoice := *locked
 
 		// Validate state transition using state machine
 		newStatus := models.InvoiceStatusCancelled
@@ -424,8 +496,11 @@ func (s *InvoiceService) CancelInvoice(tenantID, invoiceID, userID string) error
 		}
 
 		// Additional checks: cannot cancel if KRA accepted
-		if invoice.KRAStatus == models.KRAInvoiceStatusAccepted {
-			return errors.New("cannot cancel invoice: KRA accepted")
+		if invoice.KRAStatus == models.KRAInvoiceStatusAccepted || invoice.KRAStatus == models.KRAInvoiceStatusSubmitted {
+			return ErrInvoiceKRARegistered
+		}
+		if err := s.periods.CheckOpenTx(tx, tenantID, invoiceDates(&invoice)...); err != nil {
+			return err
 		}
 
 		now := time.Now()
@@ -437,6 +512,20 @@ func (s *InvoiceService) CancelInvoice(tenantID, invoiceID, userID string) error
 			return fmt.Errorf("failed to cancel invoice: %w", err)
 		}
 
+		// A submission still waiting in the KRA queue must not register the
+		// invoice after it has been cancelled
+		if err := tx.Model(&models.KRAQueueItem{}).
+			Where("tenant_id = ? AND invoice_id = ? AND status IN ?", tenantID, invoiceID,
+				[]models.KRAQueueStatus{models.KRAQueuePending, models.KRAQueueFailed}).
+			Updates(map[string]interface{}{
+				"status":        models.KRAQueueCancelled,
+				"next_retry_at": nil,
+				"last_error":    "invoice cancelled",
+				"updated_at":    now,
+			}).Error; err != nil {
+			return fmt.Errorf("failed to void queued KRA submission: %w", err)
+		}
+
 		// Log cancellation
 		tx.Create(&models.AuditLog{
 			ID:         uuid.New().String(),
@@ -462,6 +551,16 @@ func (s *InvoiceService) DeleteInvoice(tenantID, invoiceID string) error {
 	if err != nil {
 		return err
 	}
+	if invoice.KRAICN != "" {
+		return ErrInvoiceKRARegistered
+	}
+
+	// The invoice and every payment against it must be in open periods
+	var paidDates []time.Time
+	s.db.Model(&models.Payment{}).Where("invoice_id = ? AND completed_at IS NOT NULL", invoiceID).Pluck("completed_at", &paidDates)
+	if err := s.periods.CheckOpen(tenantID, append(invoiceDates(invoice), paidDates...)...); err != nil {
+		return err
+	}
 
 	// Delete related records first
 	s.db.Where("invoice_id = ?", invoiceID).Delete(&models.Payment{})
@@ -1,10 +1,7 @@
 package services
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
-	"time"
 
 	"invoicefast/internal/models"
 	"invoicefast/internal/pdf"
@@ -88,24 +85,3 @@ func (s *InvoiceService) invoiceToPDFData(invoice *models.Invoice) *pdf.InvoiceD
 
 	return data
 }
-
-func generateInvoiceNumber(userID string) string {
-	timestamp := time.Now().UTC().Format("20060102")
-	randBytes := make([]byte, 2)
-	rand.Read(randBytes)
-	return fmt.Sprintf("INV-%s-%s", timestamp, hex.EncodeToString(randBytes))
-}
-
-func generateCreditNoteNumber(userID string) string {
-	timestamp := time.Now().UTC().Format("20060102")
-	randBytes := make([]byte, 2)
-	rand.Read(randBytes)
-	return fmt.Sprintf("CN-%s-%s", timestamp, hex.EncodeToString(randBytes))
-}
-
-func generateDebitNoteNumber(userID string) string {
-	timestamp := time.Now().UTC().Format("20060102")
-	randBytes := make([]byte, 2)
-	rand.Read(randBytes)
-	return fmt.Sprintf("DN-%s-%s", timestamp, hex.EncodeToString(randBytes))
-}
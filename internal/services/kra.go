@@ -23,11 +23,17 @@ import (
 	"invoicefast/internal/database"
 	"invoicefast/internal/logger"
 	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // ErrMockMode is returned when KRA is running in sandbox/mock mode
 var ErrMockMode = errors.New("KRA e-TIMS running in mock/sandbox mode - production API not configured")
 
+// ErrKRACancelUnsupported is returned for cancellations, which eTIMS doesn't have
+var ErrKRACancelUnsupported = errors.New("eTIMS invoices can't be cancelled, issue a credit note instead")
+
 // ErrKRAIntegrationRequired is returned when KRA integration is not fully configured
 type ErrKRAIntegrationRequired struct {
 	MissingFields []string
@@ -75,8 +81,12 @@ func (s *KRAService) GetMissingKRAConfig() []string {
 
 // KRAService handles KRA e-TIMS integration
 type KRAService struct {
-	cfg *config.Config
-	db  *database.DB
+	cfg             *config.Config
+	db              *database.DB
+	notificationSvc *NotificationService
+	workflows       *WorkflowEngine
+	taxRates        *TaxRateService
+	jobQueue        *JobQueueService
 }
 
 // KRAInvoiceData for e-TIMS submission
@@ -133,6 +143,7 @@ type KRAItem struct {
 	ExciseDuty            float64 `json:"exciseDuty"`
 	VATRate              float64 `json:"vatRate"`
 	VATAmount            float64 `json:"vatAmount"`
+	TaxCategory          string  `json:"taxCategory"` // eTIMS tax type, A-E
 	ItemClassificationCode string  `json:"itemClassificationCode"`
 }
 
@@ -161,17 +172,60 @@ func NewKRAService(cfg *config.Config) *KRAService {
 
 // NewKRAServiceWithDB creates a new KRA service with database for queue management
 func NewKRAServiceWithDB(cfg *config.Config, db *database.DB) *KRAService {
-	return &KRAService{cfg: cfg, db: db}
+	return &KRAService{cfg: cfg, db: db, taxRates: NewTaxRateService(db)}
+}
+
+// SetNotificationService enables tenant alerts for submissions that exhaust their retries
+func (s *KRAService) SetNotificationService(notification *NotificationService) {
+	s.notificationSvc = notification
+}
+
+// SetJobQueue hands queued submissions to the job dispatcher, which replays
+// each one when its retry falls due
+func (s *KRAService) SetJobQueue(queue *JobQueueService) {
+	s.jobQueue = queue
+}
+
+// SetWorkflowEngine wires the workflow event bus (optional)
+func (s *KRAService) SetWorkflowEngine(engine *WorkflowEngine) {
+	s.workflows = engine
+}
+
+// publishKRAEvent emits kra_accepted or kra_rejected for an invoice
+func (s *KRAService) publishKRAEvent(event, tenantID, invoiceID, invoiceNumber string, resp *KRAResponse, reason string) {
+	data := map[string]interface{}{
+		"invoice_id":     invoiceID,
+		"invoice_number": invoiceNumber,
+	}
+	if resp != nil {
+		data["icn"] = resp.ICN
+		data["qr_code"] = resp.QRCode
+	}
+	if reason != "" {
+		data["reason"] = reason
+	}
+	s.workflows.Publish(&WorkflowEvent{
+		TenantID:   tenantID,
+		Event:      event,
+		EntityType: "invoice",
+		EntityID:   invoiceID,
+		Data:       data,
+	})
 }
 
 func (s *KRAService) isDevMode() bool {
 	return s.cfg.Server.Mode != "production"
 }
 
+// hasLiveAPI reports whether a real e-TIMS endpoint is configured
+func (s *KRAService) hasLiveAPI() bool {
+	return s.cfg.KRA.APIURL != "" && s.cfg.KRA.APIURL != "https://api.kra.go.ke"
+}
+
 // SubmitInvoice submits an invoice to KRA e-TIMS via OSCP (Online Sales Control Protocol)
 func (s *KRAService) SubmitInvoice(data *KRAInvoiceData, tenantID, invoiceID string) (*KRAResponse, error) {
 	// If no API URL configured, return error so frontend can show sandbox warning
-	if s.cfg.KRA.APIURL == "" || s.cfg.KRA.APIURL == "https://api.kra.go.ke" {
+	if !s.hasLiveAPI() {
 		if s.isDevMode() {
 			logger.Get().Info(context.Background(), "Sandbox mode - production API not configured")
 		}
@@ -229,6 +283,7 @@ func (s *KRAService) SubmitInvoice(data *KRAInvoiceData, tenantID, invoiceID str
 	}
 
 	// Log successful KRA submission (audit logging will be handled by caller)
+	s.publishKRAEvent(models.TriggerEventKRAAccepted, tenantID, invoiceID, data.InvoiceNumber, response, "")
 	return response, nil
 }
 
@@ -238,6 +293,8 @@ func (s *KRAService) buildETIMSPayload(data *KRAInvoiceData) ([]byte, error) {
 		"invoiceNumber": data.InvoiceNumber,
 		"invoiceDate":   data.InvoiceDate,
 		"invoiceTime":   data.InvoiceTime,
+		"invoiceType":   data.InvoiceType,
+		"originalICN":   data.OriginalICN,
 		"seller": map[string]string{
 			"registrationNumber": data.Seller.RegistrationNumber,
 			"businessName":       data.Seller.BusinessName,
@@ -418,15 +475,11 @@ func (s *KRAService) SubmitInvoiceBatch(invoices []KRAInvoiceData) ([]KRARespons
 	return responses, nil
 }
 
-// CancelInvoice cancels an invoice in KRA
+// CancelInvoice is refused: eTIMS has no cancellation. An invoice registered
+// with it is reversed by submitting a credit note, which
+// InvoiceService.CancelInvoice and CreateCreditNote do.
 func (s *KRAService) CancelInvoice(invoiceNumber, reason string) (*KRAResponse, error) {
-	// Submit cancellation request to KRA
-	return &KRAResponse{
-		ResultCode:    "0",
-		ResultDesc:    "CANCELLED",
-		InvoiceNumber: invoiceNumber,
-		Timestamp:     time.Now().Format(time.RFC3339),
-	}, nil
+	return nil, ErrKRACancelUnsupported
 }
 
 // GetInvoiceStatus checks invoice status in KRA
@@ -552,26 +605,31 @@ func (s *KRAService) mockSubmit(data *KRAInvoiceData) (*KRAResponse, error) {
 func (s *KRAService) ConvertInvoiceToKRA(invoice *models.Invoice, usr *models.User, cli *models.Client) *KRAInvoiceData {
 	items := make([]KRAItem, len(invoice.Items))
 	
-	// Get tax rate from invoice
-	taxRate := invoice.TaxRate
-	if taxRate == 0 {
-		taxRate = 16 // Default to 16% VAT
-	}
+	// Rates and categories come from the tax catalogue via the lines
+	taxRate := invoiceVATRate(invoice, invoice.Items)
 	
 	for i, item := range invoice.Items {
+		itemRate, category := s.itemTax(invoice, &item)
 		items[i] = KRAItem{
-			ItemCode:               fmt.Sprintf("ITEM%03d", i+1),
+			ItemCode:               item.ItemCode,
 			ItemDescription:        item.Description,
 			Quantity:               item.Quantity,
 			UnitOfMeasure:          item.Unit,
 			UnitPrice:              item.UnitPrice.Float64(),
 			Total:                  item.Total.Float64(),
 			Discount:               item.DiscountAmt.Float64(),
-			ExciseDuty:             0,
-			VATRate:                taxRate,
+			ExciseDuty:             item.ExciseDuty.Float64(),
+			VATRate:                itemRate,
 			VATAmount:              item.TaxAmount.Float64(),
+			TaxCategory:            category,
 			ItemClassificationCode: "001",
 		}
+		if item.ItemCode == "" {
+			items[i].ItemCode = fmt.Sprintf("ITEM%03d", i+1)
+		}
+	}
+	if s.db != nil {
+		classifyKRAItems(s.db.DB, invoice.TenantID, items)
 	}
 
 	// Determine buyer classification from invoice (prefer invoice-level, fallback to client)
@@ -628,6 +686,63 @@ func (s *KRAService) ConvertInvoiceToKRA(invoice *models.Invoice, usr *models.Us
 	}
 }
 
+// itemTax returns the VAT rate and eTIMS tax category to report for a line.
+// Lines without a rate of their own were taxed at the invoice's rate.
+func (s *KRAService) itemTax(invoice *models.Invoice, item *models.InvoiceItem) (float64, string) {
+	rate, taxType := item.TaxRate, item.TaxType
+	if rate == 0 && item.TaxCode == "" && invoice.TaxRate > 0 {
+		rate, taxType = invoice.TaxRate, invoice.TaxType
+	}
+	if item.TaxCategory != "" && rate == item.TaxRate {
+		return rate, item.TaxCategory
+	}
+	if s.taxRates != nil {
+		return rate, s.taxRates.CategoryFor(invoice.TenantID, taxType, rate, invoice.CreatedAt)
+	}
+	return rate, models.KRATaxCategory(taxType, rate)
+}
+
+// classifyKRAItems fills in the KRA classification code of lines that carry
+// the item code of an item registered with eTIMS
+func classifyKRAItems(db *gorm.DB, tenantID string, items []KRAItem) {
+	codes := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.ItemCode != "" {
+			codes = append(codes, item.ItemCode)
+		}
+	}
+	if len(codes) == 0 {
+		return
+	}
+	var registered []models.ItemLibrary
+	if err := db.Scopes(database.TenantFilter(tenantID)).
+		Where("item_code IN ? AND item_class_code <> ''", codes).Find(&registered).Error; err != nil {
+		return
+	}
+	classes := make(map[string]string, len(registered))
+	for _, item := range registered {
+		classes[item.ItemCode] = item.ItemClassCode
+	}
+	for i := range items {
+		if class, ok := classes[items[i].ItemCode]; ok {
+			items[i].ItemClassificationCode = class
+		}
+	}
+}
+
+// invoiceVATRate is the VAT rate reported for the invoice as a whole: its own
+// rate, or the highest rate charged on its lines
+func invoiceVATRate(invoice *models.Invoice, items []models.InvoiceItem) float64 {
+	if invoice.TaxRate > 0 {
+		return invoice.TaxRate
+	}
+	var rate float64
+	for _, item := range items {
+		rate = max(rate, item.TaxRate)
+	}
+	return rate
+}
+
 // maskPIN masks KRA PIN for secure logging (shows only last 4 chars)
 func maskPIN(pin string) string {
 	if pin == "" {
@@ -664,6 +779,7 @@ func (s *KRAService) QueueFailedSubmission(tenantID, invoiceID, invoiceNumber st
 		logger.Get().Error(context.Background(), "Failed to queue item", "error", err)
 		return err
 	}
+	s.enqueueRetryJob(&queueItem, time.Now())
 
 	logger.Get().Info(context.Background(), "Queued failed submission for invoice",
 		"invoice_number", invoiceNumber,
@@ -673,17 +789,45 @@ func (s *KRAService) QueueFailedSubmission(tenantID, invoiceID, invoiceNumber st
 	return nil
 }
 
-// ProcessRetryQueue processes pending KRA submissions
+// kraQueueStaleAfter is how long an item may stay claimed before another run takes it over
+const kraQueueStaleAfter = 15 * time.Minute
+
+// ProcessRetryQueue replays pending KRA submissions whose retry time has come.
+// Items are claimed before submission so concurrent replicas never submit the same invoice twice.
 func (s *KRAService) ProcessRetryQueue() error {
 	if s.db == nil {
 		return errors.New("KRA queue unavailable")
 	}
+	// Finish recording submissions KRA already accepted; these are never resubmitted
+	var accepted []models.KRAQueueItem
+	if err := s.db.Where("status = ?", models.KRAQueueAccepted).
+		Order("created_at ASC").
+		Limit(50).
+		Find(&accepted).Error; err != nil {
+		return fmt.Errorf("failed to load KRA queue: %w", err)
+	}
+	for i := range accepted {
+		item := &accepted[i]
+		s.completeQueueItem(item, &KRAResponse{ICN: item.ICN, QRCode: item.QRCode})
+	}
+
+	if !s.hasLiveAPI() {
+		// Nothing to replay against; items stay pending until the API is configured
+		return nil
+	}
+
+	// Release items claimed by a run that died mid-submission
+	s.db.Model(&models.KRAQueueItem{}).
+		Where("status = ? AND updated_at < ?", models.KRAQueueProcessing, time.Now().Add(-kraQueueStaleAfter)).
+		Updates(map[string]interface{}{"status": models.KRAQueuePending, "updated_at": time.Now()})
 
 	var pending []models.KRAQueueItem
-	s.db.Where("status = ? AND (next_retry_at IS NULL OR next_retry_at <= ?)", models.KRAQueuePending, time.Now()).
+	if err := s.db.Where("status = ? AND (next_retry_at IS NULL OR next_retry_at <= ?)", models.KRAQueuePending, time.Now()).
 		Order("created_at ASC").
 		Limit(50).
-		Find(&pending)
+		Find(&pending).Error; err != nil {
+		return fmt.Errorf("failed to load KRA queue: %w", err)
+	}
 
 	if len(pending) == 0 {
 		return nil
@@ -691,49 +835,306 @@ func (s *KRAService) ProcessRetryQueue() error {
 
 	logger.Get().Info(context.Background(), "Processing queued items", "count", len(pending))
 
-	for _, item := range pending {
-		s.processQueueItem(&item)
+	for i := range pending {
+		item := &pending[i]
+		claim := s.db.Model(&models.KRAQueueItem{}).
+			Where("id = ? AND status = ?", item.ID, models.KRAQueuePending).
+			Updates(map[string]interface{}{"status": models.KRAQueueProcessing, "updated_at": time.Now()})
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+		s.processQueueItem(item)
 	}
 
 	return nil
 }
 
+// kraQueueJobPayload is the payload of a models.JobTypeKRAQueue job. Without a
+// queue item the job replays everything that is due.
+type kraQueueJobPayload struct {
+	QueueItemID string `json:"queue_item_id,omitempty"`
+}
+
+// enqueueRetryJob schedules a kra_queue job for the item's next attempt. The
+// periodic ProcessRetryQueue sweep still picks up anything the job misses.
+func (s *KRAService) enqueueRetryJob(item *models.KRAQueueItem, runAt time.Time) {
+	if s.jobQueue == nil {
+		return
+	}
+	payload, _ := json.Marshal(kraQueueJobPayload{QueueItemID: item.ID})
+	key := fmt.Sprintf("kra_%s_%d", item.ID, item.RetryCount)
+	if err := s.jobQueue.EnqueueJobWithIdempotency(key, &models.AutomationJob{
+		TenantID:       item.TenantID,
+		JobType:        models.JobTypeKRAQueue,
+		Priority:       1,
+		Payload:        string(payload),
+		RunAt:          runAt,
+		MaxRetries:     1,
+		IdempotencyKey: key,
+		InvoiceID:      &item.InvoiceID,
+	}); err != nil {
+		logger.Get().Error(context.Background(), "Failed to schedule KRA queue job", "item_id", item.ID, "error", err)
+	}
+}
+
+// ProcessQueueJob replays the queue item of a kra_queue job. Items already
+// claimed, completed or not yet due are left alone; the attempt itself
+// schedules the next retry, so the job never fails on a KRA error.
+func (s *KRAService) ProcessQueueJob(ctx context.Context, job *models.AutomationJob) error {
+	var payload kraQueueJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("invalid KRA queue job payload: %w", err)
+	}
+	if payload.QueueItemID == "" {
+		return s.ProcessRetryQueue()
+	}
+	if s.db == nil {
+		return errors.New("KRA queue unavailable")
+	}
+	if !s.hasLiveAPI() {
+		return nil
+	}
+
+	var item models.KRAQueueItem
+	if err := s.db.Where("id = ? AND tenant_id = ?", payload.QueueItemID, job.TenantID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if item.Status != models.KRAQueuePending || (item.NextRetryAt != nil && item.NextRetryAt.After(time.Now())) {
+		return nil
+	}
+	claim := s.db.Model(&models.KRAQueueItem{}).
+		Where("id = ? AND status = ?", item.ID, models.KRAQueuePending).
+		Updates(map[string]interface{}{"status": models.KRAQueueProcessing, "updated_at": time.Now()})
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil
+	}
+	s.processQueueItem(&item)
+	return nil
+}
+
+// kraRetryBackoff doubles the wait after every failed attempt: 5m, 10m, 20m ... capped at 6h
+func kraRetryBackoff(retryCount int) time.Duration {
+	if retryCount < 1 {
+		retryCount = 1
+	}
+	backoff := 5 * time.Minute
+	for i := 1; i < retryCount && backoff < 6*time.Hour; i++ {
+		backoff *= 2
+	}
+	if backoff > 6*time.Hour {
+		backoff = 6 * time.Hour
+	}
+	return backoff
+}
+
 func (s *KRAService) processQueueItem(item *models.KRAQueueItem) {
-	payload := []byte(item.Payload)
+	// Invoices cancelled or voided since they were queued are never registered
+	var invoice models.Invoice
+	err := s.db.Select("id", "status").Where("id = ? AND tenant_id = ?", item.InvoiceID, item.TenantID).First(&invoice).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		// Give the claim back; the next run tries again
+		s.db.Model(&models.KRAQueueItem{}).Where("id = ?", item.ID).
+			Updates(map[string]interface{}{"status": models.KRAQueuePending, "updated_at": time.Now()})
+		logger.Get().Error(context.Background(), "Failed to load invoice for queue item", "item_id", item.ID, "error", err)
+		return
+	}
+	if err != nil || invoice.Status == models.InvoiceStatusCancelled || invoice.Status == models.InvoiceStatusVoid {
+		item.Status = models.KRAQueueCancelled
+		item.NextRetryAt = nil
+		item.LastError = "invoice cancelled"
+		item.UpdatedAt = time.Now()
+		if txErr := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(item).Error; err != nil {
+				return err
+			}
+			return tx.Create(s.queueAuditLog(item, "kra_cancel", string(item.Status), nil, item.LastError)).Error
+		}); txErr != nil {
+			logger.Get().Error(context.Background(), "Failed to cancel KRA queue item",
+				"item_id", item.ID, "error", txErr)
+			return
+		}
+		logger.Get().Info(context.Background(), "Skipped queue item of cancelled invoice",
+			"item_id", item.ID, "invoice_number", item.InvoiceNumber)
+		return
+	}
 
-	resp, err := s.submitToKRA(payload)
-	if err != nil {
-		item.RetryCount++
-		item.LastError = err.Error()
-
-		if item.RetryCount >= item.MaxRetries {
-			item.Status = models.KRAQueueFailed
-			logger.Get().Error(context.Background(), "Queue item failed permanently",
-				"item_id", item.ID,
-				"retries", item.RetryCount,
-			)
-		} else {
-			nextRetry := time.Now().Add(time.Duration(item.RetryCount) * 5 * time.Minute)
-			item.NextRetryAt = &nextRetry
-			logger.Get().Warn(context.Background(), "Queue item failed, will retry",
-				"item_id", item.ID,
-				"retry_count", item.RetryCount,
-				"max_retries", item.MaxRetries,
-				"next_retry", nextRetry,
-			)
+	resp, err := s.submitToKRA([]byte(item.Payload))
+	if err == nil && resp == nil {
+		err = errors.New("nil response from KRA")
+	}
+
+	now := time.Now()
+	item.UpdatedAt = now
+
+	if err == nil {
+		// Keep the ICN on the item before touching the invoice: an accepted item
+		// is never released back to pending, so KRA never sees the invoice twice
+		item.Status = models.KRAQueueAccepted
+		item.ICN = resp.ICN
+		item.QRCode = resp.QRCode
+		item.LastError = ""
+		if err := s.db.Model(&models.KRAQueueItem{}).Where("id = ?", item.ID).
+			Updates(map[string]interface{}{
+				"status":     item.Status,
+				"icn":        item.ICN,
+				"qr_code":    item.QRCode,
+				"last_error": "",
+				"updated_at": now,
+			}).Error; err != nil {
+			// Left processing; log the ICN so the record can be reconciled by hand
+			logger.Get().Error(context.Background(), "Failed to record KRA acceptance on queue item",
+				"item_id", item.ID, "icn", resp.ICN, "error", err)
+			return
 		}
+		s.completeQueueItem(item, resp)
+		return
+	}
+
+	item.RetryCount++
+	item.LastError = err.Error()
+	exhausted := item.RetryCount >= item.MaxRetries
+
+	action := "kra_retry"
+	if exhausted {
+		item.Status = models.KRAQueueFailed
+		item.NextRetryAt = nil
+		action = "kra_failed"
 	} else {
-		item.Status = models.KRAQueueCompleted
-		now := time.Now()
-		item.CompletedAt = &now
-		logger.Get().Info(context.Background(), "Queue item completed",
+		item.Status = models.KRAQueuePending
+		nextRetry := now.Add(kraRetryBackoff(item.RetryCount))
+		item.NextRetryAt = &nextRetry
+	}
+
+	if txErr := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(item).Error; err != nil {
+			return err
+		}
+		invoiceUpdates := map[string]interface{}{
+			"kra_error":       item.LastError,
+			"kra_retry_count": gorm.Expr("kra_retry_count + 1"),
+		}
+		if exhausted {
+			invoiceUpdates["kra_status"] = models.KRAInvoiceStatusFailed
+		}
+		if err := tx.Model(&models.Invoice{}).
+			Where("id = ? AND tenant_id = ?", item.InvoiceID, item.TenantID).
+			Updates(invoiceUpdates).Error; err != nil {
+			return err
+		}
+		return tx.Create(s.queueAuditLog(item, action, string(item.Status), nil, item.LastError)).Error
+	}); txErr != nil {
+		// The item stays in processing and is picked up again once it goes stale
+		logger.Get().Error(context.Background(), "Failed to record KRA queue failure",
+			"item_id", item.ID, "kra_error", item.LastError, "error", txErr)
+		return
+	}
+
+	if !exhausted {
+		s.enqueueRetryJob(item, *item.NextRetryAt)
+		logger.Get().Warn(context.Background(), "Queue item failed, will retry",
 			"item_id", item.ID,
-			"icn", resp.ICN,
+			"retry_count", item.RetryCount,
+			"max_retries", item.MaxRetries,
+			"next_retry", item.NextRetryAt,
 		)
+		return
+	}
+
+	logger.Get().Error(context.Background(), "Queue item failed permanently",
+		"item_id", item.ID,
+		"invoice_number", item.InvoiceNumber,
+		"retries", item.RetryCount,
+	)
+	if s.notificationSvc != nil {
+		s.notificationSvc.SendKRASubmissionFailedAlert(item.TenantID, item.InvoiceID, item.InvoiceNumber, item.LastError)
+	}
+	s.publishKRAEvent(models.TriggerEventKRARejected, item.TenantID, item.InvoiceID, item.InvoiceNumber, nil, item.LastError)
+}
+
+// errKRAQueueItemClosed means another run already recorded the accepted item
+var errKRAQueueItemClosed = errors.New("KRA queue item already completed")
+
+// completeQueueItem records an accepted item's ICN on the invoice and closes
+// the item. Until that succeeds the item stays accepted and the next
+// ProcessRetryQueue run tries again without resubmitting.
+func (s *KRAService) completeQueueItem(item *models.KRAQueueItem, resp *KRAResponse) {
+	now := time.Now()
+	if txErr := s.db.Transaction(func(tx *gorm.DB) error {
+		done := tx.Model(&models.KRAQueueItem{}).
+			Where("id = ? AND status = ?", item.ID, models.KRAQueueAccepted).
+			Updates(map[string]interface{}{
+				"status":       models.KRAQueueCompleted,
+				"completed_at": now,
+				"updated_at":   now,
+			})
+		if done.Error != nil {
+			return done.Error
+		}
+		if done.RowsAffected == 0 {
+			return errKRAQueueItemClosed
+		}
+		if err := tx.Model(&models.Invoice{}).
+			Where("id = ? AND tenant_id = ?", item.InvoiceID, item.TenantID).
+			Updates(map[string]interface{}{
+				"kra_icn":          item.ICN,
+				"kra_qr_code":      item.QRCode,
+				"kra_status":       models.KRAInvoiceStatusSubmitted,
+				"kra_submitted_at": now,
+				"kra_error":        "",
+			}).Error; err != nil {
+			return err
+		}
+		item.Status = models.KRAQueueCompleted
+		return tx.Create(s.queueAuditLog(item, "kra_success", string(models.KRAInvoiceStatusSubmitted), resp, "")).Error
+	}); txErr != nil {
+		item.Status = models.KRAQueueAccepted
+		if errors.Is(txErr, errKRAQueueItemClosed) {
+			return
+		}
+		logger.Get().Error(context.Background(), "Failed to record KRA queue success; retried on the next run",
+			"item_id", item.ID, "icn", item.ICN, "error", txErr)
+		return
 	}
+	item.CompletedAt = &now
+	item.UpdatedAt = now
+	logger.Get().Info(context.Background(), "Queue item completed",
+		"item_id", item.ID,
+		"invoice_number", item.InvoiceNumber,
+		"icn", item.ICN,
+	)
+	s.publishKRAEvent(models.TriggerEventKRAAccepted, item.TenantID, item.InvoiceID, item.InvoiceNumber, resp, "")
+}
 
-	item.UpdatedAt = time.Now()
-	s.db.Save(item)
+// queueAuditLog builds the KRA audit trail entry for a queue replay attempt
+func (s *KRAService) queueAuditLog(item *models.KRAQueueItem, action, status string, resp *KRAResponse, errMsg string) *models.KRAAuditLog {
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"queue_item_id":  item.ID,
+		"invoice_number": item.InvoiceNumber,
+		"retry_count":    item.RetryCount,
+		"max_retries":    item.MaxRetries,
+	})
+	entry := &models.KRAAuditLog{
+		ID:           uuid.New().String(),
+		TenantID:     item.TenantID,
+		InvoiceID:    item.InvoiceID,
+		Action:       action,
+		Status:       status,
+		ErrorMessage: errMsg,
+		MetadataJSON: string(metadata),
+		CreatedAt:    time.Now(),
+	}
+	if resp != nil {
+		entry.ICN = resp.ICN
+		entry.ResponseCode = resp.ResultCode
+		entry.ResponseDesc = resp.ResultDesc
+	}
+	return entry
 }
 
 // GetQueueStatus returns the status of KRA queue
@@ -0,0 +1,1363 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============================================================================
+// GENERAL LEDGER - chart of accounts, journal postings and ledger reports
+// ============================================================================
+//
+// Invoices, payments, late fees, expenses and payouts are posted from the
+// documents themselves: PostPending finds documents without a journal entry
+// and posts them. Every entry records its source and a unique index on
+// (tenant, source type, source ID) makes posting idempotent, so a missed or
+// repeated run never loses or doubles an entry. Reversals (voided invoices,
+// refunds, waived late fees) are posted as separate entries; nothing posted
+// is ever changed.
+
+var (
+	ErrLedgerAccountNotFound = errors.New("ledger account not found")
+	ErrLedgerAccountCode     = errors.New("an account with this code already exists")
+	ErrLedgerAccountInvalid  = errors.New("account code, name and type (asset, liability, equity, income, expense) are required")
+	ErrLedgerAccountInactive = errors.New("journal lines can only post to active accounts")
+	ErrLedgerSystemKey       = errors.New("a system account can only move to an active account of the same type, and can't be deactivated")
+	ErrJournalNotFound       = errors.New("journal entry not found")
+	ErrJournalUnbalanced     = errors.New("a journal entry needs at least two lines, each a debit or a credit, and debits must equal credits")
+	ErrJournalNotReversible  = errors.New("only manual journal entries can be reversed; automatic entries follow their documents")
+	ErrJournalReversed       = errors.New("journal entry has already been reversed")
+)
+
+type ledgerAccountDef struct {
+	Code, Name, Type, SystemKey string
+}
+
+// defaultChartOfAccounts is seeded for each tenant the first time the ledger is used
+var defaultChartOfAccounts = []ledgerAccountDef{
+	{"1000", "Cash on Hand", models.LedgerAsset, models.LedgerKeyCash},
+	{"1010", "Bank", models.LedgerAsset, models.LedgerKeyBank},
+	{"1020", "M-Pesa", models.LedgerAsset, models.LedgerKeyMpesa},
+	{"1030", "Card Clearing", models.LedgerAsset, models.LedgerKeyCardClearing},
+	{"1100", "Accounts Receivable", models.LedgerAsset, models.LedgerKeyReceivable},
+	{"1300", "VAT Input", models.LedgerAsset, models.LedgerKeyInputVAT},
+	{"1310", "Withholding VAT Receivable", models.LedgerAsset, models.LedgerKeyWithholdingVAT},
+	{"1320", "Withholding Tax Receivable", models.LedgerAsset, models.LedgerKeyWithholdingTax},
+	{"2000", "Accounts Payable", models.LedgerLiability, models.LedgerKeyPayable},
+	{"2100", "VAT Output", models.LedgerLiability, models.LedgerKeyOutputVAT},
+	{"2200", "Customer Credits", models.LedgerLiability, models.LedgerKeyCustomerCredit},
+	{"3000", "Owner's Equity", models.LedgerEquity, models.LedgerKeyOwnerEquity},
+	{"3100", "Retained Earnings", models.LedgerEquity, models.LedgerKeyRetainedEarnings},
+	{"4000", "Sales", models.LedgerIncome, models.LedgerKeySales},
+	{"4100", "Late Fee Income", models.LedgerIncome, models.LedgerKeyLateFeeIncome},
+	{"4200", "Realized FX Gain/Loss", models.LedgerIncome, models.LedgerKeyRealizedFX},
+	{"4210", "Unrealized FX Gain/Loss", models.LedgerIncome, models.LedgerKeyUnrealizedFX},
+	{"5000", "Operating Expenses", models.LedgerExpense, models.LedgerKeyExpenses},
+}
+
+func isLedgerAccountType(t string) bool {
+	switch t {
+	case models.LedgerAsset, models.LedgerLiability, models.LedgerEquity, models.LedgerIncome, models.LedgerExpense:
+		return true
+	}
+	return false
+}
+
+// LedgerService keeps the chart of accounts and the journal
+type LedgerService struct {
+	db *database.DB
+	// postMu runs one posting pass at a time in this process; the unique
+	// source index stops a second instance posting the same document
+	postMu  sync.Mutex
+	periods *PeriodService
+	rates   *ExchangeRateService
+}
+
+func NewLedgerService(db *database.DB) *LedgerService {
+	return &LedgerService{db: db}
+}
+
+// SetPeriodService rejects manual journals dated in closed accounting periods (optional)
+func (s *LedgerService) SetPeriodService(periods *PeriodService) {
+	s.periods = periods
+}
+
+// SetExchangeRateService values foreign-currency payments and revaluations at
+// dated rates; without it payments settle at the invoice's rate
+func (s *LedgerService) SetExchangeRateService(rates *ExchangeRateService) {
+	s.rates = rates
+}
+
+// ============================================================================
+// CHART OF ACCOUNTS
+// ============================================================================
+
+// LedgerAccountInput creates or changes an account
+type LedgerAccountInput struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	IsActive    *bool  `json:"is_active"`
+}
+
+// EnsureChart seeds the default chart of accounts for a tenant that has none,
+// and adds system accounts introduced since a tenant's chart was seeded
+func (s *LedgerService) EnsureChart(tenantID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	var held []string
+	if err := s.db.Model(&models.LedgerAccount{}).Scopes(database.TenantFilter(tenantID)).
+		Where("system_key <> ''").Pluck("system_key", &held).Error; err != nil {
+		return err
+	}
+	have := make(map[string]bool, len(held))
+	for _, key := range held {
+		have[key] = true
+	}
+
+	accounts := make([]models.LedgerAccount, 0, len(defaultChartOfAccounts))
+	for _, def := range defaultChartOfAccounts {
+		if have[def.SystemKey] {
+			continue
+		}
+		accounts = append(accounts, models.LedgerAccount{
+			ID:        uuid.New().String(),
+			TenantID:  tenantID,
+			Code:      def.Code,
+			Name:      def.Name,
+			Type:      def.Type,
+			SystemKey: def.SystemKey,
+			IsActive:  true,
+		})
+	}
+	if len(accounts) == 0 {
+		return nil
+	}
+	// Another request may be seeding the same tenant
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&accounts).Error
+}
+
+func (s *LedgerService) ListAccounts(tenantID string) ([]models.LedgerAccount, error) {
+	if err := s.EnsureChart(tenantID); err != nil {
+		return nil, err
+	}
+	var accounts []models.LedgerAccount
+	err := s.db.Scopes(database.TenantFilter(tenantID)).Order("code").Find(&accounts).Error
+	return accounts, err
+}
+
+func (s *LedgerService) GetAccount(tenantID, id string) (*models.LedgerAccount, error) {
+	var account models.LedgerAccount
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&account, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrLedgerAccountNotFound
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (s *LedgerService) CreateAccount(tenantID string, input LedgerAccountInput) (*models.LedgerAccount, error) {
+	if err := s.EnsureChart(tenantID); err != nil {
+		return nil, err
+	}
+	code := strings.TrimSpace(input.Code)
+	name := strings.TrimSpace(input.Name)
+	if code == "" || name == "" || !isLedgerAccountType(input.Type) {
+		return nil, ErrLedgerAccountInvalid
+	}
+
+	var count int64
+	if err := s.db.Model(&models.LedgerAccount{}).Scopes(database.TenantFilter(tenantID)).
+		Where("code = ?", code).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrLedgerAccountCode
+	}
+
+	account := &models.LedgerAccount{
+		ID:          uuid.New().String(),
+		TenantID:    tenantID,
+		Code:        code,
+		Name:        name,
+		Type:        input.Type,
+		Description: input.Description,
+		IsActive:    true,
+	}
+	if err := s.db.Create(account).Error; err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// UpdateAccount renames, re-describes or (de)activates an account. The type
+// can't change once lines may have posted to it.
+func (s *LedgerService) UpdateAccount(tenantID, id string, input LedgerAccountInput) (*models.LedgerAccount, error) {
+	account, err := s.GetAccount(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if code := strings.TrimSpace(input.Code); code != "" && code != account.Code {
+		var count int64
+		if err := s.db.Model(&models.LedgerAccount{}).Scopes(database.TenantFilter(tenantID)).
+			Where("code = ?", code).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, ErrLedgerAccountCode
+		}
+		updates["code"] = code
+	}
+	if name := strings.TrimSpace(input.Name); name != "" {
+		updates["name"] = name
+	}
+	if input.Description != "" {
+		updates["description"] = input.Description
+	}
+	if input.IsActive != nil {
+		if !*input.IsActive && account.SystemKey != "" {
+			return nil, ErrLedgerSystemKey
+		}
+		updates["is_active"] = *input.IsActive
+	}
+	if len(updates) > 0 {
+		if err := s.db.Model(&models.LedgerAccount{}).Where("id = ?", account.ID).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	return s.GetAccount(tenantID, id)
+}
+
+// AssignSystemKey moves an automatic posting target, e.g. sales, to another
+// account of the same type. Entries already posted stay where they are.
+func (s *LedgerService) AssignSystemKey(tenantID, accountID, systemKey string) (*models.LedgerAccount, error) {
+	account, err := s.GetAccount(tenantID, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var holder models.LedgerAccount
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&holder, "system_key = ?", systemKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrLedgerSystemKey
+		}
+		return nil, err
+	}
+	if holder.ID == account.ID {
+		return account, nil
+	}
+	if !account.IsActive || account.Type != holder.Type {
+		return nil, ErrLedgerSystemKey
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.LedgerAccount{}).Where("id = ?", holder.ID).Update("system_key", "").Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.LedgerAccount{}).Where("id = ?", account.ID).Update("system_key", systemKey).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAccount(tenantID, accountID)
+}
+
+// systemAccounts maps each system key to the account that holds it
+func (s *LedgerService) systemAccounts(tenantID string) (map[string]string, error) {
+	var accounts []models.LedgerAccount
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Where("system_key <> ''").
+		Select("id", "system_key").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string, len(accounts))
+	for _, a := range accounts {
+		keys[a.SystemKey] = a.ID
+	}
+	for _, def := range defaultChartOfAccounts {
+		if keys[def.SystemKey] == "" {
+			return nil, fmt.Errorf("no ledger account holds system key %q", def.SystemKey)
+		}
+	}
+	return keys, nil
+}
+
+// ============================================================================
+// POSTING
+// ============================================================================
+
+// journalDraft is an entry being built; amounts are signed, debits positive
+type journalDraft struct {
+	entry models.JournalEntry
+	lines []models.JournalLine
+}
+
+func newJournalDraft(sourceType, sourceID string, date time.Time, memo string) *journalDraft {
+	return &journalDraft{entry: models.JournalEntry{
+		SourceType: sourceType,
+		SourceID:   sourceID,
+		Date:       date,
+		Memo:       memo,
+	}}
+}
+
+// add posts amount (KES) to an account: a debit when positive, a credit when
+// negative. fx is the same amount in the document's currency, if not KES.
+func (d *journalDraft) add(accountID string, amount, fx models.Money, currency, description string) {
+	if amount == 0 {
+		return
+	}
+	line := models.JournalLine{AccountID: accountID, Description: description}
+	if amount > 0 {
+		line.Debit = amount
+	} else {
+		line.Credit = -amount
+	}
+	if currency != "" && currency != "KES" {
+		line.Currency = currency
+		line.FxAmount = fx
+	}
+	d.lines = append(d.lines, line)
+}
+
+// reversalDraft swaps the debits and credits of a posted entry
+func reversalDraft(original *models.JournalEntry, sourceType, sourceID string, date time.Time, memo string) *journalDraft {
+	d := newJournalDraft(sourceType, sourceID, date, memo)
+	d.entry.ReversesID = original.ID
+	for _, l := range original.Lines {
+		d.lines = append(d.lines, models.JournalLine{
+			AccountID:   l.AccountID,
+			Debit:       l.Credit,
+			Credit:      l.Debit,
+			Description: l.Description,
+			Currency:    l.Currency,
+			FxAmount:    -l.FxAmount,
+		})
+	}
+	return d
+}
+
+func validateJournalLines(lines []models.JournalLine) error {
+	if len(lines) < 2 {
+		return ErrJournalUnbalanced
+	}
+	var debits, credits models.Money
+	for _, l := range lines {
+		if l.Debit < 0 || l.Credit < 0 || (l.Debit == 0) == (l.Credit == 0) {
+			return ErrJournalUnbalanced
+		}
+		debits = debits.Add(l.Debit)
+		credits = credits.Add(l.Credit)
+	}
+	if !debits.Equals(credits) {
+		return ErrJournalUnbalanced
+	}
+	return nil
+}
+
+// post numbers and writes a balanced entry
+func (s *LedgerService) post(tx *gorm.DB, tenantID, userID string, d *journalDraft) (*models.JournalEntry, error) {
+	if err := validateJournalLines(d.lines); err != nil {
+		return nil, err
+	}
+
+	number, _, err := nextDocumentNumber(tx, tenantID, models.SequenceDocumentJournal)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := d.entry
+	entry.ID = uuid.New().String()
+	entry.TenantID = tenantID
+	entry.Number = number
+	entry.PostedBy = userID
+	if entry.SourceID == "" {
+		entry.SourceID = entry.ID
+	}
+	if err := tx.Omit("Lines").Create(&entry).Error; err != nil {
+		return nil, err
+	}
+
+	lines := make([]models.JournalLine, len(d.lines))
+	for i, l := range d.lines {
+		l.ID = uuid.New().String()
+		l.TenantID = tenantID
+		l.EntryID = entry.ID
+		lines[i] = l
+	}
+	if err := tx.Omit("Account").Create(&lines).Error; err != nil {
+		return nil, err
+	}
+	entry.Lines = lines
+	return &entry, nil
+}
+
+// unposted limits a query on table to rows without a sourceType journal entry
+func unposted(table, sourceType string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("NOT EXISTS (SELECT 1 FROM journal_entries je WHERE je.tenant_id = "+table+".tenant_id AND je.source_type = ? AND je.source_id = "+table+".id)", sourceType)
+	}
+}
+
+// posted limits a query on table to rows with a sourceType journal entry
+func posted(table, sourceType string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("EXISTS (SELECT 1 FROM journal_entries je WHERE je.tenant_id = "+table+".tenant_id AND je.source_type = ? AND je.source_id = "+table+".id)", sourceType)
+	}
+}
+
+// ledgerRate is the KES rate for a document amount
+func ledgerRate(currency string, rate float64) float64 {
+	if currency == "" || currency == "KES" || rate <= 0 {
+		return 1
+	}
+	return rate
+}
+
+// settlementRate is KES per unit for a payment in its invoice's currency: the
+// rate stored on the payment, else the rate on the day it was paid, else the
+// invoice's own rate (no gain or loss)
+func (s *LedgerService) settlementRate(tenantID string, payment *models.Payment, bookRate float64, date time.Time) float64 {
+	if payment.Currency == "" || payment.Currency == "KES" {
+		return 1
+	}
+	if payment.ExchangeRate > 0 {
+		return payment.ExchangeRate
+	}
+	if s.rates != nil {
+		if rate, err := s.rates.RateOn(tenantID, payment.Currency, date); err == nil && rate > 0 {
+			return rate
+		}
+	}
+	return bookRate
+}
+
+// withholdingAccounts are where tax withheld by clients is held until it's claimed
+var withholdingAccounts = []struct{ withholdingType, systemKey string }{
+	{models.WithholdingVAT, models.LedgerKeyWithholdingVAT},
+	{models.WithholdingIncomeTax, models.LedgerKeyWithholdingTax},
+}
+
+// cashAccountKey is the account money received or paid by method goes through
+func cashAccountKey(method string) string {
+	switch method {
+	case string(models.PaymentMethodMpesa), string(models.PaymentMethodIntasend):
+		return models.LedgerKeyMpesa
+	case string(models.PaymentMethodCard):
+		return models.LedgerKeyCardClearing
+	case string(models.PaymentMethodCash):
+		return models.LedgerKeyCash
+	}
+	return models.LedgerKeyBank
+}
+
+// PostPending posts every document that doesn't have its journal entry yet
+// and returns how many entries were posted. A document that fails to post is
+// logged and retried on the next run.
+func (s *LedgerService) PostPending(tenantID string) (int, error) {
+	s.postMu.Lock()
+	defer s.postMu.Unlock()
+
+	if err := s.EnsureChart(tenantID); err != nil {
+		return 0, err
+	}
+	accounts, err := s.systemAccounts(tenantID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Reversals come after the entries they reverse
+	steps := []func(string, map[string]string) ([]*journalDraft, error){
+		s.pendingInvoices,
+		s.pendingLateFees,
+		s.pendingPayments,
+		s.pendingExpenses,
+		s.pendingExpensePayments,
+		s.pendingPayouts,
+		s.pendingInvoiceVoids,
+		s.pendingLateFeeWaivers,
+		s.pendingRefunds,
+	}
+
+	count := 0
+	for _, step := range steps {
+		drafts, err := step(tenantID, accounts)
+		if err != nil {
+			return count, err
+		}
+		for _, d := range drafts {
+			err := s.db.Transaction(func(tx *gorm.DB) error {
+				_, err := s.post(tx, tenantID, "", d)
+				return err
+			})
+			if err != nil {
+				logger.Get().Warn(context.Background(), "Failed to post journal entry",
+					"tenant_id", tenantID, "source_type", d.entry.SourceType, "source_id", d.entry.SourceID, "error", err.Error())
+				continue
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// PostAllPending runs PostPending for every tenant
+func (s *LedgerService) PostAllPending() error {
+	var tenantIDs []string
+	if err := s.db.Model(&models.Tenant{}).Pluck("id", &tenantIDs).Error; err != nil {
+		return err
+	}
+	for _, tenantID := range tenantIDs {
+		if n, err := s.PostPending(tenantID); err != nil {
+			logger.Get().Error(context.Background(), "Ledger posting failed", "tenant_id", tenantID, "error", err.Error())
+		} else if n > 0 {
+			logger.Get().Info(context.Background(), "Posted journal entries", "tenant_id", tenantID, "count", n)
+		}
+	}
+	return nil
+}
+
+// lateFeeTotals sums the late fees added to each invoice's total
+func (s *LedgerService) lateFeeTotals(tenantID string, invoiceIDs *gorm.DB) (map[string]models.Money, error) {
+	var rows []struct {
+		InvoiceID string
+		Total     int64
+	}
+	if err := s.db.Model(&models.LateFeeInvoice{}).Scopes(database.TenantFilter(tenantID)).
+		Select("invoice_id, SUM(fee_amount) AS total").
+		Where("invoice_id IN (?)", invoiceIDs).
+		Group("invoice_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	totals := make(map[string]models.Money, len(rows))
+	for _, r := range rows {
+		totals[r.InvoiceID] = models.Money(r.Total)
+	}
+	return totals, nil
+}
+
+// invoiceTax splits the tax out of a signed invoice amount. Credit and debit
+// notes don't keep their tax, so it is what the total adds on top of the net.
+func invoiceTax(invoice *models.Invoice, gross models.Money) models.Money {
+	sign := models.Money(1)
+	if gross < 0 {
+		sign = -1
+	}
+	tax := invoice.TotalTax * sign
+	if invoice.TotalTax == 0 {
+		tax = gross - sign*(invoice.Subtotal-invoice.Discount)
+	}
+	if tax*sign < 0 || tax*sign > gross*sign {
+		return 0
+	}
+	return tax
+}
+
+func invoiceMemo(invoice *models.Invoice) string {
+	switch invoice.InvoiceType {
+	case "credit_note":
+		return "Credit note " + invoice.InvoiceNumber
+	case "debit_note":
+		return "Debit note " + invoice.InvoiceNumber
+	}
+	return "Invoice " + invoice.InvoiceNumber
+}
+
+// pendingInvoices posts invoices, credit notes and debit notes once they leave
+// draft: Dr receivable, Cr sales and VAT output. Late fees post separately.
+func (s *LedgerService) pendingInvoices(tenantID string, accounts map[string]string) ([]*journalDraft, error) {
+	query := s.db.Model(&models.Invoice{}).Scopes(database.TenantFilter(tenantID), unposted("invoices", models.JournalSourceInvoice)).
+		Where("status <> ? AND total <> 0 AND deleted_at IS NULL", models.InvoiceStatusDraft).
+		Where("NOT (status IN ? AND sent_at IS NULL)", []models.InvoiceStatus{models.InvoiceStatusCancelled, models.InvoiceStatusVoid})
+
+	var invoices []models.Invoice
+	if err := query.Session(&gorm.Session{}).Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return nil, nil
+	}
+	fees, err := s.lateFeeTotals(tenantID, query.Session(&gorm.Session{}).Select("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	drafts := make([]*journalDraft, 0, len(invoices))
+	for i := range invoices {
+		invoice := &invoices[i]
+		date := invoice.CreatedAt
+		if invoice.SentAt != nil {
+			date = *invoice.SentAt
+		}
+		rate := ledgerRate(invoice.Currency, invoice.ExchangeRate)
+		gross := invoice.Total.Sub(fees[invoice.ID])
+		tax := invoiceTax(invoice, gross)
+		kesGross, kesTax := gross.Mul(rate), tax.Mul(rate)
+
+		d := newJournalDraft(models.JournalSourceInvoice, invoice.ID, date, invoiceMemo(invoice))
+		d.add(accounts[models.LedgerKeyReceivable], kesGross, gross, invoice.Currency, invoice.InvoiceNumber)
+		d.add(accounts[models.LedgerKeySales], -(kesGross - kesTax), -(gross - tax), invoice.Currency, invoice.InvoiceNumber)
+		d.add(accounts[models.LedgerKeyOutputVAT], -kesTax, -tax, invoice.Currency, invoice.InvoiceNumber)
+		drafts = append(drafts, d)
+	}
+	return drafts, nil
+}
+
+// pendingLateFees posts late fees: Dr receivable, Cr late fee income
+func (s *LedgerService) pendingLateFees(tenantID string, accounts map[string]string) ([]*journalDraft, error) {
+	var fees []models.LateFeeInvoice
+	if err := s.db.Scopes(database.TenantFilter(tenantID), unposted("late_fee_invoices", models.JournalSourceLateFee)).
+		Where("fee_amount > 0").Find(&fees).Error; err != nil {
+		return nil, err
+	}
+	if len(fees) == 0 {
+		return nil, nil
+	}
+	invoices, err := invoicesByID(s, tenantID, fees, func(f models.LateFeeInvoice) string { return f.InvoiceID })
+	if err != nil {
+		return nil, err
+	}
+
+	drafts := make([]*journalDraft, 0, len(fees))
+	for _, fee := range fees {
+		invoice := invoices[fee.InvoiceID]
+		date := fee.AppliedAt
+		if date.IsZero() {
+			date = fee.CreatedAt
+		}
+		rate := ledgerRate(invoice.Currency, invoice.ExchangeRate)
+		kes := fee.FeeAmount.Mul(rate)
+
+		d := newJournalDraft(models.JournalSourceLateFee, fee.ID, date, "Late fee on "+invoice.InvoiceNumber)
+		d.add(accounts[models.LedgerKeyReceivable], kes, fee.FeeAmount, invoice.Currency, invoice.InvoiceNumber)
+		d.add(accounts[models.LedgerKeyLateFeeIncome], -kes, -fee.FeeAmount, invoice.Currency, invoice.InvoiceNumber)
+		drafts = append(drafts, d)
+	}
+	return drafts, nil
+}
+
+// invoicesByID loads the invoices a set of documents refer to
+func invoicesByID[T any](s *LedgerService, tenantID string, docs []T, invoiceID func(T) string) (map[string]models.Invoice, error) {
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		if id := invoiceID(doc); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	byID := make(map[string]models.Invoice, len(ids))
+	for start := 0; start < len(ids); start += 500 {
+		var invoices []models.Invoice
+		if err := s.db.Scopes(database.TenantFilter(tenantID)).
+			Select("id", "invoice_number", "currency", "exchange_rate").
+			Where("id IN ?", ids[start:min(start+500, len(ids))]).Find(&invoices).Error; err != nil {
+			return nil, err
+		}
+		for _, invoice := range invoices {
+			byID[invoice.ID] = invoice
+		}
+	}
+	return byID, nil
+}
+
+// pendingPayments posts money received: Dr the cash account for the method,
+// Cr receivable for what was allocated to invoices and customer credits for the rest
+func (s *LedgerService) pendingPayments(tenantID string, accounts map[string]string) ([]*journalDraft, error) {
+	query := s.db.Model(&models.Payment{}).Scopes(database.TenantFilter(tenantID), unposted("payments", models.JournalSourcePayment)).
+		Where("status IN ? AND amount > 0", []models.PaymentStatus{models.PaymentStatusCompleted, models.PaymentStatusRefunded})
+
+	var payments []models.Payment
+	if err := query.Session(&gorm.Session{}).
+		Select("id", "tenant_id", "invoice_id", "amount", "currency", "method", "reference", "exchange_rate", "withheld", "completed_at", "created_at").
+		Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, nil
+	}
+
+	var allocations []models.PaymentAllocation
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("payment_id IN (?)", query.Session(&gorm.Session{}).Select("id")).
+		Find(&allocations).Error; err != nil {
+		return nil, err
+	}
+	allocated := make(map[string]models.Money)
+	for _, a := range allocations {
+		allocated[a.PaymentID] = allocated[a.PaymentID].Add(a.Amount)
+	}
+	// Tax withheld by the client, by payment and type
+	var withholdings []models.WithholdingCertificate
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Select("payment_id", "type", "amount").
+		Where("payment_id IN (?)", query.Session(&gorm.Session{}).Where("withheld <> 0").Select("id")).
+		Find(&withholdings).Error; err != nil {
+		return nil, err
+	}
+	withheld := make(map[string]map[string]models.Money)
+	for _, w := range withholdings {
+		if withheld[w.PaymentID] == nil {
+			withheld[w.PaymentID] = make(map[string]models.Money)
+		}
+		withheld[w.PaymentID][w.Type] = withheld[w.PaymentID][w.Type].Add(w.Amount)
+	}
+	invoices, err := invoicesByID(s, tenantID, payments, func(p models.Payment) string { return p.InvoiceID })
+	if err != nil {
+		return nil, err
+	}
+
+	drafts := make([]*journalDraft, 0, len(payments))
+	for _, payment := range payments {
+		invoice := invoices[payment.InvoiceID]
+		date := payment.CreatedAt
+		if payment.CompletedAt != nil {
+			date = *payment.CompletedAt
+		}
+		// Payments from before allocations were recorded went to their invoice in full
+		applied, ok := allocated[payment.ID]
+		if !ok || applied > payment.Amount {
+			applied = payment.Amount
+		}
+		credit := payment.Amount.Sub(applied)
+
+		// Payments in the invoice's currency clear the receivable at the
+		// invoice's rate; the money is worth what it was on the day it came in
+		bookRate, rate := 1.0, 1.0
+		if payment.Currency == invoice.Currency {
+			bookRate = ledgerRate(invoice.Currency, invoice.ExchangeRate)
+			rate = s.settlementRate(tenantID, &payment, bookRate, date)
+		}
+		kesAmount, kesCredit := payment.Amount.Mul(rate), credit.Mul(rate)
+		kesApplied := applied.Mul(bookRate)
+		gain := kesAmount.Sub(kesCredit).Sub(kesApplied)
+
+		memo := "Payment"
+		if invoice.InvoiceNumber != "" {
+			memo += " for " + invoice.InvoiceNumber
+		}
+		d := newJournalDraft(models.JournalSourcePayment, payment.ID, date, memo)
+		d.add(accounts[cashAccountKey(string(payment.Method))], kesAmount, payment.Amount, payment.Currency, payment.Reference)
+		d.add(accounts[models.LedgerKeyReceivable], -kesApplied, -applied, payment.Currency, payment.Reference)
+		d.add(accounts[models.LedgerKeyCustomerCredit], -kesCredit, -credit, payment.Currency, payment.Reference)
+		// Withholding settles the receivable too and is held until it's claimed
+		for _, w := range withholdingAccounts {
+			amount := withheld[payment.ID][w.withholdingType]
+			d.add(accounts[w.systemKey], amount.Mul(bookRate), amount, payment.Currency, payment.Reference)
+			d.add(accounts[models.LedgerKeyReceivable], -amount.Mul(bookRate), -amount, payment.Currency, payment.Reference)
+		}
+		d.add(accounts[models.LedgerKeyRealizedFX], -gain, 0, "", payment.Reference)
+		drafts = append(drafts, d)
+	}
+	return drafts, nil
+}
+
+// pendingExpenses posts approved expenses: Dr expenses and VAT input, Cr payable
+func (s *LedgerService) pendingExpenses(tenantID string, accounts map[string]string) ([]*journalDraft, error) {
+	var expenses []models.Expense
+	if err := s.db.Scopes(database.TenantFilter(tenantID), unposted("expenses", models.JournalSourceExpense)).
+		Where("status IN ? AND amount > 0", []string{"approved", "paid"}).Find(&expenses).Error; err != nil {
+		return nil, err
+	}
+
+	drafts := make([]*journalDraft, 0, len(expenses))
+	for _, expense := range expenses {
+		date := expense.Date
+		if expense.ApprovedAt != nil {
+			date = *expense.ApprovedAt
+		}
+		// Amount includes the VAT charged by the supplier
+		tax := max(min(expense.TaxAmount, expense.Amount), 0)
+
+		d := newJournalDraft(models.JournalSourceExpense, expense.ID, date, "Expense: "+expense.Title)
+		d.add(accounts[models.LedgerKeyExpenses], expense.Amount.Sub(tax), 0, "", expense.Vendor)
+		d.add(accounts[models.LedgerKeyInputVAT], tax, 0, "", expense.Vendor)
+		d.add(accounts[models.LedgerKeyPayable], -expense.Amount, 0, "", expense.Vendor)
+		drafts = append(drafts, d)
+	}
+	return drafts, nil
+}
+
+// pendingExpensePayments posts paid expenses: Dr payable, Cr the cash account for the method
+func (s *LedgerService) pendingExpensePayments(tenantID string, accounts map[string]string) ([]*journalDraft, error) {
+	var expenses []models.Expense
+	if err := s.db.Scopes(database.TenantFilter(tenantID), unposted("expenses", models.JournalSourceExpensePayment)).
+		Where("status = ? AND amount > 0", "paid").Find(&expenses).Error; err != nil {
+		return nil, err
+	}
+
+	drafts := make([]*journalDraft, 0, len(expenses))
+	for _, expense := range expenses {
+		date := expense.Date
+		if expense.PaidAt != nil {
+			date = *expense.PaidAt
+		}
+		d := newJournalDraft(models.JournalSourceExpensePayment, expense.ID, date, "Expense paid: "+expense.Title)
+		d.add(accounts[models.LedgerKeyPayable], expense.Amount, 0, "", expense.Reference)
+		d.add(accounts[cashAccountKey(expense.PaymentMethod)], -expense.Amount, 0, "", expense.Reference)
+		drafts = append(drafts, d)
+	}
+	return drafts, nil
+}
+
+// pendingPayouts posts client credit returned over M-Pesa: Dr customer credits, Cr M-Pesa.
+// Refund payouts post as refunds and reimbursements as expense payments.
+func (s *LedgerService) pendingPayouts(tenantID string, accounts map[string]string) ([]*journalDraft, error) {
+	var payouts []models.Payout
+	if err := s.db.Scopes(database.TenantFilter(tenantID), unposted("payouts", models.JournalSourcePayout)).
+		Select("id", "tenant_id", "amount", "receipt_number", "completed_at", "updated_at").
+		Where("type = ? AND status = ?", models.PayoutTypeOverpayment, models.PayoutStatusCompleted).
+		Find(&payouts).Error; err != nil {
+		return nil, err
+	}
+
+	drafts := make([]*journalDraft, 0, len(payouts))
+	for _, payout := range payouts {
+		date := payout.UpdatedAt
+		if payout.CompletedAt != nil {
+			date = *payout.CompletedAt
+		}
+		d := newJournalDraft(models.JournalSourcePayout, payout.ID, date, "Client credit paid out")
+		d.add(accounts[models.LedgerKeyCustomerCredit], payout.Amount, 0, "", payout.ReceiptNumber)
+		d.add(accounts[models.LedgerKeyMpesa], -payout.Amount, 0, "", payout.ReceiptNumber)
+		drafts = append(drafts, d)
+	}
+	return drafts, nil
+}
+
+// reversalSource describes documents whose entry is reversed when they are
+// voided, refunded or waived
+type reversalSource struct {
+	table        string
+	sourceType   string // the entry to reverse
+	reversalType string
+	condition    string
+	reversedAt   string // column with when it happened
+	fallbackAt   string // column to use when reversedAt is null
+	memo         string
+}
+
+func (s *LedgerService) pendingReversals(tenantID string, src reversalSource) ([]*journalDraft, error) {
+	var docs []struct {
+		ID         string
+		ReversedAt *time.Time
+		FallbackAt time.Time
+	}
+	if err := s.db.Table(src.table).Scopes(database.TenantFilter(tenantID),
+		posted(src.table, src.sourceType), unposted(src.table, src.reversalType)).
+		Select(fmt.Sprintf("id, %s AS reversed_at, %s AS fallback_at", src.reversedAt, src.fallbackAt)).
+		Where(src.condition).Scan(&docs).Error; err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	originals := make(map[string]*models.JournalEntry, len(docs))
+	for start := 0; start < len(ids); start += 500 {
+		var entries []models.JournalEntry
+		if err := s.db.Scopes(database.TenantFilter(tenantID)).Preload("Lines").
+			Where("source_type = ? AND source_id IN ?", src.sourceType, ids[start:min(start+500, len(ids))]).
+			Find(&entries).Error; err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			originals[entries[i].SourceID] = &entries[i]
+		}
+	}
+
+	drafts := make([]*journalDraft, 0, len(docs))
+	for _, doc := range docs {
+		original := originals[doc.ID]
+		if original == nil {
+			continue
+		}
+		date := doc.FallbackAt
+		if doc.ReversedAt != nil {
+			date = *doc.ReversedAt
+		}
+		drafts = append(drafts, reversalDraft(original, src.reversalType, doc.ID, date, src.memo+": "+original.Memo))
+	}
+	return drafts, nil
+}
+
+// pendingInvoiceVoids reverses cancelled and deleted invoices. An invoice
+// cancelled by crediting it in full is already reversed by its credit notes.
+func (s *LedgerService) pendingInvoiceVoids(tenantID string, _ map[string]string) ([]*journalDraft, error) {
+	return s.pendingReversals(tenantID, reversalSource{
+		table:        "invoices",
+		sourceType:   models.JournalSourceInvoice,
+		reversalType: models.JournalSourceInvoiceVoid,
+		condition:    "(status IN ('cancelled', 'void') OR deleted_at IS NOT NULL) AND COALESCE(kra_status, '') <> 'credited'",
+		reversedAt:   "cancelled_at",
+		fallbackAt:   "updated_at",
+		memo:         "Voided",
+	})
+}
+
+func (s *LedgerService) pendingLateFeeWaivers(tenantID string, _ map[string]string) ([]*journalDraft, error) {
+	return s.pendingReversals(tenantID, reversalSource{
+		table:        "late_fee_invoices",
+		sourceType:   models.JournalSourceLateFee,
+		reversalType: models.JournalSourceLateFeeWaiver,
+		condition:    "waived = true",
+		reversedAt:   "waived_at",
+		fallbackAt:   "applied_at",
+		memo:         "Waived",
+	})
+}
+
+func (s *LedgerService) pendingRefunds(tenantID string, _ map[string]string) ([]*journalDraft, error) {
+	return s.pendingReversals(tenantID, reversalSource{
+		table:        "payments",
+		sourceType:   models.JournalSourcePayment,
+		reversalType: models.JournalSourceRefund,
+		condition:    "status = 'refunded'",
+		reversedAt:   "NULL",
+		fallbackAt:   "updated_at",
+		memo:         "Refunded",
+	})
+}
+
+// ============================================================================
+// MANUAL JOURNALS
+// ============================================================================
+
+// JournalLineInput is one line of a manual journal; set either Debit or Credit
+type JournalLineInput struct {
+	AccountID   string       `json:"account_id"`
+	Debit       models.Money `json:"debit"`
+	Credit      models.Money `json:"credit"`
+	Description string       `json:"description"`
+}
+
+// CreateJournal posts a manual journal entry, e.g. an accountant's adjustment
+func (s *LedgerService) CreateJournal(tenantID, userID string, date time.Time, memo string, input []JournalLineInput) (*models.JournalEntry, error) {
+	if err := s.periods.CheckOpen(tenantID, date); err != nil {
+		return nil, err
+	}
+	if err := s.EnsureChart(tenantID); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(input))
+	for _, l := range input {
+		ids = append(ids, l.AccountID)
+	}
+	var accounts []models.LedgerAccount
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Where("id IN ?", ids).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	active := make(map[string]bool, len(accounts))
+	for _, a := range accounts {
+		active[a.ID] = a.IsActive
+	}
+
+	d := newJournalDraft(models.JournalSourceManual, "", date, memo)
+	for _, l := range input {
+		isActive, ok := active[l.AccountID]
+		if !ok {
+			return nil, ErrLedgerAccountNotFound
+		}
+		if !isActive {
+			return nil, ErrLedgerAccountInactive
+		}
+		d.lines = append(d.lines, models.JournalLine{
+			AccountID:   l.AccountID,
+			Debit:       l.Debit,
+			Credit:      l.Credit,
+			Description: l.Description,
+		})
+	}
+
+	var entry *models.JournalEntry
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		entry, err = s.post(tx, tenantID, userID, d)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ReverseJournal posts the opposite of a manual entry. Automatic entries are
+// reversed by voiding, refunding or waiving their document.
+func (s *LedgerService) ReverseJournal(tenantID, userID, entryID string, date time.Time, memo string) (*models.JournalEntry, error) {
+	original, err := s.GetJournal(tenantID, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if original.SourceType != models.JournalSourceManual {
+		return nil, ErrJournalNotReversible
+	}
+	if err := s.periods.CheckOpen(tenantID, date); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.JournalEntry{}).Scopes(database.TenantFilter(tenantID)).
+		Where("reverses_id = ?", original.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrJournalReversed
+	}
+
+	if memo == "" {
+		memo = "Reversal of " + original.Number
+	}
+	var entry *models.JournalEntry
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		entry, err = s.post(tx, tenantID, userID, reversalDraft(original, models.JournalSourceReversal, original.ID, date, memo))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(strings.ToLower(err.Error()), "unique") {
+			return nil, ErrJournalReversed
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *LedgerService) GetJournal(tenantID, id string) (*models.JournalEntry, error) {
+	var entry models.JournalEntry
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Preload("Lines.Account").First(&entry, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJournalNotFound
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// JournalFilter narrows ListJournals
+type JournalFilter struct {
+	From       *time.Time
+	To         *time.Time // inclusive
+	SourceType string
+	Limit      int
+	Offset     int
+}
+
+func (s *LedgerService) ListJournals(tenantID string, filter JournalFilter) ([]models.JournalEntry, int64, error) {
+	if _, err := s.PostPending(tenantID); err != nil {
+		return nil, 0, err
+	}
+
+	query := s.db.Model(&models.JournalEntry{}).Scopes(database.TenantFilter(tenantID))
+	if filter.From != nil {
+		query = query.Where("date >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("date < ?", filter.To.AddDate(0, 0, 1))
+	}
+	if filter.SourceType != "" {
+		query = query.Where("source_type = ?", filter.SourceType)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	var entries []models.JournalEntry
+	err := query.Preload("Lines").Order("date DESC, created_at DESC").Limit(limit).Offset(filter.Offset).Find(&entries).Error
+	return entries, total, err
+}
+
+// ============================================================================
+// REPORTS
+// ============================================================================
+
+// LedgerAccountBalance is an account's activity and balance. Balance is in the
+// account's normal direction: debits less credits for assets and expenses,
+// credits less debits for the rest.
+type LedgerAccountBalance struct {
+	AccountID string       `json:"account_id"`
+	Code      string       `json:"code"`
+	Name      string       `json:"name"`
+	Type      string       `json:"type"`
+	Debit     models.Money `json:"debit"`
+	Credit    models.Money `json:"credit"`
+	Balance   models.Money `json:"balance"`
+}
+
+// TrialBalance lists every account's net debit or credit
+type TrialBalance struct {
+	AsOf        time.Time              `json:"as_of"`
+	Accounts    []LedgerAccountBalance `json:"accounts"`
+	TotalDebit  models.Money           `json:"total_debit"`
+	TotalCredit models.Money           `json:"total_credit"`
+	Balanced    bool                   `json:"balanced"`
+}
+
+// BalanceSheetSection is one side of the balance sheet
+type BalanceSheetSection struct {
+	Accounts []LedgerAccountBalance `json:"accounts"`
+	Total    models.Money           `json:"total"`
+}
+
+// BalanceSheet shows assets against liabilities and equity. Income and
+// expenses not yet closed to retained earnings show as current earnings.
+type BalanceSheet struct {
+	AsOf                      time.Time           `json:"as_of"`
+	Assets                    BalanceSheetSection `json:"assets"`
+	Liabilities               BalanceSheetSection `json:"liabilities"`
+	Equity                    BalanceSheetSection `json:"equity"`
+	CurrentEarnings           models.Money        `json:"current_earnings"`
+	TotalLiabilitiesAndEquity models.Money        `json:"total_liabilities_and_equity"`
+	Balanced                  bool                `json:"balanced"`
+}
+
+// GeneralLedgerLine is a journal line with its entry and the running balance
+type GeneralLedgerLine struct {
+	EntryID     string       `json:"entry_id"`
+	Number      string       `json:"number"`
+	Date        time.Time    `json:"date"`
+	Memo        string       `json:"memo"`
+	SourceType  string       `json:"source_type"`
+	SourceID    string       `json:"source_id"`
+	Description string       `json:"description"`
+	Debit       models.Money `json:"debit"`
+	Credit      models.Money `json:"credit"`
+	Balance     models.Money `json:"balance"`
+}
+
+// GeneralLedgerAccount is one account's lines for the period
+type GeneralLedgerAccount struct {
+	LedgerAccountBalance
+	OpeningBalance models.Money        `json:"opening_balance"`
+	Lines          []GeneralLedgerLine `json:"lines"`
+	ClosingBalance models.Money        `json:"closing_balance"`
+}
+
+// GeneralLedgerReport lists the postings to each account between From and To
+type GeneralLedgerReport struct {
+	From     time.Time              `json:"from"`
+	To       time.Time              `json:"to"`
+	Accounts []GeneralLedgerAccount `json:"accounts"`
+}
+
+// signedBalance is debits less credits turned to the account's normal direction
+func signedBalance(account *models.LedgerAccount, debit, credit models.Money) models.Money {
+	if account.DebitNormal() {
+		return debit.Sub(credit)
+	}
+	return credit.Sub(debit)
+}
+
+// balances totals each account's lines dated before the given time
+func (s *LedgerService) balances(tenantID string, before time.Time) ([]models.LedgerAccount, map[string][2]models.Money, error) {
+	var accounts []models.LedgerAccount
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Order("code").Find(&accounts).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var rows []struct {
+		AccountID string
+		Debit     int64
+		Credit    int64
+	}
+	if err := s.db.Table("journal_lines jl").
+		Select("jl.account_id, SUM(jl.debit) AS debit, SUM(jl.credit) AS credit").
+		Joins("JOIN journal_entries je ON je.id = jl.entry_id").
+		Where("jl.tenant_id = ? AND je.date < ?", tenantID, before).
+		Group("jl.account_id").Scan(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+	totals := make(map[string][2]models.Money, len(rows))
+	for _, r := range rows {
+		totals[r.AccountID] = [2]models.Money{models.Money(r.Debit), models.Money(r.Credit)}
+	}
+	return accounts, totals, nil
+}
+
+// endOfDay is the start of the day after t, the exclusive bound for "as of t"
+func endOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).AddDate(0, 0, 1)
+}
+
+// TrialBalance lists account balances at the end of asOf
+func (s *LedgerService) TrialBalance(tenantID string, asOf time.Time) (*TrialBalance, error) {
+	if _, err := s.PostPending(tenantID); err != nil {
+		return nil, err
+	}
+	accounts, totals, err := s.balances(tenantID, endOfDay(asOf))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TrialBalance{AsOf: asOf, Accounts: []LedgerAccountBalance{}}
+	for i := range accounts {
+		account := &accounts[i]
+		t, ok := totals[account.ID]
+		if !ok {
+			continue
+		}
+		net := t[0].Sub(t[1])
+		row := LedgerAccountBalance{
+			AccountID: account.ID, Code: account.Code, Name: account.Name, Type: account.Type,
+			Balance: signedBalance(account, t[0], t[1]),
+		}
+		if net > 0 {
+			row.Debit = net
+		} else {
+			row.Credit = -net
+		}
+		report.TotalDebit = report.TotalDebit.Add(row.Debit)
+		report.TotalCredit = report.TotalCredit.Add(row.Credit)
+		report.Accounts = append(report.Accounts, row)
+	}
+	report.Balanced = report.TotalDebit.Equals(report.TotalCredit)
+	return report, nil
+}
+
+// BalanceSheet reports assets, liabilities and equity at the end of asOf
+func (s *LedgerService) BalanceSheet(tenantID string, asOf time.Time) (*BalanceSheet, error) {
+	if _, err := s.PostPending(tenantID); err != nil {
+		return nil, err
+	}
+	accounts, totals, err := s.balances(tenantID, endOfDay(asOf))
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := &BalanceSheet{AsOf: asOf}
+	sheet.Assets.Accounts = []LedgerAccountBalance{}
+	sheet.Liabilities.Accounts = []LedgerAccountBalance{}
+	sheet.Equity.Accounts = []LedgerAccountBalance{}
+	for i := range accounts {
+		account := &accounts[i]
+		t, ok := totals[account.ID]
+		if !ok {
+			continue
+		}
+		row := LedgerAccountBalance{
+			AccountID: account.ID, Code: account.Code, Name: account.Name, Type: account.Type,
+			Debit: t[0], Credit: t[1], Balance: signedBalance(account, t[0], t[1]),
+		}
+		switch account.Type {
+		case models.LedgerAsset:
+			sheet.Assets.Accounts = append(sheet.Assets.Accounts, row)
+			sheet.Assets.Total = sheet.Assets.Total.Add(row.Balance)
+		case models.LedgerLiability:
+			sheet.Liabilities.Accounts = append(sheet.Liabilities.Accounts, row)
+			sheet.Liabilities.Total = sheet.Liabilities.Total.Add(row.Balance)
+		case models.LedgerEquity:
+			sheet.Equity.Accounts = append(sheet.Equity.Accounts, row)
+			sheet.Equity.Total = sheet.Equity.Total.Add(row.Balance)
+		case models.LedgerIncome:
+			sheet.CurrentEarnings = sheet.CurrentEarnings.Add(row.Balance)
+		case models.LedgerExpense:
+			sheet.CurrentEarnings = sheet.CurrentEarnings.Sub(row.Balance)
+		}
+	}
+	sheet.Equity.Total = sheet.Equity.Total.Add(sheet.CurrentEarnings)
+	sheet.TotalLiabilitiesAndEquity = sheet.Liabilities.Total.Add(sheet.Equity.Total)
+	sheet.Balanced = sheet.Assets.Total.Equals(sheet.TotalLiabilitiesAndEquity)
+	return sheet, nil
+}
+
+// GeneralLedger lists the lines posted between from and to (inclusive) with
+// running balances, for one account or, when accountID is empty, every account
+// with a balance or activity
+func (s *LedgerService) GeneralLedger(tenantID, accountID string, from, to time.Time) (*GeneralLedgerReport, error) {
+	if accountID != "" {
+		if _, err := s.GetAccount(tenantID, accountID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.PostPending(tenantID); err != nil {
+		return nil, err
+	}
+	accounts, opening, err := s.balances(tenantID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.Table("journal_lines jl").
+		Select("jl.account_id, jl.entry_id, je.number, je.date, je.memo, je.source_type, je.source_id, jl.description, jl.debit, jl.credit").
+		Joins("JOIN journal_entries je ON je.id = jl.entry_id").
+		Where("jl.tenant_id = ? AND je.date >= ? AND je.date < ?", tenantID, from, endOfDay(to))
+	if accountID != "" {
+		query = query.Where("jl.account_id = ?", accountID)
+	}
+	var rows []struct {
+		AccountID   string
+		EntryID     string
+		Number      string
+		Date        time.Time
+		Memo        string
+		SourceType  string
+		SourceID    string
+		Description string
+		Debit       int64
+		Credit      int64
+	}
+	if err := query.Order("je.date, je.created_at, jl.id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	linesByAccount := make(map[string][]GeneralLedgerLine)
+	for _, r := range rows {
+		linesByAccount[r.AccountID] = append(linesByAccount[r.AccountID], GeneralLedgerLine{
+			EntryID: r.EntryID, Number: r.Number, Date: r.Date, Memo: r.Memo,
+			SourceType: r.SourceType, SourceID: r.SourceID, Description: r.Description,
+			Debit: models.Money(r.Debit), Credit: models.Money(r.Credit),
+		})
+	}
+
+	report := &GeneralLedgerReport{From: from, To: to, Accounts: []GeneralLedgerAccount{}}
+	for i := range accounts {
+		account := &accounts[i]
+		if accountID != "" && account.ID != accountID {
+			continue
+		}
+		o := opening[account.ID]
+		lines := linesByAccount[account.ID]
+		if accountID == "" && len(lines) == 0 && o[0] == o[1] {
+			continue
+		}
+
+		gl := GeneralLedgerAccount{
+			LedgerAccountBalance: LedgerAccountBalance{AccountID: account.ID, Code: account.Code, Name: account.Name, Type: account.Type},
+			OpeningBalance:       signedBalance(account, o[0], o[1]),
+			Lines:                []GeneralLedgerLine{},
+		}
+		balance := gl.OpeningBalance
+		for _, l := range lines {
+			balance = balance.Add(signedBalance(account, l.Debit, l.Credit))
+			l.Balance = balance
+			gl.Debit = gl.Debit.Add(l.Debit)
+			gl.Credit = gl.Credit.Add(l.Credit)
+			gl.Lines = append(gl.Lines, l)
+		}
+		gl.ClosingBalance = balance
+		gl.Balance = balance
+		report.Accounts = append(report.Accounts, gl)
+	}
+	return report, nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// FX REVALUATION - unrealized gain/loss on open foreign-currency invoices
+// ============================================================================
+//
+// Invoices are booked in KES at the rate on the day they were raised. At month
+// end the balance still owed is restated at the closing rate and the difference
+// is posted as an unrealized gain or loss. The entry reverses on the first of
+// the next month, so payments keep clearing the receivable at the booked rate
+// and the realized gain or loss is measured against it.
+
+var (
+	ErrRevaluationExists   = errors.New("this month has already been revalued")
+	ErrRevaluationNotFound = errors.New("revaluation not found")
+	ErrRevaluationNoRates  = errors.New("exchange rates are not available")
+)
+
+// RevalueForeignBalances runs the month-end revaluation for a period (YYYY-MM)
+func (s *LedgerService) RevalueForeignBalances(tenantID, userID, period string) (*models.FXRevaluation, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	if s.rates == nil {
+		return nil, ErrRevaluationNoRates
+	}
+	start, err := parsePeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	next := start.AddDate(0, 1, 0)
+	if time.Now().Before(next) {
+		return nil, ErrPeriodNotEnded
+	}
+	asOf := next.AddDate(0, 0, -1)
+	if err := s.periods.CheckOpen(tenantID, asOf, next); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.FXRevaluation{}).Scopes(database.TenantFilter(tenantID)).
+		Where("period = ?", period).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrRevaluationExists
+	}
+
+	// The receivable being restated has to be complete
+	if _, err := s.PostPending(tenantID); err != nil {
+		return nil, err
+	}
+	accounts, err := s.systemAccounts(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := s.foreignBalances(tenantID, next)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &models.FXRevaluation{ID: uuid.New().String(), TenantID: tenantID, Period: period, AsOf: asOf, RunBy: userID}
+	d := newJournalDraft(models.JournalSourceFXRevaluation, run.ID, asOf, "FX revaluation "+period)
+	for i := range lines {
+		l := &lines[i]
+		if l.ClosingRate, err = s.rates.RateOn(tenantID, l.Currency, asOf); err != nil {
+			return nil, err
+		}
+		l.ID = uuid.New().String()
+		l.TenantID = tenantID
+		l.RevaluationID = run.ID
+		l.ClosingValue = l.Balance.Mul(l.ClosingRate)
+		l.Gain = l.ClosingValue.Sub(l.BookValue)
+		run.Gain = run.Gain.Add(l.Gain)
+		d.add(accounts[models.LedgerKeyReceivable], l.Gain, 0, l.Currency, l.InvoiceNumber)
+	}
+	d.add(accounts[models.LedgerKeyUnrealizedFX], -run.Gain, 0, "", "")
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if len(d.lines) > 0 {
+			entry, err := s.post(tx, tenantID, userID, d)
+			if err != nil {
+				return err
+			}
+			reversal, err := s.post(tx, tenantID, userID,
+				reversalDraft(entry, models.JournalSourceFXReversal, run.ID, next, "Reversal of FX revaluation "+period))
+			if err != nil {
+				return err
+			}
+			run.EntryID, run.ReversalID = entry.ID, reversal.ID
+		}
+		if err := tx.Omit("Lines").Create(run).Error; err != nil {
+			return err
+		}
+		if len(lines) > 0 {
+			return tx.Create(&lines).Error
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(strings.ToLower(err.Error()), "unique") {
+			return nil, ErrRevaluationExists
+		}
+		return nil, err
+	}
+	run.Lines = lines
+	return run, nil
+}
+
+// foreignBalances returns what was still owed on each foreign-currency invoice
+// (and credit note) raised before a date, valued at the invoice's own rate
+func (s *LedgerService) foreignBalances(tenantID string, before time.Time) ([]models.FXRevaluationLine, error) {
+	var invoices []models.Invoice
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Select("id", "invoice_number", "currency", "exchange_rate", "total").
+		Where("currency NOT IN ('', 'KES') AND total <> 0 AND deleted_at IS NULL").
+		Where("status NOT IN ?", []models.InvoiceStatus{models.InvoiceStatusDraft, models.InvoiceStatusCancelled, models.InvoiceStatusVoid}).
+		Where("COALESCE(sent_at, created_at) < ?", before).
+		Order("invoice_number").Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return nil, nil
+	}
+
+	// Payments in the invoice's currency received by then, with any tax withheld
+	var paid []struct {
+		InvoiceID string
+		Total     int64
+	}
+	if err := s.db.Model(&models.Payment{}).
+		Select("payments.invoice_id, SUM(payments.amount + payments.withheld) AS total").
+		Joins("JOIN invoices ON invoices.id = payments.invoice_id AND invoices.currency = payments.currency").
+		Where("payments.tenant_id = ? AND payments.status = ? AND payments.completed_at < ?", tenantID, models.PaymentStatusCompleted, before).
+		Group("payments.invoice_id").Scan(&paid).Error; err != nil {
+		return nil, err
+	}
+	paidByInvoice := make(map[string]models.Money, len(paid))
+	for _, p := range paid {
+		paidByInvoice[p.InvoiceID] = models.Money(p.Total)
+	}
+
+	lines := make([]models.FXRevaluationLine, 0, len(invoices))
+	for _, invoice := range invoices {
+		balance := invoice.Total.Sub(paidByInvoice[invoice.ID])
+		// Nothing owed, or overpaid (the excess is a customer credit)
+		if balance == 0 || (balance > 0) != (invoice.Total > 0) {
+			continue
+		}
+		rate := ledgerRate(invoice.Currency, invoice.ExchangeRate)
+		lines = append(lines, models.FXRevaluationLine{
+			InvoiceID:     invoice.ID,
+			InvoiceNumber: invoice.InvoiceNumber,
+			Currency:      invoice.Currency,
+			Balance:       balance,
+			BookRate:      rate,
+			BookValue:     balance.Mul(rate),
+		})
+	}
+	return lines, nil
+}
+
+// ListRevaluations lists a tenant's revaluation runs, newest first
+func (s *LedgerService) ListRevaluations(tenantID string) ([]models.FXRevaluation, error) {
+	var runs []models.FXRevaluation
+	err := s.db.Scopes(database.TenantFilter(tenantID)).Order("period DESC").Find(&runs).Error
+	return runs, err
+}
+
+// GetRevaluation returns a revaluation run with its invoice lines
+func (s *LedgerService) GetRevaluation(tenantID, id string) (*models.FXRevaluation, error) {
+	var run models.FXRevaluation
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Preload("Lines", func(db *gorm.DB) *gorm.DB {
+		return db.Order("invoice_number")
+	}).First(&run, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRevaluationNotFound
+		}
+		return nil, err
+	}
+	return &run, nil
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -18,6 +20,7 @@ import (
 	"invoicefast/internal/logger"
 	"invoicefast/internal/models"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -32,8 +35,53 @@ var (
 	ErrMpesaNotConfigured = fmt.Errorf("M-Pesa not configured - set MPESA_CONSUMER_KEY, MPESA_CONSUMER_SECRET, and MPESA_BUSINESS_SHORT_CODE")
 	ErrMpesaTokenFailed   = fmt.Errorf("failed to obtain M-Pesa access token")
 	ErrSTKPushFailed      = fmt.Errorf("STK push request failed")
+
+	ErrMpesaCallbackTenant    = errors.New("M-Pesa callback does not belong to this tenant")
+	ErrMpesaCallbackAmbiguous = errors.New("M-Pesa callback matches payments of more than one tenant")
 )
 
+// MpesaCredentialStore looks up a tenant's own Daraja credentials
+type MpesaCredentialStore interface {
+	GetMpesaCredentials(tenantID string) (*MpesaSettings, error)
+}
+
+// mpesaCredentials are the Daraja app and shortcode used for one request
+type mpesaCredentials struct {
+	source         string // models.MpesaCredentialsPlatform or models.MpesaCredentialsTenant
+	consumerKey    string
+	consumerSecret string
+	shortCode      string
+	passKey        string
+	environment    string
+	callbackURL    string
+	apiURL         string // MPESA_API_URL override of the Daraja host
+
+	// API initiator, only needed for Transaction Status queries and B2C payouts
+	initiatorName      string
+	securityCredential string
+	b2cShortCode       string
+}
+
+// tokenCacheKey keeps tenant tokens apart from the platform token and from
+// tokens issued for a tenant's previous consumer key
+func (c *mpesaCredentials) tokenCacheKey() string {
+	if c.source == models.MpesaCredentialsPlatform {
+		return "mpesa:access_token"
+	}
+	sum := sha256.Sum256([]byte(c.environment + ":" + c.consumerKey))
+	return fmt.Sprintf("mpesa:access_token:%s:%x", c.shortCode, sum[:8])
+}
+
+func (c *mpesaCredentials) url(path string) string {
+	if c.apiURL != "" {
+		return strings.TrimRight(c.apiURL, "/") + path
+	}
+	if c.environment == "sandbox" {
+		return "https://sandbox.safaricom.co.ke" + path
+	}
+	return "https://api.safaricom.co.ke" + path
+}
+
 // MPesaService handles M-Pesa Daraja API integration
 type MPesaService struct {
 	cfg             *config.Config
@@ -41,6 +89,9 @@ type MPesaService struct {
 	client          *http.Client
 	cache           MPesaCache
 	webhookVerifier *WebhookVerifier // SECURITY: Added for callback verification
+	workflows       *WorkflowEngine
+	credentials     MpesaCredentialStore
+	periods         *PeriodService
 }
 
 type MpesaAccessToken struct {
@@ -100,6 +151,23 @@ func NewMPesaService(cfg *config.Config, db *database.DB, cache MPesaCache) *MPe
 	}
 }
 
+// SetWorkflowEngine wires the workflow event bus (optional)
+func (s *MPesaService) SetWorkflowEngine(engine *WorkflowEngine) {
+	s.workflows = engine
+}
+
+// SetPeriodService rejects completing STK payments into closed accounting periods (optional)
+func (s *MPesaService) SetPeriodService(periods *PeriodService) {
+	s.periods = periods
+}
+
+// SetCredentialStore lets tenants with their own Daraja app collect into their
+// own shortcode. Tenants without complete credentials use the platform account.
+func (s *MPesaService) SetCredentialStore(store MpesaCredentialStore) {
+	s.credentials = store
+}
+
+// IsConfigured reports whether the platform M-Pesa account is configured
 func (s *MPesaService) IsConfigured() bool {
 	return s.cfg.MPesa.Enabled &&
 		s.cfg.MPesa.ConsumerKey != "" &&
@@ -107,25 +175,90 @@ func (s *MPesaService) IsConfigured() bool {
 		s.cfg.MPesa.BusinessShortCode != ""
 }
 
+// IsConfiguredFor reports whether STK pushes can be sent for a tenant
+func (s *MPesaService) IsConfiguredFor(tenantID string) bool {
+	_, err := s.credentialsFor(tenantID)
+	return err == nil
+}
+
+// credentialsFor picks the tenant's own Daraja credentials, falling back to the platform account
+func (s *MPesaService) credentialsFor(tenantID string) (*mpesaCredentials, error) {
+	if s.credentials != nil && tenantID != "" {
+		settings, err := s.credentials.GetMpesaCredentials(tenantID)
+		if err != nil {
+			// Don't fall back here: that would send the tenant's customers to the platform paybill
+			return nil, fmt.Errorf("failed to load M-Pesa settings: %w", err)
+		}
+		if settings.HasOwnCredentials() {
+			env := settings.Environment
+			if env == "" {
+				env = s.cfg.MPesa.Environment
+			}
+			return &mpesaCredentials{
+				source:         models.MpesaCredentialsTenant,
+				consumerKey:    settings.ConsumerKey,
+				consumerSecret: settings.ConsumerSecret,
+				shortCode:      settings.Shortcode,
+				passKey:        settings.Passkey,
+				environment:    env,
+				callbackURL:    s.callbackURL(tenantID),
+				apiURL:         s.cfg.MPesa.APIURL,
+
+				initiatorName:      settings.InitiatorName,
+				securityCredential: settings.InitiatorCredential,
+				b2cShortCode:       settings.B2CShortcode,
+			}, nil
+		}
+	}
+
+	if !s.IsConfigured() {
+		return nil, ErrMpesaNotConfigured
+	}
+	return &mpesaCredentials{
+		source:         models.MpesaCredentialsPlatform,
+		consumerKey:    s.cfg.MPesa.ConsumerKey,
+		consumerSecret: s.cfg.MPesa.ConsumerSecret,
+		shortCode:      s.cfg.MPesa.BusinessShortCode,
+		passKey:        s.cfg.MPesa.PassKey,
+		environment:    s.cfg.MPesa.Environment,
+		callbackURL:    s.callbackURL(tenantID),
+		apiURL:         s.cfg.MPesa.APIURL,
+
+		initiatorName:      s.cfg.MPesa.InitiatorName,
+		securityCredential: s.cfg.MPesa.InitiatorCredential,
+	}, nil
+}
+
+// callbackURL appends the tenant to MPESA_CALLBACK_URL (/api/v1/webhook/mpesa/:tenantID)
+// so every callback arrives on a path that names its tenant
+func (s *MPesaService) callbackURL(tenantID string) string {
+	base := strings.TrimRight(s.cfg.MPesa.CallbackURL, "/")
+	if base == "" || tenantID == "" {
+		return base
+	}
+	return base + "/" + tenantID
+}
+
 func (s *MPesaService) InitiateSTKPush(ctx context.Context, tenantID, invoiceID, phoneNumber, amount, invoiceNumber string) (*STKPushResponse, error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
-	if !s.IsConfigured() {
-		return nil, ErrMpesaNotConfigured
+	creds, err := s.credentialsFor(tenantID)
+	if err != nil {
+		return nil, err
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	token, err := s.getAccessToken(ctx)
+	token, err := s.getAccessToken(ctx, creds)
 	if err != nil {
-		logger.Get().Error(ctx, "Failed to get access token", "error", err)
+		logger.Get().Error(ctx, "Failed to get access token", "tenant_id", tenantID, "credentials", creds.source, "error", err)
 		return nil, fmt.Errorf("%w: %v", ErrMpesaTokenFailed, err)
 	}
 
 	timestamp := time.Now().Format("20060102150405")
-	password, err := s.generatePassword(timestamp)
+	password, err := s.generatePassword(creds, timestamp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate password: %w", err)
 	}
@@ -133,15 +266,15 @@ func (s *MPesaService) InitiateSTKPush(ctx context.Context, tenantID, invoiceID,
 	phone := normalizeMpesaPhone(phoneNumber)
 
 	req := STKPushRequest{
-		BusinessShortCode: s.cfg.MPesa.BusinessShortCode,
+		BusinessShortCode: creds.shortCode,
 		Password:          password,
 		Timestamp:         timestamp,
 		TransactionType:   "CustomerPayBillOnline",
 		Amount:            amount,
 		PartyA:            phone,
-		PartyB:            s.cfg.MPesa.BusinessShortCode,
+		PartyB:            creds.shortCode,
 		PhoneNumber:       phone,
-		CallBackURL:       s.cfg.MPesa.CallbackURL,
+		CallBackURL:       creds.callbackURL,
 		AccountReference:  invoiceNumber,
 		TransactionDesc:   fmt.Sprintf("InvoiceFast Invoice %s", invoiceNumber),
 	}
@@ -151,12 +284,7 @@ func (s *MPesaService) InitiateSTKPush(ctx context.Context, tenantID, invoiceID,
 		return nil, fmt.Errorf("failed to marshal STK request: %w", err)
 	}
 
-	apiURL := "https://api.safaricom.co.ke/mpesa/stkpush/v1/processrequest"
-	if s.cfg.MPesa.Environment == "sandbox" {
-		apiURL = "https://sandbox.safaricom.co.ke/mpesa/stkpush/v1/processrequest"
-	}
-
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(payload))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", creds.url("/mpesa/stkpush/v1/processrequest"), bytes.NewBuffer(payload))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -203,7 +331,24 @@ func (s *MPesaService) InitiateSTKPush(ctx context.Context, tenantID, invoiceID,
 		return nil, fmt.Errorf("%s: %s", ErrSTKPushFailed, stkResp.ResponseDescription)
 	}
 
-	logger.Get().Info(ctx, "STK Push initiated", "checkout_request_id", stkResp.CheckoutRequestID, "invoice", invoiceNumber)
+	logger.Get().Info(ctx, "STK Push initiated", "checkout_request_id", stkResp.CheckoutRequestID,
+		"invoice", invoiceNumber, "tenant_id", tenantID, "credentials", creds.source)
+
+	// The customer already has the prompt, so a failure here is logged rather than returned
+	if err := s.db.Create(&models.MpesaSTKRequest{
+		ID:                uuid.New().String(),
+		TenantID:          tenantID,
+		InvoiceID:         invoiceID,
+		ShortCode:         creds.shortCode,
+		CredentialSource:  creds.source,
+		Environment:       creds.environment,
+		MerchantRequestID: stkResp.MerchantRequestID,
+		CheckoutRequestID: stkResp.CheckoutRequestID,
+		AccountReference:  invoiceNumber,
+		Amount:            amount,
+	}).Error; err != nil {
+		logger.Get().Warn(ctx, "Failed to record STK push", "checkout_request_id", stkResp.CheckoutRequestID, "error", err)
+	}
 
 	return &stkResp, nil
 }
@@ -212,7 +357,8 @@ func (s *MPesaService) InitiateSTKPush(ctx context.Context, tenantID, invoiceID,
 // SECURITY: This should ONLY be called AFTER webhook verification middleware
 // The middleware handles signature verification, IP allowlisting, and replay protection
 // This function handles the idempotent payment processing logic
-func (s *MPesaService) ProcessSTKCallback(ctx context.Context, callback STKCallback) error {
+// tenantID comes from the per-tenant callback path; it is empty on the legacy shared path.
+func (s *MPesaService) ProcessSTKCallback(ctx context.Context, tenantID string, callback STKCallback) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
@@ -227,9 +373,15 @@ func (s *MPesaService) ProcessSTKCallback(ctx context.Context, callback STKCallb
 	merchantReqID := stkCallback.MerchantRequestID
 	checkoutReqID := stkCallback.CheckoutRequestID
 
+	tenantID, err := s.resolveCallbackTenant(tenantID, merchantReqID, checkoutReqID)
+	if err != nil {
+		logger.Get().Error(ctx, "Rejected M-Pesa callback", "checkout_request_id", checkoutReqID, "error", err)
+		return err
+	}
+
 	if stkCallback.ResultCode != 0 {
 		logger.Get().Error(ctx, "Payment failed", "result_code", stkCallback.ResultCode, "result_desc", stkCallback.ResultDesc)
-		return s.markPaymentFailed(ctx, merchantReqID, checkoutReqID, stkCallback.ResultDesc)
+		return s.markPaymentFailed(ctx, tenantID, merchantReqID, checkoutReqID, stkCallback.ResultDesc, models.MpesaResolvedByCallback)
 	}
 
 	var mpesaReceipt, phone, amount string
@@ -254,10 +406,46 @@ func (s *MPesaService) ProcessSTKCallback(ctx context.Context, callback STKCallb
 	}
 
 	logger.Get().Info(ctx, "Payment received", "receipt", mpesaReceipt, "phone", phone, "amount", amount)
-	return s.markPaymentCompleted(ctx, merchantReqID, checkoutReqID, mpesaReceipt, phone, amount)
+	return s.markPaymentCompleted(ctx, tenantID, merchantReqID, checkoutReqID, mpesaReceipt, phone, amount, models.MpesaResolvedByCallback)
 }
 
-func (s *MPesaService) markPaymentCompleted(ctx context.Context, merchantReqID, checkoutReqID, receipt, phone, amount string) error {
+// resolveCallbackTenant works out which tenant a callback belongs to. The STK
+// push record is authoritative; pushes without one fall back to their payments,
+// which must all belong to a single tenant.
+func (s *MPesaService) resolveCallbackTenant(pathTenantID, merchantReqID, checkoutReqID string) (string, error) {
+	var stk models.MpesaSTKRequest
+	err := s.db.Where("checkout_request_id = ?", checkoutReqID).First(&stk).Error
+	if err == nil {
+		if pathTenantID != "" && stk.TenantID != pathTenantID {
+			return "", ErrMpesaCallbackTenant
+		}
+		return stk.TenantID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", fmt.Errorf("failed to look up STK push: %w", err)
+	}
+
+	var tenantIDs []string
+	if err := s.db.Model(&models.Payment{}).Where("reference = ? OR id = ?", checkoutReqID, merchantReqID).
+		Distinct().Pluck("tenant_id", &tenantIDs).Error; err != nil {
+		return "", fmt.Errorf("payment not found: %w", err)
+	}
+	switch {
+	case len(tenantIDs) == 0:
+		return "", fmt.Errorf("payment not found: %w", gorm.ErrRecordNotFound)
+	case len(tenantIDs) > 1:
+		return "", ErrMpesaCallbackAmbiguous
+	case pathTenantID != "" && tenantIDs[0] != pathTenantID:
+		return "", ErrMpesaCallbackTenant
+	}
+	return tenantIDs[0], nil
+}
+
+// markPaymentCompleted settles the pending payments of an STK push. It is called
+// by the callback and by the reconciler, so whichever arrives second is a no-op.
+// An empty receipt keeps the current reference; an empty amount credits the
+// amount that was requested.
+func (s *MPesaService) markPaymentCompleted(ctx context.Context, tenantID, merchantReqID, checkoutReqID, receipt, phone, amount, source string) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
@@ -279,56 +467,123 @@ func (s *MPesaService) markPaymentCompleted(ctx context.Context, merchantReqID,
 	amountFloat := 0.0
 	fmt.Sscanf(amount, "%f", &amountFloat)
 
-	var payment models.Payment
+	// A portal STK push can settle several invoices; each has its own pending
+	// payment recorded against the same CheckoutRequestID.
+	var payments []models.Payment
+	var invoices []models.Invoice
 
 	err := s.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where("reference = ? OR id = ?", checkoutReqID, merchantReqID).First(&payment).Error; err != nil {
+		var matched []models.Payment
+		if err := tx.Scopes(database.TenantFilter(tenantID)).Where("reference = ? OR id = ?", checkoutReqID, merchantReqID).
+			Order("created_at ASC").Find(&matched).Error; err != nil {
 			return fmt.Errorf("payment not found: %w", err)
 		}
-
-		// Double-check: if payment already marked as completed in DB, skip
-		if payment.Status == models.PaymentStatusCompleted {
-			logger.Get().Info(ctx, "Payment already completed", "payment_id", payment.ID)
-			return nil
+		if len(matched) == 0 {
+			return fmt.Errorf("payment not found: %w", gorm.ErrRecordNotFound)
 		}
 
-		payment.Status = models.PaymentStatusCompleted
-		payment.Reference = receipt
-		now := time.Now()
-		payment.CompletedAt = &now
-
-		if err := tx.Save(&payment).Error; err != nil {
-			return fmt.Errorf("failed to update payment: %w", err)
+		// A single payment is credited with what the customer actually paid; a batch
+		// is credited payment by payment until the callback amount runs out, the last
+		// taking anything paid over the balances
+		remaining := models.ToCents(amountFloat)
+		if remaining <= 0 {
+			for _, p := range matched {
+				remaining = remaining.Add(p.Amount)
+			}
 		}
-
-		// SECURITY: Use TenantFilter to ensure invoice belongs to same tenant as payment
-		var invoice models.Invoice
-		if err := tx.Scopes(database.TenantFilter(payment.TenantID)).First(&invoice, "id = ?", payment.InvoiceID).Error; err != nil {
-			return fmt.Errorf("invoice not found: %w", err)
+		open := 0
+		for _, p := range matched {
+			if p.Status != models.PaymentStatusCompleted {
+				open++
+			}
 		}
 
-		amountCents := models.ToCents(amountFloat)
-		invoice.PaidAmount = invoice.PaidAmount.Add(amountCents)
-		if invoice.PaidAmount.GreaterThan(invoice.Total) || invoice.PaidAmount.Equals(invoice.Total) {
-			invoice.PaidAmount = invoice.Total
-			invoice.Status = models.InvoiceStatusPaid
+		for _, payment := range matched {
+			// Double-check: if payment already marked as completed in DB, skip
+			if payment.Status == models.PaymentStatusCompleted {
+				logger.Get().Info(ctx, "Payment already completed", "payment_id", payment.ID)
+				continue
+			}
+			open--
+
+			amountCents := remaining
+			if len(matched) > 1 {
+				if open > 0 && payment.Amount.LessThan(remaining) {
+					amountCents = payment.Amount
+				}
+				if amountCents <= 0 {
+					logger.Get().Warn(ctx, "Callback amount does not cover batch payment", "payment_id", payment.ID, "amount", amount)
+					continue
+				}
+				remaining = remaining.Sub(amountCents)
+				payment.Amount = amountCents
+			}
+
+			payment.Status = models.PaymentStatusCompleted
+			if receipt != "" {
+				payment.Reference = receipt
+			}
+			payment.ResolvedBy = source
 			now := time.Now()
-			invoice.PaidAt = &now
-		} else {
-			invoice.Status = models.InvoiceStatusPartiallyPaid
-		}
+			payment.CompletedAt = &now
+			if err := s.periods.CheckOpenTx(tx, payment.TenantID, now); err != nil {
+				return err
+			}
 
-		if err := tx.Save(&invoice).Error; err != nil {
-			return fmt.Errorf("failed to update invoice: %w", err)
-		}
+			// Update only the settled columns; a full save would write back the decrypted phone number.
+			// The status condition makes a concurrent callback and reconciler credit the invoice once.
+			res := tx.Model(&models.Payment{}).Where("id = ? AND status <> ?", payment.ID, models.PaymentStatusCompleted).Updates(map[string]interface{}{
+				"status":       payment.Status,
+				"reference":    payment.Reference,
+				"amount":       payment.Amount,
+				"completed_at": payment.CompletedAt,
+				"resolved_by":  payment.ResolvedBy,
+			})
+			if res.Error != nil {
+				return fmt.Errorf("failed to update payment: %w", res.Error)
+			}
+			if res.RowsAffected == 0 {
+				logger.Get().Info(ctx, "Payment already completed", "payment_id", payment.ID)
+				continue
+			}
+			if err := numberReceipt(tx, &payment); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Payment{}).Where("id = ?", payment.ID).Update("receipt_number", payment.ReceiptNumber).Error; err != nil {
+				return fmt.Errorf("failed to number receipt: %w", err)
+			}
 
-		tx.Model(&models.Client{}).Where("id = ?", invoice.ClientID).
-			Update("total_paid", gorm.Expr("total_paid + ?", amountCents))
+			// SECURITY: the invoice is loaded under the payment's tenant. It is
+			// locked and updated with a version check like any allocation.
+			invoice, applied, err := creditInvoice(tx, payment.TenantID, payment.InvoiceID, amountCents, now)
+			if err != nil {
+				return fmt.Errorf("failed to credit invoice: %w", err)
+			}
+			// Pushes are in whole shillings, so a batch rounded up or a customer who
+			// paid over the balance leaves money the client is owed
+			if surplus := amountCents.Sub(applied); surplus > 0 {
+				if err := addClientCredit(tx, payment.TenantID, invoice.ClientID, payment.ID, surplus, ""); err != nil {
+					return err
+				}
+			}
+
+			tx.Model(&models.Client{}).Where("id = ?", invoice.ClientID).
+				Update("total_paid", gorm.Expr("total_paid + ?", amountCents))
 
-		logger.Get().Info(ctx, "Payment completed", "receipt", receipt, "invoice", invoice.InvoiceNumber, "amount", amount)
+			logger.Get().Info(ctx, "Payment completed", "receipt", receipt, "invoice", invoice.InvoiceNumber, "amount", amountCents.Float64())
+			payments = append(payments, payment)
+			invoices = append(invoices, *invoice)
+		}
 		return nil
 	})
 
+	if err == nil {
+		s.markSTKResolved(ctx, checkoutReqID, source)
+		for i := range payments {
+			publishPaymentCompleted(s.workflows, &payments[i], &invoices[i])
+		}
+	}
+
 	// Update idempotency key to completed
 	if s.cache != nil && err == nil {
 		if err := s.cache.SetString(ctx, idempotencyKey, "completed", 24*time.Hour); err != nil {
@@ -339,45 +594,76 @@ func (s *MPesaService) markPaymentCompleted(ctx context.Context, merchantReqID,
 	return err
 }
 
-func (s *MPesaService) markPaymentFailed(ctx context.Context, merchantReqID, checkoutReqID, reason string) error {
+func (s *MPesaService) markPaymentFailed(ctx context.Context, tenantID, merchantReqID, checkoutReqID, reason, source string) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
-	var payment models.Payment
-	err := s.db.Where("reference = ? OR id = ?", checkoutReqID, merchantReqID).First(&payment).Error
+	var payments []models.Payment
+	err := s.db.Scopes(database.TenantFilter(tenantID)).Where("reference = ? OR id = ?", checkoutReqID, merchantReqID).Find(&payments).Error
 	if err != nil {
 		return fmt.Errorf("payment not found: %w", err)
 	}
+	if len(payments) == 0 {
+		return fmt.Errorf("payment not found: %w", gorm.ErrRecordNotFound)
+	}
 
-	payment.Status = models.PaymentStatusFailed
-	payment.FailureReason = reason
+	for i := range payments {
+		payment := &payments[i]
+		if payment.Status != models.PaymentStatusPending {
+			continue
+		}
+		payment.Status = models.PaymentStatusFailed
+		payment.FailureReason = reason
+		payment.ResolvedBy = source
+
+		res := s.db.Model(&models.Payment{}).Where("id = ? AND status = ?", payment.ID, models.PaymentStatusPending).Updates(map[string]interface{}{
+			"status":         payment.Status,
+			"failure_reason": payment.FailureReason,
+			"resolved_by":    payment.ResolvedBy,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
 
-	return s.db.Save(&payment).Error
+		publishPaymentFailed(s.workflows, payment)
+	}
+	s.markSTKResolved(ctx, checkoutReqID, source)
+	return nil
 }
 
-func (s *MPesaService) getAccessToken(ctx context.Context) (string, error) {
+// markSTKResolved records on the STK push what resolved it. Pushes sent before
+// pushes were recorded have nothing to update.
+func (s *MPesaService) markSTKResolved(ctx context.Context, checkoutReqID, source string) {
+	now := time.Now()
+	if err := s.db.Model(&models.MpesaSTKRequest{}).
+		Where("checkout_request_id = ? AND (resolved_by = '' OR resolved_by IS NULL)", checkoutReqID).
+		Updates(map[string]interface{}{"resolved_by": source, "resolved_at": &now}).Error; err != nil {
+		logger.Get().Warn(ctx, "Failed to record STK push resolution", "checkout_request_id", checkoutReqID, "error", err)
+	}
+}
+
+func (s *MPesaService) getAccessToken(ctx context.Context, creds *mpesaCredentials) (string, error) {
 	if ctx.Err() != nil {
 		return "", ctx.Err()
 	}
+	cacheKey := creds.tokenCacheKey()
 	if s.cache != nil {
-		token, err := s.cache.GetString(ctx, "mpesa:access_token")
+		token, err := s.cache.GetString(ctx, cacheKey)
 		if err == nil && token != "" {
 			logger.Get().Info(ctx, "Using cached access token")
 			return token, nil
 		}
 	}
 
-	apiURL := "https://api.safaricom.co.ke/oauth/v1/generate?grant_type=client_credentials"
-	if s.cfg.MPesa.Environment == "sandbox" {
-		apiURL = "https://sandbox.safaricom.co.ke/oauth/v1/generate?grant_type=client_credentials"
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", creds.url("/oauth/v1/generate?grant_type=client_credentials"), nil)
 	if err != nil {
 		return "", err
 	}
 
-	credentials := base64.StdEncoding.EncodeToString([]byte(s.cfg.MPesa.ConsumerKey + ":" + s.cfg.MPesa.ConsumerSecret))
+	credentials := base64.StdEncoding.EncodeToString([]byte(creds.consumerKey + ":" + creds.consumerSecret))
 	req.Header.Set("Authorization", "Basic "+credentials)
 
 	// Execute token request with circuit breaker protection
@@ -420,21 +706,85 @@ func (s *MPesaService) getAccessToken(ctx context.Context) (string, error) {
 	if s.cache != nil {
 		expiresIn := 3600
 		fmt.Sscanf(tokenResp.ExpiresIn, "%d", &expiresIn)
-		_ = s.cache.SetString(ctx, "mpesa:access_token", tokenResp.AccessToken, time.Duration(expiresIn)*time.Second)
+		_ = s.cache.SetString(ctx, cacheKey, tokenResp.AccessToken, time.Duration(expiresIn)*time.Second)
 	}
 
 	logger.Get().Info(ctx, "Obtained new access token")
 	return tokenResp.AccessToken, nil
 }
 
-func (s *MPesaService) generatePassword(timestamp string) (string, error) {
-	passkey := s.cfg.MPesa.PassKey
+// DarajaError is an error reply from a Daraja API
+type DarajaError struct {
+	Path       string `json:"-"`
+	StatusCode int    `json:"-"`
+	Code       string `json:"errorCode"`
+	Message    string `json:"errorMessage"`
+}
+
+func (e *DarajaError) Error() string {
+	return fmt.Sprintf("daraja %s failed: HTTP %d - %s %s", e.Path, e.StatusCode, e.Code, e.Message)
+}
+
+// postDaraja sends an authenticated JSON request to a Daraja API and decodes the reply into out
+func (s *MPesaService) postDaraja(ctx context.Context, creds *mpesaCredentials, path string, payload, out interface{}) error {
+	token, err := s.getAccessToken(ctx, creds)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMpesaTokenFailed, err)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	type darajaResult struct {
+		body       []byte
+		statusCode int
+	}
+	result, err := circuitbreaker.MpesaCircuit().ExecuteWithResult(ctx, func(ctx context.Context) (interface{}, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", creds.url(path), bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return &darajaResult{body: respBody, statusCode: resp.StatusCode}, nil
+	})
+	if err != nil {
+		return err
+	}
+	res := result.(*darajaResult)
+	if res.statusCode >= 400 {
+		darajaErr := &DarajaError{Path: path, StatusCode: res.statusCode}
+		if json.Unmarshal(res.body, darajaErr) != nil || darajaErr.Message == "" {
+			darajaErr.Message = string(res.body)
+		}
+		return darajaErr
+	}
+	if err := json.Unmarshal(res.body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+func (s *MPesaService) generatePassword(creds *mpesaCredentials, timestamp string) (string, error) {
+	passkey := creds.passKey
 	if passkey == "" {
 		// SECURITY: Fail if passkey not configured - don't use default!
 		return "", fmt.Errorf("MPESA_PASS_KEY not configured - cannot generate STK password")
 	}
 
-	data := s.cfg.MPesa.BusinessShortCode + passkey + timestamp
+	data := creds.shortCode + passkey + timestamp
 	hash := md5.Sum([]byte(data))
 	return base64.StdEncoding.EncodeToString(hash[:]), nil
 }
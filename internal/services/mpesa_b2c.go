@@ -0,0 +1,673 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoicefast/internal/circuitbreaker"
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// M-PESA B2C PAYOUTS - refunds, overpayment returns and expense reimbursements
+// ============================================================================
+
+var (
+	ErrPayoutNotConfigured = errors.New("payouts need the tenant's own Daraja app with an initiator and B2C shortcode, and MPESA_RESULT_URL")
+	ErrPayoutNotFound      = errors.New("payout not found")
+	ErrPayoutNotPending    = errors.New("payout is not awaiting approval")
+	ErrPayoutSelfApproval  = errors.New("a payout must be approved by someone other than who requested it")
+	ErrPayoutType          = errors.New("payout type must be refund, overpayment or reimbursement")
+	ErrPayoutAmount        = errors.New("payout amount must be whole shillings and more than zero")
+	ErrPayoutExceedsCredit = errors.New("payout is more than the client's available credit")
+	ErrPayoutInProgress    = errors.New("a payout for this is already awaiting approval or in progress")
+	ErrPayoutPhone         = errors.New("a valid Safaricom phone number is required")
+	ErrPayoutSourceMissing = errors.New("payment, client or expense not found")
+	ErrPayoutSource        = errors.New("nothing to pay back: the payment must be a completed M-Pesa payment and the expense approved")
+)
+
+var safaricomPhone = regexp.MustCompile(`^254(7|1)\d{8}$`)
+
+// openPayoutStatuses are payouts that still hold on to what they pay back
+var openPayoutStatuses = []string{models.PayoutStatusPendingApproval, models.PayoutStatusProcessing}
+
+// errB2CRejected is Daraja refusing a B2C request outright; nothing was sent
+var errB2CRejected = errors.New("B2C request rejected")
+
+const (
+	// A processing payout without a result after this long is asked about via Transaction Status
+	payoutStatusAfter = 10 * time.Minute
+	// Processing payouts older than this are left for a person to resolve
+	payoutStatusWindow = 7 * 24 * time.Hour
+)
+
+// PayoutRequest asks for money to be sent back over M-Pesa. Refunds and
+// reimbursements pay the full payment or expense; overpayments pay Amount
+// out of the client's credit.
+type PayoutRequest struct {
+	PaymentID   string  `json:"payment_id"`
+	ClientID    string  `json:"client_id"`
+	ExpenseID   string  `json:"expense_id"`
+	Amount      float64 `json:"amount"`
+	PhoneNumber string  `json:"phone_number"` // defaults to the payer, client or employee
+	Reason      string  `json:"reason"`
+}
+
+// PayoutService sends approved payouts through the tenant's M-Pesa B2C account
+type PayoutService struct {
+	db    *database.DB
+	mpesa *MPesaService
+}
+
+func NewPayoutService(db *database.DB, mpesa *MPesaService) *PayoutService {
+	return &PayoutService{db: db, mpesa: mpesa}
+}
+
+// RequestPayout records a payout awaiting approval. No money moves until Approve.
+func (s *PayoutService) RequestPayout(tenantID, userID, payoutType string, req *PayoutRequest) (*models.Payout, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+
+	payout := &models.Payout{
+		ID:          uuid.New().String(),
+		TenantID:    tenantID,
+		Type:        payoutType,
+		Status:      models.PayoutStatusPendingApproval,
+		Currency:    "KES",
+		Reason:      strings.TrimSpace(req.Reason),
+		RequestedBy: userID,
+	}
+	phone := req.PhoneNumber
+
+	switch payoutType {
+	case models.PayoutTypeRefund:
+		var payment models.Payment
+		if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&payment, "id = ?", req.PaymentID).Error; err != nil {
+			return nil, ErrPayoutSourceMissing
+		}
+		if payment.Status != models.PaymentStatusCompleted || payment.Method != models.PaymentMethodMpesa {
+			return nil, ErrPayoutSource
+		}
+		payout.PaymentID = payment.ID
+		payout.Amount = payment.Amount
+		if phone == "" {
+			phone = payment.PhoneNumber
+		}
+
+	case models.PayoutTypeOverpayment:
+		var client models.Client
+		if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&client, "id = ?", req.ClientID).Error; err != nil {
+			return nil, ErrPayoutSourceMissing
+		}
+		payout.ClientID = client.ID
+		payout.Amount = models.ToCents(req.Amount)
+		payout.RecipientName = client.Name
+		if phone == "" {
+			phone = client.Phone
+		}
+
+	case models.PayoutTypeReimbursement:
+		var expense models.Expense
+		if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&expense, "id = ?", req.ExpenseID).Error; err != nil {
+			return nil, ErrPayoutSourceMissing
+		}
+		if expense.Status != "approved" {
+			return nil, ErrPayoutSource
+		}
+		payout.ExpenseID = expense.ID
+		payout.Amount = expense.Amount
+		var employee models.User
+		if err := s.db.Select("id", "name", "phone").First(&employee, "id = ?", expense.CreatedBy).Error; err == nil {
+			payout.RecipientName = employee.Name
+			if phone == "" {
+				phone = employee.Phone
+			}
+		}
+
+	default:
+		return nil, ErrPayoutType
+	}
+
+	payout.PhoneNumber = normalizePhone(phone)
+	if !safaricomPhone.MatchString(payout.PhoneNumber) {
+		return nil, ErrPayoutPhone
+	}
+	if payout.Amount <= 0 || payout.Amount%100 != 0 {
+		// B2C only sends whole shillings
+		return nil, ErrPayoutAmount
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkPayoutSource(tx, payout); err != nil {
+			return err
+		}
+		plainPhone := payout.PhoneNumber
+		if err := tx.Create(payout).Error; err != nil {
+			return fmt.Errorf("failed to create payout: %w", err)
+		}
+		payout.PhoneNumber = plainPhone
+		detail := "Requested"
+		if payout.Reason != "" {
+			detail += ": " + payout.Reason
+		}
+		return addPayoutEvent(tx, payout, models.PayoutStatusPendingApproval, detail, "", userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payout, nil
+}
+
+// checkPayoutSource makes sure what a payout pays back is still there and not
+// already being paid back by another payout
+func checkPayoutSource(tx *gorm.DB, payout *models.Payout) error {
+	open := tx.Model(&models.Payout{}).Where("tenant_id = ? AND id <> ? AND status IN ?", payout.TenantID, payout.ID, openPayoutStatuses)
+
+	switch payout.Type {
+	case models.PayoutTypeOverpayment:
+		var client models.Client
+		if err := tx.Select("id", "credit_balance").First(&client, "id = ? AND tenant_id = ?", payout.ClientID, payout.TenantID).Error; err != nil {
+			return ErrPayoutSourceMissing
+		}
+		var reserved models.Money
+		if err := open.Where("type = ? AND client_id = ?", models.PayoutTypeOverpayment, payout.ClientID).
+			Select("COALESCE(SUM(amount), 0)").Scan(&reserved).Error; err != nil {
+			return err
+		}
+		if client.CreditBalance.Sub(reserved).LessThan(payout.Amount) {
+			return ErrPayoutExceedsCredit
+		}
+		return nil
+
+	case models.PayoutTypeRefund:
+		var payment models.Payment
+		if err := tx.Select("id", "status").First(&payment, "id = ? AND tenant_id = ?", payout.PaymentID, payout.TenantID).Error; err != nil {
+			return ErrPayoutSourceMissing
+		}
+		if payment.Status != models.PaymentStatusCompleted {
+			return ErrPayoutSource
+		}
+		open = open.Where("payment_id = ?", payout.PaymentID)
+
+	case models.PayoutTypeReimbursement:
+		var expense models.Expense
+		if err := tx.Select("id", "status").First(&expense, "id = ? AND tenant_id = ?", payout.ExpenseID, payout.TenantID).Error; err != nil {
+			return ErrPayoutSourceMissing
+		}
+		if expense.Status != "approved" {
+			return ErrPayoutSource
+		}
+		open = open.Where("expense_id = ?", payout.ExpenseID)
+	}
+
+	var count int64
+	if err := open.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPayoutInProgress
+	}
+	return nil
+}
+
+// Approve sends a pending payout to M-Pesa. The person approving can't be the
+// one who requested it.
+func (s *PayoutService) Approve(ctx context.Context, tenantID, userID, payoutID string) (*models.Payout, error) {
+	creds, err := s.b2cCredentials(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the payout first so a double click sends the money once
+	now := time.Now()
+	res := s.db.Model(&models.Payout{}).
+		Where("id = ? AND tenant_id = ? AND status = ? AND requested_by <> ?", payoutID, tenantID, models.PayoutStatusPendingApproval, userID).
+		Updates(map[string]interface{}{
+			"status":      models.PayoutStatusProcessing,
+			"approved_by": userID,
+			"approved_at": now,
+			"short_code":  creds.b2cShortCode,
+		})
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to approve payout: %w", res.Error)
+	}
+	payout, err := s.GetPayout(tenantID, payoutID)
+	if err != nil {
+		return nil, err
+	}
+	if res.RowsAffected == 0 {
+		if payout.Status == models.PayoutStatusPendingApproval && payout.RequestedBy == userID {
+			return nil, ErrPayoutSelfApproval
+		}
+		return nil, ErrPayoutNotPending
+	}
+
+	if err := checkPayoutSource(s.db.DB, payout); err != nil {
+		s.fail(ctx, payout, "", err.Error(), userID)
+		return payout, err
+	}
+	addPayoutEvent(s.db.DB, payout, models.PayoutStatusProcessing, "Approved", "", userID)
+
+	conversationID, err := s.sendB2C(ctx, creds, payout)
+	if err != nil {
+		if b2cNotSent(err) {
+			s.fail(ctx, payout, "", err.Error(), "")
+			return payout, err
+		}
+		// Daraja may have taken the request before the error (a timeout, a
+		// dropped connection). Failing the payout would free it to be paid
+		// again, so it stays processing until Transaction Status settles it.
+		addPayoutEvent(s.db.DB, payout, models.PayoutStatusProcessing, "No answer from M-Pesa, will check the payout's status: "+err.Error(), "", "")
+		logger.Get().Warn(ctx, "B2C payout outcome unknown", "tenant_id", tenantID, "payout_id", payout.ID, "error", err)
+		return payout, nil
+	}
+
+	payout.ConversationID = conversationID
+	s.db.Model(&models.Payout{}).Where("id = ?", payout.ID).Update("conversation_id", conversationID)
+	addPayoutEvent(s.db.DB, payout, models.PayoutStatusProcessing, "Sent to M-Pesa", "", "")
+
+	logger.Get().Info(ctx, "B2C payout sent", "tenant_id", tenantID, "payout_id", payout.ID,
+		"type", payout.Type, "conversation_id", conversationID)
+	return payout, nil
+}
+
+// Reject declines a pending payout
+func (s *PayoutService) Reject(tenantID, userID, payoutID, reason string) (*models.Payout, error) {
+	res := s.db.Model(&models.Payout{}).
+		Where("id = ? AND tenant_id = ? AND status = ?", payoutID, tenantID, models.PayoutStatusPendingApproval).
+		Updates(map[string]interface{}{
+			"status":          models.PayoutStatusRejected,
+			"rejected_by":     userID,
+			"rejected_reason": reason,
+		})
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to reject payout: %w", res.Error)
+	}
+	payout, err := s.GetPayout(tenantID, payoutID)
+	if err != nil {
+		return nil, err
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrPayoutNotPending
+	}
+	addPayoutEvent(s.db.DB, payout, models.PayoutStatusRejected, reason, "", userID)
+	return payout, nil
+}
+
+// b2cCredentials returns the tenant's own Daraja credentials. Payouts never
+// use the platform account: that would pay tenants' refunds with platform money.
+func (s *PayoutService) b2cCredentials(tenantID string) (*mpesaCredentials, error) {
+	creds, err := s.mpesa.credentialsFor(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if creds.source != models.MpesaCredentialsTenant || creds.b2cShortCode == "" ||
+		!creds.canQueryTransactionStatus() || s.mpesa.cfg.MPesa.ResultURL == "" {
+		return nil, ErrPayoutNotConfigured
+	}
+	return creds, nil
+}
+
+// b2cNotSent reports whether a B2C request failed before Daraja could have taken it
+func b2cNotSent(err error) bool {
+	var darajaErr *DarajaError
+	if errors.As(err, &darajaErr) {
+		return darajaErr.StatusCode < 500
+	}
+	return errors.Is(err, errB2CRejected) || errors.Is(err, ErrMpesaTokenFailed) ||
+		errors.Is(err, circuitbreaker.ErrCircuitOpen) || errors.Is(err, circuitbreaker.ErrCircuitHalfOpen)
+}
+
+func (s *PayoutService) sendB2C(ctx context.Context, creds *mpesaCredentials, payout *models.Payout) (string, error) {
+	base, err := s.mpesa.resultURL(payout.TenantID)
+	if err != nil {
+		return "", err
+	}
+
+	remarks := payout.Type
+	if payout.Reason != "" {
+		remarks = payout.Reason
+	}
+	if len(remarks) > 100 {
+		remarks = remarks[:100]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	var resp struct {
+		ConversationID           string `json:"ConversationID"`
+		OriginatorConversationID string `json:"OriginatorConversationID"`
+		ResponseCode             string `json:"ResponseCode"`
+		ResponseDescription      string `json:"ResponseDescription"`
+	}
+	if err := s.mpesa.postDaraja(ctx, creds, "/mpesa/b2c/v3/paymentrequest", map[string]string{
+		"OriginatorConversationID": payout.ID,
+		"InitiatorName":            creds.initiatorName,
+		"SecurityCredential":       creds.securityCredential,
+		"CommandID":                "BusinessPayment",
+		"Amount":                   strconv.FormatInt(int64(payout.Amount)/100, 10),
+		"PartyA":                   creds.b2cShortCode,
+		"PartyB":                   payout.PhoneNumber,
+		"Remarks":                  remarks,
+		"QueueTimeOutURL":          base + "/b2c/timeout",
+		"ResultURL":                base + "/b2c",
+		"Occasion":                 payout.Type,
+	}, &resp); err != nil {
+		return "", err
+	}
+	if resp.ResponseCode != "0" || resp.ConversationID == "" {
+		return "", fmt.Errorf("%w: %s", errB2CRejected, resp.ResponseDescription)
+	}
+	return resp.ConversationID, nil
+}
+
+// ProcessB2CResult settles a payout from Daraja's result callback
+func (s *PayoutService) ProcessB2CResult(ctx context.Context, tenantID string, result *MpesaResult) error {
+	payout, err := s.payoutForResult(tenantID, result)
+	if err != nil {
+		return err
+	}
+	if payout.Status != models.PayoutStatusProcessing {
+		logger.Get().Info(ctx, "Duplicate B2C result", "payout_id", payout.ID, "status", payout.Status)
+		return nil
+	}
+
+	r := result.Result
+	if r.ResultCode != 0 {
+		s.fail(ctx, payout, strconv.Itoa(r.ResultCode), r.ResultDesc, "")
+		return nil
+	}
+
+	receipt := result.Parameter("TransactionReceipt")
+	if receipt == "" {
+		receipt = r.TransactionID
+	}
+	return s.complete(ctx, payout, receipt, r.ResultDesc, result.Parameter("ReceiverPartyPublicName"))
+}
+
+// complete settles a payout M-Pesa has paid: a refund reverses the payment,
+// an overpayment uses up the client's credit and a reimbursement marks the expense paid
+func (s *PayoutService) complete(ctx context.Context, payout *models.Payout, receipt, desc, recipientName string) error {
+	tenantID := payout.TenantID
+	now := time.Now()
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"status":         models.PayoutStatusCompleted,
+			"receipt_number": receipt,
+			"result_code":    "0",
+			"result_desc":    desc,
+			"completed_at":   now,
+		}
+		if recipientName != "" {
+			updates["recipient_name"] = recipientName
+		}
+		res := tx.Model(&models.Payout{}).Where("id = ? AND status = ?", payout.ID, models.PayoutStatusProcessing).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil // settled by a concurrent delivery of the same result
+		}
+
+		switch payout.Type {
+		case models.PayoutTypeRefund:
+			if err := reversePayment(tx, tenantID, payout.PaymentID, "Refunded via M-Pesa "+receipt); err != nil {
+				return err
+			}
+		case models.PayoutTypeOverpayment:
+			if err := tx.Model(&models.Client{}).Where("id = ? AND tenant_id = ?", payout.ClientID, tenantID).
+				UpdateColumn("credit_balance", gorm.Expr("credit_balance - ?", payout.Amount)).Error; err != nil {
+				return err
+			}
+			var client models.Client
+			if err := tx.Select("id", "credit_balance").First(&client, "id = ?", payout.ClientID).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.ClientCreditTransaction{
+				ID:           uuid.New().String(),
+				TenantID:     tenantID,
+				ClientID:     payout.ClientID,
+				PayoutID:     payout.ID,
+				Type:         models.ClientCreditPayout,
+				Amount:       -payout.Amount,
+				BalanceAfter: client.CreditBalance,
+				CreatedBy:    payout.ApprovedBy,
+			}).Error; err != nil {
+				return err
+			}
+		case models.PayoutTypeReimbursement:
+			if err := tx.Model(&models.Expense{}).Where("id = ? AND tenant_id = ?", payout.ExpenseID, tenantID).
+				Updates(map[string]interface{}{
+					"status":         "paid",
+					"paid_at":        now,
+					"payment_method": "mpesa",
+					"reference":      receipt,
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		return addPayoutEvent(tx, payout, models.PayoutStatusCompleted, desc, receipt, "")
+	})
+	if err != nil {
+		// The money has left; keep the payout open so the result can be applied again
+		logger.Get().Error(ctx, "Failed to apply B2C result", "payout_id", payout.ID, "receipt", receipt, "error", err)
+		return err
+	}
+
+	logger.Get().Info(ctx, "B2C payout completed", "tenant_id", tenantID, "payout_id", payout.ID, "receipt", receipt)
+	return nil
+}
+
+// ReconcileProcessing asks M-Pesa about payouts that have been processing for
+// longer than olderThan without a result: ones whose request got no answer and
+// ones whose result callback never came
+func (s *PayoutService) ReconcileProcessing(ctx context.Context, olderThan time.Duration) (int, error) {
+	if olderThan <= 0 {
+		olderThan = payoutStatusAfter
+	}
+	now := time.Now()
+
+	var payouts []models.Payout
+	if err := s.db.Where("status = ? AND approved_at < ? AND approved_at > ?", models.PayoutStatusProcessing, now.Add(-olderThan), now.Add(-payoutStatusWindow)).
+		Where("status_conversation_id = '' OR status_conversation_id IS NULL").
+		Where("status_queried_at IS NULL OR status_queried_at < ?", now.Add(-olderThan)).
+		Order("approved_at ASC").Limit(reconcileBatchSize).Find(&payouts).Error; err != nil {
+		return 0, fmt.Errorf("failed to load processing payouts: %w", err)
+	}
+
+	queried := 0
+	for i := range payouts {
+		if err := s.requestStatus(ctx, &payouts[i]); err != nil {
+			logger.Get().Warn(ctx, "Payout status query failed", "tenant_id", payouts[i].TenantID, "payout_id", payouts[i].ID, "error", err)
+			continue
+		}
+		queried++
+	}
+	return queried, nil
+}
+
+// requestStatus starts a Transaction Status query for a payout. The payout is
+// found by the OriginatorConversationID it was sent with, its own ID, so this
+// works whether or not Daraja's reply to the request arrived.
+func (s *PayoutService) requestStatus(ctx context.Context, payout *models.Payout) error {
+	creds, err := s.b2cCredentials(payout.TenantID)
+	if err != nil {
+		return err
+	}
+	conversationID, err := s.mpesa.queryTransactionStatus(ctx, creds, payout.TenantID, "/b2c/status", "/b2c/status/timeout", map[string]string{
+		"OriginalConversationID": payout.ID,
+		"PartyA":                 creds.b2cShortCode,
+		"Remarks":                "Payout status",
+		"Occasion":               payout.Type,
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Model(&models.Payout{}).Where("id = ?", payout.ID).Updates(map[string]interface{}{
+		"status_conversation_id": conversationID,
+		"status_queried_at":      time.Now(),
+	}).Error
+}
+
+// ProcessStatusResult settles a processing payout from a Transaction Status
+// answer: completed payouts are applied as if their result had arrived, and a
+// payout M-Pesa has no record of was never sent
+func (s *PayoutService) ProcessStatusResult(ctx context.Context, tenantID string, result *MpesaResult) error {
+	payout, err := s.pendingStatusQuery(tenantID, result)
+	if err != nil {
+		return err
+	}
+	s.db.Model(&models.Payout{}).Where("id = ?", payout.ID).Update("status_conversation_id", "")
+	if payout.Status != models.PayoutStatusProcessing {
+		return nil
+	}
+
+	r := result.Result
+	if r.ResultCode != 0 {
+		s.fail(ctx, payout, strconv.Itoa(r.ResultCode), "M-Pesa has no record of the payout: "+r.ResultDesc, "")
+		return nil
+	}
+
+	switch status := result.Parameter("TransactionStatus"); {
+	case strings.EqualFold(status, "Completed"):
+		receipt := result.Parameter("ReceiptNo")
+		if receipt == "" {
+			receipt = r.TransactionID
+		}
+		return s.complete(ctx, payout, receipt, "Confirmed by transaction status", result.Parameter("CreditPartyName"))
+	case strings.EqualFold(status, "Failed"), strings.EqualFold(status, "Cancelled"), strings.EqualFold(status, "Expired"):
+		s.fail(ctx, payout, "", "M-Pesa transaction "+strings.ToLower(status), "")
+	default:
+		// Still in flight; the next reconcile asks again
+		logger.Get().Info(ctx, "Payout still pending at M-Pesa", "payout_id", payout.ID, "transaction_status", status)
+	}
+	return nil
+}
+
+// StatusTimeout frees a payout whose Transaction Status query timed out in
+// Daraja's queue so the reconciler can ask again
+func (s *PayoutService) StatusTimeout(ctx context.Context, tenantID string, result *MpesaResult) error {
+	payout, err := s.pendingStatusQuery(tenantID, result)
+	if err != nil {
+		return err
+	}
+	logger.Get().Warn(ctx, "Payout status query timed out", "tenant_id", tenantID, "payout_id", payout.ID)
+	return s.db.Model(&models.Payout{}).Where("id = ?", payout.ID).Update("status_conversation_id", "").Error
+}
+
+func (s *PayoutService) pendingStatusQuery(tenantID string, result *MpesaResult) (*models.Payout, error) {
+	var ids []string
+	for _, id := range []string{result.Result.ConversationID, result.Result.OriginatorConversationID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if tenantID == "" || len(ids) == 0 {
+		return nil, ErrMpesaResultUnknown
+	}
+
+	var payout models.Payout
+	if err := s.db.Where("tenant_id = ? AND status_conversation_id IN ?", tenantID, ids).First(&payout).Error; err != nil {
+		return nil, ErrMpesaResultUnknown
+	}
+	return &payout, nil
+}
+
+// B2CTimeout fails a payout that timed out in Daraja's queue; it was not paid
+func (s *PayoutService) B2CTimeout(ctx context.Context, tenantID string, result *MpesaResult) error {
+	payout, err := s.payoutForResult(tenantID, result)
+	if err != nil {
+		return err
+	}
+	if payout.Status == models.PayoutStatusProcessing {
+		s.fail(ctx, payout, "", "Timed out in the M-Pesa queue", "")
+	}
+	return nil
+}
+
+func (s *PayoutService) payoutForResult(tenantID string, result *MpesaResult) (*models.Payout, error) {
+	r := result.Result
+	if tenantID == "" || (r.ConversationID == "" && r.OriginatorConversationID == "") {
+		return nil, ErrMpesaResultUnknown
+	}
+
+	// OriginatorConversationID is the payout ID we sent
+	var payout models.Payout
+	err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("(conversation_id = ? AND conversation_id <> '') OR id = ?", r.ConversationID, r.OriginatorConversationID).
+		First(&payout).Error
+	if err != nil {
+		return nil, ErrMpesaResultUnknown
+	}
+	return &payout, nil
+}
+
+func (s *PayoutService) fail(ctx context.Context, payout *models.Payout, resultCode, reason, userID string) {
+	payout.Status = models.PayoutStatusFailed
+	payout.ResultCode = resultCode
+	payout.ResultDesc = reason
+	if err := s.db.Model(&models.Payout{}).Where("id = ?", payout.ID).Updates(map[string]interface{}{
+		"status":      payout.Status,
+		"result_code": resultCode,
+		"result_desc": reason,
+	}).Error; err != nil {
+		logger.Get().Error(ctx, "Failed to record failed payout", "payout_id", payout.ID, "error", err)
+	}
+	addPayoutEvent(s.db.DB, payout, models.PayoutStatusFailed, reason, "", userID)
+	logger.Get().Warn(ctx, "B2C payout failed", "payout_id", payout.ID, "reason", reason)
+}
+
+func addPayoutEvent(tx *gorm.DB, payout *models.Payout, status, detail, receipt, userID string) error {
+	return tx.Create(&models.PayoutEvent{
+		ID:            uuid.New().String(),
+		TenantID:      payout.TenantID,
+		PayoutID:      payout.ID,
+		Status:        status,
+		Detail:        strings.TrimSpace(detail),
+		ReceiptNumber: receipt,
+		CreatedBy:     userID,
+		CreatedAt:     time.Now(),
+	}).Error
+}
+
+// GetPayout returns a payout with its history
+func (s *PayoutService) GetPayout(tenantID, payoutID string) (*models.Payout, error) {
+	var payout models.Payout
+	err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		First(&payout, "id = ?", payoutID).Error
+	if err != nil {
+		return nil, ErrPayoutNotFound
+	}
+	return &payout, nil
+}
+
+// ListPayouts returns the tenant's payouts, newest first
+func (s *PayoutService) ListPayouts(tenantID, status, payoutType string) ([]models.Payout, error) {
+	query := s.db.Scopes(database.TenantFilter(tenantID))
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if payoutType != "" {
+		query = query.Where("type = ?", payoutType)
+	}
+	var payouts []models.Payout
+	if err := query.Order("created_at DESC").Limit(200).Find(&payouts).Error; err != nil {
+		return nil, fmt.Errorf("failed to load payouts: %w", err)
+	}
+	return payouts, nil
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// M-PESA C2B - Paybill/Till payments made from the customer's phone
+// ============================================================================
+
+// Daraja C2B validation result codes
+const (
+	C2BAccepted             = "0"
+	C2BRejectInvalidAccount = "C2B00012"
+	C2BRejectInvalidAmount  = "C2B00013"
+	C2BRejectShortCode      = "C2B00015"
+	C2BRejectOther          = "C2B00016"
+)
+
+var (
+	ErrC2BNotConfigured = errors.New("C2B needs the tenant's own Daraja credentials and MPESA_C2B_URL")
+	ErrC2BShortCode     = errors.New("C2B payment is for a different shortcode")
+)
+
+// C2BRequest is the body Daraja posts to the validation and confirmation URLs
+type C2BRequest struct {
+	TransactionType   string `json:"TransactionType"`
+	TransID           string `json:"TransID"`
+	TransTime         string `json:"TransTime"`
+	TransAmount       string `json:"TransAmount"`
+	BusinessShortCode string `json:"BusinessShortCode"`
+	BillRefNumber     string `json:"BillRefNumber"`
+	InvoiceNumber     string `json:"InvoiceNumber"`
+	OrgAccountBalance string `json:"OrgAccountBalance"`
+	ThirdPartyTransID string `json:"ThirdPartyTransID"`
+	MSISDN            string `json:"MSISDN"`
+	FirstName         string `json:"FirstName"`
+	MiddleName        string `json:"MiddleName"`
+	LastName          string `json:"LastName"`
+}
+
+// C2BResponse is what Daraja expects back from validation and confirmation
+type C2BResponse struct {
+	ResultCode string `json:"ResultCode"`
+	ResultDesc string `json:"ResultDesc"`
+}
+
+type C2BRegisterResponse struct {
+	OriginatorConversationID string `json:"OriginatorCoversationID"` // sic, Daraja's spelling
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+	ShortCode                string `json:"short_code"`
+	ConfirmationURL          string `json:"confirmation_url"`
+	ValidationURL            string `json:"validation_url"`
+}
+
+// C2BConfirmation is the outcome of a confirmed paybill payment
+type C2BConfirmation struct {
+	Duplicate   bool                       `json:"duplicate"`
+	Allocation  *AllocationResult          `json:"allocation,omitempty"`
+	Unallocated *models.UnallocatedPayment `json:"unallocated,omitempty"`
+	Match       *InvoiceMatch              `json:"match,omitempty"`
+}
+
+// C2BService registers paybill URLs with Daraja and applies paybill payments to invoices
+type C2BService struct {
+	db      *database.DB
+	mpesa   *MPesaService
+	matcher *PaymentMatchingService
+}
+
+func NewC2BService(db *database.DB, mpesa *MPesaService, matcher *PaymentMatchingService) *C2BService {
+	return &C2BService{db: db, mpesa: mpesa, matcher: matcher}
+}
+
+// tenantCredentials returns the tenant's own Daraja credentials. C2B is not
+// offered on the platform paybill: its account references can't name a tenant.
+func (s *C2BService) tenantCredentials(tenantID string) (*mpesaCredentials, error) {
+	creds, err := s.mpesa.credentialsFor(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if creds.source != models.MpesaCredentialsTenant {
+		return nil, ErrC2BNotConfigured
+	}
+	return creds, nil
+}
+
+// RegisterURLs registers the tenant's validation and confirmation URLs for its
+// shortcode. They carry the tenant's callback token, which is what authenticates
+// Daraja's callbacks.
+func (s *C2BService) RegisterURLs(ctx context.Context, tenantID string) (*C2BRegisterResponse, error) {
+	base := strings.TrimRight(s.mpesa.cfg.MPesa.C2BURL, "/")
+	if base == "" {
+		return nil, ErrC2BNotConfigured
+	}
+	base += "/" + tenantID + "/" + MpesaCallbackToken(s.mpesa.cfg.MPesa.CallbackSecret, tenantID)
+	creds, err := s.tenantCredentials(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	var resp C2BRegisterResponse
+	req := map[string]string{
+		"ShortCode":       creds.shortCode,
+		"ResponseType":    "Completed", // accept the payment if our validation URL can't be reached
+		"ConfirmationURL": base + "/confirmation",
+		"ValidationURL":   base + "/validation",
+	}
+	if err := s.mpesa.postDaraja(ctx, creds, "/mpesa/c2b/v2/registerurl", req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.ResponseCode != "" && resp.ResponseCode != "0" {
+		return nil, fmt.Errorf("C2B URL registration rejected: %s", resp.ResponseDescription)
+	}
+
+	resp.ShortCode = creds.shortCode
+	resp.ConfirmationURL = req["ConfirmationURL"]
+	resp.ValidationURL = req["ValidationURL"]
+	logger.Get().Info(ctx, "C2B URLs registered", "tenant_id", tenantID, "short_code", creds.shortCode)
+	return &resp, nil
+}
+
+// Validate accepts a paybill payment only when its account reference is an
+// open invoice of the tenant
+func (s *C2BService) Validate(tenantID string, req *C2BRequest) *C2BResponse {
+	creds, err := s.tenantCredentials(tenantID)
+	if err != nil {
+		return &C2BResponse{ResultCode: C2BRejectOther, ResultDesc: "Rejected"}
+	}
+	if req.BusinessShortCode != creds.shortCode {
+		return &C2BResponse{ResultCode: C2BRejectShortCode, ResultDesc: "Rejected"}
+	}
+	if amount, err := strconv.ParseFloat(req.TransAmount, 64); err != nil || amount <= 0 {
+		return &C2BResponse{ResultCode: C2BRejectInvalidAmount, ResultDesc: "Rejected"}
+	}
+
+	ref := normalizeReference(req.BillRefNumber)
+	if ref == "" {
+		return &C2BResponse{ResultCode: C2BRejectInvalidAccount, ResultDesc: "Rejected"}
+	}
+	var invoice models.Invoice
+	err = s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("UPPER(REPLACE(REPLACE(REPLACE(invoice_number, '-', ''), ' ', ''), '/', '')) = ?", ref).
+		First(&invoice).Error
+	if err != nil {
+		return &C2BResponse{ResultCode: C2BRejectInvalidAccount, ResultDesc: "Rejected"}
+	}
+	switch invoice.Status {
+	case models.InvoiceStatusSent, models.InvoiceStatusViewed, models.InvoiceStatusPartiallyPaid, models.InvoiceStatusOverdue:
+	default:
+		// Drafts, paid, cancelled and void invoices can't take payments
+		return &C2BResponse{ResultCode: C2BRejectInvalidAccount, ResultDesc: "Rejected"}
+	}
+	if invoice.InvoiceType == "credit_note" {
+		return &C2BResponse{ResultCode: C2BRejectInvalidAccount, ResultDesc: "Rejected"}
+	}
+
+	return &C2BResponse{ResultCode: C2BAccepted, ResultDesc: "Accepted"}
+}
+
+// Confirm records a paybill confirmation. Daraja doesn't sign confirmations,
+// so nothing is applied to an invoice yet: the payment waits in the
+// unallocated queue, with its best guess attached, until Transaction Status
+// confirms M-Pesa has it (ProcessStatusResult). Tenants without an initiator
+// can't be checked and leave every payment for a person to match.
+func (s *C2BService) Confirm(ctx context.Context, tenantID string, req *C2BRequest) (*C2BConfirmation, error) {
+	creds, err := s.tenantCredentials(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if req.BusinessShortCode != creds.shortCode {
+		return nil, ErrC2BShortCode
+	}
+	if strings.TrimSpace(req.TransID) == "" {
+		return nil, errors.New("missing TransID")
+	}
+	amount, err := strconv.ParseFloat(req.TransAmount, 64)
+	if err != nil || amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %q", req.TransAmount)
+	}
+
+	// Daraja may send a confirmation more than once
+	var seen int64
+	s.db.Model(&models.Payment{}).Where("tenant_id = ? AND reference = ?", tenantID, req.TransID).Count(&seen)
+	if seen == 0 {
+		s.db.Model(&models.UnallocatedPayment{}).Where("tenant_id = ? AND reference = ?", tenantID, req.TransID).Count(&seen)
+	}
+	if seen > 0 {
+		logger.Get().Info(ctx, "Duplicate C2B confirmation", "tenant_id", tenantID, "trans_id", req.TransID)
+		return &C2BConfirmation{Duplicate: true}, nil
+	}
+
+	match, err := s.matcher.FindUnpaidByReference(tenantID, req.BillRefNumber, req.MSISDN)
+	if err != nil {
+		return nil, err
+	}
+
+	unallocated := &models.UnallocatedPayment{
+		ID:               uuid.New().String(),
+		TenantID:         tenantID,
+		Amount:           models.ToCents(amount),
+		Currency:         "KES",
+		Reference:        req.TransID,
+		PhoneNumber:      req.MSISDN,
+		AccountReference: req.BillRefNumber,
+		PayerName:        strings.Join(strings.Fields(req.FirstName+" "+req.MiddleName+" "+req.LastName), " "),
+		Verification:     models.C2BVerificationUnverified,
+		CreatedAt:        time.Now(),
+	}
+	if match != nil {
+		unallocated.SuggestedInvoiceID = match.Invoice.ID
+		unallocated.SuggestionReason = match.MatchedOn
+		unallocated.SuggestionScore = match.Score
+	}
+	if err := s.db.Create(unallocated).Error; err != nil {
+		return nil, fmt.Errorf("failed to queue unallocated payment: %w", err)
+	}
+
+	if creds.canQueryTransactionStatus() && s.mpesa.cfg.MPesa.ResultURL != "" {
+		conversationID, err := s.mpesa.queryTransactionStatus(ctx, creds, tenantID, "/c2b", "/c2b/timeout", map[string]string{
+			"TransactionID": req.TransID,
+			"Remarks":       "Paybill confirmation",
+			"Occasion":      req.BillRefNumber,
+		})
+		if err != nil {
+			logger.Get().Warn(ctx, "C2B verification could not be requested", "tenant_id", tenantID, "trans_id", req.TransID, "error", err)
+		} else {
+			unallocated.Verification = models.C2BVerificationPending
+			unallocated.StatusConversationID = conversationID
+			if err := s.db.Model(unallocated).Updates(map[string]interface{}{
+				"verification":           unallocated.Verification,
+				"status_conversation_id": conversationID,
+			}).Error; err != nil {
+				return nil, fmt.Errorf("failed to record C2B verification: %w", err)
+			}
+		}
+	}
+
+	logger.Get().Info(ctx, "C2B payment queued", "tenant_id", tenantID, "trans_id", req.TransID,
+		"verification", unallocated.Verification, "suggested_invoice_id", unallocated.SuggestedInvoiceID)
+	return &C2BConfirmation{Unallocated: unallocated, Match: match}, nil
+}
+
+// ProcessStatusResult applies the Transaction Status answer for a paybill
+// confirmation. A payment M-Pesa confirms for the same amount is applied to
+// the invoice it confidently matches; one M-Pesa doesn't know is rejected and
+// can't be allocated.
+func (s *C2BService) ProcessStatusResult(ctx context.Context, tenantID string, result *MpesaResult) (*C2BConfirmation, error) {
+	unallocated, err := s.pendingVerification(tenantID, result)
+	if err != nil {
+		return nil, err
+	}
+
+	r := result.Result
+	reason := ""
+	switch {
+	case r.ResultCode != 0:
+		reason = r.ResultDesc
+	case result.Parameter("TransactionStatus") != "" && !strings.EqualFold(result.Parameter("TransactionStatus"), "Completed"):
+		reason = "M-Pesa transaction " + strings.ToLower(result.Parameter("TransactionStatus"))
+	case result.Parameter("ReceiptNo") != "" && result.Parameter("ReceiptNo") != unallocated.Reference:
+		reason = "M-Pesa returned receipt " + result.Parameter("ReceiptNo")
+	default:
+		amount, err := strconv.ParseFloat(result.Parameter("Amount"), 64)
+		if err != nil || models.ToCents(amount) != unallocated.Amount {
+			reason = fmt.Sprintf("M-Pesa amount %q does not match the confirmation", result.Parameter("Amount"))
+		}
+	}
+
+	if reason != "" {
+		if err := s.db.Model(unallocated).Updates(map[string]interface{}{
+			"verification":           models.C2BVerificationRejected,
+			"status_conversation_id": "",
+			"notes":                  strings.TrimSpace(unallocated.Notes + " Not verified: " + reason),
+		}).Error; err != nil {
+			return nil, err
+		}
+		unallocated.Verification = models.C2BVerificationRejected
+		logger.Get().Warn(ctx, "C2B confirmation rejected by transaction status", "tenant_id", tenantID,
+			"trans_id", unallocated.Reference, "reason", reason)
+		return &C2BConfirmation{Unallocated: unallocated}, nil
+	}
+
+	if err := s.db.Model(unallocated).Updates(map[string]interface{}{
+		"verification":           models.C2BVerificationVerified,
+		"status_conversation_id": "",
+	}).Error; err != nil {
+		return nil, err
+	}
+	unallocated.Verification = models.C2BVerificationVerified
+
+	match, err := s.matcher.FindUnpaidByReference(tenantID, unallocated.AccountReference, unallocated.PhoneNumber)
+	if err != nil {
+		return nil, err
+	}
+	if match == nil || !match.Automatic || unallocated.IsMatched {
+		return &C2BConfirmation{Unallocated: unallocated, Match: match}, nil
+	}
+
+	allocation, err := s.matcher.AllocatePayment(tenantID, match.Invoice.UserID, &AllocatePaymentRequest{
+		ClientID:             match.Invoice.ClientID,
+		UnallocatedPaymentID: unallocated.ID,
+		InvoiceIDs:           []string{match.Invoice.ID},
+		IdempotencyKey:       "c2b:" + unallocated.Reference,
+	})
+	if err != nil {
+		// e.g. the invoice was settled moments ago, or a person already matched it
+		logger.Get().Warn(ctx, "C2B auto-match failed, left unallocated", "trans_id", unallocated.Reference, "error", err)
+		return &C2BConfirmation{Unallocated: unallocated, Match: match}, nil
+	}
+
+	logger.Get().Info(ctx, "C2B payment matched", "tenant_id", tenantID, "trans_id", unallocated.Reference,
+		"invoice", match.Invoice.InvoiceNumber, "matched_on", match.MatchedOn)
+	return &C2BConfirmation{Allocation: allocation, Match: match}, nil
+}
+
+// StatusTimeout leaves a confirmation whose Transaction Status query timed out
+// in Daraja's queue for a person to match
+func (s *C2BService) StatusTimeout(ctx context.Context, tenantID string, result *MpesaResult) error {
+	unallocated, err := s.pendingVerification(tenantID, result)
+	if err != nil {
+		return err
+	}
+	logger.Get().Warn(ctx, "C2B verification timed out", "tenant_id", tenantID, "trans_id", unallocated.Reference)
+	return s.db.Model(unallocated).Updates(map[string]interface{}{
+		"verification":           models.C2BVerificationUnverified,
+		"status_conversation_id": "",
+	}).Error
+}
+
+func (s *C2BService) pendingVerification(tenantID string, result *MpesaResult) (*models.UnallocatedPayment, error) {
+	var ids []string
+	for _, id := range []string{result.Result.ConversationID, result.Result.OriginatorConversationID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if tenantID == "" || len(ids) == 0 {
+		return nil, ErrMpesaResultUnknown
+	}
+
+	var unallocated models.UnallocatedPayment
+	if err := s.db.Where("tenant_id = ? AND status_conversation_id IN ?", tenantID, ids).First(&unallocated).Error; err != nil {
+		return nil, ErrMpesaResultUnknown
+	}
+	return &unallocated, nil
+}
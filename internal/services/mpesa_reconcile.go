@@ -0,0 +1,387 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+)
+
+// ============================================================================
+// M-PESA STATUS RECONCILIATION - resolve STK pushes whose callback never came
+// ============================================================================
+
+const (
+	// stkQueryProcessing is Daraja's error code while the customer hasn't answered the prompt
+	stkQueryProcessing = "500.001.1001"
+	// STK Push Query only knows recent pushes; older ones are asked about via Transaction Status
+	transactionStatusAfter = 30 * time.Minute
+	// Pushes older than this are left for a person to resolve
+	reconcileWindow      = 24 * time.Hour
+	maxSTKQueryAttempts  = 10
+	reconcileBatchSize   = 50
+	defaultReconcileWait = 3 * time.Minute
+)
+
+var ErrMpesaResultUnknown = errors.New("M-Pesa result does not match a pending status query")
+
+// STKQueryResponse is Daraja's reply to an STK Push Query
+type STKQueryResponse struct {
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	MerchantRequestID   string `json:"MerchantRequestID"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	ResultCode          string `json:"ResultCode"`
+	ResultDesc          string `json:"ResultDesc"`
+}
+
+// MpesaResult is the body Daraja posts to the ResultURL of asynchronous APIs
+type MpesaResult struct {
+	Result struct {
+		ResultType               int    `json:"ResultType"`
+		ResultCode               int    `json:"ResultCode"`
+		ResultDesc               string `json:"ResultDesc"`
+		OriginatorConversationID string `json:"OriginatorConversationID"`
+		ConversationID           string `json:"ConversationID"`
+		TransactionID            string `json:"TransactionID"`
+		ResultParameters         struct {
+			ResultParameter []struct {
+				Key   string      `json:"Key"`
+				Value interface{} `json:"Value"`
+			} `json:"ResultParameter"`
+		} `json:"ResultParameters"`
+	} `json:"Result"`
+}
+
+// Parameter returns a result parameter as a string, or "" when absent
+func (r *MpesaResult) Parameter(key string) string {
+	for _, p := range r.Result.ResultParameters.ResultParameter {
+		if p.Key != key {
+			continue
+		}
+		switch v := p.Value.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case nil:
+			return ""
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// STKReconcileResult summarises one reconciler run
+type STKReconcileResult struct {
+	Checked       int `json:"checked"`
+	Completed     int `json:"completed"`
+	Failed        int `json:"failed"`
+	StillPending  int `json:"still_pending"`
+	StatusQueries int `json:"status_queries"`
+	Errors        int `json:"errors"`
+}
+
+// ReconcilePendingSTK queries Daraja for pending M-Pesa payments whose STK
+// callback hasn't arrived within olderThan, and settles them through the same
+// paths as the callback. Safe to run alongside callbacks: whichever comes
+// second finds nothing left to do.
+func (s *MPesaService) ReconcilePendingSTK(ctx context.Context, olderThan time.Duration) (*STKReconcileResult, error) {
+	if olderThan <= 0 {
+		olderThan = defaultReconcileWait
+	}
+	now := time.Now()
+
+	var payments []models.Payment
+	if err := s.db.Select("id", "tenant_id", "reference", "created_at").
+		Where("method = ? AND status = ? AND reference <> '' AND created_at < ? AND created_at > ?",
+			models.PaymentMethodMpesa, models.PaymentStatusPending, now.Add(-olderThan), now.Add(-reconcileWindow)).
+		Order("created_at ASC").Limit(reconcileBatchSize * 4).Find(&payments).Error; err != nil {
+		return nil, fmt.Errorf("failed to load pending payments: %w", err)
+	}
+
+	result := &STKReconcileResult{}
+	seen := make(map[string]bool)
+	for _, payment := range payments {
+		// A portal push settling several invoices has one payment per invoice
+		key := payment.TenantID + ":" + payment.Reference
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if result.Checked >= reconcileBatchSize || ctx.Err() != nil {
+			break
+		}
+
+		if err := s.reconcilePush(ctx, payment.TenantID, payment.Reference, payment.CreatedAt, olderThan, result); err != nil {
+			result.Errors++
+			logger.Get().Warn(ctx, "STK reconcile failed", "tenant_id", payment.TenantID,
+				"checkout_request_id", payment.Reference, "error", err)
+		}
+	}
+
+	if result.Checked > 0 {
+		logger.Get().Info(ctx, "STK reconcile run", "checked", result.Checked, "completed", result.Completed,
+			"failed", result.Failed, "still_pending", result.StillPending, "status_queries", result.StatusQueries,
+			"errors", result.Errors)
+	}
+	return result, nil
+}
+
+func (s *MPesaService) reconcilePush(ctx context.Context, tenantID, checkoutReqID string, createdAt time.Time, olderThan time.Duration, result *STKReconcileResult) error {
+	var stk *models.MpesaSTKRequest
+	var record models.MpesaSTKRequest
+	if err := s.db.Where("tenant_id = ? AND checkout_request_id = ?", tenantID, checkoutReqID).First(&record).Error; err == nil {
+		stk = &record
+	}
+
+	if stk == nil {
+		// Pushes from before they were recorded; anything else isn't an STK push
+		if !strings.HasPrefix(checkoutReqID, "ws_CO_") {
+			return nil
+		}
+	} else {
+		if stk.StatusConversationID != "" || stk.QueryAttempts >= maxSTKQueryAttempts {
+			return nil
+		}
+		if stk.LastQueriedAt != nil && time.Since(*stk.LastQueriedAt) < olderThan {
+			return nil
+		}
+	}
+	result.Checked++
+
+	creds, err := s.credentialsForPush(tenantID, stk)
+	if err != nil {
+		return err
+	}
+
+	resp, queryErr := s.querySTKPush(ctx, creds, checkoutReqID)
+	if stk != nil {
+		now := time.Now()
+		updates := map[string]interface{}{"query_attempts": stk.QueryAttempts + 1, "last_queried_at": &now}
+		if queryErr == nil {
+			updates["result_code"] = resp.ResultCode
+			updates["result_desc"] = resp.ResultDesc
+		}
+		s.db.Model(&models.MpesaSTKRequest{}).Where("id = ?", stk.ID).Updates(updates)
+	}
+
+	if queryErr != nil {
+		var darajaErr *DarajaError
+		if errors.As(queryErr, &darajaErr) && darajaErr.Code == stkQueryProcessing {
+			result.StillPending++
+			return nil
+		}
+		// Daraja forgets STK pushes after a while; ask for the transaction instead
+		if stk != nil && time.Since(createdAt) > transactionStatusAfter && creds.canQueryTransactionStatus() {
+			if err := s.requestTransactionStatus(ctx, creds, stk); err != nil {
+				return err
+			}
+			result.StatusQueries++
+			return nil
+		}
+		return queryErr
+	}
+
+	merchantReqID := resp.MerchantRequestID
+	if merchantReqID == "" && stk != nil {
+		merchantReqID = stk.MerchantRequestID
+	}
+	if merchantReqID == "" {
+		merchantReqID = checkoutReqID
+	}
+
+	if resp.ResultCode == "0" {
+		// The query has no receipt, so the payment keeps the CheckoutRequestID as its reference
+		if err := s.markPaymentCompleted(ctx, tenantID, merchantReqID, checkoutReqID, "", "", "", models.MpesaResolvedBySTKQuery); err != nil {
+			return err
+		}
+		result.Completed++
+		return nil
+	}
+
+	reason := resp.ResultDesc
+	if reason == "" {
+		reason = "M-Pesa result code " + resp.ResultCode
+	}
+	if err := s.markPaymentFailed(ctx, tenantID, merchantReqID, checkoutReqID, reason, models.MpesaResolvedBySTKQuery); err != nil {
+		return err
+	}
+	result.Failed++
+	return nil
+}
+
+// credentialsForPush returns the credentials an STK push was sent with, so a
+// tenant that switched Daraja apps since is still queried on the right shortcode
+func (s *MPesaService) credentialsForPush(tenantID string, stk *models.MpesaSTKRequest) (*mpesaCredentials, error) {
+	if stk != nil && stk.CredentialSource == models.MpesaCredentialsPlatform {
+		return s.credentialsFor("")
+	}
+	creds, err := s.credentialsFor(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if stk != nil && stk.ShortCode != "" && creds.shortCode != stk.ShortCode {
+		return nil, fmt.Errorf("shortcode changed from %s since the STK push", stk.ShortCode)
+	}
+	return creds, nil
+}
+
+func (c *mpesaCredentials) canQueryTransactionStatus() bool {
+	return c.initiatorName != "" && c.securityCredential != ""
+}
+
+// querySTKPush asks Daraja for the outcome of an STK push
+func (s *MPesaService) querySTKPush(ctx context.Context, creds *mpesaCredentials, checkoutReqID string) (*STKQueryResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	timestamp := time.Now().Format("20060102150405")
+	password, err := s.generatePassword(creds, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate password: %w", err)
+	}
+
+	var resp STKQueryResponse
+	if err := s.postDaraja(ctx, creds, "/mpesa/stkpushquery/v1/query", map[string]string{
+		"BusinessShortCode": creds.shortCode,
+		"Password":          password,
+		"Timestamp":         timestamp,
+		"CheckoutRequestID": checkoutReqID,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.ResponseCode != "" && resp.ResponseCode != "0" {
+		return nil, fmt.Errorf("STK query rejected: %s", resp.ResponseDescription)
+	}
+	return &resp, nil
+}
+
+// resultURL is the base of the tenant's asynchronous result URLs,
+// MPESA_RESULT_URL/{tenantID}/{token}
+func (s *MPesaService) resultURL(tenantID string) (string, error) {
+	base := strings.TrimRight(s.cfg.MPesa.ResultURL, "/")
+	if base == "" {
+		return "", errors.New("MPESA_RESULT_URL not configured - cannot query transaction status")
+	}
+	return base + "/" + tenantID + "/" + MpesaCallbackToken(s.cfg.MPesa.CallbackSecret, tenantID), nil
+}
+
+// queryTransactionStatus starts a Transaction Status query and returns its
+// conversation ID. Daraja posts the answer to resultPath under the tenant's result URL.
+func (s *MPesaService) queryTransactionStatus(ctx context.Context, creds *mpesaCredentials, tenantID, resultPath, timeoutPath string, query map[string]string) (string, error) {
+	base, err := s.resultURL(tenantID)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	req := map[string]string{
+		"Initiator":          creds.initiatorName,
+		"SecurityCredential": creds.securityCredential,
+		"CommandID":          "TransactionStatusQuery",
+		"PartyA":             creds.shortCode,
+		"IdentifierType":     "4", // organisation shortcode
+		"ResultURL":          base + resultPath,
+		"QueueTimeOutURL":    base + timeoutPath,
+	}
+	for k, v := range query {
+		req[k] = v
+	}
+
+	var resp struct {
+		OriginatorConversationID string `json:"OriginatorConversationID"`
+		ConversationID           string `json:"ConversationID"`
+		ResponseCode             string `json:"ResponseCode"`
+		ResponseDescription      string `json:"ResponseDescription"`
+	}
+	if err := s.postDaraja(ctx, creds, "/mpesa/transactionstatus/v1/query", req, &resp); err != nil {
+		return "", err
+	}
+	if resp.ResponseCode != "0" || resp.ConversationID == "" {
+		return "", fmt.Errorf("transaction status query rejected: %s", resp.ResponseDescription)
+	}
+	return resp.ConversationID, nil
+}
+
+// requestTransactionStatus starts a Transaction Status query for an STK push.
+// Daraja posts the answer to MPESA_RESULT_URL/{tenantID}/{token}/status.
+func (s *MPesaService) requestTransactionStatus(ctx context.Context, creds *mpesaCredentials, stk *models.MpesaSTKRequest) error {
+	conversationID, err := s.queryTransactionStatus(ctx, creds, stk.TenantID, "/status", "/timeout", map[string]string{
+		"OriginalConversationID": stk.MerchantRequestID,
+		"Remarks":                "STK push status",
+		"Occasion":               stk.AccountReference,
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Get().Info(ctx, "Transaction status requested", "tenant_id", stk.TenantID,
+		"checkout_request_id", stk.CheckoutRequestID, "conversation_id", conversationID)
+	return s.db.Model(&models.MpesaSTKRequest{}).Where("id = ?", stk.ID).
+		Update("status_conversation_id", conversationID).Error
+}
+
+// ProcessTransactionStatusResult settles the STK push a Transaction Status query was sent for
+func (s *MPesaService) ProcessTransactionStatusResult(ctx context.Context, tenantID string, result *MpesaResult) error {
+	stk, err := s.pendingStatusQuery(tenantID, result)
+	if err != nil {
+		return err
+	}
+	s.db.Model(&models.MpesaSTKRequest{}).Where("id = ?", stk.ID).Update("status_conversation_id", "")
+
+	r := result.Result
+	if r.ResultCode != 0 {
+		// Neither query knows of a transaction for this push: the customer never paid
+		return s.markPaymentFailed(ctx, tenantID, stk.MerchantRequestID, stk.CheckoutRequestID, r.ResultDesc, models.MpesaResolvedByTransactionStatus)
+	}
+
+	if status := result.Parameter("TransactionStatus"); status != "" && !strings.EqualFold(status, "Completed") {
+		return s.markPaymentFailed(ctx, tenantID, stk.MerchantRequestID, stk.CheckoutRequestID,
+			"M-Pesa transaction "+strings.ToLower(status), models.MpesaResolvedByTransactionStatus)
+	}
+
+	receipt := result.Parameter("ReceiptNo")
+	if receipt == "" {
+		receipt = r.TransactionID
+	}
+	return s.markPaymentCompleted(ctx, tenantID, stk.MerchantRequestID, stk.CheckoutRequestID, receipt, "",
+		result.Parameter("Amount"), models.MpesaResolvedByTransactionStatus)
+}
+
+// TransactionStatusTimeout frees a push whose Transaction Status query timed
+// out in Daraja's queue so the reconciler can ask again
+func (s *MPesaService) TransactionStatusTimeout(ctx context.Context, tenantID string, result *MpesaResult) error {
+	stk, err := s.pendingStatusQuery(tenantID, result)
+	if err != nil {
+		return err
+	}
+	logger.Get().Warn(ctx, "Transaction status query timed out", "tenant_id", tenantID, "checkout_request_id", stk.CheckoutRequestID)
+	return s.db.Model(&models.MpesaSTKRequest{}).Where("id = ?", stk.ID).Update("status_conversation_id", "").Error
+}
+
+func (s *MPesaService) pendingStatusQuery(tenantID string, result *MpesaResult) (*models.MpesaSTKRequest, error) {
+	var ids []string
+	for _, id := range []string{result.Result.ConversationID, result.Result.OriginatorConversationID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if tenantID == "" || len(ids) == 0 {
+		return nil, ErrMpesaResultUnknown
+	}
+
+	var stk models.MpesaSTKRequest
+	if err := s.db.Where("tenant_id = ? AND status_conversation_id IN ?", tenantID, ids).First(&stk).Error; err != nil {
+		return nil, ErrMpesaResultUnknown
+	}
+	return &stk, nil
+}
@@ -888,4 +888,35 @@ func (s *NotificationService) getTenantAdmins(tenantID string) ([]models.User, e
 	var users []models.User
 	err := s.db.Where("tenant_id = ? AND role = ?", tenantID, "admin").Find(&users).Error
 	return users, err
-}
\ No newline at end of file
+}
+// SendKRASubmissionFailedAlert tells tenant admins an invoice could not be registered with KRA
+// after exhausting its retries, so it can be fixed and resubmitted manually.
+func (s *NotificationService) SendKRASubmissionFailedAlert(tenantID, invoiceID, invoiceNumber, reason string) error {
+	admins, err := s.getTenantAdmins(tenantID)
+	if err != nil || len(admins) == 0 {
+		return nil
+	}
+
+	details := map[string]string{
+		"invoice_id":     invoiceID,
+		"invoice_number": invoiceNumber,
+		"error":          reason,
+	}
+
+	for _, admin := range admins {
+		req := &NotificationRequest{
+			TenantID:  tenantID,
+			UserID:    admin.ID,
+			EventType: EventKRARejected,
+			Channels:  []string{ChannelEmail},
+			Recipient: admin.Email,
+			Subject:   "KRA submission failed for invoice " + invoiceNumber,
+			Body:      "Invoice " + invoiceNumber + " could not be registered with KRA eTIMS after several attempts: " + reason,
+			Variables: details,
+			Reference: invoiceID,
+		}
+		s.Send(context.Background(), req)
+	}
+
+	return nil
+}
@@ -0,0 +1,541 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============================================================================
+// DOCUMENT NUMBERING - per-tenant patterns over gapless sequences
+// ============================================================================
+//
+// Invoice, credit note, debit note, receipt, quote and journal numbers all come
+// from the tenant's invoice_sequences row for the document type. The row is
+// locked and advanced inside the transaction that creates the document, so two
+// requests never get the same number and a document that is rolled back gives
+// its number back. The tenant's pattern decides how a number reads, e.g.
+// {PREFIX}/{FY}/{SEQ:5} gives INV/2026/00042, and a sequence can start again at
+// 1 when a new fiscal year begins. Numbers are unique per tenant only.
+
+var (
+	ErrNumberingDocumentType = errors.New("document type must be one of invoice, credit_note, debit_note, receipt, quote or journal")
+	ErrNumberingPattern      = errors.New("pattern must contain {SEQ} once, may only use {PREFIX}, {SEQ}, {SEQ:n}, {YYYY}, {YY}, {MM} and {FY}, and may only add letters, digits and - / _ .")
+	ErrNumberingPrefix       = errors.New("prefix must be 1 to 10 letters, digits or dashes")
+	ErrNumberingPadding      = errors.New("padding must be between 1 and 12")
+	ErrNumberingReset        = errors.New("a sequence that starts again every fiscal year needs {FY} in its pattern, or {YYYY} when the fiscal year starts in January")
+
+	errReceiptNumbered = errors.New("payment already has a receipt number")
+)
+
+const (
+	// defaultNumberPattern is used by sequences without a pattern: INV-000042
+	defaultNumberPattern = "{PREFIX}-{SEQ}"
+	maxNumberPattern     = 64
+)
+
+// numberedDocuments are the document types with a sequence, in the order they are
+// listed, and the prefix each starts with
+var numberedDocuments = []struct {
+	Type   string
+	Prefix string
+}{
+	{models.SequenceDocumentInvoice, "INV"},
+	{models.SequenceDocumentCreditNote, "CN"},
+	{models.SequenceDocumentDebitNote, "DN"},
+	{models.SequenceDocumentReceipt, "RCT"},
+	{models.SequenceDocumentQuote, "QUO"},
+	{models.SequenceDocumentJournal, "JE"},
+}
+
+var (
+	numberToken   = regexp.MustCompile(`\{([A-Z]+)(?::(\d+))?\}`)
+	numberPrefix  = regexp.MustCompile(`^[A-Za-z0-9-]{1,10}$`)
+	numberLiteral = regexp.MustCompile(`^[A-Za-z0-9/_.-]*$`)
+)
+
+// newSequence is the sequence a tenant starts with for a document type
+func newSequence(tenantID, documentType string) (*models.InvoiceSequence, error) {
+	for _, d := range numberedDocuments {
+		if d.Type == documentType {
+			return &models.InvoiceSequence{TenantID: tenantID, DocumentType: documentType, Prefix: d.Prefix, Padding: 6}, nil
+		}
+	}
+	return nil, ErrNumberingDocumentType
+}
+
+// lockSequence loads the tenant's sequence for a document type for update,
+// creating it on first use
+func lockSequence(tx *gorm.DB, tenantID, documentType string) (*models.InvoiceSequence, error) {
+	initial, err := newSequence(tenantID, documentType)
+	if err != nil {
+		return nil, err
+	}
+
+	var seq models.InvoiceSequence
+	find := func() *gorm.DB {
+		return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("tenant_id = ? AND document_type = ?", tenantID, documentType).
+			Limit(1).Find(&seq)
+	}
+	result := find()
+	if result.Error == nil && result.RowsAffected == 0 {
+		// A concurrent first use may create it first; either way it exists after this
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(initial).Error; err != nil {
+			return nil, fmt.Errorf("failed to create %s sequence: %w", documentType, err)
+		}
+		result = find()
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get %s sequence: %w", documentType, result.Error)
+	}
+	return &seq, nil
+}
+
+// fiscalStartMonth returns the month the tenant's fiscal year starts in
+func fiscalStartMonth(db *gorm.DB, tenantID string) (int, error) {
+	var config models.FiscalYearConfig
+	if err := db.Scopes(database.TenantFilter(tenantID)).Limit(1).Find(&config).Error; err != nil {
+		return 0, fmt.Errorf("failed to get fiscal year: %w", err)
+	}
+	if config.StartMonth < 1 || config.StartMonth > 12 {
+		return 1, nil
+	}
+	return config.StartMonth, nil
+}
+
+// issuingYear returns the fiscal year the next number is issued in and the last
+// number issued in that year. A sequence never goes back a year, and one that
+// resets starts again at 0 once its fiscal year has passed.
+func issuingYear(seq *models.InvoiceSequence, startMonth int, now time.Time) (int, int64) {
+	year := fiscalYearOf(now, startMonth)
+	if year < seq.FiscalYear {
+		year = seq.FiscalYear
+	}
+	if seq.ResetYearly && seq.FiscalYear != 0 && year > seq.FiscalYear {
+		return year, 0
+	}
+	return year, seq.LastSequenceNum
+}
+
+// sequenceYear is issuingYear for a sequence being advanced. Only sequences whose
+// numbers depend on the fiscal year look it up; for the rest the year is 0.
+func sequenceYear(tx *gorm.DB, seq *models.InvoiceSequence, now time.Time) (int, int64, error) {
+	if !seq.ResetYearly && !strings.Contains(sequencePattern(seq), "{FY}") {
+		return 0, seq.LastSequenceNum, nil
+	}
+	startMonth, err := fiscalStartMonth(tx, seq.TenantID)
+	if err != nil {
+		return 0, 0, err
+	}
+	year, last := issuingYear(seq, startMonth, now)
+	return year, last, nil
+}
+
+// nextDocumentNumber issues the tenant's next number for a document type with its
+// sequence number. It must run in the transaction that creates the document so
+// the number is given back if the document isn't created.
+func nextDocumentNumber(tx *gorm.DB, tenantID, documentType string) (string, int64, error) {
+	seq, err := lockSequence(tx, tenantID, documentType)
+	if err != nil {
+		return "", 0, err
+	}
+	now := time.Now()
+	year, last, err := sequenceYear(tx, seq, now)
+	if err != nil {
+		return "", 0, err
+	}
+
+	num := last + 1
+	updates := map[string]interface{}{"last_sequence_num": num}
+	if year != 0 {
+		updates["fiscal_year"] = year
+	}
+	if num > seq.HighestSequenceNum {
+		updates["highest_sequence_num"] = num
+	}
+	if err := tx.Model(&models.InvoiceSequence{}).Where("id = ?", seq.ID).Updates(updates).Error; err != nil {
+		return "", 0, fmt.Errorf("failed to update %s sequence: %w", documentType, err)
+	}
+	return formatDocumentNumber(seq, num, year, now), num, nil
+}
+
+// formatDocumentNumber renders a sequence number through the sequence's pattern.
+// {FY} is the year the fiscal year starts in; {YYYY}, {YY} and {MM} are the
+// calendar date the number is issued on.
+func formatDocumentNumber(seq *models.InvoiceSequence, num int64, fiscalYear int, at time.Time) string {
+	at = at.UTC()
+	return numberToken.ReplaceAllStringFunc(sequencePattern(seq), func(token string) string {
+		m := numberToken.FindStringSubmatch(token)
+		switch m[1] {
+		case "PREFIX":
+			return seq.Prefix
+		case "SEQ":
+			width := seq.Padding
+			if m[2] != "" {
+				width, _ = strconv.Atoi(m[2])
+			}
+			return fmt.Sprintf("%0*d", width, num)
+		case "YYYY":
+			return strconv.Itoa(at.Year())
+		case "YY":
+			return fmt.Sprintf("%02d", at.Year()%100)
+		case "MM":
+			return fmt.Sprintf("%02d", int(at.Month()))
+		case "FY":
+			return strconv.Itoa(fiscalYear)
+		}
+		return token
+	})
+}
+
+func sequencePattern(seq *models.InvoiceSequence) string {
+	if seq.Pattern == "" {
+		return defaultNumberPattern
+	}
+	return seq.Pattern
+}
+
+// validateNumberPattern checks a pattern only uses known tokens and has exactly one {SEQ}
+func validateNumberPattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if len(pattern) > maxNumberPattern {
+		return ErrNumberingPattern
+	}
+	seqs := 0
+	for _, m := range numberToken.FindAllStringSubmatch(pattern, -1) {
+		switch m[1] {
+		case "SEQ":
+			seqs++
+			if m[2] != "" {
+				if width, _ := strconv.Atoi(m[2]); width < 1 || width > 12 {
+					return ErrNumberingPattern
+				}
+			}
+		case "PREFIX", "YYYY", "YY", "MM", "FY":
+			if m[2] != "" {
+				return ErrNumberingPattern
+			}
+		default:
+			return ErrNumberingPattern
+		}
+	}
+	if seqs != 1 || !numberLiteral.MatchString(numberToken.ReplaceAllString(pattern, "")) {
+		return ErrNumberingPattern
+	}
+	return nil
+}
+
+// parseDocumentNumber reads the sequence number, and the year when the pattern
+// has one, out of a number issued under the sequence's pattern
+func parseDocumentNumber(seq *models.InvoiceSequence, number string) (int64, int, bool) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	pattern := sequencePattern(seq)
+	last := 0
+	for _, loc := range numberToken.FindAllStringSubmatchIndex(pattern, -1) {
+		expr.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		switch pattern[loc[2]:loc[3]] {
+		case "PREFIX":
+			expr.WriteString(regexp.QuoteMeta(seq.Prefix))
+		case "SEQ":
+			expr.WriteString(`(?P<seq>\d+)`)
+		case "YYYY":
+			expr.WriteString(`(?P<yyyy>\d{4})`)
+		case "FY":
+			expr.WriteString(`(?P<fy>\d{4})`)
+		default:
+			expr.WriteString(`\d{2}`)
+		}
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(pattern[last:]))
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return 0, 0, false
+	}
+	m := re.FindStringSubmatch(number)
+	if m == nil {
+		return 0, 0, false
+	}
+	var num int64
+	year := 0
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "seq":
+			num, err = strconv.ParseInt(m[i], 10, 64)
+			if err != nil {
+				return 0, 0, false
+			}
+		case "fy":
+			year, _ = strconv.Atoi(m[i])
+		case "yyyy":
+			if year == 0 {
+				year, _ = strconv.Atoi(m[i])
+			}
+		}
+	}
+	return num, year, true
+}
+
+// advanceSequencePast moves a sequence past numbers issued elsewhere, such as
+// invoices imported from another system, so new numbers don't collide with them.
+// Numbers that don't follow the tenant's pattern, or belong to another fiscal
+// year of a sequence that resets, are left alone.
+func advanceSequencePast(tx *gorm.DB, tenantID, documentType string, numbers []string) error {
+	seq, err := lockSequence(tx, tenantID, documentType)
+	if err != nil {
+		return err
+	}
+	year, highest, err := sequenceYear(tx, seq, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, number := range numbers {
+		num, numYear, ok := parseDocumentNumber(seq, number)
+		if !ok || (seq.ResetYearly && numYear != year) {
+			continue
+		}
+		if num > highest {
+			highest = num
+		}
+	}
+	if highest == seq.LastSequenceNum && (year == 0 || year == seq.FiscalYear) {
+		return nil
+	}
+	updates := map[string]interface{}{"last_sequence_num": highest}
+	if year != 0 {
+		updates["fiscal_year"] = year
+	}
+	if highest > seq.HighestSequenceNum {
+		updates["highest_sequence_num"] = highest
+	}
+	if err := tx.Model(&models.InvoiceSequence{}).Where("id = ?", seq.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to advance %s sequence: %w", documentType, err)
+	}
+	return nil
+}
+
+// numberReceipt gives a payment that has completed the tenant's next receipt
+// number. It must run in the transaction that saves the payment as completed,
+// before it is saved, so payments that never complete don't leave gaps in the
+// receipts and one that is rolled back gives its number back.
+func numberReceipt(tx *gorm.DB, payment *models.Payment) error {
+	if payment.ReceiptNumber != "" || payment.Status != models.PaymentStatusCompleted || payment.TenantID == "" {
+		return nil
+	}
+	number, _, err := nextDocumentNumber(tx, payment.TenantID, models.SequenceDocumentReceipt)
+	if err != nil {
+		return err
+	}
+	payment.ReceiptNumber = number
+	return nil
+}
+
+// issueReceiptNumber numbers the receipt of a payment that was completed
+// without one, such as before receipts were numbered on completion
+func issueReceiptNumber(db *gorm.DB, payment *models.Payment) error {
+	if payment.ReceiptNumber != "" || payment.Status != models.PaymentStatusCompleted {
+		return nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		number, _, err := nextDocumentNumber(tx, payment.TenantID, models.SequenceDocumentReceipt)
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&models.Payment{}).
+			Where("id = ? AND tenant_id = ? AND COALESCE(receipt_number, '') = ''", payment.ID, payment.TenantID).
+			Update("receipt_number", number)
+		if result.Error != nil {
+			return fmt.Errorf("failed to number receipt: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return errReceiptNumbered
+		}
+		payment.ReceiptNumber = number
+		return nil
+	})
+	if errors.Is(err, errReceiptNumbered) {
+		// Another request numbered it first and this number was given back
+		return db.Model(&models.Payment{}).Where("id = ?", payment.ID).Select("receipt_number").Scan(&payment.ReceiptNumber).Error
+	}
+	return err
+}
+
+// NumberingService manages how each tenant numbers its documents
+type NumberingService struct {
+	db *database.DB
+}
+
+func NewNumberingService(db *database.DB) *NumberingService {
+	return &NumberingService{db: db}
+}
+
+// NumberingScheme is a document type's sequence with the number it issues next
+type NumberingScheme struct {
+	models.InvoiceSequence
+	NextNumber string `json:"next_number"`
+}
+
+// NumberingUpdate changes how a document type is numbered; nil fields are left as they are
+type NumberingUpdate struct {
+	Prefix      *string
+	Pattern     *string
+	Padding     *int
+	ResetYearly *bool
+}
+
+// ListSchemes returns how each document type is numbered, including types that
+// haven't issued a number yet
+func (s *NumberingService) ListSchemes(tenantID string) ([]NumberingScheme, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	var sequences []models.InvoiceSequence
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Find(&sequences).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sequences: %w", err)
+	}
+	startMonth, err := fiscalStartMonth(s.db.DB, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	schemes := make([]NumberingScheme, 0, len(numberedDocuments))
+	for _, d := range numberedDocuments {
+		seq, _ := newSequence(tenantID, d.Type)
+		for i := range sequences {
+			if sequences[i].DocumentType == d.Type {
+				seq = &sequences[i]
+			}
+		}
+		schemes = append(schemes, numberingScheme(seq, startMonth, now))
+	}
+	return schemes, nil
+}
+
+// UpdateScheme changes a document type's prefix, pattern, padding or yearly reset.
+// The sequence carries on from the last number issued; turning the reset on
+// counts the current fiscal year on from the numbers already issued in it, and
+// turning it off carries on from the highest number issued in any year.
+func (s *NumberingService) UpdateScheme(tenantID, userID, documentType string, update NumberingUpdate) (*NumberingScheme, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	if _, err := newSequence(tenantID, documentType); err != nil {
+		return nil, err
+	}
+
+	var scheme NumberingScheme
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		seq, err := lockSequence(tx, tenantID, documentType)
+		if err != nil {
+			return err
+		}
+		startMonth, err := fiscalStartMonth(tx, tenantID)
+		if err != nil {
+			return err
+		}
+		previous := *seq
+
+		if update.Prefix != nil {
+			seq.Prefix = strings.TrimSpace(*update.Prefix)
+		}
+		if update.Pattern != nil {
+			seq.Pattern = strings.TrimSpace(*update.Pattern)
+		}
+		if update.Padding != nil {
+			seq.Padding = *update.Padding
+		}
+		if update.ResetYearly != nil {
+			seq.ResetYearly = *update.ResetYearly
+		}
+		if !numberPrefix.MatchString(seq.Prefix) {
+			return ErrNumberingPrefix
+		}
+		if seq.Padding < 1 || seq.Padding > 12 {
+			return ErrNumberingPadding
+		}
+		if err := validateNumberPattern(seq.Pattern); err != nil {
+			return err
+		}
+		if err := validateSequenceReset(seq, startMonth); err != nil {
+			return err
+		}
+		switch {
+		case seq.ResetYearly && !previous.ResetYearly:
+			// Count the current fiscal year from the numbers already issued in it
+			seq.FiscalYear, seq.LastSequenceNum = issuingYear(seq, startMonth, time.Now())
+		case !seq.ResetYearly && previous.ResetYearly:
+			// Numbers no longer say which year they're from, so carry on past every
+			// number issued, not just this year's
+			if seq.HighestSequenceNum > seq.LastSequenceNum {
+				seq.LastSequenceNum = seq.HighestSequenceNum
+			}
+		}
+
+		if err := tx.Model(&models.InvoiceSequence{}).Where("id = ?", seq.ID).Updates(map[string]interface{}{
+			"prefix":            seq.Prefix,
+			"pattern":           seq.Pattern,
+			"padding":           seq.Padding,
+			"reset_yearly":      seq.ResetYearly,
+			"fiscal_year":       seq.FiscalYear,
+			"last_sequence_num": seq.LastSequenceNum,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update %s numbering: %w", documentType, err)
+		}
+		scheme = numberingScheme(seq, startMonth, time.Now())
+
+		return tx.Create(periodAuditLog(tenantID, userID, AuditActionSettingsUpdate, AuditEntitySettings, seq.ID, map[string]interface{}{
+			"document_type":         documentType,
+			"prefix":                seq.Prefix,
+			"pattern":               seq.Pattern,
+			"padding":               seq.Padding,
+			"reset_yearly":          seq.ResetYearly,
+			"previous_prefix":       previous.Prefix,
+			"previous_pattern":      previous.Pattern,
+			"previous_padding":      previous.Padding,
+			"previous_reset_yearly": previous.ResetYearly,
+		})).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &scheme, nil
+}
+
+// validateSequenceReset checks a sequence that starts again every fiscal year
+// puts the year in its numbers, so a number never comes round twice
+func validateSequenceReset(seq *models.InvoiceSequence, startMonth int) error {
+	if !seq.ResetYearly {
+		return nil
+	}
+	pattern := sequencePattern(seq)
+	if !strings.Contains(pattern, "{FY}") && (startMonth != 1 || !strings.Contains(pattern, "{YYYY}")) {
+		return ErrNumberingReset
+	}
+	return nil
+}
+
+// numberingScheme previews the next number a sequence issues without taking it
+func numberingScheme(seq *models.InvoiceSequence, startMonth int, now time.Time) NumberingScheme {
+	year, last := issuingYear(seq, startMonth, now)
+	return NumberingScheme{
+		InvoiceSequence: *seq,
+		NextNumber:      formatDocumentNumber(seq, last+1, year, now),
+	}
+}
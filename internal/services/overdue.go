@@ -11,17 +11,29 @@ import (
 )
 
 type OverdueService struct {
-	db *database.DB
+	db        *database.DB
+	workflows *WorkflowEngine
 }
 
 func NewOverdueService(db *database.DB) *OverdueService {
 	return &OverdueService{db: db}
 }
 
+// SetWorkflowEngine wires the workflow event bus (optional)
+func (s *OverdueService) SetWorkflowEngine(engine *WorkflowEngine) {
+	s.workflows = engine
+}
+
 func (s *OverdueService) MarkOverdueInvoices() error {
 	logger.Get().Info(context.Background(), "Checking for overdue invoices")
 
 	now := time.Now()
+	excluded := []string{string(models.InvoiceStatusPaid), string(models.InvoiceStatusCancelled), string(models.InvoiceStatusDraft), string(models.InvoiceStatusOverdue)}
+
+	// Load the invoices that are about to flip so workflows get one event per invoice
+	var newlyOverdue []models.Invoice
+	s.db.Where("status NOT IN ? AND due_date < ? AND paid_amount < total", excluded, now).Find(&newlyOverdue)
+
 	result := s.db.Model(&models.Invoice{}).
 		Where("status NOT IN ? AND due_date < ? AND paid_amount < total",
 			[]string{string(models.InvoiceStatusPaid), string(models.InvoiceStatusCancelled), string(models.InvoiceStatusDraft)}, now).
@@ -33,6 +45,19 @@ func (s *OverdueService) MarkOverdueInvoices() error {
 		return fmt.Errorf("failed to mark overdue invoices: %w", result.Error)
 	}
 
+	for i := range newlyOverdue {
+		invoice := &newlyOverdue[i]
+		invoice.Status = models.InvoiceStatusOverdue
+		s.workflows.Publish(&WorkflowEvent{
+			TenantID:   invoice.TenantID,
+			UserID:     invoice.UserID,
+			Event:      models.TriggerEventInvoiceOverdue,
+			EntityType: "invoice",
+			EntityID:   invoice.ID,
+			Data:       invoiceEventData(invoice, nil),
+		})
+	}
+
 	logger.Get().Info(context.Background(), "Marked invoices as overdue", "count", result.RowsAffected)
 	return nil
 }
@@ -26,9 +26,11 @@ var (
 
 // PaymentService handles payment processing with proper security
 type PaymentService struct {
-	db  *database.DB
-	cfg *config.Config
-	log *logger.Logger
+	db        *database.DB
+	cfg       *config.Config
+	log       *logger.Logger
+	workflows *WorkflowEngine
+	periods   *PeriodService
 	// Note: Idempotency is handled via database constraints, not in-memory sync.Map
 }
 
@@ -41,6 +43,16 @@ func NewPaymentService(db *database.DB, cfg *config.Config) *PaymentService {
 	}
 }
 
+// SetWorkflowEngine wires the workflow event bus (optional)
+func (s *PaymentService) SetWorkflowEngine(engine *WorkflowEngine) {
+	s.workflows = engine
+}
+
+// SetPeriodService rejects reversing payments dated in closed accounting periods (optional)
+func (s *PaymentService) SetPeriodService(periods *PeriodService) {
+	s.periods = periods
+}
+
 // PaymentRequest represents a payment initiation request
 type PaymentRequest struct {
 	TenantID    string
@@ -161,7 +173,9 @@ func (s *PaymentService) CompletePaymentFromCallback(ctx context.Context, provid
 	// For now, assume the invoice was already created or we look it up
 
 	// Create payment record INSIDE transaction
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	var completedPayment *models.Payment
+	var paidInvoice models.Invoice
+	err = s.db.Transaction(func(tx *gorm.DB) error {
 		// Double-check idempotency within transaction
 		var count int64
 		tx.Model(&models.Payment{}).Where("reference = ? AND status = ?", receipt, models.PaymentStatusCompleted).Count(&count)
@@ -184,9 +198,9 @@ func (s *PaymentService) CompletePaymentFromCallback(ctx context.Context, provid
 		}
 
 		// Validate amount against invoice
-		var invoice models.Invoice
-		if err := tx.First(&invoice, "id = ?", payment.InvoiceID).Error; err != nil {
-			return ErrInvoiceNotFound
+		invoice, err := lockInvoice(tx, payment.TenantID, payment.InvoiceID)
+		if err != nil {
+			return err
 		}
 
 		remaining := invoice.Total.Subtract(invoice.PaidAmount).Float64()
@@ -203,27 +217,26 @@ func (s *PaymentService) CompletePaymentFromCallback(ctx context.Context, provid
 		payment.Reference = receipt
 		now := time.Now()
 		payment.CompletedAt = &now
+		if err := s.periods.CheckOpenTx(tx, payment.TenantID, now); err != nil {
+			return err
+		}
+		if err := numberReceipt(tx, payment); err != nil {
+			return err
+		}
 		if err := tx.Save(payment).Error; err != nil {
 			return fmt.Errorf("failed to update payment: %w", err)
 		}
 
 		// Update invoice status
-		invoice.PaidAmount = invoice.PaidAmount.Add(models.ToCents(amount))
-		if invoice.PaidAmount.GreaterThan(invoice.Total) || invoice.PaidAmount.Equals(invoice.Total) {
-			invoice.PaidAmount = invoice.Total
-			invoice.Status = models.InvoiceStatusPaid
-			now := time.Now()
-			invoice.PaidAt = &now
-		} else {
-			invoice.Status = models.InvoiceStatusPartiallyPaid
-		}
-		if err := tx.Save(&invoice).Error; err != nil {
-			return fmt.Errorf("failed to update invoice: %w", err)
+		if err := applyAllocation(tx, invoice, models.ToCents(amount), now); err != nil {
+			return err
 		}
 
 		// Update client totals
-		tx.Model(&models.Client{}).Where("id = ?", invoice.ClientID).
-			Update("total_paid", gorm.Expr("total_paid + ?", amount))
+		if err := tx.Model(&models.Client{}).Where("id = ?", invoice.ClientID).
+			Update("total_paid", gorm.Expr("total_paid + ?", models.ToCents(amount))).Error; err != nil {
+			return fmt.Errorf("failed to update client totals: %w", err)
+		}
 
 		s.log.Info(ctx, "Payment: Completed successfully",
 			"payment_id", payment.ID,
@@ -231,8 +244,52 @@ func (s *PaymentService) CompletePaymentFromCallback(ctx context.Context, provid
 			"amount", amount,
 		)
 
+		completedPayment = payment
+		paidInvoice = *invoice
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	if completedPayment != nil {
+		publishPaymentCompleted(s.workflows, completedPayment, &paidInvoice)
+	}
+	return nil
+}
+
+// publishPaymentCompleted emits payment_received, plus invoice_paid when the invoice is settled
+func publishPaymentCompleted(workflows *WorkflowEngine, payment *models.Payment, invoice *models.Invoice) {
+	workflows.Publish(&WorkflowEvent{
+		TenantID:   payment.TenantID,
+		UserID:     payment.UserID,
+		Event:      models.TriggerEventPaymentReceived,
+		EntityType: "payment",
+		EntityID:   payment.ID,
+		Data:       paymentEventData(payment, invoice),
+	})
+	if invoice.Status == models.InvoiceStatusPaid {
+		workflows.Publish(&WorkflowEvent{
+			TenantID:   payment.TenantID,
+			UserID:     payment.UserID,
+			Event:      models.TriggerEventInvoicePaid,
+			EntityType: "invoice",
+			EntityID:   invoice.ID,
+			Data:       invoiceEventData(invoice, nil),
+		})
+	}
+}
+
+// publishPaymentFailed emits payment_failed for a payment that did not go through
+func publishPaymentFailed(workflows *WorkflowEngine, payment *models.Payment) {
+	workflows.Publish(&WorkflowEvent{
+		TenantID:   payment.TenantID,
+		UserID:     payment.UserID,
+		Event:      models.TriggerEventPaymentFailed,
+		EntityType: "payment",
+		EntityID:   payment.ID,
+		Data:       paymentEventData(payment, nil),
+	})
 }
 
 // MarkPaymentFailed marks a payment as failed with proper tenant isolation
@@ -261,7 +318,12 @@ func (s *PaymentService) MarkPaymentFailed(ctx context.Context, tenantID, paymen
 	payment.Status = models.PaymentStatusFailed
 	payment.FailureReason = reason
 
-	return s.db.Save(&payment).Error
+	if err := s.db.Save(&payment).Error; err != nil {
+		return err
+	}
+
+	publishPaymentFailed(s.workflows, &payment)
+	return nil
 }
 
 // getInvoiceWithTenant retrieves invoice with proper tenant isolation
@@ -445,50 +507,95 @@ func (s *PaymentService) ReversePayment(ctx context.Context, tenantID, paymentID
 	}
 
 	return s.db.Transaction(func(tx *gorm.DB) error {
-		var payment models.Payment
-		if err := tx.Scopes(database.TenantFilter(tenantID)).First(&payment, "id = ?", paymentID).Error; err != nil {
-			return ErrPaymentNotFound
+		var completed []time.Time
+		if err := tx.Model(&models.Payment{}).Scopes(database.TenantFilter(tenantID)).
+			Where("id = ? AND completed_at IS NOT NULL", paymentID).Pluck("completed_at", &completed).Error; err != nil {
+			return err
 		}
-
-		if payment.Status != models.PaymentStatusCompleted {
-			return fmt.Errorf("can only reverse completed payments")
+		if err := s.periods.CheckOpenTx(tx, tenantID, completed...); err != nil {
+			return err
 		}
+		return reversePayment(tx, tenantID, paymentID, reason)
+	})
+}
 
-		// Mark payment as refunded
-		payment.Status = models.PaymentStatusRefunded
-		payment.FailureReason = reason
-		if err := tx.Save(&payment).Error; err != nil {
-			return err
+// reversePayment undoes a completed payment inside the caller's transaction
+func reversePayment(tx *gorm.DB, tenantID, paymentID, reason string) error {
+	var payment models.Payment
+	if err := tx.Scopes(database.TenantFilter(tenantID)).First(&payment, "id = ?", paymentID).Error; err != nil {
+		return ErrPaymentNotFound
+	}
+
+	if payment.Status != models.PaymentStatusCompleted {
+		return fmt.Errorf("can only reverse completed payments")
+	}
+
+	// Mark payment as refunded. Update only these columns; a full save would
+	// write back the decrypted phone number.
+	if err := tx.Model(&models.Payment{}).Where("id = ?", payment.ID).Updates(map[string]interface{}{
+		"status":         models.PaymentStatusRefunded,
+		"failure_reason": reason,
+	}).Error; err != nil {
+		return err
+	}
+
+	// A payment split across invoices gives back each invoice's share;
+	// whatever wasn't allocated went to client credit
+	var allocations []models.PaymentAllocation
+	if err := tx.Where("payment_id = ?", payment.ID).Find(&allocations).Error; err != nil {
+		return err
+	}
+	shares := map[string]models.Money{payment.InvoiceID: payment.Amount}
+	var credit models.Money
+	if len(allocations) > 0 {
+		shares = make(map[string]models.Money)
+		credit = payment.Amount
+		for _, a := range allocations {
+			shares[a.InvoiceID] = shares[a.InvoiceID].Add(a.Amount)
+			credit = credit.Sub(a.Amount)
 		}
+	}
 
+	var clientID string
+	for invoiceID, share := range shares {
 		// Reverse invoice amounts
-		var invoice models.Invoice
-		if err := tx.First(&invoice, "id = ?", payment.InvoiceID).Error; err != nil {
-			return ErrInvoiceNotFound
+		invoice, err := lockInvoice(tx, tenantID, invoiceID)
+		if err != nil {
+			return err
 		}
-
-		invoice.PaidAmount -= payment.Amount
-		if invoice.PaidAmount < 0 {
-			invoice.PaidAmount = 0
+		clientID = invoice.ClientID
+		if err := reverseAllocation(tx, invoice, share); err != nil {
+			return err
 		}
+	}
 
-		// Determine status based on remaining paid amount
-		if invoice.PaidAmount <= 0 {
-			invoice.Status = models.InvoiceStatusSent
-		} else {
-			invoice.Status = models.InvoiceStatusPartiallyPaid
+	// Reverse client totals
+	clientUpdates := map[string]interface{}{"total_paid": gorm.Expr("total_paid - ?", payment.Amount)}
+	if credit > 0 {
+		clientUpdates["credit_balance"] = gorm.Expr("credit_balance - ?", credit)
+	}
+	if err := tx.Model(&models.Client{}).Where("id = ?", clientID).UpdateColumns(clientUpdates).Error; err != nil {
+		return err
+	}
+	if credit > 0 {
+		var client models.Client
+		if err := tx.Select("id", "credit_balance").First(&client, "id = ?", clientID).Error; err != nil {
+			return err
 		}
-
-		if err := tx.Save(&invoice).Error; err != nil {
+		if err := tx.Create(&models.ClientCreditTransaction{
+			ID:           uuid.New().String(),
+			TenantID:     tenantID,
+			ClientID:     clientID,
+			PaymentID:    payment.ID,
+			Type:         models.ClientCreditRefund,
+			Amount:       -credit,
+			BalanceAfter: client.CreditBalance,
+		}).Error; err != nil {
 			return err
 		}
+	}
 
-		// Reverse client totals
-		tx.Model(&models.Client{}).Where("id = ?", invoice.ClientID).
-			Update("total_paid", gorm.Expr("total_paid - ?", payment.Amount))
-
-		return nil
-	})
+	return nil
 }
 
 // WebhookPayload represents an incoming payment webhook
@@ -566,31 +673,27 @@ func (s *PaymentService) completePaymentFromWebhook(payment *models.Payment, pay
 		payment.Reference = payload.Reference
 		now := time.Now()
 		payment.CompletedAt = &now
+		if err := s.periods.CheckOpenTx(tx, payment.TenantID, now); err != nil {
+			return err
+		}
+		if err := numberReceipt(tx, payment); err != nil {
+			return err
+		}
 		if err := tx.Save(payment).Error; err != nil {
 			return fmt.Errorf("failed to update payment: %w", err)
 		}
 
 		// Update invoice
-		var invoice models.Invoice
-		if err := tx.First(&invoice, "id = ?", payment.InvoiceID).Error; err != nil {
-			return ErrInvoiceNotFound
-		}
-
-		invoice.PaidAmount = invoice.PaidAmount.Add(models.ToCents(amount))
-		if invoice.PaidAmount.GreaterThan(invoice.Total) || invoice.PaidAmount.Equals(invoice.Total) {
-			invoice.PaidAmount = invoice.Total
-			invoice.Status = models.InvoiceStatusPaid
-			invoice.PaidAt = &now
-		} else {
-			invoice.Status = models.InvoiceStatusPartiallyPaid
-		}
-		if err := tx.Save(&invoice).Error; err != nil {
-			return fmt.Errorf("failed to update invoice: %w", err)
+		invoice, _, err := creditInvoice(tx, payment.TenantID, payment.InvoiceID, models.ToCents(amount), now)
+		if err != nil {
+			return err
 		}
 
 		// Update client
-		tx.Model(&models.Client{}).Where("id = ?", invoice.ClientID).
-			Update("total_paid", gorm.Expr("total_paid + ?", amount))
+		if err := tx.Model(&models.Client{}).Where("id = ?", invoice.ClientID).
+			Update("total_paid", gorm.Expr("total_paid + ?", models.ToCents(amount))).Error; err != nil {
+			return fmt.Errorf("failed to update client totals: %w", err)
+		}
 
 		return nil
 	})
@@ -612,17 +715,10 @@ func (s *PaymentService) reversePaymentFromWebhook(payment *models.Payment) erro
 		}
 
 		// Reverse invoice
-		var invoice models.Invoice
-		if err := tx.First(&invoice, "id = ?", payment.InvoiceID).Error; err != nil {
-			return ErrInvoiceNotFound
-		}
-
-		invoice.PaidAmount -= payment.Amount
-		if invoice.PaidAmount < 0 {
-			invoice.PaidAmount = 0
+		invoice, err := lockInvoice(tx, payment.TenantID, payment.InvoiceID)
+		if err != nil {
+			return err
 		}
-		invoice.Status = models.InvoiceStatusSent
-
-		return tx.Save(&invoice).Error
+		return reverseAllocation(tx, invoice, payment.Amount)
 	})
 }
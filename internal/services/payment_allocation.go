@@ -0,0 +1,613 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============================================================================
+// PAYMENT ALLOCATIONS - One payment split across several invoices
+// ============================================================================
+
+// allocationAttempts bounds retries when an invoice changes under an allocation
+const allocationAttempts = 3
+
+var (
+	ErrAllocationStrategy        = errors.New("strategy must be oldest_first, explicit or proportional")
+	ErrAllocationNoInvoices      = errors.New("no open invoices to allocate the payment to")
+	ErrAllocationExceedsPayment  = errors.New("allocations add up to more than the payment amount")
+	ErrAllocationConflict        = errors.New("invoice was updated by another payment, please retry")
+	ErrUnallocatedAlreadyMatched = errors.New("unallocated payment has already been matched")
+	ErrUnallocatedRejected       = errors.New("M-Pesa has no completed payment for this confirmation")
+)
+
+type AllocationLine struct {
+	InvoiceID string  `json:"invoice_id"`
+	Amount    float64 `json:"amount"`
+}
+
+// AllocatePaymentRequest records a payment from a client and splits it across
+// their invoices. Anything left over is kept as client credit.
+type AllocatePaymentRequest struct {
+	ClientID             string           `json:"client_id"`
+	UnallocatedPaymentID string           `json:"unallocated_payment_id"` // take amount and reference from an unmatched M-Pesa payment
+	Amount               float64          `json:"amount"`
+	Currency             string           `json:"currency"`
+	Method               string           `json:"method"`
+	Reference            string           `json:"reference"`
+	PhoneNumber          string           `json:"phone_number"`
+	Strategy             string           `json:"strategy"`    // oldest_first (default), explicit, proportional
+	InvoiceIDs           []string         `json:"invoice_ids"` // limit oldest_first/proportional to these invoices
+	Allocations          []AllocationLine `json:"allocations"` // explicit amounts per invoice
+	IdempotencyKey       string           `json:"idempotency_key"`
+}
+
+type AllocationResult struct {
+	Payment       *models.Payment            `json:"payment"`
+	Allocations   []models.PaymentAllocation `json:"allocations"`
+	Credit        models.Money               `json:"credit"`
+	CreditBalance models.Money               `json:"credit_balance"`
+}
+
+type plannedAllocation struct {
+	invoice *models.Invoice
+	amount  models.Money
+}
+
+// SetWorkflowEngine publishes payment events for allocated payments
+func (s *PaymentMatchingService) SetWorkflowEngine(engine *WorkflowEngine) {
+	s.workflows = engine
+}
+
+// SetPeriodService rejects allocating payments into closed accounting periods (optional)
+func (s *PaymentMatchingService) SetPeriodService(periods *PeriodService) {
+	s.periods = periods
+}
+
+// AllocatePayment records one payment and applies it to the client's invoices
+func (s *PaymentMatchingService) AllocatePayment(tenantID, userID string, req *AllocatePaymentRequest) (*AllocationResult, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	if strings.TrimSpace(req.ClientID) == "" {
+		return nil, errors.New("client ID is required")
+	}
+	if req.Strategy == "" {
+		req.Strategy = models.AllocationStrategyOldestFirst
+		if len(req.Allocations) > 0 {
+			req.Strategy = models.AllocationStrategyExplicit
+		}
+	}
+	switch req.Strategy {
+	case models.AllocationStrategyOldestFirst, models.AllocationStrategyProportional:
+	case models.AllocationStrategyExplicit:
+		if len(req.Allocations) == 0 {
+			return nil, errors.New("explicit allocation requires allocations")
+		}
+	default:
+		return nil, ErrAllocationStrategy
+	}
+	if req.UnallocatedPaymentID == "" && req.Amount <= 0 {
+		return nil, errors.New("payment amount must be positive")
+	}
+
+	if req.IdempotencyKey != "" {
+		var existing models.Payment
+		if err := s.db.Where("tenant_id = ? AND idempotency_key = ?", tenantID, req.IdempotencyKey).
+			First(&existing).Error; err == nil {
+			return s.allocationResult(tenantID, &existing)
+		}
+	}
+
+	var result *AllocationResult
+	var planned []plannedAllocation
+	var err error
+	for attempt := 1; attempt <= allocationAttempts; attempt++ {
+		result, planned, err = s.allocate(tenantID, userID, req)
+		if !errors.Is(err, ErrAllocationConflict) {
+			break
+		}
+		logger.Get().Warn(context.Background(), "Retrying payment allocation after concurrent update", "tenant_id", tenantID, "attempt", attempt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range planned {
+		share := *result.Payment
+		share.Amount = p.amount
+		publishPaymentCompleted(s.workflows, &share, p.invoice)
+	}
+
+	logger.Get().Info(context.Background(), "Payment allocated", "tenant_id", tenantID, "payment_id", result.Payment.ID,
+		"invoices", len(result.Allocations), "credit", result.Credit.Float64())
+	return result, nil
+}
+
+func (s *PaymentMatchingService) allocate(tenantID, userID string, req *AllocatePaymentRequest) (*AllocationResult, []plannedAllocation, error) {
+	var result *AllocationResult
+	var planned []plannedAllocation
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := s.periods.CheckOpenTx(tx, tenantID, now); err != nil {
+			return err
+		}
+		payment := &models.Payment{
+			ID:             uuid.New().String(),
+			TenantID:       tenantID,
+			UserID:         userID,
+			Amount:         models.ToCents(req.Amount),
+			Currency:       getValidCurrency(req.Currency),
+			Method:         models.PaymentMethodBank,
+			Status:         models.PaymentStatusCompleted,
+			Reference:      strings.TrimSpace(req.Reference),
+			PhoneNumber:    strings.TrimSpace(req.PhoneNumber),
+			IdempotencyKey: req.IdempotencyKey,
+			CompletedAt:    &now,
+		}
+		if m := strings.ToLower(strings.TrimSpace(req.Method)); m != "" {
+			payment.Method = models.PaymentMethod(getValidPaymentMethod(m))
+		}
+
+		if req.UnallocatedPaymentID != "" {
+			var unallocated models.UnallocatedPayment
+			if err := tx.Scopes(database.TenantFilter(tenantID)).
+				First(&unallocated, "id = ?", req.UnallocatedPaymentID).Error; err != nil {
+				return fmt.Errorf("unallocated payment not found: %w", err)
+			}
+			if unallocated.Verification == models.C2BVerificationRejected {
+				return ErrUnallocatedRejected
+			}
+			claim := tx.Model(&models.UnallocatedPayment{}).
+				Where("id = ? AND is_matched = ?", unallocated.ID, false).
+				Updates(map[string]interface{}{"is_matched": true, "matched_at": now, "matched_by": userID})
+			if claim.Error != nil {
+				return fmt.Errorf("failed to claim unallocated payment: %w", claim.Error)
+			}
+			if claim.RowsAffected == 0 {
+				return ErrUnallocatedAlreadyMatched
+			}
+			payment.Amount = unallocated.Amount
+			payment.Currency = unallocated.Currency
+			payment.Method = models.PaymentMethodMpesa
+			payment.Reference = unallocated.Reference
+			payment.PhoneNumber = unallocated.PhoneNumber
+		}
+
+		// Lock the client first so concurrent allocations for the same client run one at a time
+		var client models.Client
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(database.TenantFilter(tenantID)).
+			First(&client, "id = ?", req.ClientID).Error; err != nil {
+			return fmt.Errorf("client not found: %w", err)
+		}
+
+		invoices, err := s.allocationCandidates(tx, tenantID, client.ID, payment.Currency, req)
+		if err != nil {
+			return err
+		}
+
+		explicit := make(map[string]models.Money, len(req.Allocations))
+		for _, line := range req.Allocations {
+			explicit[line.InvoiceID] = explicit[line.InvoiceID].Add(models.ToCents(line.Amount))
+		}
+		var credit models.Money
+		planned, credit, err = planAllocations(req.Strategy, payment.Amount, invoices, explicit)
+		if err != nil {
+			return err
+		}
+		if len(planned) == 0 {
+			return ErrAllocationNoInvoices
+		}
+
+		payment.InvoiceID = planned[0].invoice.ID
+		if err := numberReceipt(tx, payment); err != nil {
+			return err
+		}
+		phone := payment.PhoneNumber
+		if err := tx.Create(payment).Error; err != nil {
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+		payment.PhoneNumber = phone // BeforeCreate leaves the stored ciphertext on the struct
+
+		result = &AllocationResult{Payment: payment, Credit: credit}
+		for _, p := range planned {
+			if err := applyAllocation(tx, p.invoice, p.amount, now); err != nil {
+				return err
+			}
+			allocation := models.PaymentAllocation{
+				ID:            uuid.New().String(),
+				TenantID:      tenantID,
+				PaymentID:     payment.ID,
+				InvoiceID:     p.invoice.ID,
+				ClientID:      client.ID,
+				Amount:        p.amount,
+				CreatedBy:     userID,
+				InvoiceNumber: p.invoice.InvoiceNumber,
+			}
+			if err := tx.Create(&allocation).Error; err != nil {
+				return fmt.Errorf("failed to create allocation: %w", err)
+			}
+			result.Allocations = append(result.Allocations, allocation)
+		}
+
+		clientUpdates := map[string]interface{}{
+			"total_paid":        gorm.Expr("total_paid + ?", payment.Amount),
+			"last_payment_date": now,
+		}
+		if credit > 0 {
+			clientUpdates["credit_balance"] = gorm.Expr("credit_balance + ?", credit)
+		}
+		if err := tx.Model(&models.Client{}).Where("id = ? AND tenant_id = ?", client.ID, tenantID).
+			UpdateColumns(clientUpdates).Error; err != nil {
+			return fmt.Errorf("failed to update client totals: %w", err)
+		}
+		result.CreditBalance = client.CreditBalance.Add(credit)
+
+		if credit > 0 {
+			if err := tx.Create(&models.ClientCreditTransaction{
+				ID:           uuid.New().String(),
+				TenantID:     tenantID,
+				ClientID:     client.ID,
+				PaymentID:    payment.ID,
+				Type:         models.ClientCreditOverpayment,
+				Amount:       credit,
+				BalanceAfter: result.CreditBalance,
+				CreatedBy:    userID,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to record client credit: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, planned, nil
+}
+
+// allocationCandidates loads and locks the client's open invoices, oldest due first
+func (s *PaymentMatchingService) allocationCandidates(tx *gorm.DB, tenantID, clientID, currency string, req *AllocatePaymentRequest) ([]models.Invoice, error) {
+	ids := req.InvoiceIDs
+	if req.Strategy == models.AllocationStrategyExplicit {
+		ids = nil
+		for _, line := range req.Allocations {
+			ids = append(ids, line.InvoiceID)
+		}
+	}
+
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(database.TenantFilter(tenantID)).
+		Where("client_id = ? AND invoice_type <> ? AND status IN ?", clientID, "credit_note", []models.InvoiceStatus{
+			models.InvoiceStatusSent, models.InvoiceStatusViewed,
+			models.InvoiceStatusPartiallyPaid, models.InvoiceStatusOverdue,
+		})
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+
+	var invoices []models.Invoice
+	if err := query.Order("due_date ASC, created_at ASC").Find(&invoices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+
+	if len(ids) > 0 {
+		found := make(map[string]bool, len(invoices))
+		for _, inv := range invoices {
+			found[inv.ID] = true
+		}
+		for _, id := range ids {
+			if !found[id] {
+				return nil, fmt.Errorf("invoice %s is not an open invoice of this client", id)
+			}
+		}
+	}
+	for _, inv := range invoices {
+		if inv.Currency != "" && inv.Currency != currency {
+			return nil, fmt.Errorf("invoice %s is in %s but the payment is in %s", inv.InvoiceNumber, inv.Currency, currency)
+		}
+	}
+	return invoices, nil
+}
+
+// planAllocations splits amount across invoices (already in oldest-first order)
+// and returns what is left over for client credit
+func planAllocations(strategy string, amount models.Money, invoices []models.Invoice, explicit map[string]models.Money) ([]plannedAllocation, models.Money, error) {
+	var planned []plannedAllocation
+	remaining := amount
+
+	switch strategy {
+	case models.AllocationStrategyOldestFirst:
+		for i := range invoices {
+			if remaining <= 0 {
+				break
+			}
+			balance := invoices[i].Total.Sub(invoices[i].PaidAmount)
+			if balance <= 0 {
+				continue
+			}
+			share := balance
+			if remaining.LessThan(balance) {
+				share = remaining
+			}
+			planned = append(planned, plannedAllocation{invoice: &invoices[i], amount: share})
+			remaining = remaining.Sub(share)
+		}
+
+	case models.AllocationStrategyExplicit:
+		for i := range invoices {
+			share := explicit[invoices[i].ID]
+			if share <= 0 {
+				return nil, 0, fmt.Errorf("allocation for invoice %s must be positive", invoices[i].InvoiceNumber)
+			}
+			balance := invoices[i].Total.Sub(invoices[i].PaidAmount)
+			if share.GreaterThan(balance) {
+				return nil, 0, fmt.Errorf("allocation of %.2f exceeds the %.2f due on invoice %s",
+					share.Float64(), balance.Float64(), invoices[i].InvoiceNumber)
+			}
+			planned = append(planned, plannedAllocation{invoice: &invoices[i], amount: share})
+			remaining = remaining.Sub(share)
+		}
+		if remaining < 0 {
+			return nil, 0, ErrAllocationExceedsPayment
+		}
+
+	case models.AllocationStrategyProportional:
+		var totalDue models.Money
+		for i := range invoices {
+			if balance := invoices[i].Total.Sub(invoices[i].PaidAmount); balance > 0 {
+				totalDue = totalDue.Add(balance)
+			}
+		}
+		if totalDue <= 0 {
+			return nil, amount, nil
+		}
+		if !amount.LessThan(totalDue) {
+			// Enough to settle everything; the rest is credit
+			return planAllocations(models.AllocationStrategyOldestFirst, amount, invoices, nil)
+		}
+
+		shares := make([]models.Money, len(invoices))
+		balances := make([]models.Money, len(invoices))
+		for i := range invoices {
+			balances[i] = invoices[i].Total.Sub(invoices[i].PaidAmount)
+			if balances[i] <= 0 {
+				continue
+			}
+			shares[i] = models.Money(math.Floor(float64(amount) * float64(balances[i]) / float64(totalDue)))
+			remaining = remaining.Sub(shares[i])
+		}
+		// Rounding leaves a few cents; hand them out oldest first
+		for remaining > 0 {
+			progressed := false
+			for i := range shares {
+				if remaining <= 0 {
+					break
+				}
+				if shares[i] < balances[i] {
+					shares[i]++
+					remaining--
+					progressed = true
+				}
+			}
+			if !progressed {
+				break
+			}
+		}
+		for i := range invoices {
+			if shares[i] > 0 {
+				planned = append(planned, plannedAllocation{invoice: &invoices[i], amount: shares[i]})
+			}
+		}
+
+	default:
+		return nil, 0, ErrAllocationStrategy
+	}
+
+	return planned, remaining, nil
+}
+
+// applyAllocation adds amount to an invoice locked by the caller. The version
+// check rejects the write if another payment updated the invoice first.
+func applyAllocation(tx *gorm.DB, invoice *models.Invoice, amount models.Money, now time.Time) error {
+	paid := invoice.PaidAmount.Add(amount)
+	status := models.InvoiceStatusPartiallyPaid
+	updates := map[string]interface{}{
+		"paid_amount": paid,
+		"balance_due": invoice.Total.Sub(paid),
+		"version":     invoice.Version + 1,
+	}
+	if !paid.LessThan(invoice.Total) {
+		status = models.InvoiceStatusPaid
+		updates["paid_at"] = now
+	}
+	if invoice.Status != status {
+		if err := models.ValidateTransition(invoice.Status, status); err != nil {
+			return err
+		}
+	}
+	updates["status"] = status
+
+	res := tx.Model(&models.Invoice{}).Where("id = ? AND version = ?", invoice.ID, invoice.Version).Updates(updates)
+	if res.Error != nil {
+		return fmt.Errorf("failed to update invoice: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrAllocationConflict
+	}
+
+	invoice.PaidAmount = paid
+	invoice.BalanceDue = invoice.Total.Sub(paid)
+	invoice.Status = status
+	invoice.Version++
+	if status == models.InvoiceStatusPaid {
+		invoice.PaidAt = &now
+	}
+	return nil
+}
+
+// lockInvoice loads an invoice for update. Every change to what an invoice has
+// been paid goes through it and a versioned update (applyAllocation,
+// reverseAllocation), so concurrent payments apply one after the other.
+func lockInvoice(tx *gorm.DB, tenantID, invoiceID string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(database.TenantFilter(tenantID)).
+		First(&invoice, "id = ?", invoiceID).Error; err != nil {
+		return nil, ErrInvoiceNotFound
+	}
+	return &invoice, nil
+}
+
+// creditInvoice applies a completed payment to an invoice. Anything over the
+// balance due is not applied; the amount that was is returned.
+func creditInvoice(tx *gorm.DB, tenantID, invoiceID string, amount models.Money, now time.Time) (*models.Invoice, models.Money, error) {
+	invoice, err := lockInvoice(tx, tenantID, invoiceID)
+	if err != nil {
+		return nil, 0, err
+	}
+	applied := amount
+	if due := invoice.Total.Sub(invoice.PaidAmount); due.LessThan(applied) {
+		applied = due
+	}
+	if applied <= 0 {
+		return invoice, 0, nil
+	}
+	if err := applyAllocation(tx, invoice, applied, now); err != nil {
+		return nil, 0, err
+	}
+	return invoice, applied, nil
+}
+
+// addClientCredit keeps what a payment brought in over its invoice's balance as
+// credit for the client, locking the client so the running balance stays in order
+func addClientCredit(tx *gorm.DB, tenantID, clientID, paymentID string, amount models.Money, createdBy string) error {
+	var client models.Client
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(database.TenantFilter(tenantID)).
+		First(&client, "id = ?", clientID).Error; err != nil {
+		return fmt.Errorf("client not found: %w", err)
+	}
+	if err := tx.Model(&models.Client{}).Where("id = ? AND tenant_id = ?", client.ID, tenantID).
+		UpdateColumn("credit_balance", gorm.Expr("credit_balance + ?", amount)).Error; err != nil {
+		return fmt.Errorf("failed to update client credit: %w", err)
+	}
+	if err := tx.Create(&models.ClientCreditTransaction{
+		ID:           uuid.New().String(),
+		TenantID:     tenantID,
+		ClientID:     client.ID,
+		PaymentID:    paymentID,
+		Type:         models.ClientCreditOverpayment,
+		Amount:       amount,
+		BalanceAfter: client.CreditBalance.Add(amount),
+		CreatedBy:    createdBy,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to record client credit: %w", err)
+	}
+	return nil
+}
+
+// reverseAllocation takes a reversed payment's share back off an invoice
+func reverseAllocation(tx *gorm.DB, invoice *models.Invoice, amount models.Money) error {
+	paid := invoice.PaidAmount.Sub(amount)
+	if paid < 0 {
+		paid = 0
+	}
+	status := models.InvoiceStatusPartiallyPaid
+	if paid <= 0 {
+		status = models.InvoiceStatusSent
+	}
+
+	res := tx.Model(&models.Invoice{}).Where("id = ? AND version = ?", invoice.ID, invoice.Version).Updates(map[string]interface{}{
+		"paid_amount": paid,
+		"balance_due": invoice.Total.Sub(paid),
+		"status":      status,
+		"paid_at":     nil,
+		"version":     invoice.Version + 1,
+	})
+	if res.Error != nil {
+		return fmt.Errorf("failed to update invoice: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrAllocationConflict
+	}
+
+	invoice.PaidAmount = paid
+	invoice.BalanceDue = invoice.Total.Sub(paid)
+	invoice.Status = status
+	invoice.PaidAt = nil
+	invoice.Version++
+	return nil
+}
+
+// GetAllocations returns how a payment was split across invoices
+func (s *PaymentMatchingService) GetAllocations(tenantID, paymentID string) ([]models.PaymentAllocation, error) {
+	var allocations []models.PaymentAllocation
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Where("payment_id = ?", paymentID).
+		Order("created_at ASC").Find(&allocations).Error; err != nil {
+		return nil, fmt.Errorf("failed to load allocations: %w", err)
+	}
+	s.attachInvoiceNumbers(tenantID, allocations)
+	return allocations, nil
+}
+
+// GetClientCredit returns a client's credit balance and its history
+func (s *PaymentMatchingService) GetClientCredit(tenantID, clientID string) (models.Money, []models.ClientCreditTransaction, error) {
+	client, err := s.GetClientByID(tenantID, clientID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var history []models.ClientCreditTransaction
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Where("client_id = ?", clientID).
+		Order("created_at DESC").Find(&history).Error; err != nil {
+		return 0, nil, fmt.Errorf("failed to load credit history: %w", err)
+	}
+	return client.CreditBalance, history, nil
+}
+
+func (s *PaymentMatchingService) allocationResult(tenantID string, payment *models.Payment) (*AllocationResult, error) {
+	allocations, err := s.GetAllocations(tenantID, payment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AllocationResult{Payment: payment, Allocations: allocations}
+	var credit models.ClientCreditTransaction
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("payment_id = ? AND type = ?", payment.ID, models.ClientCreditOverpayment).
+		First(&credit).Error; err == nil {
+		result.Credit = credit.Amount
+		result.CreditBalance = credit.BalanceAfter
+	}
+	return result, nil
+}
+
+func (s *PaymentMatchingService) attachInvoiceNumbers(tenantID string, allocations []models.PaymentAllocation) {
+	if len(allocations) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(allocations))
+	for _, a := range allocations {
+		ids = append(ids, a.InvoiceID)
+	}
+
+	var invoices []models.Invoice
+	s.db.Scopes(database.TenantFilter(tenantID)).Select("id", "invoice_number").Where("id IN ?", ids).Find(&invoices)
+	numbers := make(map[string]string, len(invoices))
+	for _, inv := range invoices {
+		numbers[inv.ID] = inv.InvoiceNumber
+	}
+	for i := range allocations {
+		allocations[i].InvoiceNumber = numbers[allocations[i].InvoiceID]
+	}
+}
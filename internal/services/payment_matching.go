@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"invoicefast/internal/database"
@@ -15,6 +16,8 @@ import (
 type PaymentMatchingService struct {
 	db        *database.DB
 	notifySvc *NotificationService
+	workflows *WorkflowEngine
+	periods   *PeriodService
 }
 
 func NewPaymentMatchingService(db *database.DB, notifySvc *NotificationService) *PaymentMatchingService {
@@ -148,10 +151,17 @@ func (s *PaymentMatchingService) MatchPayment(tenantID, paymentID, invoiceID, us
 		if err := tx.First(&unallocated, "id = ? AND is_matched = ?", paymentID, false).Error; err != nil {
 			return fmt.Errorf("unallocated payment not found: %w", err)
 		}
+		if unallocated.Verification == models.C2BVerificationRejected {
+			return ErrUnallocatedRejected
+		}
+		now := time.Now()
+		if err := s.periods.CheckOpenTx(tx, unallocated.TenantID, now); err != nil {
+			return err
+		}
 
-		var invoice models.Invoice
-		if err := tx.First(&invoice, "id = ? AND tenant_id = ?", invoiceID, unallocated.TenantID).Error; err != nil {
-			return fmt.Errorf("invoice not found: %w", err)
+		invoice, err := lockInvoice(tx, unallocated.TenantID, invoiceID)
+		if err != nil {
+			return err
 		}
 
 		payment := &models.Payment{
@@ -166,23 +176,17 @@ func (s *PaymentMatchingService) MatchPayment(tenantID, paymentID, invoiceID, us
 			Reference:   unallocated.Reference,
 			PhoneNumber: unallocated.PhoneNumber,
 		}
-		now := time.Now()
 		payment.CompletedAt = &now
 
+		if err := numberReceipt(tx, payment); err != nil {
+			return err
+		}
 		if err := tx.Create(payment).Error; err != nil {
 			return fmt.Errorf("failed to create payment: %w", err)
 		}
 
-		invoice.PaidAmount += unallocated.Amount
-		if invoice.PaidAmount >= invoice.Total {
-			invoice.Status = models.InvoiceStatusPaid
-			invoice.PaidAt = &now
-		} else {
-			invoice.Status = models.InvoiceStatusPartiallyPaid
-		}
-
-		if err := tx.Save(&invoice).Error; err != nil {
-			return fmt.Errorf("failed to update invoice: %w", err)
+		if err := applyAllocation(tx, invoice, unallocated.Amount, now); err != nil {
+			return err
 		}
 
 		if err := tx.Model(&unallocated).Updates(map[string]interface{}{
@@ -193,16 +197,20 @@ func (s *PaymentMatchingService) MatchPayment(tenantID, paymentID, invoiceID, us
 			return fmt.Errorf("failed to update unallocated: %w", err)
 		}
 
-		s.sendMatchNotification(unallocated.TenantID, &invoice, EventPaymentMatched)
+		s.sendMatchNotification(unallocated.TenantID, invoice, EventPaymentMatched)
 		return nil
 	})
 }
 
 func (s *PaymentMatchingService) ManualMatch(tenantID, invoiceID, reference, phone string, amount float64, userID string) error {
 	return s.db.Transaction(func(tx *gorm.DB) error {
-		var invoice models.Invoice
-		if err := tx.First(&invoice, "id = ? AND tenant_id = ?", invoiceID, tenantID).Error; err != nil {
-			return fmt.Errorf("invoice not found: %w", err)
+		now := time.Now()
+		if err := s.periods.CheckOpenTx(tx, tenantID, now); err != nil {
+			return err
+		}
+		invoice, err := lockInvoice(tx, tenantID, invoiceID)
+		if err != nil {
+			return err
 		}
 
 		payment := &models.Payment{
@@ -217,42 +225,176 @@ func (s *PaymentMatchingService) ManualMatch(tenantID, invoiceID, reference, pho
 			Reference:   reference,
 			PhoneNumber: phone,
 		}
-		now := time.Now()
 		payment.CompletedAt = &now
 
+		if err := numberReceipt(tx, payment); err != nil {
+			return err
+		}
 		if err := tx.Create(payment).Error; err != nil {
 			return fmt.Errorf("failed to create payment: %w", err)
 		}
 
-		invoice.PaidAmount = invoice.PaidAmount.Add(models.ToCents(amount))
-		if invoice.PaidAmount.GreaterThan(invoice.Total) || invoice.PaidAmount.Equals(invoice.Total) {
-			invoice.Status = models.InvoiceStatusPaid
-			invoice.PaidAt = &now
-		} else {
-			invoice.Status = models.InvoiceStatusPartiallyPaid
+		if err := applyAllocation(tx, invoice, models.ToCents(amount), now); err != nil {
+			return err
+		}
+
+		s.sendMatchNotification(tenantID, invoice, EventPaymentMatched)
+		return nil
+	})
+}
+
+// Ways a payment reference can point at an invoice
+const (
+	MatchOnInvoiceNumber  = "invoice_number"
+	MatchOnPhone          = "phone"
+	MatchOnFuzzyReference = "fuzzy_reference"
+)
+
+// InvoiceMatch is the open invoice a payment most likely belongs to
+type InvoiceMatch struct {
+	Invoice   *models.Invoice `json:"invoice"`
+	MatchedOn string          `json:"matched_on"`
+	Score     int             `json:"score"`     // 0-100
+	Automatic bool            `json:"automatic"` // confident enough to apply without review
+}
+
+// FindUnpaidByReference finds the open invoice a paybill payment is for, trying
+// the invoice number first, then the client's phone, then a near-miss reference.
+// Returns nil when nothing plausible is found.
+func (s *PaymentMatchingService) FindUnpaidByReference(tenantID, reference, phone string) (*InvoiceMatch, error) {
+	var invoices []models.Invoice
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("invoice_type <> ? AND status IN ?", "credit_note", []models.InvoiceStatus{
+			models.InvoiceStatusSent, models.InvoiceStatusViewed,
+			models.InvoiceStatusPartiallyPaid, models.InvoiceStatusOverdue,
+		}).
+		Order("due_date ASC, created_at ASC").Find(&invoices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load open invoices: %w", err)
+	}
+	if len(invoices) == 0 {
+		return nil, nil
+	}
+
+	ref := normalizeReference(reference)
+	if ref != "" {
+		for i := range invoices {
+			if normalizeReference(invoices[i].InvoiceNumber) == ref {
+				return &InvoiceMatch{Invoice: &invoices[i], MatchedOn: MatchOnInvoiceNumber, Score: 100, Automatic: true}, nil
+			}
+		}
+	}
+
+	// Customers often type their phone number as the account, so try both
+	phones := map[string]bool{}
+	for _, p := range []string{phone, reference} {
+		if n := normalizePhone(p); len(n) == 12 {
+			phones[n] = true
+		}
+	}
+	if len(phones) > 0 {
+		if match := s.matchByPhone(tenantID, invoices, phones); match != nil {
+			return match, nil
 		}
+	}
 
-		if err := tx.Save(&invoice).Error; err != nil {
-			return fmt.Errorf("failed to update invoice: %w", err)
+	// Near misses: same digits ("inv 123" for INV-000123) or a typo or two
+	var best *InvoiceMatch
+	if ref != "" {
+		refDigits := strings.TrimLeft(digitsOnly(ref), "0")
+		for i := range invoices {
+			number := normalizeReference(invoices[i].InvoiceNumber)
+			score := 0
+			if refDigits != "" && refDigits == strings.TrimLeft(digitsOnly(number), "0") {
+				score = 80
+			} else if len(ref) >= 4 {
+				if d := levenshtein(ref, number); d <= 2 {
+					score = 70 - 10*d
+				}
+			}
+			if score > 0 && (best == nil || score > best.Score) {
+				best = &InvoiceMatch{Invoice: &invoices[i], MatchedOn: MatchOnFuzzyReference, Score: score}
+			}
 		}
+	}
+	return best, nil
+}
+
+// matchByPhone matches a payer to a client by phone. A client with a single open
+// invoice is matched outright; otherwise their oldest invoice is only suggested.
+func (s *PaymentMatchingService) matchByPhone(tenantID string, invoices []models.Invoice, phones map[string]bool) *InvoiceMatch {
+	clientIDs := make([]string, 0, len(invoices))
+	for _, inv := range invoices {
+		clientIDs = append(clientIDs, inv.ClientID)
+	}
 
-		s.sendMatchNotification(tenantID, &invoice, EventPaymentMatched)
+	// Phones are encrypted at rest, so compare after loading
+	var clients []models.Client
+	s.db.Scopes(database.TenantFilter(tenantID)).Where("id IN ?", clientIDs).Find(&clients)
+	var matchedClient string
+	for _, c := range clients {
+		if phones[normalizePhone(c.Phone)] {
+			if matchedClient != "" && matchedClient != c.ID {
+				return nil // shared number, leave it to a person
+			}
+			matchedClient = c.ID
+		}
+	}
+	if matchedClient == "" {
 		return nil
-	})
+	}
+
+	var open []int
+	for i := range invoices {
+		if invoices[i].ClientID == matchedClient {
+			open = append(open, i)
+		}
+	}
+	if len(open) == 1 {
+		return &InvoiceMatch{Invoice: &invoices[open[0]], MatchedOn: MatchOnPhone, Score: 90, Automatic: true}
+	}
+	return &InvoiceMatch{Invoice: &invoices[open[0]], MatchedOn: MatchOnPhone, Score: 60}
 }
 
-func (s *PaymentMatchingService) FindUnpaidByReference(tenantID, reference string) (*models.Invoice, error) {
-	var payment models.Payment
-	if err := s.db.Where("tenant_id = ? AND reference = ?", tenantID, reference).First(&payment).Error; err == nil {
-		return nil, fmt.Errorf("payment already exists with reference %s", reference)
+// normalizeReference uppercases a reference and drops everything but letters and digits
+func normalizeReference(ref string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(ref) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
 	}
+	return b.String()
+}
 
-	var invoices []models.Invoice
-	s.db.Where("tenant_id = ? AND status NOT IN ? AND total > paid_amount",
-		tenantID, []string{"paid", "cancelled"}).
-		Find(&invoices)
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
 
-	return nil, nil
+// levenshtein returns the edit distance between two ASCII strings
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
 }
 
 func (s *PaymentMatchingService) GetPaymentByID(tenantID, paymentID string) (*models.Payment, error) {
@@ -264,6 +406,13 @@ func (s *PaymentMatchingService) GetPaymentByID(tenantID, paymentID string) (*mo
 	return &payment, nil
 }
 
+// IssueReceiptNumber gives a completed payment the tenant's next receipt number
+// if it has none. Payments are numbered when they complete; this numbers ones
+// completed before that.
+func (s *PaymentMatchingService) IssueReceiptNumber(payment *models.Payment) error {
+	return issueReceiptNumber(s.db.DB, payment)
+}
+
 func (s *PaymentMatchingService) GetClientByID(tenantID, clientID string) (*models.Client, error) {
 	var client models.Client
 	err := s.db.Where("id = ? AND tenant_id = ?", clientID, tenantID).First(&client).Error
@@ -280,26 +429,13 @@ func (s *PaymentMatchingService) ReconcilePayment(tenantID, paymentID, userID st
 			return fmt.Errorf("payment not found: %w", err)
 		}
 
-		// Get the invoice
-		var invoice models.Invoice
-		if err := tx.Where("id = ? AND tenant_id = ?", payment.InvoiceID, tenantID).First(&invoice).Error; err != nil {
-			return fmt.Errorf("invoice not found: %w", err)
-		}
-
 		// Update invoice paid amount and status
-		newPaidAmount := invoice.PaidAmount + payment.Amount
-		invoice.PaidAmount = newPaidAmount
-
-		if newPaidAmount >= invoice.Total {
-			invoice.Status = models.InvoiceStatusPaid
-			now := time.Now()
-			invoice.PaidAt = &now
-		} else if newPaidAmount > 0 {
-			invoice.Status = models.InvoiceStatusPartiallyPaid
+		invoice, err := lockInvoice(tx, tenantID, payment.InvoiceID)
+		if err != nil {
+			return err
 		}
-
-		if err := tx.Save(&invoice).Error; err != nil {
-			return fmt.Errorf("failed to update invoice: %w", err)
+		if err := applyAllocation(tx, invoice, payment.Amount, time.Now()); err != nil {
+			return err
 		}
 
 		// Mark payment as reconciled if it was unallocated
@@ -314,7 +450,7 @@ func (s *PaymentMatchingService) ReconcilePayment(tenantID, paymentID, userID st
 			}
 		}
 
-		s.sendMatchNotification(tenantID, &invoice, EventPaymentMatched)
+		s.sendMatchNotification(tenantID, invoice, EventPaymentMatched)
 		return nil
 	})
 }
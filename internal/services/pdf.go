@@ -2,10 +2,8 @@ package services
 
 import (
 	"bytes"
-	"crypto/rand"
 	"fmt"
 	"html/template"
-	"math/big"
 	"strings"
 	"time"
 
@@ -486,9 +484,13 @@ func renderInvoiceTemplate(data InvoicePDFData) (string, error) {
 	return buf.String(), nil
 }
 
-// GenerateReceiptPDF generates a receipt for a payment
+// GenerateReceiptPDF generates a receipt for a payment, under the receipt
+// number issued to it or the payment reference until one is
 func (s *PDFService) GenerateReceiptHTML(invoice *models.Invoice, payment *models.Payment, user *models.User) (string, error) {
-	receiptNumber := generateReceiptNumber()
+	receiptNumber := payment.ReceiptNumber
+	if receiptNumber == "" {
+		receiptNumber = payment.Reference
+	}
 	receiptDate := time.Now().Format("02 Jan 2006")
 
 	data := map[string]interface{}{
@@ -573,20 +575,6 @@ func (s *PDFService) GenerateReceiptHTML(invoice *models.Invoice, payment *model
 	return buf.String(), nil
 }
 
-func generateReceiptNumber() string {
-	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, 8)
-	for i := range b {
-		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
-		if err != nil {
-			b[i] = '0'
-			continue
-		}
-		b[i] = chars[n.Int64()]
-	}
-	return "RCP-" + string(b) + "-" + time.Now().Format("060102")
-}
-
 // QRCodeData generates data for QR code (for KRA compliance)
 type QRCodeData struct {
 	InvoiceNumber string
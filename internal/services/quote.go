@@ -0,0 +1,768 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
+	"invoicefast/internal/metrics"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// QUOTES - Pre-sale quotations with conversion to invoices
+// ============================================================================
+
+const defaultQuoteValidityDays = 30
+
+var (
+	ErrQuoteNotFound         = errors.New("quote not found")
+	ErrQuoteNotEditable      = errors.New("only draft or sent quotes can be changed")
+	ErrQuoteExpired          = errors.New("quote has expired")
+	ErrQuoteAlreadyConverted = errors.New("quote has already been converted to an invoice")
+	ErrQuoteNotOpen          = errors.New("quote is not awaiting a response")
+)
+
+// QuoteService handles quotations
+type QuoteService struct {
+	db           *database.DB
+	emailService *EmailService
+	workflows    *WorkflowEngine
+	rates        *ExchangeRateService
+	taxRates     *TaxRateService
+	baseURL      string
+}
+
+// NewQuoteService creates a new quote service
+func NewQuoteService(db *database.DB) *QuoteService {
+	return &QuoteService{db: db, taxRates: NewTaxRateService(db), baseURL: "https://invoice.simuxtech.com"}
+}
+
+// SetEmailService enables emailing quotes to clients
+func (s *QuoteService) SetEmailService(emailService *EmailService) {
+	s.emailService = emailService
+}
+
+// SetWorkflowEngine publishes invoice_created when a quote is converted
+func (s *QuoteService) SetWorkflowEngine(engine *WorkflowEngine) {
+	s.workflows = engine
+}
+
+// SetExchangeRateService prices foreign-currency quotes in KES
+func (s *QuoteService) SetExchangeRateService(rates *ExchangeRateService) {
+	s.rates = rates
+}
+
+// SetBaseURL sets the public URL used for client portal links
+func (s *QuoteService) SetBaseURL(baseURL string) {
+	if baseURL != "" {
+		s.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// QuoteLink returns the client portal link for a quote
+func (s *QuoteService) QuoteLink(quote *models.Quote) string {
+	return fmt.Sprintf("%s/quote/%s", s.baseURL, quote.MagicToken)
+}
+
+type CreateQuoteRequest struct {
+	ClientID   string             `json:"client_id"`
+	Reference  string             `json:"reference"`
+	Title      string             `json:"title"`
+	Currency   string             `json:"currency"`
+	TaxRate    float64            `json:"tax_rate"`
+	TaxCode    string             `json:"tax_code"`
+	Discount   float64            `json:"discount"`
+	ExpiryDate time.Time          `json:"expiry_date"`
+	Notes      string             `json:"notes"`
+	Terms      string             `json:"terms"`
+	Items      []QuoteItemRequest `json:"items"`
+}
+
+type QuoteItemRequest struct {
+	Description  string  `json:"description"`
+	ItemCode     string  `json:"item_code"`
+	Quantity     float64 `json:"quantity"`
+	UnitPrice    float64 `json:"unit_price"`
+	TaxType      string  `json:"tax_type"`
+	TaxRate      float64 `json:"tax_rate"`
+	TaxCode      string  `json:"tax_code"`
+	ExciseCode   string  `json:"excise_code"`
+	DiscountRate float64 `json:"discount_rate"`
+	Unit         string  `json:"unit"`
+}
+
+type QuoteFilter struct {
+	Status   string
+	ClientID string
+	Offset   int
+	Limit    int
+}
+
+// CreateQuote creates a draft quote numbered from the tenant's quote sequence
+func (s *QuoteService) CreateQuote(tenantID, userID string, req *CreateQuoteRequest) (*models.Quote, error) {
+	if tenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	if strings.TrimSpace(userID) == "" {
+		return nil, errors.New("user ID is required")
+	}
+
+	client := &models.Client{}
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(client, "id = ?", req.ClientID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("client not found")
+		}
+		return nil, fmt.Errorf("failed to find client: %w", err)
+	}
+
+	quote := &models.Quote{
+		ID:         uuid.New().String(),
+		TenantID:   tenantID,
+		UserID:     userID,
+		ClientID:   client.ID,
+		Status:     models.QuoteStatusDraft,
+		MagicToken: uuid.New().String(),
+	}
+	if err := s.applyRequest(quote, client, req); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		number, seqNum, err := nextDocumentNumber(tx, tenantID, models.SequenceDocumentQuote)
+		if err != nil {
+			return fmt.Errorf("failed to generate quote number: %w", err)
+		}
+		quote.QuoteNumber = number
+		quote.SequenceNumber = seqNum
+
+		items := quote.Items
+		quote.Items = nil
+		if err := tx.Create(quote).Error; err != nil {
+			return fmt.Errorf("failed to create quote: %w", err)
+		}
+		if err := tx.Create(&items).Error; err != nil {
+			return fmt.Errorf("failed to create quote items: %w", err)
+		}
+		quote.Items = items
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quote.Client = *client
+	return quote, nil
+}
+
+// UpdateQuote replaces the contents of a quote that has not been answered yet
+func (s *QuoteService) UpdateQuote(tenantID, id string, req *CreateQuoteRequest) (*models.Quote, error) {
+	quote, err := s.GetQuote(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if quote.Status != models.QuoteStatusDraft && quote.Status != models.QuoteStatusSent && quote.Status != models.QuoteStatusViewed {
+		return nil, ErrQuoteNotEditable
+	}
+
+	if req.ClientID == "" {
+		req.ClientID = quote.ClientID
+	}
+	client := &models.Client{}
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(client, "id = ?", req.ClientID).Error; err != nil {
+		return nil, errors.New("client not found")
+	}
+	quote.ClientID = client.ID
+	if err := s.applyRequest(quote, client, req); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		items := quote.Items
+		if err := tx.Where("quote_id = ?", quote.ID).Delete(&models.QuoteItem{}).Error; err != nil {
+			return fmt.Errorf("failed to replace quote items: %w", err)
+		}
+		if err := tx.Create(&items).Error; err != nil {
+			return fmt.Errorf("failed to create quote items: %w", err)
+		}
+		return tx.Model(quote).Omit("Items", "Client").Select("*").Updates(quote).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quote.Client = *client
+	return quote, nil
+}
+
+// applyRequest validates the request and recalculates the quote totals and lines.
+// The arithmetic matches CreateInvoice so a converted quote carries the same amounts
+// unless a rate in the catalogue has changed in between.
+func (s *QuoteService) applyRequest(quote *models.Quote, client *models.Client, req *CreateQuoteRequest) error {
+	if len(req.Items) == 0 {
+		return ErrEmptyItems
+	}
+
+	currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+	if currency == "" {
+		currency = client.Currency
+	}
+	if currency == "" {
+		currency = s.tenantCurrency(quote.TenantID)
+	}
+	if !validCurrencies[currency] {
+		return fmt.Errorf("unsupported currency: %s", currency)
+	}
+
+	expiry := req.ExpiryDate
+	if expiry.IsZero() {
+		expiry = time.Now().AddDate(0, 0, defaultQuoteValidityDays)
+	} else if expiry.Before(time.Now().Truncate(24 * time.Hour)) {
+		return errors.New("expiry date cannot be in the past")
+	}
+
+	// Lines are priced at the catalogue rates in force today
+	now := time.Now()
+	documentRate, err := s.documentRate(quote.TenantID, req.TaxCode, req.TaxRate, now)
+	if err != nil {
+		return err
+	}
+	items, _, err := s.priceLines(quote.TenantID, quote.ID, req.Items, documentRate, now)
+	if err != nil {
+		return err
+	}
+	subtotal, excise, totalTax := quoteLineTotals(items)
+	discount := models.ToCents(math.Max(0, req.Discount))
+	total := subtotal.Add(excise).Add(totalTax).Sub(discount)
+	if total < 0 {
+		total = 0
+	}
+
+	exchangeRate := 1.0
+	if currency != "KES" && s.rates != nil {
+		rate, err := s.rates.RateOn(quote.TenantID, currency, now)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("no exchange rate for %s: %w", currency, ErrExchangeRateNotFound)
+		}
+		exchangeRate = rate
+	}
+
+	quote.Reference = strings.TrimSpace(req.Reference)
+	quote.Title = strings.TrimSpace(req.Title)
+	quote.Currency = currency
+	quote.ExchangeRate = exchangeRate
+	quote.Subtotal = subtotal
+	quote.Discount = discount
+	quote.TaxRate, quote.TaxCode, quote.TaxType = 0, "", models.TaxTypeStandard
+	if documentRate != nil {
+		quote.TaxRate, quote.TaxCode, quote.TaxType = documentRate.Rate, documentRate.Code, documentRate.TaxType
+	}
+	quote.TotalTax = totalTax
+	quote.ExciseDuty = excise
+	quote.Total = total
+	quote.ExpiryDate = expiry
+	quote.Notes = strings.TrimSpace(req.Notes)
+	quote.Terms = strings.TrimSpace(req.Terms)
+	quote.Items = items
+	return nil
+}
+
+// documentRate returns the rate charged on lines without one of their own: the
+// catalogue rate for code on date, or rate given directly. It is nil if neither.
+func (s *QuoteService) documentRate(tenantID, code string, rate float64, date time.Time) (*models.TaxRate, error) {
+	if strings.TrimSpace(code) != "" {
+		vat, err := s.taxRates.RateOn(tenantID, code, date)
+		if err != nil {
+			return nil, err
+		}
+		if vat.Kind != models.TaxKindVAT {
+			return nil, fmt.Errorf("%s is not a VAT rate: %w", vat.Code, ErrTaxRateKind)
+		}
+		return vat, nil
+	}
+	if rate = math.Max(0, math.Min(100, rate)); rate > 0 {
+		return &models.TaxRate{TaxType: models.TaxTypeStandard, Rate: rate}, nil
+	}
+	return nil, nil
+}
+
+// priceLines validates a quote's lines and prices them with CalculateLineItemTax
+// at the catalogue rates in force on date, as CreateInvoice does. It returns the
+// VAT rate each line was charged at alongside it.
+func (s *QuoteService) priceLines(tenantID, quoteID string, lines []QuoteItemRequest, documentRate *models.TaxRate, date time.Time) ([]models.QuoteItem, []*models.TaxRate, error) {
+	items := make([]models.QuoteItem, 0, len(lines))
+	vats := make([]*models.TaxRate, 0, len(lines))
+	for i, item := range lines {
+		if item.Quantity < 0 {
+			return nil, nil, ErrInvalidQuantity
+		}
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+		unitPrice := math.Max(0, item.UnitPrice)
+		description := strings.TrimSpace(item.Description)
+		if description == "" {
+			description = "Item"
+		}
+
+		taxType := models.TaxType(strings.ToLower(strings.TrimSpace(item.TaxType)))
+		switch taxType {
+		case "":
+			taxType = models.TaxTypeStandard
+		case models.TaxTypeStandard, models.TaxTypeZeroRated, models.TaxTypeExempt, models.TaxTypeNone:
+		default:
+			return nil, nil, fmt.Errorf("invalid tax type: %s", item.TaxType)
+		}
+		taxRate := math.Max(0, math.Min(100, item.TaxRate))
+		if taxType != models.TaxTypeStandard {
+			taxRate = 0
+		}
+		discountRate := math.Max(0, math.Min(100, item.DiscountRate))
+
+		inline := &models.TaxRate{TaxType: taxType, Rate: taxRate}
+		vat, excise, err := s.taxRates.LineRates(tenantID, item.TaxCode, item.ExciseCode, inline, documentRate, date)
+		if err != nil {
+			return nil, nil, err
+		}
+		lineSubtotal, exciseDuty, taxAmt, lineTotal := models.CalculateLineItemTax(item.Quantity, unitPrice, discountRate, 0, vat, excise)
+
+		items = append(items, models.QuoteItem{
+			ID:           uuid.New().String(),
+			QuoteID:      quoteID,
+			Description:  description,
+			ItemCode:     strings.TrimSpace(item.ItemCode),
+			Quantity:     item.Quantity,
+			UnitPrice:    models.ToCents(unitPrice),
+			Unit:         item.Unit,
+			TaxType:      vat.TaxType,
+			TaxRate:      vat.Rate,
+			TaxAmount:    taxAmt,
+			TaxCode:      vat.Code,
+			ExciseCode:   strings.ToUpper(strings.TrimSpace(item.ExciseCode)),
+			ExciseDuty:   exciseDuty,
+			DiscountRate: discountRate,
+			DiscountAmt:  lineSubtotal.Mul(discountRate / 100),
+			Subtotal:     lineSubtotal,
+			Total:        lineTotal,
+			SortOrder:    i,
+		})
+		vats = append(vats, vat)
+	}
+	return items, vats, nil
+}
+
+// quoteLineTotals sums the lines' subtotals before discount, excise and VAT
+func quoteLineTotals(items []models.QuoteItem) (subtotal, excise, tax models.Money) {
+	for _, item := range items {
+		subtotal = subtotal.Add(item.Subtotal)
+		excise = excise.Add(item.ExciseDuty)
+		tax = tax.Add(item.TaxAmount)
+	}
+	return subtotal, excise, tax
+}
+
+func (s *QuoteService) tenantCurrency(tenantID string) string {
+	var tenant models.Tenant
+	if err := s.db.Select("currency").First(&tenant, "id = ?", tenantID).Error; err != nil || tenant.Currency == "" {
+		return "KES"
+	}
+	return tenant.Currency
+}
+
+// GetQuote returns a tenant's quote with its client and items
+func (s *QuoteService) GetQuote(tenantID, id string) (*models.Quote, error) {
+	var quote models.Quote
+	err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Preload("Client").Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order") }).
+		First(&quote, "id = ?", id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrQuoteNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch quote: %w", err)
+	}
+	s.expireIfDue(&quote)
+	return &quote, nil
+}
+
+// ListQuotes returns a page of quotes and the total count
+func (s *QuoteService) ListQuotes(tenantID string, filter QuoteFilter) ([]models.Quote, int64, error) {
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+
+	query := s.db.Model(&models.Quote{}).Scopes(database.TenantFilter(tenantID))
+	if filter.ClientID != "" {
+		query = query.Where("client_id = ?", filter.ClientID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var quotes []models.Quote
+	err := query.Preload("Client").Order("created_at DESC").
+		Offset(filter.Offset).Limit(filter.Limit).Find(&quotes).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range quotes {
+		s.expireIfDue(&quotes[i])
+	}
+	return quotes, total, nil
+}
+
+// DeleteQuote deletes a draft quote
+func (s *QuoteService) DeleteQuote(tenantID, id string) error {
+	quote, err := s.GetQuote(tenantID, id)
+	if err != nil {
+		return err
+	}
+	if quote.Status != models.QuoteStatusDraft {
+		return errors.New("only draft quotes can be deleted")
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("quote_id = ?", quote.ID).Delete(&models.QuoteItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(quote).Error
+	})
+}
+
+// SendQuote marks a quote as sent and emails the portal link to the client
+func (s *QuoteService) SendQuote(tenantID, id string) (*models.Quote, error) {
+	quote, err := s.GetQuote(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	switch quote.Status {
+	case models.QuoteStatusDraft, models.QuoteStatusSent, models.QuoteStatusViewed:
+	case models.QuoteStatusExpired:
+		return nil, ErrQuoteExpired
+	default:
+		return nil, ErrQuoteNotOpen
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"sent_at": now}
+	if quote.Status == models.QuoteStatusDraft {
+		updates["status"] = models.QuoteStatusSent
+		quote.Status = models.QuoteStatusSent
+	}
+	if err := s.db.Model(quote).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update quote: %w", err)
+	}
+	quote.SentAt = &now
+
+	if s.emailService != nil && quote.Client.Email != "" {
+		var tenant models.Tenant
+		s.db.Select("name").First(&tenant, "id = ?", tenantID)
+		amount := fmt.Sprintf("%s %s", quote.Currency, formatGroupedAmount(quote.Total.Float64()))
+		if err := s.emailService.SendQuoteEmail(quote.Client.Email, tenant.Name, quote.QuoteNumber, amount, quote.ExpiryDate, s.QuoteLink(quote)); err != nil {
+			logger.Get().Error(context.Background(), "Failed to send quote email", "quote_number", quote.QuoteNumber, "error", err)
+		}
+	}
+	return quote, nil
+}
+
+// GetQuoteByMagicToken returns a quote for the client portal and records the first view
+func (s *QuoteService) GetQuoteByMagicToken(token string) (*models.Quote, error) {
+	if strings.TrimSpace(token) == "" {
+		return nil, ErrQuoteNotFound
+	}
+
+	var quote models.Quote
+	err := s.db.Preload("Client").Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order") }).
+		First(&quote, "magic_token = ?", token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrQuoteNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch quote: %w", err)
+	}
+	// Drafts have not been shared with the client yet
+	if quote.Status == models.QuoteStatusDraft {
+		return nil, ErrQuoteNotFound
+	}
+
+	s.expireIfDue(&quote)
+	if quote.Status == models.QuoteStatusSent {
+		now := time.Now()
+		s.db.Model(&quote).Where("status = ?", models.QuoteStatusSent).
+			Updates(map[string]interface{}{"status": models.QuoteStatusViewed, "viewed_at": now})
+		quote.Status = models.QuoteStatusViewed
+		quote.ViewedAt = &now
+	}
+	return &quote, nil
+}
+
+// AcceptQuoteByToken accepts a quote from the client portal and converts it into an invoice
+func (s *QuoteService) AcceptQuoteByToken(token string) (*models.Quote, *models.Invoice, error) {
+	quote, err := s.GetQuoteByMagicToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.convert(quote, quote.UserID)
+}
+
+// DeclineQuoteByToken records the client's rejection of a quote
+func (s *QuoteService) DeclineQuoteByToken(token, reason string) (*models.Quote, error) {
+	quote, err := s.GetQuoteByMagicToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if quote.Status == models.QuoteStatusExpired {
+		return nil, ErrQuoteExpired
+	}
+	if quote.Status != models.QuoteStatusSent && quote.Status != models.QuoteStatusViewed {
+		return nil, ErrQuoteNotOpen
+	}
+
+	now := time.Now()
+	reason = strings.TrimSpace(reason)
+	if len(reason) > 500 {
+		reason = reason[:500]
+	}
+	result := s.db.Model(&models.Quote{}).
+		Where("id = ? AND status IN ?", quote.ID, []models.QuoteStatus{models.QuoteStatusSent, models.QuoteStatusViewed}).
+		Updates(map[string]interface{}{"status": models.QuoteStatusDeclined, "declined_at": now, "decline_reason": reason})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to decline quote: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrQuoteNotOpen
+	}
+
+	quote.Status = models.QuoteStatusDeclined
+	quote.DeclinedAt = &now
+	quote.DeclineReason = reason
+	return quote, nil
+}
+
+// ConvertToInvoice accepts a quote on the client's behalf and converts it into an invoice
+func (s *QuoteService) ConvertToInvoice(tenantID, userID, id string) (*models.Quote, *models.Invoice, error) {
+	quote, err := s.GetQuote(tenantID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if userID == "" {
+		userID = quote.UserID
+	}
+	return s.convert(quote, userID)
+}
+
+// convert creates a draft invoice from the quote. Items and discounts are copied
+// as stored and taxed again at the catalogue rates in force on the invoice date;
+// the invoice then follows the normal send and KRA flow.
+func (s *QuoteService) convert(quote *models.Quote, userID string) (*models.Quote, *models.Invoice, error) {
+	if quote.ConvertedInvoiceID != "" {
+		return nil, nil, ErrQuoteAlreadyConverted
+	}
+	switch quote.Status {
+	case models.QuoteStatusDraft, models.QuoteStatusSent, models.QuoteStatusViewed:
+	case models.QuoteStatusExpired:
+		return nil, nil, ErrQuoteExpired
+	default:
+		return nil, nil, ErrQuoteNotOpen
+	}
+
+	client := quote.Client
+	paymentTerms := client.PaymentTerms
+	if paymentTerms <= 0 {
+		paymentTerms = 30
+	}
+	reference := quote.Reference
+	if reference == "" {
+		reference = quote.QuoteNumber
+	}
+
+	now := time.Now()
+	// The invoice is priced at the rate of the day it is issued, not the day quoted
+	exchangeRate := quote.ExchangeRate
+	if quote.Currency != "KES" && s.rates != nil {
+		if rate, err := s.rates.RateOn(quote.TenantID, quote.Currency, now); err == nil && rate > 0 {
+			exchangeRate = rate
+		}
+	}
+	if exchangeRate <= 0 {
+		exchangeRate = 1
+	}
+
+	// The invoice is taxed at the rates in force on the day it is issued too
+	documentRate, err := s.documentRate(quote.TenantID, quote.TaxCode, quote.TaxRate, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := make([]QuoteItemRequest, 0, len(quote.Items))
+	for _, item := range quote.Items {
+		lines = append(lines, QuoteItemRequest{
+			Description:  item.Description,
+			ItemCode:     item.ItemCode,
+			Quantity:     item.Quantity,
+			UnitPrice:    item.UnitPrice.Float64(),
+			TaxType:      string(item.TaxType),
+			TaxRate:      item.TaxRate,
+			TaxCode:      item.TaxCode,
+			ExciseCode:   item.ExciseCode,
+			DiscountRate: item.DiscountRate,
+			Unit:         item.Unit,
+		})
+	}
+	priced, vats, err := s.priceLines(quote.TenantID, "", lines, documentRate, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	subtotal, excise, totalTax := quoteLineTotals(priced)
+	total := subtotal.Add(excise).Add(totalTax).Sub(quote.Discount)
+	if total < 0 {
+		total = 0
+	}
+	taxRate, taxCode, taxType := 0.0, "", models.TaxTypeStandard
+	if documentRate != nil {
+		taxRate, taxCode, taxType = documentRate.Rate, documentRate.Code, documentRate.TaxType
+	}
+
+	magicTokenExpires := now.AddDate(0, 3, 0)
+	invoice := &models.Invoice{
+		ID:                  uuid.New().String(),
+		TenantID:            quote.TenantID,
+		UserID:              userID,
+		ClientID:            quote.ClientID,
+		Reference:           reference,
+		Title:               quote.Title,
+		Currency:            quote.Currency,
+		KESEquivalent:       total.Mul(exchangeRate),
+		ExchangeRate:        exchangeRate,
+		ExchangeRateAt:      now,
+		Subtotal:            subtotal,
+		Discount:            quote.Discount,
+		TaxRate:             taxRate,
+		TaxCode:             taxCode,
+		TotalTax:            totalTax,
+		TaxAmount:           totalTax,
+		ExciseDuty:          excise,
+		Total:               total,
+		BalanceDue:          total,
+		TaxType:             taxType,
+		Status:              models.InvoiceStatusDraft,
+		DueDate:             now.AddDate(0, 0, paymentTerms),
+		Notes:               quote.Notes,
+		Terms:               quote.Terms,
+		MagicToken:          uuid.New().String(),
+		MagicTokenExpiresAt: &magicTokenExpires,
+		KRAStatus:           models.KRAInvoiceStatusPending,
+		Version:             1,
+		BuyerClassification: DetectBuyerType(&client),
+	}
+
+	items := make([]models.InvoiceItem, 0, len(priced))
+	for i, item := range priced {
+		items = append(items, models.InvoiceItem{
+			ID:            uuid.New().String(),
+			InvoiceID:     invoice.ID,
+			Description:   item.Description,
+			ItemCode:      item.ItemCode,
+			Quantity:      item.Quantity,
+			UnitPrice:     item.UnitPrice,
+			Unit:          item.Unit,
+			UnitOfMeasure: quote.Items[i].UnitOfMeasure,
+			TaxType:       item.TaxType,
+			TaxRate:       item.TaxRate,
+			TaxAmount:     item.TaxAmount,
+			TaxCode:       item.TaxCode,
+			TaxCategory:   s.taxRates.LineCategory(quote.TenantID, vats[i], now),
+			ExciseCode:    item.ExciseCode,
+			ExciseDuty:    item.ExciseDuty,
+			DiscountRate:  item.DiscountRate,
+			DiscountAmt:   item.DiscountAmt,
+			Subtotal:      item.Subtotal,
+			Total:         item.Total,
+			SortOrder:     item.SortOrder,
+		})
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Claim the quote first so concurrent accepts cannot create two invoices
+		result := tx.Model(&models.Quote{}).
+			Where("id = ? AND (converted_invoice_id = '' OR converted_invoice_id IS NULL)", quote.ID).
+			Where("status IN ?", []models.QuoteStatus{models.QuoteStatusDraft, models.QuoteStatusSent, models.QuoteStatusViewed}).
+			Updates(map[string]interface{}{
+				"status":               models.QuoteStatusAccepted,
+				"accepted_at":          now,
+				"converted_invoice_id": invoice.ID,
+				"converted_at":         now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to accept quote: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrQuoteAlreadyConverted
+		}
+
+		number, seqNum, err := nextDocumentNumber(tx, quote.TenantID, models.SequenceDocumentInvoice)
+		if err != nil {
+			return fmt.Errorf("failed to generate invoice number: %w", err)
+		}
+		invoice.InvoiceNumber = number
+		invoice.SequenceNumber = seqNum
+
+		if err := tx.Create(invoice).Error; err != nil {
+			return fmt.Errorf("failed to create invoice: %w", err)
+		}
+		if len(items) > 0 {
+			if err := tx.Create(&items).Error; err != nil {
+				return fmt.Errorf("failed to create invoice items: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	quote.Status = models.QuoteStatusAccepted
+	quote.AcceptedAt = &now
+	quote.ConvertedInvoiceID = invoice.ID
+	quote.ConvertedAt = &now
+	invoice.Items = items
+	invoice.Client = client
+
+	metrics.RecordInvoiceCreated()
+
+	s.workflows.Publish(&WorkflowEvent{
+		TenantID:   invoice.TenantID,
+		UserID:     userID,
+		Event:      models.TriggerEventInvoiceCreated,
+		EntityType: "invoice",
+		EntityID:   invoice.ID,
+		Data:       invoiceEventData(invoice, &client),
+	})
+
+	logger.Get().Info(context.Background(), "Quote converted to invoice",
+		"tenant_id", quote.TenantID, "quote_number", quote.QuoteNumber, "invoice_number", invoice.InvoiceNumber)
+	return quote, invoice, nil
+}
+
+// expireIfDue moves open quotes past their expiry date to expired
+func (s *QuoteService) expireIfDue(quote *models.Quote) {
+	if !quote.IsExpired(time.Now()) {
+		return
+	}
+	s.db.Model(&models.Quote{}).
+		Where("id = ? AND status IN ?", quote.ID, []models.QuoteStatus{models.QuoteStatusSent, models.QuoteStatusViewed}).
+		Update("status", models.QuoteStatusExpired)
+	quote.Status = models.QuoteStatusExpired
+}
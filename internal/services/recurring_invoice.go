@@ -25,6 +25,7 @@ type RecurringInvoiceService struct {
 	db             *database.DB
 	invoiceService *InvoiceService
 	emailService   *EmailService
+	taxRates       *TaxRateService
 }
 
 func NewRecurringInvoiceService(db *database.DB, invoiceSvc *InvoiceService, emailSvc *EmailService) *RecurringInvoiceService {
@@ -32,6 +33,7 @@ func NewRecurringInvoiceService(db *database.DB, invoiceSvc *InvoiceService, ema
 		db:             db,
 		invoiceService: invoiceSvc,
 		emailService:   emailSvc,
+		taxRates:       NewTaxRateService(db),
 	}
 }
 
@@ -61,49 +63,121 @@ func (s *RecurringInvoiceService) ProcessRecurringInvoices() error {
 func (s *RecurringInvoiceService) createRecurringInvoice(parent *models.Invoice) error {
 	tenantID := parent.TenantID
 
+	// Each invoice in the series is taxed at the catalogue rates in force on
+	// the day it is raised, not the rates the first one was charged at
+	now := time.Now()
+	var invoiceRate *models.TaxRate
+	if parent.TaxCode != "" {
+		rate, err := s.taxRates.RateOn(tenantID, parent.TaxCode, now)
+		if err != nil {
+			return err
+		}
+		invoiceRate = rate
+	} else if parent.TaxRate > 0 {
+		invoiceRate = &models.TaxRate{TaxType: models.TaxTypeStandard, Rate: parent.TaxRate}
+	}
+
+	items := []models.InvoiceItem{}
+	if err := s.db.Where("invoice_id = ?", parent.ID).Order("sort_order").Find(&items).Error; err != nil {
+		return fmt.Errorf("failed to load items: %w", err)
+	}
+	newItems := make([]models.InvoiceItem, 0, len(items))
+	var subtotal, excise, totalTax models.Money
+	for _, item := range items {
+		inline := &models.TaxRate{TaxType: item.TaxType, Rate: item.TaxRate}
+		if inline.TaxType == "" {
+			inline.TaxType = models.TaxTypeStandard
+		}
+		vat, exciseRate, err := s.taxRates.LineRates(tenantID, item.TaxCode, item.ExciseCode, inline, invoiceRate, now)
+		if err != nil {
+			return err
+		}
+		lineSubtotal, exciseDuty, taxAmt, lineTotal := models.CalculateLineItemTax(item.Quantity, item.UnitPrice.Float64(), item.DiscountRate, item.DiscountAmt.Float64(), vat, exciseRate)
+
+		discountAmt := item.DiscountAmt
+		if item.DiscountRate > 0 {
+			discountAmt = lineSubtotal.Mul(item.DiscountRate / 100)
+		}
+		subtotal = subtotal.Add(lineSubtotal)
+		excise = excise.Add(exciseDuty)
+		totalTax = totalTax.Add(taxAmt)
+		newItems = append(newItems, models.InvoiceItem{
+			ID:            uuid.New().String(),
+			Description:   item.Description,
+			ItemCode:      item.ItemCode,
+			Quantity:      item.Quantity,
+			UnitPrice:     item.UnitPrice,
+			Unit:          item.Unit,
+			UnitOfMeasure: item.UnitOfMeasure,
+			TaxType:       vat.TaxType,
+			TaxRate:       vat.Rate,
+			TaxAmount:     taxAmt,
+			TaxCode:       vat.Code,
+			TaxCategory:   s.taxRates.LineCategory(tenantID, vat, now),
+			ExciseCode:    item.ExciseCode,
+			ExciseDuty:    exciseDuty,
+			DiscountRate:  item.DiscountRate,
+			DiscountAmt:   discountAmt,
+			Subtotal:      lineSubtotal,
+			Total:         lineTotal,
+			SortOrder:     item.SortOrder,
+		})
+	}
+	total := subtotal.Add(excise).Add(totalTax).Sub(parent.Discount)
+	if total < 0 {
+		total = 0
+	}
+	kesEquivalent := total
+	if parent.ExchangeRate > 0 {
+		kesEquivalent = total.Mul(parent.ExchangeRate)
+	}
+
 	newInvoice := &models.Invoice{
 		ID:                uuid.New().String(),
 		TenantID:          tenantID,
 		UserID:            parent.UserID,
 		ClientID:          parent.ClientID,
-		InvoiceNumber:     s.generateInvoiceNumber(tenantID),
 		Currency:          parent.Currency,
-		KESEquivalent:     parent.KESEquivalent,
+		KESEquivalent:     kesEquivalent,
 		ExchangeRate:      parent.ExchangeRate,
 		InvoiceType:       parent.InvoiceType,
 		RecurringParentID: parent.ID,
-		Subtotal:          parent.Subtotal,
+		Subtotal:          subtotal,
 		TaxRate:           parent.TaxRate,
-		TaxAmount:         parent.TaxAmount,
+		TaxCode:           parent.TaxCode,
+		TaxType:           parent.TaxType,
+		TotalTax:          totalTax,
+		TaxAmount:         totalTax,
+		ExciseDuty:        excise,
 		Discount:          parent.Discount,
-		Total:             parent.Total,
+		Total:             total,
+		BalanceDue:        total,
 		PaidAmount:        0,
 		Status:            models.InvoiceStatusDraft,
-		DueDate:           time.Now().AddDate(0, 1, 0),
+		DueDate:           now.AddDate(0, 1, 0),
 		Notes:             parent.Notes,
 		Terms:             parent.Terms,
 		BrandColor:        parent.BrandColor,
 		LogoURL:           parent.LogoURL,
 	}
-
-	items := []models.InvoiceItem{}
-	s.db.Where("invoice_id = ?", parent.ID).Find(&items)
-	newItems := make([]models.InvoiceItem, 0, len(items))
-	for _, item := range items {
-		newItem := models.InvoiceItem{
-			ID:          uuid.New().String(),
-			InvoiceID:   newInvoice.ID,
-			Description: item.Description,
-			Quantity:    item.Quantity,
-			UnitPrice:   item.UnitPrice,
-			Unit:        item.Unit,
-			Total:       item.Total,
-		}
-		newItems = append(newItems, newItem)
+	if invoiceRate != nil && invoiceRate.Code != "" {
+		newInvoice.TaxRate, newInvoice.TaxType = invoiceRate.Rate, invoiceRate.TaxType
+	}
+	for i := range newItems {
+		newItems[i].InvoiceID = newInvoice.ID
 	}
 	newInvoice.Items = newItems
 
-	if err := s.db.Create(newInvoice).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		number, seqNum, err := nextDocumentNumber(tx, tenantID, models.SequenceDocumentInvoice)
+		if err != nil {
+			return fmt.Errorf("failed to generate invoice number: %w", err)
+		}
+		newInvoice.InvoiceNumber = number
+		newInvoice.SequenceNumber = seqNum
+		return tx.Create(newInvoice).Error
+	})
+	if err != nil {
 		return fmt.Errorf("failed to create recurring invoice: %w", err)
 	}
 
@@ -118,17 +192,6 @@ func (s *RecurringInvoiceService) createRecurringInvoice(parent *models.Invoice)
 	return nil
 }
 
-func (s *RecurringInvoiceService) generateInvoiceNumber(tenantID string) string {
-	year := time.Now().Year()
-	month := time.Now().Month()
-
-	var count int64
-	s.db.Model(&models.Invoice{}).Where("tenant_id = ? AND created_at >= ?",
-		tenantID, time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)).Count(&count)
-
-	return fmt.Sprintf("INV-%d%02d-%04d", year, month, count+1)
-}
-
 func (s *RecurringInvoiceService) calculateNextDate(frequency string, lastDate time.Time) time.Time {
 	switch frequency {
 	case "daily":
@@ -2,12 +2,16 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"invoicefast/internal/database"
 	"invoicefast/internal/logger"
 	"invoicefast/internal/models"
+
+	"gorm.io/gorm"
 )
 
 // ReminderService handles automated payment reminders
@@ -16,6 +20,7 @@ type ReminderService struct {
 	emailService   *EmailService
 	waService     *WhatsAppService
 	notificationSvc *NotificationService
+	jobQueue        *JobQueueService
 }
 
 // ReminderConfig for configuring reminder schedules
@@ -52,6 +57,11 @@ func NewReminderService(db *database.DB, deps *ServiceDependencies) *ReminderSer
 	}
 }
 
+// SetJobQueue lets ScheduleReminder queue reminders for the job dispatcher
+func (s *ReminderService) SetJobQueue(queue *JobQueueService) {
+	s.jobQueue = queue
+}
+
 // RunReminders checks and sends due reminders
 func (s *ReminderService) RunReminders() error {
 	logger.Get().Info(context.Background(), "Running scheduled reminders")
@@ -327,23 +337,86 @@ type ReminderSchedule struct {
 	FailureReason string    `json:"failure_reason,omitempty"`
 }
 
-// ScheduleReminder creates a future reminder
+// reminderJobPayload is the payload of a models.JobTypeReminder job
+type reminderJobPayload struct {
+	InvoiceID    string `json:"invoice_id"`
+	ReminderType string `json:"reminder_type"` // due_soon, overdue_<days>
+}
+
+func reminderJobKey(invoiceID, reminderType string) string {
+	return fmt.Sprintf("reminder_%s_%s", invoiceID, reminderType)
+}
+
+// ScheduleReminder queues a reminder job the job dispatcher runs at sendAt
 func (s *ReminderService) ScheduleReminder(invoiceID, reminderType string, sendAt time.Time) error {
-	// In production: store reminder in database with:
-	// - invoiceID
-	// - reminderType
-	// - sendAt time
+	if s.jobQueue == nil {
+		return errors.New("job queue not configured")
+	}
+	var invoice models.Invoice
+	if err := s.db.Select("id", "tenant_id").First(&invoice, "id = ?", invoiceID).Error; err != nil {
+		return ErrInvoiceNotFound
+	}
+
+	payload, _ := json.Marshal(reminderJobPayload{InvoiceID: invoiceID, ReminderType: reminderType})
+	key := reminderJobKey(invoiceID, reminderType)
+	if err := s.jobQueue.EnqueueJobWithIdempotency(key, &models.AutomationJob{
+		TenantID:       invoice.TenantID,
+		JobType:        models.JobTypeReminder,
+		Priority:       1,
+		Payload:        string(payload),
+		RunAt:          sendAt,
+		MaxRetries:     3,
+		IdempotencyKey: key,
+		InvoiceID:      &invoice.ID,
+	}); err != nil {
+		return err
+	}
 	logger.Get().Info(context.Background(), "Scheduled reminder for invoice", "invoice_id", invoiceID, "send_at", sendAt)
 	return nil
 }
 
-// CancelReminder cancels a scheduled reminder
+// CancelReminder drops a scheduled reminder that has not been sent yet
 func (s *ReminderService) CancelReminder(invoiceID, reminderType string) error {
-	// In production, update database
+	if err := s.db.Where("idempotency_key = ? AND job_type = ? AND status = ?",
+		reminderJobKey(invoiceID, reminderType), models.JobTypeReminder, models.JobStatusPending).
+		Delete(&models.AutomationJob{}).Error; err != nil {
+		return err
+	}
 	logger.Get().Info(context.Background(), "Cancelled reminder for invoice", "invoice_id", invoiceID, "reminder_type", reminderType)
 	return nil
 }
 
+// ProcessReminderJob sends the reminder of a reminder job. Invoices paid,
+// cancelled or deleted since it was scheduled are skipped.
+func (s *ReminderService) ProcessReminderJob(ctx context.Context, job *models.AutomationJob) error {
+	var payload reminderJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("invalid reminder job payload: %w", err)
+	}
+
+	var invoice models.Invoice
+	if err := s.db.WithContext(ctx).Scopes(database.TenantFilter(job.TenantID)).First(&invoice, "id = ?", payload.InvoiceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	switch invoice.Status {
+	case models.InvoiceStatusSent, models.InvoiceStatusViewed, models.InvoiceStatusPartiallyPaid, models.InvoiceStatusOverdue:
+	default:
+		return nil
+	}
+
+	if payload.ReminderType == "due_soon" {
+		return s.sendDueSoonReminder(&invoice)
+	}
+	var daysOverdue int
+	if _, err := fmt.Sscanf(payload.ReminderType, "overdue_%d", &daysOverdue); err != nil {
+		return fmt.Errorf("unknown reminder type %q", payload.ReminderType)
+	}
+	return s.sendOverdueReminder(&invoice, daysOverdue)
+}
+
 // GetReminderHistory returns reminder history for an invoice
 func (s *ReminderService) GetReminderHistory(invoiceID string) ([]models.Reminder, error) {
 	var reminders []models.Reminder
@@ -9,10 +9,13 @@ import (
 
 	"invoicefast/internal/database"
 	"invoicefast/internal/models"
+	"invoicefast/internal/pdf"
 )
 
 type ReportService struct {
-	db *database.DB
+	db           *database.DB
+	pdfGenerator *pdf.PDFGenerator
+	rates        *ExchangeRateService
 }
 
 func NewReportService(db *database.DB) *ReportService {
@@ -254,6 +257,86 @@ func (s *ReportService) GetInvoiceStats(tenantID string, period string) (map[str
 	return stats, nil
 }
 
+type QuoteConversionReport struct {
+	Period              string           `json:"period"`
+	TotalQuotes         int64            `json:"total_quotes"`
+	SentQuotes          int64            `json:"sent_quotes"`
+	AcceptedQuotes      int64            `json:"accepted_quotes"`
+	DeclinedQuotes      int64            `json:"declined_quotes"`
+	ExpiredQuotes       int64            `json:"expired_quotes"`
+	OpenQuotes          int64            `json:"open_quotes"`
+	ConversionRate      float64          `json:"conversion_rate"` // accepted / sent, %
+	QuotedValue         float64          `json:"quoted_value"`
+	AcceptedValue       float64          `json:"accepted_value"`
+	ValueConversionRate float64          `json:"value_conversion_rate"` // accepted value / quoted value, %
+	AvgDaysToAccept     float64          `json:"avg_days_to_accept"`
+	ByStatus            map[string]int64 `json:"by_status"`
+}
+
+// GetQuoteConversion reports how many quotes sent in the period were accepted and converted
+func (s *ReportService) GetQuoteConversion(tenantID string, period string) (*QuoteConversionReport, error) {
+	start, end := s.getDateRange(period)
+
+	var quotes []models.Quote
+	err := s.db.Model(&models.Quote{}).
+		Select("status, total, sent_at, accepted_at, expiry_date, created_at").
+		Where("tenant_id = ? AND created_at BETWEEN ? AND ?", tenantID, start, end).
+		Find(&quotes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := &QuoteConversionReport{Period: period, ByStatus: make(map[string]int64)}
+	var sentValue, acceptDays float64
+	now := time.Now()
+	for i := range quotes {
+		q := &quotes[i]
+		status := q.Status
+		if q.IsExpired(now) {
+			status = models.QuoteStatusExpired
+		}
+		report.TotalQuotes++
+		report.ByStatus[string(status)]++
+		if status == models.QuoteStatusDraft {
+			continue
+		}
+
+		// Quotes accepted on the client's behalf may never have been sent
+		report.SentQuotes++
+		sentValue += q.Total.Float64()
+		switch status {
+		case models.QuoteStatusAccepted:
+			report.AcceptedQuotes++
+			report.AcceptedValue += q.Total.Float64()
+			if q.AcceptedAt != nil {
+				from := q.CreatedAt
+				if q.SentAt != nil {
+					from = *q.SentAt
+				}
+				acceptDays += q.AcceptedAt.Sub(from).Hours() / 24
+			}
+		case models.QuoteStatusDeclined:
+			report.DeclinedQuotes++
+		case models.QuoteStatusExpired:
+			report.ExpiredQuotes++
+		default:
+			report.OpenQuotes++
+		}
+	}
+
+	report.QuotedValue = sentValue
+	if report.SentQuotes > 0 {
+		report.ConversionRate = math.Round(float64(report.AcceptedQuotes)/float64(report.SentQuotes)*10000) / 100
+	}
+	if sentValue > 0 {
+		report.ValueConversionRate = math.Round(report.AcceptedValue/sentValue*10000) / 100
+	}
+	if report.AcceptedQuotes > 0 {
+		report.AvgDaysToAccept = math.Round(acceptDays/float64(report.AcceptedQuotes)*10) / 10
+	}
+	return report, nil
+}
+
 func (s *ReportService) GetPayments(tenantID string, period string) ([]RevenueDataPoint, error) {
 	start, end := s.getDateRange(period)
 
@@ -521,10 +604,43 @@ type VATReport struct {
 	ZeroRatedSales   float64      `json:"zero_rated_sales"`
 	TotalSales       float64      `json:"total_sales"`
 	InvoiceCount     int          `json:"invoice_count"`
+	// VAT withheld by clients: certificates received can be claimed, the rest is still owed a certificate
+	WithholdingVATCredit  float64 `json:"withholding_vat_credit"`
+	WithholdingVATPending float64 `json:"withholding_vat_pending"`
+	// Supplier invoices whose input VAT is claimed, one per expense
+	Purchases []VATPurchase `json:"purchases"`
+	// Input VAT on expenses whose supplier invoice isn't verified on eTIMS can't be claimed
+	ExcludedInputTax float64           `json:"excluded_input_tax"`
+	ExcludedExpenses []ExcludedExpense `json:"excluded_expenses"`
 	MonthlyBreakdown []MonthlyVAT  `json:"monthly_breakdown"`
 	VATReturn        *VATReturn   `json:"vat_return,omitempty"`
 }
 
+// VATPurchase is a supplier invoice whose input VAT is claimed on the return
+type VATPurchase struct {
+	ID           string  `json:"id"`
+	Date         string  `json:"date"`
+	Vendor       string  `json:"vendor"`
+	SupplierPIN  string  `json:"supplier_pin"`
+	SupplierICN  string  `json:"supplier_icn"`
+	Title        string  `json:"title"`
+	TaxableValue float64 `json:"taxable_value"`
+	TaxAmount    float64 `json:"tax_amount"`
+}
+
+// ExcludedExpense is an expense whose input VAT was left off the return
+type ExcludedExpense struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Vendor      string  `json:"vendor"`
+	SupplierPIN string  `json:"supplier_pin"`
+	SupplierICN string  `json:"supplier_icn"`
+	Date        string  `json:"date"`
+	TaxAmount   float64 `json:"tax_amount"`
+	ETIMSStatus string  `json:"etims_status"`
+	Reason      string  `json:"reason"`
+}
+
 type MonthlyVAT struct {
 	Month        string  `json:"month"`
 	Sales        float64 `json:"sales"`
@@ -543,8 +659,12 @@ type VATReturn struct {
 	Box6            float64 `json:"box6"` // Total zero-rated supplies
 	Box7            float64 `json:"box7"` // Total standard-rated supplies
 	Box8            float64 `json:"box8"` // Total value of VAT claimed
+	Box9            float64 `json:"box9"` // VAT withheld by clients (certificates received)
+	Box10           float64 `json:"box10"` // Net VAT payable after withholding credits
 	InvoiceCount    int     `json:"invoice_count"`
-	ExpenseCount    int     `json:"expense_count"`
+	ExpenseCount    int     `json:"expense_count"` // expenses with verified input VAT
+	ExcludedInputTax     float64 `json:"excluded_input_tax"`
+	ExcludedExpenseCount int     `json:"excluded_expense_count"`
 }
 
 func (s *ReportService) GetVATReport(tenantID, period string) (*VATReport, error) {
@@ -558,55 +678,101 @@ func (s *ReportService) GetVATReport(tenantID, period string) (*VATReport, error
 
 	// OUTPUT TAX - Sales VAT from invoices
 	var salesResult struct {
-		Sales float64
-		Tax   float64
+		Sales int64
+		Tax   int64
 		Count int64
 	}
 	s.db.Model(&models.Invoice{}).
 		Where("tenant_id = ? AND created_at BETWEEN ? AND ? AND tax_rate > 0", tenantID, start, end).
-		Select("COALESCE(SUM(total), 0) as sales, COALESCE(SUM(tax_amount), 0) as tax, COUNT(*) as count").
+		Select("COALESCE(SUM(total), 0) as sales, COALESCE(SUM(total_tax), 0) as tax, COUNT(*) as count").
 		Scan(&salesResult)
 
-	report.OutputTax = salesResult.Tax
-	report.TaxableSales = salesResult.Sales
+	report.OutputTax = models.Money(salesResult.Tax).Float64()
+	report.TaxableSales = models.Money(salesResult.Sales).Float64()
 	report.InvoiceCount = int(salesResult.Count)
 
 	// Zero-rated sales
-	var zeroRated float64
+	var zeroRated int64
 	s.db.Model(&models.Invoice{}).
 		Where("tenant_id = ? AND created_at BETWEEN ? AND ? AND tax_rate = 0", tenantID, start, end).
 		Select("COALESCE(SUM(total), 0)").
 		Scan(&zeroRated)
-	report.ZeroRatedSales = zeroRated
+	report.ZeroRatedSales = models.Money(zeroRated).Float64()
 
 	// Total sales
-	var totalAll float64
+	var totalAll int64
 	s.db.Model(&models.Invoice{}).
 		Where("tenant_id = ? AND created_at BETWEEN ? AND ?", tenantID, start, end).
 		Select("COALESCE(SUM(total), 0)").
 		Scan(&totalAll)
-	report.TotalSales = totalAll
+	report.TotalSales = models.Money(totalAll).Float64()
+
+	report.ExemptSales = report.TotalSales - report.TaxableSales - report.ZeroRatedSales
+
+	// INPUT TAX - VAT from expenses (purchases) whose supplier invoice is
+	// verified on eTIMS; the rest is listed but not claimed
+	var claimed []models.Expense
+	s.db.Where("tenant_id = ? AND date BETWEEN ? AND ? AND tax_amount > 0 AND etims_status = ?", tenantID, start, end, models.ExpenseETIMSVerified).
+		Order("date").Find(&claimed)
+	var inputTax models.Money
+	report.Purchases = make([]VATPurchase, 0, len(claimed))
+	for _, e := range claimed {
+		inputTax = inputTax.Add(e.TaxAmount)
+		report.Purchases = append(report.Purchases, VATPurchase{
+			ID:           e.ID,
+			Date:         e.Date.Format("2006-01-02"),
+			Vendor:       e.Vendor,
+			SupplierPIN:  e.SupplierPIN,
+			SupplierICN:  e.SupplierICN,
+			Title:        e.Title,
+			TaxableValue: e.Amount.Sub(e.TaxAmount).Float64(),
+			TaxAmount:    e.TaxAmount.Float64(),
+		})
+	}
 
-	report.ExemptSales = totalAll - report.TaxableSales - report.ZeroRatedSales
+	report.InputTax = inputTax.Float64()
+	report.NetVAT = report.OutputTax - report.InputTax
 
-	// INPUT TAX - VAT from expenses (purchases)
-	var expenseResult struct {
-		Tax   float64
-		Count int64
+	var excluded []models.Expense
+	s.db.Where("tenant_id = ? AND date BETWEEN ? AND ? AND tax_amount > 0", tenantID, start, end).
+		Where("etims_status IS NULL OR etims_status <> ?", models.ExpenseETIMSVerified).
+		Order("date").Find(&excluded)
+	var excludedTax models.Money
+	report.ExcludedExpenses = make([]ExcludedExpense, 0, len(excluded))
+	for _, e := range excluded {
+		excludedTax = excludedTax.Add(e.TaxAmount)
+		status := e.ETIMSStatus
+		if status == "" {
+			status = models.ExpenseETIMSUnverified
+		}
+		reason := e.ETIMSNote
+		if reason == "" {
+			reason = "supplier invoice not verified on eTIMS"
+		}
+		report.ExcludedExpenses = append(report.ExcludedExpenses, ExcludedExpense{
+			ID:          e.ID,
+			Title:       e.Title,
+			Vendor:      e.Vendor,
+			SupplierPIN: e.SupplierPIN,
+			SupplierICN: e.SupplierICN,
+			Date:        e.Date.Format("2006-01-02"),
+			TaxAmount:   e.TaxAmount.Float64(),
+			ETIMSStatus: status,
+			Reason:      reason,
+		})
 	}
-	s.db.Model(&models.Expense{}).
-		Where("tenant_id = ? AND date BETWEEN ? AND ? AND tax_amount > 0", tenantID, start, end).
-		Select("COALESCE(SUM(tax_amount), 0) as tax, COUNT(*) as count").
-		Scan(&expenseResult)
+	report.ExcludedInputTax = excludedTax.Float64()
 
-	report.InputTax = expenseResult.Tax
-	report.NetVAT = report.OutputTax - report.InputTax
+	// Withholding VAT
+	credit, pending := s.withholdingVAT(tenantID, start, end)
+	report.WithholdingVATCredit = credit.Float64()
+	report.WithholdingVATPending = pending.Float64()
 
 	// Monthly breakdown
 	report.MonthlyBreakdown = s.getMonthlyVATBreakdown(tenantID, start, end)
 
 	// Generate VAT Return
-	report.VATReturn = s.generateVATReturn(report, period, start, end, int(salesResult.Count), int(expenseResult.Count))
+	report.VATReturn = s.generateVATReturn(report, period, start, end, int(salesResult.Count), len(claimed))
 
 	return report, nil
 }
@@ -618,7 +784,7 @@ func (s *ReportService) getMonthlyVATBreakdown(tenantID string, start, end time.
 		SELECT 
 			strftime('%Y-%m', created_at) as month,
 			COALESCE(SUM(total), 0) as sales,
-			COALESCE(SUM(tax_amount), 0) as tax,
+			COALESCE(SUM(total_tax), 0) as tax,
 			COUNT(*) as count
 		FROM invoices
 		WHERE tenant_id = ? AND created_at BETWEEN ? AND ?
@@ -634,8 +800,11 @@ func (s *ReportService) getMonthlyVATBreakdown(tenantID string, start, end time.
 	for rows.Next() {
 		var m MonthlyVAT
 		var monthStr string
-		rows.Scan(&monthStr, &m.Sales, &m.Tax, &m.InvoiceCount)
+		var sales, tax int64
+		rows.Scan(&monthStr, &sales, &tax, &m.InvoiceCount)
 		m.Month = monthStr
+		m.Sales = models.Money(sales).Float64()
+		m.Tax = models.Money(tax).Float64()
 		breakdown = append(breakdown, m)
 	}
 
@@ -654,8 +823,11 @@ func (s *ReportService) generateVATReturn(report *VATReport, period string, star
 		Box6:            report.ZeroRatedSales,
 		Box7:            report.TaxableSales,
 		Box8:            report.InputTax,
+		Box9:            report.WithholdingVATCredit,
 		InvoiceCount:    invoiceCount,
 		ExpenseCount:    expenseCount,
+		ExcludedInputTax:     report.ExcludedInputTax,
+		ExcludedExpenseCount: len(report.ExcludedExpenses),
 	}
 
 	// Ensure non-negative values for KRA return
@@ -668,6 +840,8 @@ func (s *ReportService) generateVATReturn(report *VATReport, period string, star
 	if vatReturn.Box3 < 0 {
 		vatReturn.Box3 = 0
 	}
+	// Withholding credits beyond what's payable carry forward
+	vatReturn.Box10 = math.Max(vatReturn.Box3-vatReturn.Box9, 0)
 
 	return vatReturn
 }
@@ -676,6 +850,7 @@ func (s *ReportService) ExportReport(tenantID, format, reportType, period string
 	var data interface{}
 	var err error
 
+	reportType = ReportExportType(reportType)
 	switch reportType {
 	case "overview":
 		data, err = s.GetOverview(tenantID, period)
@@ -693,6 +868,8 @@ func (s *ReportService) ExportReport(tenantID, format, reportType, period string
 		data, err = s.GetAgingReport(tenantID)
 	case "fraud":
 		data, err = s.GetFraudRiskReport(tenantID, period)
+	case "quotes":
+		data, err = s.GetQuoteConversion(tenantID, period)
 	default:
 		data, err = s.GetOverview(tenantID, period)
 	}
@@ -701,17 +878,19 @@ func (s *ReportService) ExportReport(tenantID, format, reportType, period string
 		return nil, fmt.Errorf("failed to get %s report: %w", reportType, err)
 	}
 
-	return s.formatExport(data, format, reportType)
+	return s.formatExport(data, format, reportType, s.tenantCurrency(tenantID))
 }
 
-func (s *ReportService) formatExport(data interface{}, format, reportType string) ([]byte, error) {
+func (s *ReportService) formatExport(data interface{}, format, reportType, currency string) ([]byte, error) {
 	switch format {
 	case "csv":
 		return s.toCSV(data, reportType)
 	case "json":
 		return s.toJSON(data)
-	case "excel":
-		return s.toCSV(data, reportType) // Excel-compatible CSV
+	case "excel", "xlsx":
+		return s.toXLSX(data, reportType, currency)
+	case "pdf":
+		return s.toPDF(data, reportType, currency)
 	default:
 		return s.toJSON(data)
 	}
@@ -760,7 +939,24 @@ func (s *ReportService) toCSV(data interface{}, reportType string) ([]byte, erro
 		lines = append(lines, fmt.Sprintf("Output Tax (Sales VAT),%.2f", v.OutputTax))
 		lines = append(lines, fmt.Sprintf("Input Tax (Purchase VAT),%.2f", v.InputTax))
 		lines = append(lines, fmt.Sprintf("Net VAT,%.2f", v.NetVAT))
+		lines = append(lines, fmt.Sprintf("Input Tax Not Claimed (unverified on eTIMS),%.2f", v.ExcludedInputTax))
 		lines = append(lines, "")
+		if len(v.Purchases) > 0 {
+			lines = append(lines, "=== Purchases ===")
+			lines = append(lines, "Date,Supplier,Supplier PIN,ICN,Description,Taxable Value,VAT")
+			for _, p := range v.Purchases {
+				lines = append(lines, fmt.Sprintf("%s,%s,%s,%s,%s,%.2f,%.2f", p.Date, p.Vendor, p.SupplierPIN, p.SupplierICN, p.Title, p.TaxableValue, p.TaxAmount))
+			}
+			lines = append(lines, "")
+		}
+		if len(v.ExcludedExpenses) > 0 {
+			lines = append(lines, "=== Expenses Excluded From Input Tax ===")
+			lines = append(lines, "Date,Title,Vendor,Supplier PIN,ICN,VAT,eTIMS Status,Reason")
+			for _, e := range v.ExcludedExpenses {
+				lines = append(lines, fmt.Sprintf("%s,%s,%s,%s,%s,%.2f,%s,%s", e.Date, e.Title, e.Vendor, e.SupplierPIN, e.SupplierICN, e.TaxAmount, e.ETIMSStatus, e.Reason))
+			}
+			lines = append(lines, "")
+		}
 		if len(v.MonthlyBreakdown) > 0 {
 			lines = append(lines, "=== Monthly Breakdown ===")
 			lines = append(lines, "Month,Sales,VAT,Invoice Count")
@@ -780,6 +976,8 @@ func (s *ReportService) toCSV(data interface{}, reportType string) ([]byte, erro
 			lines = append(lines, fmt.Sprintf("Box6,Zero-Rated Supplies,%.2f", v.VATReturn.Box6))
 			lines = append(lines, fmt.Sprintf("Box7,Standard-Rated Supplies,%.2f", v.VATReturn.Box7))
 			lines = append(lines, fmt.Sprintf("Box8,Total VAT Claimed,%.2f", v.VATReturn.Box8))
+			lines = append(lines, fmt.Sprintf("Box9,VAT Withheld by Clients,%.2f", v.VATReturn.Box9))
+			lines = append(lines, fmt.Sprintf("Box10,Net VAT Payable After Withholding,%.2f", v.VATReturn.Box10))
 		}
 
 	case *FraudRiskReport:
@@ -791,6 +989,20 @@ func (s *ReportService) toCSV(data interface{}, reportType string) ([]byte, erro
 			lines = append(lines, fmt.Sprintf("Pattern,%s", p))
 		}
 
+	case *QuoteConversionReport:
+		lines = append(lines, "Metric,Value")
+		lines = append(lines, fmt.Sprintf("Quotes Created,%d", v.TotalQuotes))
+		lines = append(lines, fmt.Sprintf("Quotes Sent,%d", v.SentQuotes))
+		lines = append(lines, fmt.Sprintf("Accepted,%d", v.AcceptedQuotes))
+		lines = append(lines, fmt.Sprintf("Declined,%d", v.DeclinedQuotes))
+		lines = append(lines, fmt.Sprintf("Expired,%d", v.ExpiredQuotes))
+		lines = append(lines, fmt.Sprintf("Open,%d", v.OpenQuotes))
+		lines = append(lines, fmt.Sprintf("Conversion Rate,%.2f%%", v.ConversionRate))
+		lines = append(lines, fmt.Sprintf("Quoted Value,%.2f", v.QuotedValue))
+		lines = append(lines, fmt.Sprintf("Accepted Value,%.2f", v.AcceptedValue))
+		lines = append(lines, fmt.Sprintf("Value Conversion Rate,%.2f%%", v.ValueConversionRate))
+		lines = append(lines, fmt.Sprintf("Avg Days To Accept,%.1f", v.AvgDaysToAccept))
+
 	default:
 		lines = append(lines, "Use JSON format for full data export")
 	}
@@ -813,6 +1025,10 @@ type AgingReport struct {
 	Overdue90    float64 `json:"overdue_90"` // 91+ days
 	Total        float64 `json:"total"`
 	InvoiceCount int64   `json:"invoice_count"`
+
+	// Foreign-currency balances restated at today's rate
+	ForeignBalances  []ForeignBalance `json:"foreign_balances,omitempty"`
+	UnrealizedFXGain float64          `json:"unrealized_fx_gain"`
 }
 
 func (s *ReportService) GetAgingReport(tenantID string) (*AgingReport, error) {
@@ -860,51 +1076,69 @@ func (s *ReportService) GetAgingReport(tenantID string) (*AgingReport, error) {
 		Where("tenant_id = ? AND status = 'overdue'", tenantID).
 		Count(&report.InvoiceCount)
 
+	foreign, err := s.foreignBalances(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	report.ForeignBalances = foreign
+	report.UnrealizedFXGain = totalUnrealizedFX(foreign)
+
 	return report, nil
 }
 
 type IncomeStatement struct {
-	Revenue      float64 `json:"revenue"`
-	CostOfSales  float64 `json:"cost_of_sales"`
-	GrossProfit  float64 `json:"gross_profit"`
-	OperatingExp float64 `json:"operating_expenses"`
-	NetProfit    float64 `json:"net_profit"`
-	Period       string  `json:"period"`
+	Revenue          float64 `json:"revenue"`
+	CostOfSales      float64 `json:"cost_of_sales"`
+	GrossProfit      float64 `json:"gross_profit"`
+	OperatingExp     float64 `json:"operating_expenses"`
+	RealizedFXGain   float64 `json:"realized_fx_gain"`   // on foreign-currency payments
+	UnrealizedFXGain float64 `json:"unrealized_fx_gain"` // from month-end revaluations
+	NetProfit        float64 `json:"net_profit"`
+	Period           string  `json:"period"`
 }
 
 func (s *ReportService) GetIncomeStatement(tenantID string, period string) (*IncomeStatement, error) {
 	start, end := s.getDateRange(period)
 	stmt := &IncomeStatement{Period: period}
 
-	// Revenue from completed payments
+	// Revenue from completed payments, in KES at their invoices' rates; the
+	// difference from the rate they settled at is the realized FX gain below
+	var revenue float64
 	s.db.Model(&models.Payment{}).
-		Where("tenant_id = ? AND status = 'completed' AND created_at BETWEEN ? AND ?", tenantID, start, end).
-		Select("COALESCE(SUM(amount), 0)").
-		Scan(&stmt.Revenue)
+		Joins("LEFT JOIN invoices ON invoices.id = payments.invoice_id").
+		Where("payments.tenant_id = ? AND payments.status = 'completed' AND payments.created_at BETWEEN ? AND ?", tenantID, start, end).
+		Select("COALESCE(SUM(CASE WHEN payments.currency NOT IN ('', 'KES') AND payments.currency = invoices.currency AND invoices.exchange_rate > 0 " +
+			"THEN payments.amount * invoices.exchange_rate ELSE payments.amount END), 0)").
+		Scan(&revenue)
+	stmt.Revenue = models.Money(math.Round(revenue)).Float64()
 
 	// Cost of sales from expenses (cost of goods sold)
-	var costOfSales float64
+	var costOfSales int64
 	s.db.Model(&models.Expense{}).
 		Where("tenant_id = ? AND status IN ('approved', 'paid') AND created_at BETWEEN ? AND ?", tenantID, start, end).
 		Joins("JOIN expense_categories ec ON expenses.category_id = ec.id").
 		Where("ec.name ILIKE ? OR ec.name ILIKE ? OR ec.name ILIKE ?", "%cost%", "%goods%", "%inventory%").
 		Select("COALESCE(SUM(amount), 0)").
 		Scan(&costOfSales)
-	stmt.CostOfSales = costOfSales
+	stmt.CostOfSales = models.Money(costOfSales).Float64()
 
 	stmt.GrossProfit = stmt.Revenue - stmt.CostOfSales
 
 	// Operating expenses (all other expenses)
-	var operatingExp float64
+	var operatingExp int64
 	s.db.Model(&models.Expense{}).
 		Where("tenant_id = ? AND status IN ('approved', 'paid') AND created_at BETWEEN ? AND ?", tenantID, start, end).
 		Joins("JOIN expense_categories ec ON expenses.category_id = ec.id").
 		Where("(ec.name NOT ILIKE ? AND ec.name NOT ILIKE ? AND ec.name NOT ILIKE ?) OR ec.id IS NULL", "%cost%", "%goods%", "%inventory%").
 		Select("COALESCE(SUM(amount), 0)").
 		Scan(&operatingExp)
-	stmt.OperatingExp = operatingExp
+	stmt.OperatingExp = models.Money(operatingExp).Float64()
+
+	realized, unrealized := s.fxGains(tenantID, start, end)
+	stmt.RealizedFXGain = realized.Float64()
+	stmt.UnrealizedFXGain = unrealized.Float64()
 
-	stmt.NetProfit = stmt.GrossProfit - stmt.OperatingExp
+	stmt.NetProfit = stmt.GrossProfit - stmt.OperatingExp + stmt.RealizedFXGain + stmt.UnrealizedFXGain
 
 	return stmt, nil
 }
@@ -945,25 +1179,25 @@ func (s *ReportService) GetClientStatement(tenantID, clientID string, startDate,
 	// Get opening balance (before start date)
 	var openingInvoices, openingPayments float64
 	s.db.Model(&models.Invoice{}).
-		Where("tenant_id = ? AND client_id = ? AND created_at < ?", tenantID, clientID, startDate).
+		Where("tenant_id = ? AND client_id = ? AND status <> ? AND created_at < ?", tenantID, clientID, models.InvoiceStatusDraft, startDate).
 		Select("COALESCE(SUM(total), 0)").
 		Scan(&openingInvoices)
 	s.db.Model(&models.Payment{}).
-		Where("tenant_id = ? AND invoice_id IN (SELECT id FROM invoices WHERE client_id = ?) AND created_at < ?", tenantID, clientID, startDate).
+		Where("tenant_id = ? AND invoice_id IN (SELECT id FROM invoices WHERE client_id = ?) AND status = ? AND created_at < ?", tenantID, clientID, models.PaymentStatusCompleted, startDate).
 		Select("COALESCE(SUM(amount), 0)").
 		Scan(&openingPayments)
 	stmt.OpeningBal = openingInvoices - openingPayments
 
-	// Get invoices in period
+	// Get issued invoices in period (drafts have not been billed yet)
 	var invoices []models.Invoice
-	s.db.Where("tenant_id = ? AND client_id = ? AND created_at BETWEEN ? AND ?", tenantID, clientID, startDate, endDate).
+	s.db.Where("tenant_id = ? AND client_id = ? AND status <> ? AND created_at BETWEEN ? AND ?", tenantID, clientID, models.InvoiceStatusDraft, startDate, endDate).
 		Order("created_at ASC").
 		Find(&invoices)
 
-	// Get payments in period
+	// Get completed payments in period
 	var payments []models.Payment
 	s.db.Joins("JOIN invoices ON invoices.id = payments.invoice_id").
-		Where("invoices.tenant_id = ? AND invoices.client_id = ? AND payments.created_at BETWEEN ? AND ?", tenantID, clientID, startDate, endDate).
+		Where("invoices.tenant_id = ? AND invoices.client_id = ? AND payments.status = ? AND payments.created_at BETWEEN ? AND ?", tenantID, clientID, models.PaymentStatusCompleted, startDate, endDate).
 		Order("payments.created_at ASC").
 		Find(&payments)
 
@@ -1341,6 +1575,9 @@ type DetailedAging struct {
 	Over90  float64 `json:"over_90"`
 	Total   float64 `json:"total"`
 	Count   int64  `json:"count"`
+
+	ForeignBalances  []ForeignBalance `json:"foreign_balances,omitempty"`
+	UnrealizedFXGain float64          `json:"unrealized_fx_gain"`
 }
 
 func (s *ReportService) GetDetailedAging(tenantID string) (*DetailedAging, error) {
@@ -1372,6 +1609,13 @@ func (s *ReportService) GetDetailedAging(tenantID string) (*DetailedAging, error
 		report.Total += balance
 	}
 
+	foreign, err := s.foreignBalances(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	report.ForeignBalances = foreign
+	report.UnrealizedFXGain = totalUnrealizedFX(foreign)
+
 	return report, nil
 }
 
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"invoicefast/internal/models"
+	"invoicefast/internal/pdf"
+	"invoicefast/internal/xlsx"
+)
+
+// ============================================================================
+// REPORT EXPORT - XLSX workbooks and PDF renderings of ExportReport data
+// ============================================================================
+
+var ErrPDFExportUnavailable = errors.New("PDF export is not available")
+
+// SetPDFGenerator enables format=pdf exports (optional)
+func (s *ReportService) SetPDFGenerator(generator *pdf.PDFGenerator) {
+	s.pdfGenerator = generator
+}
+
+// reportExportTypes are the reports ExportReport can produce
+var reportExportTypes = map[string]bool{
+	"overview": true, "revenue": true, "invoices": true, "payments": true, "clients": true,
+	"tax": true, "aging": true, "fraud": true, "quotes": true,
+}
+
+// ReportExportType returns reportType if it is a report that can be exported,
+// and the overview that is exported in its place otherwise
+func ReportExportType(reportType string) string {
+	if reportExportTypes[reportType] {
+		return reportType
+	}
+	return "overview"
+}
+
+// ReportExportFile returns the content type and file name for an export format
+func ReportExportFile(format, reportType string) (contentType, filename string) {
+	name := "report-" + ReportExportType(reportType) + "-" + time.Now().Format("20060102")
+	switch format {
+	case "csv":
+		return "text/csv", name + ".csv"
+	case "excel", "xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", name + ".xlsx"
+	case "pdf":
+		return "application/pdf", name + ".pdf"
+	default:
+		return "application/json", name + ".json"
+	}
+}
+
+// tenantCurrency returns the tenant's default currency for money formats
+func (s *ReportService) tenantCurrency(tenantID string) string {
+	var currency string
+	s.db.Model(&models.Tenant{}).Where("id = ?", tenantID).Select("currency").Scan(&currency)
+	if currency == "" {
+		return "KES"
+	}
+	return currency
+}
+
+func (s *ReportService) toXLSX(data interface{}, reportType, currency string) ([]byte, error) {
+	return reportWorkbook(data, reportType, currency).Bytes()
+}
+
+// toPDF renders the same sheets as the workbook as HTML tables and converts them
+func (s *ReportService) toPDF(data interface{}, reportType, currency string) ([]byte, error) {
+	if s.pdfGenerator == nil {
+		return nil, ErrPDFExportUnavailable
+	}
+	wb := reportWorkbook(data, reportType, currency)
+	out, err := s.pdfGenerator.HtmlToPDF(reportHTML(wb, reportType, currency), "report-"+reportType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPDFExportUnavailable, err)
+	}
+	return out.Content, nil
+}
+
+// reportWorkbook lays a report out as typed sheets, one per section
+func reportWorkbook(data interface{}, reportType, currency string) *xlsx.Workbook {
+	wb := xlsx.NewWorkbook(currency)
+
+	switch v := data.(type) {
+	case *ReportOverview:
+		wb.AddSheet("Overview").SetHeader("Metric", "Value").
+			AddRow(xlsx.Text("Total Revenue"), xlsx.Money(v.TotalRevenue)).
+			AddRow(xlsx.Text("Revenue Change"), xlsx.Percent(v.RevenueChange)).
+			AddRow(xlsx.Text("Paid Invoices"), xlsx.Integer(v.PaidCount)).
+			AddRow(xlsx.Text("Paid Amount"), xlsx.Money(v.PaidAmount)).
+			AddRow(xlsx.Text("Pending Invoices"), xlsx.Integer(v.PendingCount)).
+			AddRow(xlsx.Text("Pending Amount"), xlsx.Money(v.PendingAmount)).
+			AddRow(xlsx.Text("Overdue Invoices"), xlsx.Integer(v.OverdueCount)).
+			AddRow(xlsx.Text("Overdue Amount"), xlsx.Money(v.OverdueAmount)).
+			AddRow(xlsx.Text("Total Invoices"), xlsx.Integer(v.TotalInvoices)).
+			AddRow(xlsx.Text("Total Clients"), xlsx.Integer(v.TotalClients)).
+			AddRow(xlsx.Text("Tax Collected"), xlsx.Money(v.GSTCollected))
+
+	case []RevenueDataPoint:
+		sheet := wb.AddSheet("Revenue").SetHeader("Date", "Revenue")
+		var total float64
+		for _, p := range v {
+			sheet.AddRow(reportDateCell(p.Date), xlsx.Money(p.Value))
+			total += p.Value
+		}
+		sheet.AddRow(xlsx.Text("Total"), xlsx.Money(total))
+
+	case []TopClientRevenue:
+		sheet := wb.AddSheet("Clients").SetHeader("Client", "Email", "Revenue", "Invoices", "Outstanding")
+		for _, c := range v {
+			sheet.AddRow(xlsx.Text(c.Name), xlsx.Text(c.Email), xlsx.Money(c.Revenue), xlsx.Integer(c.Invoices), xlsx.Money(c.Outstanding))
+		}
+
+	case *VATReport:
+		wb.AddSheet("Summary").SetHeader("Item", "Value").
+			AddRow(xlsx.Text("Period (days)"), xlsx.Text(v.Period)).
+			AddRow(xlsx.Text("Start Date"), reportDateCell(v.StartDate)).
+			AddRow(xlsx.Text("End Date"), reportDateCell(v.EndDate)).
+			AddRow(xlsx.Text("Output Tax (Sales VAT)"), xlsx.Money(v.OutputTax)).
+			AddRow(xlsx.Text("Input Tax (Purchase VAT)"), xlsx.Money(v.InputTax)).
+			AddRow(xlsx.Text("Input Tax Not Claimed (unverified on eTIMS)"), xlsx.Money(v.ExcludedInputTax)).
+			AddRow(xlsx.Text("Net VAT"), xlsx.Money(v.NetVAT))
+
+		wb.AddSheet("VAT Sales").SetHeader("Category", "Amount").
+			AddRow(xlsx.Text("Taxable Sales"), xlsx.Money(v.TaxableSales)).
+			AddRow(xlsx.Text("Exempt Sales"), xlsx.Money(v.ExemptSales)).
+			AddRow(xlsx.Text("Zero-Rated Sales"), xlsx.Money(v.ZeroRatedSales)).
+			AddRow(xlsx.Text("Total Sales"), xlsx.Money(v.TotalSales)).
+			AddRow(xlsx.Text("Output Tax"), xlsx.Money(v.OutputTax))
+
+		purchases := wb.AddSheet("VAT Purchases").SetHeader("Date", "Supplier", "Supplier PIN", "ICN", "Description", "Taxable Value", "VAT")
+		var taxable float64
+		for _, p := range v.Purchases {
+			purchases.AddRow(reportDateCell(p.Date), xlsx.Text(p.Vendor), xlsx.Text(p.SupplierPIN), xlsx.Text(p.SupplierICN),
+				xlsx.Text(p.Title), xlsx.Money(p.TaxableValue), xlsx.Money(p.TaxAmount))
+			taxable += p.TaxableValue
+		}
+		purchases.AddRow(xlsx.Text("Total"), xlsx.Text(""), xlsx.Text(""), xlsx.Text(""), xlsx.Text(""), xlsx.Money(taxable), xlsx.Money(v.InputTax))
+
+		if len(v.ExcludedExpenses) > 0 {
+			excluded := wb.AddSheet("Excluded Input Tax").SetHeader("Date", "Title", "Vendor", "Supplier PIN", "ICN", "VAT", "eTIMS Status", "Reason")
+			for _, e := range v.ExcludedExpenses {
+				excluded.AddRow(reportDateCell(e.Date), xlsx.Text(e.Title), xlsx.Text(e.Vendor), xlsx.Text(e.SupplierPIN),
+					xlsx.Text(e.SupplierICN), xlsx.Money(e.TaxAmount), xlsx.Text(e.ETIMSStatus), xlsx.Text(e.Reason))
+			}
+		}
+
+		monthly := wb.AddSheet("Monthly").SetHeader("Month", "Sales", "VAT", "Invoices")
+		for _, m := range v.MonthlyBreakdown {
+			monthly.AddRow(xlsx.Text(m.Month), xlsx.Money(m.Sales), xlsx.Money(m.Tax), xlsx.Integer(int64(m.InvoiceCount)))
+		}
+
+		if r := v.VATReturn; r != nil {
+			wb.AddSheet("VAT Return").SetHeader("Box", "Description", "Amount").
+				AddRow(xlsx.Text("Box 1"), xlsx.Text("Output Tax (Sales VAT)"), xlsx.Money(r.Box1)).
+				AddRow(xlsx.Text("Box 2"), xlsx.Text("Input Tax (Purchase VAT)"), xlsx.Money(r.Box2)).
+				AddRow(xlsx.Text("Box 3"), xlsx.Text("Net VAT Payable/Receivable"), xlsx.Money(r.Box3)).
+				AddRow(xlsx.Text("Box 4"), xlsx.Text("Total Taxable Supplies"), xlsx.Money(r.Box4)).
+				AddRow(xlsx.Text("Box 5"), xlsx.Text("Exempt Supplies"), xlsx.Money(r.Box5)).
+				AddRow(xlsx.Text("Box 6"), xlsx.Text("Zero-Rated Supplies"), xlsx.Money(r.Box6)).
+				AddRow(xlsx.Text("Box 7"), xlsx.Text("Standard-Rated Supplies"), xlsx.Money(r.Box7)).
+				AddRow(xlsx.Text("Box 8"), xlsx.Text("Total VAT Claimed"), xlsx.Money(r.Box8)).
+				AddRow(xlsx.Text("Box 9"), xlsx.Text("VAT Withheld by Clients"), xlsx.Money(r.Box9)).
+				AddRow(xlsx.Text("Box 10"), xlsx.Text("Net VAT Payable After Withholding"), xlsx.Money(r.Box10))
+		}
+
+	case *AgingReport:
+		wb.AddSheet("Aging").SetHeader("Bucket", "Amount").
+			AddRow(xlsx.Text("Current (0-30 days)"), xlsx.Money(v.Current)).
+			AddRow(xlsx.Text("31-60 days"), xlsx.Money(v.Overdue30)).
+			AddRow(xlsx.Text("61-90 days"), xlsx.Money(v.Overdue60)).
+			AddRow(xlsx.Text("91+ days"), xlsx.Money(v.Overdue90)).
+			AddRow(xlsx.Text("Total"), xlsx.Money(v.Total)).
+			AddRow(xlsx.Text("Invoices"), xlsx.Integer(v.InvoiceCount))
+
+	case *FraudRiskReport:
+		wb.AddSheet("Summary").SetHeader("Metric", "Value").
+			AddRow(xlsx.Text("Failed Payments"), xlsx.Integer(v.FailedPayments)).
+			AddRow(xlsx.Text("Failed Amount"), xlsx.Money(v.FailedAmount)).
+			AddRow(xlsx.Text("Flagged"), xlsx.Integer(v.FlaggedCount)).
+			AddRow(xlsx.Text("Suspects"), xlsx.Integer(v.SuspectCount))
+
+		risk := wb.AddSheet("Risk Distribution").SetHeader("Risk Level", "Count")
+		for _, level := range sortedKeys(v.RiskDistribution) {
+			risk.AddRow(xlsx.Text(level), xlsx.Integer(v.RiskDistribution[level]))
+		}
+
+		patterns := wb.AddSheet("Patterns").SetHeader("Suspicious Pattern")
+		for _, p := range v.SuspiciousPatterns {
+			patterns.AddRow(xlsx.Text(p))
+		}
+
+	case *QuoteConversionReport:
+		wb.AddSheet("Quote Conversion").SetHeader("Metric", "Value").
+			AddRow(xlsx.Text("Quotes Created"), xlsx.Integer(v.TotalQuotes)).
+			AddRow(xlsx.Text("Quotes Sent"), xlsx.Integer(v.SentQuotes)).
+			AddRow(xlsx.Text("Accepted"), xlsx.Integer(v.AcceptedQuotes)).
+			AddRow(xlsx.Text("Declined"), xlsx.Integer(v.DeclinedQuotes)).
+			AddRow(xlsx.Text("Expired"), xlsx.Integer(v.ExpiredQuotes)).
+			AddRow(xlsx.Text("Open"), xlsx.Integer(v.OpenQuotes)).
+			AddRow(xlsx.Text("Conversion Rate"), xlsx.Percent(v.ConversionRate)).
+			AddRow(xlsx.Text("Quoted Value"), xlsx.Money(v.QuotedValue)).
+			AddRow(xlsx.Text("Accepted Value"), xlsx.Money(v.AcceptedValue)).
+			AddRow(xlsx.Text("Value Conversion Rate"), xlsx.Percent(v.ValueConversionRate)).
+			AddRow(xlsx.Text("Avg Days To Accept"), xlsx.Number(v.AvgDaysToAccept))
+
+	case map[string]interface{}:
+		statsWorkbook(wb, v)
+	}
+
+	if len(wb.Sheets()) == 0 {
+		wb.AddSheet(reportType).SetHeader("Report").AddRow(xlsx.Text("No data for this report"))
+	}
+	return wb
+}
+
+// statsWorkbook lays out the map based invoice and payment stats
+func statsWorkbook(wb *xlsx.Workbook, stats map[string]interface{}) {
+	summary := wb.AddSheet("Summary").SetHeader("Metric", "Value")
+	summaryRows := []struct {
+		key, label string
+		cell       func(float64) xlsx.Cell
+	}{
+		{"total", "Total", func(n float64) xlsx.Cell { return xlsx.Integer(int64(n)) }},
+		{"total_amount", "Total Amount", xlsx.Money},
+		{"success_rate", "Success Rate", xlsx.Percent},
+		{"failed", "Failed", func(n float64) xlsx.Cell { return xlsx.Integer(int64(n)) }},
+		{"refunded", "Refunded", func(n float64) xlsx.Cell { return xlsx.Integer(int64(n)) }},
+	}
+	for _, row := range summaryRows {
+		if n, ok := toFloat(stats[row.key]); ok {
+			summary.AddRow(xlsx.Text(row.label), row.cell(n))
+		}
+	}
+
+	if byStatus, ok := stats["by_status"].(map[string]int64); ok {
+		sheet := wb.AddSheet("By Status").SetHeader("Status", "Invoices")
+		for _, status := range sortedKeys(byStatus) {
+			sheet.AddRow(xlsx.Text(status), xlsx.Integer(byStatus[status]))
+		}
+	}
+
+	if byMethod, ok := stats["by_method"].(map[string]interface{}); ok {
+		sheet := wb.AddSheet("By Method").SetHeader("Method", "Payments", "Amount")
+		for _, method := range sortedKeys(byMethod) {
+			m, _ := byMethod[method].(map[string]interface{})
+			count, _ := toFloat(m["count"])
+			amount, _ := toFloat(m["amount"])
+			sheet.AddRow(xlsx.Text(method), xlsx.Integer(int64(count)), xlsx.Money(amount))
+		}
+	}
+}
+
+// reportDateCell parses report dates (which may come back as timestamps from some drivers)
+func reportDateCell(value string) xlsx.Cell {
+	if len(value) >= 10 {
+		if t, err := time.Parse("2006-01-02", value[:10]); err == nil {
+			return xlsx.Date(t)
+		}
+	}
+	return xlsx.Text(value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// reportHTML renders workbook sheets as print friendly tables for the PDF converter
+func reportHTML(wb *xlsx.Workbook, reportType, currency string) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html><html><head><meta charset="UTF-8"><style>`)
+	b.WriteString(`body{font-family:Arial,sans-serif;font-size:11px;color:#222}`)
+	b.WriteString(`h1{font-size:18px;margin-bottom:2px}h2{font-size:14px;margin:18px 0 6px}.meta{color:#666}`)
+	b.WriteString(`table{width:100%;border-collapse:collapse}th{background:#e7e6e6;text-align:left}`)
+	b.WriteString(`th,td{border:1px solid #ccc;padding:4px 6px}td.num{text-align:right}`)
+	b.WriteString(`</style></head><body>`)
+	fmt.Fprintf(&b, `<h1>%s Report</h1><div class="meta">Generated %s &middot; Amounts in %s</div>`,
+		html.EscapeString(reportTitle(reportType)), time.Now().Format("2006-01-02 15:04"), html.EscapeString(currency))
+
+	for _, sheet := range wb.Sheets() {
+		fmt.Fprintf(&b, `<h2>%s</h2><table>`, html.EscapeString(sheet.Name()))
+		if header := sheet.Header(); len(header) > 0 {
+			b.WriteString(`<tr>`)
+			for _, title := range header {
+				fmt.Fprintf(&b, `<th>%s</th>`, html.EscapeString(title))
+			}
+			b.WriteString(`</tr>`)
+		}
+		for _, row := range sheet.Rows() {
+			b.WriteString(`<tr>`)
+			for _, cell := range row {
+				switch cell.Kind() {
+				case xlsx.KindText, xlsx.KindDate:
+					fmt.Fprintf(&b, `<td>%s</td>`, html.EscapeString(cell.String()))
+				case xlsx.KindMoney, xlsx.KindNumber:
+					fmt.Fprintf(&b, `<td class="num">%s</td>`, formatGroupedAmount(cell.Value()))
+				default:
+					fmt.Fprintf(&b, `<td class="num">%s</td>`, html.EscapeString(cell.String()))
+				}
+			}
+			b.WriteString(`</tr>`)
+		}
+		b.WriteString(`</table>`)
+	}
+
+	b.WriteString(`</body></html>`)
+	return b.String()
+}
+
+// reportTitle capitalizes the report type for headings
+func reportTitle(reportType string) string {
+	if reportType == "" {
+		return "Report"
+	}
+	return strings.ToUpper(reportType[:1]) + reportType[1:]
+}
+
+// formatGroupedAmount formats a number with thousands separators and two decimals
+func formatGroupedAmount(n float64) string {
+	s := fmt.Sprintf("%.2f", n)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, frac := s[:len(s)-3], s[len(s)-3:]
+	var out []byte
+	for i := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			out = append(out, ',')
+		}
+		out = append(out, intPart[i])
+	}
+	return sign + string(out) + frac
+}
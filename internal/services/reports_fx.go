@@ -0,0 +1,108 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"invoicefast/internal/models"
+)
+
+// ============================================================================
+// REPORT FX - foreign-currency balances and FX gain/loss in reports
+// ============================================================================
+
+// ForeignBalance is what's owed in one foreign currency, in KES at the rates
+// the invoices were booked at and at today's rate
+type ForeignBalance struct {
+	Currency         string  `json:"currency"`
+	Balance          float64 `json:"balance"` // in Currency
+	BookValue        float64 `json:"book_value"`
+	CurrentRate      float64 `json:"current_rate"` // KES per unit
+	CurrentValue     float64 `json:"current_value"`
+	UnrealizedFXGain float64 `json:"unrealized_fx_gain"`
+	InvoiceCount     int     `json:"invoice_count"`
+}
+
+// SetExchangeRateService values foreign-currency balances at today's rate in the aging reports (optional)
+func (s *ReportService) SetExchangeRateService(rates *ExchangeRateService) {
+	s.rates = rates
+}
+
+// foreignBalances groups open foreign-currency invoices by currency
+func (s *ReportService) foreignBalances(tenantID string) ([]ForeignBalance, error) {
+	var invoices []models.Invoice
+	if err := s.db.Select("currency", "exchange_rate", "total", "paid_amount").
+		Where("tenant_id = ? AND currency NOT IN ('', 'KES') AND total > paid_amount", tenantID).
+		Where("status NOT IN ?", []models.InvoiceStatus{models.InvoiceStatusDraft, models.InvoiceStatusPaid, models.InvoiceStatusCancelled, models.InvoiceStatusVoid}).
+		Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+
+	type totals struct {
+		balance, book models.Money
+		count         int
+	}
+	byCurrency := make(map[string]*totals)
+	for _, invoice := range invoices {
+		t := byCurrency[invoice.Currency]
+		if t == nil {
+			t = &totals{}
+			byCurrency[invoice.Currency] = t
+		}
+		balance := invoice.Total.Sub(invoice.PaidAmount)
+		t.balance = t.balance.Add(balance)
+		t.book = t.book.Add(balance.Mul(ledgerRate(invoice.Currency, invoice.ExchangeRate)))
+		t.count++
+	}
+
+	balances := make([]ForeignBalance, 0, len(byCurrency))
+	for currency, t := range byCurrency {
+		fb := ForeignBalance{
+			Currency:     currency,
+			Balance:      t.balance.Float64(),
+			BookValue:    t.book.Float64(),
+			CurrentValue: t.book.Float64(),
+			InvoiceCount: t.count,
+		}
+		// Without a rate the balance stays at book value
+		if s.rates != nil {
+			if rate, err := s.rates.RateOn(tenantID, currency, time.Now()); err == nil {
+				current := t.balance.Mul(rate)
+				fb.CurrentRate = rate
+				fb.CurrentValue = current.Float64()
+				fb.UnrealizedFXGain = current.Sub(t.book).Float64()
+			}
+		}
+		balances = append(balances, fb)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Currency < balances[j].Currency })
+	return balances, nil
+}
+
+func totalUnrealizedFX(balances []ForeignBalance) float64 {
+	var total float64
+	for _, b := range balances {
+		total += b.UnrealizedFXGain
+	}
+	return total
+}
+
+// fxGains returns the realized gain posted with payments and the unrealized
+// gain posted by revaluations between two dates
+func (s *ReportService) fxGains(tenantID string, start, end time.Time) (realized, unrealized models.Money) {
+	return s.fxAccountBalance(tenantID, models.LedgerKeyRealizedFX, start, end),
+		s.fxAccountBalance(tenantID, models.LedgerKeyUnrealizedFX, start, end)
+}
+
+// fxAccountBalance is the net credit journaled to an FX account between two dates
+func (s *ReportService) fxAccountBalance(tenantID, systemKey string, start, end time.Time) models.Money {
+	var cents int64
+	s.db.Table("journal_lines").
+		Joins("JOIN journal_entries ON journal_entries.id = journal_lines.entry_id").
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = journal_lines.account_id").
+		Where("journal_entries.tenant_id = ? AND journal_entries.date BETWEEN ? AND ?", tenantID, start, end).
+		Where("ledger_accounts.system_key = ?", systemKey).
+		Select("COALESCE(SUM(journal_lines.credit - journal_lines.debit), 0)").
+		Scan(&cents)
+	return models.Money(cents)
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"invoicefast/internal/models"
+	"invoicefast/internal/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// VAT3 RETURN - iTax upload schedules for one month
+// ============================================================================
+
+var (
+	ErrVAT3PeriodInvalid   = errors.New("period must be a month that has started, as YYYY-MM")
+	ErrVAT3AlreadyExported = errors.New("the VAT3 return for this period has already been exported")
+)
+
+// Column layouts of the iTax VAT3 upload template
+var (
+	vat3SalesRegisteredHeader = []string{
+		"PIN of Purchaser", "Name of Purchaser", "ETR Serial Number", "Invoice Date", "Invoice Number",
+		"Description of Goods / Services", "Taxable Value (Ksh)", "Amount of VAT (Ksh)",
+		"Relevant Invoice Number", "Relevant Invoice Date",
+	}
+	vat3SalesUnregisteredHeader = []string{
+		"Name of Purchaser", "ETR Serial Number", "Invoice Date", "Invoice Number",
+		"Description of Goods / Services", "Taxable Value (Ksh)", "Amount of VAT (Ksh)",
+		"Relevant Invoice Number", "Relevant Invoice Date",
+	}
+	vat3PurchasesHeader = []string{
+		"Type of Purchase", "PIN of Supplier", "Name of Supplier", "ETR Serial Number", "Invoice Date", "Invoice Number",
+		"Description of Goods / Services", "Custom Entry Number", "Taxable Value (Ksh)", "Amount of VAT (Ksh)",
+		"Relevant Invoice Number", "Relevant Invoice Date",
+	}
+)
+
+const vat3DateFormat = "02/01/2006"
+
+// VAT3Issue is a line iTax would reject
+type VAT3Issue struct {
+	Section   string `json:"section"`
+	ID        string `json:"id"`
+	Reference string `json:"reference"`
+	Message   string `json:"message"`
+}
+
+// VAT3ValidationError lists every line to fix before the return can be exported
+type VAT3ValidationError struct {
+	Issues []VAT3Issue
+}
+
+func (e *VAT3ValidationError) Error() string {
+	return fmt.Sprintf("VAT3 return has %d line(s) to fix before it can be exported", len(e.Issues))
+}
+
+// VAT3ExportedError is returned when the period was exported before and the
+// caller didn't ask to export it again
+type VAT3ExportedError struct {
+	Previous *models.VATFiling
+}
+
+func (e *VAT3ExportedError) Error() string {
+	return fmt.Sprintf("%s (version %d on %s)", ErrVAT3AlreadyExported, e.Previous.Version, e.Previous.CreatedAt.Format("2006-01-02"))
+}
+
+func (e *VAT3ExportedError) Unwrap() error { return ErrVAT3AlreadyExported }
+
+// VAT3Export is a return bundle ready for download and the filing recorded for it
+type VAT3Export struct {
+	Filing   *models.VATFiling
+	Filename string
+	Data     []byte
+}
+
+// vat3Return holds the schedules of one month's return
+type vat3Return struct {
+	registered      [][]string // Section B
+	unregistered    [][]string // Section C
+	purchases       [][]string // Section F
+	registeredTax   models.Money
+	unregisteredTax models.Money
+	inputTax        models.Money
+	zeroRated       models.Money // sales without VAT, zero-rated or exempt
+	excludedTax     models.Money // input VAT on supplier invoices not verified on eTIMS
+	issues          []VAT3Issue
+}
+
+func (r *vat3Return) outputTax() models.Money {
+	return r.registeredTax.Add(r.unregisteredTax)
+}
+
+// ExportVAT3 builds the VAT3 schedules for a month (YYYY-MM) as a zip of the
+// CSV files iTax uploads and records the filing. A month that was exported
+// before is only exported again when reexport is set.
+func (s *ReportService) ExportVAT3(tenantID, userID, period string, reexport bool) (*VAT3Export, error) {
+	start, err := time.ParseInLocation("2006-01", period, time.Local)
+	if err != nil || start.After(time.Now()) {
+		return nil, ErrVAT3PeriodInvalid
+	}
+	end := start.AddDate(0, 1, 0)
+
+	var previous models.VATFiling
+	err = s.db.Where("tenant_id = ? AND period = ?", tenantID, period).Order("version DESC").First(&previous).Error
+	switch {
+	case err == nil && !reexport:
+		return nil, &VAT3ExportedError{Previous: &previous}
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	ret, err := s.buildVAT3(tenantID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret.issues) > 0 {
+		return nil, &VAT3ValidationError{Issues: ret.issues}
+	}
+
+	data, err := ret.bundle(period)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	filing := &models.VATFiling{
+		ID:            uuid.New().String(),
+		TenantID:      tenantID,
+		Period:        period,
+		Version:       previous.Version + 1,
+		OutputTax:     ret.outputTax(),
+		InputTax:      ret.inputTax,
+		NetVAT:        ret.outputTax().Sub(ret.inputTax),
+		SalesLines:    len(ret.registered) + len(ret.unregistered),
+		PurchaseLines: len(ret.purchases),
+		Checksum:      hex.EncodeToString(sum[:]),
+		ExportedBy:    userID,
+	}
+	if err := s.db.Create(filing).Error; err != nil {
+		return nil, err
+	}
+
+	return &VAT3Export{
+		Filing:   filing,
+		Filename: fmt.Sprintf("VAT3_%s_v%d.zip", period, filing.Version),
+		Data:     data,
+	}, nil
+}
+
+// ListVATFilings lists exported VAT3 returns, newest period first
+func (s *ReportService) ListVATFilings(tenantID string) ([]models.VATFiling, error) {
+	var filings []models.VATFiling
+	err := s.db.Where("tenant_id = ?", tenantID).Order("period DESC, version DESC").Find(&filings).Error
+	return filings, err
+}
+
+func (s *ReportService) buildVAT3(tenantID string, start, end time.Time) (*vat3Return, error) {
+	ret := &vat3Return{}
+
+	// Sales are reported against the tenant's eTIMS control unit when it has one
+	var device models.ETIMSDevice
+	s.db.Where("tenant_id = ?", tenantID).Limit(1).Find(&device)
+
+	var invoices []models.Invoice
+	if err := s.db.Preload("Client").
+		Where("tenant_id = ? AND created_at >= ? AND created_at < ?", tenantID, start, end).
+		// An invoice cancelled by crediting it stays on the return against its credit notes
+		Where("(status NOT IN ? OR kra_status = ?)", []models.InvoiceStatus{models.InvoiceStatusDraft, models.InvoiceStatusCancelled, models.InvoiceStatusVoid}, models.KRAInvoiceStatusCredited).
+		Order("created_at, invoice_number").Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+
+	for _, inv := range invoices {
+		net := inv.Subtotal.Sub(inv.Discount)
+		taxable := net.Sub(inv.ExemptAmount).Sub(inv.ZeroRatedAmount)
+		vat := inv.TotalTax
+		relevantNumber, relevantDate := "", ""
+		if inv.InvoiceType == "credit_note" {
+			// Credit notes store positive amounts against a negative total and are
+			// reported negative. Older ones carry no tax, which is the rest of the total.
+			if vat.IsZero() {
+				vat = models.Money(-inv.Total).Sub(net)
+			}
+			net, taxable, vat = -net, -taxable, -vat
+			var original models.Invoice
+			if inv.OriginalInvoiceID == "" || s.db.Where("tenant_id = ? AND id = ?", tenantID, inv.OriginalInvoiceID).Limit(1).Find(&original).RowsAffected == 0 {
+				ret.issues = append(ret.issues, VAT3Issue{Section: "B/C", ID: inv.ID, Reference: inv.InvoiceNumber, Message: "credit note doesn't reference the invoice it corrects"})
+				continue
+			}
+			relevantNumber, relevantDate = original.InvoiceNumber, original.CreatedAt.Format(vat3DateFormat)
+		}
+		if inv.TaxRate <= 0 || vat.IsZero() {
+			ret.zeroRated = ret.zeroRated.Add(vat3KES(inv, net))
+			continue
+		}
+		taxable, vat = vat3KES(inv, taxable), vat3KES(inv, vat)
+
+		pin := utils.SanitizeKRAPIN(inv.Client.KRAPIN)
+		validPIN := pin != "" && utils.ValidateKRAPIN(pin) == nil
+		if inv.BuyerClassification == string(models.BuyerClassificationB2B) && !validPIN {
+			ret.issues = append(ret.issues, VAT3Issue{Section: "B", ID: inv.ID, Reference: inv.InvoiceNumber, Message: fmt.Sprintf("B2B sale to %s has no valid buyer KRA PIN", inv.Client.Name)})
+			continue
+		}
+
+		description := inv.Title
+		if description == "" {
+			description = "Sale of goods and services"
+		}
+		line := []string{
+			inv.Client.Name, device.SDCID, inv.CreatedAt.Format(vat3DateFormat), inv.InvoiceNumber, description,
+			vat3Amount(taxable), vat3Amount(vat), relevantNumber, relevantDate,
+		}
+		if validPIN {
+			ret.registered = append(ret.registered, append([]string{pin}, line...))
+			ret.registeredTax = ret.registeredTax.Add(vat)
+		} else {
+			ret.unregistered = append(ret.unregistered, line)
+			ret.unregisteredTax = ret.unregisteredTax.Add(vat)
+		}
+	}
+
+	// Input VAT is claimed only on supplier invoices verified on eTIMS
+	var expenses []models.Expense
+	if err := s.db.Where("tenant_id = ? AND date >= ? AND date < ? AND tax_amount > 0", tenantID, start, end).
+		Order("date").Find(&expenses).Error; err != nil {
+		return nil, err
+	}
+	for _, e := range expenses {
+		if e.ETIMSStatus != models.ExpenseETIMSVerified {
+			ret.excludedTax = ret.excludedTax.Add(e.TaxAmount)
+			continue
+		}
+		supplier := e.Vendor
+		if supplier == "" {
+			supplier = e.Title
+		}
+		number := e.Reference
+		if number == "" {
+			number = e.SupplierICN
+		}
+		ret.purchases = append(ret.purchases, []string{
+			"Local", e.SupplierPIN, supplier, e.SupplierICN, e.Date.Format(vat3DateFormat), number,
+			e.Title, "", vat3Amount(e.Amount.Sub(e.TaxAmount)), vat3Amount(e.TaxAmount), "", "",
+		})
+		ret.inputTax = ret.inputTax.Add(e.TaxAmount)
+	}
+
+	return ret, nil
+}
+
+// bundle zips the schedules with a summary of the return
+func (r *vat3Return) bundle(period string) ([]byte, error) {
+	summary := [][]string{
+		{"Period", period},
+		{"Output VAT - registered customers (Section B)", vat3Amount(r.registeredTax)},
+		{"Output VAT - unregistered customers (Section C)", vat3Amount(r.unregisteredTax)},
+		{"Input VAT - purchases (Section F)", vat3Amount(r.inputTax)},
+		{"Net VAT", vat3Amount(r.outputTax().Sub(r.inputTax))},
+		{"Zero-rated and exempt sales", vat3Amount(r.zeroRated)},
+		{"Input VAT not claimed (not verified on eTIMS)", vat3Amount(r.excludedTax)},
+	}
+	files := []struct {
+		name   string
+		header []string
+		rows   [][]string
+	}{
+		{"B_sales_registered_customers.csv", vat3SalesRegisteredHeader, r.registered},
+		{"C_sales_unregistered_customers.csv", vat3SalesUnregisteredHeader, r.unregistered},
+		{"F_purchases.csv", vat3PurchasesHeader, r.purchases},
+		{"summary.csv", []string{"Item", "Amount"}, summary},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		cw := csv.NewWriter(w)
+		cw.Write(f.header)
+		cw.WriteAll(f.rows)
+		if err := cw.Error(); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// vat3KES converts an invoice amount to shillings at the invoice's rate
+func vat3KES(inv models.Invoice, m models.Money) models.Money {
+	if strings.EqualFold(inv.Currency, "KES") || inv.Currency == "" || inv.ExchangeRate <= 0 {
+		return m
+	}
+	return m.Mul(inv.ExchangeRate)
+}
+
+func vat3Amount(m models.Money) string {
+	return fmt.Sprintf("%.2f", m.Float64())
+}
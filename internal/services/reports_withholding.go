@@ -0,0 +1,142 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"invoicefast/internal/models"
+)
+
+// ============================================================================
+// WITHHOLDING REPORT - certificates received against deductions made
+// ============================================================================
+
+// ClientWithholding reconciles one client's deductions of one type
+type ClientWithholding struct {
+	ClientID    string  `json:"client_id"`
+	ClientName  string  `json:"client_name"`
+	Type        string  `json:"type"`
+	Deductions  int     `json:"deductions"`
+	Expected    float64 `json:"expected"`    // at the standard rate on the taxable value paid
+	Withheld    float64 `json:"withheld"`    // what the client actually deducted
+	Certified   float64 `json:"certified"`   // covered by certificates received
+	Outstanding float64 `json:"outstanding"` // withheld but no certificate yet
+	Variance    float64 `json:"variance"`    // withheld less expected
+}
+
+type WithholdingTotals struct {
+	Expected    float64 `json:"expected"`
+	Withheld    float64 `json:"withheld"`
+	Certified   float64 `json:"certified"`
+	Outstanding float64 `json:"outstanding"`
+	Variance    float64 `json:"variance"`
+}
+
+type WithholdingReport struct {
+	Period      string                          `json:"period"`
+	StartDate   string                          `json:"start_date"`
+	EndDate     string                          `json:"end_date"`
+	Clients     []ClientWithholding             `json:"clients"`
+	Totals      map[string]*WithholdingTotals   `json:"totals"`      // by type
+	Outstanding []models.WithholdingCertificate `json:"outstanding"` // deductions still owed a certificate
+}
+
+// GetWithholdingReport reconciles certificates received against the deductions
+// clients made from payments in the period
+func (s *ReportService) GetWithholdingReport(tenantID, period string) (*WithholdingReport, error) {
+	start, end := s.getDateRange(period)
+	report := &WithholdingReport{
+		Period:    period,
+		StartDate: start.Format("2006-01-02"),
+		EndDate:   end.Format("2006-01-02"),
+		Totals:    make(map[string]*WithholdingTotals),
+	}
+
+	var certificates []models.WithholdingCertificate
+	if err := s.db.Preload("Client").
+		Where("tenant_id = ? AND deducted_at BETWEEN ? AND ?", tenantID, start, end).
+		Order("deducted_at").Find(&certificates).Error; err != nil {
+		return nil, err
+	}
+
+	type key struct{ client, kind string }
+	rows := make(map[key]*ClientWithholding)
+	for _, c := range certificates {
+		k := key{c.ClientID, c.Type}
+		row := rows[k]
+		if row == nil {
+			row = &ClientWithholding{ClientID: c.ClientID, Type: c.Type}
+			if c.Client != nil {
+				row.ClientName = c.Client.Name
+			}
+			rows[k] = row
+		}
+		totals := report.Totals[c.Type]
+		if totals == nil {
+			totals = &WithholdingTotals{}
+			report.Totals[c.Type] = totals
+		}
+
+		amount, expected := c.Amount.Float64(), c.Expected.Float64()
+		certified, outstanding := 0.0, amount
+		if c.Status == models.WithholdingReceived {
+			certified, outstanding = amount, 0
+		} else {
+			c.Client = nil
+			report.Outstanding = append(report.Outstanding, c)
+		}
+
+		row.Deductions++
+		row.Expected += expected
+		row.Withheld += amount
+		row.Certified += certified
+		row.Outstanding += outstanding
+		row.Variance += amount - expected
+
+		totals.Expected += expected
+		totals.Withheld += amount
+		totals.Certified += certified
+		totals.Outstanding += outstanding
+		totals.Variance += amount - expected
+	}
+
+	report.Clients = make([]ClientWithholding, 0, len(rows))
+	for _, row := range rows {
+		report.Clients = append(report.Clients, *row)
+	}
+	sort.Slice(report.Clients, func(i, j int) bool {
+		a, b := report.Clients[i], report.Clients[j]
+		if a.ClientName != b.ClientName {
+			return a.ClientName < b.ClientName
+		}
+		return a.Type < b.Type
+	})
+	return report, nil
+}
+
+// withholdingVAT returns VAT withheld by clients in KES: certificates dated in
+// the period, which can be claimed on the return, and deductions made in the
+// period that are still waiting for one
+func (s *ReportService) withholdingVAT(tenantID string, start, end time.Time) (credit, pending models.Money) {
+	var creditCents, pendingCents float64
+	s.db.Table("withholding_certificates").
+		Joins("LEFT JOIN invoices ON invoices.id = withholding_certificates.invoice_id").
+		Where("withholding_certificates.tenant_id = ? AND withholding_certificates.type = ? AND withholding_certificates.status = ?",
+			tenantID, models.WithholdingVAT, models.WithholdingReceived).
+		Where("withholding_certificates.certificate_date BETWEEN ? AND ?", start, end).
+		Select(withholdingKESSum).
+		Scan(&creditCents)
+	s.db.Table("withholding_certificates").
+		Joins("LEFT JOIN invoices ON invoices.id = withholding_certificates.invoice_id").
+		Where("withholding_certificates.tenant_id = ? AND withholding_certificates.type = ? AND withholding_certificates.status = ?",
+			tenantID, models.WithholdingVAT, models.WithholdingPending).
+		Where("withholding_certificates.deducted_at BETWEEN ? AND ?", start, end).
+		Select(withholdingKESSum).
+		Scan(&pendingCents)
+	return models.Money(math.Round(creditCents)), models.Money(math.Round(pendingCents))
+}
+
+// withholdingKESSum totals withheld amounts in KES at their invoices' rates
+const withholdingKESSum = `COALESCE(SUM(CASE WHEN withholding_certificates.currency NOT IN ('', 'KES') AND invoices.exchange_rate > 0
+	THEN withholding_certificates.amount * invoices.exchange_rate ELSE withholding_certificates.amount END), 0)`
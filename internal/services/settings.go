@@ -107,7 +107,20 @@ type MpesaSettings struct {
 	ConsumerSecret string `json:"consumer_secret,omitempty"` // Encrypted at rest
 	Shortcode      string `json:"shortcode"`
 	Passkey        string `json:"passkey,omitempty"` // Encrypted at rest
+	Environment    string `json:"environment,omitempty"` // sandbox or production; platform default when empty
 	Enabled        bool   `json:"enabled"`
+
+	// API initiator for Transaction Status queries and B2C payouts (optional)
+	InitiatorName       string `json:"initiator_name,omitempty"`
+	InitiatorCredential string `json:"initiator_credential,omitempty"` // Encrypted at rest
+	B2CShortcode        string `json:"b2c_shortcode,omitempty"`        // disbursement shortcode; usually not the paybill
+}
+
+// HasOwnCredentials reports whether the tenant can use its own Daraja app
+// instead of the platform account
+func (m *MpesaSettings) HasOwnCredentials() bool {
+	return m != nil && m.Enabled && m.ConsumerKey != "" && m.ConsumerSecret != "" &&
+		m.Shortcode != "" && m.Passkey != ""
 }
 
 type KRASettings struct {
@@ -142,6 +155,13 @@ func (s *SettingsService) encryptSettings(settings *TenantSettings) error {
 			}
 			settings.Mpesa.Passkey = enc
 		}
+		if settings.Mpesa.InitiatorCredential != "" {
+			enc, err := models.EncryptValue(settings.Mpesa.InitiatorCredential)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt Mpesa initiator credential: %w", err)
+			}
+			settings.Mpesa.InitiatorCredential = enc
+		}
 	}
 	if settings.KRA != nil {
 		if settings.KRA.APIKey != "" {
@@ -179,6 +199,13 @@ func (s *SettingsService) decryptSettings(settings *TenantSettings) error {
 			}
 			settings.Mpesa.Passkey = dec
 		}
+		if settings.Mpesa.InitiatorCredential != "" {
+			dec, err := models.DecryptValue(settings.Mpesa.InitiatorCredential)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt Mpesa initiator credential: %w", err)
+			}
+			settings.Mpesa.InitiatorCredential = dec
+		}
 	}
 	if settings.KRA != nil {
 		if settings.KRA.APIKey != "" {
@@ -199,22 +226,28 @@ func (s *SettingsService) decryptSettings(settings *TenantSettings) error {
 	return nil
 }
 
+// maskedSecret is shown in place of stored secrets; saving it back keeps the stored value
+const maskedSecret = "********"
+
 // MaskSecrets masks sensitive values for UI display
 func (s *SettingsService) MaskSecrets(settings *TenantSettings) {
 	if settings.Mpesa != nil {
 		if settings.Mpesa.ConsumerSecret != "" {
-			settings.Mpesa.ConsumerSecret = "********"
+			settings.Mpesa.ConsumerSecret = maskedSecret
 		}
 		if settings.Mpesa.Passkey != "" {
-			settings.Mpesa.Passkey = "********"
+			settings.Mpesa.Passkey = maskedSecret
+		}
+		if settings.Mpesa.InitiatorCredential != "" {
+			settings.Mpesa.InitiatorCredential = maskedSecret
 		}
 	}
 	if settings.KRA != nil {
 		if settings.KRA.APIKey != "" {
-			settings.KRA.APIKey = "********"
+			settings.KRA.APIKey = maskedSecret
 		}
 		if settings.KRA.RSAPrivateKey != "" {
-			settings.KRA.RSAPrivateKey = "********"
+			settings.KRA.RSAPrivateKey = maskedSecret
 		}
 	}
 }
@@ -234,7 +267,6 @@ func (s *SettingsService) GetSettings(tenantID string) (*TenantSettings, error)
 			Prefix:            "INV",
 			NextNumber:        1,
 			Currency:          "KES",
-			DefaultTaxRate:    16,
 			PaymentTerms:      "30",
 			AllowPartialPayments: true,
 			AllowDiscounts:   true,
@@ -312,7 +344,10 @@ func (s *SettingsService) GetSettings(tenantID string) (*TenantSettings, error)
 		settings.Invoice.Currency = "KES"
 	}
 	if settings.Invoice.DefaultTaxRate == 0 {
-		settings.Invoice.DefaultTaxRate = 16
+		// Standard VAT in force today, from the tenant's tax catalogue
+		if vat, err := NewTaxRateService(s.db).DefaultVAT(tenantID, time.Now()); err == nil {
+			settings.Invoice.DefaultTaxRate = int(vat.Rate)
+		}
 	}
 	if settings.Invoice.PaymentTerms == "" {
 		settings.Invoice.PaymentTerms = "30"
@@ -358,6 +393,8 @@ func (s *SettingsService) SaveSettings(tenantID string, settings *TenantSettings
 	var existing TenantSettings
 	if tenant.Settings != "" {
 		if err := json.Unmarshal([]byte(tenant.Settings), &existing); err == nil {
+			// Stored secrets are re-encrypted below, so carry them over in plaintext
+			s.decryptSettings(&existing)
 			if settings.Business != nil && existing.Business != nil {
 				settings.Business = mergeBusiness(settings.Business, existing.Business)
 			}
@@ -380,20 +417,23 @@ func (s *SettingsService) SaveSettings(tenantID string, settings *TenantSettings
 			if settings.Mpesa == nil {
 				settings.Mpesa = existing.Mpesa
 			} else if existing.Mpesa != nil {
-				if settings.Mpesa.ConsumerSecret == "" {
+				if settings.Mpesa.ConsumerSecret == "" || settings.Mpesa.ConsumerSecret == maskedSecret {
 					settings.Mpesa.ConsumerSecret = existing.Mpesa.ConsumerSecret
 				}
-				if settings.Mpesa.Passkey == "" {
+				if settings.Mpesa.Passkey == "" || settings.Mpesa.Passkey == maskedSecret {
 					settings.Mpesa.Passkey = existing.Mpesa.Passkey
 				}
+				if settings.Mpesa.InitiatorCredential == "" || settings.Mpesa.InitiatorCredential == maskedSecret {
+					settings.Mpesa.InitiatorCredential = existing.Mpesa.InitiatorCredential
+				}
 			}
 			if settings.KRA == nil {
 				settings.KRA = existing.KRA
 			} else if existing.KRA != nil {
-				if settings.KRA.APIKey == "" {
+				if settings.KRA.APIKey == "" || settings.KRA.APIKey == maskedSecret {
 					settings.KRA.APIKey = existing.KRA.APIKey
 				}
-				if settings.KRA.RSAPrivateKey == "" {
+				if settings.KRA.RSAPrivateKey == "" || settings.KRA.RSAPrivateKey == maskedSecret {
 					settings.KRA.RSAPrivateKey = existing.KRA.RSAPrivateKey
 				}
 			}
@@ -529,6 +569,29 @@ func (s *SettingsService) GetMpesaSettings(tenantID string) (*MpesaSettings, err
 	return settings.Mpesa, nil
 }
 
+// GetMpesaCredentials returns the tenant's Daraja credentials with secrets
+// decrypted. Only for outgoing M-Pesa calls; never return these to clients.
+func (s *SettingsService) GetMpesaCredentials(tenantID string) (*MpesaSettings, error) {
+	var tenant models.Tenant
+	if err := s.db.Select("id", "settings").First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, fmt.Errorf("tenant not found: %w", err)
+	}
+
+	var settings TenantSettings
+	if tenant.Settings != "" {
+		if err := json.Unmarshal([]byte(tenant.Settings), &settings); err != nil {
+			return nil, fmt.Errorf("failed to parse settings: %w", err)
+		}
+	}
+	if settings.Mpesa == nil {
+		return &MpesaSettings{}, nil
+	}
+	if err := s.decryptSettings(&TenantSettings{Mpesa: settings.Mpesa}); err != nil {
+		return nil, err
+	}
+	return settings.Mpesa, nil
+}
+
 func (s *SettingsService) GetKRASettings(tenantID string) (*KRASettings, error) {
 	settings, err := s.GetSettings(tenantID)
 	if err != nil {
@@ -14,6 +14,7 @@ import (
 	"github.com/stripe/stripe-go/v72/paymentintent"
 	"github.com/stripe/stripe-go/v72/refund"
 	"github.com/stripe/stripe-go/v72/webhook"
+	"gorm.io/gorm"
 )
 
 type StripeService struct {
@@ -152,17 +153,16 @@ func (s *StripeService) handlePaymentSuccess(data interface{}) error {
 	now := time.Now()
 	payment.CompletedAt = &now
 
-	if err := s.db.Create(payment).Error; err != nil {
-		return fmt.Errorf("failed to create payment: %w", err)
-	}
-
-	invoice.PaidAmount = invoice.PaidAmount.Add(amount)
-	if invoice.PaidAmount.GreaterThan(invoice.Total) || invoice.PaidAmount.Equals(invoice.Total) {
-		invoice.Status = models.InvoiceStatusPaid
-		invoice.PaidAt = &now
-	}
-
-	return s.db.Save(&invoice).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := numberReceipt(tx, payment); err != nil {
+			return err
+		}
+		if err := tx.Create(payment).Error; err != nil {
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+		_, _, err := creditInvoice(tx, invoice.TenantID, invoice.ID, amount, now)
+		return err
+	})
 }
 
 func (s *StripeService) handlePaymentFailure(data interface{}) error {
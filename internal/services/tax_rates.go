@@ -0,0 +1,385 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============================================================================
+// TAX RATES - per-tenant catalogue of VAT, excise and levy rates by date
+// ============================================================================
+//
+// Rates are never edited in place when the law changes: the old row is closed
+// with a valid-to date and a new row starts the day after, so an invoice
+// recalculated later still gets the rate that was in force on its own date.
+
+var (
+	ErrTaxRateNotFound   = errors.New("tax rate not found")
+	ErrTaxRateInvalid    = errors.New("tax rate needs a code, a name, a kind (vat, excise or levy) and a rate between 0 and 100")
+	ErrTaxRateOverlap    = errors.New("another rate with this code is already in force for part of this period")
+	ErrTaxRateNotInForce = errors.New("no tax rate in force")
+	ErrTaxRateCategory   = errors.New("KRA tax category must be one of A, B, C, D or E")
+	ErrTaxRateKind       = errors.New("wrong kind of tax rate")
+)
+
+type taxRateDef struct {
+	Code      string
+	Name      string
+	TaxType   models.TaxType
+	Rate      float64
+	Category  string
+	ValidFrom string
+	ValidTo   string
+}
+
+// defaultTaxRates are seeded into each tenant's catalogue by country
+var defaultTaxRates = map[string][]taxRateDef{
+	"KE": {
+		// VAT Act 2013; cut to 14% from April to December 2020
+		{models.TaxCodeVAT, "VAT", models.TaxTypeStandard, 16, models.KRATaxCategoryStandard, "2013-09-02", "2020-03-31"},
+		{models.TaxCodeVAT, "VAT", models.TaxTypeStandard, 14, models.KRATaxCategoryStandard, "2020-04-01", "2020-12-31"},
+		{models.TaxCodeVAT, "VAT", models.TaxTypeStandard, 16, models.KRATaxCategoryStandard, "2021-01-01", ""},
+		{models.TaxCodeReduced, "VAT reduced rate (fuel)", models.TaxTypeStandard, 8, models.KRATaxCategoryReduced, "2018-09-21", ""},
+		{models.TaxCodeZeroRated, "Zero-rated", models.TaxTypeZeroRated, 0, models.KRATaxCategoryZeroRated, "2013-09-02", ""},
+		{models.TaxCodeExempt, "Exempt", models.TaxTypeExempt, 0, models.KRATaxCategoryExempt, "2013-09-02", ""},
+		{models.TaxCodeNonVAT, "Non-VAT", models.TaxTypeNone, 0, models.KRATaxCategoryNonVAT, "2013-09-02", ""},
+	},
+}
+
+var validKRATaxCategories = map[string]bool{
+	models.KRATaxCategoryExempt:    true,
+	models.KRATaxCategoryStandard:  true,
+	models.KRATaxCategoryZeroRated: true,
+	models.KRATaxCategoryNonVAT:    true,
+	models.KRATaxCategoryReduced:   true,
+}
+
+// TaxRateService manages a tenant's tax rate catalogue
+type TaxRateService struct {
+	db *database.DB
+}
+
+func NewTaxRateService(db *database.DB) *TaxRateService {
+	return &TaxRateService{db: db}
+}
+
+// tenantCountry returns the country whose rates a tenant charges
+func (s *TaxRateService) tenantCountry(tenantID string) string {
+	var tenant models.Tenant
+	if err := s.db.Select("country").First(&tenant, "id = ?", tenantID).Error; err != nil || tenant.Country == "" {
+		return "KE"
+	}
+	return strings.ToUpper(tenant.Country)
+}
+
+// EnsureDefaults seeds the statutory rates for the tenant's country, adding
+// any that are missing so new rates reach existing tenants
+func (s *TaxRateService) EnsureDefaults(tenantID string) error {
+	if tenantID == "" {
+		return ErrTenantRequired
+	}
+	country := s.tenantCountry(tenantID)
+	defs := defaultTaxRates[country]
+	if len(defs) == 0 {
+		return nil
+	}
+
+	var held []models.TaxRate
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("country = ? AND is_system = ?", country, true).
+		Select("code", "valid_from").Find(&held).Error; err != nil {
+		return err
+	}
+	have := make(map[string]bool, len(held))
+	for _, r := range held {
+		have[r.Code+"|"+r.ValidFrom.Format("2006-01-02")] = true
+	}
+
+	rates := make([]models.TaxRate, 0, len(defs))
+	for _, def := range defs {
+		if have[def.Code+"|"+def.ValidFrom] {
+			continue
+		}
+		from, _ := time.Parse("2006-01-02", def.ValidFrom)
+		rate := models.TaxRate{
+			ID:          uuid.New().String(),
+			TenantID:    tenantID,
+			Country:     country,
+			Code:        def.Code,
+			Name:        def.Name,
+			Kind:        models.TaxKindVAT,
+			TaxType:     def.TaxType,
+			Rate:        def.Rate,
+			KRACategory: def.Category,
+			ValidFrom:   from,
+			IsSystem:    true,
+			IsActive:    true,
+		}
+		if def.ValidTo != "" {
+			to, _ := time.Parse("2006-01-02", def.ValidTo)
+			rate.ValidTo = &to
+		}
+		rates = append(rates, rate)
+	}
+	if len(rates) == 0 {
+		return nil
+	}
+	// Another request may be seeding the same tenant
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rates).Error
+}
+
+// ListRates lists the catalogue, optionally only one kind, by code and date
+func (s *TaxRateService) ListRates(tenantID, kind string) ([]models.TaxRate, error) {
+	if err := s.EnsureDefaults(tenantID); err != nil {
+		return nil, err
+	}
+	query := s.db.Scopes(database.TenantFilter(tenantID)).Where("country = ?", s.tenantCountry(tenantID))
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	var rates []models.TaxRate
+	err := query.Order("code, valid_from").Find(&rates).Error
+	return rates, err
+}
+
+// RateOn returns the rate for a catalogue code in force on date
+func (s *TaxRateService) RateOn(tenantID, code string, date time.Time) (*models.TaxRate, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if err := s.EnsureDefaults(tenantID); err != nil {
+		return nil, err
+	}
+	var rates []models.TaxRate
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).
+		Where("country = ? AND code = ? AND is_active = ?", s.tenantCountry(tenantID), code, true).
+		Find(&rates).Error; err != nil {
+		return nil, err
+	}
+	for i := range rates {
+		if rates[i].InForce(date) {
+			return &rates[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s on %s", ErrTaxRateNotInForce, code, date.Format("2006-01-02"))
+}
+
+// DefaultVAT returns the standard VAT rate in force on date
+func (s *TaxRateService) DefaultVAT(tenantID string, date time.Time) (*models.TaxRate, error) {
+	return s.RateOn(tenantID, models.TaxCodeVAT, date)
+}
+
+// CategoryFor returns the eTIMS tax category for a line charged at rate on
+// date, matching it against the VAT rates in the catalogue
+func (s *TaxRateService) CategoryFor(tenantID string, taxType models.TaxType, rate float64, date time.Time) string {
+	if category := models.KRATaxCategory(taxType, rate); category != "" {
+		return category
+	}
+	if vat, err := s.DefaultVAT(tenantID, date); err == nil && vat.Rate == rate {
+		return vat.KRACategory
+	}
+	if rates, err := s.ListRates(tenantID, models.TaxKindVAT); err == nil {
+		for _, r := range rates {
+			if r.IsActive && r.Rate == rate && r.KRACategory != "" && r.InForce(date) {
+				return r.KRACategory
+			}
+		}
+	}
+	return models.KRATaxCategoryStandard
+}
+
+// LineRates resolves a line's VAT and excise rates from the catalogue as at
+// date. A line without a code of its own is charged at its inline rate, or at
+// the document's catalogue rate when it has no standard rate given directly.
+func (s *TaxRateService) LineRates(tenantID, taxCode, exciseCode string, inline, documentRate *models.TaxRate, date time.Time) (vat, excise *models.TaxRate, err error) {
+	switch {
+	case strings.TrimSpace(taxCode) != "":
+		if vat, err = s.RateOn(tenantID, taxCode, date); err != nil {
+			return nil, nil, err
+		}
+		if vat.Kind != models.TaxKindVAT {
+			return nil, nil, fmt.Errorf("%s is not a VAT rate: %w", vat.Code, ErrTaxRateKind)
+		}
+	case inline.TaxType == models.TaxTypeStandard && inline.Rate == 0 && documentRate != nil:
+		vat = documentRate
+	default:
+		vat = inline
+	}
+
+	if strings.TrimSpace(exciseCode) != "" {
+		if excise, err = s.RateOn(tenantID, exciseCode, date); err != nil {
+			return nil, nil, err
+		}
+		if excise.Kind == models.TaxKindVAT {
+			return nil, nil, fmt.Errorf("%s is not an excise or levy rate: %w", excise.Code, ErrTaxRateKind)
+		}
+	}
+	return vat, excise, nil
+}
+
+// LineCategory returns the eTIMS tax category for a line's VAT rate
+func (s *TaxRateService) LineCategory(tenantID string, vat *models.TaxRate, date time.Time) string {
+	if vat.KRACategory != "" {
+		return vat.KRACategory
+	}
+	return s.CategoryFor(tenantID, vat.TaxType, vat.Rate, date)
+}
+
+// CreateRate adds a rate to the catalogue. A new rate for an existing code
+// must start after the current one ends.
+func (s *TaxRateService) CreateRate(tenantID string, rate *models.TaxRate) error {
+	if err := s.EnsureDefaults(tenantID); err != nil {
+		return err
+	}
+	rate.ID = uuid.New().String()
+	rate.TenantID = tenantID
+	rate.Country = s.tenantCountry(tenantID)
+	rate.Code = strings.ToUpper(strings.TrimSpace(rate.Code))
+	rate.Name = strings.TrimSpace(rate.Name)
+	rate.Kind = strings.ToLower(strings.TrimSpace(rate.Kind))
+	rate.KRACategory = strings.ToUpper(strings.TrimSpace(rate.KRACategory))
+	rate.IsSystem = false
+	rate.IsActive = true
+	if rate.Kind == "" {
+		rate.Kind = models.TaxKindVAT
+	}
+	if rate.TaxType == "" {
+		rate.TaxType = models.TaxTypeStandard
+	}
+	if rate.ValidFrom.IsZero() {
+		rate.ValidFrom = time.Now()
+	}
+	if err := validateCatalogueRate(rate); err != nil {
+		return err
+	}
+	if rate.Kind == models.TaxKindVAT && rate.KRACategory == "" {
+		rate.KRACategory = s.CategoryFor(tenantID, rate.TaxType, rate.Rate, rate.ValidFrom)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkTaxRateOverlap(tx, rate); err != nil {
+			return err
+		}
+		return tx.Create(rate).Error
+	})
+}
+
+// TaxRateUpdate holds the fields of a rate that can change. The rate and the
+// date it starts can't: a new rate gets a new row.
+type TaxRateUpdate struct {
+	Name         *string
+	KRACategory  *string
+	ValidTo      *time.Time
+	ClearValidTo bool
+	IsActive     *bool
+}
+
+// UpdateRate renames, recategorises, end-dates or deactivates a rate
+func (s *TaxRateService) UpdateRate(tenantID, id string, update TaxRateUpdate) (*models.TaxRate, error) {
+	var rate models.TaxRate
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(database.TenantFilter(tenantID)).First(&rate, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTaxRateNotFound
+			}
+			return err
+		}
+
+		if update.Name != nil {
+			rate.Name = strings.TrimSpace(*update.Name)
+		}
+		if update.KRACategory != nil {
+			rate.KRACategory = strings.ToUpper(strings.TrimSpace(*update.KRACategory))
+		}
+		if update.ValidTo != nil {
+			rate.ValidTo = update.ValidTo
+		} else if update.ClearValidTo {
+			rate.ValidTo = nil
+		}
+		if update.IsActive != nil {
+			rate.IsActive = *update.IsActive
+		}
+		if err := validateCatalogueRate(&rate); err != nil {
+			return err
+		}
+		if rate.IsActive {
+			if err := checkTaxRateOverlap(tx, &rate); err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&models.TaxRate{}).Where("id = ?", rate.ID).Updates(map[string]interface{}{
+			"name":         rate.Name,
+			"kra_category": rate.KRACategory,
+			"valid_to":     rate.ValidTo,
+			"is_active":    rate.IsActive,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func validateCatalogueRate(rate *models.TaxRate) error {
+	switch rate.Kind {
+	case models.TaxKindVAT, models.TaxKindExcise, models.TaxKindLevy:
+	default:
+		return ErrTaxRateInvalid
+	}
+	switch rate.TaxType {
+	case models.TaxTypeStandard, models.TaxTypeZeroRated, models.TaxTypeExempt, models.TaxTypeNone:
+	default:
+		return ErrTaxRateInvalid
+	}
+	if rate.Code == "" || rate.Name == "" || rate.Rate < 0 || rate.Rate > 100 {
+		return ErrTaxRateInvalid
+	}
+	if rate.TaxType != models.TaxTypeStandard && rate.Rate != 0 {
+		return ErrTaxRateInvalid
+	}
+	if rate.ValidTo != nil && rate.ValidTo.Format("2006-01-02") < rate.ValidFrom.Format("2006-01-02") {
+		return ErrTaxRateInvalid
+	}
+	if rate.KRACategory != "" && !validKRATaxCategories[rate.KRACategory] {
+		return ErrTaxRateCategory
+	}
+	return nil
+}
+
+// checkTaxRateOverlap rejects a rate whose dates overlap another active rate
+// with the same code
+func checkTaxRateOverlap(tx *gorm.DB, rate *models.TaxRate) error {
+	var others []models.TaxRate
+	if err := tx.Scopes(database.TenantFilter(rate.TenantID)).
+		Where("country = ? AND code = ? AND is_active = ? AND id <> ?", rate.Country, rate.Code, true, rate.ID).
+		Find(&others).Error; err != nil {
+		return err
+	}
+	for _, other := range others {
+		if taxRatesOverlap(rate, &other) {
+			return ErrTaxRateOverlap
+		}
+	}
+	return nil
+}
+
+func taxRatesOverlap(a, b *models.TaxRate) bool {
+	const day = "2006-01-02"
+	// Open-ended rates run forever
+	aEnd, bEnd := "9999-12-31", "9999-12-31"
+	if a.ValidTo != nil {
+		aEnd = a.ValidTo.Format(day)
+	}
+	if b.ValidTo != nil {
+		bEnd = b.ValidTo.Format(day)
+	}
+	return a.ValidFrom.Format(day) <= bEnd && b.ValidFrom.Format(day) <= aEnd
+}
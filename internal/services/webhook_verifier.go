@@ -51,10 +51,10 @@ type WebhookVerificationResult struct {
 type mpesaCallbackPayload struct {
 	Body struct {
 		StkCallback struct {
-			MerchantRequestID string `json:"MerchantRequestID"`
-			CheckoutRequestID string `json:"CheckoutRequestID"`
-			ResultCode        string `json:"ResultCode"`
-			ResultDesc        string `json:"ResultDesc"`
+			MerchantRequestID string      `json:"MerchantRequestID"`
+			CheckoutRequestID string      `json:"CheckoutRequestID"`
+			ResultCode        json.Number `json:"ResultCode"` // Daraja sends a number
+			ResultDesc        string      `json:"ResultDesc"`
 		} `json:"stkCallback"`
 	} `json:"Body"`
 }
@@ -83,7 +83,7 @@ func (v *WebhookVerifier) VerifyMpesaCallback(payload []byte, signature string)
 		return result
 	}
 
-	code, err := strconv.Atoi(cb.Body.StkCallback.ResultCode)
+	code, err := strconv.Atoi(cb.Body.StkCallback.ResultCode.String())
 	if err != nil {
 		result.Error = fmt.Errorf("invalid result code: %s", cb.Body.StkCallback.ResultCode)
 		return result
@@ -104,6 +104,49 @@ func (v *WebhookVerifier) VerifyMpesaCallback(payload []byte, signature string)
 	return result
 }
 
+// MpesaCallbackToken is the per-tenant secret put in the callback URLs given to
+// Daraja. Daraja doesn't sign C2B or result callbacks, so knowing the URL is
+// what proves a callback came from Safaricom.
+func MpesaCallbackToken(secret, tenantID string) string {
+	return computeHMAC("mpesa-callback:"+tenantID, secret)
+}
+
+// VerifyMpesaCallbackURL checks a C2B or result callback carries the tenant's
+// token and, when MPESA_CALLBACK_IPS is set, comes from one of Safaricom's addresses
+func (v *WebhookVerifier) VerifyMpesaCallbackURL(tenantID, token, remoteIP string) *WebhookVerificationResult {
+	result := &WebhookVerificationResult{
+		Provider:  "mpesa",
+		Timestamp: time.Now(),
+	}
+
+	if tenantID == "" || token == "" {
+		result.Error = fmt.Errorf("missing callback token")
+		return result
+	}
+	expected := MpesaCallbackToken(v.cfg.MPesa.CallbackSecret, tenantID)
+	if !hmac.Equal([]byte(token), []byte(expected)) {
+		result.Error = fmt.Errorf("invalid callback token")
+		return result
+	}
+
+	if allowed := v.cfg.MPesa.CallbackIPs; len(allowed) > 0 {
+		found := false
+		for _, ip := range allowed {
+			if ip == remoteIP {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result.Error = fmt.Errorf("callback from unexpected address %s", remoteIP)
+			return result
+		}
+	}
+
+	result.Valid = true
+	return result
+}
+
 // VerifyIntasendWebhook verifies Intasend webhook signatures
 // This MUST be implemented properly - Intasend signs their webhooks
 func (v *WebhookVerifier) VerifyIntasendWebhook(payload []byte, signature string, timestamp string) *WebhookVerificationResult {
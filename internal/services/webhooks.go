@@ -0,0 +1,634 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// OUTGOING WEBHOOKS - Signed event deliveries to tenant endpoints
+// ============================================================================
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256 of the
+// raw body with the endpoint secret, so receivers can check it the same way
+// WebhookVerifier.VerifyGenericWebhook does.
+const (
+	WebhookSignatureHeader = "X-InvoiceFast-Signature"
+	WebhookEventHeader     = "X-InvoiceFast-Event"
+	WebhookDeliveryHeader  = "X-InvoiceFast-Delivery"
+	WebhookTimestampHeader = "X-InvoiceFast-Timestamp"
+
+	webhookMaxRetries      = 6
+	webhookResponseMaxBody = 4096
+)
+
+var (
+	ErrWebhookEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrWebhookEndpointPrivate  = errors.New("endpoint URL must not point at a loopback, link-local or private network address")
+)
+
+// webhookEventsByTrigger maps workflow events to the public webhook event names
+var webhookEventsByTrigger = map[string]string{
+	models.TriggerEventInvoiceCreated:  EventInvoiceCreated,
+	models.TriggerEventInvoiceSent:     EventInvoiceSent,
+	models.TriggerEventInvoicePaid:     EventInvoicePaid,
+	models.TriggerEventInvoiceOverdue:  EventInvoiceOverdue,
+	models.TriggerEventPaymentReceived: EventPaymentReceived,
+	models.TriggerEventPaymentFailed:   EventPaymentFailed,
+	models.TriggerEventClientAdded:     "client.created",
+	models.TriggerEventKRAAccepted:     EventKRAAccepted,
+	models.TriggerEventKRARejected:     EventKRARejected,
+}
+
+// WebhookEvents lists the event names endpoints can subscribe to
+func WebhookEvents() []string {
+	return []string{
+		EventInvoiceCreated, EventInvoiceSent, EventInvoicePaid, EventInvoiceOverdue,
+		EventPaymentReceived, EventPaymentFailed, "client.created",
+		EventKRAAccepted, EventKRARejected,
+	}
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents() {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// OutgoingWebhookService manages tenant webhook endpoints and their deliveries
+type OutgoingWebhookService struct {
+	db           *database.DB
+	jobQueue     *JobQueueService
+	client       *http.Client
+	allowHTTP    bool
+	allowPrivate bool
+}
+
+// NewOutgoingWebhookService creates a new outgoing webhook service. Plain http
+// endpoints are only accepted outside production. Endpoints on loopback,
+// link-local and private networks are refused, both when they are registered
+// and when a delivery connects, so a tenant can't aim deliveries at our own
+// network.
+func NewOutgoingWebhookService(db *database.DB, jobQueue *JobQueueService, cfg *config.Config) *OutgoingWebhookService {
+	s := &OutgoingWebhookService{
+		db:        db,
+		jobQueue:  jobQueue,
+		allowHTTP: cfg == nil || cfg.Server.Mode != "production",
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   s.checkDialAddress,
+	}).DialContext
+	s.client = &http.Client{Timeout: 15 * time.Second, Transport: transport}
+	return s
+}
+
+// SetAllowPrivateNetworks lets endpoints on loopback and private networks
+// receive deliveries, for self-hosted installs that deliver inside their own
+// network and for tests
+func (s *OutgoingWebhookService) SetAllowPrivateNetworks(allow bool) {
+	s.allowPrivate = allow
+}
+
+// privateNetwork reports whether ip is somewhere deliveries must not reach
+func privateNetwork(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// checkEndpointHost refuses an endpoint host that is, or resolves to, a
+// private address. A name that doesn't resolve yet is checked again when a
+// delivery connects.
+func (s *OutgoingWebhookService) checkEndpointHost(host string) error {
+	if s.allowPrivate {
+		return nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrWebhookEndpointPrivate
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if privateNetwork(ip) {
+			return ErrWebhookEndpointPrivate
+		}
+		return nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if privateNetwork(ip) {
+			return ErrWebhookEndpointPrivate
+		}
+	}
+	return nil
+}
+
+// checkDialAddress refuses a delivery connection to a private address, which
+// covers names that resolve differently after registration and redirects
+func (s *OutgoingWebhookService) checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if s.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateNetwork(ip) {
+		return ErrWebhookEndpointPrivate
+	}
+	return nil
+}
+
+// WebhookEndpointRequest holds the fields for creating or updating an endpoint
+type WebhookEndpointRequest struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// checkURL refuses a URL we must not POST to: one that isn't https (or http
+// outside production) or whose host is on a private network
+func (s *OutgoingWebhookService) checkURL(rawURL string) error {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return errors.New("a valid endpoint URL is required")
+	}
+	if u.Scheme != "https" && !(s.allowHTTP && u.Scheme == "http") {
+		return errors.New("endpoint URL must use https")
+	}
+	return s.checkEndpointHost(u.Hostname())
+}
+
+func (s *OutgoingWebhookService) validateEndpoint(rawURL string, events []string) (string, error) {
+	if err := s.checkURL(rawURL); err != nil {
+		return "", err
+	}
+
+	if len(events) == 0 {
+		return "", errors.New("at least one event is required")
+	}
+	out := make([]string, 0, len(events))
+	for _, event := range events {
+		event = strings.TrimSpace(event)
+		if event == "*" {
+			return "*", nil
+		}
+		if !isWebhookEvent(event) {
+			return "", fmt.Errorf("unsupported event: %s", event)
+		}
+		out = append(out, event)
+	}
+	return strings.Join(out, ","), nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// CreateEndpoint registers an endpoint. The signing secret is returned once.
+func (s *OutgoingWebhookService) CreateEndpoint(tenantID string, req *WebhookEndpointRequest) (*models.WebhookEndpoint, string, error) {
+	if tenantID == "" {
+		return nil, "", ErrTenantRequired
+	}
+	events, err := s.validateEndpoint(req.URL, req.Events)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	encrypted, err := models.EncryptValue(secret)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+
+	endpoint := &models.WebhookEndpoint{
+		ID:          uuid.New().String(),
+		TenantID:    tenantID,
+		URL:         strings.TrimSpace(req.URL),
+		Description: req.Description,
+		Events:      events,
+		Secret:      encrypted,
+		IsActive:    req.IsActive == nil || *req.IsActive,
+	}
+	if err := s.db.Create(endpoint).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create webhook endpoint: %w", err)
+	}
+	return endpoint, secret, nil
+}
+
+// ListEndpoints returns the tenant's endpoints
+func (s *OutgoingWebhookService) ListEndpoints(tenantID string) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	err := s.db.Scopes(database.TenantFilter(tenantID)).Order("created_at DESC").Find(&endpoints).Error
+	return endpoints, err
+}
+
+// GetEndpoint returns a single endpoint
+func (s *OutgoingWebhookService) GetEndpoint(tenantID, id string) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&endpoint, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookEndpointNotFound
+		}
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// UpdateEndpoint changes the URL, events or active flag. Re-enabling an endpoint resets its failure count.
+func (s *OutgoingWebhookService) UpdateEndpoint(tenantID, id string, req *WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	endpoint, err := s.GetEndpoint(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rawURL := endpoint.URL
+	if req.URL != "" {
+		rawURL = req.URL
+	}
+	events := strings.Split(endpoint.Events, ",")
+	if len(req.Events) > 0 {
+		events = req.Events
+	}
+	normalized, err := s.validateEndpoint(rawURL, events)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.URL = strings.TrimSpace(rawURL)
+	endpoint.Events = normalized
+	if req.Description != "" {
+		endpoint.Description = req.Description
+	}
+	if req.IsActive != nil {
+		if *req.IsActive && !endpoint.IsActive {
+			endpoint.FailureCount = 0
+		}
+		endpoint.IsActive = *req.IsActive
+	}
+
+	if err := s.db.Save(endpoint).Error; err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// DeleteEndpoint removes an endpoint; its delivery log is kept
+func (s *OutgoingWebhookService) DeleteEndpoint(tenantID, id string) error {
+	result := s.db.Scopes(database.TenantFilter(tenantID)).Where("id = ?", id).Delete(&models.WebhookEndpoint{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebhookEndpointNotFound
+	}
+	return nil
+}
+
+// RotateSecret replaces the signing secret and returns the new one. Deliveries
+// still queued are signed with the new secret when they are sent.
+func (s *OutgoingWebhookService) RotateSecret(tenantID, id string) (string, error) {
+	endpoint, err := s.GetEndpoint(tenantID, id)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := models.EncryptValue(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+
+	now := time.Now()
+	if err := s.db.Model(endpoint).Updates(map[string]interface{}{
+		"secret":            encrypted,
+		"secret_rotated_at": now,
+		"updated_at":        now,
+	}).Error; err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// PublishWorkflowEvent forwards a workflow engine event to subscribed endpoints.
+// The workflow engine calls it as the event is published, so the deliveries are
+// stored before the event waits on the bus.
+func (s *OutgoingWebhookService) PublishWorkflowEvent(evt *WorkflowEvent) {
+	if s == nil || evt == nil {
+		return
+	}
+	event, ok := webhookEventsByTrigger[evt.Event]
+	if !ok {
+		return
+	}
+	data := make(map[string]interface{}, len(evt.Data)+2)
+	for k, v := range evt.Data {
+		data[k] = v
+	}
+	data["entity_type"] = evt.EntityType
+	data["entity_id"] = evt.EntityID
+
+	if _, err := s.Publish(evt.TenantID, event, data, evt.OccurredAt); err != nil {
+		logger.Get().Error(context.Background(), "Failed to queue webhook deliveries",
+			"tenant_id", evt.TenantID, "event", event, "error", err)
+	}
+}
+
+// Publish queues a delivery of the event to every active endpoint subscribed to it
+func (s *OutgoingWebhookService) Publish(tenantID, event string, data map[string]interface{}, occurredAt time.Time) ([]models.WebhookDelivery, error) {
+	var endpoints []models.WebhookEndpoint
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).Where("is_active = ?", true).Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+	eventID := "evt_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	body, err := json.Marshal(map[string]interface{}{
+		"id":         eventID,
+		"event":      event,
+		"created_at": occurredAt.UTC().Format(time.RFC3339),
+		"data":       data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	// Every endpoint's delivery is written together, so an event is either
+	// queued for all its subscribers or for none
+	var deliveries []models.WebhookDelivery
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range endpoints {
+			if !endpointSubscribes(&endpoints[i], event) {
+				continue
+			}
+			delivery := &models.WebhookDelivery{
+				ID:         uuid.New().String(),
+				TenantID:   tenantID,
+				EndpointID: endpoints[i].ID,
+				Event:      event,
+				EventID:    eventID,
+				Payload:    string(body),
+				Status:     models.WebhookDeliveryPending,
+			}
+			if err := s.enqueueDelivery(tx, delivery); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, *delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func endpointSubscribes(endpoint *models.WebhookEndpoint, event string) bool {
+	if endpoint.Events == "*" {
+		return true
+	}
+	for _, e := range strings.Split(endpoint.Events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueDelivery stores the delivery and schedules a webhook job for it in tx
+func (s *OutgoingWebhookService) enqueueDelivery(tx *gorm.DB, delivery *models.WebhookDelivery) error {
+	payload, _ := json.Marshal(map[string]string{"delivery_id": delivery.ID})
+	job := &models.AutomationJob{
+		ID:             uuid.New().String(),
+		TenantID:       delivery.TenantID,
+		JobType:        models.JobTypeWebhook,
+		Priority:       1,
+		Payload:        string(payload),
+		Status:         models.JobStatusPending,
+		RunAt:          time.Now(),
+		MaxRetries:     webhookMaxRetries,
+		IdempotencyKey: "webhook_" + delivery.ID,
+	}
+	delivery.JobID = job.ID
+
+	if err := tx.Create(delivery).Error; err != nil {
+		return err
+	}
+	return tx.Create(job).Error
+}
+
+// Deliver sends a queued delivery. It is the job dispatcher handler for
+// models.JobTypeWebhook; returning an error lets the queue retry with backoff.
+func (s *OutgoingWebhookService) Deliver(ctx context.Context, job *models.AutomationJob) error {
+	var payload struct {
+		DeliveryID string `json:"delivery_id"`
+	}
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil || payload.DeliveryID == "" {
+		return s.jobQueue.MoveToDeadLetter(job.ID, "invalid webhook job payload")
+	}
+
+	var delivery models.WebhookDelivery
+	if err := s.db.Scopes(database.TenantFilter(job.TenantID)).First(&delivery, "id = ?", payload.DeliveryID).Error; err != nil {
+		return s.jobQueue.MoveToDeadLetter(job.ID, "webhook delivery not found")
+	}
+	if delivery.Status == models.WebhookDeliverySucceeded {
+		return nil
+	}
+
+	endpoint, err := s.GetEndpoint(job.TenantID, delivery.EndpointID)
+	if err != nil || !endpoint.IsActive {
+		s.db.Model(&delivery).Updates(map[string]interface{}{
+			"status":     models.WebhookDeliveryFailed,
+			"error":      "endpoint removed or disabled",
+			"updated_at": time.Now(),
+		})
+		return nil
+	}
+
+	secret, err := models.DecryptValue(endpoint.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt webhook secret: %w", err)
+	}
+
+	code, respBody, duration, sendErr := s.send(ctx, endpoint.URL, secret, &delivery)
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"response_code":   code,
+		"response_body":   respBody,
+		"duration_ms":     duration.Milliseconds(),
+		"last_attempt_at": now,
+		"updated_at":      now,
+	}
+	endpointUpdates := map[string]interface{}{
+		"last_status_code": code,
+		"last_delivery_at": now,
+		"updated_at":       now,
+	}
+
+	if sendErr == nil {
+		updates["status"] = models.WebhookDeliverySucceeded
+		updates["error"] = ""
+		updates["delivered_at"] = now
+		endpointUpdates["failure_count"] = 0
+		s.db.Model(&delivery).Updates(updates)
+		s.db.Model(endpoint).Updates(endpointUpdates)
+		return nil
+	}
+
+	updates["error"] = sendErr.Error()
+	if job.RetryCount+1 >= job.MaxRetries {
+		updates["status"] = models.WebhookDeliveryFailed
+		endpointUpdates["failure_count"] = gorm.Expr("failure_count + 1")
+	} else {
+		updates["status"] = models.WebhookDeliveryRetrying
+	}
+	s.db.Model(&delivery).Updates(updates)
+	s.db.Model(endpoint).Updates(endpointUpdates)
+	return sendErr
+}
+
+// send POSTs the signed payload and returns the response code and a truncated body
+func (s *OutgoingWebhookService) send(ctx context.Context, endpointURL, secret string, delivery *models.WebhookDelivery) (int, string, time.Duration, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "InvoiceFast-Webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, computeHMAC(delivery.Payload, secret))
+
+	started := time.Now()
+	resp, err := s.client.Do(req)
+	duration := time.Since(started)
+	if err != nil {
+		return 0, "", duration, fmt.Errorf("delivery failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseMaxBody))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(respBody), duration, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(respBody), duration, nil
+}
+
+// postAction POSTs a workflow webhook action's payload through the same
+// guarded client as deliveries, so actions can't reach our network either
+func (s *OutgoingWebhookService) postAction(ctx context.Context, rawURL string, body []byte) error {
+	if err := s.checkURL(rawURL); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSpace(rawURL), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "InvoiceFast-Webhooks/1.0")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ListDeliveries returns the delivery log, optionally for a single endpoint
+func (s *OutgoingWebhookService) ListDeliveries(tenantID, endpointID string, limit, offset int) ([]models.WebhookDelivery, int64, error) {
+	var deliveries []models.WebhookDelivery
+	var total int64
+
+	query := s.db.Model(&models.WebhookDelivery{}).Scopes(database.TenantFilter(tenantID))
+	if endpointID != "" {
+		query = query.Where("endpoint_id = ?", endpointID)
+	}
+
+	query.Count(&total)
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&deliveries).Error
+	return deliveries, total, err
+}
+
+// ReplayDelivery queues a fresh delivery of the same payload to the same endpoint
+func (s *OutgoingWebhookService) ReplayDelivery(tenantID, deliveryID string) (*models.WebhookDelivery, error) {
+	var original models.WebhookDelivery
+	if err := s.db.Scopes(database.TenantFilter(tenantID)).First(&original, "id = ?", deliveryID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("webhook delivery not found")
+		}
+		return nil, err
+	}
+
+	endpoint, err := s.GetEndpoint(tenantID, original.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	if !endpoint.IsActive {
+		return nil, errors.New("webhook endpoint is disabled")
+	}
+
+	replay := &models.WebhookDelivery{
+		ID:         uuid.New().String(),
+		TenantID:   tenantID,
+		EndpointID: original.EndpointID,
+		Event:      original.Event,
+		EventID:    original.EventID,
+		Payload:    original.Payload,
+		Status:     models.WebhookDeliveryPending,
+		ReplayOfID: &original.ID,
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error { return s.enqueueDelivery(tx, replay) }); err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
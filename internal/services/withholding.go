@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// WITHHOLDING - tax clients deduct at source before paying
+// ============================================================================
+//
+// Government and large corporate clients withhold VAT (2% of the taxable value)
+// and sometimes income tax from what they pay, then issue a certificate for it.
+// The deduction is recorded with the payment and settles the invoice like cash;
+// the certificate, once received, is the tenant's credit against their own tax.
+
+var (
+	ErrWithholdingInvalid     = errors.New("withholding needs a type (vat or income_tax) and an amount above zero")
+	ErrWithholdingNoVAT       = errors.New("VAT can only be withheld from an invoice that charges VAT")
+	ErrWithholdingNotFound    = errors.New("withholding certificate not found")
+	ErrWithholdingCertificate = errors.New("a certificate number is required")
+	ErrWithholdingDuplicate   = errors.New("this certificate number has already been recorded")
+)
+
+// Rates applied to the taxable value when working out what should have been withheld
+const (
+	DefaultWithholdingVATRate = 2.0
+	DefaultWithholdingTaxRate = 5.0 // resident professional and management fees
+)
+
+// prepareWithholdings checks the deductions on a payment against its invoice,
+// fills them in and returns the total withheld
+func prepareWithholdings(tx *gorm.DB, invoice *models.Invoice, payment *models.Payment, deductedAt time.Time) (models.Money, error) {
+	var withheld models.Money
+	for i := range payment.Withholdings {
+		w := &payment.Withholdings[i]
+		w.Type = strings.ToLower(strings.TrimSpace(w.Type))
+		w.CertificateNumber = strings.TrimSpace(w.CertificateNumber)
+		if (w.Type != models.WithholdingVAT && w.Type != models.WithholdingIncomeTax) || w.Amount <= 0 || w.Rate < 0 {
+			return 0, ErrWithholdingInvalid
+		}
+		if w.Type == models.WithholdingVAT && invoice.TotalTax <= 0 {
+			return 0, ErrWithholdingNoVAT
+		}
+		if w.CertificateNumber != "" {
+			if err := checkCertificateNumber(tx, invoice.TenantID, w.Type, w.CertificateNumber, ""); err != nil {
+				return 0, err
+			}
+		}
+		withheld = withheld.Add(w.Amount)
+	}
+	if withheld == 0 {
+		return 0, nil
+	}
+
+	// Expected deductions are worked out on the taxable value of the share of
+	// the invoice this payment settles
+	share := 1.0
+	if invoice.Total > 0 {
+		share = min(payment.Amount.Add(withheld).Float64()/invoice.Total.Float64(), 1)
+	}
+	taxable := invoice.Subtotal.Sub(invoice.Discount).Mul(share)
+
+	for i := range payment.Withholdings {
+		w := &payment.Withholdings[i]
+		if w.Rate == 0 {
+			w.Rate = DefaultWithholdingVATRate
+			if w.Type == models.WithholdingIncomeTax {
+				w.Rate = DefaultWithholdingTaxRate
+			}
+		}
+		w.ID = uuid.New().String()
+		w.TenantID = invoice.TenantID
+		w.ClientID = invoice.ClientID
+		w.InvoiceID = invoice.ID
+		w.PaymentID = payment.ID
+		w.Currency = payment.Currency
+		w.Expected = taxable.Mul(w.Rate / 100)
+		w.DeductedAt = deductedAt
+		w.Status = models.WithholdingPending
+		if w.CertificateNumber != "" {
+			w.Status = models.WithholdingReceived
+			w.ReceivedAt = &deductedAt
+			w.ReceivedBy = payment.UserID
+			if w.CertificateDate == nil {
+				w.CertificateDate = &deductedAt
+			}
+		}
+	}
+	return withheld, nil
+}
+
+// checkCertificateNumber rejects a certificate number already recorded for
+// another deduction of the same type
+func checkCertificateNumber(tx *gorm.DB, tenantID, withholdingType, number, exceptID string) error {
+	query := tx.Model(&models.WithholdingCertificate{}).Scopes(database.TenantFilter(tenantID)).
+		Where("type = ? AND certificate_number = ?", withholdingType, number)
+	if exceptID != "" {
+		query = query.Where("id <> ?", exceptID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrWithholdingDuplicate
+	}
+	return nil
+}
+
+// WithholdingService tracks withholding certificates per client
+type WithholdingService struct {
+	db *database.DB
+}
+
+func NewWithholdingService(db *database.DB) *WithholdingService {
+	return &WithholdingService{db: db}
+}
+
+type WithholdingFilter struct {
+	ClientID string
+	Type     string
+	Status   string
+	Page     int
+	Limit    int
+}
+
+// ListCertificates lists withholding deductions, newest first
+func (s *WithholdingService) ListCertificates(tenantID string, filter WithholdingFilter) ([]models.WithholdingCertificate, int64, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 || filter.Limit > 100 {
+		filter.Limit = 50
+	}
+
+	query := s.db.Model(&models.WithholdingCertificate{}).Scopes(database.TenantFilter(tenantID))
+	if filter.ClientID != "" {
+		query = query.Where("client_id = ?", filter.ClientID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var certificates []models.WithholdingCertificate
+	err := query.Preload("Client").Order("deducted_at DESC").
+		Offset((filter.Page - 1) * filter.Limit).Limit(filter.Limit).
+		Find(&certificates).Error
+	return certificates, total, err
+}
+
+// ReceiveCertificate records the certificate for a deduction, making the credit claimable
+func (s *WithholdingService) ReceiveCertificate(tenantID, userID, id, number string, date time.Time) (*models.WithholdingCertificate, error) {
+	number = strings.TrimSpace(number)
+	if number == "" {
+		return nil, ErrWithholdingCertificate
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var certificate models.WithholdingCertificate
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(database.TenantFilter(tenantID)).First(&certificate, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrWithholdingNotFound
+			}
+			return err
+		}
+		if err := checkCertificateNumber(tx, tenantID, certificate.Type, number, certificate.ID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&certificate).Updates(map[string]interface{}{
+			"certificate_number": number,
+			"certificate_date":   date,
+			"status":             models.WithholdingReceived,
+			"received_at":        now,
+			"received_by":        userID,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to record certificate: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
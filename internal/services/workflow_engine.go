@@ -0,0 +1,637 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============================================================================
+// WORKFLOW ENGINE - Evaluates AutomationWorkflow rows against published events
+// ============================================================================
+
+// Workflow action types understood by the engine
+const (
+	WorkflowActionSendEmail    = "send_email"
+	WorkflowActionSendSMS      = "send_sms"
+	WorkflowActionSendWhatsApp = "send_whatsapp"
+	WorkflowActionUpdateStatus = "update_status"
+	WorkflowActionWebhook      = "webhook"
+	WorkflowActionNotifyAdmin  = "notify_admin"
+)
+
+// Workflow condition operators
+const (
+	ConditionEquals      = "equals"
+	ConditionNotEquals   = "not_equals"
+	ConditionContains    = "contains"
+	ConditionGreaterThan = "greater_than"
+	ConditionLessThan    = "less_than"
+	ConditionIn          = "in"
+	ConditionNotIn       = "not_in"
+)
+
+// WorkflowEvent is what invoice, payment and client services publish.
+// Data is the payload conditions are evaluated against (e.g. "total", "currency", "client_email").
+type WorkflowEvent struct {
+	TenantID   string                 `json:"tenant_id"`
+	UserID     string                 `json:"user_id,omitempty"`
+	Event      string                 `json:"event"`       // models.TriggerEvent*
+	EntityType string                 `json:"entity_type"` // invoice, payment, client
+	EntityID   string                 `json:"entity_id"`
+	Data       map[string]interface{} `json:"data"`
+	OccurredAt time.Time              `json:"occurred_at"`
+}
+
+// workflowJobPayload is the payload of a models.JobTypeWorkflow job. When
+// WorkflowID is empty every workflow subscribed to the event is run. ResumeStep
+// is set on jobs holding the rest of a delayed run: the index of the delayed
+// action, whose delay has been served by the time the job is due.
+type workflowJobPayload struct {
+	WorkflowID string         `json:"workflow_id,omitempty"`
+	ResumeStep *int           `json:"resume_step,omitempty"`
+	Event      *WorkflowEvent `json:"event"`
+}
+
+// WorkflowEngine is the in-process event bus for workflow automation
+type WorkflowEngine struct {
+	db              *database.DB
+	notificationSvc *NotificationService
+	webhooks        *OutgoingWebhookService
+	events          chan *WorkflowEvent
+	stopCh          chan struct{}
+	stopOnce        sync.Once
+	wg              sync.WaitGroup
+	workers         int
+}
+
+// NewWorkflowEngine creates a new workflow engine
+func NewWorkflowEngine(db *database.DB, notification *NotificationService) *WorkflowEngine {
+	return &WorkflowEngine{
+		db:              db,
+		notificationSvc: notification,
+		events:          make(chan *WorkflowEvent, 500),
+		stopCh:          make(chan struct{}),
+		workers:         2,
+	}
+}
+
+// SetWebhookService forwards every published event to tenant webhook endpoints
+// and sends webhook actions through its guarded client
+func (e *WorkflowEngine) SetWebhookService(webhooks *OutgoingWebhookService) {
+	e.webhooks = webhooks
+}
+
+// Start launches the event workers
+func (e *WorkflowEngine) Start() {
+	for i := 0; i < e.workers; i++ {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Get().Error(context.Background(), "panic recovered", "goroutine", "workflow_engine", "recover", r)
+				}
+			}()
+			for {
+				select {
+				case <-e.stopCh:
+					return
+				case evt := <-e.events:
+					e.HandleEvent(evt)
+				}
+			}
+		}()
+	}
+	logger.Get().Info(context.Background(), "Workflow engine started", "workers", e.workers)
+}
+
+// Stop stops accepting events and waits for in-flight runs to finish
+func (e *WorkflowEngine) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stopCh)
+	})
+	e.wg.Wait()
+}
+
+// Publish queues an event for evaluation. Safe to call on a nil engine so
+// services without an engine configured don't need to check. When the bus is
+// full or shutting down the event is persisted as a workflow job instead.
+func (e *WorkflowEngine) Publish(evt *WorkflowEvent) {
+	if e == nil || evt == nil || evt.TenantID == "" || evt.Event == "" {
+		return
+	}
+	if evt.OccurredAt.IsZero() {
+		evt.OccurredAt = time.Now()
+	}
+	if evt.Data == nil {
+		evt.Data = make(map[string]interface{})
+	}
+	// Webhook deliveries are written now rather than when a worker picks the
+	// event up, so a restart or a full bus can't lose them
+	e.webhooks.PublishWorkflowEvent(evt)
+
+	select {
+	case <-e.stopCh:
+		e.enqueueEvent(evt)
+		return
+	default:
+	}
+
+	select {
+	case e.events <- evt:
+	default:
+		logger.Get().Warn(context.Background(), "Workflow event queue full, deferring event to the job queue",
+			"tenant_id", evt.TenantID, "event", evt.Event, "entity_id", evt.EntityID)
+		e.enqueueEvent(evt)
+	}
+}
+
+// enqueueEvent persists the event as a workflow job for the job dispatcher
+func (e *WorkflowEngine) enqueueEvent(evt *WorkflowEvent) {
+	payload, err := json.Marshal(workflowJobPayload{Event: evt})
+	if err == nil {
+		err = NewJobQueueService(e.db).EnqueueJob(&models.AutomationJob{
+			TenantID:   evt.TenantID,
+			JobType:    models.JobTypeWorkflow,
+			Priority:   1,
+			Payload:    string(payload),
+			RunAt:      time.Now(),
+			MaxRetries: 3,
+		})
+	}
+	if err != nil {
+		logger.Get().Error(context.Background(), "Failed to persist workflow event",
+			"tenant_id", evt.TenantID, "event", evt.Event, "entity_id", evt.EntityID, "error", err)
+	}
+}
+
+// HandleEvent runs every active workflow of the tenant subscribed to the event
+func (e *WorkflowEngine) HandleEvent(evt *WorkflowEvent) {
+	var workflows []models.AutomationWorkflow
+	if err := e.db.Where("tenant_id = ? AND trigger_event = ? AND is_active = ?", evt.TenantID, evt.Event, true).
+		Order("priority DESC, created_at ASC").
+		Find(&workflows).Error; err != nil {
+		logger.Get().Error(context.Background(), "Failed to load workflows", "tenant_id", evt.TenantID, "event", evt.Event, "error", err)
+		return
+	}
+
+	for i := range workflows {
+		if err := e.RunWorkflow(&workflows[i], evt); err != nil {
+			logger.Get().Warn(context.Background(), "Workflow run failed",
+				"workflow_id", workflows[i].ID, "event", evt.Event, "error", err)
+		}
+	}
+}
+
+// RunWorkflowJob replays a queued workflow job. Unlike HandleEvent it reports the
+// error back so the job dispatcher can schedule a retry.
+func (e *WorkflowEngine) RunWorkflowJob(job *models.AutomationJob) error {
+	var payload workflowJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("invalid workflow job payload: %w", err)
+	}
+	if payload.Event == nil || payload.Event.Event == "" {
+		return fmt.Errorf("workflow job has no event")
+	}
+	evt := payload.Event
+	evt.TenantID = job.TenantID
+	if evt.Data == nil {
+		evt.Data = make(map[string]interface{})
+	}
+
+	if payload.WorkflowID == "" {
+		e.HandleEvent(evt)
+		return nil
+	}
+
+	var workflow models.AutomationWorkflow
+	if err := e.db.Where("id = ? AND tenant_id = ?", payload.WorkflowID, job.TenantID).First(&workflow).Error; err != nil {
+		return fmt.Errorf("workflow not found: %w", err)
+	}
+	if !workflow.IsActive {
+		return nil
+	}
+	if payload.ResumeStep != nil {
+		// The conditions matched when the event happened; only the remaining actions run
+		return e.runActions(&workflow, evt, *payload.ResumeStep, true)
+	}
+	return e.RunWorkflow(&workflow, evt)
+}
+
+// RunWorkflow evaluates the workflow conditions and, when they match, executes its
+// ordered actions. An action with a delay is queued as a workflow job due when the
+// delay runs out rather than held in the event worker. Run counters and the audit
+// log are updated once the last action has run.
+func (e *WorkflowEngine) RunWorkflow(workflow *models.AutomationWorkflow, evt *WorkflowEvent) error {
+	var conditions []models.WorkflowCondition
+	if workflow.Conditions != "" && workflow.Conditions != "null" {
+		if err := json.Unmarshal([]byte(workflow.Conditions), &conditions); err != nil {
+			e.recordRun(workflow, evt, fmt.Errorf("invalid conditions: %w", err), 0)
+			return err
+		}
+	}
+
+	if !EvaluateWorkflowConditions(conditions, evt.Data) {
+		return nil
+	}
+	return e.runActions(workflow, evt, 0, false)
+}
+
+// runActions executes the workflow's actions from index from onwards. When
+// resumed is set the delay of the action at from has already been served.
+func (e *WorkflowEngine) runActions(workflow *models.AutomationWorkflow, evt *WorkflowEvent, from int, resumed bool) error {
+	var actions []models.WorkflowAction
+	if err := json.Unmarshal([]byte(workflow.Actions), &actions); err != nil {
+		e.recordRun(workflow, evt, fmt.Errorf("invalid actions: %w", err), 0)
+		return err
+	}
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Order < actions[j].Order })
+
+	executed := 0
+	var runErr error
+	for i := from; i < len(actions); i++ {
+		action := actions[i]
+		if action.Delay > 0 && !(resumed && i == from) {
+			runAt, err := e.scheduleActions(workflow, evt, i, time.Duration(action.Delay)*time.Second)
+			if err != nil {
+				runErr = fmt.Errorf("failed to schedule delayed action %s: %w", action.ActionType, err)
+				break
+			}
+			e.audit(workflow, evt, "workflow_action_scheduled",
+				fmt.Sprintf("Workflow %q will run %s at %s", workflow.Name, action.ActionType, runAt.Format(time.RFC3339)),
+				map[string]interface{}{
+					"action_type":      action.ActionType,
+					"order":            action.Order,
+					"actions_executed": executed,
+				})
+			return nil
+		}
+
+		if err := e.executeAction(workflow, &action, evt); err != nil {
+			runErr = fmt.Errorf("action %s failed: %w", action.ActionType, err)
+			e.audit(workflow, evt, "workflow_action_failed", runErr.Error(), map[string]interface{}{
+				"action_type": action.ActionType,
+				"order":       action.Order,
+			})
+			break
+		}
+		executed++
+	}
+
+	e.recordRun(workflow, evt, runErr, executed)
+	return runErr
+}
+
+// scheduleActions queues the rest of the run, starting at the delayed action
+// at step, as a workflow job for the job dispatcher to pick up once the delay
+// has passed. The idempotency key keeps a retried run from queueing it twice.
+func (e *WorkflowEngine) scheduleActions(workflow *models.AutomationWorkflow, evt *WorkflowEvent, step int, delay time.Duration) (time.Time, error) {
+	payload, err := json.Marshal(workflowJobPayload{WorkflowID: workflow.ID, ResumeStep: &step, Event: evt})
+	if err != nil {
+		return time.Time{}, err
+	}
+	runAt := time.Now().Add(delay)
+	key := fmt.Sprintf("workflow_%s_%s_%d_%d", workflow.ID, evt.EntityID, evt.OccurredAt.UnixNano(), step)
+	workflowID := workflow.ID
+	return runAt, NewJobQueueService(e.db).EnqueueJobWithIdempotency(key, &models.AutomationJob{
+		TenantID:       workflow.TenantID,
+		JobType:        models.JobTypeWorkflow,
+		Priority:       1,
+		Payload:        string(payload),
+		RunAt:          runAt,
+		MaxRetries:     3,
+		IdempotencyKey: key,
+		AutomationID:   &workflowID,
+	})
+}
+
+// recordRun updates the run counters and writes the run audit entry
+func (e *WorkflowEngine) recordRun(workflow *models.AutomationWorkflow, evt *WorkflowEvent, runErr error, executed int) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"total_runs":  gorm.Expr("total_runs + 1"),
+		"last_run_at": now,
+		"updated_at":  now,
+	}
+	event := "workflow_executed"
+	description := fmt.Sprintf("Workflow %q executed %d action(s) for %s", workflow.Name, executed, evt.Event)
+	if runErr != nil {
+		updates["failed_runs"] = gorm.Expr("failed_runs + 1")
+		event = "workflow_failed"
+		description = fmt.Sprintf("Workflow %q failed for %s: %v", workflow.Name, evt.Event, runErr)
+	} else {
+		updates["success_runs"] = gorm.Expr("success_runs + 1")
+	}
+
+	if err := e.db.Model(&models.AutomationWorkflow{}).
+		Where("id = ? AND tenant_id = ?", workflow.ID, workflow.TenantID).
+		Updates(updates).Error; err != nil {
+		logger.Get().Error(context.Background(), "Failed to update workflow counters", "workflow_id", workflow.ID, "error", err)
+	}
+
+	e.audit(workflow, evt, event, description, map[string]interface{}{
+		"actions_executed": executed,
+		"trigger_event":    evt.Event,
+	})
+}
+
+func (e *WorkflowEngine) audit(workflow *models.AutomationWorkflow, evt *WorkflowEvent, event, description string, metadata map[string]interface{}) {
+	metadataJSON, _ := json.Marshal(metadata)
+	payloadJSON, _ := json.Marshal(evt.Data)
+	e.db.Create(&models.AutomationAuditLog{
+		ID:          uuid.New().String(),
+		TenantID:    workflow.TenantID,
+		UserID:      evt.UserID,
+		ActorType:   "automation",
+		ActorID:     workflow.ID,
+		Event:       event,
+		EntityType:  evt.EntityType,
+		EntityID:    evt.EntityID,
+		Description: description,
+		NewValue:    string(payloadJSON),
+		Metadata:    string(metadataJSON),
+		CreatedAt:   time.Now(),
+	})
+}
+
+// executeAction performs a single workflow action
+func (e *WorkflowEngine) executeAction(workflow *models.AutomationWorkflow, action *models.WorkflowAction, evt *WorkflowEvent) error {
+	vars := workflowVariables(evt.Data)
+
+	switch action.ActionType {
+	case WorkflowActionSendEmail, WorkflowActionSendSMS, WorkflowActionSendWhatsApp:
+		if e.notificationSvc == nil {
+			return errors.New("notification service not configured")
+		}
+		channel, defaultKey := ChannelEmail, "client_email"
+		switch action.ActionType {
+		case WorkflowActionSendSMS:
+			channel, defaultKey = ChannelSMS, "client_phone"
+		case WorkflowActionSendWhatsApp:
+			channel, defaultKey = ChannelWA, "client_phone"
+		}
+		recipient := configString(action.Config, "to", vars[defaultKey])
+		if recipient == "" {
+			return fmt.Errorf("no recipient for %s", action.ActionType)
+		}
+		return e.notificationSvc.Send(context.Background(), &NotificationRequest{
+			TenantID:  workflow.TenantID,
+			UserID:    workflow.UserID,
+			EventType: "workflow." + evt.Event,
+			Channels:  []string{channel},
+			Recipient: recipient,
+			Subject:   configString(action.Config, "subject", workflow.Name),
+			Body:      configString(action.Config, "message", workflow.Name),
+			Variables: vars,
+			Reference: evt.EntityID,
+		})
+
+	case WorkflowActionNotifyAdmin:
+		if e.notificationSvc == nil {
+			return errors.New("notification service not configured")
+		}
+		var admins []models.User
+		e.db.Where("tenant_id = ? AND role IN ?", workflow.TenantID, []string{"owner", "admin"}).Find(&admins)
+		for _, admin := range admins {
+			e.notificationSvc.Send(context.Background(), &NotificationRequest{
+				TenantID:  workflow.TenantID,
+				UserID:    admin.ID,
+				EventType: "workflow." + evt.Event,
+				Channels:  []string{ChannelEmail},
+				Recipient: admin.Email,
+				Subject:   configString(action.Config, "subject", workflow.Name),
+				Body:      configString(action.Config, "message", fmt.Sprintf("Workflow %q triggered by %s", workflow.Name, evt.Event)),
+				Variables: vars,
+				Reference: evt.EntityID,
+			})
+		}
+		return nil
+
+	case WorkflowActionUpdateStatus:
+		if evt.EntityType != "invoice" {
+			return fmt.Errorf("update_status is only supported for invoice events")
+		}
+		status := models.InvoiceStatus(configString(action.Config, "status", ""))
+		if status == "" {
+			return errors.New("status is required")
+		}
+		return e.db.Transaction(func(tx *gorm.DB) error {
+			var invoice models.Invoice
+			if err := tx.Scopes(database.TenantFilter(workflow.TenantID)).First(&invoice, "id = ?", evt.EntityID).Error; err != nil {
+				return ErrInvoiceNotFound
+			}
+			if invoice.Status == status {
+				return nil
+			}
+			if err := models.ValidateTransition(invoice.Status, status); err != nil {
+				return err
+			}
+			return tx.Model(&invoice).Updates(map[string]interface{}{
+				"status":  status,
+				"version": gorm.Expr("version + 1"),
+			}).Error
+		})
+
+	case WorkflowActionWebhook:
+		url := configString(action.Config, "url", "")
+		if url == "" {
+			return errors.New("webhook url is required")
+		}
+		if e.webhooks == nil {
+			return errors.New("webhook actions are unavailable")
+		}
+		body, _ := json.Marshal(map[string]interface{}{
+			"workflow_id": workflow.ID,
+			"event":       evt.Event,
+			"entity_type": evt.EntityType,
+			"entity_id":   evt.EntityID,
+			"data":        evt.Data,
+			"occurred_at": evt.OccurredAt,
+		})
+		return e.webhooks.postAction(context.Background(), url, body)
+	}
+
+	return fmt.Errorf("unsupported action type %q", action.ActionType)
+}
+
+// EvaluateWorkflowConditions returns true when every condition matches the payload.
+// An empty condition list always matches.
+func EvaluateWorkflowConditions(conditions []models.WorkflowCondition, data map[string]interface{}) bool {
+	for _, cond := range conditions {
+		if !evaluateCondition(cond, data) {
+			return false
+		}
+	}
+	return true
+}
+
+func evaluateCondition(cond models.WorkflowCondition, data map[string]interface{}) bool {
+	actual, exists := lookupField(data, cond.Field)
+
+	switch cond.Operator {
+	case ConditionEquals, "":
+		return exists && valuesEqual(actual, cond.Value)
+	case ConditionNotEquals:
+		return !exists || !valuesEqual(actual, cond.Value)
+	case ConditionContains:
+		return exists && strings.Contains(strings.ToLower(fmt.Sprint(actual)), strings.ToLower(fmt.Sprint(cond.Value)))
+	case ConditionGreaterThan, ConditionLessThan:
+		a, okA := toFloat(actual)
+		b, okB := toFloat(cond.Value)
+		if !exists || !okA || !okB {
+			return false
+		}
+		if cond.Operator == ConditionGreaterThan {
+			return a > b
+		}
+		return a < b
+	case ConditionIn, ConditionNotIn:
+		found := false
+		if list, ok := cond.Value.([]interface{}); ok {
+			for _, v := range list {
+				if valuesEqual(actual, v) {
+					found = true
+					break
+				}
+			}
+		} else {
+			for _, v := range strings.Split(fmt.Sprint(cond.Value), ",") {
+				if valuesEqual(actual, strings.TrimSpace(v)) {
+					found = true
+					break
+				}
+			}
+		}
+		if cond.Operator == ConditionIn {
+			return exists && found
+		}
+		return !found
+	}
+	return false
+}
+
+// lookupField resolves dotted paths such as "client.country"
+func lookupField(data map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = data
+	for _, part := range strings.Split(field, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			return fa == fb
+		}
+	}
+	return strings.EqualFold(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case models.Money:
+		return n.Float64(), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func configString(config map[string]interface{}, key, def string) string {
+	if v, ok := config[key].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// workflowVariables flattens the payload into template variables for notifications
+func workflowVariables(data map[string]interface{}) map[string]string {
+	vars := make(map[string]string, len(data))
+	for k, v := range data {
+		switch val := v.(type) {
+		case map[string]interface{}, []interface{}:
+			continue
+		case float64:
+			vars[k] = strconv.FormatFloat(val, 'f', -1, 64)
+		default:
+			vars[k] = fmt.Sprint(val)
+		}
+	}
+	return vars
+}
+
+// invoiceEventData builds the standard payload for invoice events
+func invoiceEventData(invoice *models.Invoice, client *models.Client) map[string]interface{} {
+	data := map[string]interface{}{
+		"invoice_id":     invoice.ID,
+		"invoice_number": invoice.InvoiceNumber,
+		"status":         string(invoice.Status),
+		"currency":       invoice.Currency,
+		"total":          invoice.Total.Float64(),
+		"paid_amount":    invoice.PaidAmount.Float64(),
+		"balance_due":    invoice.BalanceDue.Float64(),
+		"due_date":       invoice.DueDate.Format("2006-01-02"),
+		"client_id":      invoice.ClientID,
+		"kra_status":     string(invoice.KRAStatus),
+	}
+	if client != nil && client.ID != "" {
+		data["client_name"] = client.Name
+		data["client_email"] = client.Email
+		data["client_phone"] = client.Phone
+		data["client_country"] = client.Country
+	}
+	return data
+}
+
+// paymentEventData builds the standard payload for payment events
+func paymentEventData(payment *models.Payment, invoice *models.Invoice) map[string]interface{} {
+	data := map[string]interface{}{
+		"payment_id":     payment.ID,
+		"amount":         payment.Amount.Float64(),
+		"method":         string(payment.Method),
+		"reference":      payment.Reference,
+		"payment_status": string(payment.Status),
+		"phone_number":   payment.PhoneNumber,
+		"failure_reason": payment.FailureReason,
+	}
+	if invoice != nil {
+		for k, v := range invoiceEventData(invoice, nil) {
+			data[k] = v
+		}
+	}
+	return data
+}
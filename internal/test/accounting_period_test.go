@@ -0,0 +1,137 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// Accounting Period Tests
+// ============================================================
+
+// TestAccountingPeriodClose tests closing, locking and reopening months and the audit trail
+func TestAccountingPeriodClose(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	periods := services.NewPeriodService(db)
+	adminID := uuid.New().String()
+
+	_, err := periods.ClosePeriod(tenantID, adminID, services.PeriodKey(time.Now()))
+	assert.ErrorIs(t, err, services.ErrPeriodNotEnded, "the current month stays open")
+	_, err = periods.ClosePeriod(tenantID, adminID, "March 2024")
+	assert.ErrorIs(t, err, services.ErrPeriodInvalid)
+
+	closed, err := periods.ClosePeriod(tenantID, adminID, "2024-03")
+	require.NoError(t, err)
+	assert.Equal(t, models.PeriodClosed, closed.Status)
+	assert.Equal(t, adminID, closed.ClosedBy)
+	assert.Equal(t, "2024-03-31", closed.EndDate.Format("2006-01-02"))
+	_, err = periods.ClosePeriod(tenantID, adminID, "2024-03")
+	assert.ErrorIs(t, err, services.ErrPeriodClosed)
+
+	march := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	assert.ErrorIs(t, periods.CheckOpen(tenantID, march), services.ErrPeriodClosed)
+	assert.NoError(t, periods.CheckOpen(tenantID, march.AddDate(0, 1, 0)))
+	assert.NoError(t, periods.CheckOpen(uuid.New().String(), march), "periods are per tenant")
+	var nilPeriods *services.PeriodService
+	assert.NoError(t, nilPeriods.CheckOpen(tenantID, march), "no period control wired in")
+
+	_, err = periods.ReopenPeriod(tenantID, adminID, "2024-03", "  ")
+	assert.ErrorIs(t, err, services.ErrPeriodReason)
+	reopened, err := periods.ReopenPeriod(tenantID, adminID, "2024-03", "Supplier invoice missed")
+	require.NoError(t, err)
+	assert.Equal(t, models.PeriodOpen, reopened.Status)
+	assert.Equal(t, "Supplier invoice missed", reopened.ReopenReason)
+	assert.NoError(t, periods.CheckOpen(tenantID, march))
+
+	_, err = periods.LockPeriod(tenantID, adminID, "2024-03")
+	require.NoError(t, err)
+	_, err = periods.ReopenPeriod(tenantID, adminID, "2024-03", "VAT amendment")
+	assert.ErrorIs(t, err, services.ErrPeriodLocked, "locked periods stay closed")
+
+	var actions []string
+	require.NoError(t, db.Model(&models.AuditLog{}).Where("tenant_id = ? AND entity_type = ?", tenantID, services.AuditEntityPeriod).
+		Order("created_at").Pluck("action", &actions).Error)
+	assert.Equal(t, []string{services.AuditActionPeriodClose, services.AuditActionPeriodReopen, services.AuditActionPeriodLock}, actions)
+
+	// A July fiscal year puts March in period 9 of FY2023/24
+	_, err = periods.SetFiscalYear(tenantID, adminID, 13)
+	assert.ErrorIs(t, err, services.ErrFiscalYearStart)
+	_, err = periods.SetFiscalYear(tenantID, adminID, 7)
+	require.NoError(t, err)
+	list, err := periods.ListPeriods(tenantID, 2023)
+	require.NoError(t, err)
+	require.Len(t, list, 12)
+	assert.Equal(t, "2023-07", list[0].Period)
+	assert.Equal(t, "2024-03", list[8].Period)
+	assert.Equal(t, 9, list[8].PeriodNumber)
+	assert.Equal(t, "FY2023/24", list[8].FiscalYear)
+	assert.Equal(t, models.PeriodLocked, list[8].Status)
+	assert.Equal(t, models.PeriodOpen, list[9].Status)
+}
+
+// TestAccountingPeriodGuards tests documents dated in a closed month can't be created, changed or deleted
+func TestAccountingPeriodGuards(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	periods := services.NewPeriodService(db)
+	userID := uuid.New().String()
+	_, err := periods.ClosePeriod(tenantID, userID, "2024-03")
+	require.NoError(t, err)
+	march := time.Date(2024, 3, 20, 9, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 2, 9, 0, 0, 0, time.UTC)
+
+	// Expenses
+	expenses := services.NewExpenseService(db)
+	expenses.SetPeriodService(periods)
+	_, err = expenses.CreateExpense(context.Background(), tenantID, userID, &services.CreateExpenseRequest{Title: "Rent", Amount: 30000, Currency: "KES", Date: "2024-03-31"})
+	assert.ErrorIs(t, err, services.ErrPeriodClosed)
+	expense, err := expenses.CreateExpense(context.Background(), tenantID, userID, &services.CreateExpenseRequest{Title: "Rent", Amount: 30000, Currency: "KES", Date: "2024-04-01"})
+	require.NoError(t, err)
+	backdated := "2024-03-01"
+	_, err = expenses.UpdateExpense(context.Background(), tenantID, expense.ID, &services.UpdateExpenseRequest{Date: &backdated})
+	assert.ErrorIs(t, err, services.ErrPeriodClosed)
+
+	marchExpense := &models.Expense{ID: uuid.New().String(), TenantID: tenantID, Title: "Fuel", Amount: models.ToCents(500), Currency: "KES", Date: march}
+	require.NoError(t, db.Create(marchExpense).Error)
+	assert.ErrorIs(t, expenses.DeleteExpense(tenantID, marchExpense.ID), services.ErrPeriodClosed)
+
+	// Invoices and payments
+	invoices := services.NewInvoiceServiceWithDeps(db, &services.ServiceDependencies{Periods: periods})
+	sent := ledgerInvoice(t, db, tenantID, "INV-P-001", "invoice", 1000, 160, 1160, march)
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", sent.ID).Update("created_at", march).Error)
+	assert.ErrorIs(t, invoices.CancelInvoice(tenantID, sent.ID, userID), services.ErrPeriodClosed)
+	assert.ErrorIs(t, invoices.DeleteInvoice(tenantID, sent.ID), services.ErrPeriodClosed)
+
+	current := ledgerInvoice(t, db, tenantID, "INV-P-002", "invoice", 1000, 0, 1000, april)
+	err = invoices.RecordPayment(tenantID, current.ID, &models.Payment{
+		ID: uuid.New().String(), TenantID: tenantID, Amount: models.ToCents(1000), Currency: "KES",
+		Method: models.PaymentMethodBank, Status: models.PaymentStatusCompleted, CompletedAt: &march,
+	})
+	assert.ErrorIs(t, err, services.ErrPeriodClosed, "payments can't be backdated into a closed month")
+
+	// Manual journals
+	ledger := services.NewLedgerService(db)
+	ledger.SetPeriodService(periods)
+	_, err = ledger.CreateJournal(tenantID, userID, march, "Accrual", nil)
+	assert.ErrorIs(t, err, services.ErrPeriodClosed)
+	// Payments received or allocated today are booked today, so today's period
+	// must be open for them
+	require.NoError(t, db.Create(&models.AccountingPeriod{
+		ID: uuid.New().String(), TenantID: tenantID, Period: services.PeriodKey(time.Now()), Status: models.PeriodClosed,
+	}).Error)
+	matching := services.NewPaymentMatchingService(db, nil)
+	matching.SetPeriodService(periods)
+	_, err = matching.AllocatePayment(tenantID, userID, &services.AllocatePaymentRequest{ClientID: current.ClientID, Amount: 500})
+	assert.ErrorIs(t, err, services.ErrPeriodClosed)
+	assert.ErrorIs(t, matching.ManualMatch(tenantID, current.ID, "QKX1234567", "", 500, userID), services.ErrPeriodClosed)
+	unallocated, err := matching.CreateUnallocated(tenantID, "QKX7654321", "", 500)
+	require.NoError(t, err)
+	assert.ErrorIs(t, matching.MatchPayment(tenantID, unallocated.ID, current.ID, userID), services.ErrPeriodClosed)
+}
@@ -0,0 +1,213 @@
+package services_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/middleware"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// API Key Tests
+// ============================================================
+
+func setupAPIKeys(t *testing.T) (*database.DB, *services.AuthService, *models.User) {
+	if os.Getenv("ENCRYPTION_KEY") == "" {
+		os.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-testing-only-1234567890")
+	}
+	models.InitEncryption(os.Getenv("ENCRYPTION_KEY"))
+
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Tenant{}, &models.APIKey{}))
+
+	tenantID := uuid.New().String()
+	require.NoError(t, db.Create(&models.Tenant{ID: tenantID, Name: "ERP Tenant", Plan: "free"}).Error)
+	user := &models.User{ID: uuid.New().String(), TenantID: tenantID, Email: "erp@test.com", Name: "Owner", Role: "owner"}
+	require.NoError(t, db.Create(user).Error)
+
+	cfg := &config.Config{
+		JWT:    config.JWTConfig{Secret: "test-secret-key-for-jwt-tokens-min-32-chars-long!!", Expiry: 15 * time.Minute},
+		Server: config.ServerConfig{Mode: "test"},
+	}
+	return db, services.NewAuthService(db, cfg, nil, nil, nil), user
+}
+
+// TestCreateAndAuthenticateAPIKey tests keys are stored hashed and resolve to their tenant
+func TestCreateAndAuthenticateAPIKey(t *testing.T) {
+	db, authSvc, user := setupAPIKeys(t)
+
+	key, rawKey, err := authSvc.CreateAPIKey(user.TenantID, user.ID, &services.CreateAPIKeyRequest{
+		Name:   "ERP",
+		Scopes: []string{"invoices:write", "payments:read"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, rawKey, services.APIKeyPrefix)
+	assert.Equal(t, "invoices:write,payments:read", key.Scopes)
+
+	var stored models.APIKey
+	require.NoError(t, db.First(&stored, "id = ?", key.ID).Error)
+	assert.NotEqual(t, rawKey, stored.Key)
+	assert.NotContains(t, stored.KeyHash, rawKey)
+
+	gotKey, gotUser, err := authSvc.AuthenticateAPIKey(rawKey)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, gotKey.ID)
+	assert.Equal(t, user.ID, gotUser.ID)
+
+	_, _, err = authSvc.AuthenticateAPIKey(rawKey + "x")
+	assert.ErrorIs(t, err, services.ErrAPIKeyInvalid)
+
+	_, _, err = authSvc.CreateAPIKey(user.TenantID, user.ID, &services.CreateAPIKeyRequest{Scopes: []string{"settings:write"}})
+	assert.Error(t, err)
+}
+
+// TestRotateAndRevokeAPIKey tests rotation replaces the key and revocation disables it
+func TestRotateAndRevokeAPIKey(t *testing.T) {
+	_, authSvc, user := setupAPIKeys(t)
+
+	old, oldRaw, err := authSvc.CreateAPIKey(user.TenantID, user.ID, &services.CreateAPIKeyRequest{Name: "ERP"})
+	require.NoError(t, err)
+
+	rotated, newRaw, err := authSvc.RotateAPIKey(user.TenantID, user.ID, old.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ERP", rotated.Name)
+	assert.Equal(t, old.Scopes, rotated.Scopes)
+
+	_, _, err = authSvc.AuthenticateAPIKey(oldRaw)
+	assert.ErrorIs(t, err, services.ErrAPIKeyInvalid)
+	_, _, err = authSvc.AuthenticateAPIKey(newRaw)
+	require.NoError(t, err)
+
+	require.NoError(t, authSvc.RevokeAPIKey(user.TenantID, user.ID, rotated.ID))
+	_, _, err = authSvc.AuthenticateAPIKey(newRaw)
+	assert.ErrorIs(t, err, services.ErrAPIKeyInvalid)
+
+	assert.ErrorIs(t, authSvc.RevokeAPIKey(uuid.New().String(), user.ID, old.ID), services.ErrAPIKeyNotFound)
+}
+
+// TestAPIKeyAllows tests scope checks per resource and method
+func TestAPIKeyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		method string
+		path   string
+		want   bool
+	}{
+		{"read only GET", []string{"read_only"}, "GET", "/api/v1/tenant/invoices", true},
+		{"read only POST", []string{"read_only"}, "POST", "/api/v1/tenant/invoices", false},
+		{"write implies read", []string{"invoices:write"}, "GET", "/api/v1/tenant/invoices/123", true},
+		{"write", []string{"invoices:write"}, "POST", "/api/v1/tenant/invoices", true},
+		{"wrong resource", []string{"invoices:write"}, "POST", "/api/v1/tenant/clients", false},
+		{"payments read", []string{"payments:read"}, "GET", "/api/v1/tenant/payments", true},
+		{"payments read cannot write", []string{"payments:read"}, "POST", "/api/v1/tenant/payments", false},
+		{"settings closed", []string{"read_only"}, "GET", "/api/v1/tenant/settings", false},
+		{"api keys closed", []string{"invoices:write"}, "POST", "/api/v1/tenant/api-keys", false},
+		{"outside tenant api", []string{"read_only"}, "GET", "/dashboard", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, middleware.APIKeyAllows(tt.scopes, tt.method, tt.path))
+		})
+	}
+}
+
+// TestTenantMiddlewareAPIKey tests API keys authenticate alongside JWTs
+func TestTenantMiddlewareAPIKey(t *testing.T) {
+	db, authSvc, user := setupAPIKeys(t)
+	_, rawKey, err := authSvc.CreateAPIKey(user.TenantID, user.ID, &services.CreateAPIKeyRequest{
+		Scopes: []string{"invoices:read"},
+	})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	group := app.Group("/api/v1/tenant/invoices")
+	group.Use(middleware.TenantMiddleware(authSvc, db))
+	handler := func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"tenant_id": middleware.GetTenantID(c), "api_key": middleware.IsAPIKeyRequest(c)})
+	}
+	group.Get("/", handler)
+	group.Post("/", handler)
+
+	req := newRequest("GET", "/api/v1/tenant/invoices/", "")
+	req.Header.Set("Authorization", "Bearer "+rawKey)
+	resp, err := app.Test(req, 5000)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	req = newRequest("GET", "/api/v1/tenant/invoices/", "")
+	req.Header.Set("X-API-Key", rawKey)
+	resp, err = app.Test(req, 5000)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	req = newRequest("POST", "/api/v1/tenant/invoices/", "{}")
+	req.Header.Set("X-API-Key", rawKey)
+	resp, err = app.Test(req, 5000)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+
+	req = newRequest("GET", "/api/v1/tenant/invoices/", "")
+	req.Header.Set("X-API-Key", services.APIKeyPrefix+"bogus")
+	resp, err = app.Test(req, 5000)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+}
+
+// TestCSRFExemptsValidAPIKey tests a key-authenticated write passes the CSRF
+// middleware, a made-up key is left to the tenant middleware to reject and a
+// key sent along with a browser session's cookie is still held to the CSRF check
+func TestCSRFExemptsValidAPIKey(t *testing.T) {
+	db, authSvc, user := setupAPIKeys(t)
+	_, rawKey, err := authSvc.CreateAPIKey(user.TenantID, user.ID, &services.CreateAPIKeyRequest{
+		Scopes: []string{"invoices:write"},
+	})
+	require.NoError(t, err)
+
+	csrf, stop := middleware.NewCSRFMiddleware()
+	defer stop()
+
+	app := fiber.New()
+	app.Use(csrf)
+	group := app.Group("/api/v1/tenant/invoices")
+	group.Use(middleware.TenantMiddleware(authSvc, db))
+	group.Post("/", func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"tenant_id": middleware.GetTenantID(c)})
+	})
+
+	post := func(key, cookie string) int {
+		req := newRequest("POST", "/api/v1/tenant/invoices/", "{}")
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		if cookie != "" {
+			req.Header.Set("Cookie", "csrf_token="+cookie)
+		}
+		resp, err := app.Test(req, 5000)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusCreated, post(rawKey, ""))
+	assert.Equal(t, fiber.StatusUnauthorized, post(services.APIKeyPrefix+"bogus", ""))
+	assert.Equal(t, fiber.StatusForbidden, post("bogus", ""))
+	assert.Equal(t, fiber.StatusForbidden, post(rawKey, "stale-token"))
+	assert.Equal(t, fiber.StatusForbidden, post("", ""))
+}
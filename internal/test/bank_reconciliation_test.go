@@ -0,0 +1,207 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// Bank Reconciliation Tests
+// ============================================================
+
+const equityCSV = `Equity Bank Kenya Limited
+Account Number:,0170291234567
+Statement Period:,01/03/2026 - 31/03/2026
+
+Transaction Date,Value Date,Narrative,Reference,Debit,Credit,Balance
+01/03/2026,01/03/2026,Opening Balance,,,,"100,000.00"
+02/03/2026,02/03/2026,RTGS FROM JUA KALI LTD,FT2606112345,,"45,000.00","145,000.00"
+03/03/2026,03/03/2026,EFT ACME SUPPLIES,,,"12,500.00","157,500.00"
+03/03/2026,03/03/2026,CASH DEPOSIT WESTLANDS,,,"8,000.00","165,000.00"
+10/03/2026,10/03/2026,PESALINK TO KPLC PREPAID,TKN88213,"6,200.00",,"158,800.00"
+10/03/2026,10/03/2026,LEDGER FEE,,150.00,,"158,650.00"
+11/03/2026,11/03/2026,CHQ DEPOSIT,CHQ000412,,"30,000.00","188,650.00"
+,,INV-2026-017,,,,
+`
+
+const ofxStatement = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>KES
+<BANKACCTFROM><BANKID>01<ACCTID>1122334455<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20260305120000[+3:EAT]<TRNAMT>2500.00<FITID>NCBA0001<NAME>MOBILE BANKING<MEMO>Payment INV-9 &amp; deposit</STMTTRN>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20260306<TRNAMT>-1000.50<FITID>NCBA0002<NAME>ATM WITHDRAWAL</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>51499.50<DTASOF>20260331</LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const mt940Statement = `{1:F01KCBLKENXAXXX0000000000}{2:O9401200260401KCBLKENXAXXX00000000002604011200N}{4:
+:20:STMT260331
+:25:KCBLKENX/1234567890
+:28C:00031/001
+:60F:C260301KES100000,00
+:61:2603020302C45000,00NTRFFT2606112345//KCB12345
+:86:RTGS JUA KALI LTD
+INV-2026-001
+:61:2603100310D6200,00NTRFNONREF//KCB12399
+:86:?20PESALINK?21KPLC
+:62F:C260331KES138800,00
+-}`
+
+func bankPayment(t *testing.T, db *database.DB, tenantID, reference string, amount float64, completed time.Time) *models.Payment {
+	payment := &models.Payment{
+		ID: uuid.New().String(), TenantID: tenantID, InvoiceID: uuid.New().String(), Amount: models.ToCents(amount),
+		Method: models.PaymentMethodBank, Status: models.PaymentStatusCompleted, Reference: reference, CompletedAt: &completed,
+	}
+	require.NoError(t, db.Create(payment).Error)
+	return payment
+}
+
+// TestBankStatementFormats tests bank CSV exports, OFX and MT940 are read and re-imports are skipped
+func TestBankStatementFormats(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	bank := services.NewBankReconciliationService(db)
+
+	result, err := bank.Import(tenantID, uuid.New().String(), "equity-march.csv", []byte(equityCSV), &services.BankImportOptions{BankName: "Equity"})
+	require.NoError(t, err)
+	assert.Equal(t, 6, result.Imported, "opening balance row is not a transaction")
+	assert.Equal(t, "0170291234567", result.Statement.AccountNumber)
+	statement, err := bank.GetStatement(tenantID, result.Statement.ID)
+	require.NoError(t, err)
+	require.Len(t, statement.Lines, 6)
+	assert.True(t, statement.Lines[0].Amount.Equals(models.ToCents(45000)))
+	assert.True(t, statement.Lines[4].Amount.Equals(models.ToCents(-150)))
+	assert.Equal(t, "CHQ DEPOSIT INV-2026-017", statement.Lines[5].Description, "wrapped narrative is joined")
+
+	again, err := bank.Import(tenantID, uuid.New().String(), "equity-march.csv", []byte(equityCSV), nil)
+	require.NoError(t, err)
+	assert.Zero(t, again.Imported)
+	assert.Equal(t, 6, again.Duplicates)
+
+	ofx, err := bank.Import(tenantID, uuid.New().String(), "ncba.ofx", []byte(ofxStatement), nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, ofx.Imported)
+	assert.Equal(t, "1122334455", ofx.Statement.AccountNumber)
+	require.NotNil(t, ofx.Statement.ClosingBalance)
+	assert.True(t, ofx.Statement.ClosingBalance.Equals(models.ToCents(51499.50)))
+	statement, err = bank.GetStatement(tenantID, ofx.Statement.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "MOBILE BANKING Payment INV-9 & deposit", statement.Lines[0].Description)
+	assert.Equal(t, "NCBA0001", statement.Lines[0].BankReference)
+	assert.True(t, statement.Lines[1].Amount.Equals(models.ToCents(-1000.50)))
+
+	mt940, err := bank.Import(tenantID, uuid.New().String(), "kcb.sta", []byte(mt940Statement), nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, mt940.Imported)
+	assert.Equal(t, "KCBLKENX/1234567890", mt940.Statement.AccountNumber)
+	assert.True(t, mt940.Statement.OpeningBalance.Equals(models.ToCents(100000)))
+	statement, err = bank.GetStatement(tenantID, mt940.Statement.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "FT2606112345", statement.Lines[0].Reference)
+	assert.Equal(t, "RTGS JUA KALI LTD INV-2026-001", statement.Lines[0].Description)
+	assert.Empty(t, statement.Lines[1].Reference, "NONREF is no reference")
+	assert.True(t, statement.Lines[1].Amount.Equals(models.ToCents(-6200)))
+	assert.Equal(t, "PESALINK KPLC", statement.Lines[1].Description)
+
+	_, err = bank.Import(tenantID, uuid.New().String(), "statement.pdf", []byte("%PDF-1.4"), nil)
+	assert.ErrorIs(t, err, services.ErrBankStatementFormat)
+}
+
+// TestBankReconciliation tests statement lines are matched to payments, deposits and expenses
+func TestBankReconciliation(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	bank := services.NewBankReconciliationService(db)
+	userID := uuid.New().String()
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 9, 0, 0, 0, time.UTC) }
+
+	byReference := bankPayment(t, db, tenantID, "FT2606112345", 45000, day(1))
+	// Two 12,500 payments: the EFT line can't tell which one it is
+	eftA := bankPayment(t, db, tenantID, "", 12500, day(3))
+	eftB := bankPayment(t, db, tenantID, "", 12500, day(4))
+	deposit := &models.UnallocatedPayment{
+		ID: uuid.New().String(), TenantID: tenantID, Amount: models.ToCents(8000), Currency: "KES",
+		Reference: "WESTLANDS", CreatedAt: day(3),
+	}
+	require.NoError(t, db.Create(deposit).Error)
+	paidAt := day(9)
+	expense := &models.Expense{
+		ID: uuid.New().String(), TenantID: tenantID, Title: "Electricity", Amount: models.ToCents(6200), Currency: "KES",
+		Date: day(9), Status: "paid", PaymentMethod: "bank", Reference: "TKN88213", PaidAt: &paidAt,
+	}
+	require.NoError(t, db.Create(expense).Error)
+	notOnStatement := bankPayment(t, db, tenantID, "FT2606199999", 7000, day(20))
+
+	result, err := bank.Import(tenantID, userID, "equity-march.csv", []byte(equityCSV), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Matched, "payment by reference, deposit and expense")
+	assert.Equal(t, 1, result.Suggested)
+	assert.Equal(t, 2, result.Unmatched, "ledger fee and cheque deposit")
+
+	statement, err := bank.GetStatement(tenantID, result.Statement.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, statement.MatchedCount)
+	lines := statement.Lines
+	assert.Equal(t, models.BankLineMatched, lines[0].Status)
+	assert.Equal(t, byReference.ID, lines[0].MatchID)
+	assert.Equal(t, "reference", lines[0].MatchReason)
+	assert.Equal(t, models.BankMatchUnallocated, lines[2].MatchType)
+	assert.Equal(t, deposit.ID, lines[2].MatchID)
+	assert.Equal(t, models.BankMatchExpense, lines[3].MatchType)
+	assert.Equal(t, expense.ID, lines[3].MatchID)
+
+	queue, err := bank.ReviewQueue(tenantID)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, lines[1].ID, queue[0].ID)
+	require.Len(t, queue[0].Suggestions, 2)
+	assert.Equal(t, eftA.ID, queue[0].Suggestions[0].ID, "the same-day payment ranks first")
+
+	_, err = bank.ConfirmMatch(tenantID, userID, lines[1].ID, models.BankMatchPayment, byReference.ID)
+	assert.ErrorIs(t, err, services.ErrBankMatchInvalid, "already matched to another line")
+	matched, err := bank.ConfirmMatch(tenantID, userID, lines[1].ID, models.BankMatchPayment, eftB.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.BankLineMatched, matched.Status)
+	assert.Equal(t, userID, matched.MatchedBy)
+	_, err = bank.ConfirmMatch(tenantID, userID, lines[5].ID, models.BankMatchPayment, eftA.ID)
+	assert.ErrorIs(t, err, services.ErrBankMatchInvalid, "amounts differ")
+
+	_, err = bank.Ignore(tenantID, userID, lines[4].ID, "Bank charges")
+	require.NoError(t, err)
+
+	report, err := bank.Report(tenantID, day(1), day(31), "")
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.MatchedLines)
+	assert.Equal(t, 1, report.IgnoredLines)
+	require.Len(t, report.UnmatchedLines, 1)
+	assert.Equal(t, lines[5].ID, report.UnmatchedLines[0].ID)
+	assert.True(t, report.StatementCredits.Equals(models.ToCents(95500)))
+	assert.True(t, report.StatementDebits.Equals(models.ToCents(6350)))
+	var unmatchedIDs []string
+	for _, e := range report.UnmatchedEntries {
+		unmatchedIDs = append(unmatchedIDs, e.ID)
+	}
+	assert.ElementsMatch(t, []string{eftA.ID, notOnStatement.ID}, unmatchedIDs)
+	assert.True(t, report.UnmatchedBookTotal.Equals(models.ToCents(19500)))
+
+	// Unmatching puts the entry back in the report
+	_, err = bank.Unmatch(tenantID, lines[3].ID)
+	require.NoError(t, err)
+	report, err = bank.Report(tenantID, day(1), day(31), "0170291234567")
+	require.NoError(t, err)
+	assert.Len(t, report.UnmatchedLines, 2)
+	assert.Len(t, report.UnmatchedEntries, 3)
+}
@@ -0,0 +1,261 @@
+package services_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// Client Portal Tests
+// ============================================================
+
+type portalOutbox struct {
+	links []string
+	codes []string
+}
+
+func (o *portalOutbox) SendPortalLoginEmail(to, companyName, loginLink string, expiresIn time.Duration) error {
+	o.links = append(o.links, loginLink)
+	return nil
+}
+
+func (o *portalOutbox) Send(to, message string) error {
+	o.codes = append(o.codes, regexp.MustCompile(`\d{6}`).FindString(message))
+	return nil
+}
+
+type fakeSTKPusher struct {
+	amounts []string
+}
+
+func (f *fakeSTKPusher) InitiateSTKPush(ctx context.Context, tenantID, invoiceID, phoneNumber, amount, invoiceNumber string) (*services.STKPushResponse, error) {
+	f.amounts = append(f.amounts, amount)
+	return &services.STKPushResponse{
+		MerchantRequestID: "mr-" + uuid.New().String(),
+		CheckoutRequestID: "ws_CO_" + uuid.New().String(),
+		ResponseCode:      "0",
+	}, nil
+}
+
+func setupClientPortal(t *testing.T) (*database.DB, *services.ClientPortalService, *portalOutbox, *models.Client) {
+	if os.Getenv("ENCRYPTION_KEY") == "" {
+		os.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-testing-only-1234567890")
+	}
+	models.InitEncryption(os.Getenv("ENCRYPTION_KEY"))
+
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(
+		&models.Tenant{}, &models.Client{}, &models.Invoice{}, &models.InvoiceItem{},
+		&models.Payment{}, &models.PaymentAllocation{}, &models.MpesaSTKRequest{}, &models.ClientPortalSession{},
+		&models.InvoiceSequence{}, &models.ClientCreditTransaction{},
+	))
+
+	tenant := &models.Tenant{ID: uuid.New().String(), Name: "Acme Supplies", Subdomain: "acme", IsActive: true}
+	require.NoError(t, db.Create(tenant).Error)
+	client := &models.Client{
+		ID: uuid.New().String(), TenantID: tenant.ID, UserID: uuid.New().String(),
+		Name: "Jua Kali Ltd", Email: "Accounts@JuaKali.co.ke", Phone: "0712345678", Currency: "KES",
+	}
+	require.NoError(t, db.Create(client).Error)
+
+	outbox := &portalOutbox{}
+	svc := services.NewClientPortalService(db, services.NewReportService(db))
+	svc.SetEmailService(outbox)
+	svc.SetSMSService(outbox)
+	return db, svc, outbox, client
+}
+
+func portalInvoice(t *testing.T, db *database.DB, client *models.Client, number string, total float64, status models.InvoiceStatus) *models.Invoice {
+	invoice := &models.Invoice{
+		ID: uuid.New().String(), TenantID: client.TenantID, UserID: client.UserID, ClientID: client.ID,
+		InvoiceNumber: number, Currency: "KES", Total: models.ToCents(total), Status: status,
+		InvoiceType: "invoice", DueDate: time.Now().AddDate(0, 0, 14), MagicToken: uuid.New().String(),
+	}
+	if status == models.InvoiceStatusCreditNote {
+		invoice.InvoiceType = "credit_note"
+	}
+	require.NoError(t, db.Create(invoice).Error)
+	return invoice
+}
+
+// TestClientPortalLogin tests magic-link and SMS code login, and contact updates
+func TestClientPortalLogin(t *testing.T) {
+	_, svc, outbox, client := setupClientPortal(t)
+
+	// Unknown contacts get the same response but nothing is sent
+	result, err := svc.RequestLogin(&services.PortalLoginRequest{Tenant: "acme", Email: "someone@else.co.ke"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, models.PortalChannelEmail, result.Channel)
+	assert.Empty(t, outbox.links)
+
+	_, err = svc.RequestLogin(&services.PortalLoginRequest{Tenant: "acme", Email: "accounts@juakali.co.ke"}, "")
+	require.NoError(t, err)
+	require.Len(t, outbox.links, 1)
+	link, err := url.Parse(outbox.links[0])
+	require.NoError(t, err)
+
+	session, err := svc.Verify(&services.PortalVerifyRequest{Token: link.Query().Get("token")})
+	require.NoError(t, err)
+	assert.Equal(t, client.ID, session.Client.ID)
+	_, err = svc.Verify(&services.PortalVerifyRequest{Token: link.Query().Get("token")})
+	assert.ErrorIs(t, err, services.ErrPortalLoginInvalid, "magic links work once")
+
+	active, err := svc.Authenticate(session.Token)
+	require.NoError(t, err)
+	require.NoError(t, svc.Logout(active))
+	_, err = svc.Authenticate(session.Token)
+	assert.ErrorIs(t, err, services.ErrPortalSessionInvalid)
+
+	// Phone login matches the stored number in any format
+	challenge, err := svc.RequestLogin(&services.PortalLoginRequest{Tenant: client.TenantID, Phone: "+254 712 345 678"}, "")
+	require.NoError(t, err)
+	require.Len(t, outbox.codes, 1)
+	_, err = svc.Verify(&services.PortalVerifyRequest{ChallengeID: challenge.ChallengeID, Code: "000000"})
+	assert.ErrorIs(t, err, services.ErrPortalLoginInvalid)
+	session, err = svc.Verify(&services.PortalVerifyRequest{ChallengeID: challenge.ChallengeID, Code: outbox.codes[0]})
+	require.NoError(t, err)
+
+	active, err = svc.Authenticate(session.Token)
+	require.NoError(t, err)
+	newEmail := "billing@juakali.co.ke"
+	updated, err := svc.UpdateProfile(active, &services.PortalProfileRequest{Email: &newEmail})
+	require.NoError(t, err)
+	assert.Equal(t, newEmail, updated.Email)
+	assert.Equal(t, "0712345678", updated.Phone, "untouched fields are kept")
+
+	invalid := "not-an-email"
+	_, err = svc.UpdateProfile(active, &services.PortalProfileRequest{Email: &invalid})
+	assert.Error(t, err)
+}
+
+// TestClientPortalPaysSeveralInvoicesWithOneSTKPush tests a batch STK push is settled by one callback
+func TestClientPortalPaysSeveralInvoicesWithOneSTKPush(t *testing.T) {
+	db, svc, outbox, client := setupClientPortal(t)
+	pusher := &fakeSTKPusher{}
+	svc.SetSTKPusher(pusher)
+
+	first := portalInvoice(t, db, client, "INV-000001", 1000, models.InvoiceStatusSent)
+	second := portalInvoice(t, db, client, "INV-000002", 2500, models.InvoiceStatusPartiallyPaid)
+	require.NoError(t, db.Model(second).Update("paid_amount", models.ToCents(500)).Error)
+	portalInvoice(t, db, client, "INV-000003", 400, models.InvoiceStatusPaid)
+	draft := portalInvoice(t, db, client, "INV-000004", 900, models.InvoiceStatusDraft)
+	portalInvoice(t, db, client, "CN-000001", -200, models.InvoiceStatusCreditNote)
+
+	_, err := svc.RequestLogin(&services.PortalLoginRequest{Tenant: "acme", Phone: "0712345678"}, "")
+	require.NoError(t, err)
+	var pending models.ClientPortalSession
+	require.NoError(t, db.First(&pending).Error)
+	login, err := svc.Verify(&services.PortalVerifyRequest{ChallengeID: pending.ID, Code: outbox.codes[0]})
+	require.NoError(t, err)
+	session, err := svc.Authenticate(login.Token)
+	require.NoError(t, err)
+
+	invoices, total, err := svc.ListInvoices(session, "", 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total, "drafts and credit notes are not listed")
+	assert.Len(t, invoices, 3)
+	_, open, err := svc.ListInvoices(session, "open", 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), open)
+	notes, err := svc.ListCreditNotes(session)
+	require.NoError(t, err)
+	assert.Len(t, notes, 1)
+	_, err = svc.GetInvoice(session, draft.ID)
+	assert.ErrorIs(t, err, services.ErrPortalInvoiceNotFound)
+
+	_, err = svc.PayInvoices(context.Background(), session, []string{draft.ID}, "")
+	assert.Error(t, err, "draft invoices cannot be paid")
+
+	payment, err := svc.PayInvoices(context.Background(), session, []string{first.ID, second.ID}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3000"}, pusher.amounts, "one push for both balances")
+	assert.Equal(t, models.ToCents(3000), payment.Amount)
+	require.Len(t, payment.Invoices, 2)
+
+	_, err = svc.PayInvoices(context.Background(), session, []string{first.ID}, "")
+	assert.ErrorIs(t, err, services.ErrPortalPaymentInProgress)
+
+	var callback services.STKCallback
+	callback.Body.StkCallback.MerchantRequestID = "mr-unknown"
+	callback.Body.StkCallback.CheckoutRequestID = payment.CheckoutRequestID
+	callback.Body.StkCallback.CallbackMetadata.Item = []struct {
+		Name  string      `json:"Name"`
+		Value interface{} `json:"Value"`
+	}{
+		{Name: "MpesaReceiptNumber", Value: "QHX12ABC34"},
+		{Name: "Amount", Value: float64(3000)},
+	}
+	mpesa := services.NewMPesaService(&config.Config{}, db, nil)
+	require.NoError(t, mpesa.ProcessSTKCallback(context.Background(), "", callback))
+
+	for _, id := range []string{first.ID, second.ID} {
+		var invoice models.Invoice
+		require.NoError(t, db.First(&invoice, "id = ?", id).Error)
+		assert.Equal(t, models.InvoiceStatusPaid, invoice.Status, invoice.InvoiceNumber)
+		assert.Equal(t, invoice.Total, invoice.PaidAmount, invoice.InvoiceNumber)
+	}
+
+	status, err := svc.PaymentStatus(session, payment.CheckoutRequestID)
+	require.NoError(t, err)
+	assert.Equal(t, "completed", status.Status)
+
+	receipts, err := svc.ListReceipts(session)
+	require.NoError(t, err)
+	require.Len(t, receipts, 2)
+	assert.Equal(t, "QHX12ABC34", receipts[0].Reference)
+
+	var reloaded models.Client
+	require.NoError(t, db.First(&reloaded, "id = ?", client.ID).Error)
+	assert.Equal(t, models.ToCents(3000), reloaded.TotalPaid)
+
+	statement, err := svc.Statement(session, time.Now().AddDate(0, -1, 0), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.InDelta(t, 1000+2500+400-200-3000, statement.ClosingBal, 0.01, "drafts are not billed")
+
+	// Cents are rounded up to the shilling and what is paid over is kept as credit
+	cents := portalInvoice(t, db, client, "INV-000005", 1000.40, models.InvoiceStatusSent)
+	more := portalInvoice(t, db, client, "INV-000006", 200.30, models.InvoiceStatusSent)
+	payment, err = svc.PayInvoices(context.Background(), session, []string{cents.ID, more.ID}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "1201", pusher.amounts[len(pusher.amounts)-1])
+	assert.Equal(t, models.ToCents(1200.70), payment.Amount)
+	assert.Equal(t, models.ToCents(1201), payment.Charged)
+
+	callback.Body.StkCallback.MerchantRequestID = "mr-cents"
+	callback.Body.StkCallback.CheckoutRequestID = payment.CheckoutRequestID
+	callback.Body.StkCallback.CallbackMetadata.Item[0].Value = "QHX12ABC35"
+	callback.Body.StkCallback.CallbackMetadata.Item[1].Value = float64(1201)
+	require.NoError(t, mpesa.ProcessSTKCallback(context.Background(), "", callback))
+
+	for _, invoice := range []*models.Invoice{cents, more} {
+		total := invoice.Total
+		require.NoError(t, db.First(invoice, "id = ?", invoice.ID).Error)
+		assert.Equal(t, models.InvoiceStatusPaid, invoice.Status, invoice.InvoiceNumber)
+		assert.Equal(t, total, invoice.PaidAmount, invoice.InvoiceNumber)
+	}
+	require.NoError(t, db.First(&reloaded, "id = ?", client.ID).Error)
+	assert.Equal(t, models.ToCents(0.30), reloaded.CreditBalance)
+	var credit models.ClientCreditTransaction
+	require.NoError(t, db.First(&credit, "client_id = ?", client.ID).Error)
+	assert.Equal(t, models.ClientCreditOverpayment, credit.Type)
+	assert.Equal(t, models.ToCents(0.30), credit.Amount)
+}
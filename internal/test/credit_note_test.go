@@ -0,0 +1,138 @@
+package services_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registeredInvoice creates a sent invoice of 1500 + 16% VAT that KRA has registered
+func registeredInvoice(t *testing.T, db *database.DB, invoices *services.InvoiceService, tenantID, userID, clientID string) *models.Invoice {
+	invoice, err := invoices.CreateInvoice(tenantID, userID, clientID, &services.CreateInvoiceRequest{
+		ClientID: clientID,
+		Items: []services.InvoiceItemRequest{
+			{Description: "Consulting", Quantity: 10, UnitPrice: 100, TaxRate: 16},
+			{Description: "Travel", Quantity: 1, UnitPrice: 500, TaxRate: 16},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", invoice.ID).Updates(map[string]interface{}{
+		"status":     models.InvoiceStatusSent,
+		"kra_icn":    "ICN-" + invoice.InvoiceNumber,
+		"kra_status": models.KRAInvoiceStatusSubmitted,
+	}).Error)
+	invoice, err = invoices.GetInvoiceByID(tenantID, invoice.ID)
+	require.NoError(t, err)
+	return invoice
+}
+
+// TestCreditNotesReverseRegisteredInvoices tests credit notes are submitted to eTIMS against the
+// original ICN, settle the invoice, and are the only way to cancel an invoice KRA has registered
+func TestCreditNotesReverseRegisteredInvoices(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	userID := uuid.New().String()
+	require.NoError(t, db.Create(&models.User{ID: userID, TenantID: tenantID, Email: "owner@test.com", Name: "Owner", KRAPIN: "P051111111A"}).Error)
+	clientID := createTestClient(t, db, tenantID)
+
+	payloads := make(chan map[string]interface{}, 10)
+	etims := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"resultCode": "00", "resultDesc": "Success", "icn": "ICN-" + payload["invoiceNumber"].(string)})
+	}))
+	t.Cleanup(etims.Close)
+
+	cfg := &config.Config{KRA: config.KRAConfig{APIURL: etims.URL, APIKey: "test-key", PrivateKey: "test-key"}}
+	invoices := services.NewInvoiceServiceWithDeps(db, &services.ServiceDependencies{DB: db, KRA: services.NewKRAServiceWithDB(cfg, db)})
+	invoice := registeredInvoice(t, db, invoices, tenantID, userID, clientID)
+	require.True(t, invoice.Total.Equals(models.ToCents(1740)))
+
+	assert.ErrorIs(t, invoices.DeleteInvoice(tenantID, invoice.ID), services.ErrInvoiceKRARegistered)
+
+	// A partial credit takes its share of the VAT and is registered against the original ICN
+	partial, err := invoices.CreateCreditNote(tenantID, userID, invoice.ID, []services.CreateCreditNoteItem{
+		{Description: "Consulting not delivered", Quantity: 2, UnitPrice: 100},
+	}, "Two hours not delivered")
+	require.NoError(t, err)
+	assert.True(t, partial.Total.Equals(models.ToCents(-232)))
+	assert.True(t, partial.TotalTax.Equals(models.ToCents(32)))
+	assert.Equal(t, invoice.KRAICN, partial.OriginalICN)
+	assert.Equal(t, "ICN-"+partial.InvoiceNumber, partial.KRAICN)
+	assert.Equal(t, models.KRAInvoiceStatusSubmitted, partial.KRAStatus)
+	payload := <-payloads
+	assert.Equal(t, "credit_note", payload["invoiceType"])
+	assert.Equal(t, invoice.KRAICN, payload["originalICN"])
+	assert.InDelta(t, 232, payload["totalIncludingVAT"], 0.001)
+
+	invoice, err = invoices.GetInvoiceByID(tenantID, invoice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.InvoiceStatusPartiallyPaid, invoice.Status)
+	assert.True(t, invoice.BalanceDue.Equals(models.ToCents(1508)))
+	assert.True(t, invoice.CreditedAmount.Equals(models.ToCents(232)))
+
+	_, err = invoices.CreateCreditNote(tenantID, userID, invoice.ID, []services.CreateCreditNoteItem{
+		{Description: "Everything twice", Quantity: 20, UnitPrice: 100},
+	}, "")
+	assert.ErrorIs(t, err, services.ErrCreditNoteExceedsInvoice)
+	_, err = invoices.CreateCreditNote(tenantID, userID, invoice.ID, []services.CreateCreditNoteItem{{Description: "Nothing", Quantity: 0, UnitPrice: 100}}, "")
+	assert.ErrorIs(t, err, services.ErrCreditNoteAmount)
+
+	// Cancelling credits what is left
+	require.NoError(t, invoices.CancelInvoice(tenantID, invoice.ID, userID))
+	invoice, err = invoices.GetInvoiceByID(tenantID, invoice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.InvoiceStatusCancelled, invoice.Status)
+	assert.Equal(t, models.KRAInvoiceStatusCredited, invoice.KRAStatus)
+	assert.True(t, invoice.BalanceDue.IsZero())
+	assert.True(t, invoice.CreditedAmount.Equals(invoice.Total))
+
+	var notes []models.Invoice
+	require.NoError(t, db.Preload("Items").Where("original_invoice_id = ?", invoice.ID).Order("created_at").Find(&notes).Error)
+	require.Len(t, notes, 2)
+	assert.True(t, notes[1].Total.Equals(models.ToCents(-1508)))
+	assert.True(t, notes[1].TotalTax.Equals(models.ToCents(208)))
+	assert.Len(t, notes[1].Items, 1)
+	assert.NotEmpty(t, notes[1].KRAICN)
+
+	_, err = invoices.CreateCreditNote(tenantID, userID, invoice.ID, nil, "")
+	assert.ErrorIs(t, err, services.ErrCreditNoteOriginal)
+
+	// Crediting a paid invoice in full copies its lines and owes the client the payment back
+	paid := registeredInvoice(t, db, invoices, tenantID, userID, clientID)
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", paid.ID).Updates(map[string]interface{}{
+		"status": models.InvoiceStatusPaid, "paid_amount": paid.Total, "balance_due": 0,
+	}).Error)
+	full, err := invoices.CreateCreditNote(tenantID, userID, paid.ID, nil, "")
+	require.NoError(t, err)
+	assert.True(t, full.Total.Equals(-paid.Total))
+	assert.Len(t, full.Items, 2)
+	paid, err = invoices.GetInvoiceByID(tenantID, paid.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.InvoiceStatusPaid, paid.Status)
+	assert.Equal(t, models.KRAInvoiceStatusCredited, paid.KRAStatus)
+	var client models.Client
+	require.NoError(t, db.First(&client, "id = ?", clientID).Error)
+	assert.True(t, client.CreditBalance.Equals(models.ToCents(1740)))
+
+	// Invoices KRA hasn't registered are cancelled directly and can't be credited
+	unregistered, err := invoices.CreateInvoice(tenantID, userID, clientID, &services.CreateInvoiceRequest{
+		ClientID: clientID,
+		Items:    []services.InvoiceItemRequest{{Description: "Draft work", Quantity: 1, UnitPrice: 100}},
+	})
+	require.NoError(t, err)
+	_, err = invoices.CreateCreditNote(tenantID, userID, unregistered.ID, nil, "")
+	assert.ErrorIs(t, err, services.ErrCreditNoteOriginal)
+	require.NoError(t, invoices.CancelInvoice(tenantID, unregistered.ID, userID))
+}
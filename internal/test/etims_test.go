@@ -0,0 +1,224 @@
+package services_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// eTIMS Tests (against the dev simulator)
+// ============================================================
+
+func initETIMSDevice(t *testing.T, etims *services.ETIMSService, tenantID string) *models.ETIMSDevice {
+	device, err := etims.InitializeDevice(context.Background(), tenantID, &services.ETIMSDeviceRequest{
+		TIN:          "p051111111a",
+		DeviceSerial: "DEV-0001",
+	})
+	require.NoError(t, err)
+	return device
+}
+
+// TestETIMSItemRegistrationAndStock tests a library item is registered under a KRA item code that
+// invoices report with its classification, and stock is reported with what's left
+func TestETIMSItemRegistrationAndStock(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	ctx := context.Background()
+	userID := uuid.New().String()
+	etims := services.NewETIMSService(&config.Config{}, db)
+
+	item, err := services.NewItemLibraryService(db).CreateItem(tenantID, userID, &services.CreateItemRequest{Name: "Bottled water", UnitPrice: 50})
+	require.NoError(t, err)
+
+	// Nothing works before the device is initialized
+	_, err = etims.SyncCodes(ctx, tenantID)
+	assert.ErrorIs(t, err, services.ErrETIMSNotInitialized)
+
+	device := initETIMSDevice(t, etims, tenantID)
+	assert.Equal(t, "P051111111A", device.TIN)
+	assert.Equal(t, "00", device.BranchID)
+	assert.NotEmpty(t, device.CommunicationKey)
+	assert.NotEmpty(t, device.SDCID)
+
+	synced, err := etims.SyncCodes(ctx, tenantID)
+	require.NoError(t, err)
+	assert.Greater(t, synced, 0)
+	synced, err = etims.SyncCodes(ctx, tenantID)
+	require.NoError(t, err)
+	assert.Zero(t, synced, "the second sync only asks for changes")
+	units, err := etims.ListCodes(tenantID, models.ETIMSCodeQuantityUnit)
+	require.NoError(t, err)
+	assert.NotEmpty(t, units)
+
+	_, err = etims.RegisterItem(ctx, tenantID, userID, item.ID, &services.ETIMSItemRequest{ItemClassCode: "99999999", ItemType: "2"})
+	assert.ErrorIs(t, err, services.ErrETIMSCodeUnknown)
+	_, err = etims.RegisterItem(ctx, tenantID, userID, item.ID, &services.ETIMSItemRequest{ItemClassCode: "50202300", ItemType: "7"})
+	assert.ErrorIs(t, err, services.ErrETIMSItemInvalid)
+
+	registered, err := etims.RegisterItem(ctx, tenantID, userID, item.ID, &services.ETIMSItemRequest{
+		ItemClassCode: "50202300", ItemType: models.ETIMSItemFinished, PackagingUnit: "bx",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "KE2BXU0000001", registered.ItemCode)
+	assert.Regexp(t, regexp.MustCompile(`^[A-Z]{2}[123][A-Z]{2}[A-Z]+\d{7}$`), registered.ItemCode)
+	assert.Equal(t, models.KRATaxCategoryStandard, registered.TaxCategory, "taken from the classification code")
+	assert.NotNil(t, registered.ETIMSRegisteredAt)
+
+	// Invoice lines carrying the item code are reported with its classification
+	clientID := createTestClient(t, db, tenantID)
+	invoice, err := services.NewInvoiceService(db).CreateInvoice(tenantID, userID, clientID, &services.CreateInvoiceRequest{
+		ClientID: clientID,
+		Items: []services.InvoiceItemRequest{
+			{Description: "Bottled water", Quantity: 10, UnitPrice: 50, ItemCode: registered.ItemCode},
+			{Description: "Delivery", Quantity: 1, UnitPrice: 200},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, invoice.Items, 2)
+	assert.Equal(t, registered.ItemCode, invoice.Items[0].ItemCode)
+	data := services.NewKRAServiceWithDB(&config.Config{}, db).ConvertInvoiceToKRA(invoice, &models.User{}, &invoice.Client)
+	assert.Equal(t, registered.ItemCode, data.Items[0].ItemCode)
+	assert.Equal(t, "50202300", data.Items[0].ItemClassificationCode)
+	assert.Equal(t, "ITEM002", data.Items[1].ItemCode)
+
+	// Stock in, then out, reported with the quantity left
+	in, err := etims.RecordStockMovement(ctx, tenantID, userID, &services.ETIMSStockRequest{ItemID: item.ID, Type: models.ETIMSStockPurchase, Quantity: 24})
+	require.NoError(t, err)
+	assert.Equal(t, models.ETIMSStockReported, in.Status)
+	assert.Equal(t, 24.0, in.Remaining)
+	out, err := etims.RecordStockMovement(ctx, tenantID, userID, &services.ETIMSStockRequest{ItemID: item.ID, Type: models.ETIMSStockSale, Quantity: 10, Reference: invoice.InvoiceNumber})
+	require.NoError(t, err)
+	assert.Equal(t, 14.0, out.Remaining)
+	assert.Equal(t, in.SarNo+1, out.SarNo)
+
+	_, err = etims.RecordStockMovement(ctx, tenantID, userID, &services.ETIMSStockRequest{ItemID: item.ID, Type: models.ETIMSStockDiscarded, Quantity: 15})
+	assert.ErrorIs(t, err, services.ErrETIMSInsufficientStock)
+	_, err = etims.RecordStockMovement(ctx, tenantID, userID, &services.ETIMSStockRequest{ItemID: item.ID, Type: "99", Quantity: 1})
+	assert.ErrorIs(t, err, services.ErrETIMSStockInvalid)
+
+	other, err := services.NewItemLibraryService(db).CreateItem(tenantID, userID, &services.CreateItemRequest{Name: "Unregistered", UnitPrice: 10})
+	require.NoError(t, err)
+	_, err = etims.RecordStockMovement(ctx, tenantID, userID, &services.ETIMSStockRequest{ItemID: other.ID, Type: models.ETIMSStockPurchase, Quantity: 1})
+	assert.ErrorIs(t, err, services.ErrETIMSItemNotRegistered)
+
+	reported, err := etims.ReportPendingStock(ctx, tenantID)
+	require.NoError(t, err)
+	assert.Zero(t, reported)
+}
+
+// TestETIMSPurchaseImport tests purchases issued to the tenant's PIN are imported once and confirmed once
+func TestETIMSPurchaseImport(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	ctx := context.Background()
+	userID := uuid.New().String()
+	etims := services.NewETIMSService(&config.Config{}, db)
+	initETIMSDevice(t, etims, tenantID)
+
+	imported, err := etims.ImportPurchases(ctx, tenantID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, imported)
+	imported, err = etims.ImportPurchases(ctx, tenantID)
+	require.NoError(t, err)
+	assert.Zero(t, imported)
+
+	purchases, err := etims.ListPurchases(tenantID, models.ETIMSPurchasePending)
+	require.NoError(t, err)
+	require.Len(t, purchases, 2)
+	for _, p := range purchases {
+		assert.True(t, p.TotalAmount.Equals(p.TaxableAmount.Add(p.TaxAmount)))
+		assert.NotEmpty(t, p.ICN)
+	}
+
+	accepted, err := etims.ConfirmPurchase(ctx, tenantID, userID, purchases[0].ID, true)
+	require.NoError(t, err)
+	assert.Equal(t, models.ETIMSPurchaseAccepted, accepted.Status)
+	_, err = etims.ConfirmPurchase(ctx, tenantID, userID, purchases[0].ID, false)
+	assert.ErrorIs(t, err, services.ErrETIMSPurchaseConfirmed)
+	rejected, err := etims.ConfirmPurchase(ctx, tenantID, userID, purchases[1].ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, models.ETIMSPurchaseRejected, rejected.Status)
+
+	pending, err := etims.ListPurchases(tenantID, models.ETIMSPurchasePending)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Production without an eTIMS endpoint doesn't fall back to the simulator
+	production := &config.Config{}
+	production.Server.Mode = "production"
+	_, err = services.NewETIMSService(production, db).ImportPurchases(ctx, tenantID)
+	assert.ErrorIs(t, err, services.ErrMockMode)
+}
+
+// TestExpenseInputVATVerification tests only input VAT on supplier invoices verified on eTIMS is claimed
+func TestExpenseInputVATVerification(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	ctx := context.Background()
+	userID := uuid.New().String()
+	etims := services.NewETIMSService(&config.Config{}, db)
+	initETIMSDevice(t, etims, tenantID)
+	_, err := etims.ImportPurchases(ctx, tenantID)
+	require.NoError(t, err)
+
+	expenses := services.NewExpenseService(db)
+	expenses.SetETIMSService(etims)
+	expense := func(pin, icn string, tax float64) *models.Expense {
+		e, err := expenses.CreateExpense(ctx, tenantID, userID, &services.CreateExpenseRequest{
+			Title: "Supplies", Amount: tax * 10, TaxAmount: tax, TaxRate: 16, SupplierPIN: pin, SupplierICN: icn,
+		})
+		require.NoError(t, err)
+		return e
+	}
+
+	imported := expense("p051234567x", "SIMULATED1001", 1600)
+	assert.Equal(t, models.ExpenseETIMSVerified, imported.ETIMSStatus, "matches a purchase imported from eTIMS")
+	assert.NotNil(t, imported.ETIMSVerifiedAt)
+	overclaimed := expense("P052345678Y", "SIMULATED778", 2000)
+	assert.Equal(t, models.ExpenseETIMSRejected, overclaimed.ETIMSStatus, "the supplier only charged 800")
+	assert.NotEmpty(t, overclaimed.ETIMSNote)
+	looked := expense("P000111222Z", "KRACU0100000001/152", 500)
+	assert.Equal(t, models.ExpenseETIMSVerified, looked.ETIMSStatus, "found by the eTIMS lookup")
+	unknown := expense("A123456789B", "BAD", 100)
+	assert.Equal(t, models.ExpenseETIMSRejected, unknown.ETIMSStatus)
+	_, err = etims.VerifySupplierInvoice(ctx, tenantID, "P000111222Z", "KRACU0100000009/001")
+	assert.ErrorIs(t, err, services.ErrETIMSInvoiceOtherBuyer, "issued to someone else's PIN")
+	missing := expense("", "", 300)
+	assert.Equal(t, models.ExpenseETIMSUnverified, missing.ETIMSStatus)
+
+	_, err = expenses.CreateExpense(ctx, tenantID, userID, &services.CreateExpenseRequest{Title: "Bad PIN", Amount: 10, SupplierPIN: "12345"})
+	assert.ErrorIs(t, err, services.ErrExpenseSupplierPIN)
+
+	reports := services.NewReportService(db)
+	vat, err := reports.GetVATReport(tenantID, "30")
+	require.NoError(t, err)
+	assert.InDelta(t, 2100, vat.InputTax, 0.001)
+	assert.InDelta(t, 2400, vat.ExcludedInputTax, 0.001)
+	assert.InDelta(t, -2100, vat.NetVAT, 0.001)
+	require.Len(t, vat.ExcludedExpenses, 3)
+	for _, e := range vat.ExcludedExpenses {
+		assert.NotEmpty(t, e.Reason)
+	}
+	require.NotNil(t, vat.VATReturn)
+	assert.InDelta(t, 2100, vat.VATReturn.Box2, 0.001)
+	assert.Equal(t, 2, vat.VATReturn.ExpenseCount)
+	assert.Equal(t, 3, vat.VATReturn.ExcludedExpenseCount)
+
+	// Correcting the VAT to what the supplier charged verifies it
+	corrected := 800.0
+	updated, err := expenses.UpdateExpense(ctx, tenantID, overclaimed.ID, &services.UpdateExpenseRequest{TaxAmount: &corrected})
+	require.NoError(t, err)
+	assert.Equal(t, models.ExpenseETIMSVerified, updated.ETIMSStatus)
+	assert.Empty(t, updated.ETIMSNote)
+
+	vat, err = reports.GetVATReport(tenantID, "30")
+	require.NoError(t, err)
+	assert.InDelta(t, 2900, vat.InputTax, 0.001)
+	assert.Len(t, vat.ExcludedExpenses, 2)
+}
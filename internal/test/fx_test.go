@@ -0,0 +1,139 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// Foreign Exchange Tests
+// ============================================================
+
+// usdInvoice creates a sent USD invoice booked at kesPerUnit
+func usdInvoice(t *testing.T, db *database.DB, tenantID, number string, total, kesPerUnit float64, sentAt time.Time) *models.Invoice {
+	invoice := ledgerInvoice(t, db, tenantID, number, "invoice", total, 0, total, sentAt)
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", invoice.ID).Updates(map[string]interface{}{
+		"currency": "USD", "exchange_rate": kesPerUnit,
+	}).Error)
+	invoice.Currency, invoice.ExchangeRate = "USD", kesPerUnit
+	return invoice
+}
+
+// TestExchangeRateOverrides tests dated rates and tenant overrides
+func TestExchangeRateOverrides(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	rates := services.NewExchangeRateService(db)
+	userID := uuid.New().String()
+	day := time.Date(2026, 3, 31, 15, 0, 0, 0, time.UTC)
+
+	_, err := rates.SetOverride(tenantID, userID, "KES", day, 1)
+	assert.ErrorIs(t, err, services.ErrExchangeRateInvalid)
+	_, err = rates.SetOverride(tenantID, userID, "USD", day, 0)
+	assert.ErrorIs(t, err, services.ErrExchangeRateInvalid)
+
+	override, err := rates.SetOverride(tenantID, userID, "usd", day, 130)
+	require.NoError(t, err)
+	assert.Equal(t, models.ExchangeRateSourceManual, override.Source)
+	_, err = rates.SetOverride(tenantID, userID, "USD", day, 129.5)
+	require.NoError(t, err, "setting the same day again replaces the override")
+
+	rate, err := rates.RateOn(tenantID, "USD", day)
+	require.NoError(t, err)
+	assert.InDelta(t, 129.5, rate, 1e-9)
+	rate, err = rates.RateOn(uuid.New().String(), "USD", day)
+	require.NoError(t, err)
+	assert.NotEqual(t, 129.5, rate, "overrides are per tenant")
+
+	list, err := rates.ListRates(tenantID, "USD", day, day)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.InDelta(t, 129.5, list[0].KESPerUnit, 1e-9)
+
+	assert.NoError(t, rates.DeleteOverride(tenantID, list[0].ID))
+	assert.ErrorIs(t, rates.DeleteOverride(tenantID, list[0].ID), services.ErrExchangeRateNotFound)
+}
+
+// TestFXRealizedAndRevaluation tests realized gain on payments and the month-end revaluation and its reversal
+func TestFXRealizedAndRevaluation(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	rates := services.NewExchangeRateService(db)
+	ledger := services.NewLedgerService(db)
+	ledger.SetExchangeRateService(rates)
+	userID := uuid.New().String()
+	// Last month, which has ended and can be revalued
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 9, 0, 0, 0, time.UTC)
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	day := func(d int) time.Time { return lastMonth.AddDate(0, 0, d-1) }
+	monthEnd := thisMonth.AddDate(0, 0, -1)
+
+	// Booked at 128, paid at 131: 3,000 KES realized gain
+	paidInvoice := usdInvoice(t, db, tenantID, "INV-FX-001", 1000, 128, day(1))
+	completed := day(10)
+	payment := &models.Payment{
+		ID: uuid.New().String(), TenantID: tenantID, InvoiceID: paidInvoice.ID, Amount: models.ToCents(1000), Currency: "USD",
+		Method: models.PaymentMethodBank, Status: models.PaymentStatusCompleted, ExchangeRate: 131, CompletedAt: &completed,
+	}
+	require.NoError(t, db.Create(payment).Error)
+
+	// 500 USD still open at month end, when the closing rate is 130
+	usdInvoice(t, db, tenantID, "INV-FX-002", 500, 128, day(5))
+	_, err := rates.SetOverride(tenantID, userID, "USD", monthEnd, 130)
+	require.NoError(t, err)
+
+	_, err = ledger.RevalueForeignBalances(tenantID, userID, services.PeriodKey(time.Now()))
+	assert.ErrorIs(t, err, services.ErrPeriodNotEnded)
+
+	run, err := ledger.RevalueForeignBalances(tenantID, userID, services.PeriodKey(lastMonth))
+	require.NoError(t, err)
+	require.Len(t, run.Lines, 1, "the paid invoice has nothing left to revalue")
+	assert.Equal(t, "INV-FX-002", run.Lines[0].InvoiceNumber)
+	assert.True(t, run.Lines[0].BookValue.Equals(models.ToCents(64000)))
+	assert.True(t, run.Lines[0].ClosingValue.Equals(models.ToCents(65000)))
+	assert.True(t, run.Gain.Equals(models.ToCents(1000)))
+	_, err = ledger.RevalueForeignBalances(tenantID, userID, services.PeriodKey(lastMonth))
+	assert.ErrorIs(t, err, services.ErrRevaluationExists)
+
+	// The gain is posted with the payment's journal entry; the payment is left as it was
+	var stored models.Payment
+	require.NoError(t, db.First(&stored, "id = ?", payment.ID).Error)
+	assert.InDelta(t, 131, stored.ExchangeRate, 1e-9)
+	assert.Equal(t, payment.UpdatedAt.Unix(), stored.UpdatedAt.Unix())
+
+	closing, err := ledger.TrialBalance(tenantID, monthEnd)
+	require.NoError(t, err)
+	assert.True(t, closing.Balanced)
+	assert.True(t, accountBalance(closing, "1010").Equals(models.ToCents(131000)), "cash at the rate it came in")
+	assert.True(t, accountBalance(closing, "1100").Equals(models.ToCents(65000)), "receivable at the closing rate")
+	assert.True(t, accountBalance(closing, "4200").Equals(models.ToCents(3000)))
+	assert.True(t, accountBalance(closing, "4210").Equals(models.ToCents(1000)))
+
+	// The revaluation reverses on the 1st, leaving the receivable at the booked rate
+	reopening, err := ledger.TrialBalance(tenantID, thisMonth)
+	require.NoError(t, err)
+	assert.True(t, accountBalance(reopening, "1100").Equals(models.ToCents(64000)))
+	assert.Zero(t, accountBalance(reopening, "4210"))
+
+	fetched, err := ledger.GetRevaluation(tenantID, run.ID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, fetched.ReversalID)
+	assert.Len(t, fetched.Lines, 1)
+	_, err = ledger.GetRevaluation(uuid.New().String(), run.ID)
+	assert.ErrorIs(t, err, services.ErrRevaluationNotFound)
+
+	// Revenue at the invoice rate plus the realized gain; the unrealized one has reversed
+	stmt, err := services.NewReportService(db).GetIncomeStatement(tenantID, "90")
+	require.NoError(t, err)
+	assert.InDelta(t, 128000, stmt.Revenue, 0.001)
+	assert.InDelta(t, 3000, stmt.RealizedFXGain, 0.001)
+	assert.InDelta(t, 0, stmt.UnrealizedFXGain, 0.001)
+	assert.InDelta(t, 131000, stmt.NetProfit, 0.001)
+}
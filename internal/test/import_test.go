@@ -0,0 +1,193 @@
+package services_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// Bulk Import Tests
+// ============================================================
+
+func setupImports(t *testing.T) (*database.DB, *services.ImportService, string, string) {
+	if os.Getenv("ENCRYPTION_KEY") == "" {
+		os.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-testing-only-1234567890")
+	}
+	models.InitEncryption(os.Getenv("ENCRYPTION_KEY"))
+
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(
+		&models.Tenant{}, &models.Client{}, &models.ItemLibrary{}, &models.Invoice{},
+		&models.InvoiceItem{}, &models.Payment{}, &models.InvoiceSequence{}, &models.ImportJob{},
+	))
+
+	return db, services.NewImportService(db), uuid.New().String(), uuid.New().String()
+}
+
+// TestImportClientsDryRunReportsRowErrors tests validation errors are reported per row and nothing is written
+func TestImportClientsDryRunReportsRowErrors(t *testing.T) {
+	db, svc, tenantID, userID := setupImports(t)
+
+	csv := "Client Name,Email,Phone,KRA PIN,Currency\n" +
+		"Acme Ltd,billing@acme.co.ke,0712345678,A123456789B,KES\n" +
+		"Bad Pin Ltd,,0712345678,12345,KES\n" +
+		"Bad Phone Ltd,,12ab,,KES\n" +
+		"Bad Currency Ltd,,,,XYZ\n"
+
+	preview, err := svc.Upload(tenantID, userID, models.ImportEntityClients, "clients.csv", []byte(csv))
+	require.NoError(t, err)
+	assert.Equal(t, 4, preview.Job.TotalRows)
+	assert.Equal(t, "Client Name", preview.Mapping["name"])
+	assert.Equal(t, "KRA PIN", preview.Mapping["kra_pin"])
+
+	result, err := svc.Validate(tenantID, userID, preview.Job.ID, nil)
+	require.NoError(t, err)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 1, result.Job.ValidRows)
+	assert.Equal(t, 3, result.Job.ErrorRows)
+
+	fields := map[int]string{}
+	for _, e := range result.Errors {
+		fields[e.Row] = e.Field
+	}
+	assert.Equal(t, map[int]string{3: "kra_pin", 4: "phone", 5: "currency"}, fields)
+
+	var count int64
+	db.Model(&models.Client{}).Count(&count)
+	assert.Zero(t, count)
+
+	_, err = svc.Commit(tenantID, userID, preview.Job.ID, nil)
+	assert.Error(t, err, "commit must refuse rows with errors unless skip_invalid is set")
+	db.Model(&models.Client{}).Count(&count)
+	assert.Zero(t, count)
+}
+
+// TestImportClientsCommitDedupes tests existing and repeated emails / PINs are skipped on commit
+func TestImportClientsCommitDedupes(t *testing.T) {
+	db, svc, tenantID, userID := setupImports(t)
+
+	require.NoError(t, db.Create(&models.Client{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: userID,
+		Name: "Existing", Email: "existing@example.com", Currency: "KES",
+	}).Error)
+
+	csv := "name,email,kra_pin\n" +
+		"Existing Again,EXISTING@example.com,\n" +
+		"New One,new@example.com,P051234567Q\n" +
+		"Same Pin,other@example.com,p051234567q\n" +
+		"New Two,two@example.com,\n"
+
+	preview, err := svc.Upload(tenantID, userID, models.ImportEntityClients, "clients.csv", []byte(csv))
+	require.NoError(t, err)
+
+	result, err := svc.Commit(tenantID, userID, preview.Job.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.ImportStatusCommitted, result.Job.Status)
+	assert.Equal(t, 2, result.Job.CreatedCount)
+	assert.Equal(t, 2, result.Job.SkippedCount)
+	assert.Len(t, result.Duplicates, 2)
+
+	var clients []models.Client
+	require.NoError(t, db.Where("tenant_id = ?", tenantID).Find(&clients).Error)
+	assert.Len(t, clients, 3)
+
+	_, err = svc.Commit(tenantID, userID, preview.Job.ID, nil)
+	assert.Error(t, err, "an import can only be committed once")
+}
+
+// TestImportHistoricalPaidInvoices tests paid invoices keep their numbers and get a completed payment
+func TestImportHistoricalPaidInvoices(t *testing.T) {
+	db, svc, tenantID, userID := setupImports(t)
+
+	csv := "Invoice No,Customer,Date,VAT,Total,Paid,Payment Date\n" +
+		"INV-000042,Old Client Ltd,15/01/2024,160,\"1,160.00\",\"1,160.00\",2024-02-01\n" +
+		"INV-000043,Old Client Ltd,2024-03-01,0,500,200,\n"
+
+	preview, err := svc.Upload(tenantID, userID, models.ImportEntityInvoices, "history.csv", []byte(csv))
+	require.NoError(t, err)
+
+	result, err := svc.Commit(tenantID, userID, preview.Job.ID, nil)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, 2, result.Job.CreatedCount)
+
+	var inv models.Invoice
+	require.NoError(t, db.Where("invoice_number = ?", "INV-000042").First(&inv).Error)
+	assert.Equal(t, models.InvoiceStatusPaid, inv.Status)
+	assert.Equal(t, models.ToCents(1160), inv.Total)
+	assert.Equal(t, models.ToCents(160), inv.TotalTax)
+	assert.Empty(t, inv.KRAStatus, "historical invoices are not submitted to KRA")
+	require.NotNil(t, inv.PaidAt)
+	assert.Equal(t, "2024-02-01", inv.PaidAt.Format("2006-01-02"))
+
+	var partial models.Invoice
+	require.NoError(t, db.Where("invoice_number = ?", "INV-000043").First(&partial).Error)
+	assert.Equal(t, models.InvoiceStatusPartiallyPaid, partial.Status)
+	assert.Equal(t, models.ToCents(300), partial.BalanceDue)
+	assert.Equal(t, inv.ClientID, partial.ClientID, "both rows resolve to the same new client")
+
+	var payments int64
+	db.Model(&models.Payment{}).Where("tenant_id = ? AND status = ?", tenantID, models.PaymentStatusCompleted).Count(&payments)
+	assert.Equal(t, int64(2), payments)
+
+	var seq models.InvoiceSequence
+	require.NoError(t, db.Where("tenant_id = ? AND document_type = ?", tenantID, models.SequenceDocumentInvoice).First(&seq).Error)
+	assert.Equal(t, int64(43), seq.LastSequenceNum)
+}
+
+// TestImportForeignCurrencyInvoices tests foreign-currency rows are booked at
+// their own rate or the one published for the issue date, and refused without
+func TestImportForeignCurrencyInvoices(t *testing.T) {
+	db, svc, tenantID, userID := setupImports(t)
+	require.NoError(t, db.AutoMigrate(&models.ExchangeRate{}))
+	rates := services.NewExchangeRateService(db)
+	svc.SetExchangeRateService(rates)
+	issued := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	_, err := rates.SetOverride(tenantID, userID, "USD", issued, 150)
+	require.NoError(t, err)
+
+	csv := "Invoice No,Customer,Date,Currency,FX Rate,Total,Paid\n" +
+		"INV-000100,Export Client,2024-03-01,USD,,100,100\n" +
+		"INV-000101,Export Client,2024-03-01,USD,145.5,200,0\n" +
+		"INV-000102,Export Client,2024-03-01,EUR,,300,0\n"
+	preview, err := svc.Upload(tenantID, userID, models.ImportEntityInvoices, "fx.csv", []byte(csv))
+	require.NoError(t, err)
+	assert.Equal(t, "FX Rate", preview.Mapping["exchange_rate"])
+
+	result, err := svc.Validate(tenantID, userID, preview.Job.ID, nil)
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, 4, result.Errors[0].Row)
+	assert.Equal(t, "exchange_rate", result.Errors[0].Field)
+
+	csv = strings.Join(strings.Split(csv, "\n")[:3], "\n") + "\n"
+	preview, err = svc.Upload(tenantID, userID, models.ImportEntityInvoices, "fx.csv", []byte(csv))
+	require.NoError(t, err)
+	result, err = svc.Commit(tenantID, userID, preview.Job.ID, nil)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+
+	var looked, given models.Invoice
+	require.NoError(t, db.Where("invoice_number = ?", "INV-000100").First(&looked).Error)
+	assert.Equal(t, 150.0, looked.ExchangeRate)
+	assert.Equal(t, models.ToCents(15000), looked.KESEquivalent)
+	require.NoError(t, db.Where("invoice_number = ?", "INV-000101").First(&given).Error)
+	assert.Equal(t, 145.5, given.ExchangeRate)
+	assert.Equal(t, models.ToCents(29100), given.KESEquivalent)
+}
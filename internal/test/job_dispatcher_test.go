@@ -0,0 +1,200 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+	"invoicefast/internal/worker"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// Job Dispatcher Tests
+// ============================================================
+
+func setupJobQueue(t *testing.T) (*database.DB, *services.JobQueueService) {
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(&models.AutomationJob{}))
+	return db, services.NewJobQueueService(db)
+}
+
+func enqueueTestJob(t *testing.T, queue *services.JobQueueService, jobType string, maxRetries int) *models.AutomationJob {
+	job := &models.AutomationJob{
+		TenantID:   uuid.New().String(),
+		JobType:    jobType,
+		Payload:    "{}",
+		RunAt:      time.Now().Add(-time.Second),
+		MaxRetries: maxRetries,
+	}
+	require.NoError(t, queue.EnqueueJob(job))
+	return job
+}
+
+// runDispatcherOnce starts a dispatcher, waits for the expected number of handler calls and stops it
+func runDispatcherOnce(t *testing.T, d *worker.JobDispatcher, calls <-chan string, want int) {
+	d.Start(context.Background())
+	for i := 0; i < want; i++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for job %d", i+1)
+		}
+	}
+	d.Stop()
+}
+
+// TestJobDispatcherCompletesJobs tests successful handlers complete their jobs
+func TestJobDispatcherCompletesJobs(t *testing.T) {
+	_, queue := setupJobQueue(t)
+	job := enqueueTestJob(t, queue, models.JobTypeReminder, 3)
+	other := enqueueTestJob(t, queue, models.JobTypeWebhook, 3)
+
+	calls := make(chan string, 4)
+	d := worker.NewJobDispatcher(queue, 2)
+	d.Register(models.JobTypeReminder, func(ctx context.Context, j *models.AutomationJob) error {
+		calls <- j.ID
+		return nil
+	})
+	runDispatcherOnce(t, d, calls, 1)
+
+	got, err := queue.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusCompleted, got.Status)
+	assert.NotNil(t, got.CompletedAt)
+
+	// Job types without a handler are left in the queue
+	untouched, err := queue.GetJob(other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusPending, untouched.Status)
+}
+
+// TestJobDispatcherRetriesAndDeadLetters tests failed handlers are retried with backoff
+// until MaxRetries is reached
+func TestJobDispatcherRetriesAndDeadLetters(t *testing.T) {
+	db, queue := setupJobQueue(t)
+	job := enqueueTestJob(t, queue, models.JobTypeWebhook, 2)
+
+	calls := make(chan string, 4)
+	d := worker.NewJobDispatcher(queue, 1)
+	d.Register(models.JobTypeWebhook, func(ctx context.Context, j *models.AutomationJob) error {
+		calls <- j.ID
+		return errors.New("endpoint unavailable")
+	})
+	runDispatcherOnce(t, d, calls, 1)
+
+	got, err := queue.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusPending, got.Status)
+	assert.Equal(t, 1, got.RetryCount)
+	assert.Equal(t, "endpoint unavailable", got.LastError)
+	require.NotNil(t, got.NextRetryAt)
+	assert.True(t, got.NextRetryAt.After(time.Now()))
+
+	// Not due yet
+	pending, err := queue.GetPendingJobs(10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Make the retry due and run again; the second failure exhausts MaxRetries
+	past := time.Now().Add(-time.Second)
+	require.NoError(t, db.Model(&models.AutomationJob{}).Where("id = ?", job.ID).
+		Updates(map[string]interface{}{"run_at": past, "next_retry_at": past}).Error)
+
+	d = worker.NewJobDispatcher(queue, 1)
+	d.Register(models.JobTypeWebhook, func(ctx context.Context, j *models.AutomationJob) error {
+		calls <- j.ID
+		return errors.New("endpoint unavailable")
+	})
+	runDispatcherOnce(t, d, calls, 1)
+
+	got, err = queue.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusDeadLetter, got.Status)
+	assert.Equal(t, 2, got.RetryCount)
+}
+
+// TestClaimJobOnlyOnce tests a job can only be claimed by one worker
+func TestClaimJobOnlyOnce(t *testing.T) {
+	_, queue := setupJobQueue(t)
+	job := enqueueTestJob(t, queue, models.JobTypeReminder, 3)
+
+	require.NoError(t, queue.ClaimJob(job.ID))
+	assert.Error(t, queue.ClaimJob(job.ID))
+
+	// A stalled claim is released back to the queue
+	n, err := queue.RequeueStaleJobs(-time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, queue.ClaimJob(job.ID))
+}
+
+// TestRenewJobKeepsLease tests a renewed job is not requeued as stale
+func TestRenewJobKeepsLease(t *testing.T) {
+	db, queue := setupJobQueue(t)
+	job := enqueueTestJob(t, queue, models.JobTypeReminder, 3)
+	require.NoError(t, queue.ClaimJob(job.ID))
+
+	require.NoError(t, db.Model(&models.AutomationJob{}).Where("id = ?", job.ID).
+		Update("started_at", time.Now().Add(-time.Hour)).Error)
+	require.NoError(t, queue.RenewJob(job.ID))
+
+	n, err := queue.RequeueStaleJobs(30 * time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+// TestScheduleReminderQueuesJob tests reminders are queued once as reminder jobs,
+// can be cancelled, and are skipped once the invoice is paid
+func TestScheduleReminderQueuesJob(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	queue := services.NewJobQueueService(db)
+	reminders := services.NewReminderService(db, &services.ServiceDependencies{DB: db})
+	reminders.SetJobQueue(queue)
+
+	invoice := &models.Invoice{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(),
+		ClientID: createTestClient(t, db, tenantID), InvoiceNumber: "INV-REM-1",
+		Status: models.InvoiceStatusPaid, Currency: "KES", DueDate: time.Now().Add(72 * time.Hour),
+	}
+	require.NoError(t, db.Create(invoice).Error)
+
+	sendAt := time.Now().Add(24 * time.Hour)
+	require.NoError(t, reminders.ScheduleReminder(invoice.ID, "due_soon", sendAt))
+	require.NoError(t, reminders.ScheduleReminder(invoice.ID, "due_soon", sendAt))
+	assert.Error(t, reminders.ScheduleReminder(uuid.New().String(), "due_soon", sendAt))
+
+	var jobs []models.AutomationJob
+	require.NoError(t, db.Where("job_type = ?", models.JobTypeReminder).Find(&jobs).Error)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, tenantID, jobs[0].TenantID)
+	assert.WithinDuration(t, sendAt, jobs[0].RunAt, time.Second)
+
+	// A paid invoice gets no reminder
+	require.NoError(t, reminders.ProcessReminderJob(context.Background(), &jobs[0]))
+	var sent int64
+	db.Model(&models.Reminder{}).Where("invoice_id = ?", invoice.ID).Count(&sent)
+	assert.Equal(t, int64(0), sent)
+
+	require.NoError(t, reminders.CancelReminder(invoice.ID, "due_soon"))
+	var left int64
+	db.Model(&models.AutomationJob{}).Where("job_type = ?", models.JobTypeReminder).Count(&left)
+	assert.Equal(t, int64(0), left)
+}
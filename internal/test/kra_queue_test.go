@@ -0,0 +1,240 @@
+package services_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// KRA Retry Queue Tests
+// ============================================================
+
+func setupKRAQueue(t *testing.T, handler http.HandlerFunc) (*database.DB, *services.KRAService, *models.Invoice) {
+	if os.Getenv("ENCRYPTION_KEY") == "" {
+		os.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-testing-only-1234567890")
+	}
+	models.InitEncryption(os.Getenv("ENCRYPTION_KEY"))
+
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(&models.Invoice{}, &models.KRAQueueItem{}, &models.KRAAuditLog{}))
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{KRA: config.KRAConfig{APIURL: server.URL, APIKey: "test-key"}}
+	kraService := services.NewKRAServiceWithDB(cfg, db)
+
+	invoice := &models.Invoice{
+		ID:            uuid.New().String(),
+		TenantID:      uuid.New().String(),
+		UserID:        uuid.New().String(),
+		ClientID:      uuid.New().String(),
+		InvoiceNumber: "INV-KRA-001",
+		Status:        models.InvoiceStatusSent,
+		Currency:      "KES",
+		DueDate:       time.Now().AddDate(0, 0, 14),
+		KRAStatus:     models.KRAInvoiceStatusFailed,
+	}
+	require.NoError(t, db.Create(invoice).Error)
+
+	require.NoError(t, kraService.QueueFailedSubmission(invoice.TenantID, invoice.ID, invoice.InvoiceNumber,
+		[]byte(`{"invoiceNumber":"INV-KRA-001"}`), "connection refused"))
+
+	return db, kraService, invoice
+}
+
+// TestKRARetryQueueSuccess tests a replayed submission registers the invoice
+func TestKRARetryQueueSuccess(t *testing.T) {
+	db, kraService, invoice := setupKRAQueue(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"resultCode":"00","resultDesc":"Success","icn":"ICN-123","qrCode":"QR-123"}`))
+	})
+
+	require.NoError(t, kraService.ProcessRetryQueue())
+
+	var updated models.Invoice
+	require.NoError(t, db.First(&updated, "id = ?", invoice.ID).Error)
+	assert.Equal(t, "ICN-123", updated.KRAICN)
+	assert.Equal(t, "QR-123", updated.KRAQRCode)
+	assert.Equal(t, models.KRAInvoiceStatusSubmitted, updated.KRAStatus)
+	assert.NotNil(t, updated.KRASubmittedAt)
+
+	var item models.KRAQueueItem
+	require.NoError(t, db.First(&item, "invoice_id = ?", invoice.ID).Error)
+	assert.Equal(t, models.KRAQueueCompleted, item.Status)
+
+	var logs []models.KRAAuditLog
+	require.NoError(t, db.Where("invoice_id = ?", invoice.ID).Find(&logs).Error)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "kra_success", logs[0].Action)
+	assert.Equal(t, "ICN-123", logs[0].ICN)
+
+	pending, failed, completed, err := kraService.GetQueueStatus()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending)
+	assert.Equal(t, int64(0), failed)
+	assert.Equal(t, int64(1), completed)
+}
+
+// TestKRARetryQueueExhaustsRetries tests failed replays back off and eventually give up
+func TestKRARetryQueueExhaustsRetries(t *testing.T) {
+	db, kraService, invoice := setupKRAQueue(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"resultDesc":"maintenance"}`))
+	})
+
+	require.NoError(t, kraService.ProcessRetryQueue())
+
+	var item models.KRAQueueItem
+	require.NoError(t, db.First(&item, "invoice_id = ?", invoice.ID).Error)
+	assert.Equal(t, models.KRAQueuePending, item.Status)
+	assert.Equal(t, 1, item.RetryCount)
+	require.NotNil(t, item.NextRetryAt)
+	assert.True(t, item.NextRetryAt.After(time.Now()))
+
+	// Backoff not elapsed yet: nothing is replayed
+	require.NoError(t, kraService.ProcessRetryQueue())
+	require.NoError(t, db.First(&item, "id = ?", item.ID).Error)
+	assert.Equal(t, 1, item.RetryCount)
+
+	// Force the remaining attempts
+	for i := 0; i < 2; i++ {
+		require.NoError(t, db.Model(&models.KRAQueueItem{}).Where("id = ?", item.ID).
+			Update("next_retry_at", time.Now().Add(-time.Second)).Error)
+		require.NoError(t, kraService.ProcessRetryQueue())
+	}
+
+	require.NoError(t, db.First(&item, "id = ?", item.ID).Error)
+	assert.Equal(t, models.KRAQueueFailed, item.Status)
+	assert.Equal(t, 3, item.RetryCount)
+
+	var updated models.Invoice
+	require.NoError(t, db.First(&updated, "id = ?", invoice.ID).Error)
+	assert.Equal(t, models.KRAInvoiceStatusFailed, updated.KRAStatus)
+	assert.Empty(t, updated.KRAICN)
+
+	var actions []string
+	require.NoError(t, db.Model(&models.KRAAuditLog{}).Where("invoice_id = ?", invoice.ID).
+		Order("created_at ASC").Pluck("action", &actions).Error)
+	assert.Equal(t, []string{"kra_retry", "kra_retry", "kra_failed"}, actions)
+}
+
+// TestKRARetryQueueFailureNotRecorded tests a failed replay whose result can't be
+// written to the invoice is rolled back and left for the stale-item sweep
+func TestKRARetryQueueFailureNotRecorded(t *testing.T) {
+	db, kraService, invoice := setupKRAQueue(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	require.NoError(t, db.Exec(`CREATE TRIGGER invoices_locked BEFORE UPDATE OF kra_error ON invoices
+		BEGIN SELECT RAISE(ABORT, 'invoice locked'); END`).Error)
+
+	require.NoError(t, kraService.ProcessRetryQueue())
+
+	var item models.KRAQueueItem
+	require.NoError(t, db.First(&item, "invoice_id = ?", invoice.ID).Error)
+	assert.Equal(t, models.KRAQueueProcessing, item.Status)
+	assert.Equal(t, 0, item.RetryCount)
+
+	var logs int64
+	require.NoError(t, db.Model(&models.KRAAuditLog{}).Where("invoice_id = ?", invoice.ID).Count(&logs).Error)
+	assert.Zero(t, logs)
+}
+
+// TestKRARetryQueueSuccessNotRecorded tests an accepted replay whose ICN can't be
+// written to the invoice keeps the ICN on the item and is never submitted again
+func TestKRARetryQueueSuccessNotRecorded(t *testing.T) {
+	var submissions int32
+	db, kraService, invoice := setupKRAQueue(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&submissions, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"resultCode":"00","resultDesc":"Success","icn":"ICN-456","qrCode":"QR-456"}`))
+	})
+	require.NoError(t, db.Exec(`CREATE TRIGGER invoices_locked BEFORE UPDATE OF kra_icn ON invoices
+		BEGIN SELECT RAISE(ABORT, 'invoice locked'); END`).Error)
+
+	require.NoError(t, kraService.ProcessRetryQueue())
+
+	var item models.KRAQueueItem
+	require.NoError(t, db.First(&item, "invoice_id = ?", invoice.ID).Error)
+	assert.Equal(t, models.KRAQueueAccepted, item.Status)
+	assert.Equal(t, "ICN-456", item.ICN)
+	assert.Equal(t, "QR-456", item.QRCode)
+
+	// Long past the stale-claim window the item is still not replayed
+	require.NoError(t, db.Model(&models.KRAQueueItem{}).Where("id = ?", item.ID).
+		Update("updated_at", time.Now().Add(-time.Hour)).Error)
+	require.NoError(t, kraService.ProcessRetryQueue())
+	require.NoError(t, db.First(&item, "id = ?", item.ID).Error)
+	assert.Equal(t, models.KRAQueueAccepted, item.Status)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&submissions))
+
+	var logs int64
+	require.NoError(t, db.Model(&models.KRAAuditLog{}).Where("invoice_id = ?", invoice.ID).Count(&logs).Error)
+	assert.Zero(t, logs)
+
+	// Once the invoice can be written the next run records the ICN without resubmitting
+	require.NoError(t, db.Exec(`DROP TRIGGER invoices_locked`).Error)
+	require.NoError(t, kraService.ProcessRetryQueue())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&submissions))
+
+	require.NoError(t, db.First(&item, "id = ?", item.ID).Error)
+	assert.Equal(t, models.KRAQueueCompleted, item.Status)
+	assert.NotNil(t, item.CompletedAt)
+
+	var updated models.Invoice
+	require.NoError(t, db.First(&updated, "id = ?", invoice.ID).Error)
+	assert.Equal(t, "ICN-456", updated.KRAICN)
+	assert.Equal(t, "QR-456", updated.KRAQRCode)
+	assert.Equal(t, models.KRAInvoiceStatusSubmitted, updated.KRAStatus)
+
+	var actions []string
+	require.NoError(t, db.Model(&models.KRAAuditLog{}).Where("invoice_id = ?", invoice.ID).Pluck("action", &actions).Error)
+	assert.Equal(t, []string{"kra_success"}, actions)
+}
+
+// TestKRAQueueSkipsCancelledInvoice tests cancelling an invoice voids its queued
+// submission and a queued item of a cancelled invoice is never replayed
+func TestKRAQueueSkipsCancelledInvoice(t *testing.T) {
+	submitted := 0
+	db, kraService, invoice := setupKRAQueue(t, func(w http.ResponseWriter, r *http.Request) {
+		submitted++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"resultCode":"00","resultDesc":"Success","icn":"ICN-123","qrCode":"QR-123"}`))
+	})
+	require.NoError(t, db.AutoMigrate(&models.AuditLog{}))
+
+	require.NoError(t, services.NewInvoiceService(db).CancelInvoice(invoice.TenantID, invoice.ID, invoice.UserID))
+	var item models.KRAQueueItem
+	require.NoError(t, db.First(&item, "invoice_id = ?", invoice.ID).Error)
+	assert.Equal(t, models.KRAQueueCancelled, item.Status)
+
+	// An item queued behind the cancellation's back is dropped when it comes up
+	require.NoError(t, db.Model(&models.KRAQueueItem{}).Where("id = ?", item.ID).Update("status", models.KRAQueuePending).Error)
+	require.NoError(t, kraService.ProcessRetryQueue())
+	assert.Zero(t, submitted)
+	require.NoError(t, db.First(&item, "id = ?", item.ID).Error)
+	assert.Equal(t, models.KRAQueueCancelled, item.Status)
+
+	var updated models.Invoice
+	require.NoError(t, db.First(&updated, "id = ?", invoice.ID).Error)
+	assert.Empty(t, updated.KRAICN)
+}
@@ -0,0 +1,202 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// General Ledger Tests
+// ============================================================
+
+func ledgerInvoice(t *testing.T, db *database.DB, tenantID, number, invoiceType string, subtotal, tax, total float64, sentAt time.Time) *models.Invoice {
+	status := models.InvoiceStatusSent
+	if invoiceType == "credit_note" {
+		status = models.InvoiceStatusCreditNote
+	}
+	invoice := &models.Invoice{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(), ClientID: uuid.New().String(),
+		InvoiceNumber: number, InvoiceType: invoiceType, Currency: "KES", Status: status, SentAt: &sentAt,
+		Subtotal: models.ToCents(subtotal), TotalTax: models.ToCents(tax), Total: models.ToCents(total),
+		MagicToken: uuid.New().String(),
+	}
+	require.NoError(t, db.Create(invoice).Error)
+	return invoice
+}
+
+func accountBalance(report *services.TrialBalance, code string) models.Money {
+	for _, a := range report.Accounts {
+		if a.Code == code {
+			return a.Balance
+		}
+	}
+	return 0
+}
+
+// TestLedgerAutomaticPostings tests documents post once, balance, and are reversed rather than changed
+func TestLedgerAutomaticPostings(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	ledger := services.NewLedgerService(db)
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 9, 0, 0, 0, time.UTC) }
+
+	// 10,000 + 16% VAT, then a 500 late fee added to the total
+	invoice := ledgerInvoice(t, db, tenantID, "INV-L-001", "invoice", 10000, 1600, 12100, day(1))
+	fee := &models.LateFeeInvoice{
+		ID: uuid.New().String(), TenantID: tenantID, InvoiceID: invoice.ID, FeeAmount: models.ToCents(500), AppliedAt: day(5),
+	}
+	require.NoError(t, db.Create(fee).Error)
+	// Credit notes keep neither their tax nor a positive total
+	ledgerInvoice(t, db, tenantID, "CN-L-001", "credit_note", 1000, 0, -1160, day(6))
+
+	completed := day(7)
+	payment := &models.Payment{
+		ID: uuid.New().String(), TenantID: tenantID, InvoiceID: invoice.ID, Amount: models.ToCents(13000), Currency: "KES",
+		Method: models.PaymentMethodMpesa, Status: models.PaymentStatusCompleted, Reference: "SGH7K2LM9P", CompletedAt: &completed,
+	}
+	require.NoError(t, db.Create(payment).Error)
+	require.NoError(t, db.Create(&models.PaymentAllocation{
+		ID: uuid.New().String(), TenantID: tenantID, PaymentID: payment.ID, InvoiceID: invoice.ID, Amount: models.ToCents(12100),
+	}).Error)
+
+	paidAt := day(9)
+	require.NoError(t, db.Create(&models.Expense{
+		ID: uuid.New().String(), TenantID: tenantID, Title: "Printer", Amount: models.ToCents(5800), TaxAmount: models.ToCents(800),
+		Currency: "KES", Date: day(8), Status: "paid", PaymentMethod: "bank", PaidAt: &paidAt,
+	}).Error)
+	draft := ledgerInvoice(t, db, tenantID, "INV-L-002", "invoice", 1000, 0, 1000, day(2))
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", draft.ID).Update("status", models.InvoiceStatusDraft).Error)
+
+	posted, err := ledger.PostPending(tenantID)
+	require.NoError(t, err)
+	assert.Equal(t, 6, posted, "invoice, late fee, credit note, payment, expense and its payment; not the draft")
+	posted, err = ledger.PostPending(tenantID)
+	require.NoError(t, err)
+	assert.Zero(t, posted, "posting is idempotent")
+
+	tb, err := ledger.TrialBalance(tenantID, day(31))
+	require.NoError(t, err)
+	assert.True(t, tb.Balanced)
+	assert.True(t, accountBalance(tb, "1020").Equals(models.ToCents(13000)), "M-Pesa")
+	assert.True(t, accountBalance(tb, "1100").Equals(models.ToCents(-1160)), "receivable: the credit note is still open")
+	assert.True(t, accountBalance(tb, "2200").Equals(models.ToCents(900)), "overpayment held as customer credit")
+	assert.True(t, accountBalance(tb, "4000").Equals(models.ToCents(9000)))
+	assert.True(t, accountBalance(tb, "2100").Equals(models.ToCents(1440)))
+	assert.True(t, accountBalance(tb, "4100").Equals(models.ToCents(500)))
+	assert.True(t, accountBalance(tb, "5000").Equals(models.ToCents(5000)))
+	assert.True(t, accountBalance(tb, "1300").Equals(models.ToCents(800)))
+	assert.True(t, accountBalance(tb, "1010").Equals(models.ToCents(-5800)))
+	assert.Zero(t, accountBalance(tb, "2000"), "the expense is paid")
+
+	sheet, err := ledger.BalanceSheet(tenantID, day(31))
+	require.NoError(t, err)
+	assert.True(t, sheet.Balanced)
+	assert.True(t, sheet.Assets.Total.Equals(models.ToCents(6840)))
+	assert.True(t, sheet.CurrentEarnings.Equals(models.ToCents(4500)))
+
+	// Posted entries can't be changed
+	var entry models.JournalEntry
+	require.NoError(t, db.First(&entry, "source_type = ? AND source_id = ?", models.JournalSourcePayment, payment.ID).Error)
+	assert.ErrorIs(t, db.Model(&entry).Update("memo", "edited").Error, models.ErrJournalImmutable)
+	assert.ErrorIs(t, db.Delete(&entry).Error, models.ErrJournalImmutable)
+
+	// Refunds and waivers post reversing entries
+	require.NoError(t, db.Model(&models.Payment{}).Where("id = ?", payment.ID).Update("status", models.PaymentStatusRefunded).Error)
+	require.NoError(t, db.Model(&models.LateFeeInvoice{}).Where("id = ?", fee.ID).Updates(map[string]interface{}{"waived": true, "waived_at": day(10)}).Error)
+	posted, err = ledger.PostPending(tenantID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, posted)
+
+	mpesa := ""
+	accounts, err := ledger.ListAccounts(tenantID)
+	require.NoError(t, err)
+	for _, a := range accounts {
+		if a.SystemKey == models.LedgerKeyMpesa {
+			mpesa = a.ID
+		}
+	}
+	// Refunds are dated when the payment was marked refunded
+	gl, err := ledger.GeneralLedger(tenantID, mpesa, day(1), time.Now())
+	require.NoError(t, err)
+	require.Len(t, gl.Accounts, 1)
+	lines := gl.Accounts[0].Lines
+	require.Len(t, lines, 2)
+	assert.True(t, lines[0].Balance.Equals(models.ToCents(13000)))
+	assert.Equal(t, models.JournalSourceRefund, lines[1].SourceType)
+	assert.Zero(t, gl.Accounts[0].ClosingBalance)
+
+	_, err = ledger.ReverseJournal(tenantID, uuid.New().String(), entry.ID, day(12), "")
+	assert.ErrorIs(t, err, services.ErrJournalNotReversible, "automatic entries follow their documents")
+
+	other, err := ledger.TrialBalance(uuid.New().String(), day(31))
+	require.NoError(t, err)
+	assert.Empty(t, other.Accounts, "ledgers are per tenant")
+}
+
+// TestLedgerManualJournals tests manual journals, reversals and moving a system account
+func TestLedgerManualJournals(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	ledger := services.NewLedgerService(db)
+	userID := uuid.New().String()
+	date := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	accounts, err := ledger.ListAccounts(tenantID)
+	require.NoError(t, err)
+	byCode := map[string]string{}
+	for _, a := range accounts {
+		byCode[a.Code] = a.ID
+	}
+
+	_, err = ledger.CreateJournal(tenantID, userID, date, "Unbalanced", []services.JournalLineInput{
+		{AccountID: byCode["1010"], Debit: models.ToCents(100)},
+		{AccountID: byCode["3000"], Credit: models.ToCents(90)},
+	})
+	assert.ErrorIs(t, err, services.ErrJournalUnbalanced)
+
+	capital, err := ledger.CreateJournal(tenantID, userID, date, "Owner capital", []services.JournalLineInput{
+		{AccountID: byCode["1010"], Debit: models.ToCents(50000)},
+		{AccountID: byCode["3000"], Credit: models.ToCents(50000)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "JE-000001", capital.Number)
+	assert.Equal(t, userID, capital.PostedBy)
+
+	reversal, err := ledger.ReverseJournal(tenantID, userID, capital.ID, date.AddDate(0, 0, 1), "")
+	require.NoError(t, err)
+	assert.Equal(t, capital.ID, reversal.ReversesID)
+	_, err = ledger.ReverseJournal(tenantID, userID, capital.ID, date, "")
+	assert.ErrorIs(t, err, services.ErrJournalReversed)
+
+	tb, err := ledger.TrialBalance(tenantID, date)
+	require.NoError(t, err)
+	assert.True(t, accountBalance(tb, "3000").Equals(models.ToCents(50000)), "the reversal is dated the next day")
+	tb, err = ledger.TrialBalance(tenantID, date.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Zero(t, accountBalance(tb, "3000"))
+
+	// Sales move to a new income account; the old one can't be switched off while it holds the key
+	active := false
+	_, err = ledger.UpdateAccount(tenantID, byCode["4000"], services.LedgerAccountInput{IsActive: &active})
+	assert.ErrorIs(t, err, services.ErrLedgerSystemKey)
+	consulting, err := ledger.CreateAccount(tenantID, services.LedgerAccountInput{Code: "4010", Name: "Consulting", Type: models.LedgerIncome})
+	require.NoError(t, err)
+	_, err = ledger.CreateAccount(tenantID, services.LedgerAccountInput{Code: "4010", Name: "Duplicate", Type: models.LedgerIncome})
+	assert.ErrorIs(t, err, services.ErrLedgerAccountCode)
+	_, err = ledger.AssignSystemKey(tenantID, byCode["1010"], models.LedgerKeySales)
+	assert.ErrorIs(t, err, services.ErrLedgerSystemKey, "sales can't move to an asset")
+	_, err = ledger.AssignSystemKey(tenantID, consulting.ID, models.LedgerKeySales)
+	require.NoError(t, err)
+
+	ledgerInvoice(t, db, tenantID, "INV-L-100", "invoice", 2000, 0, 2000, date)
+	tb, err = ledger.TrialBalance(tenantID, date)
+	require.NoError(t, err)
+	assert.True(t, accountBalance(tb, "4010").Equals(models.ToCents(2000)))
+	assert.Zero(t, accountBalance(tb, "4000"))
+}
@@ -0,0 +1,280 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// M-Pesa B2C Payout Tests
+// ============================================================
+
+func setupPayouts(t *testing.T) (*database.DB, *services.PayoutService, string, *int) {
+	requests := 0
+	daraja := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth/v1/generate":
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "expires_in": "3599"})
+		case "/mpesa/b2c/v3/paymentrequest":
+			requests++
+			var req map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["Amount"] == "777" {
+				// The gateway gave up; Daraja may or may not have the request
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0",
+				"ConversationID": "AG_" + req["OriginatorConversationID"].(string), "OriginatorConversationID": req["OriginatorConversationID"].(string),
+				"ResponseDescription": "Accept the service request successfully."})
+		case "/mpesa/transactionstatus/v1/query":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0", "ConversationID": "AGS_" + req["OriginalConversationID"],
+				"OriginatorConversationID": "ocs-" + req["OriginalConversationID"], "ResponseDescription": "Accept the service request successfully."})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(daraja.Close)
+
+	settingsService, db, tenantID := setupTestService(t)
+	require.NoError(t, settingsService.SaveMpesaSettings(tenantID, &services.MpesaSettings{
+		ConsumerKey: "key", ConsumerSecret: "secret", Shortcode: "600111", Passkey: "passkey", Enabled: true,
+		InitiatorName: "apiop", InitiatorCredential: "encrypted-password", B2CShortcode: "600999",
+	}))
+	mpesa := services.NewMPesaService(&config.Config{MPesa: config.MPesaConfig{
+		APIURL: daraja.URL, ResultURL: "https://invoices.example.co.ke/api/v1/webhook/mpesa/result",
+	}}, db, nil)
+	mpesa.SetCredentialStore(settingsService)
+	return db, services.NewPayoutService(db, mpesa), tenantID, &requests
+}
+
+func b2cResult(conversationID string, code int, receipt string) *services.MpesaResult {
+	var result services.MpesaResult
+	result.Result.ResultCode = code
+	result.Result.ConversationID = conversationID
+	result.Result.TransactionID = receipt
+	if code == 0 {
+		result.Result.ResultDesc = "The service request is processed successfully."
+	} else {
+		result.Result.ResultDesc = "The balance is insufficient for the transaction."
+	}
+	return &result
+}
+
+// TestPayoutRefund tests an approved refund is sent over B2C and reverses the payment when it completes
+func TestPayoutRefund(t *testing.T) {
+	db, payouts, tenantID, requests := setupPayouts(t)
+	ctx := context.Background()
+
+	client := &models.Client{ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(), Name: "Mama Mboga", Currency: "KES"}
+	require.NoError(t, db.Create(client).Error)
+	invoice := &models.Invoice{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: client.UserID, ClientID: client.ID,
+		InvoiceNumber: "INV-B2C-1", Currency: "KES", Total: models.ToCents(1500), BalanceDue: models.ToCents(1500),
+		Status: models.InvoiceStatusSent, InvoiceType: "invoice", DueDate: time.Now().AddDate(0, 0, 14), MagicToken: uuid.New().String(),
+	}
+	require.NoError(t, db.Create(invoice).Error)
+	paid, err := services.NewPaymentMatchingService(db, nil).AllocatePayment(tenantID, client.UserID, &services.AllocatePaymentRequest{
+		ClientID: client.ID, Amount: 1500, Currency: "KES", Method: string(models.PaymentMethodMpesa),
+		Reference: "QKL1B2C", PhoneNumber: "0712345678", InvoiceIDs: []string{invoice.ID},
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.InvoiceStatusPaid, reloadInvoice(t, db, invoice.ID).Status)
+
+	payout, err := payouts.RequestPayout(tenantID, client.UserID, models.PayoutTypeRefund, &services.PayoutRequest{
+		PaymentID: paid.Payment.ID, Reason: "Goods returned",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, models.PayoutStatusPendingApproval, payout.Status)
+	assert.Equal(t, "254712345678", payout.PhoneNumber, "defaults to the phone that paid")
+	assert.True(t, payout.Amount.Equals(models.ToCents(1500)))
+	assert.Zero(t, *requests, "nothing is sent before approval")
+
+	_, err = payouts.RequestPayout(tenantID, client.UserID, models.PayoutTypeRefund, &services.PayoutRequest{PaymentID: paid.Payment.ID})
+	assert.ErrorIs(t, err, services.ErrPayoutInProgress)
+
+	approved, err := payouts.Approve(ctx, tenantID, uuid.New().String(), payout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.PayoutStatusProcessing, approved.Status)
+	assert.Equal(t, "AG_"+payout.ID, approved.ConversationID)
+	assert.Equal(t, 1, *requests)
+
+	_, err = payouts.Approve(ctx, tenantID, uuid.New().String(), payout.ID)
+	assert.ErrorIs(t, err, services.ErrPayoutNotPending)
+	assert.Equal(t, 1, *requests)
+
+	require.NoError(t, payouts.ProcessB2CResult(ctx, tenantID, b2cResult(approved.ConversationID, 0, "RKT1REFUND")))
+	// Daraja may deliver the result more than once
+	require.NoError(t, payouts.ProcessB2CResult(ctx, tenantID, b2cResult(approved.ConversationID, 0, "RKT1REFUND")))
+
+	done, err := payouts.GetPayout(tenantID, payout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.PayoutStatusCompleted, done.Status)
+	assert.Equal(t, "RKT1REFUND", done.ReceiptNumber)
+	assert.Len(t, done.Events, 4, "requested, approved, sent and completed")
+
+	var payment models.Payment
+	require.NoError(t, db.First(&payment, "id = ?", paid.Payment.ID).Error)
+	assert.Equal(t, models.PaymentStatusRefunded, payment.Status)
+	reversed := reloadInvoice(t, db, invoice.ID)
+	assert.Equal(t, models.InvoiceStatusSent, reversed.Status)
+	assert.True(t, reversed.BalanceDue.Equals(models.ToCents(1500)))
+}
+
+// TestPayoutOverpayment tests client credit is paid back only up to the available balance
+func TestPayoutOverpayment(t *testing.T) {
+	db, payouts, tenantID, _ := setupPayouts(t)
+	ctx := context.Background()
+
+	client := &models.Client{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(), Name: "Duka Moja",
+		Phone: "0722000111", Currency: "KES", CreditBalance: models.ToCents(800),
+	}
+	require.NoError(t, db.Create(client).Error)
+
+	_, err := payouts.RequestPayout(tenantID, client.UserID, models.PayoutTypeOverpayment, &services.PayoutRequest{ClientID: client.ID, Amount: 900})
+	assert.ErrorIs(t, err, services.ErrPayoutExceedsCredit)
+	_, err = payouts.RequestPayout(tenantID, client.UserID, models.PayoutTypeOverpayment, &services.PayoutRequest{ClientID: client.ID, Amount: 10.50})
+	assert.ErrorIs(t, err, services.ErrPayoutAmount)
+
+	first, err := payouts.RequestPayout(tenantID, client.UserID, models.PayoutTypeOverpayment, &services.PayoutRequest{ClientID: client.ID, Amount: 500})
+	require.NoError(t, err)
+	assert.Equal(t, "254722000111", first.PhoneNumber)
+	_, err = payouts.RequestPayout(tenantID, client.UserID, models.PayoutTypeOverpayment, &services.PayoutRequest{ClientID: client.ID, Amount: 400})
+	assert.ErrorIs(t, err, services.ErrPayoutExceedsCredit, "open payouts hold their credit")
+
+	approved, err := payouts.Approve(ctx, tenantID, uuid.New().String(), first.ID)
+	require.NoError(t, err)
+	require.NoError(t, payouts.ProcessB2CResult(ctx, tenantID, b2cResult(approved.ConversationID, 0, "RKT2CREDIT")))
+
+	var reloaded models.Client
+	require.NoError(t, db.First(&reloaded, "id = ?", client.ID).Error)
+	assert.True(t, reloaded.CreditBalance.Equals(models.ToCents(300)))
+	var credit models.ClientCreditTransaction
+	require.NoError(t, db.First(&credit, "payout_id = ?", first.ID).Error)
+	assert.Equal(t, models.ClientCreditPayout, credit.Type)
+	assert.True(t, credit.Amount.Equals(models.ToCents(-500)))
+
+	// A failed payout leaves the credit untouched
+	second, err := payouts.RequestPayout(tenantID, client.UserID, models.PayoutTypeOverpayment, &services.PayoutRequest{ClientID: client.ID, Amount: 300})
+	require.NoError(t, err)
+	approved, err = payouts.Approve(ctx, tenantID, uuid.New().String(), second.ID)
+	require.NoError(t, err)
+	require.NoError(t, payouts.ProcessB2CResult(ctx, tenantID, b2cResult(approved.ConversationID, 2001, "")))
+	failed, err := payouts.GetPayout(tenantID, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.PayoutStatusFailed, failed.Status)
+	require.NoError(t, db.First(&reloaded, "id = ?", client.ID).Error)
+	assert.True(t, reloaded.CreditBalance.Equals(models.ToCents(300)))
+}
+
+// TestPayoutReimbursement tests approved expenses are reimbursed and rejected payouts are never sent
+func TestPayoutReimbursement(t *testing.T) {
+	db, payouts, tenantID, requests := setupPayouts(t)
+	ctx := context.Background()
+
+	employee := &models.User{
+		ID: uuid.New().String(), TenantID: tenantID, Email: "wanjiru@example.co.ke", Name: "Wanjiru",
+		Phone: "0733444555", PasswordHash: "x",
+	}
+	require.NoError(t, db.Create(employee).Error)
+	expense := &models.Expense{
+		ID: uuid.New().String(), TenantID: tenantID, Title: "Fuel", Amount: models.ToCents(2500),
+		Currency: "KES", Date: time.Now(), Status: "pending", CreatedBy: employee.ID,
+	}
+	require.NoError(t, db.Create(expense).Error)
+
+	_, err := payouts.RequestPayout(tenantID, employee.ID, models.PayoutTypeReimbursement, &services.PayoutRequest{ExpenseID: expense.ID})
+	assert.ErrorIs(t, err, services.ErrPayoutSource, "only approved expenses are reimbursed")
+	require.NoError(t, db.Model(expense).Update("status", "approved").Error)
+
+	rejected, err := payouts.RequestPayout(tenantID, employee.ID, models.PayoutTypeReimbursement, &services.PayoutRequest{ExpenseID: expense.ID})
+	require.NoError(t, err)
+	assert.Equal(t, "Wanjiru", rejected.RecipientName)
+	_, err = payouts.Reject(tenantID, uuid.New().String(), rejected.ID, "Missing receipt")
+	require.NoError(t, err)
+	_, err = payouts.Approve(ctx, tenantID, uuid.New().String(), rejected.ID)
+	assert.ErrorIs(t, err, services.ErrPayoutNotPending)
+	assert.Zero(t, *requests)
+
+	payout, err := payouts.RequestPayout(tenantID, employee.ID, models.PayoutTypeReimbursement, &services.PayoutRequest{ExpenseID: expense.ID})
+	require.NoError(t, err)
+	assert.Equal(t, "254733444555", payout.PhoneNumber)
+	approved, err := payouts.Approve(ctx, tenantID, uuid.New().String(), payout.ID)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, payouts.ProcessB2CResult(ctx, uuid.New().String(), b2cResult(approved.ConversationID, 0, "RKT3")), services.ErrMpesaResultUnknown)
+	require.NoError(t, payouts.ProcessB2CResult(ctx, tenantID, b2cResult(approved.ConversationID, 0, "RKT3FUEL")))
+
+	var paid models.Expense
+	require.NoError(t, db.First(&paid, "id = ?", expense.ID).Error)
+	assert.Equal(t, "paid", paid.Status)
+	assert.Equal(t, "RKT3FUEL", paid.Reference)
+	assert.NotNil(t, paid.PaidAt)
+}
+
+// TestPayoutUnknownOutcome tests a payout whose request got no answer stays
+// processing, can't be paid again and is settled through Transaction Status
+func TestPayoutUnknownOutcome(t *testing.T) {
+	db, payouts, tenantID, requests := setupPayouts(t)
+	ctx := context.Background()
+
+	requester := uuid.New().String()
+	client := &models.Client{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: requester, Name: "Duka Moja",
+		Phone: "0722000111", Currency: "KES", CreditBalance: models.ToCents(1000),
+	}
+	require.NoError(t, db.Create(client).Error)
+
+	payout, err := payouts.RequestPayout(tenantID, requester, models.PayoutTypeOverpayment, &services.PayoutRequest{ClientID: client.ID, Amount: 777})
+	require.NoError(t, err)
+	_, err = payouts.Approve(ctx, tenantID, requester, payout.ID)
+	assert.ErrorIs(t, err, services.ErrPayoutSelfApproval)
+	assert.Zero(t, *requests)
+
+	approved, err := payouts.Approve(ctx, tenantID, uuid.New().String(), payout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.PayoutStatusProcessing, approved.Status, "the money may have left")
+	assert.Equal(t, 1, *requests)
+	_, err = payouts.RequestPayout(tenantID, requester, models.PayoutTypeOverpayment, &services.PayoutRequest{ClientID: client.ID, Amount: 500})
+	assert.ErrorIs(t, err, services.ErrPayoutExceedsCredit, "the unsettled payout still holds its credit")
+
+	queried, err := payouts.ReconcileProcessing(ctx, time.Nanosecond)
+	require.NoError(t, err)
+	assert.Equal(t, 1, queried)
+	queried, err = payouts.ReconcileProcessing(ctx, time.Nanosecond)
+	require.NoError(t, err)
+	assert.Zero(t, queried, "a pending query is not sent again")
+
+	var status services.MpesaResult
+	require.NoError(t, json.Unmarshal([]byte(`{"Result":{"ResultType":0,"ResultCode":0,"ResultDesc":"done",
+		"OriginatorConversationID":"ocs-`+payout.ID+`","ConversationID":"AGS_`+payout.ID+`","TransactionID":"QJK0000000",
+		"ResultParameters":{"ResultParameter":[{"Key":"ReceiptNo","Value":"RKT7UNKNOWN"},
+		{"Key":"TransactionStatus","Value":"Completed"},{"Key":"Amount","Value":777}]}}}`), &status))
+	assert.ErrorIs(t, payouts.ProcessStatusResult(ctx, uuid.New().String(), &status), services.ErrMpesaResultUnknown)
+	require.NoError(t, payouts.ProcessStatusResult(ctx, tenantID, &status))
+
+	done, err := payouts.GetPayout(tenantID, payout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.PayoutStatusCompleted, done.Status)
+	assert.Equal(t, "RKT7UNKNOWN", done.ReceiptNumber)
+	var reloaded models.Client
+	require.NoError(t, db.First(&reloaded, "id = ?", client.ID).Error)
+	assert.True(t, reloaded.CreditBalance.Equals(models.ToCents(223)))
+}
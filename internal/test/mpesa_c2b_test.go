@@ -0,0 +1,229 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// M-Pesa C2B Tests
+// ============================================================
+
+func setupC2B(t *testing.T) (*database.DB, *services.C2BService, *services.PaymentMatchingService, string) {
+	// Daraja answers Transaction Status queries with a conversation ID naming the transaction
+	daraja := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth/v1/generate":
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "expires_in": "3599"})
+		case "/mpesa/transactionstatus/v1/query":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0", "ConversationID": "AG_" + req["TransactionID"],
+				"OriginatorConversationID": "oc-" + req["TransactionID"], "ResponseDescription": req["ResultURL"]})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(daraja.Close)
+
+	settingsService, db, tenantID := setupTestService(t)
+	require.NoError(t, settingsService.SaveMpesaSettings(tenantID, &services.MpesaSettings{
+		ConsumerKey: "key", ConsumerSecret: "secret", Shortcode: "600111", Passkey: "passkey", Enabled: true,
+		InitiatorName: "apiop", InitiatorCredential: "encrypted-password",
+	}))
+
+	mpesa := services.NewMPesaService(&config.Config{MPesa: config.MPesaConfig{
+		APIURL: daraja.URL, ResultURL: "https://invoices.example.co.ke/api/v1/webhook/mpesa/result", CallbackSecret: "callback-secret",
+	}}, db, nil)
+	mpesa.SetCredentialStore(settingsService)
+	matcher := services.NewPaymentMatchingService(db, nil)
+	return db, services.NewC2BService(db, mpesa, matcher), matcher, tenantID
+}
+
+// c2bStatus is Daraja's Transaction Status answer for a paybill transaction
+func c2bStatus(t *testing.T, transID string, resultCode int, status, amount string) *services.MpesaResult {
+	var result services.MpesaResult
+	require.NoError(t, json.Unmarshal([]byte(fmt.Sprintf(`{"Result":{"ResultType":0,"ResultCode":%d,
+		"ResultDesc":"done","OriginatorConversationID":"oc-%s","ConversationID":"AG_%s","TransactionID":"QJK0000000",
+		"ResultParameters":{"ResultParameter":[{"Key":"ReceiptNo","Value":"%s"},
+		{"Key":"TransactionStatus","Value":"%s"},{"Key":"Amount","Value":%s}]}}}`,
+		resultCode, transID, transID, transID, status, amount)), &result))
+	return &result
+}
+
+func c2bClient(t *testing.T, db *database.DB, tenantID, name, phone string) *models.Client {
+	client := &models.Client{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(),
+		Name: name, Email: uuid.New().String()[:8] + "@example.co.ke", Phone: phone, Currency: "KES",
+	}
+	require.NoError(t, db.Create(client).Error)
+	return client
+}
+
+func c2bInvoice(t *testing.T, db *database.DB, client *models.Client, number string, total float64, status models.InvoiceStatus) *models.Invoice {
+	invoice := &models.Invoice{
+		ID: uuid.New().String(), TenantID: client.TenantID, UserID: client.UserID, ClientID: client.ID,
+		InvoiceNumber: number, Currency: "KES", Total: models.ToCents(total), BalanceDue: models.ToCents(total),
+		Status: status, InvoiceType: "invoice", DueDate: time.Now().AddDate(0, 0, 14), MagicToken: uuid.New().String(),
+	}
+	require.NoError(t, db.Create(invoice).Error)
+	return invoice
+}
+
+// TestFindUnpaidByReference tests matching on invoice number, client phone and near-miss references
+func TestFindUnpaidByReference(t *testing.T) {
+	db, _, matcher, tenantID := setupC2B(t)
+	wanjiru := c2bClient(t, db, tenantID, "Wanjiru Stores", "0712345678")
+	otieno := c2bClient(t, db, tenantID, "Otieno Hardware", "0722000111")
+	first := c2bInvoice(t, db, wanjiru, "INV-000123", 1500, models.InvoiceStatusSent)
+	c2bInvoice(t, db, otieno, "INV-000124", 800, models.InvoiceStatusOverdue)
+	c2bInvoice(t, db, otieno, "INV-000125", 300, models.InvoiceStatusSent)
+	c2bInvoice(t, db, wanjiru, "INV-000099", 100, models.InvoiceStatusPaid)
+
+	match, err := matcher.FindUnpaidByReference(tenantID, "inv 000123", "")
+	require.NoError(t, err)
+	require.NotNil(t, match)
+	assert.Equal(t, first.ID, match.Invoice.ID)
+	assert.Equal(t, services.MatchOnInvoiceNumber, match.MatchedOn)
+	assert.True(t, match.Automatic)
+
+	match, err = matcher.FindUnpaidByReference(tenantID, "0712345678", "")
+	require.NoError(t, err)
+	require.NotNil(t, match)
+	assert.Equal(t, services.MatchOnPhone, match.MatchedOn)
+	assert.True(t, match.Automatic, "a client with one open invoice")
+
+	match, err = matcher.FindUnpaidByReference(tenantID, "OTIENO", "254722000111")
+	require.NoError(t, err)
+	require.NotNil(t, match)
+	assert.Equal(t, services.MatchOnPhone, match.MatchedOn)
+	assert.Equal(t, "INV-000124", match.Invoice.InvoiceNumber)
+	assert.False(t, match.Automatic, "a client with several open invoices is only a suggestion")
+
+	match, err = matcher.FindUnpaidByReference(tenantID, "125", "")
+	require.NoError(t, err)
+	require.NotNil(t, match)
+	assert.Equal(t, services.MatchOnFuzzyReference, match.MatchedOn)
+	assert.Equal(t, "INV-000125", match.Invoice.InvoiceNumber)
+	assert.False(t, match.Automatic)
+
+	match, err = matcher.FindUnpaidByReference(tenantID, "INV-000099", "")
+	require.NoError(t, err)
+	assert.Nil(t, match, "paid invoices are not matched")
+}
+
+// TestC2BValidationAndConfirmation tests paybill payments are validated, matched or queued
+func TestC2BValidationAndConfirmation(t *testing.T) {
+	db, c2b, matcher, tenantID := setupC2B(t)
+	client := c2bClient(t, db, tenantID, "Wanjiru Stores", "0712345678")
+	invoice := c2bInvoice(t, db, client, "INV-000123", 1500, models.InvoiceStatusSent)
+	c2bInvoice(t, db, client, "INV-000124", 900, models.InvoiceStatusCancelled)
+	ctx := context.Background()
+
+	req := func(transID, ref, amount string) *services.C2BRequest {
+		return &services.C2BRequest{
+			TransactionType: "Pay Bill", TransID: transID, TransAmount: amount, BusinessShortCode: "600111",
+			BillRefNumber: ref, MSISDN: "254700999888", FirstName: "JOHN", LastName: "DOE",
+		}
+	}
+
+	assert.Equal(t, services.C2BAccepted, c2b.Validate(tenantID, req("T1", "inv-000123", "1500")).ResultCode)
+	assert.Equal(t, services.C2BRejectInvalidAccount, c2b.Validate(tenantID, req("T1", "INV-999999", "1500")).ResultCode)
+	assert.Equal(t, services.C2BRejectInvalidAccount, c2b.Validate(tenantID, req("T1", "INV-000124", "900")).ResultCode, "closed invoice")
+	wrongTill := req("T1", "INV-000123", "1500")
+	wrongTill.BusinessShortCode = "999999"
+	assert.Equal(t, services.C2BRejectShortCode, c2b.Validate(tenantID, wrongTill).ResultCode)
+	assert.Equal(t, services.C2BRejectOther, c2b.Validate(uuid.New().String(), req("T1", "INV-000123", "1500")).ResultCode)
+
+	// A confirmation alone moves no money: it waits for Transaction Status
+	result, err := c2b.Confirm(ctx, tenantID, req("QGH1ABC2DE", "INV000123", "1000"))
+	require.NoError(t, err)
+	require.NotNil(t, result.Unallocated)
+	assert.Nil(t, result.Allocation)
+	assert.Equal(t, models.C2BVerificationPending, result.Unallocated.Verification)
+	var reloaded models.Invoice
+	require.NoError(t, db.First(&reloaded, "id = ?", invoice.ID).Error)
+	assert.Equal(t, models.InvoiceStatusSent, reloaded.Status)
+
+	again, err := c2b.Confirm(ctx, tenantID, req("QGH1ABC2DE", "INV000123", "1000"))
+	require.NoError(t, err)
+	assert.True(t, again.Duplicate)
+
+	// Once M-Pesa confirms it, an exact invoice number is applied
+	_, err = c2b.ProcessStatusResult(ctx, uuid.New().String(), c2bStatus(t, "QGH1ABC2DE", 0, "Completed", "1000"))
+	assert.ErrorIs(t, err, services.ErrMpesaResultUnknown)
+	result, err = c2b.ProcessStatusResult(ctx, tenantID, c2bStatus(t, "QGH1ABC2DE", 0, "Completed", "1000"))
+	require.NoError(t, err)
+	require.NotNil(t, result.Allocation)
+	require.NoError(t, db.First(&reloaded, "id = ?", invoice.ID).Error)
+	assert.Equal(t, models.InvoiceStatusPartiallyPaid, reloaded.Status)
+	assert.Equal(t, models.ToCents(500), reloaded.BalanceDue)
+	_, err = c2b.ProcessStatusResult(ctx, tenantID, c2bStatus(t, "QGH1ABC2DE", 0, "Completed", "1000"))
+	assert.ErrorIs(t, err, services.ErrMpesaResultUnknown, "results are applied once")
+
+	// A forged confirmation M-Pesa doesn't know, or one for a different amount, is rejected
+	forged, err := c2b.Confirm(ctx, tenantID, req("QFAKE00001", "INV000123", "500"))
+	require.NoError(t, err)
+	result, err = c2b.ProcessStatusResult(ctx, tenantID, c2bStatus(t, "QFAKE00001", 2001, "", "0"))
+	require.NoError(t, err)
+	assert.Equal(t, models.C2BVerificationRejected, result.Unallocated.Verification)
+	inflated, err := c2b.Confirm(ctx, tenantID, req("QFAKE00002", "INV000123", "500"))
+	require.NoError(t, err)
+	result, err = c2b.ProcessStatusResult(ctx, tenantID, c2bStatus(t, "QFAKE00002", 0, "Completed", "5"))
+	require.NoError(t, err)
+	assert.Equal(t, models.C2BVerificationRejected, result.Unallocated.Verification)
+	require.NoError(t, db.First(&reloaded, "id = ?", invoice.ID).Error)
+	assert.Equal(t, models.ToCents(500), reloaded.BalanceDue)
+	_, err = matcher.AllocatePayment(tenantID, client.UserID, &services.AllocatePaymentRequest{
+		ClientID: client.ID, UnallocatedPaymentID: forged.Unallocated.ID, InvoiceIDs: []string{invoice.ID},
+	})
+	assert.ErrorIs(t, err, services.ErrUnallocatedRejected)
+	assert.ErrorIs(t, matcher.MatchPayment(tenantID, inflated.Unallocated.ID, invoice.ID, client.UserID), services.ErrUnallocatedRejected)
+
+	// A verified near miss stays queued with the suggestion attached
+	nearMiss, err := c2b.Confirm(ctx, tenantID, req("QGH3XYZ4FG", "123", "500"))
+	require.NoError(t, err)
+	result, err = c2b.ProcessStatusResult(ctx, tenantID, c2bStatus(t, "QGH3XYZ4FG", 0, "Completed", "500"))
+	require.NoError(t, err)
+	assert.Nil(t, result.Allocation)
+	require.NotNil(t, result.Unallocated)
+	assert.Equal(t, models.C2BVerificationVerified, result.Unallocated.Verification)
+	assert.Equal(t, invoice.ID, result.Unallocated.SuggestedInvoiceID)
+	assert.Equal(t, services.MatchOnFuzzyReference, result.Unallocated.SuggestionReason)
+	assert.Equal(t, "JOHN DOE", result.Unallocated.PayerName)
+
+	require.NoError(t, matcher.MatchPayment(tenantID, nearMiss.Unallocated.ID, nearMiss.Unallocated.SuggestedInvoiceID, client.UserID))
+	require.NoError(t, db.First(&reloaded, "id = ?", invoice.ID).Error)
+	assert.Equal(t, models.InvoiceStatusPaid, reloaded.Status)
+}
+
+// TestMpesaCallbackURLVerification tests C2B and result callbacks need the
+// tenant's token and, when configured, one of Safaricom's addresses
+func TestMpesaCallbackURLVerification(t *testing.T) {
+	tenantID := uuid.New().String()
+	verifier := services.NewWebhookVerifier(&config.Config{MPesa: config.MPesaConfig{
+		CallbackSecret: "callback-secret", CallbackIPs: []string{"196.201.214.200"},
+	}})
+	token := services.MpesaCallbackToken("callback-secret", tenantID)
+
+	assert.True(t, verifier.VerifyMpesaCallbackURL(tenantID, token, "196.201.214.200").Valid)
+	assert.False(t, verifier.VerifyMpesaCallbackURL(tenantID, token, "203.0.113.9").Valid, "not a Safaricom address")
+	assert.False(t, verifier.VerifyMpesaCallbackURL(uuid.New().String(), token, "196.201.214.200").Valid, "another tenant's token")
+	assert.False(t, verifier.VerifyMpesaCallbackURL(tenantID, "", "196.201.214.200").Valid)
+	assert.NotEqual(t, token, services.MpesaCallbackToken("other-secret", tenantID))
+}
@@ -0,0 +1,182 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// M-Pesa Status Reconciliation Tests
+// ============================================================
+
+// stuckPush creates a pending STK push that was sent age ago
+func stuckPush(t *testing.T, db *database.DB, tenantID, checkoutID string, total float64, age time.Duration) *models.Invoice {
+	invoice := &models.Invoice{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(), ClientID: uuid.New().String(),
+		InvoiceNumber: "INV-" + checkoutID, Currency: "KES", Total: models.ToCents(total), Status: models.InvoiceStatusSent,
+		InvoiceType: "invoice", DueDate: time.Now().AddDate(0, 0, 14), MagicToken: uuid.New().String(),
+	}
+	require.NoError(t, db.Create(invoice).Error)
+	sent := time.Now().Add(-age)
+	require.NoError(t, db.Create(&models.Payment{
+		ID: uuid.New().String(), TenantID: tenantID, InvoiceID: invoice.ID, Amount: invoice.Total,
+		Method: models.PaymentMethodMpesa, Status: models.PaymentStatusPending, Reference: checkoutID, CreatedAt: sent,
+	}).Error)
+	require.NoError(t, db.Create(&models.MpesaSTKRequest{
+		ID: uuid.New().String(), TenantID: tenantID, InvoiceID: invoice.ID, ShortCode: "600111",
+		CredentialSource: models.MpesaCredentialsTenant, MerchantRequestID: "mr-" + checkoutID,
+		CheckoutRequestID: checkoutID, AccountReference: invoice.InvoiceNumber, CreatedAt: sent,
+	}).Error)
+	return invoice
+}
+
+func paymentFor(t *testing.T, db *database.DB, invoiceID string) models.Payment {
+	var payment models.Payment
+	require.NoError(t, db.First(&payment, "invoice_id = ?", invoiceID).Error)
+	return payment
+}
+
+// TestReconcilePendingSTK tests stuck STK pushes are settled by STK Push Query and Transaction Status
+func TestReconcilePendingSTK(t *testing.T) {
+	statusQueries := 0
+	daraja := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth/v1/generate":
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "expires_in": "3599"})
+		case "/mpesa/stkpushquery/v1/query":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			checkoutID := req["CheckoutRequestID"]
+			switch checkoutID {
+			case "ws_CO_paid":
+				json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0", "MerchantRequestID": "mr-ws_CO_paid",
+					"CheckoutRequestID": checkoutID, "ResultCode": "0", "ResultDesc": "The service request is processed successfully."})
+			case "ws_CO_cancelled":
+				json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0", "MerchantRequestID": "mr-ws_CO_cancelled",
+					"CheckoutRequestID": checkoutID, "ResultCode": "1032", "ResultDesc": "Request cancelled by user"})
+			case "ws_CO_waiting":
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"errorCode": "500.001.1001", "errorMessage": "The transaction is being processed"})
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"errorCode": "400.002.02", "errorMessage": "Bad Request - Invalid CheckoutRequestID"})
+			}
+		case "/mpesa/transactionstatus/v1/query":
+			statusQueries++
+			json.NewEncoder(w).Encode(map[string]string{"ResponseCode": "0", "ConversationID": "AG_20261016_old",
+				"OriginatorConversationID": "oc-old", "ResponseDescription": "Accept the service request successfully."})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(daraja.Close)
+
+	settingsService, db, tenantID := setupTestService(t)
+	require.NoError(t, settingsService.SaveMpesaSettings(tenantID, &services.MpesaSettings{
+		ConsumerKey: "key", ConsumerSecret: "secret", Shortcode: "600111", Passkey: "passkey", Enabled: true,
+		InitiatorName: "apiop", InitiatorCredential: "encrypted-password",
+	}))
+	mpesa := services.NewMPesaService(&config.Config{MPesa: config.MPesaConfig{
+		APIURL: daraja.URL, ResultURL: "https://invoices.example.co.ke/api/v1/webhook/mpesa/result",
+	}}, db, nil)
+	mpesa.SetCredentialStore(settingsService)
+	ctx := context.Background()
+
+	paid := stuckPush(t, db, tenantID, "ws_CO_paid", 1000, 5*time.Minute)
+	cancelled := stuckPush(t, db, tenantID, "ws_CO_cancelled", 700, 5*time.Minute)
+	waiting := stuckPush(t, db, tenantID, "ws_CO_waiting", 300, 5*time.Minute)
+	old := stuckPush(t, db, tenantID, "ws_CO_old", 450, 45*time.Minute)
+	fresh := stuckPush(t, db, tenantID, "ws_CO_fresh", 200, 30*time.Second)
+
+	result, err := mpesa.ReconcilePendingSTK(ctx, 3*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Checked, "pushes younger than the threshold wait for their callback")
+	assert.Equal(t, 1, result.Completed)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, 1, result.StillPending)
+	assert.Equal(t, 1, result.StatusQueries)
+
+	assert.Equal(t, models.InvoiceStatusPaid, reloadInvoice(t, db, paid.ID).Status)
+	payment := paymentFor(t, db, paid.ID)
+	assert.Equal(t, models.PaymentStatusCompleted, payment.Status)
+	assert.Equal(t, models.MpesaResolvedBySTKQuery, payment.ResolvedBy)
+
+	payment = paymentFor(t, db, cancelled.ID)
+	assert.Equal(t, models.PaymentStatusFailed, payment.Status)
+	assert.Equal(t, "Request cancelled by user", payment.FailureReason)
+	assert.Equal(t, models.MpesaResolvedBySTKQuery, payment.ResolvedBy)
+
+	assert.Equal(t, models.PaymentStatusPending, paymentFor(t, db, waiting.ID).Status)
+	assert.Equal(t, models.PaymentStatusPending, paymentFor(t, db, fresh.ID).Status)
+
+	var stk models.MpesaSTKRequest
+	require.NoError(t, db.First(&stk, "checkout_request_id = ?", "ws_CO_paid").Error)
+	assert.Equal(t, models.MpesaResolvedBySTKQuery, stk.ResolvedBy)
+	assert.Equal(t, 1, stk.QueryAttempts)
+
+	// A late callback for a push the reconciler already settled changes nothing
+	require.NoError(t, mpesa.ProcessSTKCallback(ctx, tenantID, stkCallback("ws_CO_paid", 1000)))
+	settled := reloadInvoice(t, db, paid.ID)
+	assert.Equal(t, models.ToCents(1000), settled.PaidAmount)
+	assert.Equal(t, models.MpesaResolvedBySTKQuery, paymentFor(t, db, paid.ID).ResolvedBy)
+
+	// Pushes already queried are left alone until the threshold passes again
+	result, err = mpesa.ReconcilePendingSTK(ctx, 3*time.Minute)
+	require.NoError(t, err)
+	assert.Zero(t, result.Checked)
+	assert.Equal(t, 1, statusQueries)
+
+	// Daraja answers the Transaction Status query on the result URL
+	var status services.MpesaResult
+	require.NoError(t, json.Unmarshal([]byte(`{"Result":{"ResultType":0,"ResultCode":0,
+		"ResultDesc":"The service request is processed successfully.","OriginatorConversationID":"oc-old",
+		"ConversationID":"AG_20261016_old","TransactionID":"QJK0000000",
+		"ResultParameters":{"ResultParameter":[{"Key":"ReceiptNo","Value":"QJG7HS12KL"},
+		{"Key":"TransactionStatus","Value":"Completed"},{"Key":"Amount","Value":450}]}}}`), &status))
+
+	assert.ErrorIs(t, mpesa.ProcessTransactionStatusResult(ctx, uuid.New().String(), &status), services.ErrMpesaResultUnknown)
+	require.NoError(t, mpesa.ProcessTransactionStatusResult(ctx, tenantID, &status))
+	assert.Equal(t, models.InvoiceStatusPaid, reloadInvoice(t, db, old.ID).Status)
+	payment = paymentFor(t, db, old.ID)
+	assert.Equal(t, "QJG7HS12KL", payment.Reference)
+	assert.Equal(t, models.MpesaResolvedByTransactionStatus, payment.ResolvedBy)
+
+	assert.ErrorIs(t, mpesa.ProcessTransactionStatusResult(ctx, tenantID, &status), services.ErrMpesaResultUnknown, "results are applied once")
+}
+
+// TestSTKCompletionUpdatesBalance tests a completed STK push moves the invoice's
+// balance and version like any other payment
+func TestSTKCompletionUpdatesBalance(t *testing.T) {
+	settingsService, db, tenantID := setupTestService(t)
+	require.NoError(t, settingsService.SaveMpesaSettings(tenantID, &services.MpesaSettings{
+		ConsumerKey: "key", ConsumerSecret: "secret", Shortcode: "600111", Passkey: "passkey", Enabled: true,
+	}))
+	mpesa := services.NewMPesaService(&config.Config{}, db, nil)
+	mpesa.SetCredentialStore(settingsService)
+
+	invoice := stuckPush(t, db, tenantID, "ws_CO_part", 1000, time.Minute)
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", invoice.ID).Update("balance_due", invoice.Total).Error)
+	require.NoError(t, db.Model(&models.Payment{}).Where("reference = ?", "ws_CO_part").Update("amount", models.ToCents(400)).Error)
+	before := reloadInvoice(t, db, invoice.ID)
+
+	require.NoError(t, mpesa.ProcessSTKCallback(context.Background(), tenantID, stkCallback("ws_CO_part", 400)))
+	after := reloadInvoice(t, db, invoice.ID)
+	assert.Equal(t, models.InvoiceStatusPartiallyPaid, after.Status)
+	assert.True(t, after.PaidAmount.Equals(models.ToCents(400)))
+	assert.True(t, after.BalanceDue.Equals(models.ToCents(600)))
+	assert.Equal(t, before.Version+1, after.Version)
+}
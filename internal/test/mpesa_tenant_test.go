@@ -0,0 +1,124 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// Per-tenant M-Pesa Tests
+// ============================================================
+
+func pendingMpesaPayment(t *testing.T, db *database.DB, tenantID, checkoutID string, total float64) *models.Invoice {
+	invoice := &models.Invoice{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(), ClientID: uuid.New().String(),
+		InvoiceNumber: "INV-" + checkoutID + "-" + tenantID[:8], Currency: "KES", Total: models.ToCents(total), Status: models.InvoiceStatusSent,
+		InvoiceType: "invoice", DueDate: time.Now().AddDate(0, 0, 14), MagicToken: uuid.New().String(),
+	}
+	require.NoError(t, db.Create(invoice).Error)
+	require.NoError(t, db.Create(&models.Payment{
+		ID: uuid.New().String(), TenantID: tenantID, InvoiceID: invoice.ID, Amount: invoice.Total,
+		Method: models.PaymentMethodMpesa, Status: models.PaymentStatusPending, Reference: checkoutID,
+	}).Error)
+	return invoice
+}
+
+func stkCallback(checkoutID string, amount float64) services.STKCallback {
+	var callback services.STKCallback
+	callback.Body.StkCallback.MerchantRequestID = "mr-" + checkoutID
+	callback.Body.StkCallback.CheckoutRequestID = checkoutID
+	callback.Body.StkCallback.CallbackMetadata.Item = []struct {
+		Name  string      `json:"Name"`
+		Value interface{} `json:"Value"`
+	}{
+		{Name: "MpesaReceiptNumber", Value: "R" + checkoutID},
+		{Name: "Amount", Value: amount},
+	}
+	return callback
+}
+
+// TestMpesaTenantCredentials tests tenants use their own Daraja app and fall back to the platform
+func TestMpesaTenantCredentials(t *testing.T) {
+	settingsService, db, tenantID := setupTestService(t)
+	other, err := settingsService.CreateTenant("Paybill Only", "paybill-only")
+	require.NoError(t, err)
+
+	require.NoError(t, settingsService.SaveMpesaSettings(tenantID, &services.MpesaSettings{
+		ConsumerKey: "tenant-key", ConsumerSecret: "tenant-secret", Shortcode: "600111",
+		Passkey: "tenant-passkey", Environment: "production", Enabled: true,
+	}))
+	require.NoError(t, settingsService.SaveMpesaSettings(other.ID, &services.MpesaSettings{Shortcode: "600222", Enabled: true}))
+
+	creds, err := settingsService.GetMpesaCredentials(tenantID)
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-secret", creds.ConsumerSecret)
+	assert.Equal(t, "tenant-passkey", creds.Passkey)
+	assert.True(t, creds.HasOwnCredentials())
+
+	// Saving the masked values shown in the UI keeps the stored secrets
+	masked, err := settingsService.GetMpesaSettings(tenantID)
+	require.NoError(t, err)
+	masked.Shortcode = "600333"
+	require.NoError(t, settingsService.SaveMpesaSettings(tenantID, masked))
+	creds, err = settingsService.GetMpesaCredentials(tenantID)
+	require.NoError(t, err)
+	assert.Equal(t, "600333", creds.Shortcode)
+	assert.Equal(t, "tenant-secret", creds.ConsumerSecret)
+	assert.Equal(t, "tenant-passkey", creds.Passkey)
+
+	cfg := &config.Config{}
+	mpesa := services.NewMPesaService(cfg, db, nil)
+	mpesa.SetCredentialStore(settingsService)
+	assert.True(t, mpesa.IsConfiguredFor(tenantID))
+	assert.False(t, mpesa.IsConfiguredFor(other.ID), "a paybill number alone is not enough")
+
+	cfg.MPesa = config.MPesaConfig{Enabled: true, ConsumerKey: "k", ConsumerSecret: "s", BusinessShortCode: "174379"}
+	assert.True(t, mpesa.IsConfiguredFor(other.ID), "falls back to the platform account")
+}
+
+// TestMpesaCallbackResolvesTenant tests callbacks only settle payments of the tenant they belong to
+func TestMpesaCallbackResolvesTenant(t *testing.T) {
+	settingsService, db, tenantID := setupTestService(t)
+	other, err := settingsService.CreateTenant("Other Tenant", "other")
+	require.NoError(t, err)
+	mpesa := services.NewMPesaService(&config.Config{}, db, nil)
+	ctx := context.Background()
+
+	invoice := pendingMpesaPayment(t, db, tenantID, "ws_CO_1", 1000)
+	require.NoError(t, db.Create(&models.MpesaSTKRequest{
+		ID: uuid.New().String(), TenantID: tenantID, InvoiceID: invoice.ID, ShortCode: "600111",
+		CredentialSource: models.MpesaCredentialsTenant, CheckoutRequestID: "ws_CO_1",
+	}).Error)
+
+	err = mpesa.ProcessSTKCallback(ctx, other.ID, stkCallback("ws_CO_1", 1000))
+	assert.ErrorIs(t, err, services.ErrMpesaCallbackTenant)
+	var reloaded models.Invoice
+	require.NoError(t, db.First(&reloaded, "id = ?", invoice.ID).Error)
+	assert.Equal(t, models.InvoiceStatusSent, reloaded.Status)
+
+	require.NoError(t, mpesa.ProcessSTKCallback(ctx, tenantID, stkCallback("ws_CO_1", 1000)))
+	require.NoError(t, db.First(&reloaded, "id = ?", invoice.ID).Error)
+	assert.Equal(t, models.InvoiceStatusPaid, reloaded.Status)
+
+	// Without a push record the payments decide, and they must agree
+	pendingMpesaPayment(t, db, tenantID, "ws_CO_2", 500)
+	pendingMpesaPayment(t, db, other.ID, "ws_CO_2", 500)
+	err = mpesa.ProcessSTKCallback(ctx, "", stkCallback("ws_CO_2", 500))
+	assert.ErrorIs(t, err, services.ErrMpesaCallbackAmbiguous)
+
+	otherInvoice := pendingMpesaPayment(t, db, other.ID, "ws_CO_3", 700)
+	require.NoError(t, mpesa.ProcessSTKCallback(ctx, "", stkCallback("ws_CO_3", 700)))
+	var settled models.Invoice
+	require.NoError(t, db.First(&settled, "id = ?", otherInvoice.ID).Error)
+	assert.Equal(t, models.InvoiceStatusPaid, settled.Status)
+}
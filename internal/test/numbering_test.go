@@ -0,0 +1,141 @@
+package services_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDocumentNumbering tests each tenant numbers its own documents through its
+// own pattern, and a sequence that resets starts again in a new fiscal year
+func TestDocumentNumbering(t *testing.T) {
+	settingsService, db, tenantID := setupTestService(t)
+	other, err := settingsService.CreateTenant("Other Tenant", "other")
+	require.NoError(t, err)
+	userID := uuid.New().String()
+	invoices := services.NewInvoiceService(db)
+	numbering := services.NewNumberingService(db)
+
+	create := func(tenant, clientID string) (*models.Invoice, error) {
+		return invoices.CreateInvoice(tenant, userID, clientID, &services.CreateInvoiceRequest{
+			ClientID: clientID,
+			Items:    []services.InvoiceItemRequest{{Description: "Consulting", Quantity: 1, UnitPrice: 100}},
+		})
+	}
+	clientID := createTestClient(t, db, tenantID)
+
+	// Invoice numbers are unique per tenant, so both start at INV-000001
+	first, err := create(tenantID, clientID)
+	require.NoError(t, err)
+	theirs, err := create(other.ID, createTestClient(t, db, other.ID))
+	require.NoError(t, err)
+	assert.Equal(t, "INV-000001", first.InvoiceNumber)
+	assert.Equal(t, "INV-000001", theirs.InvoiceNumber)
+
+	// An invoice saved without a number from the scheme is refused, not given one
+	err = db.Create(&models.Invoice{TenantID: tenantID, UserID: userID, ClientID: clientID, Currency: "KES"}).Error
+	assert.ErrorIs(t, err, models.ErrInvoiceNumberMissing)
+
+	text := func(s string) *string { return &s }
+	yes := true
+	_, err = numbering.UpdateScheme(tenantID, userID, models.SequenceDocumentInvoice, services.NumberingUpdate{Pattern: text("{PREFIX}/{DD}/{SEQ}")})
+	assert.ErrorIs(t, err, services.ErrNumberingPattern)
+	_, err = numbering.UpdateScheme(tenantID, userID, models.SequenceDocumentInvoice, services.NumberingUpdate{Pattern: text("{PREFIX}-{YYYY}")})
+	assert.ErrorIs(t, err, services.ErrNumberingPattern)
+	_, err = numbering.UpdateScheme(tenantID, userID, models.SequenceDocumentInvoice, services.NumberingUpdate{ResetYearly: &yes})
+	assert.ErrorIs(t, err, services.ErrNumberingReset, "INV-000001 would come round again")
+	_, err = numbering.UpdateScheme(tenantID, userID, "delivery_note", services.NumberingUpdate{Prefix: text("DEL")})
+	assert.ErrorIs(t, err, services.ErrNumberingDocumentType)
+
+	// A custom pattern carries on from the last number until the fiscal year ends
+	fy := time.Now().UTC().Year()
+	scheme, err := numbering.UpdateScheme(tenantID, userID, models.SequenceDocumentInvoice, services.NumberingUpdate{
+		Pattern: text("{PREFIX}/{FY}/{SEQ:5}"), ResetYearly: &yes,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("INV/%d/00002", fy), scheme.NextNumber)
+	second, err := create(tenantID, clientID)
+	require.NoError(t, err)
+	assert.Equal(t, scheme.NextNumber, second.InvoiceNumber)
+	assert.Equal(t, int64(2), second.SequenceNumber)
+
+	// Once the fiscal year the sequence was in has passed it starts again at 1
+	require.NoError(t, db.Model(&models.InvoiceSequence{}).
+		Where("tenant_id = ? AND document_type = ?", tenantID, models.SequenceDocumentInvoice).
+		Update("fiscal_year", fy-1).Error)
+	third, err := create(tenantID, clientID)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("INV/%d/00001", fy), third.InvoiceNumber)
+
+	// An invoice that isn't created gives its number back: the next number was
+	// issued before the reset, so the invoice is refused and the sequence stays put
+	_, err = create(tenantID, clientID)
+	assert.Error(t, err)
+	schemes, err := numbering.ListSchemes(tenantID)
+	require.NoError(t, err)
+	require.Len(t, schemes, 6)
+	assert.Equal(t, models.SequenceDocumentInvoice, schemes[0].DocumentType)
+	assert.Equal(t, int64(1), schemes[0].LastSequenceNum)
+	assert.Equal(t, fmt.Sprintf("INV/%d/00002", fy), schemes[0].NextNumber)
+	assert.Equal(t, "CN-000001", schemes[1].NextNumber)
+
+	// Turning the reset off carries on past every number issued, not just this year's
+	no := false
+	scheme, err = numbering.UpdateScheme(tenantID, userID, models.SequenceDocumentInvoice, services.NumberingUpdate{ResetYearly: &no})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), scheme.LastSequenceNum)
+	assert.Equal(t, fmt.Sprintf("INV/%d/00003", fy), scheme.NextNumber)
+	fourth, err := create(tenantID, clientID)
+	require.NoError(t, err)
+	assert.Equal(t, scheme.NextNumber, fourth.InvoiceNumber)
+
+	// A reset on the calendar year holds the fiscal year to a January start
+	periods := services.NewPeriodService(db)
+	_, err = numbering.UpdateScheme(tenantID, userID, models.SequenceDocumentQuote, services.NumberingUpdate{
+		Pattern: text("{PREFIX}-{YYYY}-{SEQ}"), ResetYearly: &yes,
+	})
+	require.NoError(t, err)
+	_, err = periods.SetFiscalYear(tenantID, userID, 7)
+	assert.ErrorIs(t, err, services.ErrNumberingReset)
+	config, err := periods.GetFiscalYear(tenantID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, config.StartMonth)
+
+	// A payment gets its receipt number in the transaction that completes it
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", fourth.ID).Update("status", models.InvoiceStatusSent).Error)
+	recorded := &models.Payment{Amount: models.ToCents(40), Method: models.PaymentMethodBank, Reference: "BANK-1"}
+	require.NoError(t, invoices.RecordPayment(tenantID, fourth.ID, recorded))
+	assert.Equal(t, "RCT-000001", recorded.ReceiptNumber)
+	var stored models.Payment
+	require.NoError(t, db.First(&stored, "id = ?", recorded.ID).Error)
+	assert.Equal(t, "RCT-000001", stored.ReceiptNumber)
+
+	// One completed before then is numbered once, when its receipt is first produced
+	payments := services.NewPaymentMatchingService(db, nil)
+	payment := &models.Payment{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: userID, InvoiceID: first.ID,
+		Amount: models.ToCents(100), Method: models.PaymentMethodMpesa, Status: models.PaymentStatusCompleted,
+	}
+	require.NoError(t, db.Create(payment).Error)
+	require.NoError(t, payments.IssueReceiptNumber(payment))
+	assert.Equal(t, "RCT-000002", payment.ReceiptNumber)
+	again, err := payments.GetPaymentByID(tenantID, payment.ID)
+	require.NoError(t, err)
+	require.NoError(t, payments.IssueReceiptNumber(again))
+	assert.Equal(t, "RCT-000002", again.ReceiptNumber)
+
+	pending := &models.Payment{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: userID, InvoiceID: first.ID,
+		Amount: models.ToCents(50), Method: models.PaymentMethodMpesa, Status: models.PaymentStatusPending,
+	}
+	require.NoError(t, db.Create(pending).Error)
+	require.NoError(t, payments.IssueReceiptNumber(pending))
+	assert.Empty(t, pending.ReceiptNumber)
+}
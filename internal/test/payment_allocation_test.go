@@ -0,0 +1,268 @@
+package services_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// Payment Allocation Tests
+// ============================================================
+
+func setupPaymentAllocation(t *testing.T) (*database.DB, *services.PaymentMatchingService, *models.Client) {
+	if os.Getenv("ENCRYPTION_KEY") == "" {
+		os.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-testing-only-1234567890")
+	}
+	models.InitEncryption(os.Getenv("ENCRYPTION_KEY"))
+
+	// Shared cache so concurrent allocations see the same database
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", uuid.New().String())
+	gdb, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{SkipDefaultTransaction: true})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(
+		&models.Client{}, &models.Invoice{}, &models.Payment{}, &models.UnallocatedPayment{},
+		&models.PaymentAllocation{}, &models.ClientCreditTransaction{}, &models.InvoiceSequence{},
+	))
+
+	client := &models.Client{
+		ID: uuid.New().String(), TenantID: uuid.New().String(), UserID: uuid.New().String(),
+		Name: "Jua Kali Ltd", Email: "accounts@juakali.co.ke", Currency: "KES",
+	}
+	require.NoError(t, db.Create(client).Error)
+	return db, services.NewPaymentMatchingService(db, nil), client
+}
+
+func allocationInvoice(t *testing.T, db *database.DB, client *models.Client, number string, total float64, dueInDays int) *models.Invoice {
+	invoice := &models.Invoice{
+		ID: uuid.New().String(), TenantID: client.TenantID, UserID: client.UserID, ClientID: client.ID,
+		InvoiceNumber: number, Currency: "KES", Total: models.ToCents(total), BalanceDue: models.ToCents(total),
+		Status: models.InvoiceStatusSent, InvoiceType: "invoice", DueDate: time.Now().AddDate(0, 0, dueInDays),
+		MagicToken: uuid.New().String(),
+	}
+	require.NoError(t, db.Create(invoice).Error)
+	return invoice
+}
+
+func reloadInvoice(t *testing.T, db *database.DB, id string) models.Invoice {
+	var invoice models.Invoice
+	require.NoError(t, db.First(&invoice, "id = ?", id).Error)
+	return invoice
+}
+
+// TestAllocatePaymentStrategies tests oldest-first, proportional and explicit splits
+func TestAllocatePaymentStrategies(t *testing.T) {
+	db, svc, client := setupPaymentAllocation(t)
+	older := allocationInvoice(t, db, client, "INV-000001", 1000, 7)
+	newer := allocationInvoice(t, db, client, "INV-000002", 3000, 30)
+	oldest := allocationInvoice(t, db, client, "INV-000003", 500, -10)
+
+	// Oldest due date is settled first, the rest goes part-way into the next one
+	result, err := svc.AllocatePayment(client.TenantID, client.UserID, &services.AllocatePaymentRequest{
+		ClientID: client.ID, Amount: 1200, Reference: "QHX12ABC34", IdempotencyKey: "bank-1",
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Allocations, 2)
+	assert.Equal(t, oldest.ID, result.Allocations[0].InvoiceID)
+	assert.Equal(t, models.ToCents(500), result.Allocations[0].Amount)
+	assert.Equal(t, models.ToCents(700), result.Allocations[1].Amount)
+	assert.Equal(t, models.InvoiceStatusPaid, reloadInvoice(t, db, oldest.ID).Status)
+	partial := reloadInvoice(t, db, older.ID)
+	assert.Equal(t, models.InvoiceStatusPartiallyPaid, partial.Status)
+	assert.Equal(t, models.ToCents(300), partial.BalanceDue)
+
+	again, err := svc.AllocatePayment(client.TenantID, client.UserID, &services.AllocatePaymentRequest{
+		ClientID: client.ID, Amount: 1200, IdempotencyKey: "bank-1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, result.Payment.ID, again.Payment.ID, "idempotency key returns the first allocation")
+
+	// Proportional to the remaining balances (300 and 3000)
+	result, err = svc.AllocatePayment(client.TenantID, client.UserID, &services.AllocatePaymentRequest{
+		ClientID: client.ID, Amount: 1100, Strategy: models.AllocationStrategyProportional,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Allocations, 2)
+	assert.Equal(t, models.ToCents(100), result.Allocations[0].Amount)
+	assert.Equal(t, models.ToCents(1000), result.Allocations[1].Amount)
+
+	// Explicit amounts may not exceed an invoice balance
+	_, err = svc.AllocatePayment(client.TenantID, client.UserID, &services.AllocatePaymentRequest{
+		ClientID: client.ID, Amount: 5000,
+		Allocations: []services.AllocationLine{{InvoiceID: newer.ID, Amount: 2500}},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, models.ToCents(2000), reloadInvoice(t, db, newer.ID).BalanceDue, "failed allocation changes nothing")
+
+	// Whatever is not allocated explicitly becomes client credit
+	result, err = svc.AllocatePayment(client.TenantID, client.UserID, &services.AllocatePaymentRequest{
+		ClientID: client.ID, Amount: 2600,
+		Allocations: []services.AllocationLine{{InvoiceID: older.ID, Amount: 200}, {InvoiceID: newer.ID, Amount: 2000}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, models.ToCents(400), result.Credit)
+	assert.Equal(t, models.InvoiceStatusPaid, reloadInvoice(t, db, older.ID).Status)
+	assert.Equal(t, models.InvoiceStatusPaid, reloadInvoice(t, db, newer.ID).Status)
+
+	balance, history, err := svc.GetClientCredit(client.TenantID, client.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ToCents(400), balance)
+	require.Len(t, history, 1)
+	assert.Equal(t, result.Payment.ID, history[0].PaymentID)
+
+	var reloaded models.Client
+	require.NoError(t, db.First(&reloaded, "id = ?", client.ID).Error)
+	assert.Equal(t, models.ToCents(1200+1100+2600), reloaded.TotalPaid)
+
+	allocations, err := svc.GetAllocations(client.TenantID, result.Payment.ID)
+	require.NoError(t, err)
+	require.Len(t, allocations, 2)
+	assert.Equal(t, "INV-000001", allocations[0].InvoiceNumber)
+
+	_, err = svc.AllocatePayment(client.TenantID, client.UserID, &services.AllocatePaymentRequest{ClientID: client.ID, Amount: 10})
+	assert.ErrorIs(t, err, services.ErrAllocationNoInvoices)
+}
+
+// TestAllocatePaymentConcurrently tests totals stay consistent when payments race for the same invoices
+func TestAllocatePaymentConcurrently(t *testing.T) {
+	db, svc, client := setupPaymentAllocation(t)
+	first := allocationInvoice(t, db, client, "INV-000001", 600, 5)
+	second := allocationInvoice(t, db, client, "INV-000002", 400, 10)
+
+	unallocated, err := svc.CreateUnallocated(client.TenantID, "QHX98ZYX76", "254712345678", 300)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.AllocatePayment(client.TenantID, client.UserID, &services.AllocatePaymentRequest{
+				ClientID: client.ID, Amount: 300,
+			})
+			errs <- err
+		}()
+	}
+	// The same M-Pesa payment can only be allocated once
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.AllocatePayment(client.TenantID, client.UserID, &services.AllocatePaymentRequest{
+				ClientID: client.ID, UnallocatedPaymentID: unallocated.ID,
+			})
+			if err != nil {
+				assert.ErrorIs(t, err, services.ErrUnallocatedAlreadyMatched)
+				return
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		require.NoError(t, err)
+		succeeded++
+	}
+	assert.Equal(t, 4, succeeded)
+
+	for _, id := range []string{first.ID, second.ID} {
+		invoice := reloadInvoice(t, db, id)
+		assert.Equal(t, models.InvoiceStatusPaid, invoice.Status, invoice.InvoiceNumber)
+		assert.Equal(t, invoice.Total, invoice.PaidAmount, invoice.InvoiceNumber)
+		assert.Zero(t, invoice.BalanceDue, invoice.InvoiceNumber)
+	}
+
+	var reloaded models.Client
+	require.NoError(t, db.First(&reloaded, "id = ?", client.ID).Error)
+	assert.Equal(t, models.ToCents(1200), reloaded.TotalPaid)
+	assert.Equal(t, models.ToCents(200), reloaded.CreditBalance)
+
+	var allocated models.Money
+	require.NoError(t, db.Model(&models.PaymentAllocation{}).Select("COALESCE(SUM(amount), 0)").Scan(&allocated).Error)
+	assert.Equal(t, models.ToCents(1000), allocated)
+}
+
+// TestWebhookPaymentUpdatesClientTotalPaid tests a gateway completion adds the
+// amount to the client's total paid in cents
+func TestWebhookPaymentUpdatesClientTotalPaid(t *testing.T) {
+	db, _, client := setupPaymentAllocation(t)
+	invoice := allocationInvoice(t, db, client, "INV-WH-1", 1500.50, 14)
+	require.NoError(t, db.Model(client).Update("total_paid", models.ToCents(100)).Error)
+
+	payment := &models.Payment{
+		ID: uuid.New().String(), TenantID: client.TenantID, InvoiceID: invoice.ID, UserID: client.UserID,
+		Amount: models.ToCents(1500.50), Currency: "KES", Method: models.PaymentMethodCard,
+		Status: models.PaymentStatusPending, Reference: "CARD-REF-1",
+	}
+	require.NoError(t, db.Create(payment).Error)
+
+	paymentService := services.NewPaymentService(db, nil)
+	require.NoError(t, paymentService.ProcessWebhook(context.Background(), client.TenantID, &services.WebhookPayload{
+		Event: "payment_successful", Reference: "CARD-REF-1", Amount: "1500.50", Currency: "KES",
+	}))
+
+	paid := reloadInvoice(t, db, invoice.ID)
+	assert.Equal(t, models.InvoiceStatusPaid, paid.Status)
+	assert.Equal(t, models.ToCents(1500.50), paid.PaidAmount)
+
+	var updated models.Client
+	require.NoError(t, db.First(&updated, "id = ?", client.ID).Error)
+	assert.Equal(t, models.ToCents(1600.50), updated.TotalPaid)
+}
+
+// TestRecordPaymentOverpaymentBecomesCredit tests a manual payment over the
+// balance keeps its full amount, settles the invoice and credits the rest
+func TestRecordPaymentOverpaymentBecomesCredit(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	invoices := services.NewInvoiceServiceWithDeps(db, &services.ServiceDependencies{})
+	clientID := createTestClient(t, db, tenantID)
+
+	invoice := ledgerInvoice(t, db, tenantID, "INV-OVER-1", "invoice", 1000, 160, 1160, time.Now())
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", invoice.ID).
+		Update("client_id", clientID).Error)
+
+	payment := &models.Payment{
+		TenantID: tenantID, Amount: models.ToCents(1500.25), Currency: "KES", Method: models.PaymentMethodBank,
+	}
+	require.NoError(t, invoices.RecordPayment(tenantID, invoice.ID, payment))
+
+	var recorded models.Payment
+	require.NoError(t, db.First(&recorded, "id = ?", payment.ID).Error)
+	assert.Equal(t, models.ToCents(1500.25), recorded.Amount, "the payment keeps the full receipt")
+
+	paid := reloadInvoice(t, db, invoice.ID)
+	assert.Equal(t, models.InvoiceStatusPaid, paid.Status)
+	assert.Equal(t, models.ToCents(1160), paid.PaidAmount)
+	assert.True(t, paid.BalanceDue.IsZero())
+
+	var client models.Client
+	require.NoError(t, db.First(&client, "id = ?", clientID).Error)
+	assert.Equal(t, models.ToCents(340.25), client.CreditBalance)
+	assert.Equal(t, models.ToCents(1500.25), client.TotalPaid)
+
+	var credit models.ClientCreditTransaction
+	require.NoError(t, db.First(&credit, "payment_id = ?", payment.ID).Error)
+	assert.Equal(t, models.ClientCreditOverpayment, credit.Type)
+	assert.Equal(t, models.ToCents(340.25), credit.Amount)
+}
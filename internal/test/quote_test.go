@@ -0,0 +1,179 @@
+package services_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// Quote Tests
+// ============================================================
+
+func setupQuotes(t *testing.T) (*database.DB, *services.QuoteService, string, string, *models.Client) {
+	if os.Getenv("ENCRYPTION_KEY") == "" {
+		os.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-testing-only-1234567890")
+	}
+	models.InitEncryption(os.Getenv("ENCRYPTION_KEY"))
+
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(
+		&models.Tenant{}, &models.Client{}, &models.Invoice{}, &models.InvoiceItem{},
+		&models.InvoiceSequence{}, &models.Quote{}, &models.QuoteItem{}, &models.TaxRate{},
+	))
+
+	tenantID, userID := uuid.New().String(), uuid.New().String()
+	client := &models.Client{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: userID,
+		Name: "Acme Ltd", Email: "buyer@acme.co.ke", Currency: "KES", PaymentTerms: 14,
+	}
+	require.NoError(t, db.Create(client).Error)
+
+	return db, services.NewQuoteService(db), tenantID, userID, client
+}
+
+func quoteRequest(clientID string) *services.CreateQuoteRequest {
+	return &services.CreateQuoteRequest{
+		ClientID: clientID,
+		Title:    "Website redesign",
+		Discount: 100,
+		Items: []services.QuoteItemRequest{
+			{Description: "Design", Quantity: 2, UnitPrice: 1000, TaxRate: 16, DiscountRate: 10},
+			{Description: "Export support", Quantity: 1, UnitPrice: 500, TaxType: "zero_rated", TaxRate: 16},
+		},
+	}
+}
+
+// TestQuoteAcceptConvertsToInvoice tests portal acceptance creates an invoice with the quote's lines
+func TestQuoteAcceptConvertsToInvoice(t *testing.T) {
+	db, svc, tenantID, userID, client := setupQuotes(t)
+
+	quote, err := svc.CreateQuote(tenantID, userID, quoteRequest(client.ID))
+	require.NoError(t, err)
+	assert.Equal(t, "QUO-000001", quote.QuoteNumber)
+	assert.Equal(t, models.QuoteStatusDraft, quote.Status)
+	require.Len(t, quote.Items, 2)
+	assert.Equal(t, models.TaxTypeZeroRated, quote.Items[1].TaxType)
+	assert.Zero(t, quote.Items[1].TaxAmount, "zero-rated lines carry no tax")
+	assert.Equal(t, models.ToCents(288), quote.TotalTax) // (2000 - 10%) * 16%
+	assert.Equal(t, models.ToCents(2500+288-100), quote.Total)
+
+	_, err = svc.GetQuoteByMagicToken(quote.MagicToken)
+	assert.ErrorIs(t, err, services.ErrQuoteNotFound, "drafts are not visible in the portal")
+
+	_, err = svc.SendQuote(tenantID, quote.ID)
+	require.NoError(t, err)
+	viewed, err := svc.GetQuoteByMagicToken(quote.MagicToken)
+	require.NoError(t, err)
+	assert.Equal(t, models.QuoteStatusViewed, viewed.Status)
+
+	accepted, invoice, err := svc.AcceptQuoteByToken(quote.MagicToken)
+	require.NoError(t, err)
+	assert.Equal(t, models.QuoteStatusAccepted, accepted.Status)
+	assert.Equal(t, invoice.ID, accepted.ConvertedInvoiceID)
+
+	// Quote numbering does not consume invoice numbers
+	assert.Equal(t, "INV-000001", invoice.InvoiceNumber)
+	assert.Equal(t, models.InvoiceStatusDraft, invoice.Status)
+	assert.Equal(t, quote.Total, invoice.Total)
+	assert.Equal(t, quote.Discount, invoice.Discount)
+	assert.Equal(t, quote.QuoteNumber, invoice.Reference)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 14), invoice.DueDate, time.Minute)
+
+	var items []models.InvoiceItem
+	require.NoError(t, db.Where("invoice_id = ?", invoice.ID).Order("sort_order").Find(&items).Error)
+	require.Len(t, items, 2)
+	assert.Equal(t, 10.0, items[0].DiscountRate)
+	assert.Equal(t, quote.Items[0].DiscountAmt, items[0].DiscountAmt)
+	assert.Equal(t, models.TaxTypeZeroRated, items[1].TaxType)
+
+	_, _, err = svc.AcceptQuoteByToken(quote.MagicToken)
+	assert.Error(t, err, "a quote converts only once")
+	_, _, err = svc.ConvertToInvoice(tenantID, userID, quote.ID)
+	assert.ErrorIs(t, err, services.ErrQuoteAlreadyConverted)
+
+	var invoices int64
+	db.Model(&models.Invoice{}).Where("tenant_id = ?", tenantID).Count(&invoices)
+	assert.Equal(t, int64(1), invoices)
+}
+
+// TestQuoteDeclineAndExpiry tests declined and expired quotes cannot be accepted
+func TestQuoteDeclineAndExpiry(t *testing.T) {
+	db, svc, tenantID, userID, client := setupQuotes(t)
+
+	declined, err := svc.CreateQuote(tenantID, userID, quoteRequest(client.ID))
+	require.NoError(t, err)
+	_, err = svc.SendQuote(tenantID, declined.ID)
+	require.NoError(t, err)
+	result, err := svc.DeclineQuoteByToken(declined.MagicToken, "Too expensive")
+	require.NoError(t, err)
+	assert.Equal(t, models.QuoteStatusDeclined, result.Status)
+	_, _, err = svc.AcceptQuoteByToken(declined.MagicToken)
+	assert.ErrorIs(t, err, services.ErrQuoteNotOpen)
+
+	expired, err := svc.CreateQuote(tenantID, userID, quoteRequest(client.ID))
+	require.NoError(t, err)
+	assert.Equal(t, "QUO-000002", expired.QuoteNumber)
+	_, err = svc.SendQuote(tenantID, expired.ID)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.Quote{}).Where("id = ?", expired.ID).
+		Update("expiry_date", time.Now().Add(-time.Hour)).Error)
+	_, _, err = svc.AcceptQuoteByToken(expired.MagicToken)
+	assert.ErrorIs(t, err, services.ErrQuoteExpired)
+
+	won, err := svc.CreateQuote(tenantID, userID, quoteRequest(client.ID))
+	require.NoError(t, err)
+	_, _, err = svc.ConvertToInvoice(tenantID, userID, won.ID)
+	require.NoError(t, err)
+
+	_, err = svc.CreateQuote(tenantID, userID, quoteRequest(client.ID)) // still a draft
+	require.NoError(t, err)
+
+	report, err := services.NewReportService(db).GetQuoteConversion(tenantID, "30")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), report.TotalQuotes)
+	assert.Equal(t, int64(3), report.SentQuotes)
+	assert.Equal(t, int64(1), report.AcceptedQuotes)
+	assert.Equal(t, int64(1), report.DeclinedQuotes)
+	assert.Equal(t, int64(1), report.ExpiredQuotes)
+	assert.InDelta(t, 33.33, report.ConversionRate, 0.01)
+}
+
+// TestForeignCurrencyQuoteRate tests a USD quote is priced at the day's rate and
+// the converted invoice takes the rate of the day it is issued
+func TestForeignCurrencyQuoteRate(t *testing.T) {
+	db, svc, tenantID, userID, client := setupQuotes(t)
+	require.NoError(t, db.AutoMigrate(&models.ExchangeRate{}))
+	rates := services.NewExchangeRateService(db)
+	svc.SetExchangeRateService(rates)
+
+	_, err := rates.SetOverride(tenantID, userID, "USD", time.Now(), 130)
+	require.NoError(t, err)
+	req := quoteRequest(client.ID)
+	req.Currency = "USD"
+	quote, err := svc.CreateQuote(tenantID, userID, req)
+	require.NoError(t, err)
+	assert.Equal(t, 130.0, quote.ExchangeRate)
+
+	_, err = rates.SetOverride(tenantID, userID, "USD", time.Now(), 131)
+	require.NoError(t, err)
+	_, invoice, err := svc.ConvertToInvoice(tenantID, userID, quote.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "USD", invoice.Currency)
+	assert.Equal(t, 131.0, invoice.ExchangeRate)
+	assert.Equal(t, quote.Total.Mul(131), invoice.KESEquivalent)
+}
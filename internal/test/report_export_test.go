@@ -0,0 +1,145 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+	"invoicefast/internal/xlsx"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// Report Export Tests
+// ============================================================
+
+func setupReportExport(t *testing.T) (*services.ReportService, string) {
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(&models.Tenant{}, &models.Invoice{}, &models.Expense{}, &models.Payment{}))
+
+	tenantID := uuid.New().String()
+	require.NoError(t, db.Create(&models.Tenant{ID: tenantID, Name: "Report Tenant", Plan: "free", Currency: "KES"}).Error)
+
+	now := time.Now()
+	require.NoError(t, db.Create(&models.Invoice{
+		ID:            uuid.New().String(),
+		TenantID:      tenantID,
+		UserID:        uuid.New().String(),
+		ClientID:      uuid.New().String(),
+		InvoiceNumber: "INV-RPT-001",
+		Status:        models.InvoiceStatusPaid,
+		Currency:      "KES",
+		TaxRate:       16,
+		Total:         models.ToCents(11600),
+		TotalTax:      models.ToCents(1600),
+		PaidAmount:    models.ToCents(11600),
+		PaidAt:        &now,
+		DueDate:       now.AddDate(0, 0, 14),
+	}).Error)
+
+	// Two supplier invoices verified on eTIMS and one that isn't
+	for _, e := range []struct{ vendor, icn, status string }{
+		{"Nairobi Office Supplies", "KRACU0100000001/001", models.ExpenseETIMSVerified},
+		{"Mombasa Freight Ltd", "KRACU0100000002/001", models.ExpenseETIMSVerified},
+		{"Unregistered Vendor", "", models.ExpenseETIMSUnverified},
+	} {
+		require.NoError(t, db.Create(&models.Expense{
+			ID: uuid.New().String(), TenantID: tenantID, Title: "Supplies",
+			Amount: models.ToCents(1160), TaxAmount: models.ToCents(160), Currency: "KES", Date: now,
+			Vendor: e.vendor, SupplierPIN: "P051234567Q", SupplierICN: e.icn, ETIMSStatus: e.status,
+		}).Error)
+	}
+
+	return services.NewReportService(db), tenantID
+}
+
+func readXLSX(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+// TestExportReportXLSX tests excel exports are real workbooks with typed cells
+func TestExportReportXLSX(t *testing.T) {
+	reportSvc, tenantID := setupReportExport(t)
+
+	data, err := reportSvc.ExportReport(tenantID, "excel", "tax", "30")
+	require.NoError(t, err)
+	assert.Equal(t, "PK", string(data[:2]))
+
+	files := readXLSX(t, data)
+	require.Contains(t, files, "xl/workbook.xml")
+	assert.Contains(t, files["xl/workbook.xml"], `name="VAT Sales"`)
+	assert.Contains(t, files["xl/workbook.xml"], `name="VAT Purchases"`)
+	assert.Contains(t, files["xl/styles.xml"], "KES")
+
+	sales := files["xl/worksheets/sheet2.xml"]
+	assert.Contains(t, sales, `state="frozen"`)
+	assert.Regexp(t, `<c r="B5" s="3"><v>[1-9][0-9.]*</v></c>`, sales)
+	assert.NotContains(t, sales, "Generated:")
+
+	// Each claimed supplier invoice has a row of its own, then the total
+	purchases := files["xl/worksheets/sheet3.xml"]
+	assert.Contains(t, purchases, "Nairobi Office Supplies")
+	assert.Contains(t, purchases, "KRACU0100000002/001")
+	assert.NotContains(t, purchases, "Unregistered Vendor")
+	assert.Regexp(t, `<c r="G4" s="3"><v>320</v></c>`, purchases)
+
+	// Dates are stored as serial numbers with a date format
+	assert.Regexp(t, `<c r="B3" s="4"><v>\d+</v></c>`, files["xl/worksheets/sheet1.xml"])
+
+	for _, reportType := range []string{"overview", "revenue", "invoices", "payments", "clients", "aging", "fraud"} {
+		data, err := reportSvc.ExportReport(tenantID, "xlsx", reportType, "30")
+		require.NoError(t, err, reportType)
+		assert.Contains(t, readXLSX(t, data), "xl/worksheets/sheet1.xml", reportType)
+	}
+}
+
+// TestExportReportPDFUnavailable tests PDF exports fail cleanly without a generator
+func TestExportReportPDFUnavailable(t *testing.T) {
+	reportSvc, tenantID := setupReportExport(t)
+
+	_, err := reportSvc.ExportReport(tenantID, "pdf", "overview", "30")
+	assert.ErrorIs(t, err, services.ErrPDFExportUnavailable)
+
+	contentType, filename := services.ReportExportFile("excel", "tax")
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", contentType)
+	assert.Contains(t, filename, ".xlsx")
+
+	// Only known report types make it into the file name
+	_, filename = services.ReportExportFile("csv", "tax\r\nSet-Cookie: a=b")
+	assert.Regexp(t, `^report-overview-\d{8}\.csv$`, filename)
+
+	// Sheet names are cut to 31 characters, not bytes
+	wb := xlsx.NewWorkbook("KES")
+	first := wb.AddSheet(strings.Repeat("Ü", 40))
+	second := wb.AddSheet(strings.Repeat("Ü", 40))
+	assert.Equal(t, strings.Repeat("Ü", 31), first.Name())
+	assert.Equal(t, strings.Repeat("Ü", 27)+" (2)", second.Name())
+	assert.True(t, utf8.ValidString(second.Name()))
+}
@@ -0,0 +1,208 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// Tax Rate Catalogue Tests
+// ============================================================
+
+// TestTaxRateCatalogueByDate tests the seeded rates are picked by date and a rate change is a new row
+func TestTaxRateCatalogueByDate(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	taxRates := services.NewTaxRateService(db)
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		require.NoError(t, err)
+		return d
+	}
+
+	for date, want := range map[string]float64{"2019-06-30": 16, "2020-04-01": 14, "2020-12-31": 14, "2021-01-01": 16} {
+		vat, err := taxRates.DefaultVAT(tenantID, day(date))
+		require.NoError(t, err, date)
+		assert.Equal(t, want, vat.Rate, date)
+		assert.Equal(t, models.KRATaxCategoryStandard, vat.KRACategory)
+	}
+	exempt, err := taxRates.RateOn(tenantID, "exempt", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, models.KRATaxCategoryExempt, exempt.KRACategory)
+	_, err = taxRates.RateOn(tenantID, models.TaxCodeVAT, day("2010-01-01"))
+	assert.ErrorIs(t, err, services.ErrTaxRateNotInForce)
+
+	// Seeding again adds nothing
+	require.NoError(t, taxRates.EnsureDefaults(tenantID))
+	rates, err := taxRates.ListRates(tenantID, models.TaxKindVAT)
+	require.NoError(t, err)
+	assert.Len(t, rates, 7)
+
+	// A new rate can't start while the current one is still in force
+	today := time.Now().Truncate(24 * time.Hour)
+	err = taxRates.CreateRate(tenantID, &models.TaxRate{Code: "VAT", Name: "VAT", Rate: 17.5, ValidFrom: today})
+	assert.ErrorIs(t, err, services.ErrTaxRateOverlap)
+
+	current, err := taxRates.DefaultVAT(tenantID, today)
+	require.NoError(t, err)
+	yesterday := today.AddDate(0, 0, -1)
+	_, err = taxRates.UpdateRate(tenantID, current.ID, services.TaxRateUpdate{ValidTo: &yesterday})
+	require.NoError(t, err)
+	require.NoError(t, taxRates.CreateRate(tenantID, &models.TaxRate{Code: "vat", Name: "VAT", Rate: 17.5, ValidFrom: today}))
+
+	before, err := taxRates.DefaultVAT(tenantID, yesterday)
+	require.NoError(t, err)
+	assert.Equal(t, 16.0, before.Rate)
+	after, err := taxRates.DefaultVAT(tenantID, today)
+	require.NoError(t, err)
+	assert.Equal(t, 17.5, after.Rate)
+	assert.Equal(t, models.KRATaxCategoryStandard, after.KRACategory)
+
+	err = taxRates.CreateRate(tenantID, &models.TaxRate{Code: "LEVY", Name: "Levy", Kind: "duty", Rate: 2})
+	assert.ErrorIs(t, err, services.ErrTaxRateInvalid)
+	err = taxRates.CreateRate(tenantID, &models.TaxRate{Code: "EXEMPT2", Name: "Exempt", TaxType: models.TaxTypeExempt, Rate: 16})
+	assert.ErrorIs(t, err, services.ErrTaxRateInvalid)
+}
+
+// TestInvoiceUsesTaxCatalogue tests invoice lines are taxed from the catalogue and reported to eTIMS with their categories
+func TestInvoiceUsesTaxCatalogue(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	taxRates := services.NewTaxRateService(db)
+	invoices := services.NewInvoiceServiceWithDeps(db, &services.ServiceDependencies{TaxRates: taxRates})
+	clientID := createTestClient(t, db, tenantID)
+
+	require.NoError(t, taxRates.CreateRate(tenantID, &models.TaxRate{
+		Code: "EXC-WATER", Name: "Excise duty on bottled water", Kind: models.TaxKindExcise, Rate: 10,
+		ValidFrom: time.Now().AddDate(-1, 0, 0),
+	}))
+
+	invoice, err := invoices.CreateInvoice(tenantID, uuid.New().String(), clientID, &services.CreateInvoiceRequest{
+		ClientID: clientID,
+		Currency: "KES",
+		TaxCode:  "VAT",
+		Items: []services.InvoiceItemRequest{
+			{Description: "Consulting", Quantity: 1, UnitPrice: 1000},
+			{Description: "Bottled water", Quantity: 10, UnitPrice: 100, ExciseCode: "EXC-WATER"},
+			{Description: "Diesel", Quantity: 1, UnitPrice: 500, TaxCode: "VAT8"},
+			{Description: "Export freight", Quantity: 1, UnitPrice: 400, TaxCode: "ZERO"},
+			{Description: "Training", Quantity: 1, UnitPrice: 300, TaxCode: "EXEMPT"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 16.0, invoice.TaxRate, "the invoice takes the rate in force today")
+	assert.Equal(t, models.TaxCodeVAT, invoice.TaxCode)
+	require.Len(t, invoice.Items, 5)
+	consulting, water, diesel, export, training := invoice.Items[0], invoice.Items[1], invoice.Items[2], invoice.Items[3], invoice.Items[4]
+
+	assert.True(t, consulting.TaxAmount.Equals(models.ToCents(160)))
+	assert.Equal(t, models.KRATaxCategoryStandard, consulting.TaxCategory)
+	assert.True(t, water.ExciseDuty.Equals(models.ToCents(100)))
+	assert.True(t, water.TaxAmount.Equals(models.ToCents(176)), "VAT is due on the excise too")
+	assert.True(t, diesel.TaxAmount.Equals(models.ToCents(40)))
+	assert.Equal(t, models.KRATaxCategoryReduced, diesel.TaxCategory)
+	assert.True(t, export.TaxAmount.IsZero())
+	assert.Equal(t, models.KRATaxCategoryZeroRated, export.TaxCategory)
+	assert.True(t, training.TaxAmount.IsZero())
+	assert.Equal(t, models.KRATaxCategoryExempt, training.TaxCategory)
+
+	assert.True(t, invoice.ExciseDuty.Equals(models.ToCents(100)))
+	assert.True(t, invoice.TotalTax.Equals(models.ToCents(376)))
+	assert.True(t, invoice.Total.Equals(models.ToCents(3200+100+376)))
+
+	_, err = invoices.CreateInvoice(tenantID, uuid.New().String(), clientID, &services.CreateInvoiceRequest{
+		ClientID: clientID,
+		Items:    []services.InvoiceItemRequest{{Description: "Water", Quantity: 1, UnitPrice: 100, TaxCode: "EXC-WATER"}},
+	})
+	assert.ErrorIs(t, err, services.ErrTaxRateKind)
+
+	// The eTIMS payload reports each line at its own rate and category
+	kra := services.NewKRAServiceWithDB(&config.Config{}, db)
+	data := kra.ConvertInvoiceToKRA(invoice, &models.User{}, &invoice.Client)
+	assert.Equal(t, 16.0, data.VATRate)
+	require.Len(t, data.Items, 5)
+	assert.Equal(t, "B", data.Items[0].TaxCategory)
+	assert.Equal(t, 100.0, data.Items[1].ExciseDuty)
+	assert.Equal(t, 8.0, data.Items[2].VATRate)
+	assert.Equal(t, "E", data.Items[2].TaxCategory)
+	assert.Equal(t, 0.0, data.Items[3].VATRate)
+	assert.Equal(t, "C", data.Items[3].TaxCategory)
+	assert.Equal(t, "A", data.Items[4].TaxCategory)
+}
+
+// TestQuoteAndRecurringInvoicesTaxedOnInvoiceDate tests a converted quote and the
+// next invoice in a recurring series take the rates in force on the day they are raised
+func TestQuoteAndRecurringInvoicesTaxedOnInvoiceDate(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	taxRates := services.NewTaxRateService(db)
+	invoices := services.NewInvoiceServiceWithDeps(db, &services.ServiceDependencies{TaxRates: taxRates})
+	quotes := services.NewQuoteService(db)
+	userID := uuid.New().String()
+	clientID := createTestClient(t, db, tenantID)
+
+	require.NoError(t, taxRates.CreateRate(tenantID, &models.TaxRate{
+		Code: "EXC-WATER", Name: "Excise duty on bottled water", Kind: models.TaxKindExcise, Rate: 10,
+		ValidFrom: time.Now().AddDate(-1, 0, 0),
+	}))
+
+	quote, err := quotes.CreateQuote(tenantID, userID, &services.CreateQuoteRequest{
+		ClientID: clientID,
+		TaxCode:  "VAT",
+		Items: []services.QuoteItemRequest{
+			{Description: "Consulting", Quantity: 1, UnitPrice: 1000},
+			{Description: "Bottled water", Quantity: 10, UnitPrice: 100, ExciseCode: "EXC-WATER"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, models.TaxCodeVAT, quote.Items[0].TaxCode)
+	assert.True(t, quote.ExciseDuty.Equals(models.ToCents(100)))
+	assert.True(t, quote.TotalTax.Equals(models.ToCents(336)), "VAT is due on the excise too")
+	assert.True(t, quote.Total.Equals(models.ToCents(2000+100+336)))
+
+	parent, err := invoices.CreateInvoice(tenantID, userID, clientID, &services.CreateInvoiceRequest{
+		ClientID: clientID,
+		TaxCode:  "VAT",
+		Items:    []services.InvoiceItemRequest{{Description: "Retainer", Quantity: 1, UnitPrice: 1000}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", parent.ID).Updates(map[string]interface{}{
+		"is_recurring": true, "recurring_frequency": "monthly", "recurring_next_date": time.Now().Add(-time.Hour),
+	}).Error)
+
+	// VAT goes up after the quote is sent and the series started
+	today := time.Now().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+	current, err := taxRates.DefaultVAT(tenantID, today)
+	require.NoError(t, err)
+	_, err = taxRates.UpdateRate(tenantID, current.ID, services.TaxRateUpdate{ValidTo: &yesterday})
+	require.NoError(t, err)
+	require.NoError(t, taxRates.CreateRate(tenantID, &models.TaxRate{Code: "VAT", Name: "VAT", Rate: 17.5, ValidFrom: today}))
+
+	_, invoice, err := quotes.ConvertToInvoice(tenantID, userID, quote.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 17.5, invoice.TaxRate)
+	require.Len(t, invoice.Items, 2)
+	assert.Equal(t, 17.5, invoice.Items[0].TaxRate)
+	assert.Equal(t, models.KRATaxCategoryStandard, invoice.Items[0].TaxCategory)
+	assert.True(t, invoice.TotalTax.Equals(models.ToCents(175+192.5)))
+	assert.True(t, invoice.Total.Equals(models.ToCents(2000+100+367.5)))
+	assert.NoError(t, models.ValidateInvoiceTotals(invoice, invoice.Items))
+
+	recurring := services.NewRecurringInvoiceService(db, invoices, nil)
+	require.NoError(t, recurring.ProcessRecurringInvoices())
+	var next models.Invoice
+	require.NoError(t, db.Preload("Items").First(&next, "recurring_parent_id = ?", parent.ID).Error)
+	assert.Equal(t, 17.5, next.TaxRate)
+	require.Len(t, next.Items, 1)
+	assert.Equal(t, 17.5, next.Items[0].TaxRate)
+	assert.True(t, next.TotalTax.Equals(models.ToCents(175)))
+	assert.True(t, next.Total.Equals(models.ToCents(1175)))
+	assert.True(t, next.BalanceDue.Equals(next.Total))
+}
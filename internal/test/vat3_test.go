@@ -0,0 +1,125 @@
+package services_test
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createVATInvoice(t *testing.T, db *database.DB, tenantID string, client *models.Client, number string, net, rate float64) {
+	tax := net * rate / 100
+	require.NoError(t, db.Create(&models.Invoice{
+		ID:                  uuid.New().String(),
+		TenantID:            tenantID,
+		UserID:              uuid.New().String(),
+		ClientID:            client.ID,
+		InvoiceNumber:       number,
+		Currency:            "KES",
+		Subtotal:            models.ToCents(net),
+		TaxRate:             rate,
+		TotalTax:            models.ToCents(tax),
+		Total:               models.ToCents(net + tax),
+		Status:              models.InvoiceStatusSent,
+		BuyerClassification: services.SuggestBuyerType(client),
+		MagicToken:          uuid.New().String(),
+		DueDate:             time.Now().AddDate(0, 0, 14),
+	}).Error)
+}
+
+func readVAT3(t *testing.T, data []byte) map[string][][]string {
+	schedules := make(map[string][][]string)
+	for name, content := range readXLSX(t, data) {
+		rows, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+		require.NoError(t, err)
+		schedules[name] = rows
+	}
+	return schedules
+}
+
+// TestExportVAT3 tests the VAT3 schedules split sales by whether the buyer is registered,
+// claim only verified input VAT, and record each export of a month as a new filing
+func TestExportVAT3(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	reports := services.NewReportService(db)
+	userID := uuid.New().String()
+	period := time.Now().Format("2006-01")
+
+	registered := &models.Client{ID: uuid.New().String(), TenantID: tenantID, Name: "Acme Ltd", KRAPIN: "P051234567X", Currency: "KES"}
+	consumer := &models.Client{ID: uuid.New().String(), TenantID: tenantID, Name: "Jane Doe", Currency: "KES"}
+	require.NoError(t, db.Create(registered).Error)
+	require.NoError(t, db.Create(consumer).Error)
+	createVATInvoice(t, db, tenantID, registered, "INV-VAT-001", 10000, 16)
+	createVATInvoice(t, db, tenantID, consumer, "INV-VAT-002", 5000, 16)
+	createVATInvoice(t, db, tenantID, consumer, "INV-VAT-003", 3000, 0)
+
+	now := time.Now()
+	require.NoError(t, db.Create(&models.Expense{
+		ID: uuid.New().String(), TenantID: tenantID, Title: "Stationery", Vendor: "Office Mart", Date: now,
+		Amount: models.ToCents(2320), TaxAmount: models.ToCents(320), SupplierPIN: "P052345678Y", SupplierICN: "KRACU0100000001/77",
+		ETIMSStatus: models.ExpenseETIMSVerified, ETIMSVerifiedAt: &now,
+	}).Error)
+	require.NoError(t, db.Create(&models.Expense{
+		ID: uuid.New().String(), TenantID: tenantID, Title: "Fuel", Date: now,
+		Amount: models.ToCents(1160), TaxAmount: models.ToCents(160), ETIMSStatus: models.ExpenseETIMSUnverified,
+	}).Error)
+
+	export, err := reports.ExportVAT3(tenantID, userID, period, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, export.Filing.Version)
+	assert.True(t, export.Filing.OutputTax.Equals(models.ToCents(2400)))
+	assert.True(t, export.Filing.InputTax.Equals(models.ToCents(320)))
+	assert.True(t, export.Filing.NetVAT.Equals(models.ToCents(2080)))
+	assert.Len(t, export.Filing.Checksum, 64)
+
+	schedules := readVAT3(t, export.Data)
+	sectionB := schedules["B_sales_registered_customers.csv"]
+	require.Len(t, sectionB, 2)
+	assert.Equal(t, "PIN of Purchaser", sectionB[0][0])
+	assert.Equal(t, []string{"P051234567X", "Acme Ltd"}, sectionB[1][:2])
+	assert.Equal(t, now.Format("02/01/2006"), sectionB[1][3])
+	assert.Equal(t, []string{"10000.00", "1600.00"}, sectionB[1][6:8])
+	sectionC := schedules["C_sales_unregistered_customers.csv"]
+	require.Len(t, sectionC, 2, "the zero-rated sale isn't a VAT line")
+	assert.Equal(t, "INV-VAT-002", sectionC[1][3])
+	sectionF := schedules["F_purchases.csv"]
+	require.Len(t, sectionF, 2, "the unverified purchase isn't claimed")
+	assert.Equal(t, []string{"Local", "P052345678Y", "Office Mart"}, sectionF[1][:3])
+	assert.Equal(t, []string{"2000.00", "320.00"}, sectionF[1][8:10])
+	require.Contains(t, schedules, "summary.csv")
+
+	// The same month isn't exported twice unless asked to
+	_, err = reports.ExportVAT3(tenantID, userID, period, false)
+	assert.ErrorIs(t, err, services.ErrVAT3AlreadyExported)
+	again, err := reports.ExportVAT3(tenantID, userID, period, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, again.Filing.Version)
+	assert.Equal(t, export.Filing.Checksum, again.Filing.Checksum)
+	filings, err := reports.ListVATFilings(tenantID)
+	require.NoError(t, err)
+	assert.Len(t, filings, 2)
+
+	// B2B sales without a buyer PIN are reported instead of exported
+	business := &models.Client{ID: uuid.New().String(), TenantID: tenantID, Name: "No PIN Traders", PreferredBuyerType: "B2B", Currency: "KES"}
+	require.NoError(t, db.Create(business).Error)
+	createVATInvoice(t, db, tenantID, business, "INV-VAT-004", 1000, 16)
+	_, err = reports.ExportVAT3(tenantID, userID, period, true)
+	var invalid *services.VAT3ValidationError
+	require.ErrorAs(t, err, &invalid)
+	require.Len(t, invalid.Issues, 1)
+	assert.Equal(t, "B", invalid.Issues[0].Section)
+	assert.Equal(t, "INV-VAT-004", invalid.Issues[0].Reference)
+
+	_, err = reports.ExportVAT3(tenantID, userID, time.Now().AddDate(0, 2, 0).Format("2006-01"), false)
+	assert.ErrorIs(t, err, services.ErrVAT3PeriodInvalid)
+	_, err = reports.ExportVAT3(tenantID, userID, "2026-13", false)
+	assert.ErrorIs(t, err, services.ErrVAT3PeriodInvalid)
+}
@@ -0,0 +1,202 @@
+package services_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"invoicefast/internal/config"
+	"invoicefast/internal/database"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// Outgoing Webhook Tests
+// ============================================================
+
+func setupOutgoingWebhooks(t *testing.T) (*database.DB, *services.JobQueueService, *services.OutgoingWebhookService) {
+	if os.Getenv("ENCRYPTION_KEY") == "" {
+		os.Setenv("ENCRYPTION_KEY", "test-encryption-key-for-testing-only-1234567890")
+	}
+	models.InitEncryption(os.Getenv("ENCRYPTION_KEY"))
+
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	db := &database.DB{DB: gdb}
+	require.NoError(t, db.AutoMigrate(&models.AutomationJob{}, &models.WebhookEndpoint{}, &models.WebhookDelivery{}))
+
+	cfg := &config.Config{Server: config.ServerConfig{Mode: "test"}}
+	queue := services.NewJobQueueService(db)
+	svc := services.NewOutgoingWebhookService(db, queue, cfg)
+	svc.SetAllowPrivateNetworks(true) // the test servers listen on loopback
+	return db, queue, svc
+}
+
+func webhookJob(t *testing.T, db *database.DB, delivery *models.WebhookDelivery) *models.AutomationJob {
+	var job models.AutomationJob
+	require.NoError(t, db.First(&job, "id = ?", delivery.JobID).Error)
+	return &job
+}
+
+// TestOutgoingWebhookSignedDelivery tests deliveries are signed with the endpoint secret and logged
+func TestOutgoingWebhookSignedDelivery(t *testing.T) {
+	db, _, svc := setupOutgoingWebhooks(t)
+
+	var secret string
+	var verified atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		result := services.NewWebhookVerifier(&config.Config{}).
+			VerifyGenericWebhook(body, r.Header.Get(services.WebhookSignatureHeader), secret)
+		verified.Store(result.Valid && r.Header.Get(services.WebhookEventHeader) == services.EventInvoicePaid)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	tenantID := uuid.New().String()
+	endpoint, secret, err := svc.CreateEndpoint(tenantID, &services.WebhookEndpointRequest{
+		URL:    server.URL,
+		Events: []string{services.EventInvoicePaid},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, secret, "whsec_")
+
+	var stored models.WebhookEndpoint
+	require.NoError(t, db.First(&stored, "id = ?", endpoint.ID).Error)
+	assert.NotEqual(t, secret, stored.Secret)
+
+	// Unsubscribed events are not delivered
+	deliveries, err := svc.Publish(tenantID, services.EventInvoiceSent, nil, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	deliveries, err = svc.Publish(tenantID, services.EventInvoicePaid, map[string]interface{}{"invoice_id": "inv-1"}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	require.NoError(t, svc.Deliver(context.Background(), webhookJob(t, db, &deliveries[0])))
+	assert.True(t, verified.Load())
+
+	var delivery models.WebhookDelivery
+	require.NoError(t, db.First(&delivery, "id = ?", deliveries[0].ID).Error)
+	assert.Equal(t, models.WebhookDeliverySucceeded, delivery.Status)
+	assert.Equal(t, http.StatusOK, delivery.ResponseCode)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.NotNil(t, delivery.DeliveredAt)
+
+	// Rotation changes the signing secret for later deliveries
+	newSecret, err := svc.RotateSecret(tenantID, endpoint.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, newSecret)
+	secret = newSecret
+
+	replay, err := svc.ReplayDelivery(tenantID, delivery.ID)
+	require.NoError(t, err)
+	require.NotNil(t, replay.ReplayOfID)
+	assert.Equal(t, delivery.ID, *replay.ReplayOfID)
+	assert.Equal(t, delivery.Payload, replay.Payload)
+
+	verified.Store(false)
+	require.NoError(t, svc.Deliver(context.Background(), webhookJob(t, db, replay)))
+	assert.True(t, verified.Load())
+}
+
+// TestOutgoingWebhookFailedDelivery tests non-2xx responses are retried and recorded
+func TestOutgoingWebhookFailedDelivery(t *testing.T) {
+	db, _, svc := setupOutgoingWebhooks(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(server.Close)
+
+	tenantID := uuid.New().String()
+	endpoint, _, err := svc.CreateEndpoint(tenantID, &services.WebhookEndpointRequest{URL: server.URL, Events: []string{"*"}})
+	require.NoError(t, err)
+
+	deliveries, err := svc.Publish(tenantID, services.EventKRAAccepted, nil, time.Now())
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	job := webhookJob(t, db, &deliveries[0])
+	assert.Error(t, svc.Deliver(context.Background(), job))
+
+	var delivery models.WebhookDelivery
+	require.NoError(t, db.First(&delivery, "id = ?", deliveries[0].ID).Error)
+	assert.Equal(t, models.WebhookDeliveryRetrying, delivery.Status)
+	assert.Equal(t, http.StatusBadGateway, delivery.ResponseCode)
+
+	// Last attempt marks the delivery failed and counts against the endpoint
+	job.RetryCount = job.MaxRetries - 1
+	assert.Error(t, svc.Deliver(context.Background(), job))
+	require.NoError(t, db.First(&delivery, "id = ?", deliveries[0].ID).Error)
+	assert.Equal(t, models.WebhookDeliveryFailed, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+
+	var stored models.WebhookEndpoint
+	require.NoError(t, db.First(&stored, "id = ?", endpoint.ID).Error)
+	assert.Equal(t, 1, stored.FailureCount)
+	assert.Equal(t, http.StatusBadGateway, stored.LastStatusCode)
+
+	_, _, err = svc.CreateEndpoint(tenantID, &services.WebhookEndpointRequest{URL: server.URL, Events: []string{"invoice.deleted"}})
+	assert.Error(t, err)
+}
+
+// TestOutgoingWebhookRefusesPrivateEndpoints tests endpoints can't point
+// deliveries at loopback, link-local or private addresses
+func TestOutgoingWebhookRefusesPrivateEndpoints(t *testing.T) {
+	db, queue, _ := setupOutgoingWebhooks(t)
+	svc := services.NewOutgoingWebhookService(db, queue, &config.Config{Server: config.ServerConfig{Mode: "test"}})
+	tenantID := uuid.New().String()
+
+	for _, target := range []string{
+		"http://127.0.0.1:8080/hooks",
+		"http://localhost/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.5/hooks",
+		"http://192.168.1.10/hooks",
+		"http://[::1]/hooks",
+		"http://0.0.0.0/hooks",
+	} {
+		_, _, err := svc.CreateEndpoint(tenantID, &services.WebhookEndpointRequest{URL: target, Events: []string{"*"}})
+		assert.ErrorIs(t, err, services.ErrWebhookEndpointPrivate, target)
+	}
+
+	// An endpoint that reaches a private address anyway, such as a name that
+	// resolves differently later, is refused when the delivery connects
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	secret, err := models.EncryptValue("whsec_test")
+	require.NoError(t, err)
+	endpoint := &models.WebhookEndpoint{
+		ID: uuid.New().String(), TenantID: tenantID, URL: server.URL, Events: "*", Secret: secret, IsActive: true,
+	}
+	require.NoError(t, db.Create(endpoint).Error)
+	deliveries, err := svc.Publish(tenantID, services.EventInvoicePaid, nil, time.Now())
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	err = svc.Deliver(context.Background(), webhookJob(t, db, &deliveries[0]))
+	assert.ErrorIs(t, err, services.ErrWebhookEndpointPrivate)
+	assert.Zero(t, hits.Load())
+}
@@ -0,0 +1,133 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// Withholding Tests
+// ============================================================
+
+// TestWithholdingSettlesInvoice tests a payment net of withholding VAT settles the invoice and posts the deduction
+func TestWithholdingSettlesInvoice(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	invoices := services.NewInvoiceServiceWithDeps(db, &services.ServiceDependencies{})
+	clientID := createTestClient(t, db, tenantID)
+	now := time.Now()
+
+	// 100,000 + 16% VAT; the client withholds 2% of the taxable value
+	invoice := ledgerInvoice(t, db, tenantID, "INV-W-001", "invoice", 100000, 16000, 116000, now)
+	require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", invoice.ID).
+		Updates(map[string]interface{}{"client_id": clientID, "tax_rate": 16}).Error)
+
+	payment := &models.Payment{
+		TenantID: tenantID, Amount: models.ToCents(114000), Currency: "KES", Method: models.PaymentMethodBank,
+		Withholdings: []models.WithholdingCertificate{{Type: "vat", Amount: models.ToCents(2000)}},
+	}
+	require.NoError(t, invoices.RecordPayment(tenantID, invoice.ID, payment))
+	assert.True(t, payment.Withheld.Equals(models.ToCents(2000)))
+
+	var settled models.Invoice
+	require.NoError(t, db.First(&settled, "id = ?", invoice.ID).Error)
+	assert.Equal(t, models.InvoiceStatusPaid, settled.Status, "the withholding settles the invoice")
+	assert.True(t, settled.PaidAmount.Equals(models.ToCents(116000)))
+
+	var certificate models.WithholdingCertificate
+	require.NoError(t, db.First(&certificate, "payment_id = ?", payment.ID).Error)
+	assert.Equal(t, clientID, certificate.ClientID)
+	assert.Equal(t, models.WithholdingPending, certificate.Status)
+	assert.True(t, certificate.Expected.Equals(models.ToCents(2000)))
+
+	// VAT can't be withheld from an invoice without VAT
+	exempt := ledgerInvoice(t, db, tenantID, "INV-W-002", "invoice", 5000, 0, 5000, now)
+	err := invoices.RecordPayment(tenantID, exempt.ID, &models.Payment{
+		TenantID: tenantID, Amount: models.ToCents(4900), Currency: "KES", Method: models.PaymentMethodBank,
+		Withholdings: []models.WithholdingCertificate{{Type: "vat", Amount: models.ToCents(100)}},
+	})
+	assert.ErrorIs(t, err, services.ErrWithholdingNoVAT)
+
+	// The deduction is held as a receivable until it's claimed
+	ledger := services.NewLedgerService(db)
+	_, err = ledger.PostPending(tenantID)
+	require.NoError(t, err)
+	tb, err := ledger.TrialBalance(tenantID, now.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.True(t, tb.Balanced)
+	assert.True(t, accountBalance(tb, "1010").Equals(models.ToCents(114000)))
+	assert.True(t, accountBalance(tb, "1310").Equals(models.ToCents(2000)))
+	assert.True(t, accountBalance(tb, "1100").Equals(models.ToCents(5000)), "only the exempt invoice is still owed")
+}
+
+// TestWithholdingCertificatesAndVATReturn tests certificates are tracked, reconciled and credited on the VAT return
+func TestWithholdingCertificatesAndVATReturn(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	invoices := services.NewInvoiceServiceWithDeps(db, &services.ServiceDependencies{})
+	withholding := services.NewWithholdingService(db)
+	reports := services.NewReportService(db)
+	clientID := createTestClient(t, db, tenantID)
+	now := time.Now()
+
+	pay := func(number string, cert string, vat float64) (*models.Payment, error) {
+		invoice := ledgerInvoice(t, db, tenantID, number, "invoice", 100000, 16000, 116000, now)
+		require.NoError(t, db.Model(&models.Invoice{}).Where("id = ?", invoice.ID).
+			Updates(map[string]interface{}{"client_id": clientID, "tax_rate": 16}).Error)
+		payment := &models.Payment{
+			TenantID: tenantID, Amount: models.ToCents(116000 - vat), Currency: "KES", Method: models.PaymentMethodBank,
+			Withholdings: []models.WithholdingCertificate{{Type: "vat", Amount: models.ToCents(vat), CertificateNumber: cert}},
+		}
+		return payment, invoices.RecordPayment(tenantID, invoice.ID, payment)
+	}
+
+	// One deduction still waiting for its certificate, one short by 500
+	_, err := pay("INV-W-010", "", 2000)
+	require.NoError(t, err)
+	_, err = pay("INV-W-011", "", 1500)
+	require.NoError(t, err)
+
+	report, err := reports.GetWithholdingReport(tenantID, "30")
+	require.NoError(t, err)
+	require.Len(t, report.Clients, 1)
+	row := report.Clients[0]
+	assert.Equal(t, "Test Client", row.ClientName)
+	assert.Equal(t, 2, row.Deductions)
+	assert.InDelta(t, 4000, row.Expected, 0.001)
+	assert.InDelta(t, 3500, row.Withheld, 0.001)
+	assert.InDelta(t, 3500, row.Outstanding, 0.001)
+	assert.InDelta(t, -500, row.Variance, 0.001)
+	assert.Len(t, report.Outstanding, 2)
+
+	pending, total, err := withholding.ListCertificates(tenantID, services.WithholdingFilter{ClientID: clientID, Status: models.WithholdingPending})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+
+	_, err = withholding.ReceiveCertificate(tenantID, uuid.New().String(), pending[0].ID, " ", now)
+	assert.ErrorIs(t, err, services.ErrWithholdingCertificate)
+	received, err := withholding.ReceiveCertificate(tenantID, uuid.New().String(), pending[0].ID, "KRAWHT0000001", now)
+	require.NoError(t, err)
+	assert.Equal(t, models.WithholdingReceived, received.Status)
+	_, err = withholding.ReceiveCertificate(tenantID, uuid.New().String(), pending[1].ID, "KRAWHT0000001", now)
+	assert.ErrorIs(t, err, services.ErrWithholdingDuplicate)
+	_, err = withholding.ReceiveCertificate(uuid.New().String(), uuid.New().String(), pending[1].ID, "KRAWHT0000002", now)
+	assert.ErrorIs(t, err, services.ErrWithholdingNotFound)
+
+	// Certificates received are credited on the VAT return
+	vat, err := reports.GetVATReport(tenantID, "30")
+	require.NoError(t, err)
+	assert.InDelta(t, 32000, vat.OutputTax, 0.001)
+	assert.InDelta(t, received.Amount.Float64(), vat.WithholdingVATCredit, 0.001)
+	assert.InDelta(t, 3500-received.Amount.Float64(), vat.WithholdingVATPending, 0.001)
+	require.NotNil(t, vat.VATReturn)
+	assert.InDelta(t, vat.WithholdingVATCredit, vat.VATReturn.Box9, 0.001)
+	assert.InDelta(t, 32000-vat.WithholdingVATCredit, vat.VATReturn.Box10, 0.001)
+
+	_, err = pay("INV-W-012", "KRAWHT0000001", 2000)
+	assert.ErrorIs(t, err, services.ErrWithholdingDuplicate, "a certificate covers one deduction")
+}
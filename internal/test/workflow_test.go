@@ -0,0 +1,205 @@
+package services_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================
+// Workflow Condition Tests
+// ============================================================
+
+// TestEvaluateWorkflowConditions tests operator evaluation against event payloads
+func TestEvaluateWorkflowConditions(t *testing.T) {
+	payload := map[string]interface{}{
+		"total":        25000.0,
+		"currency":     "KES",
+		"status":       "paid",
+		"client_email": "billing@acme.co.ke",
+		"client": map[string]interface{}{
+			"country": "KE",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		conditions []models.WorkflowCondition
+		want       bool
+	}{
+		{"no conditions", nil, true},
+		{"equals case-insensitive", []models.WorkflowCondition{{Field: "currency", Operator: "equals", Value: "kes"}}, true},
+		{"equals numeric string", []models.WorkflowCondition{{Field: "total", Operator: "equals", Value: "25000"}}, true},
+		{"not equals", []models.WorkflowCondition{{Field: "status", Operator: "not_equals", Value: "sent"}}, true},
+		{"contains", []models.WorkflowCondition{{Field: "client_email", Operator: "contains", Value: "acme"}}, true},
+		{"greater than", []models.WorkflowCondition{{Field: "total", Operator: "greater_than", Value: 10000.0}}, true},
+		{"less than fails", []models.WorkflowCondition{{Field: "total", Operator: "less_than", Value: 10000.0}}, false},
+		{"in list", []models.WorkflowCondition{{Field: "currency", Operator: "in", Value: []interface{}{"USD", "KES"}}}, true},
+		{"in comma string", []models.WorkflowCondition{{Field: "currency", Operator: "in", Value: "USD, EUR"}}, false},
+		{"not in", []models.WorkflowCondition{{Field: "status", Operator: "not_in", Value: []interface{}{"draft", "cancelled"}}}, true},
+		{"dotted path", []models.WorkflowCondition{{Field: "client.country", Operator: "equals", Value: "KE"}}, true},
+		{"missing field", []models.WorkflowCondition{{Field: "unknown", Operator: "equals", Value: "x"}}, false},
+		{"unknown operator", []models.WorkflowCondition{{Field: "status", Operator: "matches", Value: "paid"}}, false},
+		{
+			"all must match",
+			[]models.WorkflowCondition{
+				{Field: "currency", Operator: "equals", Value: "KES"},
+				{Field: "total", Operator: "greater_than", Value: 50000.0},
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, services.EvaluateWorkflowConditions(tt.conditions, payload))
+		})
+	}
+}
+
+// TestWorkflowDelayedActionQueued tests a delayed action is persisted as a
+// workflow job instead of holding the event worker, and the run finishes when
+// the job is dispatched
+func TestWorkflowDelayedActionQueued(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer server.Close()
+
+	actions, err := json.Marshal([]models.WorkflowAction{
+		{ActionType: services.WorkflowActionWebhook, Order: 2, Delay: 3600, Config: map[string]interface{}{"url": server.URL}},
+		{ActionType: services.WorkflowActionWebhook, Order: 1, Config: map[string]interface{}{"url": server.URL}},
+	})
+	require.NoError(t, err)
+	workflow := &models.AutomationWorkflow{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(), Name: "Follow up",
+		TriggerEvent: models.TriggerEventInvoiceSent, Actions: string(actions), IsActive: true,
+	}
+	require.NoError(t, db.Create(workflow).Error)
+
+	engine := services.NewWorkflowEngine(db, nil)
+	webhooks := services.NewOutgoingWebhookService(db, services.NewJobQueueService(db), nil)
+	webhooks.SetAllowPrivateNetworks(true)
+	engine.SetWebhookService(webhooks)
+	evt := &services.WorkflowEvent{
+		TenantID: tenantID, Event: models.TriggerEventInvoiceSent, EntityType: "invoice",
+		EntityID: uuid.New().String(), Data: map[string]interface{}{}, OccurredAt: time.Now(),
+	}
+	start := time.Now()
+	require.NoError(t, engine.RunWorkflow(workflow, evt))
+	assert.Less(t, time.Since(start), time.Minute)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits), "only the undelayed action runs straight away")
+
+	var jobs []models.AutomationJob
+	require.NoError(t, db.Where("tenant_id = ? AND job_type = ?", tenantID, models.JobTypeWorkflow).Find(&jobs).Error)
+	require.Len(t, jobs, 1)
+	assert.WithinDuration(t, start.Add(time.Hour), jobs[0].RunAt, time.Minute)
+
+	var stored models.AutomationWorkflow
+	require.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+	assert.Equal(t, 0, stored.TotalRuns, "the run is not finished until the delayed action has run")
+
+	require.NoError(t, engine.RunWorkflowJob(&jobs[0]))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+	require.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+	assert.Equal(t, 1, stored.TotalRuns)
+	assert.Equal(t, 1, stored.SuccessRuns)
+
+	var count int64
+	db.Model(&models.AutomationJob{}).Where("tenant_id = ?", tenantID).Count(&count)
+	assert.Equal(t, int64(1), count, "the resumed run does not queue its served delay again")
+}
+
+// TestWorkflowEventPersistedWhenBusStopped tests events published while the bus
+// cannot take them are kept as workflow jobs rather than dropped
+func TestWorkflowEventPersistedWhenBusStopped(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	engine := services.NewWorkflowEngine(db, nil)
+	webhooks := services.NewOutgoingWebhookService(db, services.NewJobQueueService(db), nil)
+	webhooks.SetAllowPrivateNetworks(true)
+	engine.SetWebhookService(webhooks)
+	_, _, err := webhooks.CreateEndpoint(tenantID, &services.WebhookEndpointRequest{
+		URL: "http://127.0.0.1:9/hooks", Events: []string{services.EventInvoicePaid},
+	})
+	require.NoError(t, err)
+	engine.Stop()
+
+	engine.Publish(&services.WorkflowEvent{
+		TenantID: tenantID, Event: models.TriggerEventInvoicePaid, EntityType: "invoice", EntityID: uuid.New().String(),
+	})
+
+	// The webhook delivery is stored as the event is published, not when it is handled
+	var deliveries int64
+	db.Model(&models.WebhookDelivery{}).Where("tenant_id = ?", tenantID).Count(&deliveries)
+	assert.Equal(t, int64(1), deliveries)
+
+	var jobs []models.AutomationJob
+	require.NoError(t, db.Where("tenant_id = ? AND job_type = ?", tenantID, models.JobTypeWorkflow).Find(&jobs).Error)
+	require.Len(t, jobs, 1)
+	assert.Contains(t, jobs[0].Payload, models.TriggerEventInvoicePaid)
+	assert.NoError(t, engine.RunWorkflowJob(&jobs[0]))
+	db.Model(&models.WebhookDelivery{}).Where("tenant_id = ?", tenantID).Count(&deliveries)
+	assert.Equal(t, int64(1), deliveries, "handling the event doesn't deliver it twice")
+}
+
+// TestWorkflowWebhookActionGuarded tests webhook actions can't be aimed at
+// private networks, neither when the workflow is saved nor when it runs
+func TestWorkflowWebhookActionGuarded(t *testing.T) {
+	_, db, tenantID := setupTestService(t)
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer server.Close()
+
+	jobQueue := services.NewJobQueueService(db)
+	webhooks := services.NewOutgoingWebhookService(db, jobQueue, nil)
+	workflows := services.NewAutoWorkflowService(db, jobQueue)
+	workflows.SetWebhookService(webhooks)
+
+	webhookAction := func(url string) *services.CreateWorkflowRequest {
+		return &services.CreateWorkflowRequest{
+			Name: "Notify", TriggerEvent: models.TriggerEventInvoiceSent,
+			Actions: []models.WorkflowAction{{ActionType: services.WorkflowActionWebhook, Config: map[string]interface{}{"url": url}}},
+		}
+	}
+	for _, url := range []string{server.URL, "http://localhost:8080/admin", "http://169.254.169.254/latest/meta-data", "http://10.0.0.5/hook"} {
+		_, err := workflows.CreateWorkflow(tenantID, uuid.New().String(), webhookAction(url))
+		assert.ErrorIs(t, err, services.ErrWebhookEndpointPrivate, url)
+	}
+	_, err := workflows.CreateWorkflow(tenantID, uuid.New().String(), webhookAction("ftp://203.0.113.10/hook"))
+	assert.Error(t, err)
+	_, err = workflows.CreateWorkflow(tenantID, uuid.New().String(), webhookAction("https://203.0.113.10/hook"))
+	assert.NoError(t, err)
+
+	// A workflow stored before the check is still refused when it runs
+	actions, err := json.Marshal([]models.WorkflowAction{
+		{ActionType: services.WorkflowActionWebhook, Config: map[string]interface{}{"url": server.URL}},
+	})
+	require.NoError(t, err)
+	workflow := &models.AutomationWorkflow{
+		ID: uuid.New().String(), TenantID: tenantID, UserID: uuid.New().String(), Name: "Legacy",
+		TriggerEvent: models.TriggerEventInvoiceSent, Actions: string(actions), IsActive: true,
+	}
+	require.NoError(t, db.Create(workflow).Error)
+
+	engine := services.NewWorkflowEngine(db, nil)
+	engine.SetWebhookService(webhooks)
+	engine.RunWorkflow(workflow, &services.WorkflowEvent{
+		TenantID: tenantID, Event: models.TriggerEventInvoiceSent, EntityType: "invoice",
+		EntityID: uuid.New().String(), Data: map[string]interface{}{}, OccurredAt: time.Now(),
+	})
+	assert.Zero(t, atomic.LoadInt32(&hits))
+}
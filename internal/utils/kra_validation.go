@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"fmt"
 	"strings"
 
 	"invoicefast/internal/models"
@@ -62,53 +61,6 @@ func CalculateTaxBreakdown(subtotal float64, vatRate float64, items []models.Inv
 	return
 }
 
-// GenerateInvoiceNumber generates sequential invoice number per tenant
-func GenerateInvoiceNumber(tenantID string, lastInvoiceNumber string) string {
-	if lastInvoiceNumber == "" {
-		// Start with INV/TENANTID/000001
-		return fmt.Sprintf("INV/%s/%06d", tenantID[:8], 1)
-	}
-
-	// Extract numeric part and increment
-	var num int
-	_, err := fmt.Sscanf(lastInvoiceNumber, "INV/%s/%d", &num)
-	if err != nil {
-		// Fallback: increment last number
-		num = 1
-		_, err = fmt.Sscanf(lastInvoiceNumber, "%*[^/]/%*[^/]/%d", &num)
-		if err != nil {
-			num = 1
-		}
-	}
-
-	return fmt.Sprintf("INV/%s/%06d", tenantID[:8], num+1)
-}
-
-// ValidateInvoiceSequentialNumbering ensures invoice numbers are sequential per tenant
-func ValidateInvoiceSequentialNumbering(tenantID, currentNumber, lastNumber string) bool {
-	if lastNumber == "" {
-		return true // First invoice
-	}
-
-	// Extract numbers
-	var current, last int
-	_, err1 := fmt.Sscanf(currentNumber, "INV/%s/%d", &current)
-	_, err2 := fmt.Sscanf(lastNumber, "INV/%s/%d", &last)
-
-	if err1 != nil || err2 != nil {
-		// Try alternative format
-		_, err1 = fmt.Sscanf(currentNumber, "%*[^/]/%*[^/]/%d", &current)
-		_, err2 = fmt.Sscanf(lastNumber, "%*[^/]/%*[^/]/%d", &last)
-		if err1 != nil || err2 != nil {
-			return false // Invalid format
-		}
-	}
-
-	return current == last+1 // Should be exactly one more than last
-}
-
-
-
 // MaskKRAPIN masks KRA PIN for display (show first 3 and last 1 chars)
 func MaskKRAPIN(pin string) string {
 	if pin == "" || len(pin) < 4 {
@@ -0,0 +1,240 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"invoicefast/internal/logger"
+	"invoicefast/internal/models"
+	"invoicefast/internal/services"
+)
+
+// JobHandler processes a single claimed AutomationJob. Returning an error
+// schedules a retry (or dead-letters the job once MaxRetries is reached).
+// Handlers may settle the job themselves via the JobQueueService; the
+// dispatcher only completes or fails jobs that are still processing.
+type JobHandler func(ctx context.Context, job *models.AutomationJob) error
+
+// JobDispatcher drains the AutomationJob queue with a fixed-size worker pool
+type JobDispatcher struct {
+	queue        *services.JobQueueService
+	handlers     map[string]JobHandler
+	mu           sync.RWMutex
+	workers      int
+	pollInterval time.Duration
+	jobTimeout   time.Duration
+	staleAfter   time.Duration
+	slots        chan struct{}
+	stopChan     chan struct{}
+	stopOnce     sync.Once
+	wg           sync.WaitGroup
+}
+
+func NewJobDispatcher(queue *services.JobQueueService, workers int) *JobDispatcher {
+	if workers <= 0 {
+		workers = 4
+	}
+	return &JobDispatcher{
+		queue:        queue,
+		handlers:     make(map[string]JobHandler),
+		workers:      workers,
+		pollInterval: 5 * time.Second,
+		jobTimeout:   5 * time.Minute,
+		staleAfter:   30 * time.Minute,
+		slots:        make(chan struct{}, workers),
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Register installs the handler for a job type, replacing any previous one
+func (d *JobDispatcher) Register(jobType string, handler JobHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[jobType] = handler
+}
+
+func (d *JobDispatcher) handler(jobType string) (JobHandler, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	h, ok := d.handlers[jobType]
+	return h, ok
+}
+
+func (d *JobDispatcher) jobTypes() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	types := make([]string, 0, len(d.handlers))
+	for t := range d.handlers {
+		types = append(types, t)
+	}
+	return types
+}
+
+func (d *JobDispatcher) Start(ctx context.Context) {
+	logger.Get().Info(ctx, "Starting job dispatcher", "workers", d.workers, "job_types", d.jobTypes())
+
+	d.wg.Add(1)
+	go d.pollLoop(ctx)
+}
+
+// Stop stops claiming new jobs and waits for in-flight jobs to finish
+func (d *JobDispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		logger.Get().Info(context.Background(), "Stopping job dispatcher")
+		close(d.stopChan)
+	})
+	d.wg.Wait()
+}
+
+func (d *JobDispatcher) pollLoop(ctx context.Context) {
+	defer d.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Get().Error(ctx, "panic recovered", "goroutine", "job_dispatcher", "recover", r)
+		}
+	}()
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+	staleTicker := time.NewTicker(d.staleAfter / 2)
+	defer staleTicker.Stop()
+
+	d.requeueStale(ctx)
+	d.dispatch(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.stopChan:
+			return
+		case <-staleTicker.C:
+			d.requeueStale(ctx)
+		case <-ticker.C:
+			d.dispatch(ctx)
+		}
+	}
+}
+
+// dispatch claims up to the number of free workers and hands each job to a
+// goroutine. ClaimJob is a conditional update on status, so when several
+// replicas poll the same rows only one of them wins each job.
+func (d *JobDispatcher) dispatch(ctx context.Context) {
+	free := cap(d.slots) - len(d.slots)
+	if free == 0 {
+		return
+	}
+	types := d.jobTypes()
+	if len(types) == 0 {
+		return
+	}
+
+	jobs, err := d.queue.GetPendingJobs(free, types...)
+	if err != nil {
+		logger.Get().Error(ctx, "Failed to load pending jobs", "error", err)
+		return
+	}
+
+	for i := range jobs {
+		job := jobs[i]
+		select {
+		case <-d.stopChan:
+			return
+		case d.slots <- struct{}{}:
+		}
+
+		if err := d.queue.ClaimJob(job.ID); err != nil {
+			<-d.slots
+			continue
+		}
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			defer func() { <-d.slots }()
+			d.run(ctx, &job)
+		}()
+	}
+}
+
+func (d *JobDispatcher) run(ctx context.Context, job *models.AutomationJob) {
+	handler, ok := d.handler(job.JobType)
+	if !ok {
+		// Handler was unregistered after the job was loaded; give it back
+		d.queue.ReleaseJob(job.ID)
+		return
+	}
+
+	jobCtx, cancel := context.WithTimeout(context.Background(), d.jobTimeout)
+	defer cancel()
+
+	// Handlers that overrun jobTimeout keep their lease for as long as they run,
+	// so the stale sweep never hands a live job to a second worker
+	done := make(chan struct{})
+	defer close(done)
+	go d.renewLease(ctx, job.ID, done)
+
+	started := time.Now()
+	err := d.execute(jobCtx, handler, job)
+
+	current, getErr := d.queue.GetJob(job.ID)
+	if getErr != nil {
+		logger.Get().Error(ctx, "Failed to reload job", "job_id", job.ID, "error", getErr)
+		return
+	}
+	if current.Status != models.JobStatusProcessing {
+		// The handler already completed or failed the job
+		return
+	}
+
+	if err != nil {
+		logger.Get().Warn(ctx, "Job failed", "job_id", job.ID, "job_type", job.JobType,
+			"attempt", current.RetryCount+1, "max_retries", current.MaxRetries, "error", err)
+		if failErr := d.queue.FailJob(job.ID, err.Error()); failErr != nil {
+			logger.Get().Debug(ctx, "Job retry state", "job_id", job.ID, "status", failErr.Error())
+		}
+		return
+	}
+
+	if err := d.queue.CompleteJob(job.ID, fmt.Sprintf("completed in %s", time.Since(started).Round(time.Millisecond))); err != nil {
+		logger.Get().Error(ctx, "Failed to complete job", "job_id", job.ID, "error", err)
+	}
+}
+
+// execute runs the handler and converts a panic into a job failure
+func (d *JobDispatcher) execute(ctx context.Context, handler JobHandler, job *models.AutomationJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// renewLease refreshes the job's claim until done is closed
+func (d *JobDispatcher) renewLease(ctx context.Context, jobID string, done <-chan struct{}) {
+	ticker := time.NewTicker(d.staleAfter / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := d.queue.RenewJob(jobID); err != nil {
+				logger.Get().Error(ctx, "Failed to renew job lease", "job_id", jobID, "error", err)
+			}
+		}
+	}
+}
+
+func (d *JobDispatcher) requeueStale(ctx context.Context) {
+	n, err := d.queue.RequeueStaleJobs(d.staleAfter)
+	if err != nil {
+		logger.Get().Error(ctx, "Failed to requeue stale jobs", "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Get().Warn(ctx, "Requeued stale jobs", "count", n)
+	}
+}
@@ -0,0 +1,191 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxReadRows guards against decompression bombs in uploaded workbooks
+const maxReadRows = 100000
+
+var ErrNotWorkbook = errors.New("file is not a valid .xlsx workbook")
+
+type xmlWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xmlRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xmlRichText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (r xmlRichText) String() string {
+	if len(r.Runs) == 0 {
+		return r.Text
+	}
+	var b strings.Builder
+	for _, run := range r.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+type xmlSharedStrings struct {
+	Items []xmlRichText `xml:"si"`
+}
+
+type xmlWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string      `xml:"r,attr"`
+			Type   string      `xml:"t,attr"`
+			Value  string      `xml:"v"`
+			Inline xmlRichText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// ReadRows returns the cell text of the first worksheet, one slice per row.
+// Numbers are returned as stored (dates as serial numbers, see DateFromSerial).
+func ReadRows(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, ErrNotWorkbook
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared xmlSharedStrings
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeZipXML(f, &shared); err != nil {
+			return nil, fmt.Errorf("failed to read shared strings: %w", err)
+		}
+	}
+
+	f, ok := files[sheetPath]
+	if !ok {
+		return nil, ErrNotWorkbook
+	}
+	var sheet xmlWorksheet
+	if err := decodeZipXML(f, &sheet); err != nil {
+		return nil, fmt.Errorf("failed to read worksheet: %w", err)
+	}
+	if len(sheet.Rows) > maxReadRows {
+		return nil, fmt.Errorf("worksheet has more than %d rows", maxReadRows)
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		var values []string
+		for i, c := range row.Cells {
+			col := i
+			if c.Ref != "" {
+				col = columnIndex(c.Ref)
+			}
+			for len(values) <= col {
+				values = append(values, "")
+			}
+
+			switch c.Type {
+			case "s":
+				var idx int
+				if _, err := fmt.Sscanf(c.Value, "%d", &idx); err == nil && idx >= 0 && idx < len(shared.Items) {
+					values[col] = shared.Items[idx].String()
+				}
+			case "inlineStr":
+				values[col] = c.Inline.String()
+			case "b":
+				if c.Value == "1" {
+					values[col] = "TRUE"
+				} else {
+					values[col] = "FALSE"
+				}
+			default:
+				values[col] = c.Value
+			}
+		}
+		rows = append(rows, values)
+	}
+	return rows, nil
+}
+
+// DateFromSerial converts an Excel 1900 date serial to a date
+func DateFromSerial(serial float64) time.Time {
+	days := int(serial)
+	seconds := int((serial - float64(days)) * 86400)
+	return excelEpoch.AddDate(0, 0, days).Add(time.Duration(seconds) * time.Second)
+}
+
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	wbFile, ok := files["xl/workbook.xml"]
+	if !ok {
+		return "", ErrNotWorkbook
+	}
+	var wb xmlWorkbook
+	if err := decodeZipXML(wbFile, &wb); err != nil || len(wb.Sheets) == 0 {
+		return "", ErrNotWorkbook
+	}
+
+	if relFile, ok := files["xl/_rels/workbook.xml.rels"]; ok {
+		var rels xmlRelationships
+		if err := decodeZipXML(relFile, &rels); err == nil {
+			for _, rel := range rels.Relationships {
+				if rel.ID != wb.Sheets[0].RID {
+					continue
+				}
+				if strings.HasPrefix(rel.Target, "/") {
+					return strings.TrimPrefix(rel.Target, "/"), nil
+				}
+				return path.Join("xl", rel.Target), nil
+			}
+		}
+	}
+	return "xl/worksheets/sheet1.xml", nil
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(io.LimitReader(rc, 64<<20)).Decode(v)
+}
+
+// columnIndex returns the zero based column of an A1 reference
+func columnIndex(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
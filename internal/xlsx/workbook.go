@@ -0,0 +1,431 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// XLSX - Minimal Office Open XML spreadsheet writer
+// ============================================================================
+
+// Kind identifies how a cell is stored and formatted
+type Kind int
+
+const (
+	KindText Kind = iota
+	KindInteger
+	KindNumber
+	KindMoney
+	KindPercent
+	KindDate
+)
+
+// Cell styles, indexes into cellXfs in styles.xml
+const (
+	styleDefault = iota
+	styleHeader
+	styleInteger
+	styleMoney
+	styleDate
+	stylePercent
+	styleNumber
+)
+
+const (
+	maxSheetName   = 31
+	minColumnWidth = 10
+	maxColumnWidth = 60
+)
+
+// excelEpoch is day zero of the 1900 date system (accounting for the 1900 leap year bug)
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Cell is a single typed value
+type Cell struct {
+	kind Kind
+	text string
+	num  float64
+	date time.Time
+}
+
+// Text returns a string cell
+func Text(s string) Cell { return Cell{kind: KindText, text: s} }
+
+// Integer returns a whole number cell formatted with thousands separators
+func Integer(n int64) Cell { return Cell{kind: KindInteger, num: float64(n)} }
+
+// Number returns a decimal cell with two places
+func Number(n float64) Cell { return Cell{kind: KindNumber, num: n} }
+
+// Money returns a cell formatted with the workbook currency
+func Money(n float64) Cell { return Cell{kind: KindMoney, num: n} }
+
+// Percent returns a percentage cell. The value is a percentage (12.5 = 12.5%).
+func Percent(n float64) Cell { return Cell{kind: KindPercent, num: n / 100} }
+
+// Date returns a date cell
+func Date(t time.Time) Cell { return Cell{kind: KindDate, date: t} }
+
+// Kind reports how the cell is stored
+func (c Cell) Kind() Kind { return c.kind }
+
+// Value returns the numeric value of the cell. Percentages are returned as entered.
+func (c Cell) Value() float64 {
+	if c.kind == KindPercent {
+		return c.num * 100
+	}
+	return c.num
+}
+
+// String renders the cell for display outside a spreadsheet
+func (c Cell) String() string {
+	switch c.kind {
+	case KindInteger:
+		return strconv.FormatInt(int64(c.num), 10)
+	case KindNumber, KindMoney:
+		return strconv.FormatFloat(c.num, 'f', 2, 64)
+	case KindPercent:
+		return strconv.FormatFloat(c.num*100, 'f', 2, 64) + "%"
+	case KindDate:
+		return c.date.Format("2006-01-02")
+	default:
+		return c.text
+	}
+}
+
+// Sheet is a worksheet with an optional frozen header row
+type Sheet struct {
+	name   string
+	header []string
+	rows   [][]Cell
+}
+
+// Name returns the sheet name as it appears in the workbook
+func (s *Sheet) Name() string { return s.name }
+
+// Header returns the header titles
+func (s *Sheet) Header() []string { return s.header }
+
+// Rows returns the data rows
+func (s *Sheet) Rows() [][]Cell { return s.rows }
+
+// SetHeader sets the bold, frozen first row
+func (s *Sheet) SetHeader(titles ...string) *Sheet {
+	s.header = titles
+	return s
+}
+
+// AddRow appends a row of cells
+func (s *Sheet) AddRow(cells ...Cell) *Sheet {
+	s.rows = append(s.rows, cells)
+	return s
+}
+
+// Workbook is a set of sheets written as a single .xlsx file
+type Workbook struct {
+	currency string
+	sheets   []*Sheet
+}
+
+// NewWorkbook creates an empty workbook. Money cells are prefixed with the currency code.
+func NewWorkbook(currency string) *Workbook {
+	return &Workbook{currency: currency}
+}
+
+// Sheets returns the sheets in order
+func (w *Workbook) Sheets() []*Sheet { return w.sheets }
+
+// AddSheet appends a sheet. Names are cleaned and made unique as Excel requires.
+func (w *Workbook) AddSheet(name string) *Sheet {
+	sheet := &Sheet{name: w.uniqueName(name)}
+	w.sheets = append(w.sheets, sheet)
+	return sheet
+}
+
+func (w *Workbook) uniqueName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", len(w.sheets)+1)
+	}
+	name = truncateRunes(name, maxSheetName)
+
+	candidate := name
+	for i := 2; w.hasSheet(candidate); i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		candidate = truncateRunes(name, maxSheetName-len(suffix)) + suffix
+	}
+	return candidate
+}
+
+// truncateRunes cuts s to at most n characters without splitting one
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+func (w *Workbook) hasSheet(name string) bool {
+	for _, s := range w.sheets {
+		if strings.EqualFold(s.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes writes the workbook as an .xlsx (zip) archive
+func (w *Workbook) Bytes() ([]byte, error) {
+	if len(w.sheets) == 0 {
+		w.AddSheet("Sheet1")
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", w.contentTypes()},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", w.workbookXML()},
+		{"xl/_rels/workbook.xml.rels", w.workbookRels()},
+		{"xl/styles.xml", w.stylesXML()},
+	}
+	for i, sheet := range w.sheets {
+		files = append(files, struct {
+			name    string
+			content string
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet.xml()})
+	}
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %w", f.name, err)
+		}
+		if _, err := fw.Write([]byte(f.content)); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize workbook: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const rootRels = xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+func (w *Workbook) contentTypes() string {
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	b.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := range w.sheets {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+func (w *Workbook) workbookXML() string {
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, sheet := range w.sheets {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(sheet.name), i+1, i+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	return b.String()
+}
+
+func (w *Workbook) workbookRels() string {
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := range w.sheets {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(w.sheets)+1)
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+// stylesXML defines the cell formats referenced by the style* constants
+func (w *Workbook) stylesXML() string {
+	moneyFormat := `#,##0.00;[Red]-#,##0.00`
+	if w.currency != "" {
+		moneyFormat = fmt.Sprintf(`"%[1]s "#,##0.00;[Red]-"%[1]s "#,##0.00`, w.currency)
+	}
+
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	fmt.Fprintf(&b, `<numFmts count="2"><numFmt numFmtId="164" formatCode="%s"/><numFmt numFmtId="165" formatCode="yyyy-mm-dd"/></numFmts>`, escape(moneyFormat))
+	b.WriteString(`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>`)
+	b.WriteString(`<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>`)
+	b.WriteString(`<fill><patternFill patternType="solid"><fgColor rgb="FFE7E6E6"/><bgColor indexed="64"/></patternFill></fill></fills>`)
+	b.WriteString(`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>`)
+	b.WriteString(`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>`)
+	b.WriteString(`<cellXfs count="7">`)
+	b.WriteString(`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>`)
+	b.WriteString(`<xf numFmtId="0" fontId="1" fillId="2" borderId="0" xfId="0" applyFont="1" applyFill="1"/>`)
+	b.WriteString(`<xf numFmtId="3" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`)
+	b.WriteString(`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`)
+	b.WriteString(`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`)
+	b.WriteString(`<xf numFmtId="10" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`)
+	b.WriteString(`<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`)
+	b.WriteString(`</cellXfs>`)
+	b.WriteString(`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>`)
+	b.WriteString(`</styleSheet>`)
+	return b.String()
+}
+
+func (s *Sheet) xml() string {
+	var b strings.Builder
+	b.WriteString(xmlHeader)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+
+	if len(s.header) > 0 {
+		b.WriteString(`<sheetViews><sheetView workbookViewId="0">`)
+		b.WriteString(`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`)
+		b.WriteString(`<selection pane="bottomLeft" activeCell="A2" sqref="A2"/>`)
+		b.WriteString(`</sheetView></sheetViews>`)
+	}
+
+	if widths := s.columnWidths(); len(widths) > 0 {
+		b.WriteString(`<cols>`)
+		for i, width := range widths {
+			fmt.Fprintf(&b, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width)
+		}
+		b.WriteString(`</cols>`)
+	}
+
+	b.WriteString(`<sheetData>`)
+	row := 1
+	if len(s.header) > 0 {
+		fmt.Fprintf(&b, `<row r="%d">`, row)
+		for col, title := range s.header {
+			writeInlineString(&b, cellRef(col, row), title, styleHeader)
+		}
+		b.WriteString(`</row>`)
+		row++
+	}
+	for _, cells := range s.rows {
+		fmt.Fprintf(&b, `<row r="%d">`, row)
+		for col, cell := range cells {
+			writeCell(&b, cellRef(col, row), cell)
+		}
+		b.WriteString(`</row>`)
+		row++
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// columnWidths sizes each column to its longest rendered value
+func (s *Sheet) columnWidths() []int {
+	var widths []int
+	grow := func(col, n int) {
+		for len(widths) <= col {
+			widths = append(widths, minColumnWidth)
+		}
+		if n+2 > widths[col] {
+			widths[col] = n + 2
+		}
+		if widths[col] > maxColumnWidth {
+			widths[col] = maxColumnWidth
+		}
+	}
+	for col, title := range s.header {
+		grow(col, len(title))
+	}
+	for _, cells := range s.rows {
+		for col, cell := range cells {
+			n := len(cell.String())
+			if cell.kind == KindMoney || cell.kind == KindInteger {
+				n += n / 3
+				if cell.kind == KindMoney {
+					n += 4
+				}
+			}
+			grow(col, n)
+		}
+	}
+	return widths
+}
+
+func writeCell(b *strings.Builder, ref string, cell Cell) {
+	switch cell.kind {
+	case KindText:
+		if cell.text == "" {
+			return
+		}
+		writeInlineString(b, ref, cell.text, styleDefault)
+	case KindDate:
+		if cell.date.IsZero() {
+			return
+		}
+		d := time.Date(cell.date.Year(), cell.date.Month(), cell.date.Day(), 0, 0, 0, 0, time.UTC)
+		fmt.Fprintf(b, `<c r="%s" s="%d"><v>%d</v></c>`, ref, styleDate, int(d.Sub(excelEpoch).Hours()/24))
+	default:
+		fmt.Fprintf(b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, numberStyle(cell.kind), strconv.FormatFloat(cell.num, 'f', -1, 64))
+	}
+}
+
+func numberStyle(kind Kind) int {
+	switch kind {
+	case KindInteger:
+		return styleInteger
+	case KindMoney:
+		return styleMoney
+	case KindPercent:
+		return stylePercent
+	default:
+		return styleNumber
+	}
+}
+
+func writeInlineString(b *strings.Builder, ref, text string, style int) {
+	fmt.Fprintf(b, `<c r="%s" t="inlineStr"`, ref)
+	if style != styleDefault {
+		fmt.Fprintf(b, ` s="%d"`, style)
+	}
+	fmt.Fprintf(b, `><is><t xml:space="preserve">%s</t></is></c>`, escape(text))
+}
+
+// cellRef converts a zero based column and one based row to A1 notation
+func cellRef(col, row int) string {
+	name := ""
+	for col >= 0 {
+		name = string(rune('A'+col%26)) + name
+		col = col/26 - 1
+	}
+	return name + strconv.Itoa(row)
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,42 @@
+-- Tenant webhook endpoints and their delivery log
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    url TEXT NOT NULL,
+    description TEXT,
+    events TEXT,
+    secret TEXT NOT NULL,
+    is_active BOOLEAN DEFAULT TRUE,
+    failure_count INTEGER DEFAULT 0,
+    last_status_code INTEGER,
+    last_delivery_at TIMESTAMP WITH TIME ZONE,
+    secret_rotated_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_tenant_id ON webhook_endpoints(tenant_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    endpoint_id UUID NOT NULL,
+    event VARCHAR(64),
+    event_id VARCHAR(64),
+    payload TEXT,
+    status VARCHAR(20),
+    attempts INTEGER DEFAULT 0,
+    response_code INTEGER,
+    response_body TEXT,
+    error TEXT,
+    duration_ms BIGINT,
+    job_id VARCHAR(64),
+    replay_of_id UUID,
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_tenant_id ON webhook_deliveries(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint_id ON webhook_deliveries(endpoint_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_event_id ON webhook_deliveries(event_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_created_at ON webhook_deliveries(created_at);
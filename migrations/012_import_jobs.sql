@@ -0,0 +1,26 @@
+-- Bulk import jobs (uploaded CSV/XLSX files with their mapping and last validation run)
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    user_id UUID,
+    entity_type VARCHAR(20),
+    file_name TEXT,
+    format VARCHAR(10),
+    file_data BYTEA,
+    headers TEXT,
+    mapping TEXT,
+    status VARCHAR(20),
+    total_rows INTEGER DEFAULT 0,
+    valid_rows INTEGER DEFAULT 0,
+    error_rows INTEGER DEFAULT 0,
+    created_count INTEGER DEFAULT 0,
+    skipped_count INTEGER DEFAULT 0,
+    errors TEXT,
+    committed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_tenant_id ON import_jobs(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_user_id ON import_jobs(user_id);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_entity_type ON import_jobs(entity_type);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_status ON import_jobs(status);
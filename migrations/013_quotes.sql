@@ -0,0 +1,67 @@
+-- Quotes / estimates and per-document-type numbering sequences
+
+-- Sequences are now kept per tenant and document type (invoice, quote)
+ALTER TABLE invoice_sequences ADD COLUMN IF NOT EXISTS document_type VARCHAR(20) DEFAULT 'invoice';
+UPDATE invoice_sequences SET document_type = 'invoice' WHERE document_type IS NULL OR document_type = '';
+ALTER TABLE invoice_sequences DROP CONSTRAINT IF EXISTS invoice_sequences_tenant_id_key;
+DROP INDEX IF EXISTS idx_invoice_sequences_tenant_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoice_sequences_tenant_document ON invoice_sequences(tenant_id, document_type);
+
+CREATE TABLE IF NOT EXISTS quotes (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    client_id UUID NOT NULL,
+    quote_number TEXT,
+    sequence_number BIGINT,
+    reference TEXT,
+    title TEXT,
+    currency VARCHAR(3) DEFAULT 'KES',
+    exchange_rate DOUBLE PRECISION DEFAULT 1,
+    subtotal BIGINT,
+    discount BIGINT DEFAULT 0,
+    tax_rate DOUBLE PRECISION DEFAULT 0,
+    total_tax BIGINT DEFAULT 0,
+    total BIGINT NOT NULL,
+    tax_type VARCHAR(20) DEFAULT 'standard',
+    status VARCHAR(20) DEFAULT 'draft',
+    expiry_date TIMESTAMP WITH TIME ZONE,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    viewed_at TIMESTAMP WITH TIME ZONE,
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    declined_at TIMESTAMP WITH TIME ZONE,
+    decline_reason TEXT,
+    converted_invoice_id TEXT,
+    converted_at TIMESTAMP WITH TIME ZONE,
+    notes TEXT,
+    terms TEXT,
+    magic_token TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quotes_tenant_number ON quotes(tenant_id, quote_number);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quotes_magic_token ON quotes(magic_token);
+CREATE INDEX IF NOT EXISTS idx_quotes_client_id ON quotes(client_id);
+CREATE INDEX IF NOT EXISTS idx_quotes_status ON quotes(status);
+CREATE INDEX IF NOT EXISTS idx_quotes_converted_invoice_id ON quotes(converted_invoice_id);
+
+CREATE TABLE IF NOT EXISTS quote_items (
+    id UUID PRIMARY KEY,
+    quote_id UUID NOT NULL,
+    description TEXT NOT NULL,
+    item_code VARCHAR(100),
+    quantity DOUBLE PRECISION DEFAULT 1,
+    unit_price BIGINT NOT NULL,
+    unit VARCHAR(50),
+    unit_of_measure VARCHAR(50),
+    tax_type VARCHAR(20) DEFAULT 'standard',
+    tax_rate DOUBLE PRECISION DEFAULT 0,
+    tax_amount BIGINT DEFAULT 0,
+    discount_rate DOUBLE PRECISION DEFAULT 0,
+    discount_amount BIGINT DEFAULT 0,
+    subtotal BIGINT,
+    total BIGINT NOT NULL,
+    sort_order INTEGER DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_quote_items_quote_id ON quote_items(quote_id);
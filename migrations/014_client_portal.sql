@@ -0,0 +1,21 @@
+-- Client self-service portal sessions (magic link or SMS code login)
+CREATE TABLE IF NOT EXISTS client_portal_sessions (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    client_id UUID NOT NULL,
+    channel VARCHAR(10) NOT NULL,
+    challenge_hash TEXT,
+    challenge_expires_at TIMESTAMP WITH TIME ZONE,
+    attempts INTEGER DEFAULT 0,
+    token_hash TEXT,
+    verified_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    last_seen_at TIMESTAMP WITH TIME ZONE,
+    ip_address TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_client_portal_sessions_tenant_id ON client_portal_sessions(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_client_portal_sessions_client_id ON client_portal_sessions(client_id);
+CREATE INDEX IF NOT EXISTS idx_client_portal_sessions_challenge_hash ON client_portal_sessions(challenge_hash);
+CREATE INDEX IF NOT EXISTS idx_client_portal_sessions_token_hash ON client_portal_sessions(token_hash);
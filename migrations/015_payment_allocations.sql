@@ -0,0 +1,33 @@
+-- Payment allocations: one payment split across several invoices
+CREATE TABLE IF NOT EXISTS payment_allocations (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    payment_id UUID NOT NULL,
+    invoice_id UUID NOT NULL,
+    client_id UUID,
+    amount BIGINT NOT NULL,
+    created_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_tenant_id ON payment_allocations(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_payment_id ON payment_allocations(payment_id);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_invoice_id ON payment_allocations(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_client_id ON payment_allocations(client_id);
+
+-- Client credit from overpayments
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS credit_balance BIGINT DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS client_credit_transactions (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    client_id UUID NOT NULL,
+    payment_id TEXT,
+    type VARCHAR(20),
+    amount BIGINT NOT NULL,
+    balance_after BIGINT,
+    created_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_client_credit_transactions_tenant_id ON client_credit_transactions(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_client_credit_transactions_client_id ON client_credit_transactions(client_id);
+CREATE INDEX IF NOT EXISTS idx_client_credit_transactions_payment_id ON client_credit_transactions(payment_id);
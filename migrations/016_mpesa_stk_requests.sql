@@ -0,0 +1,18 @@
+-- STK pushes sent to Daraja, so callbacks resolve to exactly one tenant
+CREATE TABLE IF NOT EXISTS mpesa_stk_requests (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    invoice_id UUID,
+    short_code TEXT,
+    credential_source VARCHAR(20),
+    environment VARCHAR(20),
+    merchant_request_id TEXT,
+    checkout_request_id TEXT NOT NULL,
+    account_reference TEXT,
+    amount TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_mpesa_stk_requests_tenant_id ON mpesa_stk_requests(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_mpesa_stk_requests_invoice_id ON mpesa_stk_requests(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_mpesa_stk_requests_merchant_request_id ON mpesa_stk_requests(merchant_request_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mpesa_stk_requests_checkout_request_id ON mpesa_stk_requests(checkout_request_id);
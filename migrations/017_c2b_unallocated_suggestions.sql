@@ -0,0 +1,6 @@
+-- Paybill (C2B) payment details and suggested matches on the unallocated queue
+ALTER TABLE unallocated_payments ADD COLUMN IF NOT EXISTS account_reference TEXT;
+ALTER TABLE unallocated_payments ADD COLUMN IF NOT EXISTS payer_name TEXT;
+ALTER TABLE unallocated_payments ADD COLUMN IF NOT EXISTS suggested_invoice_id TEXT;
+ALTER TABLE unallocated_payments ADD COLUMN IF NOT EXISTS suggestion_reason VARCHAR(30);
+ALTER TABLE unallocated_payments ADD COLUMN IF NOT EXISTS suggestion_score INTEGER DEFAULT 0;
@@ -0,0 +1,12 @@
+-- Resolve STK pushes whose callback never arrived via STK Push Query / Transaction Status
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS resolved_by VARCHAR(30);
+
+ALTER TABLE mpesa_stk_requests ADD COLUMN IF NOT EXISTS query_attempts INTEGER DEFAULT 0;
+ALTER TABLE mpesa_stk_requests ADD COLUMN IF NOT EXISTS last_queried_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE mpesa_stk_requests ADD COLUMN IF NOT EXISTS status_conversation_id TEXT;
+ALTER TABLE mpesa_stk_requests ADD COLUMN IF NOT EXISTS result_code TEXT;
+ALTER TABLE mpesa_stk_requests ADD COLUMN IF NOT EXISTS result_desc TEXT;
+ALTER TABLE mpesa_stk_requests ADD COLUMN IF NOT EXISTS resolved_by VARCHAR(30);
+ALTER TABLE mpesa_stk_requests ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_mpesa_stk_requests_status_conversation_id ON mpesa_stk_requests(status_conversation_id);
+CREATE INDEX IF NOT EXISTS idx_payments_pending_mpesa ON payments(method, status, created_at) WHERE status = 'pending';
@@ -0,0 +1,51 @@
+-- M-Pesa B2C payouts: refunds, overpayment returns and expense reimbursements
+CREATE TABLE IF NOT EXISTS payouts (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    status VARCHAR(20) DEFAULT 'pending_approval',
+    amount BIGINT NOT NULL,
+    currency VARCHAR(3) DEFAULT 'KES',
+    phone_number TEXT,
+    recipient_name TEXT,
+    reason TEXT,
+    payment_id TEXT,
+    client_id TEXT,
+    expense_id TEXT,
+    requested_by TEXT,
+    approved_by TEXT,
+    approved_at TIMESTAMP WITH TIME ZONE,
+    rejected_by TEXT,
+    rejected_reason TEXT,
+    short_code TEXT,
+    conversation_id TEXT,
+    receipt_number TEXT,
+    result_code TEXT,
+    result_desc TEXT,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_payouts_tenant_id ON payouts(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_payouts_status ON payouts(status);
+CREATE INDEX IF NOT EXISTS idx_payouts_payment_id ON payouts(payment_id);
+CREATE INDEX IF NOT EXISTS idx_payouts_client_id ON payouts(client_id);
+CREATE INDEX IF NOT EXISTS idx_payouts_expense_id ON payouts(expense_id);
+CREATE INDEX IF NOT EXISTS idx_payouts_conversation_id ON payouts(conversation_id);
+CREATE INDEX IF NOT EXISTS idx_payouts_receipt_number ON payouts(receipt_number);
+
+CREATE TABLE IF NOT EXISTS payout_events (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    payout_id UUID NOT NULL,
+    status VARCHAR(20),
+    detail TEXT,
+    receipt_number TEXT,
+    created_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_payout_events_tenant_id ON payout_events(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_payout_events_payout_id ON payout_events(payout_id);
+
+ALTER TABLE client_credit_transactions ADD COLUMN IF NOT EXISTS payout_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_client_credit_transactions_payout_id ON client_credit_transactions(payout_id);
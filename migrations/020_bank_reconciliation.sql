@@ -0,0 +1,54 @@
+-- Bank statement import (CSV, OFX, MT940) and reconciliation against payments and expenses
+CREATE TABLE IF NOT EXISTS bank_statements (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    uploaded_by UUID,
+    file_name TEXT,
+    format VARCHAR(10),
+    bank_name TEXT,
+    account_number TEXT,
+    currency VARCHAR(3) DEFAULT 'KES',
+    period_start TIMESTAMP WITH TIME ZONE,
+    period_end TIMESTAMP WITH TIME ZONE,
+    opening_balance BIGINT,
+    closing_balance BIGINT,
+    line_count INTEGER DEFAULT 0,
+    duplicate_count INTEGER DEFAULT 0,
+    matched_count INTEGER DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_bank_statements_tenant_id ON bank_statements(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_bank_statements_account_number ON bank_statements(account_number);
+
+CREATE TABLE IF NOT EXISTS bank_statement_lines (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    statement_id UUID NOT NULL REFERENCES bank_statements(id) ON DELETE CASCADE,
+    line_number INTEGER,
+    account_number TEXT,
+    date TIMESTAMP WITH TIME ZONE,
+    value_date TIMESTAMP WITH TIME ZONE,
+    amount BIGINT NOT NULL,
+    description TEXT,
+    reference TEXT,
+    bank_reference TEXT,
+    balance BIGINT,
+    fingerprint VARCHAR(32),
+    status VARCHAR(20) DEFAULT 'unmatched',
+    match_type VARCHAR(30),
+    match_id TEXT,
+    match_reason TEXT,
+    candidates TEXT,
+    matched_by TEXT,
+    matched_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_tenant_id ON bank_statement_lines(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_statement_id ON bank_statement_lines(statement_id);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_account_number ON bank_statement_lines(account_number);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_date ON bank_statement_lines(date);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_fingerprint ON bank_statement_lines(fingerprint);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_status ON bank_statement_lines(status);
+CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_match_id ON bank_statement_lines(match_id);
@@ -0,0 +1,50 @@
+-- Double-entry general ledger: chart of accounts and immutable journal entries
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    code VARCHAR(20) NOT NULL,
+    name TEXT NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    system_key VARCHAR(40),
+    description TEXT,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_accounts_tenant_code ON ledger_accounts(tenant_id, code);
+CREATE INDEX IF NOT EXISTS idx_ledger_accounts_system_key ON ledger_accounts(system_key);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    number VARCHAR(20),
+    date TIMESTAMP WITH TIME ZONE,
+    memo TEXT,
+    source_type VARCHAR(30) NOT NULL,
+    source_id UUID NOT NULL,
+    reverses_id UUID,
+    posted_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+-- One entry per document and event: posting twice fails instead of doubling
+CREATE UNIQUE INDEX IF NOT EXISTS idx_journal_entries_source ON journal_entries(tenant_id, source_type, source_id);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_number ON journal_entries(number);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_date ON journal_entries(date);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_reverses_id ON journal_entries(reverses_id);
+
+CREATE TABLE IF NOT EXISTS journal_lines (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    entry_id UUID NOT NULL REFERENCES journal_entries(id),
+    account_id UUID NOT NULL REFERENCES ledger_accounts(id),
+    debit BIGINT DEFAULT 0,
+    credit BIGINT DEFAULT 0,
+    description TEXT,
+    currency VARCHAR(3),
+    fx_amount BIGINT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT chk_journal_lines_one_side CHECK ((debit > 0 AND credit = 0) OR (credit > 0 AND debit = 0))
+);
+CREATE INDEX IF NOT EXISTS idx_journal_lines_tenant_id ON journal_lines(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_journal_lines_entry_id ON journal_lines(entry_id);
+CREATE INDEX IF NOT EXISTS idx_journal_lines_account_id ON journal_lines(account_id);
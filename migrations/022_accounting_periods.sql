@@ -0,0 +1,28 @@
+-- Fiscal year settings and month-end close
+CREATE TABLE IF NOT EXISTS fiscal_year_configs (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    start_month INTEGER DEFAULT 1 CHECK (start_month BETWEEN 1 AND 12),
+    updated_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fiscal_year_configs_tenant_id ON fiscal_year_configs(tenant_id);
+
+-- Months without a row are open
+CREATE TABLE IF NOT EXISTS accounting_periods (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    period VARCHAR(7) NOT NULL,
+    status VARCHAR(10) DEFAULT 'open',
+    closed_by TEXT,
+    closed_at TIMESTAMP WITH TIME ZONE,
+    locked_by TEXT,
+    locked_at TIMESTAMP WITH TIME ZONE,
+    reopened_by TEXT,
+    reopened_at TIMESTAMP WITH TIME ZONE,
+    reopen_reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_accounting_periods_tenant_period ON accounting_periods(tenant_id, period);
@@ -0,0 +1,42 @@
+-- Dated exchange rates: published rows have no tenant, overrides belong to one
+ALTER TABLE exchange_rates ADD COLUMN IF NOT EXISTS tenant_id UUID;
+ALTER TABLE exchange_rates ADD COLUMN IF NOT EXISTS source VARCHAR(20) DEFAULT 'cbk';
+ALTER TABLE exchange_rates ADD COLUMN IF NOT EXISTS created_by TEXT;
+CREATE INDEX IF NOT EXISTS idx_exchange_rates_tenant_id ON exchange_rates(tenant_id);
+
+-- Rate a foreign-currency payment settled at and the gain or loss against the invoice rate
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS exchange_rate DECIMAL(15,8) DEFAULT 0;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS realized_fx_gain BIGINT DEFAULT 0;
+
+-- Month-end revaluation runs, one per tenant and month
+CREATE TABLE IF NOT EXISTS fx_revaluations (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    period VARCHAR(7) NOT NULL,
+    as_of TIMESTAMP WITH TIME ZONE,
+    gain BIGINT DEFAULT 0,
+    entry_id UUID,
+    reversal_id UUID,
+    run_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fx_revaluations_tenant_period ON fx_revaluations(tenant_id, period);
+
+CREATE TABLE IF NOT EXISTS fx_revaluation_lines (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    revaluation_id UUID NOT NULL REFERENCES fx_revaluations(id) ON DELETE CASCADE,
+    invoice_id UUID,
+    invoice_number TEXT,
+    currency VARCHAR(10),
+    balance BIGINT DEFAULT 0,
+    book_rate DECIMAL(15,8),
+    closing_rate DECIMAL(15,8),
+    book_value BIGINT DEFAULT 0,
+    closing_value BIGINT DEFAULT 0,
+    gain BIGINT DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_fx_revaluation_lines_tenant_id ON fx_revaluation_lines(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_fx_revaluation_lines_revaluation_id ON fx_revaluation_lines(revaluation_id);
+CREATE INDEX IF NOT EXISTS idx_fx_revaluation_lines_invoice_id ON fx_revaluation_lines(invoice_id);
@@ -0,0 +1,31 @@
+-- Tax withheld by clients settles the invoice along with the cash paid
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS withheld BIGINT DEFAULT 0;
+
+-- Withholding VAT and income tax deductions and the certificates issued for them
+CREATE TABLE IF NOT EXISTS withholding_certificates (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    client_id UUID,
+    invoice_id UUID,
+    payment_id UUID,
+    type VARCHAR(20) NOT NULL,
+    certificate_number TEXT,
+    certificate_date TIMESTAMP WITH TIME ZONE,
+    rate DECIMAL(5,2) DEFAULT 0,
+    amount BIGINT DEFAULT 0,
+    expected BIGINT DEFAULT 0,
+    currency VARCHAR(10) DEFAULT 'KES',
+    deducted_at TIMESTAMP WITH TIME ZONE,
+    status VARCHAR(20) DEFAULT 'pending',
+    received_at TIMESTAMP WITH TIME ZONE,
+    received_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_withholding_certificates_tenant_id ON withholding_certificates(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_withholding_certificates_client_id ON withholding_certificates(client_id);
+CREATE INDEX IF NOT EXISTS idx_withholding_certificates_invoice_id ON withholding_certificates(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_withholding_certificates_payment_id ON withholding_certificates(payment_id);
+CREATE INDEX IF NOT EXISTS idx_withholding_certificates_certificate_number ON withholding_certificates(certificate_number);
+CREATE INDEX IF NOT EXISTS idx_withholding_certificates_deducted_at ON withholding_certificates(deducted_at);
+CREATE INDEX IF NOT EXISTS idx_withholding_certificates_status ON withholding_certificates(status);
@@ -0,0 +1,29 @@
+-- Per-tenant tax rate catalogue: VAT, excise and levies with the dates they apply
+CREATE TABLE IF NOT EXISTS tax_rates (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    country VARCHAR(2) DEFAULT 'KE',
+    code TEXT NOT NULL,
+    name TEXT NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'vat',
+    tax_type VARCHAR(20) NOT NULL DEFAULT 'standard',
+    rate DECIMAL(5,2) DEFAULT 0,
+    kra_category VARCHAR(2),
+    valid_from TIMESTAMP WITH TIME ZONE NOT NULL,
+    valid_to TIMESTAMP WITH TIME ZONE,
+    is_system BOOLEAN DEFAULT FALSE,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tax_rates_code_from ON tax_rates(tenant_id, country, code, valid_from);
+
+-- The rate an invoice was taxed at is now taken from the catalogue, not defaulted
+ALTER TABLE invoices ALTER COLUMN tax_rate DROP DEFAULT;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax_code TEXT;
+
+-- Line items carry their catalogue codes, eTIMS tax category and excise
+ALTER TABLE invoice_items ADD COLUMN IF NOT EXISTS tax_code TEXT;
+ALTER TABLE invoice_items ADD COLUMN IF NOT EXISTS tax_category VARCHAR(2);
+ALTER TABLE invoice_items ADD COLUMN IF NOT EXISTS excise_code TEXT;
+ALTER TABLE invoice_items ADD COLUMN IF NOT EXISTS excise_duty BIGINT DEFAULT 0;
@@ -0,0 +1,88 @@
+-- eTIMS control unit per tenant, with the communication key issued at initialization
+CREATE TABLE IF NOT EXISTS etims_devices (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL UNIQUE,
+    mode VARCHAR(10) NOT NULL DEFAULT 'oscu',
+    tin TEXT NOT NULL,
+    branch_id TEXT NOT NULL,
+    device_serial TEXT NOT NULL,
+    sdc_id TEXT,
+    mrc_no TEXT,
+    communication_key TEXT,
+    initialized_at TIMESTAMP WITH TIME ZONE,
+    codes_synced_at TIMESTAMP WITH TIME ZONE,
+    purchases_synced_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Code lists synced from eTIMS: units, tax types, countries, item classifications
+CREATE TABLE IF NOT EXISTS etims_codes (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    class TEXT NOT NULL,
+    code TEXT NOT NULL,
+    name TEXT,
+    tax_category VARCHAR(2),
+    is_active BOOLEAN DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_etims_codes_class_code ON etims_codes(tenant_id, class, code);
+
+-- Invoices suppliers issued to the tenant's PIN, imported for confirmation
+CREATE TABLE IF NOT EXISTS etims_purchases (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    supplier_pin TEXT NOT NULL,
+    supplier_name TEXT,
+    supplier_branch TEXT,
+    invoice_number TEXT NOT NULL,
+    icn TEXT,
+    invoice_date TIMESTAMP WITH TIME ZONE,
+    taxable_amount BIGINT DEFAULT 0,
+    tax_amount BIGINT DEFAULT 0,
+    total_amount BIGINT DEFAULT 0,
+    items_json TEXT,
+    status VARCHAR(20) DEFAULT 'pending',
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    confirmed_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_etims_purchases_supplier_invoice ON etims_purchases(tenant_id, supplier_pin, invoice_number);
+CREATE INDEX IF NOT EXISTS idx_etims_purchases_status ON etims_purchases(status);
+
+-- Stock moving in and out of registered items, reported with the quantity left
+CREATE TABLE IF NOT EXISTS etims_stock_movements (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    item_id UUID NOT NULL,
+    item_code TEXT NOT NULL,
+    sar_no BIGINT,
+    type VARCHAR(2) NOT NULL,
+    quantity DECIMAL(15,3) NOT NULL,
+    unit_price BIGINT DEFAULT 0,
+    remaining DECIMAL(15,3) DEFAULT 0,
+    reference TEXT,
+    occurred_at TIMESTAMP WITH TIME ZONE,
+    status VARCHAR(20) DEFAULT 'pending',
+    error TEXT,
+    reported_at TIMESTAMP WITH TIME ZONE,
+    created_by TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_etims_stock_movements_tenant_id ON etims_stock_movements(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_etims_stock_movements_item_id ON etims_stock_movements(item_id);
+CREATE INDEX IF NOT EXISTS idx_etims_stock_movements_status ON etims_stock_movements(status);
+
+-- Library items registered with eTIMS carry their KRA item code and classification
+ALTER TABLE IF EXISTS item_libraries ADD COLUMN IF NOT EXISTS item_code TEXT;
+ALTER TABLE IF EXISTS item_libraries ADD COLUMN IF NOT EXISTS item_class_code TEXT;
+ALTER TABLE IF EXISTS item_libraries ADD COLUMN IF NOT EXISTS item_type VARCHAR(1);
+ALTER TABLE IF EXISTS item_libraries ADD COLUMN IF NOT EXISTS origin_country VARCHAR(2);
+ALTER TABLE IF EXISTS item_libraries ADD COLUMN IF NOT EXISTS packaging_unit TEXT;
+ALTER TABLE IF EXISTS item_libraries ADD COLUMN IF NOT EXISTS quantity_unit TEXT;
+ALTER TABLE IF EXISTS item_libraries ADD COLUMN IF NOT EXISTS tax_category VARCHAR(2);
+ALTER TABLE IF EXISTS item_libraries ADD COLUMN IF NOT EXISTS etims_registered_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_item_libraries_item_code ON item_libraries(item_code);
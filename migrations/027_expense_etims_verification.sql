@@ -0,0 +1,7 @@
+-- Expenses capture the supplier's eTIMS invoice so input VAT is only claimed on verified purchases
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS supplier_pin TEXT;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS supplier_icn TEXT;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS etims_status VARCHAR(20) DEFAULT 'unverified';
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS etims_note TEXT;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS etims_verified_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_expenses_etims_status ON expenses(etims_status);
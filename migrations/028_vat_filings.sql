@@ -0,0 +1,16 @@
+-- VAT3 return bundles exported for upload to iTax, one version per export of a month
+CREATE TABLE IF NOT EXISTS vat_filings (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    period VARCHAR(7) NOT NULL,
+    version INTEGER NOT NULL,
+    output_tax BIGINT DEFAULT 0,
+    input_tax BIGINT DEFAULT 0,
+    net_vat BIGINT DEFAULT 0,
+    sales_lines INTEGER DEFAULT 0,
+    purchase_lines INTEGER DEFAULT 0,
+    checksum TEXT,
+    exported_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_vat_filings_period_version ON vat_filings(tenant_id, period, version);
@@ -0,0 +1,5 @@
+-- Invoices track how much of them credit notes have reversed; a credit note
+-- reversing more than was left to pay credits the client
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS credited_amount BIGINT DEFAULT 0;
+ALTER TABLE client_credit_transactions ADD COLUMN IF NOT EXISTS credit_note_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_client_credit_transactions_credit_note_id ON client_credit_transactions(credit_note_id);
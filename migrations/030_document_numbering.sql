@@ -0,0 +1,13 @@
+-- Invoice numbers are unique per tenant, not across every tenant
+DROP INDEX IF EXISTS idx_invoices_invoice_number;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_tenant_number ON invoices(tenant_id, invoice_number);
+
+-- Tenants choose how each document type is numbered, optionally starting
+-- again every fiscal year
+ALTER TABLE invoice_sequences ADD COLUMN IF NOT EXISTS pattern VARCHAR(64) DEFAULT '';
+ALTER TABLE invoice_sequences ADD COLUMN IF NOT EXISTS reset_yearly BOOLEAN DEFAULT FALSE;
+ALTER TABLE invoice_sequences ADD COLUMN IF NOT EXISTS fiscal_year INTEGER DEFAULT 0;
+
+-- Payments get a receipt number from the tenant's receipt sequence
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS receipt_number VARCHAR(64);
+CREATE INDEX IF NOT EXISTS idx_payments_receipt_number ON payments(receipt_number);
@@ -0,0 +1,5 @@
+-- Paybill confirmations are checked against Daraja's Transaction Status before
+-- they are applied to an invoice
+ALTER TABLE unallocated_payments ADD COLUMN IF NOT EXISTS verification TEXT;
+ALTER TABLE unallocated_payments ADD COLUMN IF NOT EXISTS status_conversation_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_unallocated_payments_status_conversation_id ON unallocated_payments(status_conversation_id);
@@ -0,0 +1,5 @@
+-- Payouts whose B2C request or result went unanswered are resolved through
+-- Transaction Status instead of being failed and paid again
+ALTER TABLE payouts ADD COLUMN IF NOT EXISTS status_conversation_id TEXT;
+ALTER TABLE payouts ADD COLUMN IF NOT EXISTS status_queried_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_payouts_status_conversation_id ON payouts(status_conversation_id);
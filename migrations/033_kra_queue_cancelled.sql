@@ -0,0 +1,5 @@
+-- Queue items of invoices cancelled before KRA registered them are voided
+-- rather than replayed; items being replayed are marked processing
+ALTER TABLE kra_queue_items DROP CONSTRAINT IF EXISTS kra_queue_items_status_check;
+ALTER TABLE kra_queue_items ADD CONSTRAINT kra_queue_items_status_check
+    CHECK (status IN ('pending', 'processing', 'failed', 'completed', 'cancelled'));
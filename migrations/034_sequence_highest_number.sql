@@ -0,0 +1,17 @@
+-- Sequences remember the highest number they have issued in any fiscal year,
+-- so turning off the yearly reset carries on past every number already used
+ALTER TABLE invoice_sequences ADD COLUMN IF NOT EXISTS highest_sequence_num BIGINT DEFAULT 0;
+
+UPDATE invoice_sequences s SET highest_sequence_num = GREATEST(s.last_sequence_num, COALESCE((
+    SELECT MAX(i.sequence_number) FROM invoices i
+    WHERE i.tenant_id = s.tenant_id AND i.invoice_type = s.document_type
+), 0))
+WHERE s.document_type IN ('invoice', 'credit_note', 'debit_note');
+
+UPDATE invoice_sequences s SET highest_sequence_num = GREATEST(s.last_sequence_num, COALESCE((
+    SELECT MAX(q.sequence_number) FROM quotes q WHERE q.tenant_id = s.tenant_id
+), 0))
+WHERE s.document_type = 'quote';
+
+UPDATE invoice_sequences SET highest_sequence_num = last_sequence_num
+WHERE document_type NOT IN ('invoice', 'credit_note', 'debit_note', 'quote');
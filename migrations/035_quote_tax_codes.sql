@@ -0,0 +1,8 @@
+-- Quotes carry their catalogue codes so a converted quote is taxed at the
+-- rates in force on the day it becomes an invoice
+ALTER TABLE quotes ADD COLUMN IF NOT EXISTS tax_code TEXT;
+ALTER TABLE quotes ADD COLUMN IF NOT EXISTS excise_duty BIGINT DEFAULT 0;
+
+ALTER TABLE quote_items ADD COLUMN IF NOT EXISTS tax_code TEXT;
+ALTER TABLE quote_items ADD COLUMN IF NOT EXISTS excise_code TEXT;
+ALTER TABLE quote_items ADD COLUMN IF NOT EXISTS excise_duty BIGINT DEFAULT 0;
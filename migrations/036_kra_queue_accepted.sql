@@ -0,0 +1,7 @@
+-- Submissions KRA accepted keep their ICN on the queue item until it is
+-- recorded on the invoice, so they are never replayed
+ALTER TABLE kra_queue_items ADD COLUMN IF NOT EXISTS icn TEXT;
+ALTER TABLE kra_queue_items ADD COLUMN IF NOT EXISTS qr_code TEXT;
+ALTER TABLE kra_queue_items DROP CONSTRAINT IF EXISTS kra_queue_items_status_check;
+ALTER TABLE kra_queue_items ADD CONSTRAINT kra_queue_items_status_check
+    CHECK (status IN ('pending', 'processing', 'accepted', 'failed', 'completed', 'cancelled'));